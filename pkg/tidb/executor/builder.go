@@ -1594,7 +1594,11 @@ func (b *executorBuilder) buildMemTable(v *plannercore.PhysicalMemTable) Executo
 			strings.ToLower(infoschema.TableClientErrorsSummaryByHost),
 			strings.ToLower(infoschema.TableDeadlocks),
 			strings.ToLower(infoschema.ClusterTableDeadlocks),
-			strings.ToLower(infoschema.TableDataLockWaits):
+			strings.ToLower(infoschema.TableDataLockWaits),
+			strings.ToLower(infoschema.TableServerlessBackends),
+			strings.ToLower(infoschema.TableServerlessScaleHistory),
+			strings.ToLower(infoschema.TableServerlessRoutingRules),
+			strings.ToLower(infoschema.TableServerlessTopQueries):
 			return &MemTableReaderExec{
 				baseExecutor: newBaseExecutor(b.ctx, v.Schema(), v.ID()),
 				table:        v.Table,
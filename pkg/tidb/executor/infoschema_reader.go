@@ -163,6 +163,11 @@ func (e *memtableRetriever) retrieve(ctx context.Context, sctx sessionctx.Contex
 			err = e.setDataForClusterDeadlock(sctx)
 		case infoschema.TableDataLockWaits:
 			err = e.setDataForTableDataLockWaits(sctx)
+		case infoschema.TableServerlessBackends,
+			infoschema.TableServerlessScaleHistory,
+			infoschema.TableServerlessRoutingRules,
+			infoschema.TableServerlessTopQueries:
+			err = e.setDataForServerless(sctx, e.table.Name.O)
 		}
 		if err != nil {
 			return nil, err
@@ -1973,6 +1978,30 @@ func (e *memtableRetriever) setDataForPlacementPolicy(ctx sessionctx.Context) er
 	return nil
 }
 
+// ProxyStateProvider is implemented by the session manager of the serverless proxy, it
+// returns the rows of the SERVERLESS_* tables.
+type ProxyStateProvider interface {
+	ProxyStateRows(tableName string) ([][]types.Datum, error)
+}
+
+// setDataForServerless reads the proxy state, the tables are empty when the server is
+// not a proxy.
+func (e *memtableRetriever) setDataForServerless(ctx sessionctx.Context, tableName string) error {
+	if !hasPriv(ctx, mysql.ProcessPriv) {
+		return plannercore.ErrSpecificAccessDenied.GenWithStackByArgs("PROCESS")
+	}
+	provider, ok := ctx.GetSessionManager().(ProxyStateProvider)
+	if !ok {
+		return nil
+	}
+	rows, err := provider.ProxyStateRows(tableName)
+	if err != nil {
+		return err
+	}
+	e.rows = rows
+	return nil
+}
+
 func (e *memtableRetriever) setDataForClientErrorsSummary(ctx sessionctx.Context, tableName string) error {
 	// Seeing client errors should require the PROCESS privilege, with the exception of errors for your own user.
 	// This is similar to information_schema.processlist, which is the closest comparison.
@@ -172,6 +172,14 @@ const (
 	TableDeadlocks = "DEADLOCKS"
 	// TableDataLockWaits is current lock waiting status table.
 	TableDataLockWaits = "DATA_LOCK_WAITS"
+	// TableServerlessBackends is the backends of the serverless proxy pools.
+	TableServerlessBackends = "SERVERLESS_BACKENDS"
+	// TableServerlessScaleHistory is the recent scale calls of the serverless proxy.
+	TableServerlessScaleHistory = "SERVERLESS_SCALE_HISTORY"
+	// TableServerlessRoutingRules is the rewrite rules and plan pins of the serverless proxy.
+	TableServerlessRoutingRules = "SERVERLESS_ROUTING_RULES"
+	// TableServerlessTopQueries is the most frequent statement digests per pool of the serverless proxy.
+	TableServerlessTopQueries = "SERVERLESS_TOP_QUERIES"
 )
 
 var tableIDMap = map[string]int64{
@@ -251,6 +259,10 @@ var tableIDMap = map[string]int64{
 	TableDataLockWaits:                      autoid.InformationSchemaDBID + 74,
 	TableStatementsSummaryEvicted:           autoid.InformationSchemaDBID + 75,
 	ClusterTableStatementsSummaryEvicted:    autoid.InformationSchemaDBID + 76,
+	TableServerlessBackends:                 autoid.InformationSchemaDBID + 77,
+	TableServerlessScaleHistory:             autoid.InformationSchemaDBID + 78,
+	TableServerlessRoutingRules:             autoid.InformationSchemaDBID + 79,
+	TableServerlessTopQueries:               autoid.InformationSchemaDBID + 80,
 }
 
 type columnInfo struct {
@@ -1392,6 +1404,51 @@ var tableDataLockWaitsCols = []columnInfo{
 	{name: "SQL_DIGEST", tp: mysql.TypeVarchar, size: 64, comment: "Digest of the SQL that's trying to acquire the lock"},
 }
 
+var tableServerlessBackendsCols = []columnInfo{
+	{name: "POOL", tp: mysql.TypeVarchar, size: 16, flag: mysql.NotNullFlag},
+	{name: "ADDRESS", tp: mysql.TypeVarchar, size: 64, flag: mysql.NotNullFlag},
+	{name: "STATE", tp: mysql.TypeVarchar, size: 16},
+	{name: "VERSION", tp: mysql.TypeVarchar, size: 64},
+	{name: "WEIGHT", tp: mysql.TypeDouble, size: 22},
+	{name: "USING_CONNS", tp: mysql.TypeLonglong, size: 21},
+	{name: "CORDONED", tp: mysql.TypeTiny, size: 1},
+	{name: "ZONE", tp: mysql.TypeVarchar, size: 64},
+}
+
+var tableServerlessScaleHistoryCols = []columnInfo{
+	{name: "TIME", tp: mysql.TypeTimestamp, size: 26},
+	{name: "CALLER", tp: mysql.TypeVarchar, size: 64},
+	{name: "METHOD", tp: mysql.TypeVarchar, size: 64},
+	{name: "REQUEST", tp: mysql.TypeVarchar, size: 1024},
+	{name: "RESPONSE", tp: mysql.TypeVarchar, size: 1024},
+	{name: "LATENCY_MS", tp: mysql.TypeDouble, size: 22},
+	{name: "ERROR", tp: mysql.TypeVarchar, size: 1024},
+	{name: "LIMITED", tp: mysql.TypeTiny, size: 1},
+}
+
+var tableServerlessRoutingRulesCols = []columnInfo{
+	{name: "RULE_TYPE", tp: mysql.TypeVarchar, size: 16, flag: mysql.NotNullFlag, comment: "rewrite or pin"},
+	{name: "ID", tp: mysql.TypeLonglong, size: 21, flag: mysql.NotNullFlag},
+	{name: "DIGEST", tp: mysql.TypeVarchar, size: 64},
+	{name: "USER", tp: mysql.TypeVarchar, size: 64},
+	{name: "TARGET", tp: mysql.TypeVarchar, size: 16, comment: "Routing class of a rewrite rule or pool of a pin"},
+	{name: "MATCH_TEXT", tp: mysql.TypeVarchar, size: 1024},
+	{name: "ACTION", tp: mysql.TypeVarchar, size: 1024, comment: "Replacement of a rewrite rule or hint of a pin"},
+	{name: "HITS", tp: mysql.TypeLonglong, size: 21},
+}
+
+var tableServerlessTopQueriesCols = []columnInfo{
+	{name: "POOL", tp: mysql.TypeVarchar, size: 16, flag: mysql.NotNullFlag},
+	{name: "DIGEST", tp: mysql.TypeVarchar, size: 64, flag: mysql.NotNullFlag},
+	{name: "DIGEST_TEXT", tp: mysql.TypeVarchar, size: 1024},
+	{name: "EXEC_COUNT", tp: mysql.TypeLonglong, size: 21, flag: mysql.NotNullFlag},
+	{name: "EXEC_COUNT_ERROR", tp: mysql.TypeLonglong, size: 21, comment: "Max overcount of EXEC_COUNT"},
+	{name: "AVG_LATENCY", tp: mysql.TypeLonglong, size: 21, comment: "Microseconds"},
+	{name: "SUM_LATENCY", tp: mysql.TypeLonglong, size: 21, comment: "Microseconds"},
+	{name: "SUM_COST", tp: mysql.TypeLonglong, size: 21},
+	{name: "LAST_SEEN", tp: mysql.TypeTimestamp, size: 26},
+}
+
 var tableStatementsSummaryEvictedCols = []columnInfo{
 	{name: "BEGIN_TIME", tp: mysql.TypeTimestamp, size: 26},
 	{name: "END_TIME", tp: mysql.TypeTimestamp, size: 26},
@@ -1780,6 +1837,10 @@ var tableNameToColumns = map[string][]columnInfo{
 	TableTiDBTrx:                            tableTiDBTrxCols,
 	TableDeadlocks:                          tableDeadlocksCols,
 	TableDataLockWaits:                      tableDataLockWaitsCols,
+	TableServerlessBackends:                 tableServerlessBackendsCols,
+	TableServerlessScaleHistory:             tableServerlessScaleHistoryCols,
+	TableServerlessRoutingRules:             tableServerlessRoutingRulesCols,
+	TableServerlessTopQueries:               tableServerlessTopQueriesCols,
 }
 
 func createInfoSchemaTable(_ autoid.Allocators, meta *model.TableInfo) (table.Table, error) {
@@ -50,6 +50,7 @@ import (
 	"github.com/pingcap/tidb/store/mockstore"
 	"github.com/pingcap/tidb/store/mockstore/mockstorage"
 	"github.com/pingcap/tidb/store/mockstore/unistore"
+	"github.com/pingcap/tidb/types"
 	"github.com/pingcap/tidb/util"
 	"github.com/pingcap/tidb/util/kvcache"
 	"github.com/pingcap/tidb/util/pdapi"
@@ -472,6 +473,35 @@ func (sm *mockSessionManager) ServerID() uint64 {
 	return 1
 }
 
+type mockProxySessionManager struct {
+	*mockSessionManager
+}
+
+func (sm *mockProxySessionManager) ProxyStateRows(tableName string) ([][]types.Datum, error) {
+	if tableName == infoschema.TableServerlessBackends {
+		return [][]types.Datum{types.MakeDatums("tp", "10.0.0.1:4000", "up", "v5.0.0", 2.0, int64(3), false, "zone-a")}, nil
+	}
+	return nil, nil
+}
+
+func (s *testTableSuite) TestServerlessTables(c *C) {
+	tk := s.newTestKitWithRoot(c)
+	tk.MustQuery("select * from information_schema.SERVERLESS_BACKENDS").Check(testkit.Rows())
+
+	tk.Se.SetSessionManager(&mockProxySessionManager{&mockSessionManager{processInfoMap: make(map[uint64]*util.ProcessInfo)}})
+	tk.MustQuery("select pool, address, weight, using_conns, cordoned, zone from information_schema.SERVERLESS_BACKENDS").
+		Check(testkit.Rows("tp 10.0.0.1:4000 2 3 0 zone-a"))
+	tk.MustQuery("select * from information_schema.SERVERLESS_SCALE_HISTORY").Check(testkit.Rows())
+	tk.MustQuery("select * from information_schema.SERVERLESS_ROUTING_RULES").Check(testkit.Rows())
+	tk.MustQuery("select * from information_schema.SERVERLESS_TOP_QUERIES").Check(testkit.Rows())
+
+	tk.MustExec("create user 'serverless_reader'@'localhost'")
+	c.Assert(tk.Se.Auth(&auth.UserIdentity{Username: "serverless_reader", Hostname: "localhost"}, nil, nil), IsTrue)
+	err := tk.QueryToErr("select * from information_schema.SERVERLESS_BACKENDS")
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, "[planner:1227]Access denied; you need (at least one of) the PROCESS privilege(s) for this operation")
+}
+
 func (s *testTableSuite) TestSomeTables(c *C) {
 	se, err := session.CreateSession4Test(s.store)
 	c.Assert(err, IsNil)
@@ -157,6 +157,70 @@ func RegisterMetrics() {
 	prometheus.MustRegister(TopSQLReportDurationHistogram)
 	prometheus.MustRegister(TopSQLReportDataHistogram)
 	prometheus.MustRegister(QueriesCounter)
+	prometheus.MustRegister(ProxySchemaLagGauge)
+	prometheus.MustRegister(ProxyConnReapedCounter)
+	prometheus.MustRegister(ProxyBackendThrottledCounter)
+	prometheus.MustRegister(ProxyLoadDataBytesCounter)
+	prometheus.MustRegister(ProxyLoadDataInProgressGauge)
+	prometheus.MustRegister(ProxyScaleInStepCounter)
+	prometheus.MustRegister(ProxyInflightCostGauge)
+	prometheus.MustRegister(ProxyPoolQueuedGauge)
+	prometheus.MustRegister(ProxyPoolQueueWaitGauge)
+	prometheus.MustRegister(ProxyPoolUtilizationGauge)
+	prometheus.MustRegister(ProxyCompressionBytesCounter)
+	prometheus.MustRegister(ProxyBackendIPChangeCounter)
+	prometheus.MustRegister(ProxyScaleCallCounter)
+	prometheus.MustRegister(ProxyResultLimitCounter)
+	prometheus.MustRegister(ProxySessionPinGauge)
+	prometheus.MustRegister(ProxyPoolCordonedGauge)
+	prometheus.MustRegister(ProxySessionSuspendCounter)
+	prometheus.MustRegister(ProxyConnLimitRejectedCounter)
+	prometheus.MustRegister(ProxyHandshakeTimeoutCounter)
+	prometheus.MustRegister(ProxyAuthBlockedCounter)
+	prometheus.MustRegister(ProxyMetadataCacheCounter)
+	prometheus.MustRegister(ProxyTokenQueueGauge)
+	prometheus.MustRegister(ProxyScaleWebhookCounter)
+	prometheus.MustRegister(ProxyPDLostGauge)
+	prometheus.MustRegister(ProxyPDLossConnCounter)
+	prometheus.MustRegister(ProxyScalerAvailableGauge)
+	prometheus.MustRegister(ProxyScalerFailoverCounter)
+	prometheus.MustRegister(ProxyCertAuthCounter)
+	prometheus.MustRegister(ProxyLocalProbeCounter)
+	prometheus.MustRegister(ProxyMaintenanceGauge)
+	prometheus.MustRegister(ProxyPreflightCounter)
+	prometheus.MustRegister(ProxyReadOnlyGauge)
+	prometheus.MustRegister(ProxyReadOnlyRejectedCounter)
+	prometheus.MustRegister(ProxyCostEstimateErrorHistogram)
+	prometheus.MustRegister(ProxyCostCalibratedDigestsGauge)
+	prometheus.MustRegister(ProxyStatsWarmupCounter)
+	prometheus.MustRegister(ProxyPoolDesiredCoresGauge)
+	prometheus.MustRegister(ProxyPoolActualCoresGauge)
+	prometheus.MustRegister(ProxyScaleInLockCheckCounter)
+	prometheus.MustRegister(ProxyOriginLatencyHistogram)
+	prometheus.MustRegister(ProxyConnectDBCounter)
+	prometheus.MustRegister(ProxyAppStmtCounter)
+	prometheus.MustRegister(ProxyAppLatencyHistogram)
+	prometheus.MustRegister(ProxyScaleInRejectedCounter)
+	prometheus.MustRegister(ProxyAuthPassthroughCounter)
+	prometheus.MustRegister(ProxyCachingSha2Counter)
+	prometheus.MustRegister(ProxyAutoscaleEvalCounter)
+	prometheus.MustRegister(ProxyAutoscaleEvalHistogram)
+	prometheus.MustRegister(ProxyShadowCounter)
+	prometheus.MustRegister(ProxyShadowLatencyHistogram)
+	prometheus.MustRegister(ProxyLeaderGauge)
+	prometheus.MustRegister(ProxyPeersGauge)
+	prometheus.MustRegister(ProxyLongTxnCounter)
+	prometheus.MustRegister(ProxyOldestTxnGauge)
+	prometheus.MustRegister(ProxyZoneRouteCounter)
+	prometheus.MustRegister(ProxyHandshakeFailureCounter)
+	prometheus.MustRegister(ProxyRouteCacheCounter)
+	prometheus.MustRegister(ProxyPodLookupCounter)
+	prometheus.MustRegister(ProxyLocalLoadGauge)
+	prometheus.MustRegister(ProxySelfSkipCounter)
+	prometheus.MustRegister(ProxyFreshConnCounter)
+	prometheus.MustRegister(ProxyBackendFaultCounter)
+	prometheus.MustRegister(ProxySchemaListingCounter)
+	prometheus.MustRegister(ProxyAcceptLimitedCounter)
 
 	tikvmetrics.InitMetrics(TiDB, TiKVClient)
 	tikvmetrics.RegisterMetrics()
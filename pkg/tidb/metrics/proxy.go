@@ -0,0 +1,554 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Label of where a statement is executed, by the proxy itself or forwarded to a backend.
+const LblOrigin = "origin"
+
+// Label of the application of the client, from program_name or the proxy config.
+const LblApp = "app"
+
+// Labels of protocol compression.
+const (
+	LblAlgorithm = "algorithm"
+	LblDirection = "direction"
+	LblStage     = "stage"
+)
+
+// Label of why a client handshake failed.
+const LblReason = "reason"
+
+// Metrics for the serverless proxy.
+var (
+	ProxySchemaLagGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "schema_lag",
+			Help:      "Schema versions a backend lags behind the proxy.",
+		}, []string{LblAddress})
+
+	ProxyConnReapedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "conn_reaped_total",
+			Help:      "Counter of client connections closed by the proxy for idle timeout or max lifetime.",
+		}, []string{LblType})
+
+	ProxyBackendThrottledCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "backend_throttled_total",
+			Help:      "Counter of statements queued or spilled because a backend reached its concurrency cap.",
+		}, []string{LblAddress})
+
+	ProxyLoadDataBytesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "load_data_bytes_total",
+			Help:      "Bytes of LOAD DATA LOCAL INFILE relayed from clients to backends.",
+		})
+
+	ProxyLoadDataInProgressGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "load_data_in_progress",
+			Help:      "Number of LOAD DATA statements being relayed to backends.",
+		})
+
+	ProxyScaleInStepCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "scale_in_step_total",
+			Help:      "Counter of stepwise scale-in steps by result.",
+		}, []string{LblResult})
+
+	ProxyInflightCostGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "inflight_cost",
+			Help:      "Estimated cost of running statements by statement class and origin.",
+		}, []string{LblType, LblOrigin})
+
+	ProxyPoolQueuedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "pool_queued",
+			Help:      "Number of statements waiting for a backend of the pool.",
+		}, []string{LblType})
+
+	ProxyPoolQueueWaitGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "pool_queue_wait_seconds",
+			Help:      "Longest wait for a backend of the pool in the last check interval.",
+		}, []string{LblType})
+
+	ProxyPoolUtilizationGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "pool_utilization",
+			Help:      "Cores needed by the cost of the pool over the cpu weights of its up backends.",
+		}, []string{LblType})
+
+	ProxyCompressionBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "compression_bytes",
+			Help:      "Bytes of compressed protocol frames before (raw) and after (wire) compression.",
+		}, []string{LblType, LblAlgorithm, LblDirection, LblStage})
+
+	ProxyBackendIPChangeCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "backend_ip_change_total",
+			Help:      "Counter of backend ip changes by how they are found, pod watch or lookup after connection errors.",
+		}, []string{LblType})
+
+	ProxyScaleCallCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "scale_call_total",
+			Help:      "Counter of scale rpcs by the policy asking for them and result, ok, failed or limited.",
+		}, []string{LblType, LblResult})
+
+	ProxyResultLimitCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "result_limit_total",
+			Help:      "Counter of result sets over the limit by routing class and action, error or truncate.",
+		}, []string{LblType, LblResult})
+
+	ProxySessionPinGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "session_pinned",
+			Help:      "Number of client sessions pinned to a backend by temporary tables.",
+		})
+
+	ProxyPoolCordonedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "pool_cordoned",
+			Help:      "Whether the pool is cordoned, no new statements are routed to it.",
+		}, []string{LblType})
+
+	ProxySessionSuspendCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "session_suspend_total",
+			Help:      "Counter of idle sessions releasing their backend connection and resuming on a new one.",
+		}, []string{LblType})
+
+	ProxyConnLimitRejectedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "conn_limit_rejected_total",
+			Help:      "Counter of client connections rejected by the per user or per client ip limits.",
+		}, []string{LblType, "key"})
+
+	ProxyHandshakeTimeoutCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "handshake_timeout_total",
+			Help:      "Counter of client connections closed for not completing the handshake in time.",
+		})
+
+	ProxyHandshakeFailureCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "handshake_failures_total",
+			Help:      "Counter of failed client handshakes by reason.",
+		}, []string{LblReason})
+
+	ProxyAuthBlockedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "auth_blocked_total",
+			Help:      "Counter of client ips blocked after repeated auth failures.",
+		})
+
+	ProxyMetadataCacheCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "metadata_cache_total",
+			Help:      "Counter of metadata selects answered from the proxy cache or missing it.",
+		}, []string{LblResult})
+
+	ProxyRouteCacheCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "route_cache_total",
+			Help:      "Counter of statements routed by the cost kept in the route cache or missing it.",
+		}, []string{LblResult})
+
+	ProxyTokenQueueGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "token_queue",
+			Help:      "Number of sessions waiting for an execution token.",
+		})
+
+	ProxyScaleWebhookCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "scale_webhook_total",
+			Help:      "Counter of scale events posted to the webhooks by result.",
+		}, []string{LblResult})
+
+	ProxyPDLostGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "pd_lost",
+			Help:      "1 while the proxy lost the connection to pd.",
+		})
+
+	ProxyPDLossConnCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "pd_loss_connections_total",
+			Help:      "Counter of connections rejected or accepted read only while pd is lost.",
+		}, []string{LblType})
+
+	ProxyScalerAvailableGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "scaler_available",
+			Help:      "1 while an endpoint of the scaler is reachable.",
+		})
+
+	ProxyScalerFailoverCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "scaler_failover_total",
+			Help:      "Counter of scale calls failed over to the next scaler endpoint.",
+		})
+
+	ProxyCertAuthCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "cert_auth_total",
+			Help:      "Counter of clients logged in or rejected by their tls certificate.",
+		}, []string{LblResult})
+
+	ProxyLocalProbeCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "local_probes_total",
+			Help:      "Counter of health probes answered by the proxy.",
+		}, []string{LblType})
+
+	ProxyMaintenanceGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "pool_in_maintenance",
+			Help:      "1 while the pool is in a maintenance window and its autoscaling is frozen.",
+		}, []string{LblType})
+
+	ProxyPreflightCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "preflight_total",
+			Help:      "Counter of tidb preflights by result, ok or the check rejecting the tidb.",
+		}, []string{LblResult})
+
+	ProxyReadOnlyGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "read_only",
+			Help:      "1 while the proxy is in read-only mode.",
+		})
+
+	ProxyReadOnlyRejectedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "read_only_rejected_total",
+			Help:      "Counter of writes rejected in read-only mode.",
+		})
+
+	ProxyCostEstimateErrorHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "cost_estimate_error_ratio",
+			Help:      "Bucketed histogram of actual cost over estimated cost of statements, by the plan cost and the calibrated cost.",
+			Buckets:   []float64{0.01, 0.1, 0.5, 0.8, 1.25, 2, 10, 100},
+		}, []string{LblType})
+
+	ProxyCostCalibratedDigestsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "cost_calibrated_digests",
+			Help:      "Number of statement digests the cost estimator keeps a correction factor for.",
+		})
+
+	ProxyStatsWarmupCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "stats_warmup_queries_total",
+			Help:      "Counter of the stats warm-up queries run on new tidbs by result, ok, failed or timeout.",
+		}, []string{LblResult})
+
+	ProxyPoolDesiredCoresGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "pool_desired_cores",
+			Help:      "Cores of the pool wanted by the scaling policies in the last reconcile.",
+		}, []string{LblType})
+
+	ProxyPoolActualCoresGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "pool_actual_cores",
+			Help:      "Cores of the up backends of the pool in the last reconcile.",
+		}, []string{LblType})
+
+	ProxyScaleInLockCheckCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "scale_in_lock_check_total",
+			Help:      "Counter of tidb removals held by long transactions by result, vetoed, delayed or timeout.",
+		}, []string{LblResult})
+
+	ProxyOriginLatencyHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "origin_duration_seconds",
+			Help:      "Bucketed histogram of statement latency by statement class and origin, executed in the proxy or forwarded.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 22), // 0.5ms ~ 1048s
+		}, []string{LblType, LblOrigin})
+
+	ProxyConnectDBCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "connect_db_total",
+			Help:      "Counter of handshake default databases unknown to the proxy by result, found, created, missing or timeout.",
+		}, []string{LblResult})
+
+	ProxyAppStmtCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "app_statements_total",
+			Help:      "Counter of statements by application of the client and pool routed to.",
+		}, []string{LblApp, LblType})
+
+	ProxyAppLatencyHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "app_duration_seconds",
+			Help:      "Bucketed histogram of statement latency by application of the client and pool routed to.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 22), // 0.5ms ~ 1048s
+		}, []string{LblApp, LblType})
+
+	ProxyScaleInRejectedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "scale_in_rejected_total",
+			Help:      "Counter of tidb removals and scale ins rejected for leaving the pool without an up tidb or below its min replicas.",
+		}, []string{LblType})
+
+	ProxyAuthPassthroughCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "auth_passthrough_total",
+			Help:      "Counter of client authentications relayed to backends by result, ok, refused or failed.",
+		}, []string{LblResult})
+
+	ProxyCachingSha2Counter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "caching_sha2_auth_total",
+			Help:      "Counter of caching_sha2_password client auths by result, fast, full_tls, full_rsa or failed.",
+		}, []string{LblResult})
+
+	ProxyAutoscaleEvalCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "autoscale_evaluations_total",
+			Help:      "Counter of autoscaler evaluations by pool and the action taken.",
+		}, []string{LblType, LblResult})
+
+	ProxyAutoscaleEvalHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "autoscale_evaluation_duration_seconds",
+			Help:      "Bucketed histogram of the time an autoscaler evaluation of a pool takes.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 18), // 0.1ms ~ 13s
+		}, []string{LblType})
+
+	ProxyShadowCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "shadow_statements_total",
+			Help:      "Counter of statements mirrored to the shadow tidb by result, ok, failed or dropped.",
+		}, []string{LblResult})
+
+	ProxyShadowLatencyHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "shadow_duration_seconds",
+			Help:      "Bucketed histogram of the latency of statements mirrored to the shadow tidb.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 22), // 0.5ms ~ 1048s
+		})
+
+	ProxyLeaderGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "leader",
+			Help:      "1 if the proxy is the leader of its replicas and sends the scale requests.",
+		})
+
+	ProxyPeersGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "peers",
+			Help:      "Other proxy replicas whose counters are aggregated.",
+		})
+
+	ProxyLongTxnCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "long_txns_total",
+			Help:      "Counter of long transactions by action, alerted or killed.",
+		}, []string{LblAction})
+
+	ProxyOldestTxnGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "oldest_txn_seconds",
+			Help:      "Age of the oldest open transaction of the client sessions.",
+		})
+
+	ProxyZoneRouteCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "zone_routes_total",
+			Help:      "Counter of statements routed to backends in the zone of the proxy or in another zone.",
+		}, []string{LblType})
+
+	ProxyPodLookupCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "pod_lookups_total",
+			Help:      "Counter of tidb pods read from kubernetes or its cache, by the state found.",
+		}, []string{LblResult})
+
+	ProxyLocalLoadGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "local_load_ratio",
+			Help:      "Cpu and memory used by the proxy container as ratios of its limits.",
+		}, []string{LblType})
+
+	ProxySelfSkipCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "saturated_self_skips_total",
+			Help:      "Counter of statements routed to a tidb instead of the saturated proxy.",
+		})
+
+	ProxyFreshConnCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "fresh_conns_total",
+			Help:      "Counter of DDL, ANALYZE and ADMIN statements forwarded over a fresh conn, by the tidb chosen.",
+		}, []string{LblResult})
+
+	ProxyBackendFaultCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "backend_faults_total",
+			Help:      "Counter of connection errors and fault codes of each tidb decaying its routing weight.",
+		}, []string{LblAddress})
+
+	ProxySchemaListingCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "schema_listings_total",
+			Help:      "Counter of SHOW DATABASES and SHOW TABLES answered from the listing cache, read from a tidb or run by the proxy.",
+		}, []string{LblResult})
+
+	ProxyAcceptLimitedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "proxy",
+			Name:      "accept_limited_total",
+			Help:      "Counter of new client connections closed by the accept rate limit of the proxy or of their client ip.",
+		}, []string{LblType})
+)
@@ -0,0 +1,297 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+//first byte of the auth more data packet, the caching_sha2_password fast auth success and
+//full auth request, and the public key request of the client answering the full auth
+const (
+	authMoreData     byte = 0x01
+	fastAuthSuccess  byte = 0x03
+	performFullAuth  byte = 0x04
+	requestPublicKey byte = 0x02
+)
+
+//auth plugin sending the password in clear text
+const clearPasswordPlugin = "mysql_clear_password"
+
+//backends tried for a relayed authentication
+const authRelayTries = 2
+
+//AuthRelay authenticates a client on a backend by relaying its auth exchange, so the
+//backend checks the password with its own user system and auth plugins.
+type AuthRelay struct {
+	co *Conn
+	//the exchange runs over tls, the only way a cleartext password is relayed
+	tls bool
+	//the client answers the last packet of the backend with its password in clear text
+	cleartext bool
+}
+
+//DialAuthRelay connects the backend and reads its initial handshake. With tlsCfg the conn
+//is upgraded to tls before the handshake response, a backend without tls is refused.
+func DialAuthRelay(addr string, tlsCfg *tls.Config) (*AuthRelay, error) {
+	co := &Conn{addr: addr, collation: mysql.DEFAULT_COLLATION_ID, charset: mysql.DEFAULT_CHARSET}
+	n := "tcp"
+	if strings.Contains(addr, "/") {
+		n = "unix"
+	}
+	netConn, err := dial(n, addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := netConn.(*net.TCPConn); ok {
+		setKeepAlive(tcpConn)
+	}
+	co.conn = netConn
+	co.pkg = mysql.NewPacketIO(netConn)
+	if err = co.readInitialHandshake(); err != nil {
+		co.Close()
+		return nil, err
+	}
+	if co.authPlugin == "" {
+		co.authPlugin = mysql.AUTH_NAME
+	}
+	r := &AuthRelay{co: co}
+	if tlsCfg != nil {
+		if err = r.upgradeTLS(tlsCfg); err != nil {
+			co.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+//upgradeTLS sends the ssl request and runs the tls handshake, the handshake response then
+//goes over tls.
+func (r *AuthRelay) upgradeTLS(tlsCfg *tls.Config) error {
+	c := r.co
+	if c.capability&mysql.CLIENT_SSL == 0 {
+		return errors.ErrRelayNoTLS
+	}
+	r.tls = true
+	capability := r.capability()
+	//capability, max packet size, charset and 23 bytes filler
+	data := make([]byte, 4+4+4+1+23)
+	data[4] = byte(capability)
+	data[5] = byte(capability >> 8)
+	data[6] = byte(capability >> 16)
+	data[7] = byte(capability >> 24)
+	data[12] = byte(c.collation)
+	if err := c.writePacket(data); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, tlsCfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	seq := c.pkg.Sequence
+	c.conn = tlsConn
+	c.pkg = mysql.NewPacketIO(tlsConn)
+	c.pkg.Sequence = seq
+	return nil
+}
+
+//AuthRelayTLS loads the tls config of the relayed auth exchanges, nil if not configured.
+func AuthRelayTLS(cfg config.AuthRelayTLSConfig) (*tls.Config, error) {
+	if cfg.CA == "" {
+		return nil, nil
+	}
+	ca, err := ioutil.ReadFile(cfg.CA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.ErrInvalidCert
+	}
+	return &tls.Config{
+		RootCAs:    pool,
+		ServerName: cfg.ServerName,
+	}, nil
+}
+
+//DialAuthRelay connects an up backend of the cluster for a relayed authentication.
+func (cluster *Cluster) DialAuthRelay() (*AuthRelay, error) {
+	tlsCfg, err := AuthRelayTLS(cluster.Cfg.AuthRelayTLS)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error = errors.ErrNoTidbDB
+	for _, addr := range cluster.upAddrs(authRelayTries) {
+		r, err := DialAuthRelay(addr, tlsCfg)
+		if err == nil {
+			return r, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+//Salt is the scramble of the backend, the client answers it.
+func (r *AuthRelay) Salt() []byte {
+	return r.co.salt
+}
+
+//Plugin is the auth plugin the backend starts with.
+func (r *AuthRelay) Plugin() string {
+	return r.co.authPlugin
+}
+
+func (r *AuthRelay) Addr() string {
+	return r.co.addr
+}
+
+//Start sends the handshake response of the user with the auth data the client computed
+//from Salt by Plugin. No database is sent, the proxy checks it.
+func (r *AuthRelay) Start(user string, auth []byte) error {
+	c := r.co
+	if c.authPlugin == clearPasswordPlugin && !r.tls {
+		return errors.ErrRelayCleartext
+	}
+	capability := r.capability()
+	c.capability = capability
+
+	var attrs []byte
+	if capability&mysql.CLIENT_CONNECT_ATTRS > 0 {
+		for _, kv := range connectAttrs {
+			attrs = append(attrs, mysql.PutLengthEncodedString([]byte(kv[0]))...)
+			attrs = append(attrs, mysql.PutLengthEncodedString([]byte(kv[1]))...)
+		}
+		attrs = append(mysql.PutLengthEncodedInt(uint64(len(attrs))), attrs...)
+	}
+
+	//capability, max packet size, charset and 23 bytes filler
+	data := make([]byte, 4+4+4+1+23, 4+4+4+1+23+len(user)+2+len(auth)+len(c.authPlugin)+1+len(attrs))
+	data[4] = byte(capability)
+	data[5] = byte(capability >> 8)
+	data[6] = byte(capability >> 16)
+	data[7] = byte(capability >> 24)
+	data[12] = byte(c.collation)
+	data = append(data, user...)
+	data = append(data, 0)
+	data = append(data, byte(len(auth)))
+	data = append(data, auth...)
+	if capability&mysql.CLIENT_PLUGIN_AUTH > 0 {
+		data = append(data, c.authPlugin...)
+		data = append(data, 0)
+	}
+	data = append(data, attrs...)
+	return c.writePacket(data)
+}
+
+//capability is the one of the handshake response, ssl included over tls.
+func (r *AuthRelay) capability() uint32 {
+	capability := mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION |
+		mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_LONG_FLAG |
+		mysql.CLIENT_PLUGIN_AUTH | mysql.CLIENT_CONNECT_ATTRS
+	if r.tls {
+		capability |= mysql.CLIENT_SSL
+	}
+	return capability & r.co.capability
+}
+
+//Next reads the next packet of the backend. A nil packet ends the exchange, with the error
+//of the backend if it refuses the client. Otherwise the packet is relayed to the client,
+//and its answer sent by Reply when reply is set.
+func (r *AuthRelay) Next() (packet []byte, reply bool, err error) {
+	data, err := r.co.readPacket()
+	if err != nil {
+		return nil, false, err
+	}
+	r.cleartext = false
+	switch data[0] {
+	case mysql.OK_HEADER:
+		return nil, false, nil
+	case mysql.ERR_HEADER:
+		return nil, false, r.co.handleErrorPacket(data)
+	case authMoreData:
+		//the fast auth success is followed by the ok of the backend, the full auth is
+		//answered by the password or the request of the public key
+		r.cleartext = len(data) > 1 && data[1] == performFullAuth
+		return data, len(data) < 2 || data[1] != fastAuthSuccess, nil
+	case mysql.EOF_HEADER:
+		//auth switch request, the plugin name ends with a 0
+		plugin := data[1:]
+		if i := strings.IndexByte(string(plugin), 0); i >= 0 {
+			plugin = plugin[:i]
+		}
+		r.cleartext = string(plugin) == clearPasswordPlugin
+		return data, true, nil
+	default:
+		return data, true, nil
+	}
+}
+
+//Reply sends the answer of the client to the last packet of the backend. A password in
+//clear text is only sent over tls.
+func (r *AuthRelay) Reply(data []byte) error {
+	if r.cleartext && !r.tls && !(len(data) == 1 && data[0] == requestPublicKey) {
+		return errors.ErrRelayCleartext
+	}
+	return r.co.writePacket(append(make([]byte, 4, 4+len(data)), data...))
+}
+
+//CurrentUser asks the backend which account it authenticated the client as, once the
+//exchange ended with its ok.
+func (r *AuthRelay) CurrentUser() (user string, host string, err error) {
+	result, err := r.co.exec("SELECT CURRENT_USER()")
+	if err != nil {
+		return "", "", err
+	}
+	if result.Resultset == nil || result.RowNumber() == 0 {
+		return "", "", mysql.NewError(mysql.ER_UNKNOWN_ERROR, "result is empty")
+	}
+	account, err := result.GetString(0, 0)
+	if err != nil {
+		return "", "", err
+	}
+	i := strings.LastIndexByte(account, '@')
+	if i < 0 {
+		return "", "", mysql.NewError(mysql.ER_UNKNOWN_ERROR, "unexpected current user "+account)
+	}
+	return account[:i], account[i+1:], nil
+}
+
+//Session keeps the conn of the exchange the backend accepted as the backend session of the
+//client, its statements run as the account the backend authenticated. The conn is pinned to
+//the session, Unpin then Close closes it, it never goes to the pool of the cluster.
+func (r *AuthRelay) Session(cluster *Cluster) *BackendConn {
+	_, _, db := cluster.dbOfPod(r.co.addr)
+	if db == nil {
+		//deleted from the pool while the client logged in
+		db = &DB{addr: r.co.addr, dbType: TiDBForTP}
+	}
+	co := r.co
+	r.co = nil
+	return &BackendConn{Conn: co, db: db, pinned: true, fresh: true}
+}
+
+func (r *AuthRelay) Close() {
+	if r.co != nil {
+		r.co.Close()
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+//serveAuth answers one relayed authentication as a backend asking for a full
+//caching_sha2_password auth.
+func serveAuth(t *testing.T, l net.Listener, salt []byte, done chan<- error) {
+	conn, err := l.Accept()
+	if err != nil {
+		done <- err
+		return
+	}
+	defer conn.Close()
+	pkg := mysql.NewPacketIO(conn)
+	capability := mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION | mysql.CLIENT_PLUGIN_AUTH
+	hs := []byte{0, 0, 0, 0, 10}
+	hs = append(hs, "5.7.25-TiDB-v5.1.0"...)
+	hs = append(hs, 0, 1, 0, 0, 0)
+	hs = append(hs, salt[:8]...)
+	hs = append(hs, 0, byte(capability), byte(capability>>8), 46, 2, 0,
+		byte(capability>>16), byte(capability>>24), 21)
+	hs = append(hs, make([]byte, 10)...)
+	hs = append(hs, salt[8:]...)
+	hs = append(hs, 0)
+	hs = append(hs, "caching_sha2_password"...)
+	hs = append(hs, 0)
+	if err = pkg.WritePacket(hs); err != nil {
+		done <- err
+		return
+	}
+	resp, err := pkg.ReadPacket()
+	if err != nil {
+		done <- err
+		return
+	}
+	if !bytes.Contains(resp, []byte("u1\x00\x03abc")) || !bytes.Contains(resp, []byte("caching_sha2_password\x00")) {
+		t.Errorf("unexpected handshake response %q", resp)
+	}
+	if err = pkg.WritePacket([]byte{0, 0, 0, 0, authMoreData, 4}); err != nil {
+		done <- err
+		return
+	}
+	if key, err := pkg.ReadPacket(); err != nil || !bytes.Equal(key, []byte{requestPublicKey}) {
+		t.Errorf("unexpected full auth %q %v", key, err)
+	}
+	done <- pkg.WritePacket([]byte{0, 0, 0, 0, mysql.OK_HEADER, 0, 0, 2, 0, 0, 0})
+}
+
+func TestAuthRelay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	salt := []byte("0123456789abcdefghij")
+	done := make(chan error, 1)
+	go serveAuth(t, l, salt, done)
+
+	r, err := DialAuthRelay(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !bytes.Equal(r.Salt(), salt) || r.Plugin() != "caching_sha2_password" {
+		t.Fatalf("unexpected salt %q plugin %q", r.Salt(), r.Plugin())
+	}
+	if err = r.Start("u1", []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	packet, reply, err := r.Next()
+	if err != nil || !reply || !bytes.Equal(packet, []byte{authMoreData, 4}) {
+		t.Fatalf("unexpected full auth request %v %v %v", packet, reply, err)
+	}
+	//a cleartext password is not relayed over plain tcp, the public key request is
+	if err = r.Reply([]byte("pw\x00")); err != errors.ErrRelayCleartext {
+		t.Fatalf("cleartext password relayed without tls: %v", err)
+	}
+	if err = r.Reply([]byte{requestPublicKey}); err != nil {
+		t.Fatal(err)
+	}
+	if packet, _, err = r.Next(); packet != nil || err != nil {
+		t.Fatalf("relay not ended by ok: %v %v", packet, err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
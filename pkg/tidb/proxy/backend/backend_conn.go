@@ -29,6 +29,11 @@ import (
 
 var (
 	pingPeriod = int64(time.Second * 16)
+	//attributes sent to backends in the handshake, so backend processlists can tell proxy connections
+	connectAttrs = [][2]string{
+		{"_client_name", "he3proxy"},
+		{"program_name", "he3proxy"},
+	}
 )
 
 //proxy <-> mysql server
@@ -49,9 +54,33 @@ type Conn struct {
 	collation mysql.CollationId
 	charset   string
 	salt      []byte
+	//server version reported in the initial handshake
+	serverVersion string
+	//auth plugin the backend asks for in the initial handshake
+	authPlugin string
+	//attributes of the client connection this conn serves last
+	connAttrs string
+	//tidb_replica_read of the session, empty means the backend default
+	replicaRead string
+	//tidb_isolation_read_engines of the session, empty means the backend default
+	readEngines string
+	//tidb_force_priority of the session, empty means the backend default
+	forcePriority string
+	//cap of the result sets read
+	resultLimit ResultLimit
+
+	//the statements run on the conn fail once it passes, zero for none
+	deadline time.Time
 
 	pushTimestamp int64
 	pkgErr        error
+	//a statement hit a connection error or a fault code of the tidb, see reportFault
+	fault bool
+	//ip the conn is connected to
+	remoteIP string
+	//client the conn is dialed for, sent to the backend by PROXY protocol
+	clientAddr *net.TCPAddr
+	clientIP   string
 }
 
 func (c *Conn) Connect(addr string, user string, password string, db string) error {
@@ -77,7 +106,7 @@ func (c *Conn) ReConnect() error {
 		n = "unix"
 	}
 
-	netConn, err := net.Dial(n, c.addr)
+	netConn, err := dial(n, c.addr)
 	if err != nil {
 		return err
 	}
@@ -90,10 +119,18 @@ func (c *Conn) ReConnect() error {
 	// meaning that data is sent as soon as possible after a Write.
 	//I set this option false.
 	tcpConn.SetNoDelay(false)
-	tcpConn.SetKeepAlive(true)
+	setKeepAlive(tcpConn)
 	c.conn = tcpConn
+	c.remoteIP = ipOf(tcpConn.RemoteAddr())
 	c.pkg = mysql.NewPacketIO(tcpConn)
 
+	if c.clientAddr != nil {
+		if err := c.writeProxyHeader(tcpConn); err != nil {
+			c.conn.Close()
+			return err
+		}
+	}
+
 	if err := c.readInitialHandshake(); err != nil {
 		c.conn.Close()
 		return err
@@ -111,6 +148,12 @@ func (c *Conn) ReConnect() error {
 		return err
 	}
 
+	if err := c.enableCompression(); err != nil {
+		c.conn.Close()
+
+		return err
+	}
+
 	//we must always use autocommit
 	if !c.IsAutoCommit() {
 		if _, err := c.exec("set autocommit = 1"); err != nil {
@@ -129,20 +172,29 @@ func (c *Conn) Close() error {
 		c.conn = nil
 		c.salt = nil
 		c.pkgErr = nil
+		c.fault = false
 	}
 
 	return nil
 }
 
 func (c *Conn) readPacket() ([]byte, error) {
+	c.setReadDeadline()
 	d, err := c.pkg.ReadPacket()
 	c.pkgErr = err
+	if err != nil {
+		c.fault = true
+	}
 	return d, err
 }
 
 func (c *Conn) writePacket(data []byte) error {
+	c.setWriteDeadline()
 	err := c.pkg.WritePacket(data)
 	c.pkgErr = err
+	if err != nil {
+		c.fault = true
+	}
 	return err
 }
 
@@ -160,10 +212,11 @@ func (c *Conn) readInitialHandshake() error {
 		return fmt.Errorf("invalid protocol version %d, must >= 10", data[0])
 	}
 
-	//skip mysql version and connection id
 	//mysql version end with 0x00
-	//connection id length is 4
-	pos := 1 + bytes.IndexByte(data[1:], 0x00) + 1 + 4
+	//skip connection id, its length is 4
+	versionEnd := 1 + bytes.IndexByte(data[1:], 0x00)
+	c.serverVersion = string(data[1:versionEnd])
+	pos := versionEnd + 1 + 4
 
 	c.salt = append(c.salt, data[pos:pos+8]...)
 
@@ -196,6 +249,16 @@ func (c *Conn) readInitialHandshake() error {
 		// mysql-proxy also use 12
 		// which is not documented but seems to work.
 		c.salt = append(c.salt, data[pos:pos+12]...)
+		pos += 12 + 1
+
+		//auth plugin name [null terminated]
+		if c.capability&mysql.CLIENT_PLUGIN_AUTH > 0 && len(data) > pos {
+			if end := bytes.IndexByte(data[pos:], 0x00); end >= 0 {
+				c.authPlugin = string(data[pos : pos+end])
+			} else {
+				c.authPlugin = string(data[pos:])
+			}
+		}
 	}
 
 	return nil
@@ -204,9 +267,11 @@ func (c *Conn) readInitialHandshake() error {
 func (c *Conn) writeAuthHandshake() error {
 	// Adjust client capability flags based on server support
 	capability := mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION |
-		mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_LONG_FLAG
+		mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_LONG_FLAG |
+		mysql.CLIENT_LOCAL_FILES | mysql.CLIENT_CONNECT_ATTRS | mysql.CLIENT_SESSION_TRACK
 
 	capability &= c.capability
+	capability |= compressionCapability(c.capability)
 
 	//packet length
 	//capbility 4
@@ -229,6 +294,24 @@ func (c *Conn) writeAuthHandshake() error {
 		length += len(c.db) + 1
 	}
 
+	var attrs []byte
+	if capability&mysql.CLIENT_CONNECT_ATTRS > 0 {
+		for _, kv := range connectAttrs {
+			attrs = append(attrs, mysql.PutLengthEncodedString([]byte(kv[0]))...)
+			attrs = append(attrs, mysql.PutLengthEncodedString([]byte(kv[1]))...)
+		}
+		attrs = append(mysql.PutLengthEncodedInt(uint64(len(attrs))), attrs...)
+		length += len(attrs)
+	}
+
+	zstdLevel := compressionLevel
+	if capability&mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM > 0 {
+		if zstdLevel == 0 {
+			zstdLevel = mysql.DefaultZstdLevel
+		}
+		length++
+	}
+
 	c.capability = capability
 
 	data := make([]byte, length+4)
@@ -266,6 +349,15 @@ func (c *Conn) writeAuthHandshake() error {
 	if len(c.db) > 0 {
 		pos += copy(data[pos:], c.db)
 		//data[pos] = 0x00
+		pos++
+	}
+
+	// connect attributes [length encoded]
+	pos += copy(data[pos:], attrs)
+
+	// zstd compression level [1 byte]
+	if capability&mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM > 0 {
+		data[pos] = byte(zstdLevel)
 	}
 
 	return c.writePacket(data)
@@ -383,6 +475,10 @@ func (c *Conn) GetAddr() string {
 	return c.addr
 }
 
+func (c *Conn) GetServerVersion() string {
+	return c.serverVersion
+}
+
 func (c *Conn) Execute(proxyS *Stmt,paramsType []byte,args ...interface{}) (*mysql.Result, error) {
 	if len(args) == 0 {
 		return c.exec(proxyS.query)
@@ -442,6 +538,71 @@ func (c *Conn) SetAutoCommit(n uint8) error {
 	return nil
 }
 
+//SetConnAttrs tags the connection with attributes of the client connection it serves,
+//the tag is kept in a user variable so backend sessions can be traced back to the app.
+func (c *Conn) SetConnAttrs(attrs string) error {
+	if c.connAttrs == attrs {
+		return nil
+	}
+	if _, err := c.exec(fmt.Sprintf("SET @proxy_conn_attrs = '%s'", mysql.Escape(attrs))); err != nil {
+		return err
+	}
+	c.connAttrs = attrs
+	return nil
+}
+
+//SetForcePriority sets tidb_force_priority of the backend session if it changed, empty and
+//NO_PRIORITY both mean the priority of the statement.
+func (c *Conn) SetForcePriority(priority string) error {
+	if priority == "" {
+		priority = NoPriority
+	}
+	cur := c.forcePriority
+	if cur == "" {
+		cur = NoPriority
+	}
+	if cur == priority {
+		return nil
+	}
+	if _, err := c.exec(fmt.Sprintf("SET @@session.tidb_force_priority = '%s'", mysql.Escape(priority))); err != nil {
+		return err
+	}
+	c.forcePriority = priority
+	return nil
+}
+
+//SetReplicaRead sets tidb_replica_read of the backend session if it changed, empty and
+//leader both mean reading from the leader.
+func (c *Conn) SetReplicaRead(mode string) error {
+	if mode == "" {
+		mode = ReplicaReadLeader
+	}
+	cur := c.replicaRead
+	if cur == "" {
+		cur = ReplicaReadLeader
+	}
+	if cur == mode {
+		return nil
+	}
+	if _, err := c.exec(fmt.Sprintf("SET @@session.tidb_replica_read = '%s'", mysql.Escape(mode))); err != nil {
+		return err
+	}
+	c.replicaRead = mode
+	return nil
+}
+
+//SetReadEngines sets tidb_isolation_read_engines of the backend session if it changed.
+func (c *Conn) SetReadEngines(engines string) error {
+	if c.readEngines == engines {
+		return nil
+	}
+	if _, err := c.exec(fmt.Sprintf("SET @@session.tidb_isolation_read_engines = '%s'", mysql.Escape(engines))); err != nil {
+		return err
+	}
+	c.readEngines = engines
+	return nil
+}
+
 func (c *Conn) SetCharset(charset string, collation mysql.CollationId) error {
 	charset = strings.Trim(charset, "\"'`")
 
@@ -514,6 +675,44 @@ func (c *Conn) exec(query string) (*mysql.Result, error) {
 	return c.readResult(false)
 }
 
+//LoadData runs LOAD DATA on the backend. When the backend asks for the local file,
+//readFile is called to relay the file content packet by packet through send.
+func (c *Conn) LoadData(query string, readFile func(fileName string, send func(data []byte) error) error) (*mysql.Result, error) {
+	if err := c.writeCommandStr(mysql.COM_QUERY, query); err != nil {
+		return nil, err
+	}
+	data, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	switch data[0] {
+	case mysql.OK_HEADER:
+		return c.handleOKPacket(data)
+	case mysql.ERR_HEADER:
+		return nil, c.handleErrorPacket(data)
+	case mysql.LocalInFile_HEADER:
+	default:
+		return nil, mysql.ErrMalformPacket
+	}
+
+	send := func(payload []byte) error {
+		buf := make([]byte, 4+len(payload))
+		copy(buf[4:], payload)
+		return c.writePacket(buf)
+	}
+	if err = readFile(string(data[1:]), send); err != nil {
+		//break the connection, so the backend aborts the load instead of loading a partial file
+		c.conn.Close()
+		c.pkgErr = err
+		return nil, err
+	}
+	//empty packet ends the file
+	if err = send(nil); err != nil {
+		return nil, err
+	}
+	return c.readOK()
+}
+
 func (c *Conn) readResultset(data []byte, binary bool) (*mysql.Result, error) {
 	result := &mysql.Result{
 		Status:       0,
@@ -583,6 +782,7 @@ func (c *Conn) readResultColumns(result *mysql.Result) (err error) {
 
 func (c *Conn) readResultRows(result *mysql.Result, isBinary bool) (err error) {
 	var data []byte
+	var size int64
 
 	for {
 		data, err = c.readPacket()
@@ -591,6 +791,20 @@ func (c *Conn) readResultRows(result *mysql.Result, isBinary bool) (err error) {
 			return
 		}
 
+		if !c.isEOFPacket(data) {
+			size += int64(len(data))
+			if c.resultLimit.exceeded(int64(len(result.RowDatas))+1, size) {
+				if err = c.abortResult(); err != nil {
+					return
+				}
+				if !c.resultLimit.Truncate {
+					return ErrResultTooLarge(c.resultLimit)
+				}
+				result.Truncated = true
+				break
+			}
+		}
+
 		// EOF Packet
 		if c.isEOFPacket(data) {
 			if c.capability&mysql.CLIENT_PROTOCOL_41 > 0 {
@@ -659,14 +873,18 @@ func (c *Conn) handleOKPacket(data []byte) (*mysql.Result, error) {
 
 		//todo:strict_mode, check warnings as error
 		//Warnings := binary.LittleEndiacluster.Uint16(data[pos:])
-		//pos += 2
+		pos += 2
 	} else if c.capability&mysql.CLIENT_TRANSACTIONS > 0 {
 		r.Status = binary.LittleEndian.Uint16(data[pos:])
 		c.status = r.Status
 		pos += 2
 	}
 
-	//info
+	//info, then the session state changes passed to the client
+	if c.capability&mysql.CLIENT_SESSION_TRACK > 0 && r.Status&mysql.SERVER_SESSION_STATE_CHANGED > 0 && pos < len(data) {
+		//the statement succeeded, malformed state changes are dropped instead of failing it
+		r.SessionStates, _ = mysql.ParseOKSessionStates(data[pos:])
+	}
 	return r, nil
 }
 
@@ -686,6 +904,9 @@ func (c *Conn) handleErrorPacket(data []byte) error {
 	}
 
 	e.Message = string(data[pos:])
+	if isFaultCode(e.Code) {
+		c.fault = true
+	}
 
 	return e
 }
@@ -17,14 +17,23 @@ package backend
 import (
 	"context"
 	"fmt"
+	"github.com/pingcap/tidb/metrics"
 	"github.com/pingcap/tidb/proxy/core/errors"
 	"github.com/pingcap/tidb/proxy/scalepb"
-	"google.golang.org/grpc"
+	"github.com/pingcap/tidb/proxy/scaler"
 	"sync/atomic"
+	"time"
 )
 
 const DefaultBigSize = 16.0
 
+const (
+	//weights are scaled to int by weightPrecision before gcd
+	weightPrecision = 100
+	//warm-up starts at 1/warmupSteps of the weight and grows one step at a time
+	warmupSteps = 10
+)
+
 func Gcd(ary []int) int {
 	var i int
 	min := ary[0]
@@ -75,12 +84,24 @@ func gcd(x, y int) int {
 
 func (cluster *Pool) InitBalancer() {
 	var sum int
-	cluster.LastTidbIndex = 0
 
 	sws := make([]int, 0, len(cluster.TidbsWeights))
 
 	for i := 0; i < len(cluster.TidbsWeights); i++ {
-		sws = append(sws, int(cluster.TidbsWeights[i]*10))
+		weight := cluster.TidbsWeights[i]
+		if i < len(cluster.Tidbs) {
+			factor, step := cluster.Tidbs[i].warmupFactor()
+			cluster.Tidbs[i].warmupStep = step
+			weight *= factor
+			factor, step = cluster.Tidbs[i].errorFactor(time.Now())
+			cluster.Tidbs[i].errorStep = step
+			weight *= factor
+		}
+		sw := int(weight * weightPrecision)
+		if sw < 1 && weight > 0 {
+			sw = 1
+		}
+		sws = append(sws, sw)
 	}
 
 	//gcd := Gcd(sws)
@@ -100,6 +121,42 @@ func (cluster *Pool) InitBalancer() {
 	for i:=0;i<len(cluster.Tidbs);i++ {
 		fmt.Println("db weight db self",cluster.Tidbs[i].addr,cluster.Tidbs[i].Self)
 	}
+	cluster.publish()
+}
+
+//warmupFactor returns the share of routing weight and the warm-up step of the backend.
+func (db *DB) warmupFactor() (float64, int) {
+	if db.warmupWindow <= 0 || db.warmupStart.IsZero() {
+		return 1, warmupSteps
+	}
+	step := int(time.Since(db.warmupStart)*warmupSteps/db.warmupWindow) + 1
+	if step >= warmupSteps {
+		return 1, warmupSteps
+	}
+	return float64(step) / warmupSteps, step
+}
+
+//CheckWarmup rebuilds the balancer when a warming backend, or one whose weight decays by
+//its errors, moves to the next step.
+func (cluster *Cluster) CheckWarmup() {
+	for cluster.Online {
+		if cluster.WarmupWindow > 0 || errorDecayOn() {
+			now := time.Now()
+			for _, pool := range cluster.BackendPools {
+				pool.Lock()
+				for _, db := range pool.Tidbs {
+					_, step := db.warmupFactor()
+					_, errorStep := db.errorFactor(now)
+					if step != db.warmupStep || errorStep != db.errorStep {
+						pool.InitBalancer()
+						break
+					}
+				}
+				pool.Unlock()
+			}
+		}
+		time.Sleep(time.Second)
+	}
 }
 
 type peer struct {
@@ -148,24 +205,15 @@ func (cluster *Cluster) GetNextTidb(lbIndicator string, cost int64,bindFlag bool
 		pool := cluster.BackendPools[TiDBForTP]
 		var i int
 		for ;i<30;i++ {
-			pool.Lock()
-			//if cluster.ProxyNode.IsPureCompute && len(pool.Tidbs) == 1 {
-			if len(pool.Tidbs) == 1 {
-				db = pool.Tidbs[0]
-			} else {
-				db, err = pool.GetNextDB(lbIndicator)
-				if err != nil {
-					pool.Unlock()
-					return nil, err
-				}
+			db, _, err = pool.pick(lbIndicator)
+			if err != nil {
+				return nil, err
 			}
-			pool.Unlock()
 
 			if db.Self {
 				atomic.AddInt64(&cluster.ProxyNode.ProxyCost, cost)
 			} else {
-				atomic.AddInt64(&pool.Costs, cost)
-				atomic.AddUint64(&pool.TotalCost[CurCost],uint64(cost))
+				pool.AddCost(db, cost)
 			}
 			return db, err
 		}
@@ -193,54 +241,77 @@ func (cluster *Cluster) GetNextTidb(lbIndicator string, cost int64,bindFlag bool
 	default:
 		//choose AP tidb pools
 		pool := cluster.BackendPools[TiDBForAP]
-		pool.Lock()
-		if len(pool.Tidbs) == 1 {
-			db = pool.Tidbs[0]
-		} else {
-			db, err = pool.GetNextDB(lbIndicator)
-			if err != nil {
-				pool.Unlock()
-				return nil, err
-			}
+		db, _, err = pool.pick(lbIndicator)
+		if err != nil {
+			return nil, err
 		}
-		pool.Unlock()
-		atomic.AddInt64(&pool.Costs, cost)
-		atomic.AddUint64(&pool.TotalCost[CurCost],uint64(cost))
+		pool.AddCost(db, cost)
 		return db, err
 	}
 	return db, err
 }
 
+//GetNextDB returns the next backend of the current view by the indicator, it doesn't
+//take the pool lock.
 func (cluster *Pool) GetNextDB(indicator string) (*DB, error) {
+	return cluster.nextDB(cluster.view(), indicator)
+}
+
+func (cluster *Pool) nextDB(v *poolView, indicator string) (*DB, error) {
 	switch indicator {
 	case "qps":
 		var index int
-		queueLen := len(cluster.RoundRobinQ)
+		queueLen := len(v.roundRobinQ)
 		if queueLen == 0 {
-			fmt.Println("queueLen is 0, cluster tidb is ", cluster.Tidbs, cluster.RoundRobinQ, cluster.TidbsWeights)
+			fmt.Println("queueLen is 0, cluster tidb is ", v.tidbs, v.roundRobinQ, v.weights)
 			return nil, errors.ErrNoDatabase
 		}
 		if queueLen == 1 {
-			index = cluster.RoundRobinQ[0]
-			return cluster.Tidbs[index], nil
+			index = v.roundRobinQ[0]
+			if len(v.tidbs) <= index {
+				return nil, errors.ErrNoDatabase
+			}
+			return v.tidbs[index], nil
 		}
 
-		cluster.LastTidbIndex = cluster.LastTidbIndex % queueLen
+		start := cluster.nextIndex(queueLen)
+		strict := cluster.strictZone(v)
 
-		var db *DB
-		for i := 0; i < len(cluster.RoundRobinQ); i++ {
-			index = cluster.RoundRobinQ[cluster.LastTidbIndex]
-			if len(cluster.Tidbs) <= index {
+		var db, stale, busy *DB
+		for i := 0; i < queueLen; i++ {
+			index = v.roundRobinQ[(start+i)%queueLen]
+			if len(v.tidbs) <= index {
 				fmt.Println("========index is====", index)
 				return nil, errors.ErrNoDatabase
 			}
-			db = cluster.Tidbs[index]
-			cluster.LastTidbIndex++
-			cluster.LastTidbIndex = cluster.LastTidbIndex % queueLen
-			if db.state == Up {
-				return db, nil
+			db = v.tidbs[index]
+			if strict && !db.inZone(cluster.zone) {
+				continue
+			}
+			if atomic.LoadInt32(&db.state) == Up {
+				//the proxy without headroom only takes statements no tidb is up for
+				if db.busy() {
+					busy = db
+					continue
+				}
+				//delay routing to backends which have not loaded the latest schema
+				if !db.IsSchemaStale() {
+					if busy != nil {
+						metrics.ProxySelfSkipCounter.Inc()
+					}
+					return cluster.localize(v, index), nil
+				}
+				if stale == nil {
+					stale = db
+				}
 			}
 		}
+		if busy != nil {
+			return busy, nil
+		}
+		if stale != nil {
+			return stale, nil
+		}
 	case "cost":
 		//Check whether the number of tidb nodes exceeds 8.
 		//when less then 8, get tidb node of least costs.
@@ -273,24 +344,18 @@ func GetBigCostDB(addr string, user string, password string, dbName string) (*DB
 }
 
 func ScaleTempTidb(ns, clus string, hashrate float32, needStart bool, needStopAddr string) (*scalepb.TempClusterReply, error) {
-	serviceName := "scale-operator.sldb-admin.svc:8028"
-
-	conn, err := grpc.Dial(serviceName, grpc.WithInsecure())
-	if err != nil {
-		fmt.Errorf("scale big tidb failed:%s", err)
-		return nil, err
-	}
-	defer conn.Close()
-
-	t := scalepb.NewScaleClient(conn)
-
+	var tr *scalepb.TempClusterReply
 	// 调用gRPC接口
-	tr, err := t.ScaleTempCluster(context.Background(), &scalepb.TempClusterRequest{
-		Clustername: clus,
-		Namespace:   ns,
-		Start:       needStart,
-		Hashrate:    hashrate,
-		StopAddr:    needStopAddr,
+	err := scaler.Do(context.Background(), func(ctx context.Context, t scalepb.ScaleClient) error {
+		var err error
+		tr, err = t.ScaleTempCluster(ctx, &scalepb.TempClusterRequest{
+			Clustername: clus,
+			Namespace:   ns,
+			Start:       needStart,
+			Hashrate:    hashrate,
+			StopAddr:    needStopAddr,
+		})
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,225 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+//idle conns kept for every client ip when the client ip is sent by PROXY protocol
+const maxClientIdleConns = 4
+
+//idle conns kept for all the client ips of a backend, the conns in use and the idle ones
+//dialed for clients also stay within the max conns of the backend
+const maxClientIdleTotal = 64
+
+//seconds a conn dialed for a client ip stays idle before it is closed
+const clientIdleTimeout int64 = 60
+
+//https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV2Command = 0x21
+	proxyV2TCP4    = 0x11
+	proxyV2TCP6    = 0x21
+)
+
+//proxyHeaderV2 returns the PROXY protocol v2 header telling the backend the conn comes
+//from src, ipv4 addresses are mapped to ipv6 when the other side is ipv6.
+func proxyHeaderV2(src, dst *net.TCPAddr) []byte {
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	family := byte(proxyV2TCP4)
+	if srcIP == nil || dstIP == nil {
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		family = proxyV2TCP6
+	}
+	addrLen := 2*len(srcIP) + 4
+
+	header := make([]byte, 0, len(proxyV2Signature)+4+addrLen)
+	header = append(header, proxyV2Signature...)
+	header = append(header, proxyV2Command, family, byte(addrLen>>8), byte(addrLen))
+	header = append(header, srcIP...)
+	header = append(header, dstIP...)
+	var ports [4]byte
+	binary.BigEndian.PutUint16(ports[:2], uint16(src.Port))
+	binary.BigEndian.PutUint16(ports[2:], uint16(dst.Port))
+	return append(header, ports[:]...)
+}
+
+//writeProxyHeader sends the client address ahead of the handshake.
+func (c *Conn) writeProxyHeader(conn *net.TCPConn) error {
+	dst, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	_, err := conn.Write(proxyHeaderV2(c.clientAddr, dst))
+	return err
+}
+
+//ClientIP returns the client ip the conn is dialed for, empty for pooled conns.
+func (c *Conn) ClientIP() string {
+	return c.clientIP
+}
+
+//popClientConn takes an idle conn dialed for the client ip, or dials a new one.
+func (db *DB) popClientConn(client *net.TCPAddr) (*Conn, error) {
+	ip := client.IP.String()
+	for {
+		now := time.Now().Unix()
+		db.clientMu.Lock()
+		expired := db.expireClientConns(now)
+		co := db.takeClientConn(ip)
+		db.clientMu.Unlock()
+		closeConns(expired)
+		if co == nil {
+			return db.dialClientConn(client)
+		}
+
+		if db.isStale(co) {
+			co.Close()
+			continue
+		}
+		if PingPeroid < now-co.pushTimestamp {
+			if err := co.Ping(); err != nil {
+				db.resolve()
+				co.Close()
+				continue
+			}
+		}
+		if err := db.tryReuse(co); err != nil {
+			co.Close()
+			continue
+		}
+		return co, nil
+	}
+}
+
+//takeClientConn removes the last idle conn of the client ip, nil if there is none. The
+//caller holds clientMu.
+func (db *DB) takeClientConn(ip string) *Conn {
+	conns := db.clientConns[ip]
+	if len(conns) == 0 {
+		return nil
+	}
+	co := conns[len(conns)-1]
+	conns[len(conns)-1] = nil
+	if len(conns) == 1 {
+		delete(db.clientConns, ip)
+	} else {
+		db.clientConns[ip] = conns[:len(conns)-1]
+	}
+	db.clientIdle--
+	return co
+}
+
+//expireClientConns removes the conns idle for longer than clientIdleTimeout and returns
+//them to be closed. The conns of a client ip are pushed oldest first. The caller holds
+//clientMu.
+func (db *DB) expireClientConns(now int64) []*Conn {
+	var expired []*Conn
+	for ip, conns := range db.clientConns {
+		n := 0
+		for n < len(conns) && clientIdleTimeout < now-conns[n].pushTimestamp {
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		expired = append(expired, conns[:n]...)
+		db.clientIdle -= n
+		if n == len(conns) {
+			delete(db.clientConns, ip)
+		} else {
+			db.clientConns[ip] = append([]*Conn(nil), conns[n:]...)
+		}
+	}
+	return expired
+}
+
+func closeConns(conns []*Conn) {
+	for _, co := range conns {
+		co.Close()
+	}
+}
+
+func (db *DB) dialClientConn(client *net.TCPAddr) (*Conn, error) {
+	co := &Conn{clientAddr: client, clientIP: client.IP.String()}
+	if err := co.Connect(db.addr, db.user, db.password, db.db); err != nil {
+		db.resolve()
+		return nil, err
+	}
+	co.pushTimestamp = time.Now().Unix()
+	return co, nil
+}
+
+//pushClientConn keeps the conn for later statements from the same client ip.
+func (db *DB) pushClientConn(co *Conn, err error) {
+	if err != nil || co.pkgErr != nil || db.isStale(co) || db.getCacheConns() == nil {
+		co.Close()
+		return
+	}
+	now := time.Now().Unix()
+	co.pushTimestamp = now
+	db.clientMu.Lock()
+	expired := db.expireClientConns(now)
+	keep := len(db.clientConns[co.clientIP]) < maxClientIdleConns && db.clientIdle < maxClientIdleTotal &&
+		db.clientIdle+int(atomic.LoadInt64(&db.usingConnsCount)) < db.maxConnNum
+	if keep {
+		if db.clientConns == nil {
+			db.clientConns = make(map[string][]*Conn)
+		}
+		db.clientConns[co.clientIP] = append(db.clientConns[co.clientIP], co)
+		db.clientIdle++
+	}
+	db.clientMu.Unlock()
+	closeConns(expired)
+	if !keep {
+		co.Close()
+	}
+}
+
+//closeClientConns drops the idle conns dialed for clients.
+func (db *DB) closeClientConns() {
+	db.clientMu.Lock()
+	conns := db.clientConns
+	db.clientConns = nil
+	db.clientIdle = 0
+	db.clientMu.Unlock()
+	for _, list := range conns {
+		closeConns(list)
+	}
+}
+
+//BindClient swaps the pooled conn for a conn that tells the backend the client address by
+//PROXY protocol v2, so backend audit logs record the client instead of the proxy. Conns
+//dialed for a client are reused only by the same client ip.
+func (p *BackendConn) BindClient(client *net.TCPAddr) error {
+	if p.db.Self || client == nil || (p.Conn != nil && p.Conn.clientIP == client.IP.String()) {
+		return nil
+	}
+	co, err := p.db.popClientConn(client)
+	if err != nil {
+		return err
+	}
+	if p.Conn != nil {
+		p.db.PushConn(p.Conn, nil)
+	}
+	p.Conn = co
+	return nil
+}
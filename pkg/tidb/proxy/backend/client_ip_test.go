@@ -0,0 +1,86 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyHeaderV2(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 40000}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 4000}
+	want := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21, 0x11, 0, 12,
+		10, 0, 0, 1, 10, 0, 0, 2, 0x9c, 0x40, 0x0f, 0xa0)
+	if h := proxyHeaderV2(src, dst); !bytes.Equal(h, want) {
+		t.Fatalf("ipv4 header %x, want %x", h, want)
+	}
+
+	//the ipv4 side is mapped to ipv6
+	src = &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}
+	want = append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21, 0x21, 0, 36,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 2,
+		0x9c, 0x40, 0x0f, 0xa0)
+	if h := proxyHeaderV2(src, dst); !bytes.Equal(h, want) {
+		t.Fatalf("ipv6 header %x, want %x", h, want)
+	}
+}
+
+func TestClientIdleConns(t *testing.T) {
+	db := &DB{maxConnNum: 8, cacheConns: make(chan *Conn, 1)}
+	for i := 0; i < maxClientIdleConns+1; i++ {
+		db.pushClientConn(&Conn{clientIP: "10.0.0.1"}, nil)
+	}
+	if db.clientIdle != maxClientIdleConns {
+		t.Fatalf("%d idle conns kept for one client ip, want %d", db.clientIdle, maxClientIdleConns)
+	}
+	for i := 0; i < maxClientIdleConns; i++ {
+		if db.takeClientConn("10.0.0.1") == nil {
+			t.Fatalf("idle conn %d not taken", i)
+		}
+	}
+	if len(db.clientConns) != 0 || db.clientIdle != 0 {
+		t.Fatalf("client ip left without idle conns: %v, %d idle", db.clientConns, db.clientIdle)
+	}
+
+	//idle conns for clients count against the max conns of the backend with the conns in use
+	db.usingConnsCount = 7
+	db.pushClientConn(&Conn{clientIP: "10.0.0.2"}, nil)
+	db.pushClientConn(&Conn{clientIP: "10.0.0.3"}, nil)
+	if db.clientIdle != 1 {
+		t.Fatalf("%d idle conns kept with 7 of 8 conns in use, want 1", db.clientIdle)
+	}
+
+	//conns idle for too long are closed
+	db.clientConns["10.0.0.2"][0].pushTimestamp = time.Now().Unix() - clientIdleTimeout - 1
+	db.usingConnsCount = 0
+	db.pushClientConn(&Conn{clientIP: "10.0.0.3"}, nil)
+	if _, ok := db.clientConns["10.0.0.2"]; ok || db.clientIdle != 1 {
+		t.Fatalf("expired idle conn kept: %v, %d idle", db.clientConns, db.clientIdle)
+	}
+
+	//many client ips share the idle conns of the backend
+	db.maxConnNum = DefaultMaxConnNum
+	for i := 0; i < 2*maxClientIdleTotal; i++ {
+		db.pushClientConn(&Conn{clientIP: fmt.Sprintf("10.1.%d.%d", i/256, i%256)}, nil)
+	}
+	if db.clientIdle != maxClientIdleTotal {
+		t.Fatalf("%d idle conns kept for all client ips, want %d", db.clientIdle, maxClientIdleTotal)
+	}
+}
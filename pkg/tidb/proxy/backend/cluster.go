@@ -18,7 +18,6 @@ import (
 	"fmt"
 	"github.com/pingcap/tidb/metrics"
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"math"
 	"strconv"
 	"strings"
@@ -29,6 +28,7 @@ import (
 	"github.com/pingcap/tidb/proxy/config"
 	"github.com/pingcap/tidb/proxy/core/errors"
 	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/events"
 	"github.com/pingcap/tidb/proxy/util"
 )
 
@@ -40,10 +40,19 @@ const (
 
 	TiDBForTP          = "tp"
 	TiDBForAP          = "ap"
+	ReplicaReadLeader   = "leader"
+	ReplicaReadFollower = "follower"
+	NoPriority          = "NO_PRIORITY"
 	WeightPerHalfProxy = 1
 	DefaultProxySize = 4.0
 	LastCost = 0
 	CurCost = 1
+
+	//seconds to wait for rolled back connections to be released after the drain timeout
+	drainRollbackWait = 10
+	//tries and wait time when all backends of a pool reach the concurrency cap
+	concurrencyRetry = 20
+	concurrencyWait  = 20 * time.Millisecond
 )
 
 type Cluster struct {
@@ -51,9 +60,23 @@ type Cluster struct {
 	BackendPools     map[string]*Pool
 	ProxyNode        *Proxy
 	DownAfterNoAlive time.Duration
+	//max running statements per backend cpu, 0 means no limit
+	ConcurrencyPerCore int
+	//ap statements over the capacity of the ap pool wait here, nil if disabled
+	APQueue *FairQueue
+	//window to ramp routing weight of a new tidb
+	WarmupWindow time.Duration
+	//interval to refresh tidb weights from pod resources
+	RebalanceInterval time.Duration
+	//time a removed tidb waits for its connections before ForceRollback is called
+	DrainTimeout time.Duration
+	//rolls back the transactions open on the tidb and returns how many there were
+	ForceRollback func(addr string) int
 
 	Online        bool
 	MaxCostPerSql int64
+	//set once the backends are discovered, statements fail with ErrClusterInitializing before
+	initialized int32
 }
 
 type Pool struct {
@@ -61,17 +84,88 @@ type Pool struct {
 	sync.RWMutex
 	CurVersion uint64
 	Tidbs         []*DB
-	LastTidbIndex int
 	RoundRobinQ   []int
 	TidbsWeights  []float64
+	//routing copy of the backends, a *poolView published by InitBalancer
+	current   atomic.Value
+	lastIndex uint64
 
 	Costs int64
 	TotalCost [2]uint64
+
+	//statements waiting for a backend of the pool, and the longest wait in nanoseconds
+	//since it is last taken
+	Queued  int64
+	maxWait int64
+
+	//no new statements are routed to a cordoned pool, set to 1 when cordoned
+	cordoned int32
+
+	//zone of the proxy and the locality mode, set before statements are routed
+	zone     string
+	locality string
+}
+
+func (pool *Pool) observeWait(wait time.Duration) {
+	for {
+		old := atomic.LoadInt64(&pool.maxWait)
+		if int64(wait) <= old || atomic.CompareAndSwapInt64(&pool.maxWait, old, int64(wait)) {
+			return
+		}
+	}
+}
+
+//TakeMaxWait returns the longest wait for a backend since the last call.
+func (pool *Pool) TakeMaxWait() time.Duration {
+	return time.Duration(atomic.SwapInt64(&pool.maxWait, 0))
+}
+
+//MaxConcurrent returns the max running statements of the up backends, 0 means no limit.
+func (pool *Pool) MaxConcurrent(perCore int) int64 {
+	var max int64
+	for _, db := range pool.view().tidbs {
+		if db.Self || atomic.LoadInt32(&db.state) != Up {
+			continue
+		}
+		max += db.MaxConcurrent(perCore)
+	}
+	return max
+}
+
+//WaitAPTurn waits for the turn of the user in the ap queue, the wait counts as queued
+//for the ap pool so it scales out.
+func (cluster *Cluster) WaitAPTurn(user string, timeout time.Duration) (func(), error) {
+	pool := cluster.BackendPools[TiDBForAP]
+	if pool == nil {
+		return cluster.APQueue.Acquire(user, timeout)
+	}
+	start := time.Now()
+	atomic.AddInt64(&pool.Queued, 1)
+	release, err := cluster.APQueue.Acquire(user, timeout)
+	atomic.AddInt64(&pool.Queued, -1)
+	pool.observeWait(time.Since(start))
+	if err != nil {
+		return nil, errors.NewPoolError(TiDBForAP, err)
+	}
+	return release, nil
 }
 
 type Proxy struct {
 	ProxyAsCompute bool
 	ProxyCost      int64
+	//the proxy never serves the cluster as a compute node, as for tenant clusters
+	Remote bool
+	//cost of running statements by class and origin
+	Costs CostStats
+}
+
+//Initialized reports whether the backends of the cluster have been discovered.
+func (cluster *Cluster) Initialized() bool {
+	return atomic.LoadInt32(&cluster.initialized) == 1
+}
+
+func (cluster *Cluster) SetInitialized() {
+	atomic.StoreInt32(&cluster.initialized, 1)
 }
 
 func (cluster *Cluster) CheckCluster() {
@@ -85,69 +179,96 @@ func (cluster *Cluster) CheckCluster() {
 }
 
 func (cluster *Cluster)getConn(ty string,cost int64,bindFlag bool) (*BackendConn, error) {
+	if !cluster.Initialized() {
+		return nil, errors.ErrClusterInitializing
+	}
+	ty, err := cluster.routableType(ty)
+	if err != nil {
+		return nil, errors.NewPoolError(ty, err)
+	}
 	pool := cluster.BackendPools[ty]
+	if pool == nil {
+		return nil, errors.NewPoolError(ty, errors.ErrNoTidbDB)
+	}
 	if ty == TiDBForAP {
 		bindFlag = false
 	}
 	var i int
 	indicate := "qps"
 	var db *DB
-	var err error
-	for ;i<30;i++ {
-		pool.Lock()
-		//if cluster.ProxyNode.IsPureCompute && len(pool.Tidbs) == 1 {
-		if len(pool.Tidbs) == 1 {
-			db = pool.Tidbs[0]
-		} else {
-			db, err = pool.GetNextDB(indicate)
-			if err != nil {
-				pool.Unlock()
-				return nil, err
-			}
+	var tidbNum int
+	start := time.Now()
+	var queued bool
+	enqueue := func() {
+		if !queued {
+			queued = true
+			atomic.AddInt64(&pool.Queued, 1)
 		}
-		pool.Unlock()
+	}
+	defer func() {
+		if queued {
+			atomic.AddInt64(&pool.Queued, -1)
+		}
+		pool.observeWait(time.Since(start))
+	}()
+	for ;i<30;i++ {
+		db, tidbNum, err = pool.pick(indicate)
 		if err != nil {
-			return nil, err
+			return nil, errors.NewPoolError(ty, err)
 		}
 		if db == nil {
-			return nil, errors.ErrNoTidbDB
+			return nil, errors.NewPoolError(ty, errors.ErrNoTidbDB)
 		}
-		if atomic.LoadInt32(&(db.state)) == Down {
-			return nil, errors.ErrTidbDown
+		if atomic.LoadInt32(&(db.state)) == Down || db.injectedDown() {
+			return nil, errors.NewPoolError(ty, errors.ErrTidbDown)
+		}
+		if !db.Self && i < concurrencyRetry && db.IsOverloaded(cluster.ConcurrencyPerCore) {
+			//spill to the next backend, queue a moment after every backend is tried
+			metrics.ProxyBackendThrottledCounter.WithLabelValues(db.addr).Inc()
+			enqueue()
+			if (i+1)%tidbNum == 0 {
+				time.Sleep(concurrencyWait)
+			}
+			continue
 		}
 		if db.Self {
 			atomic.AddInt64(&cluster.ProxyNode.ProxyCost, cost)
+			cluster.ProxyNode.Costs.Add(cost, OriginProxy)
 			//atomic.AddUint64(&pool.TotalCost[CurCost],uint64(cost))
 			return &BackendConn{db: db,bindConn: bindFlag}, nil
 		} else {
 			var backCon *BackendConn
 			backCon, err = db.GetConn(bindFlag)
 			if err != nil && err.Error() == errors.ErrGetConnTimeout.Error() {
+				enqueue()
 				continue
 			} else {
-				atomic.AddInt64(&pool.Costs, cost)
-				//fmt.Println("total cost is ", pool.Costs, ty)
-				atomic.AddUint64(&pool.TotalCost[CurCost],uint64(cost))
+				if err == nil {
+					pool.AddCost(db, cost)
+					cluster.ProxyNode.Costs.Add(cost, OriginForward)
+				}
 				return backCon, err
 			}
 		}
 	}
-	return nil,fmt.Errorf(ty + " get Connection Timeout")
+	return nil, errors.NewPoolError(ty, errors.ErrGetConnTimeout)
 }
 
 func (cluster *Cluster) GetTidbConn(cost int64,bindFlag bool) (*BackendConn, error) {
-
+	if !cluster.Initialized() {
+		return nil, errors.ErrClusterInitializing
+	}
 
 	//db, err := cluster.GetNextTidb(indicate, cost,bindFlag)
 	//Distinguish SQL types based on costs
 	var db *DB
 	switch {
-	case cost <= 10000:
+	case cost <= TPMaxCost:
 		//Predicate SQL is belong to TP type
 		metrics.QueriesCounter.WithLabelValues(TiDBForTP).Inc()
 		return cluster.getConn(TiDBForTP, cost, bindFlag)
 
-	case cost > 8000000000:
+	case cost > BigCostMinCost:
 		//Predicate SQL is belong to Big AP type
 		//invoke grpc api of starting a new pod to handle this request.
 		var tempSize float32
@@ -174,6 +295,7 @@ func (cluster *Cluster) GetTidbConn(cost int64,bindFlag bool) (*BackendConn, err
 			fmt.Println("err is ", err)
 			return nil, errors.ErrConnIsNil
 		}
+		cluster.ProxyNode.Costs.Add(cost, OriginForward)
 		return &BackendConn{Conn: conn, db: db}, nil
 
 	default:
@@ -183,6 +305,64 @@ func (cluster *Cluster) GetTidbConn(cost int64,bindFlag bool) (*BackendConn, err
 	}
 }
 
+//GetTidbConnByType gets conn from the given pool without cost classification.
+func (cluster *Cluster) GetTidbConnByType(ty string, cost int64, bindFlag bool) (*BackendConn, error) {
+	if _, ok := cluster.BackendPools[ty]; !ok {
+		return nil, errors.ErrNoTidbDB
+	}
+	metrics.QueriesCounter.WithLabelValues(ty).Inc()
+	return cluster.getConn(ty, cost, bindFlag)
+}
+
+//GetTidbConnByPod gets conn from the backend of the pod, or of the addr for backends
+//outside kubernetes, whatever its load is.
+func (cluster *Cluster) GetTidbConnByPod(name string, cost int64, bindFlag bool) (*BackendConn, error) {
+	if !cluster.Initialized() {
+		return nil, errors.ErrClusterInitializing
+	}
+	ty, pool, db := cluster.dbOfPod(name)
+	if db == nil {
+		return nil, errors.ErrTidbNotExist
+	}
+	if atomic.LoadInt32(&(db.state)) == Down || db.injectedDown() {
+		return nil, errors.NewPoolError(ty, errors.ErrTidbDown)
+	}
+	if ty == TiDBForAP {
+		bindFlag = false
+	}
+	metrics.QueriesCounter.WithLabelValues(ty).Inc()
+	backCon, err := db.GetConn(bindFlag)
+	if err != nil {
+		return nil, errors.NewPoolError(ty, err)
+	}
+	pool.AddCost(db, cost)
+	cluster.ProxyNode.Costs.Add(cost, OriginForward)
+	return backCon, nil
+}
+
+//dbOfPod finds the backend by its pod name or its addr.
+func (cluster *Cluster) dbOfPod(name string) (string, *Pool, *DB) {
+	for _, ty := range []string{TiDBForTP, TiDBForAP} {
+		pool, ok := cluster.BackendPools[ty]
+		if !ok {
+			continue
+		}
+		pool.RLock()
+		for _, db := range pool.Tidbs {
+			if db.Self {
+				continue
+			}
+			podName, _, _ := podOfAddr(db.addr)
+			if podName == name || strings.Split(db.addr, WeightSplit)[0] == name {
+				pool.RUnlock()
+				return ty, pool, db
+			}
+		}
+		pool.RUnlock()
+	}
+	return "", nil, nil
+}
+
 func (cluster *Cluster) checkTidbs() {
 	return
 	if cluster.BackendPools == nil {
@@ -227,101 +407,323 @@ func (cluster *Cluster) checkTidbs() {
 	}
 }
 
-func GetOnePod(podName, namespace string) *v1.Pod {
-	pod, err := util.KubeClient.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{})
-	if err != nil {
+//podOfAddr returns pod name and namespace of the tidb address, like pod.tc-tidb-peer.ns:4000.
+func podOfAddr(addr string) (string, string, bool) {
+	podArr := strings.Split(strings.Split(addr, WeightSplit)[0], ".")
+	if len(podArr) < 3 {
+		return "", "", false
+	}
+	return podArr[0], strings.Split(podArr[2], ":")[0], true
+}
+
+//podCpu returns cpu request of the tidb container, or its limit if no request is set.
+func podCpu(pod *v1.Pod, container string) float64 {
+	for _, c := range pod.Spec.Containers {
+		if c.Name != container {
+			continue
+		}
+		if cpu := c.Resources.Requests.Cpu(); !cpu.IsZero() {
+			return float64(cpu.MilliValue()) / 1000
+		}
+		return float64(c.Resources.Limits.Cpu().MilliValue()) / 1000
+	}
+	return 0
+}
+
+//RebalanceWeights re-reads cpu of backend pods, and rebuilds balancer of pools whose weights changed.
+func (cluster *Cluster) RebalanceWeights() bool {
+	var changed bool
+	labels := cluster.Cfg.PodLabels()
+	for tidbType, pool := range cluster.BackendPools {
+		pool.RLock()
+		addrs := make([]string, 0, len(pool.Tidbs))
+		for _, db := range pool.Tidbs {
+			if !db.Self {
+				addrs = append(addrs, db.addr)
+			}
+		}
+		pool.RUnlock()
+
+		//read pods without holding pool lock
+		weights := make(map[string]float64, len(addrs))
+		zones := make(map[string]string, len(addrs))
+		for _, addr := range addrs {
+			podName, ns, ok := podOfAddr(addr)
+			if !ok {
+				continue
+			}
+			//an unreadable pod keeps its weight and zone
+			pod := GetOnePod(podName, ns, labels)
+			if pod == nil {
+				continue
+			}
+			if cpu := podCpu(pod, labels.Container); cpu > 0 {
+				weights[addr] = cpu
+			}
+			//a pod recreated under the same name may be on a node of another zone
+			zones[addr] = NodeZone(pod.Spec.NodeName, cluster.Cfg.ZoneLabel())
+		}
+
+		pool.Lock()
+		var poolChanged bool
+		for i, db := range pool.Tidbs {
+			if zone, ok := zones[db.addr]; ok {
+				db.SetZone(zone)
+			}
+			weight, ok := weights[db.addr]
+			if !ok || i >= len(pool.TidbsWeights) || pool.TidbsWeights[i] == weight {
+				continue
+			}
+			golog.Info("Cluster", "RebalanceWeights", "tidb weight changed", 0,
+				"tidbtype", tidbType, "addr", db.addr, "old", pool.TidbsWeights[i], "new", weight)
+			pool.TidbsWeights[i] = weight
+			db.weight = weight
+			poolChanged = true
+		}
+		if poolChanged {
+			pool.InitBalancer()
+			changed = true
+		}
+		pool.Unlock()
+	}
+	return changed
+}
+
+//CheckWeights refreshes tidb weights from pod resources periodically.
+func (cluster *Cluster) CheckWeights() {
+	if cluster.RebalanceInterval <= 0 {
+		return
+	}
+	for cluster.Online {
+		time.Sleep(cluster.RebalanceInterval)
+		cluster.RebalanceWeights()
+	}
+}
+
+//GetOnePod returns the pod, nil if it can't be read, is absent or is about to be deleted,
+//see LookupPod to tell them apart.
+func GetOnePod(podName, namespace string, labels *config.PodLabelConfig) *v1.Pod {
+	if pod, state := LookupPod(podName, namespace, labels); state == PodFound {
+		return pod
+	}
+	return nil
+}
+
+//majorityVersion returns the most common major.minor version of the pool, caller holds the pool lock.
+func (pool *Pool) majorityVersion() (string, int) {
+	counts := make(map[string]int)
+	var majority string
+	for _, db := range pool.Tidbs {
+		if db.Self || db.version == "" {
+			continue
+		}
+		v := VersionMajorMinor(db.version)
+		counts[v]++
+		if counts[v] > counts[majority] {
+			majority = v
+		}
+	}
+	return majority, counts[majority]
+}
+
+//checkVersion warns when a new tidb differs from the pool majority in major.minor version,
+//and refuses it if reject is set, so partial upgrades don't leave a silently mixed pool.
+func (pool *Pool) checkVersion(db *DB, reject bool) error {
+	majority, count := pool.majorityVersion()
+	if count == 0 || db.version == "" || VersionMajorMinor(db.version) == majority {
 		return nil
 	}
-	if v, ok := pod.Labels["predelete"]; ok {
-		if v == "true" {
-			return nil
+	golog.Warn("Cluster", "checkVersion", "tidb version differs from pool", 0,
+		"addr", db.addr,
+		"version", db.version,
+		"pool_version", majority,
+		"reject", reject)
+	if reject {
+		return errors.ErrVersionSkew
+	}
+	return nil
+}
+
+//newTidb is a tidb of AddTidb that passed the checks made before the pool is locked.
+type newTidb struct {
+	tidb   *server.NewTidb
+	addr   string
+	weight float64
+	zone   string
+	db     *DB
+}
+
+//hasTidb reports whether the tidb is in the pool, the pool is locked by the caller.
+func (pool *Pool) hasTidb(addr string) bool {
+	for _, v := range pool.Tidbs {
+		if strings.Split(v.addr, WeightSplit)[0] == addr {
+			return true
 		}
 	}
-	return pod
+	return false
 }
 
+//prepareTidb looks up the pod of the tidb, then preflights, opens and warms it. The pool is
+//not locked, these take the network round trips of the tidb. A tidb whose pod is absent is
+//pending and returns a nil tidb without error.
+func (cluster *Cluster) prepareTidb(tidb *server.NewTidb) (*newTidb, error) {
+	addrAndWeight := strings.Split(tidb.Addr, WeightSplit)
+	t := &newTidb{tidb: tidb, addr: addrAndWeight[0], weight: 1}
+	if len(addrAndWeight) == 2 {
+		weight, err := strconv.ParseFloat(addrAndWeight[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		t.weight = weight
+	}
+	if t.addr == "self" {
+		t.db = &DB{addr: t.addr, Self: true}
+		return t, nil
+	}
+	//check pod status,predelete filter
+	if cluster.Cfg.DiscoveryType() == config.DiscoveryK8s {
+		podArr := strings.Split(tidb.Addr, ".")
+		podName := podArr[0]
+		podNs := podArr[2]
+		nsArr := strings.Split(podNs, ":")
+		ns := nsArr[0]
+		pod, state := LookupPod(podName, ns, cluster.Cfg.PodLabels())
+		switch state {
+		case PodAbsent:
+			golog.Warn("Cluster", "AddTidb", "pod unavailable, add it later", 0,
+				"tidb.Addr", tidb.Addr)
+			return nil, nil
+		case PodUnknown:
+			//an api server hiccup must not shrink the pool, the health checks judge the tidb
+			golog.Warn("Cluster", "AddTidb", "pod state unknown, add it anyway", 0,
+				"tidb.Addr", tidb.Addr)
+		default:
+			t.zone = NodeZone(pod.Spec.NodeName, cluster.Cfg.ZoneLabel())
+		}
+	}
+	if err := cluster.preflight(t.addr, tidb.TidbType); err != nil {
+		return nil, err
+	}
+	db, err := cluster.OpenDB(t.addr, t.weight)
+	if err != nil {
+		return nil, err
+	}
+	cluster.warmStats(t.addr, tidb.TidbType)
+	t.db = db
+	return t, nil
+}
+
+//AddTidb adds the tidbs to their pool. The tidbs are checked before the pool is locked, a
+//tidb failing its checks is logged and left out, the others are still added.
 func (cluster *Cluster) AddTidb(allNewTidb []*server.NewTidb) error {
-	var db *DB
-	var weight float64
-	var err error
 	pool := cluster.BackendPools[allNewTidb[0].TidbType]
-	pool.Lock()
-	defer pool.Unlock()
 	var needAdd []*server.NewTidb
-	for _, j :=range allNewTidb {
-		flag := true
-		for _, v := range pool.Tidbs {
-			if strings.Split(v.addr, WeightSplit)[0] == strings.Split(j.Addr, WeightSplit)[0] || len(j.Addr) == 0{
-				golog.Error("Cluster", "AddTidb", "exsit tidb or addressNull", 0,
-					"tidb.Addr", j.Addr)
-				flag = false
-				break
-			}
-		}
-		if flag == true {
-			needAdd = append(needAdd,j)
+	pool.RLock()
+	for _, j := range allNewTidb {
+		if len(j.Addr) == 0 || pool.hasTidb(strings.Split(j.Addr, WeightSplit)[0]) {
+			golog.Error("Cluster", "AddTidb", "exsit tidb or addressNull", 0,
+				"tidb.Addr", j.Addr)
+			continue
 		}
+		needAdd = append(needAdd, j)
 	}
+	pool.RUnlock()
 
 	if len(needAdd) == 0 {
 		return errors.ErrTidbExist
 	}
 
-	for _,tidb := range needAdd {
-		//lock check pod status,predelete filter
-		if strings.Split(tidb.Addr, WeightSplit)[0] != "self" {
-			podArr := strings.Split(tidb.Addr, ".")
-			podName := podArr[0]
-			podNs := podArr[2]
-			nsArr := strings.Split(podNs, ":")
-			ns := nsArr[0]
-			pod := GetOnePod(podName, ns)
-			if pod == nil {
-				return nil
+	//tidbs whose pods are absent or about to be deleted, they are retried later
+	var pending []string
+	var prepared []*newTidb
+	var firstErr error
+	for _, tidb := range needAdd {
+		t, err := cluster.prepareTidb(tidb)
+		if err != nil {
+			golog.Warn("Cluster", "AddTidb", "tidb not added", 0,
+				"tidb.Addr", tidb.Addr, "error", err)
+			if firstErr == nil {
+				firstErr = err
 			}
+			continue
+		}
+		if t == nil {
+			pending = append(pending, strings.Split(tidb.Addr, WeightSplit)[0])
+			continue
 		}
+		prepared = append(prepared, t)
+	}
 
-		addrAndWeight := strings.Split(tidb.Addr, WeightSplit)
-		if len(addrAndWeight) == 2 {
-			weight, err = strconv.ParseFloat(addrAndWeight[1], 64)
-			if err != nil {
-				return err
+	pool.Lock()
+	defer pool.Unlock()
+	var added int
+	for _, t := range prepared {
+		db := t.db
+		if pool.hasTidb(t.addr) {
+			//added meanwhile by another call
+			if !db.Self {
+				db.Close()
 			}
-		} else {
-			weight = 1
+			continue
 		}
-		if addrAndWeight[0] == "self" {
-			db = &DB{
-				addr: addrAndWeight[0],
-				Self: true,
-			}
+		if db.Self {
 			cluster.ProxyNode.ProxyAsCompute = true
-		} else if db, err = cluster.OpenDB(addrAndWeight[0], weight); err != nil {
-			return err
+		} else if err := pool.checkVersion(db, cluster.Cfg.RejectVersionSkew); err != nil {
+			db.Close()
+			golog.Warn("Cluster", "AddTidb", "tidb not added", 0,
+				"tidb.Addr", t.tidb.Addr, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		} else if cluster.WarmupWindow > 0 {
+			db.warmupStart = time.Now()
+			db.warmupWindow = cluster.WarmupWindow
 		}
-		pool.TidbsWeights = append(pool.TidbsWeights, weight)
-		db.dbType = tidb.TidbType
+		pool.TidbsWeights = append(pool.TidbsWeights, t.weight)
+		db.dbType = t.tidb.TidbType
+		db.SetZone(t.zone)
 		pool.Tidbs = append(pool.Tidbs, db)
-		if tidb.TidbType == TiDBForTP && cluster.ProxyNode.ProxyAsCompute && addrAndWeight[0] != "self" {
-			if pool.RebalanceWeight(math.Ceil(weight / WeightPerHalfProxy)) {
+		added++
+		events.Default().Publish(events.Event{Type: events.BackendAdded, Cluster: cluster.Cfg.ClusterName,
+			Pool: t.tidb.TidbType, Addr: db.addr, Detail: map[string]interface{}{"weight": t.weight}})
+		if t.tidb.TidbType == TiDBForTP && cluster.ProxyNode.ProxyAsCompute && !db.Self {
+			if pool.RebalanceWeight(math.Ceil(t.weight / WeightPerHalfProxy)) {
 				cluster.ProxyNode.ProxyAsCompute = false
 			}
 		}
 	}
+	if added == 0 {
+		if firstErr != nil {
+			return firstErr
+		}
+		if len(pending) > 0 {
+			return errors.NewRetriableError(pending, errors.ErrPodUnavailable)
+		}
+		return errors.ErrTidbExist
+	}
 	for i:=0;i<len(pool.Tidbs);i++ {
 		fmt.Println("=======db weight db self=======",pool.Tidbs[i].addr,pool.Tidbs[i].Self,pool.Tidbs[i].state)
 	}
 	pool.InitBalancer()
 	pool.CurVersion++
+	if len(pending) > 0 {
+		return errors.NewRetriableError(pending, errors.ErrPodUnavailable)
+	}
 	return nil
 }
 
 func (cluster *Cluster) DeleteTidb(addr string, tidbType string) error {
 	//pool := cluster.BackendPools[tidbType]
+	if cluster.vetoRemoval(addr) {
+		return errors.ErrLongTxnsOnTidb
+	}
 	he3db, err := cluster.InitBalancerAfterDeleteTidb(addr, tidbType)
 	if err != nil {
 		return err
 	} else {
+		events.Default().Publish(events.Event{Type: events.BackendRemoved, Cluster: cluster.Cfg.ClusterName,
+			Pool: tidbType, Addr: addr})
 		if he3db == nil {
 			return nil
 		}
@@ -337,7 +739,22 @@ func (cluster *Cluster) DeleteTidb(addr string, tidbType string) error {
 		return false, nil
 	}
 
-	if err := util.Retry(1*time.Second, 600, CanDelete); err != nil {
+	//long transactions, e.g. batch jobs holding pessimistic locks, are waited for before the
+	//drain, so the drain timeout doesn't roll them back
+	cluster.delayRemoval(he3db.addr)
+	tries := 600
+	if cluster.DrainTimeout > 0 && cluster.ForceRollback != nil {
+		tries = int(cluster.DrainTimeout / time.Second)
+	}
+	err = util.Retry(1*time.Second, tries, CanDelete)
+	if err != nil && cluster.DrainTimeout > 0 && cluster.ForceRollback != nil {
+		golog.Warn("Cluster", "DeleteTidb", "drain timeout, roll back open transactions", 0,
+			"addr", he3db.addr, "current conn num", he3db.usingConnsCount)
+		if cluster.ForceRollback(he3db.addr) > 0 {
+			err = util.Retry(1*time.Second, drainRollbackWait, CanDelete)
+		}
+	}
+	if err != nil {
 
 		golog.Warn("Cluster", "DeleteTidb", "usingconn been killed", 0, "current conn num", he3db.usingConnsCount)
 	}
@@ -367,10 +784,14 @@ func (cluster *Cluster) InitBalancerAfterDeleteTidb(addr, tidbType string) (*DB,
 	if i == TidbCount {
 		return nil, errors.ErrTidbNotExist
 	}
+	if err := cluster.checkRemoval(pool, addr, tidbType); err != nil {
+		return nil, err
+	}
 	if TidbCount == 1 {
 		pool.Tidbs = nil
 		pool.TidbsWeights = nil
 		pool.RoundRobinQ = nil
+		pool.publish()
 		return nil, nil
 	}
 
@@ -396,7 +817,7 @@ func (cluster *Cluster) InitBalancerAfterDeleteTidb(addr, tidbType string) (*DB,
 	}
 
 	threshold := DefaultProxySize * 2 / WeightPerHalfProxy
-	if tidbType == TiDBForTP && !cluster.ProxyNode.ProxyAsCompute && sum < threshold {
+	if tidbType == TiDBForTP && !cluster.ProxyNode.ProxyAsCompute && !cluster.ProxyNode.Remote && sum < threshold {
 		db := &DB{
 			addr: "self",
 			Self: true,
@@ -415,6 +836,9 @@ func (cluster *Cluster) InitBalancerAfterDeleteTidb(addr, tidbType string) (*DB,
 
 func (cluster *Cluster) OpenDB(addr string, weight float64) (*DB, error) {
 	db, err := Open(addr, cluster.Cfg.User, cluster.Cfg.Password, "", weight)
+	if err == nil {
+		db.Warmup(cluster.Cfg.WarmupSQL)
+	}
 	return db, err
 }
 
@@ -452,11 +876,16 @@ func (cluster *Pool) UpTidb(addr, user, passwd string) error {
 	for k, Tidb := range cluster.Tidbs {
 		if Tidb.addr == addr {
 			cluster.Tidbs[k] = db
+			cluster.publish()
 			cluster.Unlock()
+			if db != nil {
+				events.Default().Publish(events.Event{Type: events.BackendUp, Pool: Tidb.dbType, Addr: addr})
+			}
 			return nil
 		}
 	}
 	cluster.Tidbs = append(cluster.Tidbs, db)
+	cluster.publish()
 	cluster.Unlock()
 
 	return err
@@ -477,6 +906,8 @@ func (cluster *Pool) DownTidb(addr string, state int32) error {
 		if Tidb.addr == addr {
 			Tidb.Close()
 			atomic.StoreInt32(&(Tidb.state), state)
+			events.Default().Publish(events.Event{Type: events.BackendDown, Pool: Tidb.dbType, Addr: addr,
+				Detail: map[string]interface{}{"manual": state == ManualDown}})
 			break
 		}
 	}
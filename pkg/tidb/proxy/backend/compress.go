@@ -0,0 +1,84 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+var (
+	//compression asked of the backends, empty means none
+	compressionAlgorithm string
+	compressionLevel     int
+)
+
+//SetCompression sets the compression of new backend connections, it is only used when
+//the backend advertises the algorithm.
+func SetCompression(algorithm string, level int) {
+	compressionAlgorithm = algorithm
+	compressionLevel = level
+}
+
+//compressionCapability returns the capability flag to ask for, 0 if the backend
+//doesn't support the configured algorithm.
+func compressionCapability(serverCapability uint32) uint32 {
+	var flag uint32
+	switch compressionAlgorithm {
+	case mysql.CompressionZlib:
+		flag = mysql.CLIENT_COMPRESS
+	case mysql.CompressionZstd:
+		flag = mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM
+	}
+	return flag & serverCapability
+}
+
+//CompressionObserver counts the bytes of the compressed frames of a connection to a
+//client or a backend.
+func CompressionObserver(side, algorithm string) func(out bool, raw, wire int) {
+	rawIn := metrics.ProxyCompressionBytesCounter.WithLabelValues(side, algorithm, "in", "raw")
+	wireIn := metrics.ProxyCompressionBytesCounter.WithLabelValues(side, algorithm, "in", "wire")
+	rawOut := metrics.ProxyCompressionBytesCounter.WithLabelValues(side, algorithm, "out", "raw")
+	wireOut := metrics.ProxyCompressionBytesCounter.WithLabelValues(side, algorithm, "out", "wire")
+	return func(out bool, raw, wire int) {
+		if out {
+			rawOut.Add(float64(raw))
+			wireOut.Add(float64(wire))
+		} else {
+			rawIn.Add(float64(raw))
+			wireIn.Add(float64(wire))
+		}
+	}
+}
+
+//enableCompression switches the conn to compressed frames after the handshake asked
+//the backend for it.
+func (c *Conn) enableCompression() error {
+	var algorithm string
+	switch {
+	case c.capability&mysql.CLIENT_COMPRESS > 0:
+		algorithm = mysql.CompressionZlib
+	case c.capability&mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM > 0:
+		algorithm = mysql.CompressionZstd
+	default:
+		return nil
+	}
+	cc, err := c.pkg.EnableCompression(algorithm, compressionLevel)
+	if err != nil {
+		return err
+	}
+	cc.Observe = CompressionObserver("backend", algorithm)
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"net"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+//tcp settings of new backend connections
+var (
+	dialTimeout  = config.DefaultBackendDialTimeout * time.Second
+	keepAlive    time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+)
+
+//SetConnTimeouts sets the dial timeout, the keepalive and the read and write deadlines of
+//the backend connections, it is called before the pools are opened.
+func SetConnTimeouts(cfg config.BackendConnConfig) {
+	dialTimeout = time.Duration(cfg.DialTimeout) * time.Second
+	if cfg.DialTimeout <= 0 {
+		dialTimeout = config.DefaultBackendDialTimeout * time.Second
+	}
+	keepAlive = time.Duration(cfg.KeepAlive) * time.Second
+	readTimeout = time.Duration(cfg.ReadTimeout) * time.Second
+	writeTimeout = time.Duration(cfg.WriteTimeout) * time.Second
+}
+
+func dial(network, addr string) (net.Conn, error) {
+	//keepalive is set by setKeepAlive once the conn is up
+	d := net.Dialer{Timeout: dialTimeout, KeepAlive: -1}
+	return d.Dial(network, addr)
+}
+
+func setKeepAlive(conn *net.TCPConn) {
+	if keepAlive < 0 {
+		conn.SetKeepAlive(false)
+		return
+	}
+	conn.SetKeepAlive(true)
+	if keepAlive > 0 {
+		conn.SetKeepAlivePeriod(keepAlive)
+	}
+}
+
+//ioDeadline returns the deadline of the next packet by the timeout, capped by the deadline
+//of the statements of the conn, zero for none.
+func (c *Conn) ioDeadline(timeout time.Duration) time.Time {
+	var d time.Time
+	if timeout > 0 {
+		d = time.Now().Add(timeout)
+	}
+	if !c.deadline.IsZero() && (d.IsZero() || c.deadline.Before(d)) {
+		d = c.deadline
+	}
+	return d
+}
+
+//setReadDeadline arms the deadline of the next packet read, none is set without a read timeout
+//or a deadline of the conn.
+func (c *Conn) setReadDeadline() {
+	if readTimeout > 0 || !c.deadline.IsZero() {
+		c.conn.SetReadDeadline(c.ioDeadline(readTimeout))
+	}
+}
+
+func (c *Conn) setWriteDeadline() {
+	if writeTimeout > 0 || !c.deadline.IsZero() {
+		c.conn.SetWriteDeadline(c.ioDeadline(writeTimeout))
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func TestReadTimeout(t *testing.T) {
+	defer SetConnTimeouts(config.BackendConnConfig{})
+	SetConnTimeouts(config.BackendConnConfig{ReadTimeout: 1})
+	if dialTimeout != config.DefaultBackendDialTimeout*time.Second {
+		t.Fatalf("dial timeout %s, want the default", dialTimeout)
+	}
+
+	//a tidb accepting the conn and never sending its handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			time.Sleep(5 * time.Second)
+			conn.Close()
+		}
+	}()
+
+	start := time.Now()
+	err = new(Conn).Connect(l.Addr().String(), "root", "", "")
+	//the packet io reports a bad conn for a deadline too
+	if err == nil {
+		t.Fatal("stalled handshake connected")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("read deadline not applied, connect took %s", elapsed)
+	}
+}
+
+func TestConnDeadline(t *testing.T) {
+	c := new(Conn)
+	if !c.ioDeadline(0).IsZero() {
+		t.Fatal("deadline set without a timeout")
+	}
+	c.deadline = time.Now().Add(time.Second)
+	if d := c.ioDeadline(0); !d.Equal(c.deadline) {
+		t.Fatalf("deadline %s, want the one of the conn", d)
+	}
+	if d := c.ioDeadline(time.Minute); !d.Equal(c.deadline) {
+		t.Fatalf("deadline %s past the one of the conn", d)
+	}
+	c.deadline = time.Now().Add(time.Hour)
+	if d := c.ioDeadline(time.Minute); !d.Before(c.deadline) {
+		t.Fatalf("deadline %s, want the one of the timeout", d)
+	}
+}
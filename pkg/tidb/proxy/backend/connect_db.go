@@ -0,0 +1,100 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+func databaseExistsQuery(db string) string {
+	return fmt.Sprintf("SELECT SCHEMA_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = '%s'", mysql.Escape(db))
+}
+
+//DatabaseExists reports whether the database exists on the tidb.
+func DatabaseExists(addr, user, password, db string) (bool, error) {
+	co := new(Conn)
+	if err := co.Connect(addr, user, password, ""); err != nil {
+		return false, err
+	}
+	defer co.Close()
+	r, err := co.exec(databaseExistsQuery(db))
+	if err != nil {
+		return false, err
+	}
+	return r.RowNumber() > 0, nil
+}
+
+//CreateDatabase creates the database on the tidb unless it exists.
+func CreateDatabase(addr, user, password, db string) error {
+	co := new(Conn)
+	if err := co.Connect(addr, user, password, ""); err != nil {
+		return err
+	}
+	defer co.Close()
+	_, err := co.exec("CREATE DATABASE IF NOT EXISTS " + quoteName(db))
+	return err
+}
+
+//upAddrs returns the up backends of the tp then the ap pool, at most n.
+func (cluster *Cluster) upAddrs(n int) []string {
+	addrs := make([]string, 0, n)
+	for _, ty := range []string{TiDBForTP, TiDBForAP} {
+		pool, ok := cluster.BackendPools[ty]
+		if !ok {
+			continue
+		}
+		for _, db := range pool.Backends() {
+			if len(addrs) >= n {
+				return addrs
+			}
+			if db.Self || atomic.LoadInt32(&db.state) != Up {
+				continue
+			}
+			addrs = append(addrs, db.addr)
+		}
+	}
+	return addrs
+}
+
+//FindDatabase asks up to tries up backends for the database and returns the first one
+//having it, empty if none has. The error is the last backend failing to answer when none
+//has the database.
+func (cluster *Cluster) FindDatabase(db string, tries int) (string, error) {
+	var lastErr error
+	for _, addr := range cluster.upAddrs(tries) {
+		ok, err := DatabaseExists(addr, cluster.Cfg.User, cluster.Cfg.Password, db)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ok {
+			return addr, nil
+		}
+	}
+	return "", lastErr
+}
+
+//CreateDatabase creates the database on an up backend and returns its address.
+func (cluster *Cluster) CreateDatabase(db string) (string, error) {
+	addrs := cluster.upAddrs(1)
+	if len(addrs) == 0 {
+		return "", errors.ErrNoTidbDB
+	}
+	return addrs[0], CreateDatabase(addrs[0], cluster.Cfg.User, cluster.Cfg.Password, db)
+}
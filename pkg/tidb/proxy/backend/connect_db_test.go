@@ -0,0 +1,36 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"testing"
+)
+
+func TestConnectDB(t *testing.T) {
+	q := databaseExistsQuery("it's")
+	want := "SELECT SCHEMA_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = 'it\\'s'"
+	if q != want {
+		t.Fatalf("unexpected query %q", q)
+	}
+
+	//no up backend to ask or to create the database on
+	cluster := &Cluster{BackendPools: map[string]*Pool{}}
+	if addr, err := cluster.FindDatabase("db1", 2); addr != "" || err != nil {
+		t.Fatalf("found db1 on %q err %v without backends", addr, err)
+	}
+	if _, err := cluster.CreateDatabase("db1"); err == nil {
+		t.Fatal("db1 created without backends")
+	}
+}
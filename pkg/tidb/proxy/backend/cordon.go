@@ -0,0 +1,176 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/util"
+)
+
+//DefaultDrainTimeout is the time a drained pool waits for its connections before the open
+//transactions are rolled back.
+const DefaultDrainTimeout = 60 * time.Second
+
+//PoolState is the routing state of a pool and the backend connections still in use.
+type PoolState struct {
+	Type       string `json:"type"`
+	Cordoned   bool   `json:"cordoned"`
+	Tidbs      int    `json:"tidbs"`
+	UsingConns int64  `json:"using_conns"`
+}
+
+//Cordon stops routing new statements to the backends of the pool, the sessions bound to
+//them keep running.
+func (pool *Pool) Cordon() {
+	atomic.StoreInt32(&pool.cordoned, 1)
+}
+
+func (pool *Pool) Uncordon() {
+	atomic.StoreInt32(&pool.cordoned, 0)
+}
+
+func (pool *Pool) IsCordoned() bool {
+	return atomic.LoadInt32(&pool.cordoned) == 1
+}
+
+//UsingConns returns the backend connections of the pool held by sessions.
+func (pool *Pool) UsingConns() int64 {
+	var n int64
+	for _, db := range pool.Backends() {
+		if !db.Self {
+			n += atomic.LoadInt64(&db.usingConnsCount)
+		}
+	}
+	return n
+}
+
+func (pool *Pool) addrs() []string {
+	pool.RLock()
+	defer pool.RUnlock()
+	addrs := make([]string, 0, len(pool.Tidbs))
+	for _, db := range pool.Tidbs {
+		if !db.Self {
+			addrs = append(addrs, db.addr)
+		}
+	}
+	return addrs
+}
+
+//PoolStates returns the routing state of the tp and ap pools.
+func (cluster *Cluster) PoolStates() []PoolState {
+	var states []PoolState
+	for _, ty := range []string{TiDBForTP, TiDBForAP} {
+		pool, ok := cluster.BackendPools[ty]
+		if !ok {
+			continue
+		}
+		state := PoolState{Type: ty, Cordoned: pool.IsCordoned(), Tidbs: len(pool.Backends())}
+		state.UsingConns = pool.UsingConns()
+		states = append(states, state)
+	}
+	return states
+}
+
+func (cluster *Cluster) pool(ty string) (*Pool, error) {
+	pool, ok := cluster.BackendPools[ty]
+	if !ok || pool == nil {
+		return nil, errors.NewPoolError(ty, errors.ErrNoTidbDB)
+	}
+	return pool, nil
+}
+
+//CordonPool stops routing to the pool, its statements go to the other pool.
+func (cluster *Cluster) CordonPool(ty string) error {
+	pool, err := cluster.pool(ty)
+	if err != nil {
+		return err
+	}
+	pool.Cordon()
+	metrics.ProxyPoolCordonedGauge.WithLabelValues(ty).Set(1)
+	golog.Info("cluster", "CordonPool", "pool cordoned", 0, "type", ty)
+	return nil
+}
+
+func (cluster *Cluster) UncordonPool(ty string) error {
+	pool, err := cluster.pool(ty)
+	if err != nil {
+		return err
+	}
+	pool.Uncordon()
+	metrics.ProxyPoolCordonedGauge.WithLabelValues(ty).Set(0)
+	golog.Info("cluster", "UncordonPool", "pool uncordoned", 0, "type", ty)
+	return nil
+}
+
+//DrainPool cordons the pool and moves the sessions off its backends: sessions bound by
+//prepared statements move on their next statement, open transactions are waited for until
+//the timeout and then rolled back. It returns the backend connections still in use.
+func (cluster *Cluster) DrainPool(ty string, timeout time.Duration) (int64, error) {
+	if err := cluster.CordonPool(ty); err != nil {
+		return 0, err
+	}
+	pool := cluster.BackendPools[ty]
+	//prepared statements are bound to a backend until the pool version changes
+	pool.Lock()
+	pool.CurVersion++
+	pool.Unlock()
+
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	drained := func() (bool, error) {
+		return pool.UsingConns() == 0, nil
+	}
+	tries := int(timeout / time.Second)
+	if tries < 1 {
+		tries = 1
+	}
+	err := util.Retry(time.Second, tries, drained)
+	if err != nil && cluster.ForceRollback != nil {
+		var count int
+		for _, addr := range pool.addrs() {
+			count += cluster.ForceRollback(addr)
+		}
+		golog.Warn("cluster", "DrainPool", "drain timeout, roll back open transactions", 0,
+			"type", ty, "connections", count)
+		if count > 0 {
+			util.Retry(time.Second, drainRollbackWait, drained)
+		}
+	}
+	left := pool.UsingConns()
+	golog.Info("cluster", "DrainPool", "pool drained", 0, "type", ty, "using_conns", left)
+	return left, nil
+}
+
+//routableType returns the pool to route to, the other pool when the chosen one is cordoned.
+func (cluster *Cluster) routableType(ty string) (string, error) {
+	pool, ok := cluster.BackendPools[ty]
+	if !ok || pool == nil || !pool.IsCordoned() {
+		return ty, nil
+	}
+	other := TiDBForAP
+	if ty == TiDBForAP {
+		other = TiDBForTP
+	}
+	if pool, ok := cluster.BackendPools[other]; ok && pool != nil && !pool.IsCordoned() {
+		return other, nil
+	}
+	return ty, errors.ErrPoolCordoned
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+func TestRoutableType(t *testing.T) {
+	tp, ap := testPool(2), testPool(1)
+	cluster := &Cluster{BackendPools: map[string]*Pool{TiDBForTP: tp, TiDBForAP: ap}}
+	if err := cluster.CordonPool(TiDBForTP); err != nil {
+		t.Fatal(err)
+	}
+	if ty, err := cluster.routableType(TiDBForTP); ty != TiDBForAP || err != nil {
+		t.Fatalf("cordoned tp routed to %s %v, want ap", ty, err)
+	}
+	if ty, err := cluster.routableType(TiDBForAP); ty != TiDBForAP || err != nil {
+		t.Fatalf("ap routed to %s %v", ty, err)
+	}
+	cluster.CordonPool(TiDBForAP)
+	if _, err := cluster.routableType(TiDBForTP); err != errors.ErrPoolCordoned {
+		t.Fatalf("both pools cordoned got %v, want %v", err, errors.ErrPoolCordoned)
+	}
+	cluster.UncordonPool(TiDBForTP)
+	if ty, err := cluster.routableType(TiDBForTP); ty != TiDBForTP || err != nil {
+		t.Fatalf("uncordoned tp routed to %s %v", ty, err)
+	}
+	if err := cluster.CordonPool("unknown"); err == nil {
+		t.Fatal("expect error for cordoning an unknown pool")
+	}
+}
+
+func TestDrainPool(t *testing.T) {
+	tp := testPool(2)
+	tp.Tidbs = append(tp.Tidbs, &DB{addr: "self", Self: true, usingConnsCount: 5})
+	tp.publish()
+	atomic.StoreInt64(&tp.Tidbs[0].usingConnsCount, 1)
+	atomic.StoreInt64(&tp.Tidbs[1].usingConnsCount, 2)
+	cluster := &Cluster{BackendPools: map[string]*Pool{TiDBForTP: tp}}
+	var rolledBack []string
+	cluster.ForceRollback = func(addr string) int {
+		rolledBack = append(rolledBack, addr)
+		for _, db := range tp.Backends() {
+			if db.addr == addr {
+				return int(atomic.SwapInt64(&db.usingConnsCount, 0))
+			}
+		}
+		return 0
+	}
+	//the proxy itself holds no backend connections
+	if states := cluster.PoolStates(); len(states) != 1 || states[0].UsingConns != 3 || states[0].Tidbs != 3 {
+		t.Fatalf("unexpected pool states %+v", states)
+	}
+
+	version := tp.CurVersion
+	left, err := cluster.DrainPool(TiDBForTP, time.Second)
+	if err != nil || left != 0 {
+		t.Fatalf("%d conns left after drain: %v", left, err)
+	}
+	if !tp.IsCordoned() || tp.CurVersion != version+1 {
+		t.Fatal("drained pool not cordoned or its prepared statements not rebound")
+	}
+	if want := []string{"tidb-0:4000", "tidb-1:4000"}; !reflect.DeepEqual(rolledBack, want) {
+		t.Fatalf("rolled back %v, want %v", rolledBack, want)
+	}
+}
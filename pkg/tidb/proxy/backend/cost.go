@@ -0,0 +1,184 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/metrics"
+)
+
+const (
+	//statements up to this cost are tp class
+	TPMaxCost int64 = 10000
+	//statements over this cost are run on a temporary big tidb
+	BigCostMinCost int64 = 8000000000
+)
+
+const (
+	//statement run by the proxy as a compute node
+	OriginProxy = iota
+	//statement forwarded to a backend tidb
+	OriginForward
+	costOrigins
+)
+
+var (
+	costClasses     = [...]string{TiDBForTP, TiDBForAP, BigCost}
+	costOriginNames = [costOrigins]string{"proxy", "forward"}
+)
+
+//CostClass classifies a statement by its cost, the same way GetTidbConn routes it.
+func CostClass(cost int64) string {
+	switch {
+	case cost <= TPMaxCost:
+		return TiDBForTP
+	case cost > BigCostMinCost:
+		return BigCost
+	default:
+		return TiDBForAP
+	}
+}
+
+func classIndex(class string) int {
+	for i, c := range costClasses {
+		if c == class {
+			return i
+		}
+	}
+	return 0
+}
+
+//CostStats is the cost of running statements by class and origin.
+type CostStats struct {
+	costs [len(costClasses)][costOrigins]int64
+}
+
+//Add accounts cost of a statement starting, a finishing statement adds the negative cost.
+func (s *CostStats) Add(cost int64, origin int) {
+	class := CostClass(abs(cost))
+	atomic.AddInt64(&s.costs[classIndex(class)][origin], cost)
+	metrics.ProxyInflightCostGauge.WithLabelValues(class, costOriginNames[origin]).Add(float64(cost))
+}
+
+func (s *CostStats) Load(class string, origin int) int64 {
+	return atomic.LoadInt64(&s.costs[classIndex(class)][origin])
+}
+
+//Origin returns the cost of all classes from the origin.
+func (s *CostStats) Origin(origin int) int64 {
+	var total int64
+	for i := range costClasses {
+		total += atomic.LoadInt64(&s.costs[i][origin])
+	}
+	return total
+}
+
+//Snapshot returns costs keyed by class and origin.
+func (s *CostStats) Snapshot() map[string]map[string]int64 {
+	rs := make(map[string]map[string]int64, len(costClasses))
+	for i, class := range costClasses {
+		rs[class] = make(map[string]int64, costOrigins)
+		for origin, name := range costOriginNames {
+			rs[class][name] = atomic.LoadInt64(&s.costs[i][origin])
+		}
+	}
+	return rs
+}
+
+//AddCost accounts the cost of a statement sent to db on the pool and the backend, a
+//finishing statement adds the negative cost.
+func (pool *Pool) AddCost(db *DB, cost int64) {
+	atomic.AddInt64(&pool.Costs, cost)
+	if db != nil {
+		atomic.AddInt64(&db.costs, cost)
+	}
+	if cost > 0 {
+		atomic.AddUint64(&pool.TotalCost[CurCost], uint64(cost))
+		if db != nil {
+			atomic.AddUint64(&db.totalCost, uint64(cost))
+		}
+	}
+}
+
+//AddConnCost accounts the cost on the pool of the backend the conn belongs to,
+//the proxy itself and temporary big tidbs are not in a pool.
+func (cluster *Cluster) AddConnCost(conn *BackendConn, cost int64) {
+	if conn == nil || conn.db.Self {
+		return
+	}
+	if pool, ok := cluster.BackendPools[conn.db.dbType]; ok {
+		pool.AddCost(conn.db, cost)
+	}
+}
+
+//PoolUsage is the load of the Up backends of a pool.
+type PoolUsage struct {
+	//cost of running statements
+	Cost int64
+	//cost sent since the last TakeUsage
+	AddedCost uint64
+	//cpu weights
+	Cores float64
+	Up    int
+	Down  int
+}
+
+//TakeUsage sums the cost and cpu weights of the Up backends, Down backends are left out
+//so neither their capacity nor the cost they took before going down skews scaling.
+//The cost sent to every backend since the last call is taken.
+func (pool *Pool) TakeUsage() PoolUsage {
+	return pool.usage(true)
+}
+
+//PeekUsage is TakeUsage leaving the cost sent to the autoscaler, AddedCost is all the
+//cost sent to the Up backends since they were added.
+func (pool *Pool) PeekUsage() PoolUsage {
+	return pool.usage(false)
+}
+
+func (pool *Pool) usage(take bool) PoolUsage {
+	var usage PoolUsage
+	pool.RLock()
+	defer pool.RUnlock()
+	for i, db := range pool.Tidbs {
+		if db.Self {
+			continue
+		}
+		total := atomic.LoadUint64(&db.totalCost)
+		added := total
+		if take {
+			added -= atomic.SwapUint64(&db.lastTotalCost, total)
+		}
+		if atomic.LoadInt32(&db.state) != Up {
+			usage.Down++
+			continue
+		}
+		usage.Up++
+		usage.Cost += atomic.LoadInt64(&db.costs)
+		usage.AddedCost += added
+		if i < len(pool.TidbsWeights) {
+			usage.Cores += pool.TidbsWeights[i]
+		}
+	}
+	return usage
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
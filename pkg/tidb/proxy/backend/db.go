@@ -16,12 +16,16 @@ package backend
 
 import (
 	"fmt"
+	"math"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/pingcap/failpoint"
 	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
 	"github.com/pingcap/tidb/proxy/mysql"
 )
 
@@ -32,6 +36,8 @@ const (
 	Unknown
 
 	InitConnCount           = 16
+	//connections warmed up in parallel
+	warmupWorkers = 16
 	DefaultMaxConnNum       = 1024
 	PingPeroid        int64 = 4
 )
@@ -63,6 +69,41 @@ type DB struct {
 	//Self indicates whether the current node is a proxy node.
 	Self bool
 	dbType string
+	//cpu weight of the backend, used to cap running statements
+	weight float64
+
+	//routing weight ramps up during warm-up, guarded by the pool lock
+	warmupStart  time.Time
+	warmupWindow time.Duration
+	warmupStep   int
+	//errors decay the routing weight, errorStep is guarded by the pool lock
+	errors    errorScore
+	errorStep int
+
+	//schema version loaded by the backend, stale when lagging behind proxy
+	schemaVersion int64
+	schemaStale   int32
+
+	//server version reported by the backend at open
+	version string
+
+	//cost of running statements sent to the backend, all the cost sent and the part the
+	//autoscaler has taken
+	costs         int64
+	totalCost     uint64
+	lastTotalCost uint64
+
+	//ip the backend is at, conns bound to another ip are recycled
+	ip string
+	//zone of the node of the pod, empty if not known
+	zone string
+	//unix nano of the last lookup of the backend host
+	lastResolve int64
+
+	//idle conns dialed for a client ip by PROXY protocol, and their count
+	clientMu    sync.Mutex
+	clientConns map[string][]*Conn
+	clientIdle  int
 }
 
 func Open(addr string, user string, password string, dbName string,weight float64) (*DB, error) {
@@ -72,6 +113,7 @@ func Open(addr string, user string, password string, dbName string,weight float6
 	db.user = user
 	db.password = password
 	db.db = dbName
+	db.weight = weight
 
 	var conum int
 	if weight < 1.0 {
@@ -100,6 +142,8 @@ func Open(addr string, user string, password string, dbName string,weight float6
 		db.Close()
 		return nil, err
 	}
+	db.version = db.checkConn.GetServerVersion()
+	db.ip = db.checkConn.RemoteIP()
 
 	db.idleConns = make(chan *Conn, db.maxConnNum)
 	db.cacheConns = make(chan *Conn, db.maxConnNum)
@@ -138,6 +182,57 @@ func Open(addr string, user string, password string, dbName string,weight float6
 	return db, nil
 }
 
+//Warmup runs the statements on every pre-established connection, so the first queries
+//routed to a new tidb don't pay for schema loading and plan building. A statement
+//starting with USE switches the database of the connection.
+func (db *DB) Warmup(stmts []string) {
+	if len(stmts) == 0 {
+		return
+	}
+	start := time.Now()
+	n := len(db.cacheConns)
+	conns := make(chan *Conn, n)
+	for i := 0; i < n; i++ {
+		conns <- <-db.cacheConns
+	}
+	close(conns)
+
+	var failed int64
+	wg := &sync.WaitGroup{}
+	for w := 0; w < warmupWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for co := range conns {
+				for _, stmt := range stmts {
+					var err error
+					if fields := strings.Fields(stmt); len(fields) == 2 && strings.EqualFold(fields[0], "USE") {
+						err = co.UseDB(strings.Trim(fields[1], "`;"))
+					} else {
+						_, err = co.exec(stmt)
+					}
+					if err != nil {
+						atomic.AddInt64(&failed, 1)
+						golog.Warn("db", "Warmup", "warm-up statement failed", 0,
+							"addr", db.addr, "sql", stmt, "error", err)
+						break
+					}
+				}
+				if co.pkgErr != nil {
+					//broken by the warm-up, reconnected on demand from the idle conns
+					co.Close()
+					db.idleConns <- co
+					continue
+				}
+				db.cacheConns <- co
+			}
+		}()
+	}
+	wg.Wait()
+	golog.Info("db", "Warmup", "connections warmed up", 0,
+		"addr", db.addr, "conns", n, "failed", failed, "cost", time.Since(start).String())
+}
+
 func (db *DB) Addr() string {
 	return db.addr
 }
@@ -146,6 +241,23 @@ func (db *DB) DbType() string {
 	return db.dbType
 }
 
+func (db *DB) Version() string {
+	return db.version
+}
+
+//VersionMajorMinor returns major.minor of a backend version, for tidb which reports
+//5.7.25-TiDB-v5.1.0 the tidb release is used instead of the mysql compatible one.
+func VersionMajorMinor(version string) string {
+	if i := strings.Index(version, "-TiDB-v"); i >= 0 {
+		version = version[i+len("-TiDB-v"):]
+	}
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
 func (db *DB) State() string {
 	var state string
 	switch db.state {
@@ -181,6 +293,7 @@ func (db *DB) Close() error {
 		db.closeConn(conn)
 	}
 	close(idleChannel)
+	db.closeClientConns()
 
 	return nil
 }
@@ -207,8 +320,26 @@ func (db *DB) getIdleConns() chan *Conn {
 	return conns
 }
 
+//injectedDown reports whether the proxyBackendDown failpoint takes the db down, its value
+//is the addr of the db or * for every db.
+func (db *DB) injectedDown() (down bool) {
+	failpoint.Inject("proxyBackendDown", func(val failpoint.Value) {
+		addr, _ := val.(string)
+		down = addr == "*" || addr == db.addr
+	})
+	return
+}
+
 func (db *DB) Ping() error {
 	var err error
+	failpoint.Inject("proxyPingDelay", func(val failpoint.Value) {
+		if ms, ok := val.(int); ok {
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+		}
+	})
+	if db.injectedDown() {
+		return errors.ErrTidbDown
+	}
 	if db.checkConn == nil {
 		db.checkConn, err = db.newConn()
 		if err != nil {
@@ -221,6 +352,7 @@ func (db *DB) Ping() error {
 	}
 	err = db.checkConn.Ping()
 	if err != nil {
+		db.resolve()
 		if db.checkConn != nil {
 			db.checkConn.Close()
 			db.checkConn = nil
@@ -234,6 +366,8 @@ func (db *DB) newConn() (*Conn, error) {
 	co := new(Conn)
 
 	if err := co.Connect(db.addr, db.user, db.password, db.db); err != nil {
+		db.resolve()
+		db.recordError(time.Now())
 		return nil, err
 	}
 
@@ -254,6 +388,10 @@ func (db *DB) addIdleConn() {
 func (db *DB) closeConn(co *Conn) error {
 	atomic.AddInt64(&db.pushConnCount, 1)
 
+	if co != nil && co.clientIP != "" {
+		//conns dialed for a client have no idle slot
+		return co.Close()
+	}
 	if co != nil {
 		co.Close()
 		conns := db.getIdleConns()
@@ -272,6 +410,9 @@ func (db *DB) closeConn(co *Conn) error {
 }
 
 func (db *DB) closeConnNotAdd(co *Conn) error {
+	if co != nil && co.clientIP != "" {
+		return co.Close()
+	}
 	if co != nil {
 		co.Close()
 		conns := db.getIdleConns()
@@ -352,9 +493,14 @@ func (db *DB) GetConnFromCache(cacheConns chan *Conn) *Conn {
 		co = <-cacheConns
 		atomic.AddInt64(&db.popConnCount, 1)
 		//atomic.AddInt64(&db.usingConnsCount, 1)
+		if db.isStale(co) {
+			db.closeConn(co)
+			co = nil
+		}
 		if co != nil && PingPeroid < time.Now().Unix()-co.pushTimestamp {
 			err = co.Ping()
 			if err != nil {
+				db.resolve()
 				db.closeConn(co)
 				co = nil
 			}
@@ -390,9 +536,14 @@ func (db *DB) GetConnFromIdle(cacheConns, idleConns chan *Conn) (*Conn, error) {
 		if co == nil {
 			return nil, errors.ErrConnIsNil
 		}
+		if db.isStale(co) {
+			db.closeConn(co)
+			return nil, errors.ErrBadConn
+		}
 		if co != nil && PingPeroid < time.Now().Unix()-co.pushTimestamp {
 			err = co.Ping()
 			if err != nil {
+				db.resolve()
 				db.closeConn(co)
 				return nil, errors.ErrBadConn
 			}
@@ -410,12 +561,16 @@ func (db *DB) PushConn(co *Conn, err error) {
 		db.addIdleConn()
 		return
 	}
+	if co.clientIP != "" {
+		db.pushClientConn(co, err)
+		return
+	}
 	conns := db.getCacheConns()
 	if conns == nil {
 		co.Close()
 		return
 	}
-	if err != nil {
+	if err != nil || db.isStale(co) {
 		db.closeConnNotAdd(co)
 		return
 	}
@@ -433,6 +588,10 @@ type BackendConn struct {
 	*Conn
 	db *DB
 	bindConn bool
+	//kept by the session, Close does nothing until Unpin
+	pinned bool
+	//dialed for one statement, Close closes it instead of giving it back to the pool
+	fresh bool
 }
 
 func (p *BackendConn) GetBindConn() bool{
@@ -451,6 +610,14 @@ func (p *BackendConn) GetDbAddr() string {
 	return p.db.addr
 }
 
+//GetPodName returns the pod of the tidb, or its addr when it is not a pod of the cluster.
+func (p *BackendConn) GetPodName() string {
+	if podName, _, ok := podOfAddr(p.db.addr); ok {
+		return podName
+	}
+	return strings.Split(p.db.addr, WeightSplit)[0]
+}
+
 func (p *BackendConn) SetNoDelayTrue() {
 	tcptemp := p.Conn.conn.(*net.TCPConn)
 	tcptemp.SetNoDelay(true)
@@ -460,13 +627,54 @@ func (p *BackendConn) SetNoDelayFlase() {
 	tcptemp.SetNoDelay(false)
 }
 
+//Pin keeps the conn for the session while it holds state on the backend session, like
+//temporary tables, the conn is not given back to the pool until Unpin.
+func (p *BackendConn) Pin() {
+	p.pinned = true
+}
+
+func (p *BackendConn) Unpin() {
+	p.pinned = false
+}
+
+func (p *BackendConn) IsPinned() bool {
+	return p.pinned
+}
+
+//Discard closes the backend session instead of giving it back to the pool, the state the
+//session left on it must not be seen by other sessions.
+func (p *BackendConn) Discard() {
+	p.reportFault()
+	p.pinned = false
+	if p.db.Self {
+		return
+	}
+	atomic.AddInt64(&p.db.usingConnsCount, -1)
+	if p.Conn != nil {
+		p.db.closeConn(p.Conn)
+		p.Conn = nil
+	}
+}
+
 func (p *BackendConn) Close() {
+	p.reportFault()
+	if p.pinned {
+		return
+	}
+	if p.fresh {
+		if p.Conn != nil {
+			p.Conn.Close()
+			p.Conn = nil
+		}
+		return
+	}
 	atomic.AddInt64(&p.db.usingConnsCount,-1)
 	//fmt.Printf("using conn is %d \n",p.db.usingConnsCount)
 	fmt.Printf("Close using conn is %d initnum %d,maxConn %d\n",p.db.usingConnsCount,p.db.InitConnNum,p.db.maxConnNum)
 
 	if p != nil && p.Conn != nil {
 		if p.Conn.pkgErr != nil {
+			p.db.resolve()
 			p.db.closeConn(p.Conn)
 		} else {
 			p.db.PushConn(p.Conn, nil)
@@ -488,7 +696,43 @@ func (db *DB) GetConn(bindFlag bool) (*BackendConn, error) {
 			bindFlag = false
 		}
 	}
-	return &BackendConn{c, db,bindFlag}, nil
+	return &BackendConn{Conn: c, db: db, bindConn: bindFlag}, nil
+}
+
+//MaxConcurrent returns the max running statements of the backend, 0 means no limit.
+func (db *DB) MaxConcurrent(perCore int) int64 {
+	if perCore <= 0 || db.weight <= 0 {
+		return 0
+	}
+	max := int64(math.Ceil(db.weight * float64(perCore)))
+	if max < 1 {
+		max = 1
+	}
+	return max
+}
+
+//IsOverloaded reports whether the backend reaches its concurrency cap.
+func (db *DB) IsOverloaded(perCore int) bool {
+	max := db.MaxConcurrent(perCore)
+	return max > 0 && atomic.LoadInt64(&db.usingConnsCount) >= max
+}
+
+func (db *DB) SchemaVersion() int64 {
+	return atomic.LoadInt64(&db.schemaVersion)
+}
+
+func (db *DB) IsSchemaStale() bool {
+	return atomic.LoadInt32(&db.schemaStale) == 1
+}
+
+//SetSchemaVersion records backend schema version and whether it is behind the latest one.
+func (db *DB) SetSchemaVersion(version int64, latest int64) {
+	atomic.StoreInt64(&db.schemaVersion, version)
+	if version < latest {
+		atomic.StoreInt32(&db.schemaStale, 1)
+	} else {
+		atomic.StoreInt32(&db.schemaStale, 0)
+	}
 }
 
 func (db *DB) SetLastPing() {
@@ -0,0 +1,52 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"testing"
+)
+
+func TestSchemaStaleSkipped(t *testing.T) {
+	pool := &Pool{}
+	pool.Tidbs = []*DB{
+		{addr: "tidb-0:4000", state: Up},
+		{addr: "tidb-1:4000", state: Up},
+	}
+	pool.TidbsWeights = []float64{1, 1}
+	pool.RoundRobinQ = order([]int{1, 1})
+	pool.publish()
+
+	pool.Tidbs[0].SetSchemaVersion(41, 42)
+	if !pool.Tidbs[0].IsSchemaStale() || pool.Tidbs[0].SchemaVersion() != 41 {
+		t.Fatal("backend behind the schema of the proxy not stale")
+	}
+	if counts := pickCounts(t, pool, 10); counts["tidb-1:4000"] != 10 {
+		t.Fatalf("stale backend picked %v", counts)
+	}
+	//with every backend stale the statements still go to one of them
+	pool.Tidbs[1].SetSchemaVersion(40, 42)
+	if counts := pickCounts(t, pool, 10); counts["tidb-0:4000"]+counts["tidb-1:4000"] != 10 {
+		t.Fatalf("unexpected picks with every backend stale %v", counts)
+	}
+	//caught up, or ahead of the proxy
+	pool.Tidbs[0].SetSchemaVersion(42, 42)
+	pool.Tidbs[1].SetSchemaVersion(43, 42)
+	if pool.Tidbs[0].IsSchemaStale() || pool.Tidbs[1].IsSchemaStale() {
+		t.Fatal("backend at the schema of the proxy stale")
+	}
+	if counts := pickCounts(t, pool, 10); counts["tidb-0:4000"] != 5 || counts["tidb-1:4000"] != 5 {
+		t.Fatalf("unexpected picks once caught up %v", counts)
+	}
+}
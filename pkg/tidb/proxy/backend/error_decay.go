@@ -0,0 +1,151 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+//how errors decay the routing weight of the tidbs, see SetErrorDecay
+var errorDecay = struct {
+	sync.RWMutex
+	halfLife  time.Duration
+	minFactor float64
+	codes     map[uint16]bool
+}{
+	halfLife:  config.DefaultErrorDecayHalfLife * time.Second,
+	minFactor: config.DefaultErrorDecayMinWeight / 100.0,
+	codes:     errorCodes(config.DefaultErrorDecayCodes),
+}
+
+//error score of a tidb, it halves every half life of the decay
+type errorScore struct {
+	sync.Mutex
+	score float64
+	at    time.Time
+}
+
+func errorCodes(codes []uint16) map[uint16]bool {
+	m := make(map[uint16]bool, len(codes))
+	for _, code := range codes {
+		m[code] = true
+	}
+	return m
+}
+
+//SetErrorDecay sets how the errors of a tidb decay its routing weight.
+func SetErrorDecay(cfg config.ErrorDecayConfig) {
+	errorDecay.Lock()
+	defer errorDecay.Unlock()
+	switch {
+	case cfg.HalfLife == 0:
+		errorDecay.halfLife = config.DefaultErrorDecayHalfLife * time.Second
+	case cfg.HalfLife < 0:
+		errorDecay.halfLife = 0
+	default:
+		errorDecay.halfLife = time.Duration(cfg.HalfLife) * time.Second
+	}
+	errorDecay.minFactor = float64(cfg.MinWeight) / 100
+	if cfg.MinWeight <= 0 || cfg.MinWeight > 100 {
+		errorDecay.minFactor = config.DefaultErrorDecayMinWeight / 100.0
+	}
+	errorDecay.codes = errorCodes(cfg.Codes)
+	if len(cfg.Codes) == 0 {
+		errorDecay.codes = errorCodes(config.DefaultErrorDecayCodes)
+	}
+}
+
+func errorDecayOn() bool {
+	errorDecay.RLock()
+	defer errorDecay.RUnlock()
+	return errorDecay.halfLife > 0
+}
+
+//isFaultCode reports whether the error of the tidb tells it is sick rather than the
+//statement is wrong
+func isFaultCode(code uint16) bool {
+	errorDecay.RLock()
+	defer errorDecay.RUnlock()
+	return errorDecay.codes[code]
+}
+
+//decayed returns the score at now
+func (s *errorScore) decayed(now time.Time, halfLife time.Duration) float64 {
+	if s.score == 0 || halfLife <= 0 {
+		return 0
+	}
+	return s.score * math.Exp2(-float64(now.Sub(s.at))/float64(halfLife))
+}
+
+//recordError adds an error of the tidb to its score.
+func (db *DB) recordError(now time.Time) {
+	if db.Self {
+		return
+	}
+	errorDecay.RLock()
+	halfLife := errorDecay.halfLife
+	errorDecay.RUnlock()
+	if halfLife <= 0 {
+		return
+	}
+	metrics.ProxyBackendFaultCounter.WithLabelValues(db.addr).Inc()
+	db.errors.Lock()
+	db.errors.score = db.errors.decayed(now, halfLife) + 1
+	db.errors.at = now
+	db.errors.Unlock()
+}
+
+//errorFactor returns the share of routing weight the tidb keeps after its errors and its
+//step out of warmupSteps, the balancer is rebuilt when the step changes.
+func (db *DB) errorFactor(now time.Time) (float64, int) {
+	errorDecay.RLock()
+	halfLife, minFactor := errorDecay.halfLife, errorDecay.minFactor
+	errorDecay.RUnlock()
+	db.errors.Lock()
+	score := db.errors.decayed(now, halfLife)
+	db.errors.Unlock()
+	factor := 1 / (1 + score)
+	if factor < minFactor {
+		factor = minFactor
+	}
+	step := int(math.Ceil(factor * warmupSteps))
+	if step >= warmupSteps {
+		return 1, warmupSteps
+	}
+	return float64(step) / warmupSteps, step
+}
+
+//Faulted reports whether a statement on the conn hit a connection error or a fault code
+//since it was taken from the pool.
+func (p *BackendConn) Faulted() bool {
+	return p != nil && p.Conn != nil && p.Conn.fault
+}
+
+//reportFault adds an error to the tidb of the conn when a statement on it hit a
+//connection error or a fault code.
+func (p *BackendConn) reportFault() {
+	if p == nil || p.Conn == nil || p.db.Self {
+		return
+	}
+	if p.Conn.fault {
+		p.Conn.fault = false
+		p.db.recordError(time.Now())
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func TestErrorFactor(t *testing.T) {
+	SetErrorDecay(config.ErrorDecayConfig{HalfLife: 10})
+	defer SetErrorDecay(config.ErrorDecayConfig{})
+	db := &DB{addr: "tidb-0:4000", state: Up}
+	now := time.Now()
+	if factor, step := db.errorFactor(now); factor != 1 || step != warmupSteps {
+		t.Fatalf("expect full weight without errors, got %v %d", factor, step)
+	}
+	//4 errors at once keep 20% of the weight
+	for i := 0; i < 4; i++ {
+		db.recordError(now)
+	}
+	if factor, _ := db.errorFactor(now); factor != 0.2 {
+		t.Fatalf("expect 0.2 after 4 errors, got %v", factor)
+	}
+	//the score halves every 10s
+	if factor, _ := db.errorFactor(now.Add(10 * time.Second)); factor < 0.3 || factor > 0.4 {
+		t.Fatalf("expect about 1/3 after a half life, got %v", factor)
+	}
+	if factor, _ := db.errorFactor(now.Add(100 * time.Second)); factor != 1 {
+		t.Fatalf("expect full weight after the errors aged, got %v", factor)
+	}
+	//the weight never drops below min_weight
+	for i := 0; i < 100; i++ {
+		db.recordError(now)
+	}
+	if factor, step := db.errorFactor(now); factor != 0.1 || step != 1 {
+		t.Fatalf("expect the min weight, got %v %d", factor, step)
+	}
+
+	SetErrorDecay(config.ErrorDecayConfig{HalfLife: -1})
+	if factor, _ := db.errorFactor(now); factor != 1 {
+		t.Fatalf("expect full weight with decay disabled, got %v", factor)
+	}
+}
+
+func TestErrorDecayBalancer(t *testing.T) {
+	SetErrorDecay(config.ErrorDecayConfig{HalfLife: 60, Codes: []uint16{1053}})
+	defer SetErrorDecay(config.ErrorDecayConfig{})
+	pool := testPool(2)
+	pool.TidbsWeights = []float64{1, 1}
+	sick := pool.Tidbs[1]
+
+	//an error packet of a fault code marks the conn, reporting it scores the tidb
+	co := &Conn{}
+	if err := co.handleErrorPacket([]byte{0xff, 0x1d, 0x04, 'b', 'y', 'e'}); err == nil || !co.fault {
+		t.Fatalf("expect a fault of code 1053, got %v %v", err, co.fault)
+	}
+	(&BackendConn{Conn: co, db: sick}).reportFault()
+	if co.fault {
+		t.Fatal("expect the fault reported once")
+	}
+	//a statement error is not a fault
+	if co.handleErrorPacket([]byte{0xff, 0x26, 0x04, 'd', 'u', 'p'}); co.fault {
+		t.Fatal("expect no fault of code 1062")
+	}
+	sick.recordError(time.Now())
+
+	pool.Lock()
+	pool.InitBalancer()
+	pool.Unlock()
+	counts := make(map[int]int)
+	for _, index := range pool.view().roundRobinQ {
+		counts[index]++
+	}
+	if counts[1]*2 >= counts[0] {
+		t.Fatalf("expect the sick tidb to take less traffic, got %v", counts)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+//FairQueue admits statements up to a limit of running ones. Statements over the limit
+//wait in a queue per user, and the users waiting take turns in round robin, a user
+//with weight n is admitted up to n statements per turn. A user running many statements
+//can't starve the others.
+type FairQueue struct {
+	sync.Mutex
+	//max running statements, 0 or less means no limit
+	limit   func() int64
+	weights map[string]int
+
+	running int64
+	users   map[string]*fairUser
+	//users with waiting statements in the order they take turns
+	order []string
+	next  int
+}
+
+type fairUser struct {
+	running int64
+	waiting []chan struct{}
+	//statements left to admit in the current turn
+	credit int
+}
+
+//FairUserStats is the statements of a user in the queue.
+type FairUserStats struct {
+	User    string `json:"user"`
+	Weight  int    `json:"weight"`
+	Running int64  `json:"running"`
+	Waiting int    `json:"waiting"`
+}
+
+//FairQueueStats is the state of the queue.
+type FairQueueStats struct {
+	Limit   int64           `json:"limit"`
+	Running int64           `json:"running"`
+	Waiting int             `json:"waiting"`
+	Users   []FairUserStats `json:"users"`
+}
+
+func NewFairQueue(limit func() int64, weights map[string]int) *FairQueue {
+	return &FairQueue{
+		limit:   limit,
+		weights: weights,
+		users:   make(map[string]*fairUser),
+	}
+}
+
+func (q *FairQueue) weight(user string) int {
+	if w, ok := q.weights[user]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (q *FairQueue) user(name string) *fairUser {
+	u, ok := q.users[name]
+	if !ok {
+		u = new(fairUser)
+		q.users[name] = u
+	}
+	return u
+}
+
+func (q *FairQueue) full() bool {
+	limit := q.limit()
+	return limit > 0 && q.running >= limit
+}
+
+//Acquire waits for the turn of the user to run a statement, and returns the func to call
+//once the statement finishes. It fails with ErrFairQueueTimeout if the turn doesn't come
+//in timeout.
+func (q *FairQueue) Acquire(user string, timeout time.Duration) (func(), error) {
+	q.Lock()
+	//the limit may be raised since the last release, admit the waiting ones first
+	q.dispatch()
+	if len(q.order) == 0 && !q.full() {
+		q.admit(user)
+		q.Unlock()
+		return q.releaser(user), nil
+	}
+	ch := make(chan struct{})
+	u := q.user(user)
+	if len(u.waiting) == 0 {
+		q.order = append(q.order, user)
+	}
+	u.waiting = append(u.waiting, ch)
+	q.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return q.releaser(user), nil
+	case <-timer.C:
+	}
+	q.Lock()
+	defer q.Unlock()
+	select {
+	case <-ch:
+		//granted while the timer fired
+		return q.releaser(user), nil
+	default:
+	}
+	q.removeWaiter(user, ch)
+	return nil, errors.ErrFairQueueTimeout
+}
+
+func (q *FairQueue) admit(user string) {
+	q.running++
+	q.user(user).running++
+}
+
+func (q *FairQueue) releaser(user string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.Lock()
+			defer q.Unlock()
+			q.running--
+			if u, ok := q.users[user]; ok {
+				u.running--
+				q.forget(user, u)
+			}
+			q.dispatch()
+		})
+	}
+}
+
+//dispatch admits the waiting statements while the limit allows, every user waiting is
+//admitted up to its weight per turn. A turn cut by the limit goes on at the next release.
+func (q *FairQueue) dispatch() {
+	for len(q.order) > 0 && !q.full() {
+		if q.next >= len(q.order) {
+			q.next = 0
+		}
+		name := q.order[q.next]
+		u := q.users[name]
+		if u.credit <= 0 {
+			u.credit = q.weight(name)
+		}
+		for u.credit > 0 && len(u.waiting) > 0 && !q.full() {
+			close(u.waiting[0])
+			u.waiting = u.waiting[1:]
+			u.credit--
+			q.admit(name)
+		}
+		if len(u.waiting) == 0 {
+			u.credit = 0
+			q.order = append(q.order[:q.next], q.order[q.next+1:]...)
+			continue
+		}
+		if u.credit == 0 {
+			q.next++
+		}
+	}
+}
+
+func (q *FairQueue) removeWaiter(user string, ch chan struct{}) {
+	u, ok := q.users[user]
+	if !ok {
+		return
+	}
+	for i, w := range u.waiting {
+		if w == ch {
+			u.waiting = append(u.waiting[:i], u.waiting[i+1:]...)
+			break
+		}
+	}
+	if len(u.waiting) == 0 {
+		u.credit = 0
+		for i, name := range q.order {
+			if name == user {
+				q.order = append(q.order[:i], q.order[i+1:]...)
+				if q.next > i {
+					q.next--
+				}
+				break
+			}
+		}
+	}
+	q.forget(user, u)
+}
+
+//forget drops a user with nothing running or waiting.
+func (q *FairQueue) forget(name string, u *fairUser) {
+	if u.running == 0 && len(u.waiting) == 0 {
+		delete(q.users, name)
+	}
+}
+
+//Waiting returns the number of statements waiting for a turn.
+func (q *FairQueue) Waiting() int {
+	q.Lock()
+	defer q.Unlock()
+	var n int
+	for _, u := range q.users {
+		n += len(u.waiting)
+	}
+	return n
+}
+
+func (q *FairQueue) Stats() FairQueueStats {
+	q.Lock()
+	defer q.Unlock()
+	stats := FairQueueStats{
+		Limit:   q.limit(),
+		Running: q.running,
+		Users:   make([]FairUserStats, 0, len(q.users)),
+	}
+	for name, u := range q.users {
+		stats.Waiting += len(u.waiting)
+		stats.Users = append(stats.Users, FairUserStats{
+			User:    name,
+			Weight:  q.weight(name),
+			Running: u.running,
+			Waiting: len(u.waiting),
+		})
+	}
+	sort.Slice(stats.Users, func(i, j int) bool { return stats.Users[i].User < stats.Users[j].User })
+	return stats
+}
@@ -0,0 +1,113 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+//waitFor waits until n statements wait in the queue.
+func waitFor(t *testing.T, q *FairQueue, n int) {
+	for i := 0; q.Waiting() != n; i++ {
+		if i > 1000 {
+			t.Fatalf("expect %d waiting, got %d", n, q.Waiting())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFairQueueTurns(t *testing.T) {
+	q := NewFairQueue(func() int64 { return 1 }, map[string]int{"b": 2})
+	release, err := q.Acquire("a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	acquire := func(user string) {
+		defer wg.Done()
+		r, err := q.Acquire(user, 5*time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		order = append(order, user)
+		mu.Unlock()
+		r()
+	}
+	//a queues many statements before b and c queue theirs
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go acquire("a")
+		waitFor(t, q, i+1)
+	}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go acquire("b")
+		waitFor(t, q, 4+i)
+	}
+	wg.Add(1)
+	go acquire("c")
+	waitFor(t, q, 7)
+
+	release()
+	wg.Wait()
+	if want := []string{"a", "b", "b", "c", "a", "b", "a"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("expect turns %v, got %v", want, order)
+	}
+	if stats := q.Stats(); stats.Running != 0 || stats.Waiting != 0 || len(stats.Users) != 0 {
+		t.Fatalf("expect empty queue, got %+v", stats)
+	}
+}
+
+func TestFairQueueTimeout(t *testing.T) {
+	q := NewFairQueue(func() int64 { return 1 }, nil)
+	release, err := q.Acquire("a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = q.Acquire("b", 10*time.Millisecond); err != errors.ErrFairQueueTimeout {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+	if q.Waiting() != 0 {
+		t.Fatalf("expect timed out statement left the queue")
+	}
+	release()
+	//a second release is ignored
+	release()
+	if _, err = q.Acquire("b", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if stats := q.Stats(); stats.Running != 1 {
+		t.Fatalf("expect 1 running, got %+v", stats)
+	}
+}
+
+func TestFairQueueNoLimit(t *testing.T) {
+	q := NewFairQueue(func() int64 { return 0 }, nil)
+	for i := 0; i < 10; i++ {
+		if _, err := q.Acquire("a", time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+//which tidb a fresh conn goes to
+const (
+	freshPreferred = "preferred"
+	freshPool      = "pool"
+	//no tidb is up, the proxy runs the statement itself
+	freshProxy = "proxy"
+)
+
+//GetFreshConn dials a conn to the preferred tidb, by pod name or addr, or else to the
+//first up tp tidb. The conn is closed instead of given back to the pool. It returns nil
+//without error when no tidb is up, then the proxy runs the statement itself.
+func (cluster *Cluster) GetFreshConn(preferred string) (*BackendConn, error) {
+	conn, result, err := cluster.dialFresh(preferred)
+	if result != "" {
+		metrics.ProxyFreshConnCounter.WithLabelValues(result).Inc()
+	}
+	return conn, err
+}
+
+//dialFresh returns the fresh conn and the label of the tidb chosen, empty before the
+//cluster is initialized.
+func (cluster *Cluster) dialFresh(preferred string) (*BackendConn, string, error) {
+	if !cluster.Initialized() {
+		return nil, "", errors.ErrClusterInitializing
+	}
+	db, result := cluster.freshDB(preferred)
+	if db == nil {
+		return nil, result, nil
+	}
+	co, err := db.newConn()
+	if err != nil {
+		return nil, result, errors.NewPoolError(db.dbType, err)
+	}
+	return &BackendConn{Conn: co, db: db, fresh: true}, result, nil
+}
+
+func (cluster *Cluster) freshDB(preferred string) (*DB, string) {
+	if preferred != "" {
+		if _, _, db := cluster.dbOfPod(preferred); db != nil && db.freshUp() {
+			return db, freshPreferred
+		}
+		golog.Warn("Cluster", "GetFreshConn", "preferred tidb not up", 0, "backend", preferred)
+	}
+	pool, ok := cluster.BackendPools[TiDBForTP]
+	if !ok {
+		return nil, freshProxy
+	}
+	//the same tidb while it is up, so schema changes come from a predictable node
+	for _, db := range pool.view().tidbs {
+		if !db.Self && db.freshUp() && !db.IsSchemaStale() {
+			return db, freshPool
+		}
+	}
+	return nil, freshProxy
+}
+
+func (db *DB) freshUp() bool {
+	return atomic.LoadInt32(&db.state) == Up && !db.injectedDown()
+}
@@ -0,0 +1,44 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"testing"
+)
+
+func TestFreshDB(t *testing.T) {
+	pool := &Pool{Tidbs: []*DB{
+		{addr: "self", Self: true, state: Up},
+		{addr: "cluster-tidb-0.cluster-tidb-peer.ns.svc:4000", state: Down},
+		{addr: "cluster-tidb-1.cluster-tidb-peer.ns.svc:4000", state: Up},
+		{addr: "cluster-tidb-2.cluster-tidb-peer.ns.svc:4000", state: Up},
+	}}
+	pool.publish()
+	cluster := &Cluster{BackendPools: map[string]*Pool{TiDBForTP: pool}}
+
+	if db, result := cluster.freshDB("cluster-tidb-2"); db != pool.Tidbs[3] || result != freshPreferred {
+		t.Fatalf("expect the preferred tidb, got %v %s", db, result)
+	}
+	//the preferred tidb is down, the first up tidb is used
+	for i := 0; i < 3; i++ {
+		if db, result := cluster.freshDB("cluster-tidb-0"); db != pool.Tidbs[2] || result != freshPool {
+			t.Fatalf("expect the first up tidb, got %v %s", db, result)
+		}
+	}
+	pool.Tidbs[2].state, pool.Tidbs[3].state = Down, Down
+	if db, result := cluster.freshDB(""); db != nil || result != freshProxy {
+		t.Fatalf("expect the proxy without up tidbs, got %v %s", db, result)
+	}
+}
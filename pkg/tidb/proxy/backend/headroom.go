@@ -0,0 +1,216 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/util/memory"
+)
+
+//cgroup fs of the proxy container, cgroup v2 files are read first
+var cgroupRoot = "/sys/fs/cgroup"
+
+var errNoCgroup = errors.New("no cgroup usage found")
+
+//LocalLoadInfo is the usage of the proxy container, as ratios of its limits
+type LocalLoadInfo struct {
+	CPU       float64 `json:"cpu"`
+	Memory    float64 `json:"memory"`
+	Saturated bool    `json:"saturated"`
+}
+
+//usage of the proxy container sampled by SampleLocalLoad
+var localLoad = struct {
+	sync.Mutex
+	//ratios of the limits, 0 means no limit
+	maxCPU, maxMemory float64
+	interval          time.Duration
+	usage             time.Duration
+	at                time.Time
+	info              LocalLoadInfo
+	saturated         int32
+}{
+	maxCPU:    config.DefaultProxyComputeMaxCPU / 100.0,
+	maxMemory: config.DefaultProxyComputeMaxMemory / 100.0,
+	interval:  config.DefaultProxyComputeSampleInterval * time.Second,
+}
+
+func headroomLimit(percent, def float64) float64 {
+	switch {
+	case percent == 0:
+		return def / 100
+	case percent < 0:
+		return 0
+	}
+	return percent / 100
+}
+
+//SetProxyCompute sets the usage above which the proxy takes no new statements as a compute node.
+func SetProxyCompute(cfg config.ProxyComputeConfig) {
+	localLoad.Lock()
+	defer localLoad.Unlock()
+	localLoad.maxCPU = headroomLimit(cfg.MaxCPU, config.DefaultProxyComputeMaxCPU)
+	localLoad.maxMemory = headroomLimit(cfg.MaxMemory, config.DefaultProxyComputeMaxMemory)
+	localLoad.interval = time.Duration(cfg.SampleInterval) * time.Second
+	if cfg.SampleInterval <= 0 {
+		localLoad.interval = config.DefaultProxyComputeSampleInterval * time.Second
+	}
+}
+
+//LocalSampleInterval returns how often the usage of the proxy container is sampled.
+func LocalSampleInterval() time.Duration {
+	localLoad.Lock()
+	defer localLoad.Unlock()
+	return localLoad.interval
+}
+
+//LocalLoad returns the usage of the proxy container of the last sample.
+func LocalLoad() LocalLoadInfo {
+	localLoad.Lock()
+	defer localLoad.Unlock()
+	return localLoad.info
+}
+
+//LocalSaturated reports whether the proxy container has no headroom for new statements.
+func LocalSaturated() bool {
+	return atomic.LoadInt32(&localLoad.saturated) == 1
+}
+
+//SampleLocalLoad samples the usage of the proxy container, the cpu usage is the average
+//since the last sample. Usage that can't be read counts as idle, so the proxy keeps
+//serving as a compute node outside containers.
+func SampleLocalLoad(now time.Time) LocalLoadInfo {
+	localLoad.Lock()
+	defer localLoad.Unlock()
+	info := &localLoad.info
+	if usage, err := cpuUsage(); err == nil {
+		if !localLoad.at.IsZero() && now.After(localLoad.at) && usage >= localLoad.usage {
+			info.CPU = float64(usage-localLoad.usage) / float64(now.Sub(localLoad.at)) / cpuLimit()
+		}
+		localLoad.usage, localLoad.at = usage, now
+	}
+	if ratio, ok := memoryRatio(); ok {
+		info.Memory = ratio
+	}
+	saturated := localLoad.maxCPU > 0 && info.CPU >= localLoad.maxCPU ||
+		localLoad.maxMemory > 0 && info.Memory >= localLoad.maxMemory
+	if saturated != info.Saturated {
+		if saturated {
+			golog.Warn("Cluster", "SampleLocalLoad", "proxy saturated, no new local statements", 0,
+				"cpu", info.CPU, "memory", info.Memory)
+		} else {
+			golog.Info("Cluster", "SampleLocalLoad", "proxy has headroom again", 0,
+				"cpu", info.CPU, "memory", info.Memory)
+		}
+	}
+	info.Saturated = saturated
+	var flag int32
+	if saturated {
+		flag = 1
+	}
+	atomic.StoreInt32(&localLoad.saturated, flag)
+	metrics.ProxyLocalLoadGauge.WithLabelValues("cpu").Set(info.CPU)
+	metrics.ProxyLocalLoadGauge.WithLabelValues("memory").Set(info.Memory)
+	return *info
+}
+
+//busy reports whether the db is the proxy itself without headroom for new statements
+func (db *DB) busy() bool {
+	return db.Self && LocalSaturated()
+}
+
+func readCgroup(name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cgroupRoot, name))
+	return strings.TrimSpace(string(data)), err
+}
+
+func readCgroupUint(name string) (uint64, error) {
+	s, err := readCgroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+//cpuUsage returns the cpu time used by the container
+func cpuUsage() (time.Duration, error) {
+	if s, err := readCgroup("cpu.stat"); err == nil {
+		for _, line := range strings.Split(s, "\n") {
+			if f := strings.Fields(line); len(f) == 2 && f[0] == "usage_usec" {
+				usec, err := strconv.ParseUint(f[1], 10, 64)
+				return time.Duration(usec) * time.Microsecond, err
+			}
+		}
+	}
+	if ns, err := readCgroupUint("cpuacct/cpuacct.usage"); err == nil {
+		return time.Duration(ns), nil
+	}
+	return 0, errNoCgroup
+}
+
+//cpuLimit returns the cores the container may use
+func cpuLimit() float64 {
+	if s, err := readCgroup("cpu.max"); err == nil {
+		if f := strings.Fields(s); len(f) == 2 && f[0] != "max" {
+			quota, err1 := strconv.ParseFloat(f[0], 64)
+			period, err2 := strconv.ParseFloat(f[1], 64)
+			if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+				return quota / period
+			}
+		}
+	} else if s, err := readCgroup("cpu/cpu.cfs_quota_us"); err == nil {
+		quota, err1 := strconv.ParseFloat(s, 64)
+		period, err2 := readCgroupUint("cpu/cpu.cfs_period_us")
+		if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+			return quota / float64(period)
+		}
+	}
+	return float64(runtime.NumCPU())
+}
+
+//memoryRatio returns the memory used by the container of its limit, or of the host
+//memory if it has no limit
+func memoryRatio() (float64, bool) {
+	used, err := readCgroupUint("memory.current")
+	if err != nil {
+		if used, err = readCgroupUint("memory/memory.usage_in_bytes"); err != nil {
+			return 0, false
+		}
+	}
+	//memory.max is "max" without a limit
+	limit, err := readCgroupUint("memory.max")
+	if err != nil {
+		limit, err = readCgroupUint("memory/memory.limit_in_bytes")
+	}
+	if total, terr := memory.MemTotalNormal(); terr == nil && (err != nil || limit > total) {
+		limit, err = total, nil
+	}
+	if err != nil || limit == 0 {
+		return 0, false
+	}
+	return float64(used) / float64(limit), true
+}
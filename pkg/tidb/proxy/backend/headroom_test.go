@@ -0,0 +1,101 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func writeCgroup(t *testing.T, dir, name, content string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSampleLocalLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := cgroupRoot
+	cgroupRoot = dir
+	defer func() {
+		cgroupRoot = root
+		SetProxyCompute(config.ProxyComputeConfig{})
+		localLoad.at, localLoad.info = time.Time{}, LocalLoadInfo{}
+		atomic.StoreInt32(&localLoad.saturated, 0)
+	}()
+	SetProxyCompute(config.ProxyComputeConfig{})
+
+	//two cores, 1GiB of which half is used
+	writeCgroup(t, dir, "cpu.max", "200000 100000")
+	writeCgroup(t, dir, "cpu.stat", "usage_usec 1000000\nuser_usec 800000\n")
+	writeCgroup(t, dir, "memory.current", "536870912")
+	writeCgroup(t, dir, "memory.max", "1073741824")
+	now := time.Now()
+	if info := SampleLocalLoad(now); info.Saturated || info.Memory != 0.5 {
+		t.Fatalf("first sample: %+v", info)
+	}
+
+	//1.4 cores used in the second
+	writeCgroup(t, dir, "cpu.stat", "usage_usec 2400000\n")
+	info := SampleLocalLoad(now.Add(time.Second))
+	if !info.Saturated || info.CPU < 0.69 || info.CPU > 0.71 || !LocalSaturated() {
+		t.Fatalf("saturated sample: %+v", info)
+	}
+
+	pool := new(Pool)
+	pool.Tidbs = []*DB{{addr: "self", Self: true, state: Up}, {addr: "tidb-0:4000", state: Up}}
+	pool.TidbsWeights = []float64{1, 1}
+	pool.RoundRobinQ = order([]int{1, 1})
+	pool.publish()
+	for i := 0; i < 4; i++ {
+		if db, _, err := pool.pick("qps"); err != nil || db.Self {
+			t.Fatalf("saturated proxy picked: %v %v", db, err)
+		}
+	}
+	//the saturated proxy still takes statements no tidb is up for
+	atomic.StoreInt32(&pool.Tidbs[1].state, Down)
+	if db, _, err := pool.pick("qps"); err != nil || !db.Self {
+		t.Fatalf("expect the proxy without up tidbs, got %v %v", db, err)
+	}
+
+	//no limits
+	SetProxyCompute(config.ProxyComputeConfig{MaxCPU: -1, MaxMemory: -1})
+	writeCgroup(t, dir, "cpu.stat", "usage_usec 4400000\n")
+	if info := SampleLocalLoad(now.Add(2 * time.Second)); info.Saturated || LocalSaturated() {
+		t.Fatalf("saturated without limits: %+v", info)
+	}
+}
+
+func TestSampleLocalLoadWithoutCgroup(t *testing.T) {
+	root := cgroupRoot
+	cgroupRoot = filepath.Join(os.TempDir(), "no-such-cgroup")
+	defer func() { cgroupRoot = root }()
+	if _, err := cpuUsage(); err == nil {
+		t.Fatal("expect no cpu usage without cgroup")
+	}
+	if _, ok := memoryRatio(); ok {
+		t.Fatal("expect no memory usage without cgroup")
+	}
+}
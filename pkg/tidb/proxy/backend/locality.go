@@ -0,0 +1,159 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	routeLocal  = "local"
+	routeRemote = "remote"
+)
+
+//zones of the nodes by name, a node doesn't move to another zone
+var nodeZones sync.Map
+
+//NodeZone returns the zone of the node read from the label, empty if it can't be read.
+func NodeZone(node, label string) string {
+	if node == "" || util.KubeClient == nil {
+		return ""
+	}
+	if zone, ok := nodeZones.Load(node); ok {
+		return zone.(string)
+	}
+	n, err := util.KubeClient.CoreV1().Nodes().Get(node, metav1.GetOptions{})
+	if err != nil {
+		golog.Warn("locality", "NodeZone", "get node failed", 0, "node", node, "error", err)
+		return ""
+	}
+	zone := n.Labels[label]
+	nodeZones.Store(node, zone)
+	return zone
+}
+
+var (
+	localZoneOnce sync.Once
+	localZone     string
+)
+
+//LocalZone returns the zone of the proxy, the configured one or the zone of the node
+//of the proxy pod. The pod is read once, it runs in the namespace of the first cluster.
+func LocalZone(cfg *config.ClusterConfig) string {
+	if cfg.Locality.Zone != "" {
+		return cfg.Locality.Zone
+	}
+	localZoneOnce.Do(func() {
+		if util.KubeClient == nil {
+			return
+		}
+		//the pod name in kubernetes
+		name, _ := os.Hostname()
+		pod, err := util.KubeClient.CoreV1().Pods(cfg.NameSpace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			golog.Warn("locality", "LocalZone", "get proxy pod failed", 0, "pod", name, "error", err)
+			return
+		}
+		localZone = NodeZone(pod.Spec.NodeName, cfg.ZoneLabel())
+	})
+	return localZone
+}
+
+//Zone returns the zone of the backend, empty if not known.
+func (db *DB) Zone() string {
+	db.RLock()
+	defer db.RUnlock()
+	return db.zone
+}
+
+func (db *DB) SetZone(zone string) {
+	if zone == "" {
+		return
+	}
+	db.Lock()
+	defer db.Unlock()
+	db.zone = zone
+}
+
+//inZone reports whether the backend is in the zone, the proxy itself is in its own zone.
+func (db *DB) inZone(zone string) bool {
+	return db.Self || db.Zone() == zone
+}
+
+//SetLocality sets the zone of the proxy and how routing prefers its backends, before
+//statements are routed.
+func (cluster *Cluster) SetLocality(mode, zone string) {
+	if zone == "" && mode != "" && mode != config.LocalityNone {
+		golog.Warn("locality", "SetLocality", "zone of the proxy unknown, locality ignored", 0, "mode", mode)
+	}
+	for _, pool := range cluster.BackendPools {
+		pool.Lock()
+		pool.locality, pool.zone = mode, zone
+		pool.Unlock()
+	}
+}
+
+func (pool *Pool) localityOn() bool {
+	return pool.zone != "" && (pool.locality == config.LocalityPrefer || pool.locality == config.LocalityStrict)
+}
+
+//strictZone reports whether the statement is only routed to the zone of the proxy, as long
+//as the zone has an up backend.
+func (pool *Pool) strictZone(v *poolView) bool {
+	if pool.locality != config.LocalityStrict || pool.zone == "" {
+		return false
+	}
+	for _, db := range v.tidbs {
+		if atomic.LoadInt32(&db.state) == Up && db.inZone(pool.zone) {
+			return true
+		}
+	}
+	return false
+}
+
+//localize returns a backend in the zone of the proxy with the same weight as the picked
+//one, or the picked one if there is none.
+func (pool *Pool) localize(v *poolView, index int) *DB {
+	db := v.tidbs[index]
+	if !pool.localityOn() {
+		return db
+	}
+	if pool.locality == config.LocalityPrefer && !db.inZone(pool.zone) && index < len(v.weights) {
+		n := len(v.tidbs)
+		for i := 1; i < n; i++ {
+			j := (index + i) % n
+			local := v.tidbs[j]
+			if j < len(v.weights) && v.weights[j] == v.weights[index] && local.inZone(pool.zone) &&
+				atomic.LoadInt32(&local.state) == Up && !local.IsSchemaStale() && !local.busy() {
+				db = local
+				break
+			}
+		}
+	}
+	if db.inZone(pool.zone) {
+		metrics.ProxyZoneRouteCounter.WithLabelValues(routeLocal).Inc()
+	} else {
+		metrics.ProxyZoneRouteCounter.WithLabelValues(routeRemote).Inc()
+	}
+	return db
+}
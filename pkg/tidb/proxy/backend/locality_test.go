@@ -0,0 +1,70 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func zonePool(mode string) *Pool {
+	pool := &Pool{zone: "a", locality: mode}
+	pool.Tidbs = []*DB{
+		{addr: "tidb-0:4000", state: Up, zone: "a"},
+		{addr: "tidb-1:4000", state: Up, zone: "b"},
+		{addr: "tidb-2:4000", state: Up, zone: "b"},
+	}
+	pool.TidbsWeights = []float64{1, 1, 2}
+	pool.RoundRobinQ = order([]int{1, 1, 2})
+	pool.publish()
+	return pool
+}
+
+func pickCounts(t *testing.T, pool *Pool, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		db, _, err := pool.pick("qps")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[db.addr]++
+	}
+	return counts
+}
+
+func TestLocalityPrefer(t *testing.T) {
+	counts := pickCounts(t, zonePool(config.LocalityPrefer), 40)
+	if counts["tidb-1:4000"] != 0 || counts["tidb-0:4000"] != 20 || counts["tidb-2:4000"] != 20 {
+		t.Fatalf("remote backend of the same weight picked %v", counts)
+	}
+	counts = pickCounts(t, zonePool(config.LocalityNone), 40)
+	if counts["tidb-0:4000"] != 10 || counts["tidb-1:4000"] != 10 {
+		t.Fatalf("unexpected picks without locality %v", counts)
+	}
+}
+
+func TestLocalityStrict(t *testing.T) {
+	pool := zonePool(config.LocalityStrict)
+	if counts := pickCounts(t, pool, 20); counts["tidb-0:4000"] != 20 {
+		t.Fatalf("routed out of the zone %v", counts)
+	}
+	//other zones take the statements while the zone has no up backend
+	atomic.StoreInt32(&pool.Tidbs[0].state, Down)
+	if counts := pickCounts(t, pool, 20); counts["tidb-0:4000"] != 0 || counts["tidb-1:4000"] == 0 {
+		t.Fatalf("unexpected picks with the zone down %v", counts)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+//interval the delayed removal checks the long transactions of the tidb again
+var lockCheckInterval = 5 * time.Second
+
+//longTransactions reads the long transactions of a tidb, tests replace it
+var longTransactions = LongTransactions
+
+//LongTxn is a transaction open on a tidb for longer than the lock check age.
+type LongTxn struct {
+	ID      uint64
+	Session uint64
+	User    string
+	DB      string
+	Start   string
+	//keys written or pessimistically locked by the transaction
+	Keys int64
+}
+
+func longTxnsQuery(minAge time.Duration) string {
+	return fmt.Sprintf("SELECT ID, SESSION_ID, USER, DB, START_TIME, MEM_BUFFER_KEYS FROM information_schema.TIDB_TRX "+
+		"WHERE START_TIME < NOW() - INTERVAL %d SECOND ORDER BY START_TIME", int64(minAge/time.Second))
+}
+
+//LongTransactions returns the transactions open on the tidb for longer than minAge.
+func LongTransactions(addr, user, password string, minAge time.Duration) ([]LongTxn, error) {
+	co := new(Conn)
+	if err := co.Connect(addr, user, password, ""); err != nil {
+		return nil, err
+	}
+	defer co.Close()
+	r, err := co.exec(longTxnsQuery(minAge))
+	if err != nil {
+		return nil, err
+	}
+	txns := make([]LongTxn, 0, r.RowNumber())
+	for i := 0; i < r.RowNumber(); i++ {
+		var t LongTxn
+		t.ID, _ = r.GetUint(i, 0)
+		t.Session, _ = r.GetUint(i, 1)
+		t.User, _ = r.GetString(i, 2)
+		t.DB, _ = r.GetString(i, 3)
+		t.Start, _ = r.GetString(i, 4)
+		t.Keys, _ = r.GetInt(i, 5)
+		txns = append(txns, t)
+	}
+	return txns, nil
+}
+
+//longTxns returns the long transactions of the tidb, none if the lock check is off or the
+//tidb can't tell.
+func (cluster *Cluster) longTxns(addr string) []LongTxn {
+	cfg := cluster.Cfg.ScaleInLockCheck
+	if !cfg.Enable {
+		return nil
+	}
+	minAge := time.Duration(cfg.MinAge) * time.Second
+	if cfg.MinAge <= 0 {
+		minAge = config.DefaultLockCheckMinAge * time.Second
+	}
+	txns, err := longTransactions(addr, cluster.Cfg.User, cluster.Cfg.Password, minAge)
+	if err != nil {
+		golog.Warn("Cluster", "longTxns", "read transactions of tidb failed", 0,
+			"addr", addr, "error", err)
+		return nil
+	}
+	return txns
+}
+
+//vetoRemoval reports whether the removal of the tidb is refused for its long transactions.
+func (cluster *Cluster) vetoRemoval(addr string) bool {
+	if cluster.Cfg.ScaleInLockCheck.Action != config.LockCheckVeto {
+		return false
+	}
+	txns := cluster.longTxns(addr)
+	if len(txns) == 0 {
+		return false
+	}
+	metrics.ProxyScaleInLockCheckCounter.WithLabelValues("vetoed").Inc()
+	golog.Warn("Cluster", "vetoRemoval", "removal vetoed by long transactions", 0,
+		"addr", addr, "transactions", len(txns), "oldest_start", txns[0].Start,
+		"oldest_session", txns[0].Session, "oldest_user", txns[0].User)
+	return true
+}
+
+//delayRemoval waits for the long transactions of the removed tidb to finish, up to the max
+//delay. It reports whether it waited.
+func (cluster *Cluster) delayRemoval(addr string) bool {
+	cfg := cluster.Cfg.ScaleInLockCheck
+	if cfg.Action == config.LockCheckVeto {
+		return false
+	}
+	txns := cluster.longTxns(addr)
+	if len(txns) == 0 {
+		return false
+	}
+	maxDelay := time.Duration(cfg.MaxDelay) * time.Second
+	if cfg.MaxDelay <= 0 {
+		maxDelay = config.DefaultLockCheckMaxDelay * time.Second
+	}
+	golog.Info("Cluster", "delayRemoval", "wait for long transactions before removal", 0,
+		"addr", addr, "transactions", len(txns), "oldest_start", txns[0].Start, "max_delay", maxDelay.String())
+	deadline := time.Now().Add(maxDelay)
+	for len(txns) > 0 {
+		if time.Now().After(deadline) {
+			metrics.ProxyScaleInLockCheckCounter.WithLabelValues("timeout").Inc()
+			golog.Warn("Cluster", "delayRemoval", "long transactions left after max delay", 0,
+				"addr", addr, "transactions", len(txns))
+			return true
+		}
+		time.Sleep(lockCheckInterval)
+		txns = cluster.longTxns(addr)
+	}
+	metrics.ProxyScaleInLockCheckCounter.WithLabelValues("delayed").Inc()
+	return true
+}
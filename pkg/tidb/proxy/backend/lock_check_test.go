@@ -0,0 +1,96 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func TestLockCheck(t *testing.T) {
+	q := longTxnsQuery(90 * time.Second)
+	want := "SELECT ID, SESSION_ID, USER, DB, START_TIME, MEM_BUFFER_KEYS FROM information_schema.TIDB_TRX " +
+		"WHERE START_TIME < NOW() - INTERVAL 90 SECOND ORDER BY START_TIME"
+	if q != want {
+		t.Fatalf("unexpected query %q", q)
+	}
+
+	//the check is off, no tidb is asked
+	cluster := &Cluster{}
+	if cluster.vetoRemoval("127.0.0.1:1") || cluster.delayRemoval("127.0.0.1:1") {
+		t.Fatal("removal held with the lock check off")
+	}
+	//a tidb that can't answer is removed as without the check
+	cluster.Cfg.ScaleInLockCheck = config.LockCheckConfig{Enable: true, Action: config.LockCheckVeto}
+	if cluster.vetoRemoval("127.0.0.1:1") {
+		t.Fatal("removal vetoed for an unreachable tidb")
+	}
+}
+
+func TestDelayRemovalBeforeDrain(t *testing.T) {
+	interval, read := lockCheckInterval, longTransactions
+	defer func() {
+		lockCheckInterval, longTransactions = interval, read
+	}()
+	lockCheckInterval = 10 * time.Millisecond
+
+	removed := &DB{addr: "a", state: Up, dbType: TiDBForTP, usingConnsCount: 1}
+	pool := &Pool{
+		Tidbs:        []*DB{removed, {addr: "b", state: Up, dbType: TiDBForTP}},
+		TidbsWeights: []float64{4, 4},
+	}
+	cluster := &Cluster{
+		BackendPools: map[string]*Pool{TiDBForTP: pool},
+		ProxyNode:    &Proxy{},
+		DrainTimeout: time.Second,
+	}
+	cluster.Cfg.ScaleInLockCheck = config.LockCheckConfig{Enable: true, Action: config.LockCheckDelay}
+
+	//the batch job of the tidb finishes after three checks
+	var steps []string
+	var firstCheck time.Duration
+	start, open := time.Now(), 3
+	longTransactions = func(addr, user, password string, minAge time.Duration) ([]LongTxn, error) {
+		if len(steps) == 0 {
+			firstCheck = time.Since(start)
+		}
+		steps = append(steps, "check")
+		if open == 0 {
+			return nil, nil
+		}
+		open--
+		return []LongTxn{{ID: 1, Session: 7, Start: "2026-10-15 10:00:00"}}, nil
+	}
+	cluster.ForceRollback = func(addr string) int {
+		steps = append(steps, "rollback")
+		atomic.StoreInt64(&removed.usingConnsCount, 0)
+		return 1
+	}
+	if err := cluster.DeleteTidb("a", TiDBForTP); err != nil {
+		t.Fatalf("delete tidb: %v", err)
+	}
+	//the long transactions are waited for before the drain, only the conns left after the
+	//drain timeout are rolled back
+	if want := []string{"check", "check", "check", "check", "rollback"}; !reflect.DeepEqual(steps, want) {
+		t.Fatalf("removal steps %v, want %v", steps, want)
+	}
+	if firstCheck >= cluster.DrainTimeout {
+		t.Fatalf("long transactions checked %v after the removal, after the drain", firstCheck)
+	}
+}
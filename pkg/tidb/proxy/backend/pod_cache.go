@@ -0,0 +1,120 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/util"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//PodState is what kubernetes tells of the pod of a tidb
+type PodState int
+
+const (
+	//the pod can't be read, e.g. the api server is unreachable or there is no client
+	PodUnknown PodState = iota
+	PodFound
+	//the pod doesn't exist or is about to be deleted
+	PodAbsent
+)
+
+func (s PodState) String() string {
+	switch s {
+	case PodFound:
+		return "found"
+	case PodAbsent:
+		return "absent"
+	}
+	return "unknown"
+}
+
+type podEntry struct {
+	pod   *v1.Pod
+	state PodState
+	read  time.Time
+}
+
+//pods read from kubernetes by namespace/name, errors are never cached
+var podCache = struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]podEntry
+}{ttl: config.DefaultPodCacheTTL * time.Second, entries: make(map[string]podEntry)}
+
+//SetPodCacheTTL sets how long a pod read from kubernetes is used without reading it again.
+func SetPodCacheTTL(ttl int) {
+	podCache.Lock()
+	defer podCache.Unlock()
+	podCache.ttl = time.Duration(ttl) * time.Second
+	if ttl == 0 {
+		podCache.ttl = config.DefaultPodCacheTTL * time.Second
+	}
+}
+
+//LookupPod returns the pod and its state. A pod absent or about to be deleted is PodAbsent,
+//while PodUnknown tells the api server could not answer, then callers fail open and let
+//the health checks judge the tidb.
+func LookupPod(podName, namespace string, labels *config.PodLabelConfig) (*v1.Pod, PodState) {
+	if util.KubeClient == nil {
+		return nil, PodUnknown
+	}
+	key := namespace + "/" + podName
+	now := time.Now()
+	podCache.Lock()
+	e, ok := podCache.entries[key]
+	ttl := podCache.ttl
+	podCache.Unlock()
+	if ok && now.Sub(e.read) < ttl {
+		metrics.ProxyPodLookupCounter.WithLabelValues("cached").Inc()
+		return e.pod, e.state
+	}
+
+	e = podEntry{read: now}
+	pod, err := util.KubeClient.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		e.state = PodAbsent
+	case err != nil:
+		metrics.ProxyPodLookupCounter.WithLabelValues(PodUnknown.String()).Inc()
+		golog.Warn("Cluster", "LookupPod", "read pod failed", 0,
+			"pod", key, "error", err)
+		return nil, PodUnknown
+	case pod.Labels[labels.PredeleteKey] == "true":
+		e.pod, e.state = pod, PodAbsent
+	default:
+		e.pod, e.state = pod, PodFound
+	}
+	metrics.ProxyPodLookupCounter.WithLabelValues(e.state.String()).Inc()
+	podCache.Lock()
+	if ttl > 0 {
+		podCache.entries[key] = e
+	}
+	//pods gone for long are not kept forever
+	for k, old := range podCache.entries {
+		if now.Sub(old.read) >= ttl {
+			delete(podCache.entries, k)
+		}
+	}
+	podCache.Unlock()
+	return e.pod, e.state
+}
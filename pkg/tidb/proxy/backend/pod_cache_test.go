@@ -0,0 +1,83 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/util"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestLookupPod(t *testing.T) {
+	var reads int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reads, 1)
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		w.Header().Set("Content-Type", "application/json")
+		switch name {
+		case "tidb-0", "tidb-3":
+			pod := v1.Pod{TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"}}
+			if name == "tidb-3" {
+				pod.Labels = map[string]string{"predelete": "true"}
+			}
+			json.NewEncoder(w).Encode(pod)
+		case "tidb-1":
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := util.KubeClient
+	util.KubeClient = client
+	defer func() { util.KubeClient = prev }()
+	SetPodCacheTTL(60)
+	defer SetPodCacheTTL(0)
+
+	labels := &config.PodLabelConfig{PredeleteKey: "predelete"}
+	for name, want := range map[string]PodState{"tidb-0": PodFound, "tidb-1": PodAbsent, "tidb-2": PodUnknown, "tidb-3": PodAbsent} {
+		if _, state := LookupPod(name, "ns", labels); state != want {
+			t.Fatalf("%s is %s, want %s", name, state, want)
+		}
+	}
+	//found and absent pods are cached, api errors are read again
+	n := atomic.LoadInt32(&reads)
+	for _, name := range []string{"tidb-0", "tidb-1", "tidb-2"} {
+		LookupPod(name, "ns", labels)
+	}
+	if got := atomic.LoadInt32(&reads) - n; got != 1 {
+		t.Fatalf("%d pods read, want the unknown one only", got)
+	}
+	if GetOnePod("tidb-0", "ns", labels) == nil || GetOnePod("tidb-2", "ns", labels) != nil {
+		t.Fatal("unexpected pods")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"sync/atomic"
+)
+
+//poolView is an immutable copy of the backends of a pool. Writers change Tidbs,
+//TidbsWeights and RoundRobinQ under the pool lock and publish a new view, statements
+//are routed from the current view without taking the lock.
+type poolView struct {
+	tidbs       []*DB
+	weights     []float64
+	roundRobinQ []int
+}
+
+var emptyPoolView = &poolView{}
+
+func (pool *Pool) view() *poolView {
+	if v, ok := pool.current.Load().(*poolView); ok {
+		return v
+	}
+	return emptyPoolView
+}
+
+//publish swaps in a copy of the backends, the caller holds the pool lock.
+func (pool *Pool) publish() {
+	pool.current.Store(&poolView{
+		tidbs:       append([]*DB(nil), pool.Tidbs...),
+		weights:     append([]float64(nil), pool.TidbsWeights...),
+		roundRobinQ: append([]int(nil), pool.RoundRobinQ...),
+	})
+}
+
+//Reset drops every backend of the pool.
+func (pool *Pool) Reset() {
+	pool.Lock()
+	defer pool.Unlock()
+	pool.Tidbs = nil
+	pool.TidbsWeights = nil
+	pool.RoundRobinQ = nil
+	pool.publish()
+}
+
+//Backends returns the backends statements are routed to, without taking the pool lock.
+//The slice is shared and must not be modified.
+func (pool *Pool) Backends() []*DB {
+	return pool.view().tidbs
+}
+
+//Weights returns the backends and their weights from the same view, without taking the
+//pool lock. The slices are shared and must not be modified.
+func (pool *Pool) Weights() ([]*DB, []float64) {
+	v := pool.view()
+	return v.tidbs, v.weights
+}
+
+//pick returns the next backend for a statement and the number of backends of the pool.
+func (pool *Pool) pick(indicator string) (*DB, int, error) {
+	v := pool.view()
+	if len(v.tidbs) == 1 {
+		return v.tidbs[0], 1, nil
+	}
+	db, err := pool.nextDB(v, indicator)
+	return db, len(v.tidbs), err
+}
+
+//nextIndex moves the round robin cursor shared by the routing goroutines.
+func (pool *Pool) nextIndex(queueLen int) int {
+	return int((atomic.AddUint64(&pool.lastIndex, 1) - 1) % uint64(queueLen))
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func testPool(n int) *Pool {
+	pool := new(Pool)
+	sws := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		pool.Tidbs = append(pool.Tidbs, &DB{addr: fmt.Sprintf("tidb-%d:4000", i), state: Up})
+		pool.TidbsWeights = append(pool.TidbsWeights, float64(i%3+1))
+		sws = append(sws, i%3+1)
+	}
+	pool.RoundRobinQ = order(sws)
+	pool.publish()
+	return pool
+}
+
+func TestPoolPickSkipsDownBackends(t *testing.T) {
+	pool := testPool(3)
+	atomic.StoreInt32(&pool.Tidbs[1].state, Down)
+	for i := 0; i < 12; i++ {
+		db, n, err := pool.pick("qps")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Fatalf("expect 3 backends, got %d", n)
+		}
+		if db == pool.Tidbs[1] {
+			t.Fatalf("routed to down backend %s", db.addr)
+		}
+	}
+}
+
+func TestPoolViewIsCopied(t *testing.T) {
+	pool := testPool(2)
+	v := pool.view()
+	pool.Lock()
+	pool.Tidbs[0] = &DB{addr: "replaced:4000", state: Up}
+	pool.Unlock()
+	if v.tidbs[0].addr == "replaced:4000" {
+		t.Fatal("published view changed without publish")
+	}
+	pool.Reset()
+	if _, _, err := pool.pick("qps"); err == nil {
+		t.Fatal("expect error on empty pool")
+	}
+}
+
+func TestPoolWeights(t *testing.T) {
+	pool := testPool(2)
+	tidbs, weights := pool.Weights()
+	pool.Lock()
+	pool.TidbsWeights[1] = 8
+	pool.Unlock()
+	if len(tidbs) != 2 || len(weights) != 2 || weights[1] != 2 {
+		t.Fatalf("unexpected view %v %v", tidbs, weights)
+	}
+	pool.Lock()
+	pool.publish()
+	pool.Unlock()
+	if _, weights = pool.Weights(); weights[1] != 8 {
+		t.Fatalf("expect the published weight 8, got %v", weights[1])
+	}
+}
+
+func TestDbOfPod(t *testing.T) {
+	tp, ap := testPool(2), new(Pool)
+	ap.Tidbs = []*DB{{addr: "cluster-ap-0.cluster-ap-peer.ns.svc:4000", state: Up}}
+	cluster := &Cluster{BackendPools: map[string]*Pool{TiDBForTP: tp, TiDBForAP: ap}}
+	if ty, _, db := cluster.dbOfPod("cluster-ap-0"); ty != TiDBForAP || db != ap.Tidbs[0] {
+		t.Fatalf("expect ap backend of the pod, got %s %v", ty, db)
+	}
+	if ty, _, db := cluster.dbOfPod("tidb-1:4000"); ty != TiDBForTP || db != tp.Tidbs[1] {
+		t.Fatalf("expect tp backend of the addr, got %s %v", ty, db)
+	}
+	if _, _, db := cluster.dbOfPod("cluster-tp-9"); db != nil {
+		t.Fatalf("expect no backend, got %s", db.addr)
+	}
+}
+
+func benchmarkPick(b *testing.B, churn bool) {
+	pool := testPool(8)
+	stop := make(chan struct{})
+	if churn {
+		//add and remove a backend in a loop, like scaling in and out
+		go func() {
+			extra := &DB{addr: "extra:4000", state: Up}
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				pool.Lock()
+				if i%2 == 0 {
+					pool.Tidbs = append(pool.Tidbs, extra)
+					pool.RoundRobinQ = append(pool.RoundRobinQ, len(pool.Tidbs)-1)
+				} else {
+					pool.Tidbs = pool.Tidbs[:len(pool.Tidbs)-1]
+					pool.RoundRobinQ = pool.RoundRobinQ[:len(pool.RoundRobinQ)-1]
+				}
+				pool.publish()
+				pool.Unlock()
+			}
+		}()
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := pool.pick("qps"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.StopTimer()
+	close(stop)
+}
+
+func BenchmarkPoolPick(b *testing.B) {
+	benchmarkPick(b, false)
+}
+
+func BenchmarkPoolPickDuringMembershipChange(b *testing.B) {
+	benchmarkPick(b, true)
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/mysql"
+	"github.com/pingcap/tidb/proxy/scaler"
+)
+
+//checks of the preflight, the one a tidb fails is the reason of its rejection
+const (
+	PreflightConnect  = "connect"
+	PreflightAuth     = "auth"
+	PreflightVersion  = "version"
+	PreflightLatency  = "latency"
+	PreflightVariable = "variable"
+)
+
+var preflightVariableName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+//PreflightError is a tidb rejected by the preflight.
+type PreflightError struct {
+	Addr   string
+	Reason string
+	Err    error
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("tidb %s rejected by preflight %s check: %v", e.Addr, e.Reason, e.Err)
+}
+
+//Preflight checks the tidb on one connection before it is added to a pool: the auth,
+//SELECT version(), a sample of ping latencies and the required global variables.
+func Preflight(addr, user, password string, cfg config.PreflightConfig) error {
+	reject := func(reason string, err error) error {
+		return &PreflightError{Addr: addr, Reason: reason, Err: err}
+	}
+	co := new(Conn)
+	if err := co.Connect(addr, user, password, ""); err != nil {
+		if e, ok := err.(*mysql.SqlError); ok && e.Code == mysql.ER_ACCESS_DENIED_ERROR {
+			return reject(PreflightAuth, err)
+		}
+		return reject(PreflightConnect, err)
+	}
+	defer co.Close()
+
+	r, err := co.exec("SELECT version()")
+	if err != nil {
+		return reject(PreflightVersion, err)
+	}
+	if version, err := r.GetString(0, 0); err != nil || version == "" {
+		return reject(PreflightVersion, fmt.Errorf("no version returned, %v", err))
+	}
+
+	pings := cfg.Pings
+	if pings <= 0 {
+		pings = config.DefaultPreflightPings
+	}
+	samples := make([]time.Duration, 0, pings)
+	for i := 0; i < pings; i++ {
+		start := time.Now()
+		if err = co.Ping(); err != nil {
+			return reject(PreflightConnect, err)
+		}
+		samples = append(samples, time.Since(start))
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	maxLatency := time.Duration(cfg.MaxLatency) * time.Millisecond
+	if cfg.MaxLatency == 0 {
+		maxLatency = config.DefaultPreflightMaxLatency * time.Millisecond
+	}
+	if median := samples[len(samples)/2]; maxLatency > 0 && median > maxLatency {
+		return reject(PreflightLatency, fmt.Errorf("median ping %s over %s", median, maxLatency))
+	}
+
+	names := make([]string, 0, len(cfg.Variables))
+	for name := range cfg.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !preflightVariableName.MatchString(name) {
+			return reject(PreflightVariable, fmt.Errorf("invalid variable name %q", name))
+		}
+		r, err := co.exec("SELECT @@GLOBAL." + name)
+		if err != nil {
+			return reject(PreflightVariable, err)
+		}
+		value, _ := r.GetString(0, 0)
+		if want := cfg.Variables[name]; !strings.EqualFold(value, want) {
+			return reject(PreflightVariable, fmt.Errorf("%s is %q, want %q", name, value, want))
+		}
+	}
+	return nil
+}
+
+//preflight runs the preflight of the tidb unless it is disabled, a rejection is logged
+//and recorded in the scale history.
+func (cluster *Cluster) preflight(addr, tidbType string) error {
+	if cluster.Cfg.Preflight.Disable {
+		return nil
+	}
+	err := Preflight(addr, cluster.Cfg.User, cluster.Cfg.Password, cluster.Cfg.Preflight)
+	if err == nil {
+		metrics.ProxyPreflightCounter.WithLabelValues("ok").Inc()
+		return nil
+	}
+	reason := PreflightConnect
+	if e, ok := err.(*PreflightError); ok {
+		reason = e.Reason
+	}
+	metrics.ProxyPreflightCounter.WithLabelValues(reason).Inc()
+	golog.Warn("Cluster", "preflight", "tidb rejected by preflight", 0,
+		"addr", addr, "tidbtype", tidbType, "reason", reason, "error", err)
+	scaler.Default().RecordRejected(addr, tidbType, reason, err)
+	return err
+}
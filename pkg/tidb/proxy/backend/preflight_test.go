@@ -0,0 +1,64 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package backend
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/server"
+)
+
+func TestPreflightConnectRejected(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	err = Preflight(addr, "root", "", config.PreflightConfig{})
+	e, ok := err.(*PreflightError)
+	if !ok {
+		t.Fatalf("expect PreflightError, got %v", err)
+	}
+	if e.Addr != addr || e.Reason != PreflightConnect {
+		t.Fatalf("expect %s rejected by %s, got %s by %s", addr, PreflightConnect, e.Addr, e.Reason)
+	}
+}
+
+func TestAddTidbSkipsRejected(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	pool := new(Pool)
+	cluster := &Cluster{BackendPools: map[string]*Pool{TiDBForTP: pool}, ProxyNode: &Proxy{}}
+	cluster.Cfg.Discovery.Type = config.DiscoveryStatic
+	//the tidb rejected by preflight doesn't keep the rest of the batch out
+	err = cluster.AddTidb([]*server.NewTidb{{Addr: addr, TidbType: TiDBForTP}, {Addr: "self", TidbType: TiDBForTP}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Tidbs) != 1 || !pool.Tidbs[0].Self || len(pool.TidbsWeights) != 1 {
+		t.Fatalf("expect only self added, got %d tidbs", len(pool.Tidbs))
+	}
+	if err = cluster.AddTidb([]*server.NewTidb{{Addr: addr, TidbType: TiDBForTP}}); err == nil {
+		t.Fatal("expect the error of the rejected tidb")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+//upBackends returns the up dedicated backends of the pool other than addr, and whether
+//addr is one of the up ones. The caller holds the pool lock.
+func (pool *Pool) upBackends(addr string) (int, bool) {
+	var up int
+	var isUp bool
+	for _, db := range pool.Tidbs {
+		if db == nil || db.Self || atomic.LoadInt32(&db.state) != Up {
+			continue
+		}
+		if db.addr == addr {
+			isUp = true
+			continue
+		}
+		up++
+	}
+	return up, isUp
+}
+
+//CheckScaleIn returns a ScaleInError if the pool left with up backends breaks an invariant:
+//the tp pool keeps an up backend unless the proxy is compute, and no pool goes below its
+//min replicas. The rejection is logged and counted for alerting.
+func (cluster *Cluster) CheckScaleIn(tidbType, addr string, up int) error {
+	var err error
+	min, _ := cluster.Cfg.ReplicaBounds(tidbType)
+	switch {
+	case tidbType == TiDBForTP && up == 0 && (cluster.ProxyNode == nil || !cluster.ProxyNode.ProxyAsCompute):
+		err = errors.ErrLastUpBackend
+	case min > 0 && up < min:
+		err = errors.ErrBelowMinReplicas
+	default:
+		return nil
+	}
+	metrics.ProxyScaleInRejectedCounter.WithLabelValues(tidbType).Inc()
+	golog.Error("Cluster", "CheckScaleIn", "scale in rejected", 0,
+		"cluster", cluster.Cfg.ClusterName, "tidbtype", tidbType, "addr", addr,
+		"up", up, "min_replicas", min, "error", err)
+	return errors.NewScaleInError(tidbType, addr, up, err)
+}
+
+//checkRemoval checks the invariants of the pool without the backend, removing a backend
+//that is not up leaves the up ones as they are. The caller holds the pool lock, so
+//concurrent removals can't pass the check together.
+func (cluster *Cluster) checkRemoval(pool *Pool, addr, tidbType string) error {
+	up, isUp := pool.upBackends(addr)
+	if !isUp {
+		return nil
+	}
+	return cluster.CheckScaleIn(tidbType, addr, up)
+}
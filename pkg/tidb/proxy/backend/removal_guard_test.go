@@ -0,0 +1,57 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	goerrors "errors"
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+func TestCheckRemoval(t *testing.T) {
+	pool := &Pool{Tidbs: []*DB{
+		{addr: "a", state: Up},
+		{addr: "b", state: Down},
+		{addr: "self", Self: true, state: Up},
+	}}
+	cluster := &Cluster{
+		BackendPools: map[string]*Pool{TiDBForTP: pool},
+		ProxyNode:    &Proxy{},
+	}
+	//the last up tp tidb while the proxy doesn't compute
+	err := cluster.checkRemoval(pool, "a", TiDBForTP)
+	if !errors.IsScaleInRejected(err) || !goerrors.Is(err, errors.ErrLastUpBackend) {
+		t.Fatalf("removal of the last up tp tidb: %v", err)
+	}
+	//a down tidb leaves the up ones as they are
+	if err = cluster.checkRemoval(pool, "b", TiDBForTP); err != nil {
+		t.Fatalf("removal of a down tidb: %v", err)
+	}
+	cluster.ProxyNode.ProxyAsCompute = true
+	if err = cluster.checkRemoval(pool, "a", TiDBForTP); err != nil {
+		t.Fatalf("removal of the last up tp tidb with the proxy as compute: %v", err)
+	}
+
+	cluster.Cfg.Replicas = map[string]config.ReplicaConfig{TiDBForTP: {MinReplicas: 1}}
+	err = cluster.checkRemoval(pool, "a", TiDBForTP)
+	if !goerrors.Is(err, errors.ErrBelowMinReplicas) {
+		t.Fatalf("removal below min replicas: %v", err)
+	}
+	if err = cluster.CheckScaleIn(TiDBForTP, "", 1); err != nil {
+		t.Fatalf("scale in to min replicas: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/failpoint"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/util"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	//min interval between looking up the host of a backend after connection errors
+	resolveMinInterval = time.Second
+	//wait before watching the pods again after the watch fails
+	podWatchRetry = 5 * time.Second
+
+	ipChangeByResolve = "resolve"
+	ipChangeByWatch   = "watch"
+)
+
+func ipOf(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	return ""
+}
+
+//RemoteIP returns the ip the conn is connected to, empty for unix sockets.
+func (c *Conn) RemoteIP() string {
+	return c.remoteIP
+}
+
+//IP returns the ip the backend is known at, empty if not known yet.
+func (db *DB) IP() string {
+	db.RLock()
+	defer db.RUnlock()
+	return db.ip
+}
+
+//SetIP records the ip the backend is at now, pooled conns to another ip are recycled.
+//It reports whether the ip changed.
+func (db *DB) SetIP(ip, source string) bool {
+	if ip == "" {
+		return false
+	}
+	db.Lock()
+	old := db.ip
+	db.ip = ip
+	db.Unlock()
+	if old == ip || old == "" {
+		return false
+	}
+	metrics.ProxyBackendIPChangeCounter.WithLabelValues(source).Inc()
+	n := db.recycleStale()
+	golog.Info("db", "SetIP", "backend ip changed", 0,
+		"addr", db.addr, "old", old, "new", ip, "source", source, "recycled", n)
+	return true
+}
+
+//isStale reports whether the conn is bound to an ip the backend is no longer at.
+func (db *DB) isStale(co *Conn) bool {
+	if co == nil || co.remoteIP == "" {
+		return false
+	}
+	ip := db.IP()
+	return ip != "" && co.remoteIP != ip
+}
+
+//recycleStale closes the cached conns bound to an old ip, their slots go back to idle
+//so they are dialed again. Conns in use are recycled when they are pushed back.
+func (db *DB) recycleStale() int {
+	cacheConns := db.getCacheConns()
+	if cacheConns == nil {
+		return 0
+	}
+	var n int
+	for i := len(cacheConns); i > 0; i-- {
+		var co *Conn
+		select {
+		case co = <-cacheConns:
+		default:
+			return n
+		}
+		if db.isStale(co) {
+			db.closeConnNotAdd(co)
+			n++
+			continue
+		}
+		select {
+		case cacheConns <- co:
+		default:
+			db.closeConnNotAdd(co)
+		}
+	}
+	return n
+}
+
+//resolve looks up the host of the backend again after a connection error, a pod
+//restarted under the same name comes back at another ip.
+func (db *DB) resolve() {
+	if db.Self {
+		return
+	}
+	host, _, err := net.SplitHostPort(db.addr)
+	if err != nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&db.lastResolve)
+	if now-last < int64(resolveMinInterval) || !atomic.CompareAndSwapInt64(&db.lastResolve, last, now) {
+		return
+	}
+	go func() {
+		ips, err := net.LookupHost(host)
+		if err != nil || len(ips) == 0 {
+			golog.Warn("db", "resolve", "lookup backend host failed", 0, "addr", db.addr, "error", err)
+			return
+		}
+		cur := db.IP()
+		for _, ip := range ips {
+			if ip == cur {
+				return
+			}
+		}
+		db.SetIP(ips[0], ipChangeByResolve)
+	}()
+}
+
+//dbsOfPod returns the backends served by the pod.
+func (cluster *Cluster) dbsOfPod(podName, namespace string) []*DB {
+	var dbs []*DB
+	for _, pool := range cluster.BackendPools {
+		pool.RLock()
+		for _, db := range pool.Tidbs {
+			if db.Self {
+				continue
+			}
+			if name, ns, ok := podOfAddr(db.addr); ok && name == podName && ns == namespace {
+				dbs = append(dbs, db)
+			}
+		}
+		pool.RUnlock()
+	}
+	return dbs
+}
+
+//WatchPods follows the tidb pods of the cluster and moves a backend to the new ip of its
+//pod as soon as it restarts, before the conns to the old ip fail.
+func (cluster *Cluster) WatchPods() {
+	if util.KubeClient == nil || cluster.Cfg.DiscoveryType() != config.DiscoveryK8s {
+		return
+	}
+	selector := cluster.Cfg.PodLabels().ClusterSelector(cluster.Cfg.ClusterName)
+	for cluster.Online {
+		w, err := util.KubeClient.CoreV1().Pods(cluster.Cfg.NameSpace).Watch(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			golog.Warn("cluster", "WatchPods", "watch pods failed", 0, "selector", selector, "error", err)
+			time.Sleep(podWatchRetry)
+			continue
+		}
+		for event := range w.ResultChan() {
+			if event.Type != watch.Added && event.Type != watch.Modified {
+				continue
+			}
+			pod, ok := event.Object.(*v1.Pod)
+			if !ok || pod.Status.PodIP == "" {
+				continue
+			}
+			failpoint.Inject("proxyPodWatchDelay", func(val failpoint.Value) {
+				if ms, ok := val.(int); ok {
+					time.Sleep(time.Duration(ms) * time.Millisecond)
+				}
+			})
+			for _, db := range cluster.dbsOfPod(pod.Name, pod.Namespace) {
+				db.SetIP(pod.Status.PodIP, ipChangeByWatch)
+			}
+		}
+		w.Stop()
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import "testing"
+
+func TestRecycleStale(t *testing.T) {
+	db := &DB{addr: "tidb-0.tidb-peer.ns:4000", cacheConns: make(chan *Conn, 4), idleConns: make(chan *Conn, 4)}
+	if db.SetIP("10.0.0.1", ipChangeByResolve) {
+		t.Fatal("first ip of the backend reported as a change")
+	}
+	old, cur, unix := &Conn{remoteIP: "10.0.0.1"}, &Conn{remoteIP: "10.0.0.2"}, &Conn{}
+	if db.isStale(old) || db.isStale(unix) || db.isStale(nil) {
+		t.Fatal("conn to the current ip is stale")
+	}
+	db.cacheConns <- old
+	db.cacheConns <- cur
+	db.cacheConns <- unix
+
+	if db.SetIP("10.0.0.1", ipChangeByResolve) {
+		t.Fatal("same ip reported as a change")
+	}
+	if !db.SetIP("10.0.0.2", ipChangeByWatch) {
+		t.Fatal("ip change not reported")
+	}
+	if !db.isStale(old) || db.isStale(cur) || db.isStale(unix) {
+		t.Fatal("stale conns not told apart after the ip changed")
+	}
+	//only the conn to the old ip is closed, its slot goes back to idle
+	if len(db.cacheConns) != 2 || len(db.idleConns) != 1 || <-db.idleConns != old {
+		t.Fatalf("%d cached and %d idle conns after recycle, want 2 and 1", len(db.cacheConns), len(db.idleConns))
+	}
+	for i := 0; i < 2; i++ {
+		if co := <-db.cacheConns; co == old {
+			t.Fatal("conn to the old ip kept in the cache")
+		}
+	}
+}
+
+func TestDBsOfPod(t *testing.T) {
+	tp := &Pool{Tidbs: []*DB{{Self: true, addr: "tidb-0.tidb-peer.ns:4000"}, {addr: "tidb-0.tidb-peer.ns:4000"}, {addr: "tidb-1.tidb-peer.ns:4000"}}}
+	ap := &Pool{Tidbs: []*DB{{addr: "tidb-0.tidb-peer.other:4000"}}}
+	cluster := &Cluster{BackendPools: map[string]*Pool{TiDBForTP: tp, TiDBForAP: ap}}
+	if dbs := cluster.dbsOfPod("tidb-0", "ns"); len(dbs) != 1 || dbs[0] != tp.Tidbs[1] {
+		t.Fatalf("unexpected backends of the pod %v", dbs)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	goerrors "errors"
+	"fmt"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+//ResultLimit caps the result set read from the backend, 0 means no limit.
+type ResultLimit struct {
+	MaxRows  int64
+	MaxBytes int64
+	//return the rows read so far instead of an error
+	Truncate bool
+}
+
+func (l ResultLimit) exceeded(rows, bytes int64) bool {
+	return (l.MaxRows > 0 && rows > l.MaxRows) || (l.MaxBytes > 0 && bytes > l.MaxBytes)
+}
+
+//resultTooLargeError is the error of a result set over the limit. The client gets a query
+//interrupted error, the proxy tells it apart from the interrupts of the backends, e.g. by
+//KILL QUERY or max_execution_time, by errors.ErrResultTooLarge.
+type resultTooLargeError struct {
+	*mysql.SqlError
+}
+
+func (e *resultTooLargeError) Unwrap() error {
+	return e.SqlError
+}
+
+func (e *resultTooLargeError) Is(target error) bool {
+	return target == errors.ErrResultTooLarge
+}
+
+//ErrResultTooLarge reports the result set is over the limit of the statement.
+func ErrResultTooLarge(l ResultLimit) error {
+	return &resultTooLargeError{mysql.NewError(mysql.ER_QUERY_INTERRUPTED,
+		fmt.Sprintf("result set exceeds the proxy limit of %d rows or %d bytes", l.MaxRows, l.MaxBytes))}
+}
+
+//IsResultTooLarge reports whether err is returned for a result set over the limit.
+func IsResultTooLarge(err error) bool {
+	return goerrors.Is(err, errors.ErrResultTooLarge)
+}
+
+//SetResultLimit caps the result sets of the following statements, the zero value removes it.
+func (c *Conn) SetResultLimit(l ResultLimit) {
+	c.resultLimit = l
+}
+
+//abortResult stops reading a result set over the limit. The conn is closed so the backend
+//stops sending, unless a transaction is open on it, then the rest of the rows are dropped.
+func (c *Conn) abortResult() error {
+	if c.IsInTransaction() {
+		return c.readUntilEOF()
+	}
+	c.conn.Close()
+	c.pkgErr = ErrResultTooLarge(c.resultLimit)
+	return nil
+}
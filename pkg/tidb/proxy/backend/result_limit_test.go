@@ -0,0 +1,38 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	goerrors "errors"
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+func TestIsResultTooLarge(t *testing.T) {
+	err := ErrResultTooLarge(ResultLimit{MaxRows: 10})
+	if !IsResultTooLarge(err) {
+		t.Fatal("result limit error not recognized")
+	}
+	//the client still gets a query interrupted error
+	var sqlErr *mysql.SqlError
+	if !goerrors.As(err, &sqlErr) || sqlErr.Code != mysql.ER_QUERY_INTERRUPTED {
+		t.Fatalf("unexpected client error %v", err)
+	}
+	//KILL QUERY or max_execution_time on the backend
+	if IsResultTooLarge(mysql.NewError(mysql.ER_QUERY_INTERRUPTED, "Query execution was interrupted")) {
+		t.Fatal("interrupt of the backend taken for a result over the limit")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+//RouteCandidate is a backend of the pool a statement is routed to, with its load.
+type RouteCandidate struct {
+	Addr        string
+	State       string
+	Weight      float64
+	UsingConns  int64
+	Cost        int64
+	Overloaded  bool
+	SchemaStale bool
+}
+
+//RoutePlan is where a statement would be routed, Choice is the addr of the backend
+//or empty when Err tells why the statement can't be routed.
+type RoutePlan struct {
+	Pool       string
+	Candidates []RouteCandidate
+	Choice     string
+	Err        error
+}
+
+//ExplainRoute returns the pool and the backend the next statement of the cost would be
+//routed to, like getConn but without taking a conn or moving the round robin cursor.
+//A pod pins the statement to its backend, empty ty means the pool is chosen by the cost.
+func (cluster *Cluster) ExplainRoute(ty, pod string, cost int64) *RoutePlan {
+	if ty == "" && pod == "" {
+		ty = CostClass(cost)
+	}
+	plan := &RoutePlan{Pool: ty}
+	if !cluster.Initialized() {
+		plan.Err = errors.ErrClusterInitializing
+		return plan
+	}
+	if pod != "" {
+		ty, _, db := cluster.dbOfPod(pod)
+		if db == nil {
+			plan.Err = errors.ErrTidbNotExist
+			return plan
+		}
+		plan.Pool = ty
+		plan.Candidates = []RouteCandidate{cluster.routeCandidate(db, db.weight)}
+		if atomic.LoadInt32(&db.state) == Down || db.injectedDown() {
+			plan.Err = errors.NewPoolError(ty, errors.ErrTidbDown)
+		} else {
+			plan.Choice = db.addr
+		}
+		return plan
+	}
+	if ty == BigCost {
+		//a temporary big tidb is started for the statement
+		return plan
+	}
+	ty, err := cluster.routableType(ty)
+	plan.Pool = ty
+	if err != nil {
+		plan.Err = errors.NewPoolError(ty, err)
+		return plan
+	}
+	pool := cluster.BackendPools[ty]
+	if pool == nil {
+		plan.Err = errors.NewPoolError(ty, errors.ErrNoTidbDB)
+		return plan
+	}
+	v := pool.view()
+	for i, db := range v.tidbs {
+		var weight float64
+		if i < len(v.weights) {
+			weight = v.weights[i]
+		}
+		plan.Candidates = append(plan.Candidates, cluster.routeCandidate(db, weight))
+	}
+	db := pool.peek(v, cluster.ConcurrencyPerCore)
+	if db == nil {
+		plan.Err = errors.NewPoolError(ty, errors.ErrNoTidbDB)
+	} else if atomic.LoadInt32(&db.state) == Down || db.injectedDown() {
+		plan.Err = errors.NewPoolError(ty, errors.ErrTidbDown)
+	} else {
+		plan.Choice = db.addr
+	}
+	return plan
+}
+
+func (cluster *Cluster) routeCandidate(db *DB, weight float64) RouteCandidate {
+	return RouteCandidate{
+		Addr:        db.addr,
+		State:       db.State(),
+		Weight:      weight,
+		UsingConns:  atomic.LoadInt64(&db.usingConnsCount),
+		Cost:        atomic.LoadInt64(&db.costs),
+		Overloaded:  !db.Self && db.IsOverloaded(cluster.ConcurrencyPerCore),
+		SchemaStale: db.IsSchemaStale(),
+	}
+}
+
+//peek returns the backend pick would return next, preferring the backends under their
+//concurrency cap as getConn does.
+func (pool *Pool) peek(v *poolView, perCore int) *DB {
+	if len(v.tidbs) == 1 {
+		return v.tidbs[0]
+	}
+	queueLen := len(v.roundRobinQ)
+	if queueLen == 0 {
+		return nil
+	}
+	start := int(atomic.LoadUint64(&pool.lastIndex) % uint64(queueLen))
+	var first, stale *DB
+	for i := 0; i < queueLen; i++ {
+		index := v.roundRobinQ[(start+i)%queueLen]
+		if index >= len(v.tidbs) {
+			return nil
+		}
+		db := v.tidbs[index]
+		if atomic.LoadInt32(&db.state) != Up {
+			continue
+		}
+		if db.IsSchemaStale() {
+			if stale == nil {
+				stale = db
+			}
+			continue
+		}
+		if db.Self || !db.IsOverloaded(perCore) {
+			return db
+		}
+		if first == nil {
+			first = db
+		}
+	}
+	if first != nil {
+		return first
+	}
+	return stale
+}
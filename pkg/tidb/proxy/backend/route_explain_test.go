@@ -0,0 +1,52 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package backend
+
+import (
+	"testing"
+)
+
+func TestPoolPeekMatchesPick(t *testing.T) {
+	pool := testPool(3)
+	for i := 0; i < 12; i++ {
+		want := pool.peek(pool.view(), 0)
+		if again := pool.peek(pool.view(), 0); again != want {
+			t.Fatalf("peek moved the cursor, %s then %s", want.addr, again.addr)
+		}
+		db, _, err := pool.pick("qps")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db != want {
+			t.Fatalf("expect %s picked, got %s", want.addr, db.addr)
+		}
+	}
+}
+
+func TestPoolPeekSkipsOverloaded(t *testing.T) {
+	pool := testPool(2)
+	busy := pool.peek(pool.view(), 1)
+	busy.weight = 1
+	busy.usingConnsCount = 1
+	if db := pool.peek(pool.view(), 1); db == busy {
+		t.Fatalf("expect overloaded %s skipped", busy.addr)
+	}
+	for _, db := range pool.Tidbs {
+		db.weight = 1
+		db.usingConnsCount = 1
+	}
+	if db := pool.peek(pool.view(), 1); db != busy {
+		t.Fatalf("expect %s queued on when every backend is overloaded", busy.addr)
+	}
+}
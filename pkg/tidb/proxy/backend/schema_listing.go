@@ -0,0 +1,41 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+//FetchListing runs the SHOW DATABASES or SHOW TABLES statement in db over a fresh conn to
+//the preferred tidb, or else the first up tp tidb, and returns its rows with the address
+//of the tidb. The resultset is nil without error when no tidb is up.
+func (cluster *Cluster) FetchListing(preferred, db, query string) (*mysql.Resultset, string, error) {
+	conn, _, err := cluster.dialFresh(preferred)
+	if err != nil || conn == nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+	if err = conn.UseDB(db); err != nil {
+		return nil, "", err
+	}
+	r, err := conn.exec(query)
+	if err != nil {
+		return nil, "", err
+	}
+	if r.Resultset == nil {
+		return nil, "", mysql.NewError(mysql.ER_UNKNOWN_ERROR, "result is empty")
+	}
+	return r.Resultset, conn.GetAddr(), nil
+}
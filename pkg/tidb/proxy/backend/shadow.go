@@ -0,0 +1,268 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+//errors of the shadow tidb kept for /proxy/shadow
+const shadowErrorsKept = 32
+
+//Shadow mirrors read only statements to a shadow tidb in the background. The results are
+//discarded and the errors recorded, so a new tidb version or tiflash setup is validated
+//under the real traffic without the clients waiting for it or seeing its errors.
+type Shadow struct {
+	addr     string
+	user     string
+	password string
+	percent  float64
+	//a statement runs on the shadow tidb for at most timeout
+	timeout time.Duration
+
+	queue chan shadowStmt
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+
+	mirrored int64
+	failed   int64
+	dropped  int64
+
+	mu   sync.Mutex
+	errs []ShadowError
+}
+
+type shadowStmt struct {
+	db  string
+	sql string
+}
+
+//ShadowError is a statement that failed on the shadow tidb, after it passed on the pools.
+type ShadowError struct {
+	Time  int64  `json:"time"`
+	DB    string `json:"db"`
+	SQL   string `json:"sql"`
+	Error string `json:"error"`
+}
+
+//ShadowStats is served by /proxy/shadow.
+type ShadowStats struct {
+	Addr     string        `json:"addr"`
+	Percent  float64       `json:"percent"`
+	Mirrored int64         `json:"mirrored"`
+	Failed   int64         `json:"failed"`
+	Dropped  int64         `json:"dropped"`
+	Queued   int           `json:"queued"`
+	Errors   []ShadowError `json:"errors"`
+}
+
+//NewShadow starts the workers mirroring to the shadow tidb, it returns nil if shadowing is
+//disabled. The user and password of the cluster are taken if the config has none.
+func NewShadow(cfg config.ShadowConfig, user, password string) *Shadow {
+	if cfg.Addr == "" || cfg.Percent <= 0 {
+		return nil
+	}
+	if cfg.User != "" {
+		user, password = cfg.User, cfg.Password
+	}
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = config.DefaultShadowWorkers
+	}
+	size := cfg.QueueSize
+	if size <= 0 {
+		size = config.DefaultShadowQueueSize
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if cfg.Timeout <= 0 {
+		timeout = config.DefaultShadowTimeout * time.Second
+	}
+	s := &Shadow{
+		addr:     cfg.Addr,
+		user:     user,
+		password: password,
+		percent:  cfg.Percent,
+		timeout:  timeout,
+		queue:    make(chan shadowStmt, size),
+		done:     make(chan struct{}),
+	}
+	s.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	golog.Info("Shadow", "NewShadow", "mirror read only statements", 0,
+		"addr", s.addr, "percent", s.percent, "workers", workers)
+	return s
+}
+
+//Sampled reports whether the next statement is mirrored.
+func (s *Shadow) Sampled() bool {
+	return s != nil && rand.Float64()*100 < s.percent
+}
+
+//Mirror queues the statement for the shadow tidb, it is dropped if the workers lag behind.
+func (s *Shadow) Mirror(db, sql string) {
+	select {
+	case s.queue <- shadowStmt{db: db, sql: sql}:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+		metrics.ProxyShadowCounter.WithLabelValues("dropped").Inc()
+	}
+}
+
+//work runs the queued statements on its own connection, dialed again after a failure of
+//the connection.
+func (s *Shadow) work() {
+	defer s.wg.Done()
+	var co *Conn
+	defer func() {
+		if co != nil {
+			co.Close()
+		}
+	}()
+	for {
+		var st shadowStmt
+		select {
+		case <-s.done:
+			return
+		case st = <-s.queue:
+		}
+		if co == nil {
+			var err error
+			if co, err = s.connect(); err != nil {
+				co = nil
+				s.fail(st, err)
+				continue
+			}
+		}
+		start := time.Now()
+		//the shadow tidb stops the statement at max_execution_time, the deadline covers
+		//the statements it doesn't stop and a shadow tidb that doesn't answer
+		co.deadline = start.Add(s.timeout + time.Second)
+		err := co.UseDB(st.db)
+		if err == nil {
+			err = co.discard(st.sql)
+		}
+		atomic.AddInt64(&s.mirrored, 1)
+		if err == nil {
+			metrics.ProxyShadowCounter.WithLabelValues("ok").Inc()
+			metrics.ProxyShadowLatencyHistogram.Observe(time.Since(start).Seconds())
+			continue
+		}
+		s.fail(st, err)
+		if _, ok := err.(*mysql.SqlError); !ok {
+			co.Close()
+			co = nil
+		}
+	}
+}
+
+//connect dials the shadow tidb, the statements of the conn run for at most the timeout.
+func (s *Shadow) connect() (*Conn, error) {
+	co := new(Conn)
+	if err := co.Connect(s.addr, s.user, s.password, ""); err != nil {
+		return nil, err
+	}
+	co.deadline = time.Now().Add(s.timeout)
+	if _, err := co.exec(fmt.Sprintf("SET SESSION max_execution_time = %d", s.timeout.Milliseconds())); err != nil {
+		co.Close()
+		return nil, err
+	}
+	return co, nil
+}
+
+//discard runs the query and reads its result set without keeping it, the rows of the
+//mirrored statements may be many.
+func (c *Conn) discard(query string) error {
+	if err := c.writeCommandStr(mysql.COM_QUERY, query); err != nil {
+		return err
+	}
+	data, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	switch data[0] {
+	case mysql.OK_HEADER:
+		_, err = c.handleOKPacket(data)
+		return err
+	case mysql.ERR_HEADER:
+		return c.handleErrorPacket(data)
+	case mysql.LocalInFile_HEADER:
+		return mysql.ErrMalformPacket
+	}
+	//the column definitions end with an eof, then the rows
+	if err = c.readUntilEOF(); err != nil {
+		return err
+	}
+	for {
+		data, err = c.readPacket()
+		if err != nil {
+			return err
+		}
+		if c.isEOFPacket(data) {
+			return nil
+		}
+		//the statement failed while sending its rows, e.g. at max_execution_time
+		if data[0] == mysql.ERR_HEADER {
+			return c.handleErrorPacket(data)
+		}
+	}
+}
+
+func (s *Shadow) fail(st shadowStmt, err error) {
+	atomic.AddInt64(&s.failed, 1)
+	metrics.ProxyShadowCounter.WithLabelValues("failed").Inc()
+	s.mu.Lock()
+	if len(s.errs) == shadowErrorsKept {
+		s.errs = s.errs[1:]
+	}
+	s.errs = append(s.errs, ShadowError{Time: time.Now().Unix(), DB: st.db, SQL: st.sql, Error: err.Error()})
+	s.mu.Unlock()
+}
+
+//Stats returns the counters and the last errors of the shadow tidb.
+func (s *Shadow) Stats() ShadowStats {
+	s.mu.Lock()
+	errs := append([]ShadowError{}, s.errs...)
+	s.mu.Unlock()
+	return ShadowStats{
+		Addr:     s.addr,
+		Percent:  s.percent,
+		Mirrored: atomic.LoadInt64(&s.mirrored),
+		Failed:   atomic.LoadInt64(&s.failed),
+		Dropped:  atomic.LoadInt64(&s.dropped),
+		Queued:   len(s.queue),
+		Errors:   errs,
+	}
+}
+
+//Close stops the workers, the statements still queued are not mirrored.
+func (s *Shadow) Close() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() { close(s.done) })
+	s.wg.Wait()
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	goerrors "errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+func TestShadowDisabled(t *testing.T) {
+	if NewShadow(config.ShadowConfig{Percent: 10}, "u", "p") != nil {
+		t.Fatal("shadow without addr")
+	}
+	if NewShadow(config.ShadowConfig{Addr: "127.0.0.1:1"}, "u", "p") != nil {
+		t.Fatal("shadow without percent")
+	}
+	var s *Shadow
+	if s.Sampled() {
+		t.Fatal("nil shadow sampled")
+	}
+	s.Close()
+}
+
+func TestShadowMirror(t *testing.T) {
+	//nothing listens on the shadow tidb, every statement fails to connect
+	s := NewShadow(config.ShadowConfig{Addr: "127.0.0.1:1", Percent: 100, Workers: 1}, "u", "p")
+	defer s.Close()
+	if !s.Sampled() {
+		t.Fatal("statement not sampled at 100 percent")
+	}
+	s.Mirror("test", "SELECT 1")
+	deadline := time.Now().Add(10 * time.Second)
+	for s.Stats().Failed == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("failure of the shadow tidb not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	st := s.Stats()
+	if len(st.Errors) != 1 || st.Errors[0].SQL != "SELECT 1" || st.Errors[0].DB != "test" {
+		t.Fatalf("unexpected errors %+v", st.Errors)
+	}
+}
+
+func TestShadowDropsOverQueue(t *testing.T) {
+	//no worker takes the statements
+	s := &Shadow{queue: make(chan shadowStmt, 1)}
+	s.Mirror("test", "SELECT 1")
+	s.Mirror("test", "SELECT 2")
+	if st := s.Stats(); st.Dropped != 1 || st.Queued != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	for i := 0; i < shadowErrorsKept+5; i++ {
+		s.fail(shadowStmt{sql: "SELECT 3"}, goerrors.New("shadow tidb down"))
+	}
+	if st := s.Stats(); len(st.Errors) != shadowErrorsKept {
+		t.Fatalf("%d errors kept", len(st.Errors))
+	}
+}
+
+//serveRows answers the query with a result set of one column and the rows, ended by last.
+func serveRows(conn net.Conn, rows int, last []byte) {
+	pkg := mysql.NewPacketIO(conn)
+	if _, err := pkg.ReadPacket(); err != nil {
+		return
+	}
+	packets := [][]byte{{1}, []byte("column"), {mysql.EOF_HEADER, 0, 0, 2, 0}}
+	for i := 0; i < rows; i++ {
+		packets = append(packets, []byte{3, 'r', 'o', 'w'})
+	}
+	for _, p := range append(packets, last) {
+		if err := pkg.WritePacket(append(make([]byte, 4), p...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestShadowDiscard(t *testing.T) {
+	newConn := func() (*Conn, net.Conn) {
+		client, server := net.Pipe()
+		return &Conn{conn: client, pkg: mysql.NewPacketIO(client), capability: mysql.CLIENT_PROTOCOL_41}, server
+	}
+	co, server := newConn()
+	go serveRows(server, 1000, []byte{mysql.EOF_HEADER, 0, 0, 2, 0})
+	if err := co.discard("SELECT * FROM t"); err != nil {
+		t.Fatalf("discard the rows: %v", err)
+	}
+	co.Close()
+	server.Close()
+
+	//killed at max_execution_time after some rows
+	co, server = newConn()
+	go serveRows(server, 10, append([]byte{mysql.ERR_HEADER, 0xd0, 0x0b, '#', 'H', 'Y', '0', '0', '0'},
+		"Query execution was interrupted"...))
+	err := co.discard("SELECT * FROM t")
+	var sqlErr *mysql.SqlError
+	if !goerrors.As(err, &sqlErr) || sqlErr.Code != 3024 {
+		t.Fatalf("interrupted statement: %v", err)
+	}
+	co.Close()
+	server.Close()
+
+	//the shadow tidb doesn't answer
+	co, server = newConn()
+	defer server.Close()
+	go mysql.NewPacketIO(server).ReadPacket()
+	co.deadline = time.Now().Add(100 * time.Millisecond)
+	err = co.discard("SELECT SLEEP(100)")
+	if err == nil || goerrors.As(err, &sqlErr) {
+		t.Fatalf("statement past the deadline: %v", err)
+	}
+	co.Close()
+}
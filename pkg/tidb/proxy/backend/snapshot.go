@@ -0,0 +1,138 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//Snapshot is the membership and the manual states of the pools, saved so a restarted
+//proxy serves before it discovers the backends again.
+type Snapshot struct {
+	Cluster string                   `json:"cluster"`
+	Time    int64                    `json:"time"`
+	Pools   map[string]*PoolSnapshot `json:"pools"`
+}
+
+type PoolSnapshot struct {
+	Cordoned bool           `json:"cordoned"`
+	Tidbs    []TidbSnapshot `json:"tidbs"`
+}
+
+//TidbSnapshot is a backend of the pool, the proxy itself is left out.
+type TidbSnapshot struct {
+	Addr       string  `json:"addr"`
+	Weight     float64 `json:"weight"`
+	ManualDown bool    `json:"manual_down,omitempty"`
+}
+
+//Snapshot takes the snapshot of the pools.
+func (cluster *Cluster) Snapshot() *Snapshot {
+	snap := &Snapshot{
+		Cluster: cluster.Cfg.ClusterName,
+		Time:    time.Now().Unix(),
+		Pools:   make(map[string]*PoolSnapshot, len(cluster.BackendPools)),
+	}
+	for ty, pool := range cluster.BackendPools {
+		ps := &PoolSnapshot{Cordoned: pool.IsCordoned(), Tidbs: []TidbSnapshot{}}
+		pool.RLock()
+		for _, db := range pool.Tidbs {
+			if db.Self {
+				continue
+			}
+			ps.Tidbs = append(ps.Tidbs, TidbSnapshot{
+				Addr:       db.addr,
+				Weight:     db.weight,
+				ManualDown: atomic.LoadInt32(&db.state) == ManualDown,
+			})
+		}
+		pool.RUnlock()
+		snap.Pools[ty] = ps
+	}
+	return snap
+}
+
+//SamePools reports whether the pools of the snapshots are the same, whenever taken.
+func (snap *Snapshot) SamePools(other *Snapshot) bool {
+	return other != nil && reflect.DeepEqual(snap.Pools, other.Pools)
+}
+
+//Tidbs returns the backends of the pool as addr@weight, the format ParseTidbs takes.
+func (snap *Snapshot) Tidbs(ty string) []string {
+	ps, ok := snap.Pools[ty]
+	if !ok {
+		return nil
+	}
+	tidbs := make([]string, 0, len(ps.Tidbs))
+	for _, tidb := range ps.Tidbs {
+		tidbs = append(tidbs, tidb.Addr+WeightSplit+strconv.FormatFloat(tidb.Weight, 'f', -1, 64))
+	}
+	return tidbs
+}
+
+//ApplyStates cordons the pools and takes down the backends manually taken down when the
+//snapshot was saved, the pools are filled from the snapshot before.
+func (cluster *Cluster) ApplyStates(snap *Snapshot) {
+	for ty, ps := range snap.Pools {
+		pool, ok := cluster.BackendPools[ty]
+		if !ok {
+			continue
+		}
+		if ps.Cordoned {
+			pool.Cordon()
+		}
+		for _, tidb := range ps.Tidbs {
+			if tidb.ManualDown {
+				pool.DownTidb(tidb.Addr, ManualDown)
+			}
+		}
+	}
+}
+
+//LoadSnapshot reads the snapshot saved in the file, a missing file returns nil.
+func LoadSnapshot(fileName string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snap := new(Snapshot)
+	if err = json.Unmarshal(data, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+//Save writes the snapshot to the file.
+func (snap *Snapshot) Save(fileName string) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	//write a temp file and rename it, so a crash doesn't leave half a file
+	tmp := fileName + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
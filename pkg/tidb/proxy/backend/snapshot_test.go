@@ -0,0 +1,68 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotSaveLoad(t *testing.T) {
+	tp := testPool(2)
+	tp.Tidbs = append(tp.Tidbs, &DB{addr: "self", Self: true})
+	tp.Tidbs[0].weight = 4
+	tp.Tidbs[1].weight = 2.5
+	tp.Tidbs[1].state = ManualDown
+	ap := new(Pool)
+	ap.Cordon()
+	cluster := &Cluster{BackendPools: map[string]*Pool{TiDBForTP: tp, TiDBForAP: ap}}
+	cluster.Cfg.ClusterName = "c1"
+
+	snap := cluster.Snapshot()
+	if got, want := snap.Tidbs(TiDBForTP), []string{"tidb-0:4000@4", "tidb-1:4000@2.5"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expect tidbs %v, got %v", want, got)
+	}
+	if !snap.Pools[TiDBForTP].Tidbs[1].ManualDown || !snap.Pools[TiDBForAP].Cordoned {
+		t.Fatalf("manual states are not kept: %+v %+v", snap.Pools[TiDBForTP], snap.Pools[TiDBForAP])
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "pools.json")
+	if loaded, err := LoadSnapshot(file); err != nil || loaded != nil {
+		t.Fatalf("expect no snapshot before save, got %v %v", loaded, err)
+	}
+	if err = snap.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSnapshot(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Cluster != "c1" || !loaded.SamePools(snap) {
+		t.Fatalf("loaded snapshot differs: %+v", loaded)
+	}
+
+	tp.Tidbs[0].weight = 8
+	if cluster.Snapshot().SamePools(snap) {
+		t.Fatal("expect weight change to change the snapshot")
+	}
+}
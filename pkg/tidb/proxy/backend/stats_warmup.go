@@ -0,0 +1,103 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/stats"
+)
+
+func quoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+//statsWarmupQueries returns the warm-up queries of the tables, in the order of the tables.
+func statsWarmupQueries(cfg config.StatsWarmupConfig, tables []stats.Table) []string {
+	queries := cfg.Queries
+	if len(queries) == 0 {
+		queries = []string{config.DefaultStatsWarmupQuery}
+	}
+	sqls := make([]string, 0, len(tables)*len(queries))
+	for _, t := range tables {
+		name := quoteName(t.Schema) + "." + quoteName(t.Name)
+		for _, q := range queries {
+			sqls = append(sqls, strings.Replace(q, "{table}", name, -1))
+		}
+	}
+	return sqls
+}
+
+//warmStats loads the statistics of the hottest tables of the pool on a new tidb, so its
+//optimizer has them for the first statements it gets. A failing query is only logged, the
+//tidb is added once the queries pass or the timeout expires, a query hung past the timeout
+//fails by the deadline of the conn. It runs before the pool of the tidb is locked.
+func (cluster *Cluster) warmStats(addr, tidbType string) {
+	cfg := cluster.Cfg.StatsWarmup
+	if !cfg.Enable {
+		return
+	}
+	n := cfg.Tables
+	if n <= 0 {
+		n = config.DefaultStatsWarmupTables
+	}
+	tables := stats.HotTables(stats.DefaultTop().Digests(tidbType, 0), n)
+	if len(tables) == 0 {
+		return
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = config.DefaultStatsWarmupTimeout * time.Second
+	}
+	start := time.Now()
+	co := new(Conn)
+	if err := co.Connect(addr, cluster.Cfg.User, cluster.Cfg.Password, ""); err != nil {
+		golog.Warn("Cluster", "warmStats", "connect tidb failed", 0, "addr", addr, "error", err)
+		return
+	}
+	defer co.Close()
+	co.deadline = start.Add(timeout)
+
+	var ok, failed int
+	sqls := statsWarmupQueries(cfg, tables)
+	for i, sql := range sqls {
+		if time.Since(start) >= timeout {
+			metrics.ProxyStatsWarmupCounter.WithLabelValues("timeout").Add(float64(len(sqls) - i))
+			break
+		}
+		if _, err := co.exec(sql); err != nil {
+			failed++
+			metrics.ProxyStatsWarmupCounter.WithLabelValues("failed").Inc()
+			golog.Warn("Cluster", "warmStats", "stats warm-up query failed", 0,
+				"addr", addr, "sql", sql, "error", err)
+			if co.pkgErr != nil {
+				if time.Since(start) >= timeout {
+					metrics.ProxyStatsWarmupCounter.WithLabelValues("timeout").Add(float64(len(sqls) - i - 1))
+				}
+				break
+			}
+			continue
+		}
+		ok++
+		metrics.ProxyStatsWarmupCounter.WithLabelValues("ok").Inc()
+	}
+	golog.Info("Cluster", "warmStats", "stats warm-up done", 0,
+		"addr", addr, "tidbtype", tidbType, "tables", len(tables),
+		"ok", ok, "failed", failed, "elapsed", time.Since(start).String())
+}
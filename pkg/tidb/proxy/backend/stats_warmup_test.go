@@ -0,0 +1,36 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/stats"
+)
+
+func TestStatsWarmupQueries(t *testing.T) {
+	tables := []stats.Table{{Schema: "shop", Name: "orders"}, {Schema: "crm", Name: "we`ird"}}
+	sqls := statsWarmupQueries(config.StatsWarmupConfig{}, tables)
+	want := []string{"EXPLAIN SELECT * FROM `shop`.`orders`", "EXPLAIN SELECT * FROM `crm`.`we``ird`"}
+	if len(sqls) != len(want) || sqls[0] != want[0] || sqls[1] != want[1] {
+		t.Fatalf("unexpected default warm-up queries %q", sqls)
+	}
+	cfg := config.StatsWarmupConfig{Queries: []string{"SELECT 1 FROM {table} LIMIT 1", "EXPLAIN SELECT COUNT(*) FROM {table}"}}
+	sqls = statsWarmupQueries(cfg, tables[:1])
+	if len(sqls) != 2 || sqls[0] != "SELECT 1 FROM `shop`.`orders` LIMIT 1" || sqls[1] != "EXPLAIN SELECT COUNT(*) FROM `shop`.`orders`" {
+		t.Fatalf("unexpected warm-up queries %q", sqls)
+	}
+}
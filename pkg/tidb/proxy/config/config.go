@@ -15,7 +15,9 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
+	"strconv"
 
 	"gopkg.in/yaml.v2"
 )
@@ -34,8 +36,355 @@ type Config struct {
 
 	Charset string        `yaml:"proxy_charset"`
 	Cluster ClusterConfig `yaml:"clusters"`
+	//tidb clusters of other tenants served besides the cluster, a session is routed to the
+	//cluster of its current database
+	Tenants TenantsConfig `yaml:"tenants"`
+
+	//for analytics endpoint, connections on this addr always go to ap pool
+	ApAddr string `yaml:"ap_addr"`
+
+	//X Protocol listener, connections are relayed to mysqlx_backend if it is set,
+	//otherwise they get a capability error
+	MysqlxAddr    string `yaml:"mysqlx_addr"`
+	MysqlxBackend string `yaml:"mysqlx_backend"`
+
+	//seconds, client connections idle or older than this are closed, 0 means no limit
+	ClientIdleTimeout int `yaml:"client_idle_timeout"`
+	ClientMaxLifetime int `yaml:"client_max_lifetime"`
+	//seconds a client may take to read a result set relayed from a backend, then the statement
+	//fails and the backend connection is released, 0 means no limit
+	ClientWriteTimeout int `yaml:"client_write_timeout"`
+
+	//max client connections of a user and of a client ip, 0 means no limit. The users
+	//and ips listed get their own limits instead
+	MaxUserConnections   int            `yaml:"max_user_connections"`
+	MaxHostConnections   int            `yaml:"max_host_connections"`
+	UserConnectionLimits map[string]int `yaml:"user_connection_limits"`
+	HostConnectionLimits map[string]int `yaml:"host_connection_limits"`
+
+	//seconds a client has to complete the handshake and auth, 0 means
+	//DefaultHandshakeTimeout, negative means no limit
+	HandshakeTimeout int `yaml:"handshake_timeout"`
+	//block client ips after repeated auth failures
+	AuthThrottle AuthThrottleConfig `yaml:"auth_throttle"`
+	//token buckets on new client connections, checked when they are accepted before the
+	//handshake, so a reconnect storm doesn't use up file descriptors and goroutines
+	AcceptLimit AcceptLimitConfig `yaml:"accept_limit"`
+	//log in clients by their tls certificate instead of a password
+	CertAuth CertAuthConfig `yaml:"cert_auth"`
+	//rsa key and fast auth cache of clients logging in by caching_sha2_password
+	CachingSha2 CachingSha2Config `yaml:"caching_sha2"`
+
+	//seconds, idle sessions without transaction or temporary tables release their bound
+	//backend connection and get one again on the next statement, 0 means never
+	SessionSuspendIdle int `yaml:"session_suspend_idle"`
+
+	//seconds the results of selects reading only system variables, sent by drivers and
+	//orms on every connect, are answered by the proxy, 0 means no cache
+	MetadataCacheTTL int `yaml:"metadata_cache_ttl"`
+
+	//statement digests whose plan cost is kept for routing, so the statements forwarded to
+	//a backend are not compiled by the proxy. The least recently used one is dropped when
+	//full and all are dropped when the schema changes. 0 means no cache
+	RouteCacheSize int `yaml:"route_cache_size"`
+
+	//milliseconds, sessions waiting longer than this for an execution token of the
+	//token-limit are logged with the digest of their statement, 0 means no log
+	TokenWaitLogThreshold int `yaml:"token_wait_log_threshold"`
+
+	//statement digests kept per pool for /proxy/top-queries, the least frequent one is
+	//replaced when full. 0 means stats.DefaultTopSize, negative means disable
+	TopQueries int `yaml:"top_queries"`
+
+	//file the usage of every user is flushed to and loaded from on start, for showback
+	//reports of /proxy/user-stats. Empty means the usage is kept in memory only
+	UserStatsFile string `yaml:"user_stats_file"`
+	//seconds between flushes of the user usage, 0 means DefaultUserStatsFlushInterval
+	UserStatsFlushInterval int `yaml:"user_stats_flush_interval"`
+	//seconds of a slice of the workload report comparing statements run in the proxy with
+	//forwarded ones, 0 means DefaultOriginReportInterval
+	OriginReportInterval int `yaml:"origin_report_interval"`
+
+	//how statements are costed for routing, by their plan cost or calibrated by latency
+	CostEstimator CostEstimatorConfig `yaml:"cost_estimator"`
+
+	//multi-statement batches are split and each statement is routed by its own cost,
+	//disable it to route the whole batch to the pool of its first statement
+	DisableMultiStmtSplit bool `yaml:"disable_multi_stmt_split"`
+	//COM_PING, SELECT 1 and /* ping */ probes of load balancers and connection pools are
+	//answered by the proxy without a token or a backend, and don't count as client qps.
+	//Disable it to run them like other statements
+	DisableLocalProbes bool `yaml:"disable_local_probes"`
+
+	//MB, max file size of LOAD DATA LOCAL INFILE relayed to backend, 0 means no limit
+	MaxLoadDataSize int64 `yaml:"max_load_data_size"`
+
+	//reply ER_SERVER_SHUTDOWN to new connections while the proxy is shutting down
+	RejectOnShutdown bool `yaml:"reject_on_shutdown"`
+	//errors of statements whose backend failed mid-query end with the pod of the backend and
+	//an id that is also logged by the proxy
+	ErrorContext bool `yaml:"error_context"`
+	//what the proxy does while it lost the connection to pd, reject closes new connections
+	//and kills existing sessions, degrade keeps existing sessions and accepts new sessions
+	//that are read only until pd is back. Empty means reject
+	PDLossPolicy string `yaml:"pd_loss_policy"`
+
+	//reject writes while serving reads, it can be switched at runtime by admin sql and
+	///proxy/read-only
+	ReadOnly ReadOnlyConfig `yaml:"read_only"`
+
+	//serve /fail/ on the status port to inject failures for chaos tests, e.g. PUT
+	///fail/github.com/pingcap/tidb/proxy/backend/proxyBackendDown with return("*").
+	//It takes effect only in binaries built after make failpoint-enable
+	EnableFailpoints bool `yaml:"enable_failpoints"`
+	//where /api/v1/capture writes its logs and whether /api/v1/replay may run them
+	Capture CaptureConfig `yaml:"capture"`
+
+	//tag backend sessions with client connection attributes in @proxy_conn_attrs
+	ForwardConnAttrs bool `yaml:"forward_conn_attrs"`
+	//tell backends the client ip, attr adds client_ip to @proxy_conn_attrs, proxy_protocol
+	//sends PROXY protocol v2 on conns dialed for every client ip, empty means disable
+	ForwardClientIP string `yaml:"forward_client_ip"`
+	//label statement metrics by the application of the client
+	AppMetrics AppMetricsConfig `yaml:"app_metrics"`
+	//priority of statements on backends by user and routing class
+	Priority PriorityConfig `yaml:"priority"`
+
+	//mysql protocol compression with clients and backends
+	Compression CompressionConfig `yaml:"compression"`
+
+	Scaler ScalerConfig `yaml:"scaler"`
+	//proxy replicas behind one service elect a leader by a kubernetes lease, only the leader
+	//sends scale requests, and the connection limits and the scaling cost count every replica
+	Coordination CoordinationConfig `yaml:"coordination"`
+	//transactions open longer than a threshold are listed, alerted and optionally rolled back
+	LongTxn LongTxnConfig `yaml:"long_txn"`
+
+	//service mesh sidecar of the proxy pod, e.g. envoy of istio
+	Sidecar SidecarConfig `yaml:"sidecar"`
+}
+
+const DefaultHandshakeTimeout = 10
+
+const DefaultUserStatsFlushInterval = 60
+
+const DefaultOriginReportInterval = 300
+
+//capture logs are plain file names in dir, an empty dir means no capture. Replays are off
+//unless replay is set, they only go to the tidbs of the cluster and skip the statements
+//that write unless replay_writes is set and the request asks for them
+type CaptureConfig struct {
+	Dir          string `yaml:"dir"`
+	Replay       bool   `yaml:"replay"`
+	ReplayWrites bool   `yaml:"replay_writes"`
+}
+
+//a client ip failing max_failures auths within window seconds is blocked for block_time
+//seconds, 0 max_failures means never block
+type AuthThrottleConfig struct {
+	MaxFailures int `yaml:"max_failures"`
+	//0 means DefaultAuthFailureWindow
+	Window int `yaml:"window"`
+	//0 means DefaultAuthBlockTime
+	BlockTime int `yaml:"block_time"`
+}
+
+//new connections accepted per second by the proxy and by one client ip, 0 rate means no
+//limit. A burst of 0 means the rate, rounded up
+type AcceptLimitConfig struct {
+	Rate       float64 `yaml:"rate"`
+	Burst      int     `yaml:"burst"`
+	PerIPRate  float64 `yaml:"per_ip_rate"`
+	PerIPBurst int     `yaml:"per_ip_burst"`
+}
+
+const (
+	DefaultAuthFailureWindow = 60
+	DefaultAuthBlockTime     = 300
+)
+
+//plan routes by the plan cost, observe compares the plan cost with the actual cost of
+//every digest and exports the error, calibrate also corrects the plan cost of a digest
+//by the factor learned from its statements
+type CostEstimatorConfig struct {
+	Mode string `yaml:"mode"`
+	//statements of a digest before its cost is corrected, 0 means estimator.DefaultMinSamples
+	MinSamples int `yaml:"min_samples"`
+	//digests calibrated, 0 means estimator.DefaultMaxDigests
+	MaxDigests int `yaml:"max_digests"`
+}
+
+const (
+	CostEstimatorPlan      = "plan"
+	CostEstimatorObserve   = "observe"
+	CostEstimatorCalibrate = "calibrate"
+)
+
+//INSERT, UPDATE, DELETE, LOAD DATA and DDL are rejected unless the user is exempt
+type ReadOnlyConfig struct {
+	Enable bool `yaml:"enable"`
+	//shown in the error of rejected writes
+	Reason string `yaml:"reason"`
+	//users whose writes are still served, e.g. the user migrating data
+	ExemptUsers []string `yaml:"exempt_users"`
+}
+
+//a client with a verified certificate matched by a mapping logs in as the user of the
+//mapping without password, the certificates are verified by the ssl-ca of the proxy
+type CertAuthConfig struct {
+	//the first mapping matched by the certificate is taken
+	Mappings []CertMappingConfig `yaml:"mappings"`
+	//x509 rejects clients without a certificate matched by a mapping, san also requires
+	//the mapping to match by san. Empty means clients without one log in by password
+	Require string `yaml:"require"`
+}
+
+//a mapping matches a certificate with all of its subject and sans
+type CertMappingConfig struct {
+	//e.g. /C=US/O=acme/CN=billing, empty matches any subject
+	Subject string `yaml:"subject"`
+	//e.g. URI:spiffe://cluster.local/ns/billing/sa/api, DNS:api.billing, every type
+	//listed has to match one of its values. Empty matches any san
+	SAN  string `yaml:"san"`
+	User string `yaml:"user"`
+}
+
+const (
+	CertRequireX509 = "x509"
+	CertRequireSAN  = "san"
+)
+
+//clients without tls send the password of the full auth encrypted by the rsa public key,
+//the pem files are generated on start if empty. Clients pinning the public key need the files
+type CachingSha2Config struct {
+	PrivateKey string `yaml:"private_key"`
+	//empty means the public key of the private key
+	PublicKey string `yaml:"public_key"`
+	//bits of the generated key, 0 means DefaultCachingSha2KeyBits
+	KeyBits int `yaml:"key_bits"`
+	//accounts whose last full auth is cached for the fast auth, 0 means
+	//DefaultCachingSha2CacheSize, negative means every auth is a full auth
+	CacheSize int `yaml:"cache_size"`
+}
+
+const (
+	DefaultCachingSha2KeyBits   = 2048
+	DefaultCachingSha2CacheSize = 10000
+)
+
+//zlib and zstd protocol compression
+type CompressionConfig struct {
+	//advertise CLIENT_COMPRESS and CLIENT_ZSTD_COMPRESSION_ALGORITHM to clients
+	Enable bool `yaml:"enable"`
+	//zlib level of the replies to clients, zstd takes the level the client asks, 0 means the default
+	ZlibLevel int `yaml:"zlib_level"`
+	//zlib or zstd toward backends that advertise it, empty means no compression
+	Backend string `yaml:"backend"`
+	//level toward backends, 0 means the default of the algorithm
+	BackendLevel int `yaml:"backend_level"`
+}
+
+//leases of the replicas in the namespace of the cluster, the leader lease is
+//<cluster>-proxy-leader and every replica renews <cluster>-proxy-<pod> with its counters
+type CoordinationConfig struct {
+	Enable bool `yaml:"enable"`
+	//seconds, 0 means DefaultCoordinationLeaseDuration, DefaultCoordinationRenewDeadline and
+	//DefaultCoordinationRetryPeriod
+	LeaseDuration int `yaml:"lease_duration"`
+	RenewDeadline int `yaml:"renew_deadline"`
+	RetryPeriod   int `yaml:"retry_period"`
+	//seconds between two syncs of the counters with the other replicas, 0 means
+	//DefaultCoordinationSyncInterval
+	SyncInterval int `yaml:"sync_interval"`
+}
+
+const (
+	DefaultCoordinationLeaseDuration = 15
+	DefaultCoordinationRenewDeadline = 10
+	DefaultCoordinationRetryPeriod   = 2
+	DefaultCoordinationSyncInterval  = 5
+)
+
+//a transaction older than threshold is posted once to the webhooks, one idle in transaction
+//longer than idle_kill is rolled back so it doesn't block scale in and gc
+type LongTxnConfig struct {
+	//seconds, 0 means DefaultLongTxnThreshold
+	Threshold int `yaml:"threshold"`
+	//seconds a session may stay idle in a transaction, 0 means never rolled back
+	IdleKill int `yaml:"idle_kill"`
+	//seconds between two checks, 0 means DefaultLongTxnInterval
+	Interval int `yaml:"interval"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+const (
+	DefaultLongTxnThreshold = 60
+	DefaultLongTxnInterval  = 5
+)
+
+//grpc client of the scale operator
+type ScalerConfig struct {
+	Addr string `yaml:"addr"`
+	//more scaler endpoints, e.g. the pods of a headless service, calls fail over to the
+	//next endpoint in order when one is unavailable
+	Addrs []string `yaml:"addrs"`
+	//seconds between health probes of the endpoints, 0 means DefaultProbeInterval of the
+	//scaler, negative means never probe
+	ProbeInterval int `yaml:"probe_interval"`
+	//seconds
+	DialTimeout int `yaml:"dial_timeout"`
+	CallTimeout int `yaml:"call_timeout"`
+	//retries for unavailable scaler, backoff in milliseconds and doubled every retry
+	MaxRetries   int `yaml:"max_retries"`
+	RetryBackoff int `yaml:"retry_backoff"`
+	//seconds, 0 means disable keepalive
+	KeepaliveTime    int `yaml:"keepalive_time"`
+	KeepaliveTimeout int `yaml:"keepalive_timeout"`
+	//mTLS, empty ca means insecure
+	CA         string `yaml:"ca"`
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	ServerName string `yaml:"server_name"`
+	//scale rpcs per second and burst, 0 means no limit
+	RateLimit float64 `yaml:"rate_limit"`
+	RateBurst int     `yaml:"rate_burst"`
+	//scale rpcs kept for the status api
+	AuditSize int `yaml:"audit_size"`
+	//every scale decision is posted to the webhooks
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	//the pools are scaled by an external autoscaler like a kubernetes hpa or keda reading the
+	//scale metrics, the proxy computes the desired cores but sends no scale request
+	External bool `yaml:"external"`
+}
+
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	//key of the hmac-sha256 signature of the body, empty means not signed
+	Secret string `yaml:"secret"`
+	//seconds, 0 means the default
+	Timeout int `yaml:"timeout"`
+	//0 means the default, negative means no retry
+	MaxRetries int `yaml:"max_retries"`
 }
 
+//the proxy waits for the sidecar before dialing backends and keeps it running until the
+//client connections are drained, otherwise dials fail at pod start and stop in meshed namespaces
+type SidecarConfig struct {
+	//readiness url of the sidecar, e.g. http://127.0.0.1:15021/healthz/ready, empty means no sidecar
+	ReadyURL string `yaml:"ready_url"`
+	//seconds to wait for the sidecar at start, backends are dialed anyway after it, 0 means
+	//DefaultSidecarReadyTimeout
+	ReadyTimeout int `yaml:"ready_timeout"`
+	//posted once the proxy is closed so the sidecar exits with it,
+	//e.g. http://127.0.0.1:15020/quitquitquit, empty means not to call it
+	QuitURL string `yaml:"quit_url"`
+	//seconds the pre-stop hook waits for client connections to close after the proxy
+	//reports unhealthy, the sidecar has to keep running at least this long
+	DrainTimeout int `yaml:"drain_timeout"`
+}
+
+const DefaultSidecarReadyTimeout = 120
+
 //user_list对应的配置
 type UserConfig struct {
 	User     string `yaml:"user"`
@@ -52,6 +401,102 @@ type ClusterConfig struct {
 	ResendForScaleOUT int    `yaml:"resend_for_scale_out"`
 	ScaleInInterval   int    `yaml:"scale_in_interval"`
 	SilentPeriod      int    `yaml:"silent_period"`
+	//predictive scale out, minutes to look ahead, 0 means disable
+	PredictAhead     int    `yaml:"predict_ahead"`
+	PredictStateFile string `yaml:"predict_state_file"`
+	//file the counter metrics and the cost and cores of the pools are appended to every
+	//second, replayed offline by -simulate-autoscale, empty means off
+	CounterTrace string `yaml:"counter_trace"`
+	//file the rewrite rules and plan pins are saved to and loaded from on start
+	RulesFile string `yaml:"rules_file"`
+	//file the pool membership, weights and manual states are saved to on change, the
+	//pools are restored from it on start so the proxy serves before discovery completes
+	PoolSnapshotFile string `yaml:"pool_snapshot_file"`
+	//seconds a pool snapshot is trusted on start, 0 means DefaultPoolSnapshotMaxAge
+	PoolSnapshotMaxAge int `yaml:"pool_snapshot_max_age"`
+	//seconds between backend schema version checks, 0 means disable
+	SchemaCheckInterval int `yaml:"schema_check_interval"`
+	TidbStatusPort      int `yaml:"tidb_status_port"`
+	//max running statements per backend cpu, 0 means no limit
+	ConcurrencyPerCore int `yaml:"concurrency_per_core"`
+	//ap statements beyond the capacity of the ap pool wait in a queue fair across users
+	ApFairQueue FairQueueConfig `yaml:"ap_fair_queue"`
+	//no scale requests are sent for a pool in its maintenance window, and tidbs no longer
+	//discovered are deleted after it
+	MaintenanceWindows []MaintenanceWindowConfig `yaml:"maintenance_windows"`
+	//seconds between re-reading pod cpu to refresh tidb weights, 0 means disable
+	RebalanceInterval int `yaml:"rebalance_interval"`
+	//seconds a pod read from kubernetes is used without reading it again, 0 means
+	//DefaultPodCacheTTL, negative means always read
+	PodCacheTTL int `yaml:"pod_cache_ttl"`
+	//seconds to ramp the routing weight of a new tidb from 10% to 100%, 0 means disable
+	WarmupPeriod int `yaml:"warmup_period"`
+	//the routing weight of a tidb drops on its errors and recovers as they age, so a
+	//partially sick tidb takes less traffic before the health checks mark it down
+	ErrorDecay ErrorDecayConfig `yaml:"error_decay"`
+	//statements run on every pre-established connection of a new tidb before it takes traffic,
+	//a USE statement switches the database of the connection
+	WarmupSQL []string `yaml:"warmup_sql"`
+	//refuse to add a tidb whose major.minor version differs from the majority of its pool
+	RejectVersionSkew bool `yaml:"reject_version_skew"`
+	//checks a tidb passes on one connection before it is added to a pool
+	Preflight PreflightConfig `yaml:"preflight"`
+	//queries loading the statistics of the hottest tables on a new tidb before it takes traffic
+	StatsWarmup StatsWarmupConfig `yaml:"stats_warmup"`
+	//dial timeout, keepalive and deadlines of the connections to the tidbs, the ones of the
+	//client connections are in the performance config of the proxy
+	BackendConn BackendConnConfig `yaml:"backend_conn"`
+	//seconds to verify load after each tp tidb removed when the proxy turns into a pure
+	//compute node, 0 means remove all tp tidbs at once
+	ScaleInVerifyWindow int `yaml:"scale_in_verify_window"`
+	//percent the average latency may rise over the baseline during verification
+	ScaleInLatencyTolerance int `yaml:"scale_in_latency_tolerance"`
+	//seconds a removed tidb waits for its connections to finish, then open transactions
+	//on it are rolled back, 0 means wait up to 600s without rollback
+	ScaleInDrainTimeout int `yaml:"scale_in_drain_timeout"`
+	//long transactions on a removed tidb delay or veto its removal, so scale in doesn't
+	//abort batch jobs holding pessimistic locks
+	ScaleInLockCheck LockCheckConfig `yaml:"scale_in_lock_check"`
+	//a default database of the handshake unknown to the proxy is looked for on the backends,
+	//and created if missing everywhere, before the handshake fails
+	ConnectDB ConnectDBConfig `yaml:"connect_db"`
+	//terminate authenticates clients by the users of the proxy, passthrough relays the auth
+	//exchange to a backend of the cluster, terminate if not set
+	AuthMode string `yaml:"auth_mode"`
+	//tls of the relayed auth exchanges, a cleartext password, e.g. of a caching_sha2_password
+	//full auth, is only relayed over it
+	AuthRelayTLS AuthRelayTLSConfig `yaml:"auth_relay_tls"`
+	//mirror a share of the read only statements to a test backend, e.g. a tidb of a new
+	//version or with another tiflash setup. Its results are discarded and its errors recorded
+	Shadow ShadowConfig `yaml:"shadow"`
+	//prefer the backends in the zone of the proxy to cut cross zone traffic
+	Locality LocalityConfig `yaml:"locality"`
+	//headroom the proxy keeps while it serves as a compute node of the tp pool
+	ProxyCompute ProxyComputeConfig `yaml:"proxy_compute"`
+	//DDL, ANALYZE and ADMIN statements forwarded over a fresh conn instead of run in the proxy
+	AdminConn AdminConnConfig `yaml:"admin_conn"`
+	//SHOW DATABASES and SHOW TABLES answered from the listings of one tidb
+	SchemaListing SchemaListingConfig `yaml:"schema_listing"`
+	//milliseconds an ap statement may wait for a backend before ap scales out regardless of cost, 0 means disable
+	ApQueueWait int `yaml:"ap_queue_wait"`
+	//seconds the ap queue must stay empty before ap scales in, 0 means disable
+	ApQueueIdle int `yaml:"ap_queue_idle"`
+	//replica floor and ceiling of each pool, keyed by tp and ap
+	Replicas map[string]ReplicaConfig `yaml:"replicas"`
+	//evaluation cadence, cost sampling window and step sizes of the autoscaler of each pool,
+	//keyed by tp and ap, every pool is evaluated by its own loop
+	Autoscale map[string]AutoscaleConfig `yaml:"autoscale"`
+	//tidb_replica_read of reads when serverless_follower_read is on, keyed by tp and ap,
+	//ap defaults to follower and tp to leader
+	ReplicaRead map[string]string `yaml:"replica_read"`
+	//cap of result sets keyed by tp and ap, no limit if not set
+	ResultLimit map[string]ResultLimitConfig `yaml:"result_limit"`
+	//read ap statements from tiflash when all their tables have tiflash replicas
+	TiflashRouting bool `yaml:"tiflash_routing"`
+	//how backends of the pools are found, k8s pod labels by default
+	Discovery DiscoveryConfig `yaml:"discovery"`
+	//pod labels and service naming of the tidb operator
+	Labels PodLabelConfig `yaml:"labels"`
 
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
@@ -59,6 +504,501 @@ type ClusterConfig struct {
 	Tidbs string `yaml:"tidbs"`
 }
 
+//replicas of a pool, 0 means no limit
+//read only statements run by the pools are mirrored to addr in the background, clients
+//never wait for it
+type ShadowConfig struct {
+	//host:port of the shadow tidb, empty means disable
+	Addr string `yaml:"addr"`
+	//percent of the read only statements mirrored, e.g. 0.5
+	Percent float64 `yaml:"percent"`
+	//connections to the shadow tidb, 0 means DefaultShadowWorkers
+	Workers int `yaml:"workers"`
+	//statements waiting for a worker, the ones over it are dropped. 0 means
+	//DefaultShadowQueueSize
+	QueueSize int `yaml:"queue_size"`
+	//empty means the user of the cluster
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	//seconds a mirrored statement runs on the shadow tidb at most, 0 means
+	//DefaultShadowTimeout
+	Timeout int `yaml:"timeout"`
+}
+
+const (
+	DefaultShadowWorkers   = 4
+	DefaultShadowQueueSize = 1024
+	DefaultShadowTimeout   = 10
+)
+
+type ReplicaConfig struct {
+	MinReplicas int `yaml:"min_replicas"`
+	MaxReplicas int `yaml:"max_replicas"`
+}
+
+type AutoscaleConfig struct {
+	//seconds between two evaluations of the pool, 0 means DefaultAutoscaleInterval
+	Interval int `yaml:"interval"`
+	//seconds the cost is averaged over, 0 means the cost of the last interval only
+	Window int `yaml:"window"`
+	//max cores added or removed by one scale request, 0 means no limit
+	ScaleOutStep float64 `yaml:"scale_out_step"`
+	ScaleInStep  float64 `yaml:"scale_in_step"`
+}
+
+const DefaultAutoscaleInterval = 1
+
+//actions on result sets over the limit
+const (
+	ResultLimitError    = "error"
+	ResultLimitTruncate = "truncate"
+)
+
+type ResultLimitConfig struct {
+	//0 means no limit
+	MaxRows  int64 `yaml:"max_rows"`
+	MaxBytes int64 `yaml:"max_bytes"`
+	//error or truncate, error by default
+	Action string `yaml:"action"`
+}
+
+//priority levels of statements forwarded to backends
+const (
+	PriorityHigh   = "high"
+	PriorityMedium = "medium"
+	PriorityLow    = "low"
+)
+
+//the application of a client is the app configured for its user, else the program_name
+//attribute of its handshake
+type AppMetricsConfig struct {
+	Enable bool `yaml:"enable"`
+	//app tag by user, taking precedence over program_name
+	Users map[string]string `yaml:"users"`
+	//apps labelled at most, later ones are labelled other, 0 means DefaultAppMetricsMaxApps
+	MaxApps int `yaml:"max_apps"`
+}
+
+const DefaultAppMetricsMaxApps = 50
+
+type PriorityConfig struct {
+	//level by routing class, tp or ap
+	Classes map[string]string `yaml:"classes"`
+	//level by user, it overrides the class
+	Users map[string]string `yaml:"users"`
+}
+
+//Level returns the priority level of the user's statements routed to the class,
+//empty means the backend default.
+func (p PriorityConfig) Level(user, class string) string {
+	if level, ok := p.Users[user]; ok {
+		return level
+	}
+	return p.Classes[class]
+}
+
+//ways to tell backends the client ip
+const (
+	ForwardClientIPAttr          = "attr"
+	ForwardClientIPProxyProtocol = "proxy_protocol"
+)
+
+//policies while the connection to pd is lost
+const (
+	PDLossReject  = "reject"
+	PDLossDegrade = "degrade"
+)
+
+const (
+	DiscoveryK8s    = "k8s"
+	DiscoveryStatic = "static"
+	DiscoveryDNS    = "dns"
+	DiscoveryEtcd   = "etcd"
+)
+
+//backend discovery of the cluster, the maps are keyed by tp and ap
+type DiscoveryConfig struct {
+	//one of k8s, static, dns and etcd, empty means k8s
+	Type string `yaml:"type"`
+	//static: tidbs of each pool, addr@cores separated by commas
+	Static map[string]string `yaml:"static"`
+	//dns: SRV record name of each pool, the record weight is taken as cores
+	SRV map[string]string `yaml:"srv"`
+	//etcd: tidbs register under prefix/<pool>/<addr> with cores as value,
+	//the pd etcd is used if no endpoints are given
+	EtcdEndpoints []string `yaml:"etcd_endpoints"`
+	EtcdPrefix    string   `yaml:"etcd_prefix"`
+	//seconds between refreshing the pools for static, dns and etcd discovery, 0 means only at bootstrap
+	RefreshInterval int `yaml:"refresh_interval"`
+	//seconds between adding the tidbs discovered in kubernetes but missing in the pools,
+	//e.g. pods briefly unavailable when the operator added them. 0 means
+	//DefaultReconcileInterval, negative means never
+	ReconcileInterval int `yaml:"reconcile_interval"`
+}
+
+const DefaultReconcileInterval = 30
+
+//tenant clusters are found in kubernetes by name or by the label selector of their tidb
+//pods, they take the config of the cluster except the name and namespace
+type TenantsConfig struct {
+	Clusters []TenantClusterConfig `yaml:"clusters"`
+	//label selector of tidb pods in every namespace, the cluster of a pod found by it is a
+	//tenant serving the database named after the cluster
+	Selector string `yaml:"selector"`
+	//seconds between refreshes of the tenant clusters and their tidbs, 0 means
+	//DefaultTenantRefreshInterval
+	RefreshInterval int `yaml:"refresh_interval"`
+}
+
+type TenantClusterConfig struct {
+	ClusterName string `yaml:"clustername"`
+	NameSpace   string `yaml:"namespace"`
+	//databases routed to the cluster, empty means the database named after the cluster
+	Databases []string `yaml:"databases"`
+}
+
+const DefaultTenantRefreshInterval = 30
+
+const DefaultPoolSnapshotMaxAge = 600
+
+const DefaultPodCacheTTL = 10
+
+//statements of every user waiting for a turn take turns, a user with weight n runs n
+//statements per turn
+type FairQueueConfig struct {
+	Enable bool `yaml:"enable"`
+	//running statements of the pool, 0 means concurrency_per_core of its backends
+	MaxRunning int `yaml:"max_running"`
+	//weight by user, 1 if not set
+	Weights map[string]int `yaml:"weights"`
+	//seconds a statement waits for its turn, 0 means DefaultFairQueueTimeout
+	Timeout int `yaml:"timeout"`
+}
+
+const DefaultFairQueueTimeout = 60
+
+//a tidb failing the auth, SELECT version(), the latency sample or the variables check is
+//rejected with the reason in the log and the scale history
+type PreflightConfig struct {
+	//add tidbs once they are connected, without the checks
+	Disable bool `yaml:"disable"`
+	//pings of the latency sample, 0 means DefaultPreflightPings
+	Pings int `yaml:"pings"`
+	//milliseconds, a tidb with a median ping over this is rejected, 0 means
+	//DefaultPreflightMaxLatency, negative means no limit
+	MaxLatency int `yaml:"max_latency"`
+	//global variables the tidb must have, e.g. tidb_enable_clustered_index: "ON"
+	Variables map[string]string `yaml:"variables"`
+}
+
+const (
+	DefaultPreflightPings      = 5
+	DefaultPreflightMaxLatency = 100
+)
+
+//the transactions are read from information_schema.TIDB_TRX of the tidb, a tidb that can't
+//answer is removed as without the check
+type LockCheckConfig struct {
+	Enable bool `yaml:"enable"`
+	//seconds, transactions open longer than this hold the removal, 0 means DefaultLockCheckMinAge
+	MinAge int `yaml:"min_age"`
+	//veto refuses the removal, delay takes the tidb out of routing and waits for the
+	//transactions before the drain timeout rolls them back, delay if not set
+	Action string `yaml:"action"`
+	//seconds the removal waits for the transactions at most, 0 means DefaultLockCheckMaxDelay
+	MaxDelay int `yaml:"max_delay"`
+}
+
+const (
+	LockCheckDelay = "delay"
+	LockCheckVeto  = "veto"
+
+	DefaultLockCheckMinAge   = 60
+	DefaultLockCheckMaxDelay = 1800
+)
+
+//ways the proxy authenticates clients
+const (
+	AuthTerminate   = "terminate"
+	AuthPassthrough = "passthrough"
+)
+
+//the schema of the proxy may miss a database just created on a backend, or the database
+//may be on some backends only, so the handshake checks the backends before failing
+type ConnectDBConfig struct {
+	Validate bool `yaml:"validate"`
+	//backends asked for the database, 0 means DefaultConnectDBRetries
+	Retries int `yaml:"retries"`
+	//create the database missing on every backend, for serverless tenants creating their
+	//database on first connect, the user needs CREATE on it
+	AutoCreate bool `yaml:"auto_create"`
+	//seconds the handshake waits for the proxy schema to have the database, 0 means
+	//DefaultConnectDBWait
+	Wait int `yaml:"wait"`
+}
+
+const (
+	DefaultConnectDBRetries = 2
+	DefaultConnectDBWait    = 5
+)
+
+//a backend connection whose read or write passes its deadline is broken and closed, the
+//statement on it fails
+type BackendConnConfig struct {
+	//seconds, 0 means DefaultBackendDialTimeout
+	DialTimeout int `yaml:"dial_timeout"`
+	//seconds between tcp keepalive probes, 0 means the system default, negative disables them
+	KeepAlive int `yaml:"keepalive"`
+	//seconds waiting for a packet of the tidb, it bounds the statements without result for
+	//this long, 0 means no deadline
+	ReadTimeout int `yaml:"read_timeout"`
+	//seconds to write a packet to the tidb, 0 means no deadline
+	WriteTimeout int `yaml:"write_timeout"`
+}
+
+const DefaultBackendDialTimeout = 5
+
+//the hottest tables are the ones read by the most statements of the top digests of the pool,
+//every query is run on every table with {table} replaced by its quoted name
+type StatsWarmupConfig struct {
+	Enable bool `yaml:"enable"`
+	//hottest tables warmed up, 0 means DefaultStatsWarmupTables
+	Tables int `yaml:"tables"`
+	//DefaultStatsWarmupQuery if not set
+	Queries []string `yaml:"queries"`
+	//seconds, the tidb is added once they pass, 0 means DefaultStatsWarmupTimeout
+	Timeout int `yaml:"timeout"`
+}
+
+const (
+	DefaultStatsWarmupTables  = 10
+	DefaultStatsWarmupQuery   = "EXPLAIN SELECT * FROM {table}"
+	DefaultStatsWarmupTimeout = 10
+)
+
+//a window starts at every time matched by the cron and lasts duration seconds
+type MaintenanceWindowConfig struct {
+	//tp or ap, empty means every pool
+	Pool string `yaml:"pool"`
+	//minute hour day-of-month month day-of-week in the local time of the proxy, e.g.
+	//0 2 * * 6 is 02:00 every saturday
+	Cron     string `yaml:"cron"`
+	Duration int    `yaml:"duration"`
+}
+
+//DiscoveryType returns the discovery type, k8s if not set.
+func (cfg *ClusterConfig) DiscoveryType() string {
+	if cfg.Discovery.Type == "" {
+		return DiscoveryK8s
+	}
+	return cfg.Discovery.Type
+}
+
+const (
+	DefaultComponentLabelKey = "app.kubernetes.io/component"
+	DefaultComponent         = "tidb"
+	DefaultRoleLabelKey      = "bcrds.cmss.com/role"
+	DefaultClusterLabelKey   = "bcrds.cmss.com/instance"
+	DefaultInstanceLabelKey  = "app.kubernetes.io/instance"
+	DefaultPredeleteLabelKey = "predelete"
+	DefaultPeerServiceSuffix = "-tidb-peer"
+	DefaultTidbContainer     = "tidb"
+	DefaultTidbPort          = 4000
+
+	//role of the proxy pod, the pools take tp and ap
+	RoleProxy = "proxy"
+)
+
+//pod labels and service naming of the tidb operator, empty fields take the defaults
+type PodLabelConfig struct {
+	//label of the tidb component and its value
+	ComponentKey string `yaml:"component_key"`
+	Component    string `yaml:"component"`
+	//label of the pod role, with the value of each role keyed by tp, ap and proxy
+	RoleKey string            `yaml:"role_key"`
+	Roles   map[string]string `yaml:"roles"`
+	//label of the cluster name
+	ClusterKey string `yaml:"cluster_key"`
+	//label of the operator instance the peer service is named after
+	InstanceKey string `yaml:"instance_key"`
+	//label set to true on pods about to be deleted
+	PredeleteKey string `yaml:"predelete_key"`
+	//tidbs are reached at <pod>.<instance><peer_service_suffix>.<namespace>:<port>
+	PeerServiceSuffix string `yaml:"peer_service_suffix"`
+	Port              int    `yaml:"port"`
+	//container whose cpu request is the weight of the tidb
+	Container string `yaml:"container"`
+}
+
+//a backend is in the zone of the node of its pod
+type LocalityConfig struct {
+	//none, prefer or strict. prefer routes to a backend in the zone of the proxy over one
+	//of the same weight in another zone, strict routes only to the zone of the proxy while
+	//it has an up backend. Empty means none
+	Mode string `yaml:"mode"`
+	//zone of the proxy, empty means the zone of the node of the proxy pod
+	Zone string `yaml:"zone"`
+	//node label holding the zone, empty means DefaultZoneLabel
+	ZoneLabel string `yaml:"zone_label"`
+}
+
+//the proxy serving as a compute node takes no new statements while its container uses
+//more than the limits, unless no tidb of the pool is up
+type ProxyComputeConfig struct {
+	//percent of the cpu limit of the proxy container, 0 means DefaultProxyComputeMaxCPU,
+	//negative means no limit
+	MaxCPU float64 `yaml:"max_cpu"`
+	//percent of the memory limit of the proxy container, 0 means
+	//DefaultProxyComputeMaxMemory, negative means no limit
+	MaxMemory float64 `yaml:"max_memory"`
+	//seconds between samples of the usage, 0 means DefaultProxyComputeSampleInterval
+	SampleInterval int `yaml:"sample_interval"`
+}
+
+//each connection error, failed dial or error of Codes adds 1 to the error score of the tidb,
+//which halves every HalfLife. The routing weight is divided by 1 + score
+type ErrorDecayConfig struct {
+	//seconds, 0 means DefaultErrorDecayHalfLife, negative means disable
+	HalfLife int `yaml:"half_life"`
+	//lowest percent of its weight a tidb keeps, 0 means DefaultErrorDecayMinWeight
+	MinWeight int `yaml:"min_weight"`
+	//error codes of the tidb telling it is sick, DefaultErrorDecayCodes if empty
+	Codes []uint16 `yaml:"codes"`
+}
+
+const (
+	DefaultErrorDecayHalfLife  = 30
+	DefaultErrorDecayMinWeight = 10
+)
+
+//server shutdown and too many connections
+var DefaultErrorDecayCodes = []uint16{1053, 1040}
+
+//a fresh conn is dialed to a tidb for each statement outside transactions and closed after
+//it, so a long DDL doesn't hold a pooled conn. serverless_fresh_conn of the session
+//overrides fresh
+type AdminConnConfig struct {
+	Fresh bool `yaml:"fresh"`
+	//pod name or addr of the ddl-preferred tidb, the first up tp tidb if empty or not up
+	Backend string `yaml:"backend"`
+}
+
+//the listings of SHOW DATABASES and SHOW TABLES are read from one tidb and kept for ttl
+//seconds, so every session sees the same listing whichever tidb it is bound to
+type AuthRelayTLSConfig struct {
+	//ca verifying the tidbs, empty means the relay is plain tcp and clients needing a
+	//cleartext password are refused
+	CA         string `yaml:"ca"`
+	ServerName string `yaml:"server_name"`
+}
+
+type SchemaListingConfig struct {
+	//seconds, 0 means the proxy runs these statements itself
+	TTL int `yaml:"ttl"`
+	//pod name or addr of the tidb listings are read from, backend of admin_conn if empty
+	Backend string `yaml:"backend"`
+}
+
+const (
+	DefaultProxyComputeMaxCPU         = 60
+	DefaultProxyComputeMaxMemory      = 80
+	DefaultProxyComputeSampleInterval = 5
+)
+
+const (
+	LocalityNone   = "none"
+	LocalityPrefer = "prefer"
+	LocalityStrict = "strict"
+
+	DefaultZoneLabel = "topology.kubernetes.io/zone"
+)
+
+//ZoneLabel returns the node label holding the zone.
+func (cfg *ClusterConfig) ZoneLabel() string {
+	if cfg.Locality.ZoneLabel != "" {
+		return cfg.Locality.ZoneLabel
+	}
+	return DefaultZoneLabel
+}
+
+//PodLabels returns the pod labels with the defaults filled in.
+func (cfg *ClusterConfig) PodLabels() *PodLabelConfig {
+	l := cfg.Labels
+	defaults := []struct {
+		field *string
+		value string
+	}{
+		{&l.ComponentKey, DefaultComponentLabelKey},
+		{&l.Component, DefaultComponent},
+		{&l.RoleKey, DefaultRoleLabelKey},
+		{&l.ClusterKey, DefaultClusterLabelKey},
+		{&l.InstanceKey, DefaultInstanceLabelKey},
+		{&l.PredeleteKey, DefaultPredeleteLabelKey},
+		{&l.PeerServiceSuffix, DefaultPeerServiceSuffix},
+		{&l.Container, DefaultTidbContainer},
+	}
+	for _, d := range defaults {
+		if *d.field == "" {
+			*d.field = d.value
+		}
+	}
+	if l.Port == 0 {
+		l.Port = DefaultTidbPort
+	}
+	return &l
+}
+
+//Role returns the role label value of tp, ap or proxy, the role itself if not mapped.
+func (l *PodLabelConfig) Role(role string) string {
+	if v, ok := l.Roles[role]; ok && v != "" {
+		return v
+	}
+	return role
+}
+
+//Selector returns the label selector of the pods in the role of the cluster.
+func (l *PodLabelConfig) Selector(clusterName, role string) string {
+	return fmt.Sprintf("%s=%s,%s=%s,%s=%s", l.ComponentKey, l.Component, l.RoleKey, l.Role(role), l.ClusterKey, clusterName)
+}
+
+//ClusterSelector returns the label selector of all the tidb pods of the cluster.
+func (l *PodLabelConfig) ClusterSelector(clusterName string) string {
+	return fmt.Sprintf("%s=%s,%s=%s", l.ComponentKey, l.Component, l.ClusterKey, clusterName)
+}
+
+//IsRole reports whether the pod labels have the role.
+func (l *PodLabelConfig) IsRole(labels map[string]string, role string) bool {
+	return labels[l.RoleKey] == l.Role(role)
+}
+
+//PeerHost returns the host of the pod behind the peer service.
+func (l *PodLabelConfig) PeerHost(podName string, labels map[string]string, namespace string) string {
+	return podName + "." + labels[l.InstanceKey] + l.PeerServiceSuffix + "." + namespace
+}
+
+//PeerAddr returns host:port of the pod behind the peer service.
+func (l *PodLabelConfig) PeerAddr(podName string, labels map[string]string, namespace string) string {
+	return l.PeerHost(podName, labels, namespace) + ":" + strconv.Itoa(l.Port)
+}
+
+//AutoscaleOf returns the autoscale config of the pool with its interval set.
+func (cfg *ClusterConfig) AutoscaleOf(tidbType string) AutoscaleConfig {
+	a := cfg.Autoscale[tidbType]
+	if a.Interval <= 0 {
+		a.Interval = DefaultAutoscaleInterval
+	}
+	if a.Window < a.Interval {
+		a.Window = a.Interval
+	}
+	return a
+}
+
+//ReplicaBounds returns the replica floor and ceiling of the pool.
+func (cfg *ClusterConfig) ReplicaBounds(tidbType string) (int, int) {
+	r := cfg.Replicas[tidbType]
+	return r.MinReplicas, r.MaxReplicas
+}
+
 func ParseConfigData(data []byte) (*Config, error) {
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
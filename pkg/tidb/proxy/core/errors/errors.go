@@ -16,6 +16,8 @@ package errors
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 )
 
 var (
@@ -24,6 +26,7 @@ var (
 	ErrNoDefaultNode = errors.New("no default node")
 	ErrNoMasterDB    = errors.New("no master database")
 	ErrNoTidbDB     = errors.New("no Tidb database")
+	ErrClusterInitializing = errors.New("cluster is initializing")
 	ErrNoDatabase    = errors.New("no database")
 	ErrAllDatabaseDown    = errors.New("all database down")
 	ErrMasterDown    = errors.New("master is down")
@@ -68,10 +71,110 @@ var (
 	ErrDateRangeCount   = errors.New("date range count is not equal")
 	ErrTidbExist       = errors.New("Tidb has exist")
 	ErrTidbNotExist    = errors.New("Tidb has not exist")
+	ErrTidbRemoved     = errors.New("Tidb removed by autoscaler")
+	ErrIdleTxnKilled   = errors.New("transaction idle longer than the idle kill timeout")
+	ErrVersionSkew     = errors.New("Tidb version differs from pool")
 	ErrBlackSqlExist    = errors.New("black sql has exist")
 	ErrBlackSqlNotExist = errors.New("black sql has not exist")
+	ErrRewriteNotExist  = errors.New("rewrite rule has not exist")
+	ErrPinNotExist      = errors.New("plan pin has not exist")
+	ErrResultTooLarge   = errors.New("result set exceeds the proxy limit")
+	ErrWindowNotExist   = errors.New("maintenance window has not exist")
 	ErrInsertTooComplex = errors.New("insert is too complex")
 	ErrSQLNULL          = errors.New("sql is null")
 
 	ErrInternalServer = errors.New("internal server error")
+	ErrInvalidModel   = errors.New("predict model is invalid")
+	ErrInvalidCert    = errors.New("certificate is invalid")
+	ErrLoadDataTooLarge = errors.New("load data file is too large")
+	ErrCaptureRunning    = errors.New("capture is running")
+	ErrCaptureNotRunning = errors.New("capture is not running")
+	ErrReplayRunning     = errors.New("replay is running")
+	ErrCaptureDisabled   = errors.New("capture dir is not configured")
+	ErrCaptureFile       = errors.New("capture file must be a file name in the capture dir")
+	ErrReplayDisabled    = errors.New("replay is not enabled")
+	ErrReplayTarget      = errors.New("replay target is not a tidb of the cluster")
+	ErrReplayWrites      = errors.New("replaying writes is not enabled")
+	ErrRelayNoTLS        = errors.New("backend does not support tls")
+	ErrRelayCleartext    = errors.New("cleartext password is not relayed without tls")
+	ErrScaleRateLimited  = errors.New("scale request is rate limited")
+	ErrPoolCordoned      = errors.New("pool is cordoned")
+	ErrPoolInMaintenance = errors.New("pool is in its maintenance window")
+	ErrPodUnavailable    = errors.New("pod is not found or about to be deleted")
+	ErrFairQueueTimeout  = errors.New("timeout waiting for the turn in the ap queue")
+	ErrExplainRouteStmt  = errors.New("EXPLAIN ROUTE takes exactly one statement")
+	ErrLongTxnsOnTidb    = errors.New("tidb has long transactions, removal vetoed")
+	ErrLastUpBackend     = errors.New("removal leaves no up tp backend while the proxy is not compute")
+	ErrBelowMinReplicas  = errors.New("removal takes the pool below its min replicas")
 )
+
+//PoolError records which backend pool an error comes from.
+type PoolError struct {
+	Pool string
+	Err  error
+}
+
+func NewPoolError(pool string, err error) *PoolError {
+	return &PoolError{Pool: pool, Err: err}
+}
+
+func (e *PoolError) Error() string {
+	return e.Pool + " " + e.Err.Error()
+}
+
+func (e *PoolError) Unwrap() error {
+	return e.Err
+}
+
+//ScaleInError is returned when removing a backend or scaling in a pool would break an
+//invariant of the pool, Err is ErrLastUpBackend or ErrBelowMinReplicas.
+type ScaleInError struct {
+	Pool string
+	Addr string
+	//up backends left after the removal
+	Up  int
+	Err error
+}
+
+func NewScaleInError(pool, addr string, up int, err error) *ScaleInError {
+	return &ScaleInError{Pool: pool, Addr: addr, Up: up, Err: err}
+}
+
+func (e *ScaleInError) Error() string {
+	return fmt.Sprintf("%s %s: %s, %d up left", e.Pool, e.Addr, e.Err.Error(), e.Up)
+}
+
+func (e *ScaleInError) Unwrap() error {
+	return e.Err
+}
+
+//IsScaleInRejected reports whether the operation failed with a ScaleInError.
+func IsScaleInRejected(err error) bool {
+	var se *ScaleInError
+	return errors.As(err, &se)
+}
+
+//RetriableError is returned when some backends can't be added for now, e.g. their pods
+//are briefly unavailable, and adding them again later may succeed.
+type RetriableError struct {
+	Addrs []string
+	Err   error
+}
+
+func NewRetriableError(addrs []string, err error) *RetriableError {
+	return &RetriableError{Addrs: addrs, Err: err}
+}
+
+func (e *RetriableError) Error() string {
+	return e.Err.Error() + ": " + strings.Join(e.Addrs, ",")
+}
+
+func (e *RetriableError) Unwrap() error {
+	return e.Err
+}
+
+//IsRetriable reports whether the operation failed with a RetriableError.
+func IsRetriable(err error) bool {
+	var re *RetriableError
+	return errors.As(err, &re)
+}
@@ -0,0 +1,127 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package estimator
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+)
+
+const (
+	DefaultMinSamples = 10
+	DefaultMaxDigests = 10000
+	//weight of a new sample in the moving averages
+	calibrationAlpha = 0.1
+	//the correction factor of a digest stays within [1/maxFactor, maxFactor]
+	maxFactor = 100.0
+)
+
+//digestCalibration is the moving average of the log of actual to plan cost of a digest.
+type digestCalibration struct {
+	logRatio float64
+	samples  int64
+}
+
+//Calibrator compares the plan cost of statements with their actual cost and corrects the
+//estimate of every digest by a factor adjusted over time. The actual cost is the latency
+//of the statement in the cost units of the plans, the cost per millisecond averaged over
+//all statements. A digest needs minSamples statements before it is corrected, and only
+//the error is exported when apply is false.
+type Calibrator struct {
+	sync.RWMutex
+	apply      bool
+	minSamples int64
+	maxDigests int
+	//moving average of the log of plan cost per millisecond of all statements
+	logRate float64
+	samples int64
+	digests map[string]*digestCalibration
+}
+
+func NewCalibrator(minSamples, maxDigests int, apply bool) *Calibrator {
+	if minSamples <= 0 {
+		minSamples = DefaultMinSamples
+	}
+	if maxDigests <= 0 {
+		maxDigests = DefaultMaxDigests
+	}
+	return &Calibrator{
+		apply:      apply,
+		minSamples: int64(minSamples),
+		maxDigests: maxDigests,
+		digests:    make(map[string]*digestCalibration),
+	}
+}
+
+//Factor returns the correction factor of the digest, 1 until it has enough samples.
+func (c *Calibrator) Factor(digest string) float64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.factor(digest)
+}
+
+func (c *Calibrator) factor(digest string) float64 {
+	d, ok := c.digests[digest]
+	if !ok || d.samples < c.minSamples || c.samples < c.minSamples {
+		return 1
+	}
+	return math.Min(maxFactor, math.Max(1/maxFactor, math.Exp(d.logRatio)))
+}
+
+func (c *Calibrator) Estimate(digest string, planCost int64) int64 {
+	if !c.apply || planCost <= 0 {
+		return planCost
+	}
+	return int64(float64(planCost) * c.Factor(digest))
+}
+
+func (c *Calibrator) Observe(digest string, planCost int64, latency time.Duration) {
+	ms := float64(latency) / float64(time.Millisecond)
+	if planCost <= 0 || ms <= 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	logRate := math.Log(float64(planCost) / ms)
+	if c.samples == 0 {
+		c.logRate = logRate
+	} else {
+		c.logRate += calibrationAlpha * (logRate - c.logRate)
+	}
+	c.samples++
+	if c.samples < c.minSamples {
+		return
+	}
+	//actual cost over plan cost, with the cost per millisecond of all statements
+	logRatio := c.logRate - logRate
+	metrics.ProxyCostEstimateErrorHistogram.WithLabelValues("plan").Observe(math.Exp(logRatio))
+	metrics.ProxyCostEstimateErrorHistogram.WithLabelValues("calibrated").Observe(math.Exp(logRatio) / c.factor(digest))
+
+	d, ok := c.digests[digest]
+	if !ok {
+		if len(c.digests) >= c.maxDigests {
+			return
+		}
+		d = &digestCalibration{logRatio: logRatio}
+		c.digests[digest] = d
+		metrics.ProxyCostCalibratedDigestsGauge.Set(float64(len(c.digests)))
+	} else {
+		d.logRatio += calibrationAlpha * (logRatio - d.logRatio)
+	}
+	d.samples++
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package estimator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func TestCalibratorCorrectsUnderestimatedDigest(t *testing.T) {
+	c := NewCalibrator(5, 0, true)
+	//cost 1000 per millisecond for most statements, the slow digest runs 10 times longer
+	for i := 0; i < 50; i++ {
+		c.Observe("fast", 10000, 10*time.Millisecond)
+		c.Observe("other", 5000, 5*time.Millisecond)
+	}
+	for i := 0; i < 50; i++ {
+		c.Observe("slow", 10000, 100*time.Millisecond)
+		c.Observe("fast", 10000, 10*time.Millisecond)
+		c.Observe("other", 5000, 5*time.Millisecond)
+	}
+	//the factors are relative to the average statement, the slow digest is about 10 times the fast one
+	slow, fast := c.Factor("slow"), c.Factor("fast")
+	if slow <= 1 || fast >= 1 || slow/fast < 5 {
+		t.Fatalf("expect slow digest corrected up against fast one, got factors %f and %f", slow, fast)
+	}
+	if cost := c.Estimate("slow", 10000); cost <= 10000 {
+		t.Fatalf("expect slow digest estimated over its plan cost, got %d", cost)
+	}
+	if cost := c.Estimate("unknown", 10000); cost != 10000 {
+		t.Fatalf("expect plan cost for a digest without samples, got %d", cost)
+	}
+}
+
+func TestCalibratorObserveOnly(t *testing.T) {
+	c := NewCalibrator(1, 1, false)
+	for i := 0; i < 10; i++ {
+		c.Observe("fast", 10000, 10*time.Millisecond)
+		c.Observe("slow", 10000, 100*time.Millisecond)
+	}
+	if cost := c.Estimate("fast", 10000); cost != 10000 {
+		t.Fatalf("expect plan cost when only observing, got %d", cost)
+	}
+	if len(c.digests) != 1 {
+		t.Fatalf("expect digests capped at 1, got %d", len(c.digests))
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, ok := New(config.CostEstimatorConfig{}).(PlanCost); !ok {
+		t.Fatal("expect plan cost by default")
+	}
+	if c, ok := New(config.CostEstimatorConfig{Mode: config.CostEstimatorCalibrate}).(*Calibrator); !ok || !c.apply {
+		t.Fatal("expect applying calibrator")
+	}
+	SetDefault(NewCalibrator(0, 0, false))
+	defer SetDefault(PlanCost{})
+	if _, ok := Default().(*Calibrator); !ok {
+		t.Fatal("expect calibrator set as default")
+	}
+}
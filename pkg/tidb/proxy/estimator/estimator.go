@@ -0,0 +1,71 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package estimator
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+//CostEstimator estimates the cost a statement is routed by and accounted with, from the
+//cost of its plan.
+type CostEstimator interface {
+	//Estimate returns the cost of a statement of the digest with the plan cost.
+	Estimate(digest string, planCost int64) int64
+	//Observe feeds back the latency of a statement of the digest run with the plan cost.
+	Observe(digest string, planCost int64, latency time.Duration)
+}
+
+//PlanCost is the cost of the plan, as the optimizer estimates it.
+type PlanCost struct{}
+
+func (PlanCost) Estimate(digest string, planCost int64) int64 {
+	return planCost
+}
+
+func (PlanCost) Observe(digest string, planCost int64, latency time.Duration) {}
+
+type holder struct {
+	CostEstimator
+}
+
+var defaultEstimator atomic.Value
+
+func init() {
+	defaultEstimator.Store(holder{PlanCost{}})
+}
+
+//Default returns the estimator of the proxy.
+func Default() CostEstimator {
+	return defaultEstimator.Load().(holder).CostEstimator
+}
+
+//SetDefault replaces the estimator of the proxy.
+func SetDefault(e CostEstimator) {
+	defaultEstimator.Store(holder{e})
+}
+
+//New returns the estimator of the config, the plan cost unless calibration is enabled.
+func New(cfg config.CostEstimatorConfig) CostEstimator {
+	switch cfg.Mode {
+	case config.CostEstimatorObserve:
+		return NewCalibrator(cfg.MinSamples, cfg.MaxDigests, false)
+	case config.CostEstimatorCalibrate:
+		return NewCalibrator(cfg.MinSamples, cfg.MaxDigests, true)
+	}
+	return PlanCost{}
+}
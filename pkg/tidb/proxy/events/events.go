@@ -0,0 +1,133 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+//events kept for subscribers resuming after a disconnect
+const DefaultHistorySize = 256
+
+//types of the events
+const (
+	BackendAdded   = "backend_added"
+	BackendRemoved = "backend_removed"
+	BackendUp      = "backend_up"
+	BackendDown    = "backend_down"
+	ScaleDecision  = "scale_decision"
+	ConfigChanged  = "config_changed"
+)
+
+//Event is a change of the topology, the scaling or the config of the proxy.
+type Event struct {
+	//increasing from 1, a subscriber resumes after the last id it got
+	ID      uint64 `json:"id"`
+	Time    int64  `json:"time"`
+	Type    string `json:"type"`
+	Cluster string `json:"cluster,omitempty"`
+	Pool    string `json:"pool,omitempty"`
+	Addr    string `json:"addr,omitempty"`
+	//fields of the type, e.g. the action and cores of a scale decision
+	Detail map[string]interface{} `json:"detail,omitempty"`
+}
+
+//Subscription receives the events published after it subscribes. A subscriber too slow
+//for its buffer is closed, it subscribes again from the last id it got.
+type Subscription struct {
+	C      chan Event
+	bus    *Bus
+	closed bool
+}
+
+//Close stops the events of the subscription, C is closed.
+func (s *Subscription) Close() {
+	s.bus.Lock()
+	defer s.bus.Unlock()
+	s.bus.remove(s)
+}
+
+//Bus publishes the events to the subscribers and keeps the last ones for late subscribers.
+type Bus struct {
+	sync.Mutex
+	next    uint64
+	history []Event
+	size    int
+	subs    map[*Subscription]struct{}
+}
+
+var defaultBus = NewBus(DefaultHistorySize)
+
+//Default returns the event bus of the proxy.
+func Default() *Bus {
+	return defaultBus
+}
+
+func NewBus(size int) *Bus {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &Bus{next: 1, size: size, subs: make(map[*Subscription]struct{})}
+}
+
+//Publish sends the event to the subscribers, it never blocks.
+func (b *Bus) Publish(e Event) {
+	b.Lock()
+	defer b.Unlock()
+	e.ID = b.next
+	b.next++
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	b.history = append(b.history, e)
+	if len(b.history) > b.size {
+		b.history = b.history[len(b.history)-b.size:]
+	}
+	for s := range b.subs {
+		select {
+		case s.C <- e:
+		default:
+			b.remove(s)
+		}
+	}
+}
+
+//Subscribe returns a subscription with room for buffer events, and the kept events after
+//lastID to send first. A lastID of 0 means no kept event.
+func (b *Bus) Subscribe(buffer int, lastID uint64) (*Subscription, []Event) {
+	b.Lock()
+	defer b.Unlock()
+	s := &Subscription{C: make(chan Event, buffer), bus: b}
+	b.subs[s] = struct{}{}
+	var missed []Event
+	if lastID > 0 {
+		for _, e := range b.history {
+			if e.ID > lastID {
+				missed = append(missed, e)
+			}
+		}
+	}
+	return s, missed
+}
+
+func (b *Bus) remove(s *Subscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(b.subs, s)
+	close(s.C)
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package events
+
+import (
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus(2)
+	b.Publish(Event{Type: BackendAdded, Addr: "a"})
+	s, missed := b.Subscribe(1, 0)
+	if len(missed) != 0 {
+		t.Fatalf("got %d kept events without a last id", len(missed))
+	}
+	b.Publish(Event{Type: BackendUp, Addr: "a"})
+	if e := <-s.C; e.ID != 2 || e.Type != BackendUp {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	//the buffer is full, the slow subscriber is closed
+	b.Publish(Event{Type: BackendDown, Addr: "a"})
+	b.Publish(Event{Type: BackendRemoved, Addr: "a"})
+	<-s.C
+	if _, ok := <-s.C; ok {
+		t.Fatal("slow subscription not closed")
+	}
+	s.Close()
+
+	//resuming from event 2 gets the kept events 3 and 4
+	s, missed = b.Subscribe(1, 2)
+	defer s.Close()
+	if len(missed) != 2 || missed[0].ID != 3 || missed[1].ID != 4 {
+		t.Fatalf("unexpected kept events %+v", missed)
+	}
+}
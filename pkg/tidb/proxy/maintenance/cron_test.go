@@ -0,0 +1,63 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("expect invalid cron %q", expr)
+		}
+	}
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	for _, c := range []struct {
+		expr  string
+		time  string
+		match bool
+	}{
+		{"0 2 * * 6", "2026-10-17 02:00", true},
+		{"0 2 * * 6", "2026-10-17 02:01", false},
+		{"0 2 * * 6", "2026-10-16 02:00", false},
+		{"*/15 * * * *", "2026-10-16 10:45", true},
+		{"*/15 * * * *", "2026-10-16 10:40", false},
+		{"30 1-3,22 * * *", "2026-10-16 22:30", true},
+		{"30 1-3,22 * * *", "2026-10-16 04:30", false},
+		//sunday as 7
+		{"0 0 * * 7", "2026-10-18 00:00", true},
+		//either day field matches when both are restricted
+		{"0 0 1 * 1", "2026-10-01 00:00", true},
+		{"0 0 1 * 1", "2026-10-19 00:00", true},
+		{"0 0 1 * 1", "2026-10-20 00:00", false},
+		{"0 0 1 * *", "2026-10-19 00:00", false},
+	} {
+		s, err := ParseSchedule(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Match(at(c.time)); got != c.match {
+			t.Errorf("expect %q match %s to be %v", c.expr, c.time, c.match)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package maintenance
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+const (
+	SourceConfig = "config"
+	SourceAdmin  = "admin"
+)
+
+//Window freezes the autoscaling of a pool, it starts at every time matched by the cron
+//and lasts the duration.
+type Window struct {
+	ID int64 `json:"id"`
+	//empty means every pool
+	Pool     string        `json:"pool"`
+	Cron     string        `json:"cron"`
+	Duration time.Duration `json:"duration"`
+	//config or admin, the windows from the config are kept on restart
+	Source string `json:"source"`
+
+	schedule *Schedule
+}
+
+//ActiveAt reports whether t is in the window.
+func (w *Window) ActiveAt(t time.Time) bool {
+	now := t.Truncate(time.Minute)
+	for start := now; t.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+		if w.schedule.Match(start) {
+			return true
+		}
+	}
+	return false
+}
+
+//Windows holds the maintenance windows, it is safe for concurrent use.
+type Windows struct {
+	sync.RWMutex
+	windows []*Window
+	nextID  int64
+}
+
+var defaultWindows = &Windows{}
+
+//Default returns the windows used by the proxy.
+func Default() *Windows {
+	return defaultWindows
+}
+
+//Load adds the windows of the config.
+func (ws *Windows) Load(cfgs []config.MaintenanceWindowConfig) error {
+	for _, cfg := range cfgs {
+		_, err := ws.Add(Window{
+			Pool:     cfg.Pool,
+			Cron:     cfg.Cron,
+			Duration: time.Duration(cfg.Duration) * time.Second,
+			Source:   SourceConfig,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Add validates and adds the window, it returns the window id.
+func (ws *Windows) Add(w Window) (int64, error) {
+	if w.Duration <= 0 {
+		return 0, errors.ErrInvalidArgument
+	}
+	schedule, err := ParseSchedule(w.Cron)
+	if err != nil {
+		return 0, err
+	}
+	window := &Window{Pool: w.Pool, Cron: w.Cron, Duration: w.Duration, Source: w.Source, schedule: schedule}
+	if window.Source == "" {
+		window.Source = SourceAdmin
+	}
+
+	ws.Lock()
+	defer ws.Unlock()
+	ws.nextID++
+	window.ID = ws.nextID
+	ws.windows = append(ws.windows, window)
+	return window.ID, nil
+}
+
+//Delete removes the window by id.
+func (ws *Windows) Delete(id int64) error {
+	ws.Lock()
+	defer ws.Unlock()
+	for i, w := range ws.windows {
+		if w.ID == id {
+			ws.windows = append(ws.windows[:i:i], ws.windows[i+1:]...)
+			return nil
+		}
+	}
+	return errors.ErrWindowNotExist
+}
+
+//List returns a copy of the windows ordered by id.
+func (ws *Windows) List() []Window {
+	ws.RLock()
+	defer ws.RUnlock()
+	windows := make([]Window, 0, len(ws.windows))
+	for _, w := range ws.windows {
+		windows = append(windows, *w)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].ID < windows[j].ID })
+	return windows
+}
+
+//Active returns the window the pool is in at t, nil if none.
+func (ws *Windows) Active(pool string, t time.Time) *Window {
+	ws.RLock()
+	defer ws.RUnlock()
+	for _, w := range ws.windows {
+		if (w.Pool == "" || w.Pool == pool) && w.ActiveAt(t) {
+			window := *w
+			return &window
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+func TestWindows(t *testing.T) {
+	ws := &Windows{}
+	if _, err := ws.Add(Window{Cron: "0 2 * * *"}); err != errors.ErrInvalidArgument {
+		t.Fatalf("expect window without duration rejected, got %v", err)
+	}
+	err := ws.Load([]config.MaintenanceWindowConfig{{Pool: "tp", Cron: "0 2 * * 6", Duration: 7200}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := ws.Add(Window{Cron: "30 12 * * *", Duration: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//saturday
+	day := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		pool   string
+		offset time.Duration
+		id     int64
+	}{
+		{"tp", 2 * time.Hour, 1},
+		{"tp", 3*time.Hour + 59*time.Minute + 59*time.Second, 1},
+		{"tp", 4 * time.Hour, 0},
+		{"ap", 2 * time.Hour, 0},
+		{"ap", 12*time.Hour + 35*time.Minute, id},
+		{"ap", 12*time.Hour + 40*time.Minute, 0},
+		//friday
+		{"tp", -22 * time.Hour, 0},
+	} {
+		w := ws.Active(c.pool, day.Add(c.offset))
+		if c.id == 0 && w != nil || c.id != 0 && (w == nil || w.ID != c.id) {
+			t.Errorf("expect window %d of %s at %v, got %+v", c.id, c.pool, c.offset, w)
+		}
+	}
+
+	if list := ws.List(); len(list) != 2 || list[0].Source != SourceConfig || list[1].Source != SourceAdmin {
+		t.Fatalf("unexpected windows %+v", list)
+	}
+	if err = ws.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if err = ws.Delete(id); err != errors.ErrWindowNotExist {
+		t.Fatalf("expect deleted window not exist, got %v", err)
+	}
+}
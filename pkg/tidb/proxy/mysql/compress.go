@@ -0,0 +1,263 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+//https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_compression.html
+const (
+	CLIENT_ZSTD_COMPRESSION_ALGORITHM uint32 = 1 << 26
+
+	CompressionZlib = "zlib"
+	CompressionZstd = "zstd"
+
+	//zstd level when the client sends none, the same as mysql
+	DefaultZstdLevel = 3
+	//payloads shorter than this are sent uncompressed, the same as mysql
+	MinCompressLength = 50
+
+	compressedHeaderSize = 7
+)
+
+var (
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+
+	zstdEncodersMu sync.Mutex
+	zstdEncoders   = make(map[zstd.EncoderLevel]*zstd.Encoder)
+)
+
+//zstd coders are shared by all connections, EncodeAll and DecodeAll are safe for
+//concurrent use and every coder keeps its own goroutines.
+func getZstdDecoder() (*zstd.Decoder, error) {
+	zstdDecoderOnce.Do(func() {
+		zstdDecoder, zstdDecoderErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(2*MaxPayloadLen)))
+	})
+	return zstdDecoder, zstdDecoderErr
+}
+
+func getZstdEncoder(level int) (*zstd.Encoder, error) {
+	l := zstd.EncoderLevelFromZstd(level)
+	zstdEncodersMu.Lock()
+	defer zstdEncodersMu.Unlock()
+	if enc, ok := zstdEncoders[l]; ok {
+		return enc, nil
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(l))
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[l] = enc
+	return enc, nil
+}
+
+//CompressedConn carries the packets of a connection in compressed frames, it is put
+//between the packet reader and writer and the network connection once the handshake
+//negotiates compression. Every frame has a 7 bytes header: compressed length, sequence
+//and uncompressed length, which is 0 when the payload is not compressed.
+type CompressedConn struct {
+	r io.Reader
+	w io.Writer
+
+	algorithm string
+	level     int
+	sequence  uint8
+
+	//decompressed data not read yet
+	buf []byte
+
+	zlibBuf    bytes.Buffer
+	zlibWriter *zlib.Writer
+	zstdEnc    *zstd.Encoder
+	zstdDec    *zstd.Decoder
+
+	//Observe is called with the payload size before and after compression of every frame
+	Observe func(out bool, raw, wire int)
+}
+
+//NewCompressedConn returns the compressed framing of r and w. Level is the zlib or zstd
+//level used to compress, 0 means the default of the algorithm.
+func NewCompressedConn(r io.Reader, w io.Writer, algorithm string, level int) (*CompressedConn, error) {
+	c := &CompressedConn{r: r, w: w, algorithm: algorithm, level: level}
+	var err error
+	switch algorithm {
+	case CompressionZlib:
+		if c.level == 0 {
+			c.level = zlib.DefaultCompression
+		}
+		c.zlibWriter, err = zlib.NewWriterLevel(&c.zlibBuf, c.level)
+	case CompressionZstd:
+		if c.level == 0 {
+			c.level = DefaultZstdLevel
+		}
+		if c.zstdEnc, err = getZstdEncoder(c.level); err == nil {
+			c.zstdDec, err = getZstdDecoder()
+		}
+	default:
+		err = fmt.Errorf("unknown compression algorithm %s", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *CompressedConn) Algorithm() string {
+	return c.algorithm
+}
+
+func (c *CompressedConn) Level() int {
+	return c.level
+}
+
+//ResetSequence starts the frame sequence of a new command, the side that sends the
+//command calls it. The replies follow the sequence of the frames read.
+func (c *CompressedConn) ResetSequence() {
+	c.sequence = 0
+}
+
+func (c *CompressedConn) Read(p []byte) (int, error) {
+	if len(c.buf) == 0 {
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *CompressedConn) readFrame() error {
+	var header [compressedHeaderSize]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return err
+	}
+	length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	c.sequence = header[3] + 1
+	rawLength := int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16)
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+	if rawLength == 0 {
+		c.buf = payload
+		c.observe(false, length, length)
+		return nil
+	}
+
+	var data []byte
+	var err error
+	switch c.algorithm {
+	case CompressionZlib:
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(bytes.NewReader(payload)); err == nil {
+			data = make([]byte, rawLength)
+			_, err = io.ReadFull(zr, data)
+			zr.Close()
+		}
+	case CompressionZstd:
+		data, err = c.zstdDec.DecodeAll(payload, make([]byte, 0, rawLength))
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) != rawLength {
+		return ErrMalformPacket
+	}
+	c.buf = data
+	c.observe(false, rawLength, length)
+	return nil
+}
+
+//Write sends p in frames, a frame holds at most MaxPayloadLen bytes.
+func (c *CompressedConn) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > MaxPayloadLen {
+			chunk = chunk[:MaxPayloadLen]
+		}
+		if err := c.writeFrame(chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+func (c *CompressedConn) writeFrame(data []byte) error {
+	payload, rawLength := data, 0
+	if len(data) >= MinCompressLength {
+		compressed, err := c.compress(data)
+		if err != nil {
+			return err
+		}
+		//keep the data as it is when it doesn't shrink
+		if len(compressed) < len(data) {
+			payload, rawLength = compressed, len(data)
+		}
+	}
+
+	frame := make([]byte, compressedHeaderSize, compressedHeaderSize+len(payload))
+	frame[0] = byte(len(payload))
+	frame[1] = byte(len(payload) >> 8)
+	frame[2] = byte(len(payload) >> 16)
+	frame[3] = c.sequence
+	frame[4] = byte(rawLength)
+	frame[5] = byte(rawLength >> 8)
+	frame[6] = byte(rawLength >> 16)
+	frame = append(frame, payload...)
+	if _, err := c.w.Write(frame); err != nil {
+		return err
+	}
+	c.sequence++
+	c.observe(true, len(data), len(payload))
+	return nil
+}
+
+func (c *CompressedConn) compress(data []byte) ([]byte, error) {
+	switch c.algorithm {
+	case CompressionZlib:
+		c.zlibBuf.Reset()
+		c.zlibWriter.Reset(&c.zlibBuf)
+		if _, err := c.zlibWriter.Write(data); err != nil {
+			return nil, err
+		}
+		if err := c.zlibWriter.Close(); err != nil {
+			return nil, err
+		}
+		return c.zlibBuf.Bytes(), nil
+	case CompressionZstd:
+		return c.zstdEnc.EncodeAll(data, nil), nil
+	}
+	return nil, fmt.Errorf("unknown compression algorithm %s", c.algorithm)
+}
+
+func (c *CompressedConn) observe(out bool, raw, wire int) {
+	if c.Observe != nil {
+		c.Observe(out, raw, wire)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCompressedConnRoundTrip(t *testing.T) {
+	small := []byte("select 1")
+	large := bytes.Repeat([]byte("select * from t where a = 1;"), 1000)
+	for _, algorithm := range []string{CompressionZlib, CompressionZstd} {
+		var wire bytes.Buffer
+		var rawOut, wireOut int
+		w, err := NewCompressedConn(nil, &wire, algorithm, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Observe = func(out bool, raw, n int) {
+			rawOut += raw
+			wireOut += n
+		}
+		for _, data := range [][]byte{small, large} {
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if rawOut != len(small)+len(large) || wireOut >= rawOut {
+			t.Fatalf("%s: raw %d, wire %d", algorithm, rawOut, wireOut)
+		}
+		//the small payload is sent as it is
+		if wire.Bytes()[4] != 0 || !bytes.Equal(wire.Bytes()[7:7+len(small)], small) {
+			t.Fatalf("%s: small payload compressed", algorithm)
+		}
+
+		r, err := NewCompressedConn(&wire, nil, algorithm, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, append(append([]byte{}, small...), large...)) {
+			t.Fatalf("%s: got %d bytes", algorithm, len(got))
+		}
+		//replies follow the sequence of the frames read
+		if r.sequence != 2 {
+			t.Fatalf("%s: sequence %d", algorithm, r.sequence)
+		}
+	}
+}
@@ -30,6 +30,9 @@ type PacketIO struct {
 	wb io.Writer
 
 	Sequence uint8
+
+	//compressed frames under the packets, nil if not negotiated
+	compressed *CompressedConn
 }
 
 func NewPacketIO(conn net.Conn) *PacketIO {
@@ -82,8 +85,28 @@ func (p *PacketIO) ReadPacket() ([]byte, error) {
 	}
 }
 
+//EnableCompression puts compressed frames under the packets once the handshake is done.
+func (p *PacketIO) EnableCompression(algorithm string, level int) (*CompressedConn, error) {
+	cc, err := NewCompressedConn(p.rb, p.wb, algorithm, level)
+	if err != nil {
+		return nil, err
+	}
+	p.compressed = cc
+	p.rb = bufio.NewReaderSize(cc, defaultReaderSize)
+	p.wb = cc
+	return cc, nil
+}
+
+//resetCompressedSequence starts the frame sequence with the first packet of a command.
+func (p *PacketIO) resetCompressedSequence() {
+	if p.compressed != nil && p.Sequence == 0 {
+		p.compressed.ResetSequence()
+	}
+}
+
 //data already have header
 func (p *PacketIO) WritePacket(data []byte) error {
+	p.resetCompressedSequence()
 	length := len(data) - 4
 
 	for length >= MaxPayloadLen {
@@ -134,6 +157,7 @@ func (p *PacketIO) WritePacketBatch(total, data []byte, direct bool) ([]byte, er
 		}
 		return total, nil
 	}
+	p.resetCompressedSequence()
 
 	length := len(data) - 4
 	for length >= MaxPayloadLen {
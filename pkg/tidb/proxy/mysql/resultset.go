@@ -267,6 +267,9 @@ type Result struct {
 	InsertId     uint64
 	AffectedRows uint64
 
+	//session state changes of the OK packet, with CLIENT_SESSION_TRACK
+	SessionStates []SessionState
+
 	*Resultset
 }
 
@@ -276,6 +279,8 @@ type Resultset struct {
 	Values     [][]interface{}
 
 	RowDatas []RowData
+	//rows over the limit of the proxy are dropped
+	Truncated bool
 }
 
 func (r *Resultset) RowNumber() int {
@@ -0,0 +1,129 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"strings"
+)
+
+//https://dev.mysql.com/doc/internals/en/packet-OK_Packet.html
+const (
+	SESSION_TRACK_SYSTEM_VARIABLES byte = iota
+	SESSION_TRACK_SCHEMA
+	SESSION_TRACK_STATE_CHANGE
+	SESSION_TRACK_GTIDS
+	SESSION_TRACK_TRANSACTION_CHARACTERISTICS
+	SESSION_TRACK_TRANSACTION_STATE
+)
+
+//SessionState is a session state change told by an OK packet, Data is the payload of its type
+type SessionState struct {
+	Type byte
+	Data []byte
+}
+
+//SysVarState tells the new value of a system variable
+func SysVarState(name, value string) SessionState {
+	data := PutLengthEncodedString([]byte(name))
+	data = append(data, PutLengthEncodedString([]byte(value))...)
+	return SessionState{Type: SESSION_TRACK_SYSTEM_VARIABLES, Data: data}
+}
+
+//SchemaState tells the new current database
+func SchemaState(db string) SessionState {
+	return SessionState{Type: SESSION_TRACK_SCHEMA, Data: PutLengthEncodedString([]byte(db))}
+}
+
+//StateChangeState tells that the session state changed
+func StateChangeState() SessionState {
+	return SessionState{Type: SESSION_TRACK_STATE_CHANGE, Data: PutLengthEncodedString([]byte("1"))}
+}
+
+//Key identifies what the state changes, a later state of the same key replaces the earlier one
+func (s SessionState) Key() string {
+	key := string([]byte{s.Type})
+	if s.Type == SESSION_TRACK_SYSTEM_VARIABLES {
+		if name, _, err := readLengthEncoded(s.Data); err == nil {
+			key += strings.ToLower(string(name))
+		}
+	}
+	return key
+}
+
+//ParseSessionStates parses the session state info of an OK packet, the states don't share
+//the packet buffer
+func ParseSessionStates(data []byte) ([]SessionState, error) {
+	var states []SessionState
+	for len(data) > 0 {
+		payload, n, err := readLengthEncoded(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, SessionState{Type: data[0], Data: append([]byte(nil), payload...)})
+		data = data[1+n:]
+	}
+	return states, nil
+}
+
+//ParseOKSessionStates parses the session state changes of an OK packet, data starts at the
+//info following the warnings
+func ParseOKSessionStates(data []byte) ([]SessionState, error) {
+	_, n, err := readLengthEncoded(data)
+	if err != nil {
+		return nil, err
+	}
+	info, _, err := readLengthEncoded(data[n:])
+	if err != nil {
+		return nil, err
+	}
+	return ParseSessionStates(info)
+}
+
+//DumpSessionStates encodes the states as the session state info of an OK packet
+func DumpSessionStates(states []SessionState) []byte {
+	var data []byte
+	for _, s := range states {
+		data = append(data, s.Type)
+		data = append(data, PutLengthEncodedString(s.Data)...)
+	}
+	return data
+}
+
+//readLengthEncoded reads a length encoded string checking the bounds, the packets of the
+//backends are not trusted to be well formed
+func readLengthEncoded(b []byte) ([]byte, int, error) {
+	if len(b) == 0 {
+		return nil, 0, ErrMalformPacket
+	}
+	size := 1
+	switch b[0] {
+	case 0xfc:
+		size = 3
+	case 0xfd:
+		size = 4
+	case 0xfe:
+		size = 9
+	case 0xfb, 0xff:
+		return nil, 0, ErrMalformPacket
+	}
+	if len(b) < size {
+		return nil, 0, ErrMalformPacket
+	}
+	num, _, n := LengthEncodedInt(b)
+	if uint64(len(b)-n) < num {
+		return nil, 0, ErrMalformPacket
+	}
+	return b[n : n+int(num)], n + int(num), nil
+}
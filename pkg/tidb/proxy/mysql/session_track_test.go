@@ -0,0 +1,54 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSessionStates(t *testing.T) {
+	gtids := SessionState{Type: SESSION_TRACK_GTIDS, Data: append([]byte{0}, PutLengthEncodedString([]byte("3E11FA47-71CA-11E1-9E33-C80AA9429562:23"))...)}
+	states := []SessionState{SysVarState("autocommit", "OFF"), SchemaState("test"), StateChangeState(), gtids}
+	data := DumpSessionStates(states)
+	parsed, err := ParseSessionStates(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(states) {
+		t.Fatalf("%d states parsed", len(parsed))
+	}
+	for i := range states {
+		if parsed[i].Type != states[i].Type || !bytes.Equal(parsed[i].Data, states[i].Data) {
+			t.Fatalf("state %d parsed as %v", i, parsed[i])
+		}
+	}
+	if SysVarState("AUTOCOMMIT", "ON").Key() != states[0].Key() || states[0].Key() == SysVarState("time_zone", "UTC").Key() {
+		t.Fatal("system variables keyed by another than their name")
+	}
+	if SchemaState("a").Key() != states[1].Key() {
+		t.Fatal("schema changes keyed apart")
+	}
+	ok := append(PutLengthEncodedString([]byte("Rows matched: 1")), PutLengthEncodedString(data)...)
+	if parsed, err = ParseOKSessionStates(ok); err != nil || len(parsed) != len(states) {
+		t.Fatalf("%d states parsed from the OK packet, %v", len(parsed), err)
+	}
+	//truncated packets are malformed instead of panicking
+	for _, bad := range [][]byte{data[:len(data)-1], {SESSION_TRACK_SCHEMA}, {SESSION_TRACK_SCHEMA, 0xfc, 1}} {
+		if _, err := ParseSessionStates(bad); err != ErrMalformPacket {
+			t.Fatalf("%v parsed with %v", bad, err)
+		}
+	}
+}
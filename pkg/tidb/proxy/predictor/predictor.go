@@ -0,0 +1,169 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package predictor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+const (
+	DefaultSlotSeconds = 300
+	//one day of 5 minute slots
+	DefaultSeasonSlots = 288
+	DefaultAlpha       = 0.3
+	DefaultGamma       = 0.2
+)
+
+//HoltWinters is an additive Holt-Winters model without trend.
+//Samples are averaged per slot, and every finished slot updates the level
+//and the seasonal component of its position in the season.
+type HoltWinters struct {
+	sync.Mutex
+
+	Alpha       float64   `json:"alpha"`
+	Gamma       float64   `json:"gamma"`
+	SlotSeconds int64     `json:"slot_seconds"`
+	Level       float64   `json:"level"`
+	Seasonal    []float64 `json:"seasonal"`
+	//Observed is the number of finished slots used for training.
+	Observed int64 `json:"observed"`
+	CurSlot  int64 `json:"cur_slot"`
+
+	slotSum   float64
+	slotCount int64
+}
+
+func New(slotSeconds int64, seasonSlots int, alpha, gamma float64) *HoltWinters {
+	if slotSeconds <= 0 {
+		slotSeconds = DefaultSlotSeconds
+	}
+	if seasonSlots <= 0 {
+		seasonSlots = DefaultSeasonSlots
+	}
+	return &HoltWinters{
+		Alpha:       alpha,
+		Gamma:       gamma,
+		SlotSeconds: slotSeconds,
+		Seasonal:    make([]float64, seasonSlots),
+	}
+}
+
+func NewDefault() *HoltWinters {
+	return New(DefaultSlotSeconds, DefaultSeasonSlots, DefaultAlpha, DefaultGamma)
+}
+
+func (m *HoltWinters) slotOf(t time.Time) int64 {
+	return t.Unix() / m.SlotSeconds
+}
+
+//Observe adds one sample, it returns true when a slot is finished and the model is updated.
+func (m *HoltWinters) Observe(now time.Time, v float64) bool {
+	m.Lock()
+	defer m.Unlock()
+	slot := m.slotOf(now)
+	if m.CurSlot == 0 {
+		m.CurSlot = slot
+	}
+	if slot == m.CurSlot {
+		m.slotSum += v
+		m.slotCount++
+		return false
+	}
+
+	var updated bool
+	if m.slotCount > 0 {
+		m.update(m.CurSlot, m.slotSum/float64(m.slotCount))
+		updated = true
+	}
+	m.CurSlot = slot
+	m.slotSum = v
+	m.slotCount = 1
+	return updated
+}
+
+func (m *HoltWinters) update(slot int64, y float64) {
+	idx := int(slot % int64(len(m.Seasonal)))
+	if m.Observed == 0 {
+		m.Level = y
+	}
+	season := m.Seasonal[idx]
+	level := m.Alpha*(y-season) + (1-m.Alpha)*m.Level
+	if m.Observed < int64(len(m.Seasonal)) {
+		//first season, seasonal component is the plain deviation
+		m.Seasonal[idx] = y - level
+	} else {
+		m.Seasonal[idx] = m.Gamma*(y-level) + (1-m.Gamma)*season
+	}
+	m.Level = level
+	m.Observed++
+}
+
+//Ready reports whether at least one full season has been observed.
+func (m *HoltWinters) Ready() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.Observed >= int64(len(m.Seasonal))
+}
+
+//Forecast returns the peak predicted value within (now, now+ahead].
+func (m *HoltWinters) Forecast(now time.Time, ahead time.Duration) float64 {
+	m.Lock()
+	defer m.Unlock()
+	slot := m.slotOf(now)
+	steps := int64(ahead.Seconds()) / m.SlotSeconds
+	if steps < 1 {
+		steps = 1
+	}
+	var peak float64
+	for h := int64(1); h <= steps; h++ {
+		idx := int((slot + h) % int64(len(m.Seasonal)))
+		if v := m.Level + m.Seasonal[idx]; v > peak {
+			peak = v
+		}
+	}
+	return peak
+}
+
+//Save persists the trained model, so it survives proxy restarts.
+func (m *HoltWinters) Save(fileName string) error {
+	m.Lock()
+	data, err := json.Marshal(m)
+	m.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
+
+//Load restores a model saved by Save.
+func Load(fileName string) (*HoltWinters, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	m := new(HoltWinters)
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.SlotSeconds <= 0 || len(m.Seasonal) == 0 {
+		return nil, errors.ErrInvalidModel
+	}
+	return m, nil
+}
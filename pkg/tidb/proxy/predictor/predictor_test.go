@@ -0,0 +1,80 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package predictor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//feed three seasons of 4 slots: slot 2 of every season is a peak.
+func trainModel(t *testing.T) (*HoltWinters, time.Time) {
+	m := New(60, 4, DefaultAlpha, DefaultGamma)
+	start := time.Unix(60*4*1000, 0)
+	now := start
+	for i := 0; i < 12; i++ {
+		v := 10.0
+		if i%4 == 2 {
+			v = 100.0
+		}
+		for j := 0; j < 6; j++ {
+			m.Observe(now, v)
+			now = now.Add(10 * time.Second)
+		}
+	}
+	//close the last slot
+	m.Observe(now, 10)
+	if !m.Ready() {
+		t.Fatalf("model should be ready after %d slots", m.Observed)
+	}
+	return m, now
+}
+
+func TestForecastPeak(t *testing.T) {
+	m, now := trainModel(t)
+	//now is at slot 0 of a season, the peak is two slots ahead.
+	if v := m.Forecast(now, time.Minute); v > 50 {
+		t.Fatalf("expect no peak in next slot, got %f", v)
+	}
+	if v := m.Forecast(now, 2*time.Minute); v < 50 {
+		t.Fatalf("expect peak within two slots, got %f", v)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	m, now := trainModel(t)
+	dir, err := ioutil.TempDir("", "predictor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "tp.json")
+	if err = m.Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := Load(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m2.Ready() || m2.Forecast(now, 2*time.Minute) != m.Forecast(now, 2*time.Minute) {
+		t.Fatal("loaded model differs from saved one")
+	}
+	if _, err = Load(filepath.Join(dir, "none.json")); err == nil {
+		t.Fatal("expect error for missing file")
+	}
+}
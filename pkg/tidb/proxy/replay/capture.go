@@ -0,0 +1,185 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+//Record is one captured statement, records are written as json lines.
+type Record struct {
+	//unix nano when the statement started
+	Ts         int64  `json:"ts"`
+	ConnID     uint64 `json:"conn_id"`
+	DB         string `json:"db"`
+	SQL        string `json:"sql"`
+	Normalized string `json:"normalized"`
+	Digest     string `json:"digest"`
+	Pool       string `json:"pool"`
+	//microseconds
+	Duration int64 `json:"duration"`
+	Rows     int64 `json:"rows"`
+	Checksum uint64 `json:"checksum"`
+	Error    string `json:"error,omitempty"`
+}
+
+//NewRecord fills the normalized text and digest of the statement.
+func NewRecord(sql string) *Record {
+	normalized, digest := parser.NormalizeDigest(sql)
+	return &Record{
+		SQL:        sql,
+		Normalized: normalized,
+		Digest:     digest.String(),
+	}
+}
+
+//CapturePath returns the path of the capture log name in dir. The name must be a plain
+//file name, so a request can't read or write files out of dir.
+func CapturePath(dir, name string) (string, error) {
+	if dir == "" {
+		return "", errors.ErrCaptureDisabled
+	}
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) || filepath.IsAbs(name) {
+		return "", errors.ErrCaptureFile
+	}
+	return filepath.Join(dir, name), nil
+}
+
+//Recorder appends captured statements to a log file.
+type Recorder struct {
+	sync.Mutex
+
+	file   *os.File
+	writer *bufio.Writer
+	count  int64
+}
+
+var defaultRecorder = new(Recorder)
+
+func DefaultRecorder() *Recorder {
+	return defaultRecorder
+}
+
+func (r *Recorder) Start(fileName string) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.file != nil {
+		return errors.ErrCaptureRunning
+	}
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.file = f
+	r.writer = bufio.NewWriter(f)
+	r.count = 0
+	return nil
+}
+
+//Stop flushes and closes the log, it returns the number of captured statements.
+func (r *Recorder) Stop() (int64, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.file == nil {
+		return 0, errors.ErrCaptureNotRunning
+	}
+	err := r.writer.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file = nil
+	r.writer = nil
+	return r.count, err
+}
+
+func (r *Recorder) Enabled() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.file != nil
+}
+
+func (r *Recorder) Write(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	data = append(data, '\n')
+	if _, err = r.writer.Write(data); err != nil {
+		return err
+	}
+	r.count++
+	return nil
+}
+
+//Checksum is an order independent checksum of result rows,
+//so queries without ORDER BY compare equal across backends.
+type Checksum struct {
+	sum  uint64
+	rows int64
+	row  []byte
+}
+
+//AddValue adds one column of the current row.
+func (c *Checksum) AddValue(v []byte, isNull bool) {
+	if isNull {
+		c.row = append(c.row, 0xfb)
+		return
+	}
+	c.row = append(c.row, mysql.PutLengthEncodedString(v)...)
+}
+
+//EndRow finishes the current row.
+func (c *Checksum) EndRow() {
+	c.sum += uint64(crc32.ChecksumIEEE(c.row))
+	c.rows++
+	c.row = c.row[:0]
+}
+
+//AddTextRow adds a row of text protocol resultset.
+func (c *Checksum) AddTextRow(data []byte, columns int) error {
+	var pos int
+	for i := 0; i < columns; i++ {
+		v, isNull, n, err := mysql.LengthEnodedString(data[pos:])
+		if err != nil {
+			return err
+		}
+		c.AddValue(v, isNull)
+		pos += n
+	}
+	c.EndRow()
+	return nil
+}
+
+func (c *Checksum) Sum() uint64 {
+	return c.sum
+}
+
+func (c *Checksum) Rows() int64 {
+	return c.rows
+}
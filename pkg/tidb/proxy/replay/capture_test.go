@@ -0,0 +1,81 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package replay
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+func textRow(values ...[]byte) []byte {
+	var data []byte
+	for _, v := range values {
+		if v == nil {
+			data = append(data, 0xfb)
+			continue
+		}
+		data = append(data, mysql.PutLengthEncodedString(v)...)
+	}
+	return data
+}
+
+func TestChecksumOrder(t *testing.T) {
+	rows := [][][]byte{
+		{[]byte("1"), []byte("a")},
+		{[]byte("2"), nil},
+		{[]byte("3"), []byte("")},
+	}
+
+	c1 := new(Checksum)
+	for _, row := range rows {
+		if err := c1.AddTextRow(textRow(row...), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c2 := new(Checksum)
+	for i := len(rows) - 1; i >= 0; i-- {
+		for _, v := range rows[i] {
+			c2.AddValue(v, v == nil)
+		}
+		c2.EndRow()
+	}
+	if c1.Sum() != c2.Sum() || c1.Rows() != 3 || c2.Rows() != 3 {
+		t.Fatalf("checksum mismatch %d %d", c1.Sum(), c2.Sum())
+	}
+
+	c3 := new(Checksum)
+	c3.AddTextRow(textRow([]byte("2"), []byte("")), 2)
+	c4 := new(Checksum)
+	c4.AddTextRow(textRow([]byte("2"), nil), 2)
+	if c3.Sum() == c4.Sum() {
+		t.Fatal("null and empty string should differ")
+	}
+}
+
+func TestCapturePath(t *testing.T) {
+	if _, err := CapturePath("", "a.log"); err != errors.ErrCaptureDisabled {
+		t.Fatalf("expect capture disabled without dir, got %v", err)
+	}
+	if path, err := CapturePath("/var/lib/proxy/capture", "a.log"); err != nil || path != "/var/lib/proxy/capture/a.log" {
+		t.Fatalf("unexpected path %s %v", path, err)
+	}
+	for _, name := range []string{"", ".", "..", "../a.log", "/etc/passwd", "sub/a.log"} {
+		if _, err := CapturePath("/var/lib/proxy/capture", name); err != errors.ErrCaptureFile {
+			t.Fatalf("expect %q rejected, got %v", name, err)
+		}
+	}
+}
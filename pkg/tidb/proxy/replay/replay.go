@@ -0,0 +1,267 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package replay
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	// mysql driver for replaying against backends
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+const maxMismatches = 100
+
+type Options struct {
+	File     string `json:"file"`
+	Addr     string `json:"addr"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	//replay speed relative to the capture, 0 means as fast as possible
+	Speed float64 `json:"speed"`
+	//replay the statements that write too, by default only reads are replayed
+	Writes bool `json:"writes"`
+}
+
+type Mismatch struct {
+	SQL      string `json:"sql"`
+	Expected uint64 `json:"expected"`
+	Actual   uint64 `json:"actual"`
+	Error    string `json:"error,omitempty"`
+}
+
+//Report compares the replay with the capture.
+type Report struct {
+	Running    bool  `json:"running"`
+	Statements int64 `json:"statements"`
+	//statements that write, not replayed without Options.Writes
+	Skipped    int64      `json:"skipped"`
+	Errors     int64      `json:"errors"`
+	Mismatched int64      `json:"mismatched"`
+	Mismatches []Mismatch `json:"mismatches,omitempty"`
+	//microseconds
+	CaptureLatency int64  `json:"capture_latency"`
+	ReplayLatency  int64  `json:"replay_latency"`
+	Error          string `json:"error,omitempty"`
+}
+
+//Replayer re-drives a captured workload, statements of one captured connection
+//run in order on one connection of the target.
+type Replayer struct {
+	sync.Mutex
+	report Report
+}
+
+var defaultReplayer = new(Replayer)
+
+func DefaultReplayer() *Replayer {
+	return defaultReplayer
+}
+
+func (r *Replayer) Report() Report {
+	r.Lock()
+	defer r.Unlock()
+	report := r.report
+	report.Mismatches = append([]Mismatch(nil), r.report.Mismatches...)
+	return report
+}
+
+//Start runs the replay in background, the progress is reported by Report.
+func (r *Replayer) Start(opts Options) error {
+	r.Lock()
+	if r.report.Running {
+		r.Unlock()
+		return errors.ErrReplayRunning
+	}
+	r.report = Report{Running: true}
+	r.Unlock()
+
+	go func() {
+		err := r.run(opts)
+		r.Lock()
+		r.report.Running = false
+		if err != nil {
+			r.report.Error = err.Error()
+		}
+		r.Unlock()
+	}()
+	return nil
+}
+
+func readRecords(fileName string) (map[uint64][]*Record, int64, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	conns := make(map[uint64][]*Record)
+	var firstTs int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		rec := new(Record)
+		if err = json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			return nil, 0, err
+		}
+		if firstTs == 0 || rec.Ts < firstTs {
+			firstTs = rec.Ts
+		}
+		conns[rec.ConnID] = append(conns[rec.ConnID], rec)
+	}
+	return conns, firstTs, scanner.Err()
+}
+
+func (r *Replayer) run(opts Options) error {
+	conns, firstTs, err := readRecords(opts.File)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s)/", opts.User, opts.Password, opts.Addr))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	start := time.Now()
+	wg := &sync.WaitGroup{}
+	for _, recs := range conns {
+		wg.Add(1)
+		go func(recs []*Record) {
+			defer wg.Done()
+			r.replayConn(db, recs, start, firstTs, opts)
+		}(recs)
+	}
+	wg.Wait()
+	return nil
+}
+
+//IsRead reports whether the statements of sql only read, without locks or files written.
+func IsRead(sql string) bool {
+	stmts, _, err := parser.New().Parse(sql, "", "")
+	if err != nil || len(stmts) == 0 {
+		return false
+	}
+	for _, stmt := range stmts {
+		if sel, ok := stmt.(*ast.SelectStmt); ok && sel.SelectIntoOpt != nil {
+			return false
+		}
+		if !ast.IsReadOnly(stmt) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Replayer) replayConn(db *sql.DB, recs []*Record, start time.Time, firstTs int64, opts Options) {
+	speed := opts.Speed
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		r.Lock()
+		r.report.Errors += int64(len(recs))
+		r.Unlock()
+		return
+	}
+	defer conn.Close()
+
+	var curDB string
+	for _, rec := range recs {
+		if !opts.Writes && !IsRead(rec.SQL) {
+			r.Lock()
+			r.report.Skipped++
+			r.Unlock()
+			continue
+		}
+		if speed > 0 {
+			offset := time.Duration(float64(rec.Ts-firstTs) / speed)
+			if wait := offset - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		if rec.DB != "" && rec.DB != curDB {
+			if _, err = conn.ExecContext(ctx, "USE `"+rec.DB+"`"); err == nil {
+				curDB = rec.DB
+			}
+		}
+		begin := time.Now()
+		sum, err := execChecksum(ctx, conn, rec.SQL)
+		r.addResult(rec, sum, time.Since(begin), err)
+	}
+}
+
+func execChecksum(ctx context.Context, conn *sql.Conn, query string) (*Checksum, error) {
+	sum := new(Checksum)
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			sum.AddValue(v, v == nil)
+		}
+		sum.EndRow()
+	}
+	return sum, rows.Err()
+}
+
+func (r *Replayer) addResult(rec *Record, sum *Checksum, latency time.Duration, err error) {
+	r.Lock()
+	defer r.Unlock()
+	report := &r.report
+	report.Statements++
+	report.CaptureLatency += rec.Duration
+	report.ReplayLatency += latency.Microseconds()
+
+	var m *Mismatch
+	switch {
+	case err != nil:
+		report.Errors++
+		if rec.Error == "" {
+			m = &Mismatch{SQL: rec.SQL, Expected: rec.Checksum, Error: err.Error()}
+		}
+	case rec.Error == "" && sum.Sum() != rec.Checksum:
+		m = &Mismatch{SQL: rec.SQL, Expected: rec.Checksum, Actual: sum.Sum()}
+	}
+	if m == nil {
+		return
+	}
+	report.Mismatched++
+	if len(report.Mismatches) < maxMismatches {
+		report.Mismatches = append(report.Mismatches, *m)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package replay
+
+import (
+	"testing"
+
+	_ "github.com/pingcap/tidb/types/parser_driver"
+)
+
+func TestIsRead(t *testing.T) {
+	cases := map[string]bool{
+		"select * from t where id = 1":            true,
+		"select a from t union select b from t2":  true,
+		"show tables":                             true,
+		"explain select 1":                        true,
+		"select * from t for update":              false,
+		"select * from t into outfile '/tmp/out'": false,
+		"insert into t values (1)":                false,
+		"explain analyze delete from t":           false,
+		"select 1; delete from t":                 false,
+		"not sql":                                 false,
+	}
+	for sql, expected := range cases {
+		if IsRead(sql) != expected {
+			t.Fatalf("%s: expect %v", sql, expected)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package rewrite
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+const (
+	PinPoolTP = "tp"
+	PinPoolAP = "ap"
+)
+
+//hintPosition matches the leading comments and the first keyword of a statement,
+//optimizer hints go right after the keyword.
+var hintPosition = regexp.MustCompile(`(?is)^(\s*(?:/\*.*?\*/\s*)*(?:select|insert|update|delete|replace)\b)`)
+
+//Pin routes statements of a digest to a pool, overriding the cost based classifier, and
+//optionally adds optimizer hints to them, e.g. READ_FROM_STORAGE(TIFLASH[t]).
+type Pin struct {
+	ID     int64  `json:"id"`
+	Digest string `json:"digest"`
+	User   string `json:"user"`
+	Pool   string `json:"pool"`
+	Hint   string `json:"hint"`
+	Hits   int64  `json:"hits"`
+}
+
+//AddPin validates and adds the pin, a pin of the same digest and user is replaced.
+//It returns the pin id.
+func (e *Engine) AddPin(p Pin) (int64, error) {
+	p.Pool = strings.ToLower(p.Pool)
+	if p.Digest == "" || p.Digest == AnyDigest || (p.Pool != PinPoolTP && p.Pool != PinPoolAP) {
+		return 0, errors.ErrInvalidArgument
+	}
+	p.Hint = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(p.Hint), "/*+"), "*/"))
+	pin := &Pin{Digest: p.Digest, User: p.User, Pool: p.Pool, Hint: p.Hint}
+
+	e.Lock()
+	for i, old := range e.pins {
+		if old.Digest == pin.Digest && old.User == pin.User {
+			e.pins = append(e.pins[:i:i], e.pins[i+1:]...)
+			break
+		}
+	}
+	e.nextPinID++
+	pin.ID = e.nextPinID
+	e.pins = append(e.pins, pin)
+	atomic.StoreInt32(&e.pinCount, int32(len(e.pins)))
+	snap := e.snapshot()
+	e.Unlock()
+	e.save(snap)
+	return pin.ID, nil
+}
+
+//DeletePin removes the pin by id.
+func (e *Engine) DeletePin(id int64) error {
+	e.Lock()
+	for i, p := range e.pins {
+		if p.ID == id {
+			e.pins = append(e.pins[:i:i], e.pins[i+1:]...)
+			atomic.StoreInt32(&e.pinCount, int32(len(e.pins)))
+			snap := e.snapshot()
+			e.Unlock()
+			e.save(snap)
+			return nil
+		}
+	}
+	e.Unlock()
+	return errors.ErrPinNotExist
+}
+
+//Pins returns a copy of the pins ordered by id.
+func (e *Engine) Pins() []Pin {
+	e.RLock()
+	defer e.RUnlock()
+	pins := make([]Pin, 0, len(e.pins))
+	for _, p := range e.pins {
+		c := *p
+		c.Hits = atomic.LoadInt64(&p.Hits)
+		pins = append(pins, c)
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].ID < pins[j].ID })
+	return pins
+}
+
+//PinOf returns the pin of the statement digest, a pin for the user is preferred over one
+//for every user. count tells whether the pin is used to route the statement.
+func (e *Engine) PinOf(digest, user string, count bool) (Pin, bool) {
+	if atomic.LoadInt32(&e.pinCount) == 0 {
+		return Pin{}, false
+	}
+	e.RLock()
+	defer e.RUnlock()
+	var found *Pin
+	for _, p := range e.pins {
+		if p.Digest != digest || (p.User != "" && p.User != user) {
+			continue
+		}
+		if found == nil || p.User != "" {
+			found = p
+		}
+	}
+	if found == nil {
+		return Pin{}, false
+	}
+	if count {
+		atomic.AddInt64(&found.Hits, 1)
+	}
+	return *found, true
+}
+
+//WithHint adds the optimizer hint of the pin after the first keyword of the statement.
+func (p Pin) WithHint(sql string) string {
+	if p.Hint == "" {
+		return sql
+	}
+	loc := hintPosition.FindStringIndex(sql)
+	if loc == nil {
+		return sql
+	}
+	return sql[:loc[1]] + " /*+ " + p.Hint + " */" + sql[loc[1]:]
+}
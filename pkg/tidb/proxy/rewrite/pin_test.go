@@ -0,0 +1,127 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package rewrite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap/parser"
+)
+
+func TestPinByDigest(t *testing.T) {
+	e := &Engine{}
+	_, d := parser.NormalizeDigest("select count(*) from t where a > 1")
+	if _, err := e.AddPin(Pin{Digest: d.String(), Pool: "AP", Hint: "/*+ READ_FROM_STORAGE(TIFLASH[t]) */"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddPin(Pin{Digest: d.String(), User: "report", Pool: "tp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, ok := e.PinOf(digestOf("select count(*) from t where a > 5"), "app", true)
+	if !ok || p.Pool != PinPoolAP {
+		t.Fatalf("unexpected pin %+v %v", p, ok)
+	}
+	if sql := p.WithHint("select count(*) from t where a > 5"); sql != "select /*+ READ_FROM_STORAGE(TIFLASH[t]) */ count(*) from t where a > 5" {
+		t.Fatalf("unexpected hinted statement %q", sql)
+	}
+	if p, ok = e.PinOf(digestOf("select count(*) from t where a > 5"), "report", true); !ok || p.Pool != PinPoolTP {
+		t.Fatalf("user pin not preferred %+v", p)
+	}
+	if _, ok = e.PinOf(digestOf("select a from t"), "app", true); ok {
+		t.Fatal("statement of another digest pinned")
+	}
+	if pins := e.Pins(); len(pins) != 2 || pins[0].Hits != 1 {
+		t.Fatalf("unexpected pins %+v", pins)
+	}
+
+	if _, err := e.AddPin(Pin{Digest: d.String(), Pool: "big"}); err == nil {
+		t.Fatal("invalid pool accepted")
+	}
+	if err := e.DeletePin(42); err == nil {
+		t.Fatal("delete of a missing pin succeeded")
+	}
+}
+
+func TestRulesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "rules.json")
+
+	e := &Engine{}
+	if err = e.Open(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = e.Add(Rule{Digest: AnyDigest, Match: `(?i)from\s+t\b`, Replace: "FROM t FORCE INDEX(idx)"}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := e.AddPin(Pin{Digest: "abc", Pool: "ap"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &Engine{}
+	if err = loaded.Open(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if sql, ok := rewriteOf(loaded, "select a from t", "u", "tp"); !ok || sql != "select a FROM t FORCE INDEX(idx)" {
+		t.Fatalf("rule not loaded %q %v", sql, ok)
+	}
+	if pins := loaded.Pins(); len(pins) != 1 || pins[0].ID != id {
+		t.Fatalf("unexpected pins %+v", pins)
+	}
+	if err = loaded.DeletePin(id); err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := loaded.AddPin(Pin{Digest: "def", Pool: "tp"}); next <= id {
+		t.Fatalf("pin id %d reused", next)
+	}
+}
+
+func TestSaveDropsStaleSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "rules.json")
+
+	e := &Engine{}
+	if err = e.Open(fileName); err != nil {
+		t.Fatal(err)
+	}
+	//a change saved after a later one, as the saves run outside the lock
+	e.Lock()
+	stale := e.snapshot()
+	e.Unlock()
+	if _, err = e.AddPin(Pin{Digest: "abc", Pool: "ap"}); err != nil {
+		t.Fatal(err)
+	}
+	e.save(stale)
+
+	loaded := &Engine{}
+	if err = loaded.Open(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if pins := loaded.Pins(); len(pins) != 1 {
+		t.Fatalf("stale rules saved over the latest ones, pins %+v", pins)
+	}
+}
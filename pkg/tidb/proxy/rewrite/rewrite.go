@@ -0,0 +1,152 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package rewrite
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+//AnyDigest matches statements of every digest.
+const AnyDigest = "*"
+
+//wholeStatement is the match of a rule without pattern, $0 in the template is the statement.
+var wholeStatement = regexp.MustCompile(`(?s)^.*$`)
+
+//Rule rewrites statements of a digest, optionally only for a user or routing class.
+//Match is a regexp on the statement text and Replace its template, $0 and $1.. refer to
+//the match. A rule without match replaces the whole statement.
+type Rule struct {
+	ID      int64  `json:"id"`
+	Digest  string `json:"digest"`
+	User    string `json:"user"`
+	Class   string `json:"class"`
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+	Hits    int64  `json:"hits"`
+
+	re *regexp.Regexp
+}
+
+//Engine holds the rewrite rules, it is safe for concurrent use.
+type Engine struct {
+	sync.RWMutex
+	rules  []*Rule
+	nextID int64
+	//number of rules, read without the lock to skip digesting when there are none
+	count int32
+
+	pins      []*Pin
+	nextPinID int64
+	pinCount  int32
+	//rules file the rules and pins are saved to, set by Open
+	file string
+	//version of the rules and pins, bumped by every change, and the version saved last
+	version int64
+	saveMu  sync.Mutex
+	saved   int64
+}
+
+var defaultEngine = &Engine{}
+
+//DefaultEngine returns the engine used by the proxy.
+func DefaultEngine() *Engine {
+	return defaultEngine
+}
+
+//Add validates and adds the rule, it returns the rule id.
+func (e *Engine) Add(r Rule) (int64, error) {
+	if r.Digest == "" || r.Replace == "" {
+		return 0, errors.ErrInvalidArgument
+	}
+	re := wholeStatement
+	if r.Match != "" {
+		var err error
+		if re, err = regexp.Compile(r.Match); err != nil {
+			return 0, err
+		}
+	}
+	rule := &Rule{Digest: r.Digest, User: r.User, Class: r.Class, Match: r.Match, Replace: r.Replace, re: re}
+
+	e.Lock()
+	e.nextID++
+	rule.ID = e.nextID
+	e.rules = append(e.rules, rule)
+	atomic.StoreInt32(&e.count, int32(len(e.rules)))
+	snap := e.snapshot()
+	e.Unlock()
+	e.save(snap)
+	return rule.ID, nil
+}
+
+//Delete removes the rule by id.
+func (e *Engine) Delete(id int64) error {
+	e.Lock()
+	for i, r := range e.rules {
+		if r.ID == id {
+			e.rules = append(e.rules[:i:i], e.rules[i+1:]...)
+			atomic.StoreInt32(&e.count, int32(len(e.rules)))
+			snap := e.snapshot()
+			e.Unlock()
+			e.save(snap)
+			return nil
+		}
+	}
+	e.Unlock()
+	return errors.ErrRewriteNotExist
+}
+
+//Rules returns a copy of the rules ordered by id.
+func (e *Engine) Rules() []Rule {
+	e.RLock()
+	defer e.RUnlock()
+	rules := make([]Rule, 0, len(e.rules))
+	for _, r := range e.rules {
+		c := *r
+		c.Hits = atomic.LoadInt64(&r.Hits)
+		c.re = nil
+		rules = append(rules, c)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+func (r *Rule) matches(digest, user, class string) bool {
+	return (r.Digest == AnyDigest || r.Digest == digest) &&
+		(r.User == "" || r.User == user) &&
+		(r.Class == "" || r.Class == class)
+}
+
+//Rewrite applies the first rule matching the statement of the digest, it returns the
+//statement unchanged and false if none does.
+func (e *Engine) Rewrite(sql, digest, user, class string) (string, bool) {
+	if atomic.LoadInt32(&e.count) == 0 {
+		return sql, false
+	}
+	e.RLock()
+	defer e.RUnlock()
+	for _, r := range e.rules {
+		if !r.matches(digest, user, class) || !r.re.MatchString(sql) {
+			continue
+		}
+		atomic.AddInt64(&r.Hits, 1)
+		return r.re.ReplaceAllString(sql, r.Replace), true
+	}
+	return sql, false
+}
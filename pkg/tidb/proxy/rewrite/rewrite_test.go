@@ -0,0 +1,84 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package rewrite
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser"
+)
+
+func digestOf(sql string) string {
+	_, d := parser.NormalizeDigest(sql)
+	return d.String()
+}
+
+func rewriteOf(e *Engine, sql, user, class string) (string, bool) {
+	return e.Rewrite(sql, digestOf(sql), user, class)
+}
+
+func TestRewriteByDigest(t *testing.T) {
+	e := &Engine{}
+	_, d := parser.NormalizeDigest("select * from t where a = 1")
+	if _, err := e.Add(Rule{Digest: d.String(), Replace: "$0 LIMIT 1000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sql, ok := rewriteOf(e, "select * from t where a = 2", "u", "tp")
+	if !ok || sql != "select * from t where a = 2 LIMIT 1000" {
+		t.Fatalf("unexpected rewrite %q %v", sql, ok)
+	}
+	if sql, ok = rewriteOf(e, "select * from t where a = 2 limit 5", "u", "tp"); ok {
+		t.Fatalf("statement of another digest rewritten to %q", sql)
+	}
+	if rules := e.Rules(); len(rules) != 1 || rules[0].Hits != 1 {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+}
+
+func TestRewriteFilters(t *testing.T) {
+	e := &Engine{}
+	id, err := e.Add(Rule{Digest: AnyDigest, Class: "ap", Match: `(?i)^\s*select`,
+		Replace: "SELECT /*+ READ_FROM_STORAGE(TIFLASH[t]) */"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = e.Add(Rule{Digest: AnyDigest, User: "report", Match: `(?i)from\s+t\b`, Replace: "FROM t FORCE INDEX(idx)"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if sql, _ := rewriteOf(e, "select count(*) from t", "app", "ap"); sql != "SELECT /*+ READ_FROM_STORAGE(TIFLASH[t]) */ count(*) from t" {
+		t.Fatalf("unexpected ap rewrite %q", sql)
+	}
+	if sql, ok := rewriteOf(e, "select count(*) from t", "app", "tp"); ok {
+		t.Fatalf("tp statement rewritten to %q", sql)
+	}
+	if sql, _ := rewriteOf(e, "select a from t where b = 1", "report", "tp"); sql != "select a FROM t FORCE INDEX(idx) where b = 1" {
+		t.Fatalf("unexpected user rewrite %q", sql)
+	}
+
+	if err = e.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if sql, ok := rewriteOf(e, "select count(*) from t", "app", "ap"); ok {
+		t.Fatalf("deleted rule rewrote %q", sql)
+	}
+	if err = e.Delete(id); err == nil {
+		t.Fatal("delete of a missing rule succeeded")
+	}
+	if _, err = e.Add(Rule{Digest: AnyDigest, Match: "(", Replace: "x"}); err == nil {
+		t.Fatal("invalid match accepted")
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package rewrite
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+//storedRules is the content of the rules file.
+type storedRules struct {
+	Rules []Rule `json:"rules"`
+	Pins  []Pin  `json:"pins"`
+}
+
+//Open loads the rewrite rules and pins saved in the file, and saves them to it whenever
+//they change. A missing file is not an error, it is created on the first change.
+func (e *Engine) Open(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var stored storedRules
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+	}
+
+	rules := make([]*Rule, 0, len(stored.Rules))
+	var nextID int64
+	for _, r := range stored.Rules {
+		re := wholeStatement
+		if r.Match != "" {
+			if re, err = regexp.Compile(r.Match); err != nil {
+				return err
+			}
+		}
+		rule := r
+		rule.re = re
+		rules = append(rules, &rule)
+		if r.ID > nextID {
+			nextID = r.ID
+		}
+	}
+	pins := make([]*Pin, 0, len(stored.Pins))
+	var nextPinID int64
+	for _, p := range stored.Pins {
+		pin := p
+		pins = append(pins, &pin)
+		if p.ID > nextPinID {
+			nextPinID = p.ID
+		}
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	e.rules, e.nextID = rules, nextID
+	e.pins, e.nextPinID = pins, nextPinID
+	e.file = fileName
+	atomic.StoreInt32(&e.count, int32(len(e.rules)))
+	atomic.StoreInt32(&e.pinCount, int32(len(e.pins)))
+	return nil
+}
+
+//rulesSnapshot is the content of the rules file after a change, by the version of the change.
+type rulesSnapshot struct {
+	file    string
+	version int64
+	stored  storedRules
+}
+
+//snapshot copies the rules and pins of a change for save, the caller holds the lock.
+func (e *Engine) snapshot() rulesSnapshot {
+	e.version++
+	snap := rulesSnapshot{file: e.file, version: e.version}
+	if e.file == "" {
+		return snap
+	}
+	snap.stored = storedRules{Rules: make([]Rule, 0, len(e.rules)), Pins: make([]Pin, 0, len(e.pins))}
+	for _, r := range e.rules {
+		c := *r
+		c.Hits = 0
+		c.re = nil
+		snap.stored.Rules = append(snap.stored.Rules, c)
+	}
+	for _, p := range e.pins {
+		c := *p
+		c.Hits = 0
+		snap.stored.Pins = append(snap.stored.Pins, c)
+	}
+	return snap
+}
+
+//save writes the snapshot to the rules file, the caller doesn't hold the lock so the
+//statements looking up the rules don't wait for the disk. A snapshot older than the one
+//saved last is dropped. The change stays in effect if it can't be saved.
+func (e *Engine) save(snap rulesSnapshot) {
+	if snap.file == "" {
+		return
+	}
+	e.saveMu.Lock()
+	defer e.saveMu.Unlock()
+	if snap.version <= e.saved {
+		return
+	}
+	data, err := json.MarshalIndent(snap.stored, "", "  ")
+	if err == nil {
+		//write a temp file and rename it, so a crash doesn't leave half a file
+		tmp := snap.file + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, snap.file)
+		}
+	}
+	if err != nil {
+		golog.Warn("rewrite", "save", "save rules failed", 0, "file", snap.file, "error", err)
+		return
+	}
+	e.saved = snap.version
+}
@@ -0,0 +1,224 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package scaler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/scalepb"
+)
+
+const (
+	DefaultAuditSize = 256
+
+	MethodScaleCluster      = "ScaleCluster"
+	MethodAutoScalerCluster = "AutoScalerCluster"
+	MethodPreflight         = "Preflight"
+
+	callOK      = "ok"
+	callFailed  = "failed"
+	callLimited = "limited"
+)
+
+//Call is the audit record of one scale rpc.
+type Call struct {
+	Time     int64                `json:"time"`
+	Caller   string               `json:"caller"`
+	Method   string               `json:"method"`
+	Request  interface{}          `json:"request"`
+	Response *scalepb.UpdateReply `json:"response,omitempty"`
+	Latency  float64              `json:"latency_ms"`
+	Error    string               `json:"error,omitempty"`
+	Limited  bool                 `json:"limited,omitempty"`
+}
+
+//limiter is a token bucket, a zero rate means no limit.
+type limiter struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (l *limiter) allow(now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+//auditLog keeps the last calls in a ring.
+type auditLog struct {
+	sync.Mutex
+	calls []Call
+	next  int
+	full  bool
+}
+
+func newAuditLog(size int) *auditLog {
+	if size <= 0 {
+		size = DefaultAuditSize
+	}
+	return &auditLog{calls: make([]Call, size)}
+}
+
+func (a *auditLog) add(call Call) {
+	a.Lock()
+	defer a.Unlock()
+	a.calls[a.next] = call
+	a.next++
+	if a.next == len(a.calls) {
+		a.next = 0
+		a.full = true
+	}
+}
+
+//list returns the calls from the newest to the oldest.
+func (a *auditLog) list() []Call {
+	a.Lock()
+	defer a.Unlock()
+	n := a.next
+	if a.full {
+		n = len(a.calls)
+	}
+	calls := make([]Call, 0, n)
+	for i := 1; i <= n; i++ {
+		calls = append(calls, a.calls[(a.next-i+len(a.calls))%len(a.calls)])
+	}
+	return calls
+}
+
+//dispatch sends one scale rpc through the rate limiter and records it, caller names the
+//policy that asks for the scale.
+func (c *Client) dispatch(ctx context.Context, caller, method string, req interface{},
+	fn func(ctx context.Context, sc scalepb.ScaleClient) (*scalepb.UpdateReply, error)) (*scalepb.UpdateReply, error) {
+	start := time.Now()
+	call := Call{Time: start.Unix(), Caller: caller, Method: method, Request: req}
+	if !c.limiter.allow(start) {
+		call.Limited = true
+		call.Error = errors.ErrScaleRateLimited.Error()
+		c.record(ctx, call)
+		metrics.ProxyScaleCallCounter.WithLabelValues(caller, callLimited).Inc()
+		golog.Warn("scaler", "dispatch", "scale request rate limited", 0,
+			"caller", caller, "method", method)
+		return nil, errors.ErrScaleRateLimited
+	}
+
+	var reply *scalepb.UpdateReply
+	err := c.Do(ctx, func(ctx context.Context, sc scalepb.ScaleClient) error {
+		var err error
+		reply, err = fn(ctx, sc)
+		return err
+	})
+	call.Response = reply
+	call.Latency = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		call.Error = err.Error()
+		metrics.ProxyScaleCallCounter.WithLabelValues(caller, callFailed).Inc()
+		golog.Error("scaler", "dispatch", "scale request failed", 0,
+			"caller", caller, "method", method, "error", err)
+	} else {
+		metrics.ProxyScaleCallCounter.WithLabelValues(caller, callOK).Inc()
+	}
+	c.record(ctx, call)
+	return reply, err
+}
+
+//record audits the call and sends it to the webhooks.
+func (c *Client) record(ctx context.Context, call Call) {
+	c.audit.add(call)
+	c.Lock()
+	webhook := c.webhook
+	c.Unlock()
+	if webhook != nil {
+		webhook.notify(newEvent(ctx, call))
+	}
+}
+
+//ScaleCluster asks the scale operator for the given cores of a pool.
+func (c *Client) ScaleCluster(ctx context.Context, caller string, req *scalepb.ScaleRequest) (*scalepb.UpdateReply, error) {
+	return c.dispatch(ctx, caller, MethodScaleCluster, req,
+		func(ctx context.Context, sc scalepb.ScaleClient) (*scalepb.UpdateReply, error) {
+			return sc.ScaleCluster(ctx, req)
+		})
+}
+
+//AutoScalerCluster reports a scale out or in decision to the scale operator.
+func (c *Client) AutoScalerCluster(ctx context.Context, caller string, req *scalepb.AutoScaleRequest) (*scalepb.UpdateReply, error) {
+	return c.dispatch(ctx, caller, MethodAutoScalerCluster, req,
+		func(ctx context.Context, sc scalepb.ScaleClient) (*scalepb.UpdateReply, error) {
+			return sc.AutoScalerCluster(ctx, req)
+		})
+}
+
+//PreflightRequest is the tidb rejected by the preflight, recorded as the request.
+type PreflightRequest struct {
+	Addr   string `json:"addr"`
+	Pool   string `json:"pool"`
+	Reason string `json:"reason"`
+}
+
+//RecordRejected records the tidb rejected by the preflight in the scale history next to
+//the scale rpcs, and sends it to the webhooks.
+func (c *Client) RecordRejected(addr, pool, reason string, err error) {
+	c.record(context.Background(), Call{
+		Time:    time.Now().Unix(),
+		Caller:  "preflight",
+		Method:  MethodPreflight,
+		Request: &PreflightRequest{Addr: addr, Pool: pool, Reason: reason},
+		Error:   err.Error(),
+	})
+}
+
+//Calls returns the audited scale rpcs, the newest first.
+func (c *Client) Calls() []Call {
+	return c.audit.list()
+}
+
+//ScaleCluster calls ScaleCluster with the default client.
+func ScaleCluster(ctx context.Context, caller string, req *scalepb.ScaleRequest) (*scalepb.UpdateReply, error) {
+	return Default().ScaleCluster(ctx, caller, req)
+}
+
+//AutoScalerCluster calls AutoScalerCluster with the default client.
+func AutoScalerCluster(ctx context.Context, caller string, req *scalepb.AutoScaleRequest) (*scalepb.UpdateReply, error) {
+	return Default().AutoScalerCluster(ctx, caller, req)
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package scaler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1, 2)
+	now := time.Now()
+	if !l.allow(now) || !l.allow(now) {
+		t.Fatal("burst should be allowed")
+	}
+	if l.allow(now) {
+		t.Fatal("call over burst should be limited")
+	}
+	if !l.allow(now.Add(time.Second)) {
+		t.Fatal("token should be refilled after a second")
+	}
+
+	unlimited := newLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if !unlimited.allow(now) {
+			t.Fatal("zero rate should not limit")
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	a := newAuditLog(3)
+	if len(a.list()) != 0 {
+		t.Fatal("empty log should have no calls")
+	}
+	for i := 1; i <= 5; i++ {
+		a.add(Call{Time: int64(i)})
+	}
+	calls := a.list()
+	if len(calls) != 3 {
+		t.Fatalf("expect 3 calls, got %d", len(calls))
+	}
+	for i, want := range []int64{5, 4, 3} {
+		if calls[i].Time != want {
+			t.Fatalf("call %d: expect %d, got %d", i, want, calls[i].Time)
+		}
+	}
+}
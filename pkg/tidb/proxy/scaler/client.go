@@ -0,0 +1,480 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package scaler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/failpoint"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/scalepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DefaultAddr          = "scale-operator.sldb-admin.svc:8028"
+	DefaultDialTimeout   = 5
+	DefaultRetryBackoff  = 200
+	DefaultProbeInterval = 10
+)
+
+//endpoint is a scaler address and its connection.
+type endpoint struct {
+	addr string
+	conn *grpc.ClientConn
+	//set when the endpoint fails to dial or answer, cleared by a success or a probe
+	down        bool
+	lastErr     error
+	lastSuccess time.Time
+}
+
+//Client is the grpc client of the scale operator, it dials lazily and
+//reconnects on the next call after the connection is broken. Calls go to the
+//active endpoint and fail over to the next one when it is unavailable.
+type Client struct {
+	sync.Mutex
+
+	cfg       config.ScalerConfig
+	endpoints []*endpoint
+	//index of the endpoint calls go to
+	active int
+
+	//scale rpcs are rate limited and audited
+	limiter *limiter
+	audit   *auditLog
+	//nil if no webhook is configured
+	webhook *notifier
+	//closed to stop the probes
+	stop chan struct{}
+}
+
+type Health struct {
+	Addr        string `json:"addr"`
+	TLS         bool   `json:"tls"`
+	Connected   bool   `json:"connected"`
+	State       string `json:"state"`
+	LastError   string `json:"last_error,omitempty"`
+	LastSuccess int64  `json:"last_success,omitempty"`
+	//false while no endpoint is reachable, scale in decisions are paused
+	Available bool             `json:"available"`
+	Endpoints []EndpointHealth `json:"endpoints"`
+}
+
+type EndpointHealth struct {
+	Addr        string `json:"addr"`
+	Active      bool   `json:"active"`
+	Down        bool   `json:"down"`
+	LastError   string `json:"last_error,omitempty"`
+	LastSuccess int64  `json:"last_success,omitempty"`
+}
+
+//defaultClient holds the *Client calls go to, it is replaced by Init on a config reload
+//while the calls read it.
+var defaultClient atomic.Value
+
+func init() {
+	defaultClient.Store(NewClient(config.ScalerConfig{}))
+}
+
+func NewClient(cfg config.ScalerConfig) *Client {
+	if cfg.Addr == "" && len(cfg.Addrs) == 0 {
+		cfg.Addr = DefaultAddr
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = DefaultDialTimeout
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultRetryBackoff
+	}
+	if cfg.ProbeInterval == 0 {
+		cfg.ProbeInterval = DefaultProbeInterval
+	}
+	c := &Client{
+		cfg:     cfg,
+		limiter: newLimiter(cfg.RateLimit, cfg.RateBurst),
+		audit:   newAuditLog(cfg.AuditSize),
+		webhook: newNotifier(cfg.Webhooks),
+		stop:    make(chan struct{}),
+	}
+	seen := make(map[string]bool)
+	for _, addr := range append([]string{cfg.Addr}, cfg.Addrs...) {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			c.endpoints = append(c.endpoints, &endpoint{addr: addr})
+		}
+	}
+	return c
+}
+
+//Init replaces the default client with the given config, and starts probing its endpoints.
+func Init(cfg config.ScalerConfig) {
+	c := NewClient(cfg)
+	old := Default()
+	defaultClient.Store(c)
+	old.Close()
+	c.updateAvailable()
+	go c.runProbes()
+}
+
+func Default() *Client {
+	return defaultClient.Load().(*Client)
+}
+
+//Do calls fn with the default client.
+func Do(ctx context.Context, fn func(ctx context.Context, c scalepb.ScaleClient) error) error {
+	return Default().Do(ctx, fn)
+}
+
+func (c *Client) dialOptions() ([]grpc.DialOption, error) {
+	opts := []grpc.DialOption{grpc.WithBlock()}
+	if c.cfg.CA == "" {
+		opts = append(opts, grpc.WithInsecure())
+	} else {
+		tlsCfg, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg)))
+	}
+	if c.cfg.KeepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(c.cfg.KeepaliveTime) * time.Second,
+			Timeout:             time.Duration(c.cfg.KeepaliveTimeout) * time.Second,
+			PermitWithoutStream: true,
+		}))
+	}
+	return opts, nil
+}
+
+func (c *Client) tlsConfig() (*tls.Config, error) {
+	ca, err := ioutil.ReadFile(c.cfg.CA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.ErrInvalidCert
+	}
+	tlsCfg := &tls.Config{
+		RootCAs:    pool,
+		ServerName: c.cfg.ServerName,
+	}
+	if c.cfg.Cert != "" || c.cfg.Key != "" {
+		cert, err := tls.LoadX509KeyPair(c.cfg.Cert, c.cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+//getConn returns the cached connection of the endpoint, or dials a new one if it is
+//missing or broken. The dial doesn't hold the lock, so probing an endpoint that is down
+//doesn't hold up the calls to the others.
+func (c *Client) getConn(ep *endpoint) (*grpc.ClientConn, error) {
+	c.Lock()
+	if ep.conn != nil {
+		switch ep.conn.GetState() {
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			ep.conn.Close()
+			ep.conn = nil
+		default:
+			conn := ep.conn
+			c.Unlock()
+			return conn, nil
+		}
+	}
+	c.Unlock()
+
+	opts, err := c.dialOptions()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.cfg.DialTimeout)*time.Second)
+		var conn *grpc.ClientConn
+		conn, err = grpc.DialContext(ctx, ep.addr, opts...)
+		cancel()
+		if err == nil {
+			c.Lock()
+			defer c.Unlock()
+			if ep.conn != nil {
+				//dialed at the same time by another call
+				conn.Close()
+				return ep.conn, nil
+			}
+			ep.conn = conn
+			return conn, nil
+		}
+		golog.Warn("scaler", "getConn", "dial scaler failed", 0, "address", ep.addr, "error", err)
+	}
+	c.Lock()
+	ep.lastErr = err
+	c.Unlock()
+	return nil, err
+}
+
+//current returns the active endpoint.
+func (c *Client) current() *endpoint {
+	c.Lock()
+	defer c.Unlock()
+	return c.endpoints[c.active]
+}
+
+//succeed marks the endpoint up after it answered.
+func (c *Client) succeed(ep *endpoint) {
+	c.Lock()
+	ep.down = false
+	ep.lastErr = nil
+	ep.lastSuccess = time.Now()
+	c.Unlock()
+	c.updateAvailable()
+}
+
+//failover marks the endpoint down, and moves the calls to the next endpoint if it is the
+//active one.
+func (c *Client) failover(ep *endpoint, err error) {
+	c.Lock()
+	ep.down = true
+	ep.lastErr = err
+	if ep.conn != nil {
+		ep.conn.Close()
+		ep.conn = nil
+	}
+	var from, to string
+	if c.endpoints[c.active] == ep && len(c.endpoints) > 1 {
+		c.active = (c.active + 1) % len(c.endpoints)
+		from, to = ep.addr, c.endpoints[c.active].addr
+	}
+	c.Unlock()
+	if to != "" {
+		metrics.ProxyScalerFailoverCounter.Inc()
+		golog.Warn("scaler", "failover", "scaler unavailable, fail over to the next endpoint", 0,
+			"from", from, "to", to, "error", err)
+	}
+	c.updateAvailable()
+}
+
+func retryable(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+//injectedError is the error of the proxyScalerError failpoint, its value is the grpc
+//code of the error, Unavailable if it is not a number.
+func injectedError() (err error) {
+	failpoint.Inject("proxyScalerError", func(val failpoint.Value) {
+		code := codes.Unavailable
+		if v, ok := val.(int); ok {
+			code = codes.Code(v)
+		}
+		err = status.Error(code, "injected scaler failure")
+	})
+	return
+}
+
+//Do calls fn with the scaler client, it fails over to the other endpoints and retries
+//with backoff when the scaler is unavailable.
+func (c *Client) Do(ctx context.Context, fn func(ctx context.Context, c scalepb.ScaleClient) error) error {
+	var err error
+	backoff := time.Duration(c.cfg.RetryBackoff) * time.Millisecond
+	for i := 0; i <= c.cfg.MaxRetries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		for n := 0; n < len(c.endpoints); n++ {
+			ep := c.current()
+			if err = c.call(ctx, ep, fn); err == nil {
+				c.succeed(ep)
+				return nil
+			}
+			if !retryable(err) {
+				c.Lock()
+				ep.lastErr = err
+				c.Unlock()
+				return err
+			}
+			c.failover(ep, err)
+		}
+	}
+	return err
+}
+
+func (c *Client) call(ctx context.Context, ep *endpoint, fn func(ctx context.Context, c scalepb.ScaleClient) error) error {
+	conn, err := c.getConn(ep)
+	if err != nil {
+		return err
+	}
+	if c.cfg.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.cfg.CallTimeout)*time.Second)
+		defer cancel()
+	}
+	if err = injectedError(); err != nil {
+		return err
+	}
+	return fn(ctx, scalepb.NewScaleClient(conn))
+}
+
+//runProbes dials the endpoints every probe interval, so a scaler that comes back is
+//known before the next call. Calls move back to the first endpoint up, in config order.
+func (c *Client) runProbes() {
+	if c.cfg.ProbeInterval < 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(c.cfg.ProbeInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.probe()
+		}
+	}
+}
+
+func (c *Client) probe() {
+	for _, ep := range c.endpoints {
+		conn, err := c.getConn(ep)
+		if err == nil {
+			if state := conn.GetState(); state != connectivity.Ready && state != connectivity.Idle {
+				err = fmt.Errorf("scaler connection is %s", state)
+			}
+		}
+		c.Lock()
+		ep.down = err != nil
+		if err != nil {
+			ep.lastErr = err
+		}
+		c.Unlock()
+	}
+	c.Lock()
+	for i, ep := range c.endpoints {
+		if !ep.down {
+			if i != c.active {
+				golog.Info("scaler", "probe", "move calls to the scaler endpoint up", 0,
+					"from", c.endpoints[c.active].addr, "to", ep.addr)
+				c.active = i
+			}
+			break
+		}
+	}
+	c.Unlock()
+	c.updateAvailable()
+}
+
+//Available reports whether an endpoint of the scaler is reachable, by the last calls
+//and probes.
+func (c *Client) Available() bool {
+	c.Lock()
+	defer c.Unlock()
+	for _, ep := range c.endpoints {
+		if !ep.down {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) updateAvailable() {
+	if c.Available() {
+		metrics.ProxyScalerAvailableGauge.Set(1)
+	} else {
+		metrics.ProxyScalerAvailableGauge.Set(0)
+	}
+}
+
+//Health reports the connectivity to the active scaler endpoint, it dials if there is no
+//connection, and the state of every endpoint. The dial doesn't hold the lock.
+func (c *Client) Health() Health {
+	ep := c.current()
+	h := Health{
+		Addr:      ep.addr,
+		TLS:       c.cfg.CA != "",
+		Available: c.Available(),
+	}
+	conn, err := c.getConn(ep)
+	if err != nil {
+		h.State = connectivity.TransientFailure.String()
+	} else {
+		state := conn.GetState()
+		h.State = state.String()
+		h.Connected = state == connectivity.Ready || state == connectivity.Idle
+	}
+	c.Lock()
+	defer c.Unlock()
+	if ep.lastErr != nil {
+		h.LastError = ep.lastErr.Error()
+	}
+	if !ep.lastSuccess.IsZero() {
+		h.LastSuccess = ep.lastSuccess.Unix()
+	}
+	for i, e := range c.endpoints {
+		eh := EndpointHealth{Addr: e.addr, Active: i == c.active, Down: e.down}
+		if e.lastErr != nil {
+			eh.LastError = e.lastErr.Error()
+		}
+		if !e.lastSuccess.IsZero() {
+			eh.LastSuccess = e.lastSuccess.Unix()
+		}
+		h.Endpoints = append(h.Endpoints, eh)
+	}
+	return h
+}
+
+func (c *Client) Close() {
+	c.Lock()
+	defer c.Unlock()
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	if c.webhook != nil {
+		c.webhook.close()
+		c.webhook = nil
+	}
+	for _, ep := range c.endpoints {
+		if ep.conn != nil {
+			ep.conn.Close()
+			ep.conn = nil
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package scaler
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/scalepb"
+	"google.golang.org/grpc"
+)
+
+type testScaleServer struct {
+	scalepb.UnimplementedScaleServer
+}
+
+func (*testScaleServer) ScaleCluster(ctx context.Context, req *scalepb.ScaleRequest) (*scalepb.UpdateReply, error) {
+	return &scalepb.UpdateReply{Success: true}, nil
+}
+
+//closedAddr returns an address nothing listens on.
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestClientFailover(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	scalepb.RegisterScaleServer(srv, &testScaleServer{})
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	down := closedAddr(t)
+	c := NewClient(config.ScalerConfig{Addr: down, Addrs: []string{l.Addr().String()}, DialTimeout: 1, ProbeInterval: -1})
+	defer c.Close()
+	reply, err := c.ScaleCluster(context.Background(), "test", &scalepb.ScaleRequest{Scaletype: "tp"})
+	if err != nil || !reply.Success {
+		t.Fatalf("scale call not failed over: %v %v", reply, err)
+	}
+	h := c.Health()
+	if h.Addr != l.Addr().String() || !h.Available || len(h.Endpoints) != 2 || !h.Endpoints[0].Down || h.Endpoints[1].Down {
+		t.Fatalf("unexpected health %+v", h)
+	}
+
+	c.probe()
+	if c.current().addr != l.Addr().String() {
+		t.Fatal("calls moved back to the endpoint that is down")
+	}
+}
+
+func TestClientUnavailable(t *testing.T) {
+	c := NewClient(config.ScalerConfig{Addr: closedAddr(t), Addrs: []string{closedAddr(t)}, DialTimeout: 1, ProbeInterval: -1})
+	defer c.Close()
+	if !c.Available() {
+		t.Fatal("scaler unavailable before any call")
+	}
+	if _, err := c.ScaleCluster(context.Background(), "test", &scalepb.ScaleRequest{Scaletype: "tp"}); err == nil {
+		t.Fatal("expect error without any scaler")
+	}
+	if c.Available() {
+		t.Fatal("scaler available with every endpoint down")
+	}
+}
+
+func TestInitWhileCalling(t *testing.T) {
+	cfg := config.ScalerConfig{Addr: closedAddr(t), DialTimeout: 1, ProbeInterval: -1}
+	defer Init(config.ScalerConfig{ProbeInterval: -1})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			Init(cfg)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if Default() == nil {
+			t.Fatal("no default client while reloading")
+		}
+		Default().Health()
+	}
+	wg.Wait()
+	if Default().cfg.Addr != cfg.Addr {
+		t.Fatalf("default client at %s, want %s", Default().cfg.Addr, cfg.Addr)
+	}
+}
+
+//writeCert writes a self signed certificate and its key to dir, and returns their paths.
+func writeCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "scaler"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := writeCert(t, dir)
+	bad := filepath.Join(dir, "bad.pem")
+	if err := ioutil.WriteFile(bad, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewClient(config.ScalerConfig{}).dialOptions(); err != nil {
+		t.Fatalf("insecure dial options: %v", err)
+	}
+	if _, err := NewClient(config.ScalerConfig{CA: filepath.Join(dir, "missing.pem")}).tlsConfig(); err == nil {
+		t.Fatal("expect error for a missing ca")
+	}
+	if _, err := NewClient(config.ScalerConfig{CA: bad}).tlsConfig(); err != errors.ErrInvalidCert {
+		t.Fatalf("invalid ca got %v, want %v", err, errors.ErrInvalidCert)
+	}
+	if _, err := NewClient(config.ScalerConfig{CA: cert, Cert: cert}).tlsConfig(); err == nil {
+		t.Fatal("expect error for a cert without its key")
+	}
+
+	tlsCfg, err := NewClient(config.ScalerConfig{CA: cert, ServerName: "scaler"}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsCfg.RootCAs == nil || tlsCfg.ServerName != "scaler" || len(tlsCfg.Certificates) != 0 {
+		t.Fatalf("unexpected tls config %+v", tlsCfg)
+	}
+	tlsCfg, err = NewClient(config.ScalerConfig{CA: cert, Cert: cert, Key: key}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tlsCfg.Certificates) != 1 {
+		t.Fatalf("client certificate not loaded, got %d", len(tlsCfg.Certificates))
+	}
+	if _, err := NewClient(config.ScalerConfig{CA: cert, Cert: cert, Key: key}).dialOptions(); err != nil {
+		t.Fatalf("mtls dial options: %v", err)
+	}
+}
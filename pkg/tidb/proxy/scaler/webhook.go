@@ -0,0 +1,225 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package scaler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/scalepb"
+)
+
+const (
+	DefaultWebhookTimeout    = 5
+	DefaultWebhookMaxRetries = 3
+	//events waiting for the webhooks, newer events are dropped when it is full
+	webhookQueueSize = 256
+	webhookBackoff   = time.Second
+
+	//header of the hex hmac-sha256 of the body with the webhook secret
+	SignatureHeader = "X-Proxy-Signature"
+
+	DirectionOut = "out"
+	DirectionIn  = "in"
+	//the scaler is asked for the cores of the pool
+	DirectionSet = "set"
+
+	webhookOK      = "ok"
+	webhookFailed  = "failed"
+	webhookDropped = "dropped"
+)
+
+//Event is the json posted to the webhooks for every scale decision.
+type Event struct {
+	Time      int64   `json:"time"`
+	Cluster   string  `json:"cluster"`
+	Namespace string  `json:"namespace"`
+	Pool      string  `json:"pool"`
+	Direction string  `json:"direction"`
+	Caller    string  `json:"caller"`
+	Cores     float32 `json:"cores"`
+	//metrics the policy decided on, e.g. the current cores
+	Reason  map[string]float64 `json:"reason,omitempty"`
+	Success bool               `json:"success"`
+	Error   string             `json:"error,omitempty"`
+	Limited bool               `json:"limited,omitempty"`
+	Latency float64            `json:"latency_ms"`
+}
+
+type reasonKey struct{}
+
+//WithReason attaches the metrics a scale decision is based on to the context of the
+//scale rpc, they are sent with the event to the webhooks.
+func WithReason(ctx context.Context, reason map[string]float64) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+func newEvent(ctx context.Context, call Call) *Event {
+	ev := &Event{
+		Time:    call.Time,
+		Caller:  call.Caller,
+		Success: call.Error == "",
+		Error:   call.Error,
+		Limited: call.Limited,
+		Latency: call.Latency,
+	}
+	ev.Reason, _ = ctx.Value(reasonKey{}).(map[string]float64)
+	switch req := call.Request.(type) {
+	case *scalepb.ScaleRequest:
+		ev.Cluster, ev.Namespace, ev.Pool, ev.Cores = req.Clustername, req.Namespace, req.Scaletype, req.Hashrate
+		ev.Direction = DirectionSet
+	case *scalepb.AutoScaleRequest:
+		ev.Cluster, ev.Namespace, ev.Pool, ev.Cores = req.Clustername, req.Namespace, req.Scaletype, req.Hashrate
+		ev.Direction = DirectionOut
+		if req.Autoscaler == 2 {
+			ev.Direction = DirectionIn
+		}
+	case *PreflightRequest:
+		ev.Pool = req.Pool
+	}
+	if ev.Success && call.Response != nil && !call.Response.Success {
+		ev.Success = false
+	}
+	return ev
+}
+
+//Sign returns the signature of the body sent in SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//notifier posts the events to the webhooks in the background, an event is retried with
+//backoff until it is delivered to a webhook or its retries are used up.
+type notifier struct {
+	hooks  []config.WebhookConfig
+	client *http.Client
+	events chan *Event
+	stop   chan struct{}
+}
+
+func newNotifier(hooks []config.WebhookConfig) *notifier {
+	if len(hooks) == 0 {
+		return nil
+	}
+	n := &notifier{
+		hooks:  make([]config.WebhookConfig, 0, len(hooks)),
+		client: &http.Client{},
+		events: make(chan *Event, webhookQueueSize),
+		stop:   make(chan struct{}),
+	}
+	for _, hook := range hooks {
+		if hook.URL == "" {
+			continue
+		}
+		if hook.Timeout <= 0 {
+			hook.Timeout = DefaultWebhookTimeout
+		}
+		if hook.MaxRetries == 0 {
+			hook.MaxRetries = DefaultWebhookMaxRetries
+		}
+		n.hooks = append(n.hooks, hook)
+	}
+	go n.run()
+	return n
+}
+
+func (n *notifier) notify(ev *Event) {
+	select {
+	case n.events <- ev:
+	default:
+		metrics.ProxyScaleWebhookCounter.WithLabelValues(webhookDropped).Inc()
+		golog.Warn("scaler", "notify", "webhook queue full, drop scale event", 0,
+			"caller", ev.Caller, "pool", ev.Pool)
+	}
+}
+
+func (n *notifier) run() {
+	for {
+		select {
+		case <-n.stop:
+			return
+		case ev := <-n.events:
+			body, err := json.Marshal(ev)
+			if err != nil {
+				golog.Error("scaler", "notifier", "encode scale event failed", 0, "error", err)
+				continue
+			}
+			for _, hook := range n.hooks {
+				n.deliver(hook, body)
+			}
+		}
+	}
+}
+
+//deliver posts the body to the webhook, a negative max retries means no retry.
+func (n *notifier) deliver(hook config.WebhookConfig, body []byte) {
+	backoff := webhookBackoff
+	var err error
+	for i := 0; i <= hook.MaxRetries; i++ {
+		if i > 0 {
+			select {
+			case <-n.stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = n.post(hook, body); err == nil {
+			metrics.ProxyScaleWebhookCounter.WithLabelValues(webhookOK).Inc()
+			return
+		}
+	}
+	metrics.ProxyScaleWebhookCounter.WithLabelValues(webhookFailed).Inc()
+	golog.Warn("scaler", "deliver", "post scale event failed", 0,
+		"url", hook.URL, "error", err)
+}
+
+func (n *notifier) post(hook config.WebhookConfig, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(hook.Timeout)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook replied status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (n *notifier) close() {
+	close(n.stop)
+}
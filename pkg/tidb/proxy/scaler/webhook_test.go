@@ -0,0 +1,71 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package scaler
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/scalepb"
+)
+
+func TestWebhook(t *testing.T) {
+	var calls int32
+	events := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&calls, 1) == 1 {
+			//the first post fails and is retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+			t.Errorf("unexpected signature %s", r.Header.Get(SignatureHeader))
+		}
+		var ev Event
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer srv.Close()
+
+	n := newNotifier([]config.WebhookConfig{{URL: srv.URL, Secret: "secret", MaxRetries: 1}})
+	defer n.close()
+	ctx := WithReason(context.Background(), map[string]float64{"current_cores": 2})
+	n.notify(newEvent(ctx, Call{
+		Time:     1,
+		Caller:   "auto_scale_out",
+		Method:   MethodAutoScalerCluster,
+		Request:  &scalepb.AutoScaleRequest{Clustername: "c", Hashrate: 4, Autoscaler: 1, Scaletype: "tp"},
+		Response: &scalepb.UpdateReply{Success: true},
+	}))
+
+	select {
+	case ev := <-events:
+		if ev.Direction != DirectionOut || ev.Pool != "tp" || ev.Cores != 4 || !ev.Success || ev.Reason["current_cores"] != 2 {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+}
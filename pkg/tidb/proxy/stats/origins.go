@@ -0,0 +1,160 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+//number of closed slices kept by DefaultOrigins
+const DefaultOriginSlices = 24
+
+//origin names, the same as the cost origins of backend
+const (
+	OriginProxy   = "proxy"
+	OriginForward = "forward"
+)
+
+//OriginStat is the workload of a cost class run from an origin, executed in the proxy
+//or forwarded to a backend tidb, in a time slice.
+type OriginStat struct {
+	Class  string `json:"class"`
+	Origin string `json:"origin"`
+	Count  int64  `json:"count"`
+	//microseconds
+	TotalLatency int64 `json:"total_latency_us"`
+	MeanLatency  int64 `json:"mean_latency_us"`
+	MaxLatency   int64 `json:"max_latency_us"`
+	TotalCost    int64 `json:"total_cost"`
+	MeanCost     int64 `json:"mean_cost"`
+}
+
+//OriginSlice is the workload of every class and origin between Start and End.
+type OriginSlice struct {
+	Start int64        `json:"start"`
+	End   int64        `json:"end"`
+	Stats []OriginStat `json:"stats"`
+}
+
+//Stat returns the workload of the class from the origin, false if none ran in the slice.
+func (s *OriginSlice) Stat(class, origin string) (OriginStat, bool) {
+	for _, st := range s.Stats {
+		if st.Class == class && st.Origin == origin {
+			return st, true
+		}
+	}
+	return OriginStat{}, false
+}
+
+//LatencyRatio returns the mean latency of the class run in the proxy over the forwarded
+//one, false unless both origins ran the class in the slice.
+func (s *OriginSlice) LatencyRatio(class string) (float64, bool) {
+	local, ok := s.Stat(class, OriginProxy)
+	if !ok {
+		return 0, false
+	}
+	forward, ok := s.Stat(class, OriginForward)
+	if !ok || forward.MeanLatency == 0 {
+		return 0, false
+	}
+	return float64(local.MeanLatency) / float64(forward.MeanLatency), true
+}
+
+type originKey struct {
+	class, origin string
+}
+
+//Origins slices the statements by time, class and origin, to compare the latency of
+//statements run in the proxy with the ones forwarded.
+type Origins struct {
+	sync.Mutex
+	keep   int
+	start  time.Time
+	cur    map[originKey]*OriginStat
+	slices []OriginSlice
+}
+
+var defaultOrigins = NewOrigins(DefaultOriginSlices)
+
+//DefaultOrigins returns the origin workload of the proxy.
+func DefaultOrigins() *Origins {
+	return defaultOrigins
+}
+
+func NewOrigins(keep int) *Origins {
+	return &Origins{keep: keep, start: time.Now(), cur: make(map[originKey]*OriginStat)}
+}
+
+//Add accounts a statement of the class from the origin to the current slice.
+func (o *Origins) Add(class, origin string, latency time.Duration, cost int64) {
+	o.Lock()
+	defer o.Unlock()
+	k := originKey{class, origin}
+	st, ok := o.cur[k]
+	if !ok {
+		st = &OriginStat{Class: class, Origin: origin}
+		o.cur[k] = st
+	}
+	us := latency.Microseconds()
+	st.Count++
+	st.TotalLatency += us
+	if us > st.MaxLatency {
+		st.MaxLatency = us
+	}
+	st.TotalCost += cost
+}
+
+//Rotate closes the current slice at now and returns it, the oldest slice is dropped once
+//more than keep are closed.
+func (o *Origins) Rotate(now time.Time) OriginSlice {
+	o.Lock()
+	defer o.Unlock()
+	s := o.slice(now)
+	o.slices = append(o.slices, s)
+	if len(o.slices) > o.keep {
+		o.slices = o.slices[len(o.slices)-o.keep:]
+	}
+	o.start = now
+	o.cur = make(map[originKey]*OriginStat)
+	return s
+}
+
+//Slices returns the closed slices, oldest first, followed by the current one up to now.
+func (o *Origins) Slices(now time.Time) []OriginSlice {
+	o.Lock()
+	defer o.Unlock()
+	rs := make([]OriginSlice, 0, len(o.slices)+1)
+	rs = append(rs, o.slices...)
+	return append(rs, o.slice(now))
+}
+
+func (o *Origins) slice(now time.Time) OriginSlice {
+	s := OriginSlice{Start: o.start.Unix(), End: now.Unix(), Stats: make([]OriginStat, 0, len(o.cur))}
+	for _, st := range o.cur {
+		v := *st
+		v.MeanLatency = v.TotalLatency / v.Count
+		v.MeanCost = v.TotalCost / v.Count
+		s.Stats = append(s.Stats, v)
+	}
+	sort.Slice(s.Stats, func(i, j int) bool {
+		if s.Stats[i].Class != s.Stats[j].Class {
+			return s.Stats[i].Class < s.Stats[j].Class
+		}
+		return s.Stats[i].Origin < s.Stats[j].Origin
+	})
+	return s
+}
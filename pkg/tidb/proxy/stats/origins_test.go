@@ -0,0 +1,55 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOriginsRotate(t *testing.T) {
+	o := NewOrigins(2)
+	o.Add("tp", "proxy", 2*time.Millisecond, 10)
+	o.Add("tp", "proxy", 4*time.Millisecond, 30)
+	o.Add("tp", "forward", time.Millisecond, 10)
+	s := o.Rotate(time.Now())
+	st, ok := s.Stat("tp", "proxy")
+	if !ok || st.Count != 2 || st.MeanLatency != 3000 || st.MaxLatency != 4000 || st.MeanCost != 20 {
+		t.Fatalf("proxy tp stat %+v", st)
+	}
+	if r, ok := s.LatencyRatio("tp"); !ok || r != 3 {
+		t.Fatalf("tp latency ratio %v %v, want 3", r, ok)
+	}
+	if _, ok := s.Stat("ap", "proxy"); ok {
+		t.Fatalf("ap stat in slice without ap statements")
+	}
+	if _, ok := s.LatencyRatio("ap"); ok {
+		t.Fatalf("ap latency ratio without ap statements")
+	}
+
+	o.Add("ap", "forward", time.Second, 100)
+	o.Rotate(time.Now())
+	o.Rotate(time.Now())
+	slices := o.Slices(time.Now())
+	if len(slices) != 3 {
+		t.Fatalf("got %d slices, want 2 closed and the current one", len(slices))
+	}
+	if _, ok := slices[0].Stat("ap", "forward"); !ok {
+		t.Fatalf("oldest slice should be the ap one after dropping the first, got %+v", slices[0])
+	}
+	if len(slices[2].Stats) != 0 {
+		t.Fatalf("current slice should be empty, got %+v", slices[2])
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package stats
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+)
+
+//schemas whose tables are never hot
+var systemSchemas = map[string]bool{
+	"mysql":              true,
+	"information_schema": true,
+	"performance_schema": true,
+	"metrics_schema":     true,
+}
+
+//Table is a table read by the top digests.
+type Table struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+	//statements of the digests reading the table
+	Count int64 `json:"count"`
+}
+
+type tableCollector struct {
+	schema string
+	tables map[Table]bool
+}
+
+func (c *tableCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if t, ok := n.(*ast.TableName); ok {
+		schema := t.Schema.L
+		if schema == "" {
+			schema = strings.ToLower(c.schema)
+		}
+		if schema != "" && !systemSchemas[schema] {
+			c.tables[Table{Schema: schema, Name: t.Name.L}] = true
+		}
+	}
+	return n, false
+}
+
+func (c *tableCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+//HotTables returns the n tables read by the most statements of the digests, n of 0 or less
+//means all of them. Unqualified tables are in the schema of their digest, digests whose
+//sample doesn't parse are skipped.
+func HotTables(digests []Digest, n int) []Table {
+	p := parser.New()
+	counts := make(map[Table]int64)
+	for _, d := range digests {
+		stmt, err := p.ParseOneStmt(d.Sample, "", "")
+		if err != nil {
+			continue
+		}
+		c := &tableCollector{schema: d.Schema, tables: make(map[Table]bool)}
+		stmt.Accept(c)
+		for t := range c.tables {
+			counts[t] += d.Count
+		}
+	}
+	tables := make([]Table, 0, len(counts))
+	for t, count := range counts {
+		t.Count = count
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].Count != tables[j].Count {
+			return tables[i].Count > tables[j].Count
+		}
+		if tables[i].Schema != tables[j].Schema {
+			return tables[i].Schema < tables[j].Schema
+		}
+		return tables[i].Name < tables[j].Name
+	})
+	if n > 0 && len(tables) > n {
+		tables = tables[:n]
+	}
+	return tables
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	_ "github.com/pingcap/tidb/types/parser_driver"
+)
+
+func TestHotTables(t *testing.T) {
+	top := NewTop(10)
+	for i := 0; i < 3; i++ {
+		top.Add("tp", "shop", "select * from orders o join users u on o.uid = u.id where o.id = 1", time.Millisecond, 10)
+	}
+	top.Add("tp", "shop", "select * from users where id = 1", time.Millisecond, 10)
+	top.Add("tp", "", "update crm.users set name = 'a' where id = 2", time.Millisecond, 10)
+	//no schema
+	top.Add("tp", "", "select * from items", time.Millisecond, 10)
+	top.Add("tp", "shop", "select * from mysql.user", time.Millisecond, 10)
+
+	tables := HotTables(top.Digests("tp", 0), 0)
+	want := []Table{
+		{Schema: "shop", Name: "users", Count: 4},
+		{Schema: "shop", Name: "orders", Count: 3},
+		{Schema: "crm", Name: "users", Count: 1},
+	}
+	if len(tables) != len(want) {
+		t.Fatalf("unexpected hot tables %+v", tables)
+	}
+	for i := range want {
+		if tables[i] != want[i] {
+			t.Fatalf("hot table %d is %+v, want %+v", i, tables[i], want[i])
+		}
+	}
+	if top2 := HotTables(top.Digests("tp", 0), 2); len(top2) != 2 || top2[1].Name != "orders" {
+		t.Fatalf("unexpected top 2 tables %+v", top2)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/parser"
+)
+
+const DefaultTopSize = 100
+
+//maximum length of the sample statement kept for a digest
+const maxSampleLen = 1024
+
+//Digest is the workload of one statement digest on a pool.
+type Digest struct {
+	Pool   string `json:"pool"`
+	Digest string `json:"digest"`
+	//current database of the session first running the digest
+	Schema string `json:"schema,omitempty"`
+	//normalized statement
+	Sample string `json:"sample"`
+	Count  int64  `json:"count"`
+	//max overcount of Count, set when the digest replaced an evicted one
+	CountError int64 `json:"count_error"`
+	//microseconds, of the Count-CountError statements seen since the digest was added
+	TotalLatency int64 `json:"total_latency_us"`
+	MeanLatency  int64 `json:"mean_latency_us"`
+	TotalCost    int64 `json:"total_cost"`
+	LastSeen     int64 `json:"last_seen"`
+}
+
+//top is the space saving summary of the digests of a pool, it keeps the size most
+//frequent digests. A new digest in a full summary takes the place of the least frequent
+//one and inherits its count as error, so heavy digests are never missed.
+type top struct {
+	sync.Mutex
+	size    int
+	digests map[string]*Digest
+}
+
+func (t *top) add(pool, schema, digest, normalized string, latency time.Duration, cost int64, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	d, ok := t.digests[digest]
+	if !ok {
+		d = &Digest{Pool: pool, Digest: digest, Schema: schema, Sample: normalized}
+		if len(d.Sample) > maxSampleLen {
+			d.Sample = d.Sample[:maxSampleLen]
+		}
+		if len(t.digests) >= t.size {
+			var min *Digest
+			for _, v := range t.digests {
+				if min == nil || v.Count < min.Count {
+					min = v
+				}
+			}
+			delete(t.digests, min.Digest)
+			d.Count, d.CountError = min.Count, min.Count
+		}
+		t.digests[digest] = d
+	}
+	d.Count++
+	d.TotalLatency += latency.Microseconds()
+	d.TotalCost += cost
+	d.LastSeen = now.Unix()
+}
+
+//Top is the top digests of every pool.
+type Top struct {
+	sync.RWMutex
+	size  int
+	pools map[string]*top
+}
+
+var defaultTop = NewTop(DefaultTopSize)
+
+//DefaultTop returns the top digests of the proxy.
+func DefaultTop() *Top {
+	return defaultTop
+}
+
+//NewTop returns the top digests keeping size digests per pool, a size of 0 or less
+//disables it.
+func NewTop(size int) *Top {
+	return &Top{size: size, pools: make(map[string]*top)}
+}
+
+//SetSize changes the digests kept per pool, a size of 0 or less disables it. The digests
+//are cleared.
+func (t *Top) SetSize(size int) {
+	t.Lock()
+	defer t.Unlock()
+	t.size = size
+	t.pools = make(map[string]*top)
+}
+
+//Add records a statement run on the pool in the schema.
+func (t *Top) Add(pool, schema, sql string, latency time.Duration, cost int64) {
+	t.RLock()
+	size := t.size
+	p, ok := t.pools[pool]
+	t.RUnlock()
+	if size <= 0 {
+		return
+	}
+	if !ok {
+		t.Lock()
+		if p, ok = t.pools[pool]; !ok {
+			p = &top{size: t.size, digests: make(map[string]*Digest, t.size)}
+			t.pools[pool] = p
+		}
+		t.Unlock()
+	}
+	normalized, digest := parser.NormalizeDigest(sql)
+	p.add(pool, schema, digest.String(), normalized, latency, cost, time.Now())
+}
+
+//Digests returns the top n digests of the pool by count, an empty pool means every pool
+//and n of 0 or less means all of them.
+func (t *Top) Digests(pool string, n int) []Digest {
+	t.RLock()
+	pools := make([]*top, 0, len(t.pools))
+	for name, p := range t.pools {
+		if pool == "" || pool == name {
+			pools = append(pools, p)
+		}
+	}
+	t.RUnlock()
+
+	var digests []Digest
+	for _, p := range pools {
+		p.Lock()
+		for _, d := range p.digests {
+			v := *d
+			v.MeanLatency = v.TotalLatency / (v.Count - v.CountError)
+			digests = append(digests, v)
+		}
+		p.Unlock()
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		if digests[i].Count != digests[j].Count {
+			return digests[i].Count > digests[j].Count
+		}
+		return digests[i].Digest < digests[j].Digest
+	})
+	if n > 0 && len(digests) > n {
+		digests = digests[:n]
+	}
+	return digests
+}
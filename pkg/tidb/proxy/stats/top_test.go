@@ -0,0 +1,57 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTopDigests(t *testing.T) {
+	top := NewTop(2)
+	for i := 0; i < 5; i++ {
+		top.Add("ap", "test", fmt.Sprintf("select sum(a) from t where b > %d", i), 10*time.Millisecond, 2000)
+	}
+	top.Add("ap", "test", "select a from t where c = 1", time.Millisecond, 10)
+	top.Add("tp", "test", "select a from t where c = 1", time.Millisecond, 10)
+	//evicts the digest seen once, the heavy one stays
+	top.Add("ap", "test", "select b from t where d = 1", time.Millisecond, 10)
+
+	digests := top.Digests("ap", 0)
+	if len(digests) != 2 {
+		t.Fatalf("unexpected digests %+v", digests)
+	}
+	heavy := digests[0]
+	if heavy.Count != 5 || heavy.CountError != 0 || heavy.MeanLatency != 10000 || heavy.TotalCost != 10000 ||
+		heavy.Sample != "select sum ( `a` ) from `t` where `b` > ?" {
+		t.Fatalf("unexpected heavy digest %+v", heavy)
+	}
+	if d := digests[1]; d.Count != 2 || d.CountError != 1 || d.MeanLatency != 1000 || d.Sample != "select `b` from `t` where `d` = ?" {
+		t.Fatalf("unexpected replacing digest %+v", d)
+	}
+	if all := top.Digests("", 1); len(all) != 1 || all[0].Digest != heavy.Digest {
+		t.Fatalf("unexpected top digest %+v", all)
+	}
+	if tp := top.Digests("tp", 0); len(tp) != 1 || tp[0].Pool != "tp" {
+		t.Fatalf("unexpected tp digests %+v", tp)
+	}
+
+	top.SetSize(0)
+	top.Add("ap", "test", "select 1", time.Millisecond, 1)
+	if all := top.Digests("", 0); len(all) != 0 {
+		t.Fatalf("disabled top kept digests %+v", all)
+	}
+}
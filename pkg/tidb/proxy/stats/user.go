@@ -0,0 +1,175 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package stats
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+//UserStats is the usage of a user accumulated across restarts, for showback reports.
+type UserStats struct {
+	User string `json:"user"`
+	//statements forwarded to backends by pool, tp, ap or bigcost
+	Statements map[string]int64 `json:"statements"`
+	//bytes read from and written to the client
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+	//microseconds the forwarded statements spent on backends
+	BackendTime int64 `json:"backend_time_us"`
+	Since       int64 `json:"since"`
+	LastSeen    int64 `json:"last_seen"`
+}
+
+//Users is the usage of every user, flushed to a file periodically and loaded from it
+//on start.
+type Users struct {
+	sync.Mutex
+	file  string
+	dirty bool
+	users map[string]*UserStats
+}
+
+var defaultUsers = NewUsers()
+
+//DefaultUsers returns the usage of the users of the proxy.
+func DefaultUsers() *Users {
+	return defaultUsers
+}
+
+func NewUsers() *Users {
+	return &Users{users: make(map[string]*UserStats)}
+}
+
+//Open loads the usage saved in the file and flushes to it from now on, a missing file
+//starts from no usage.
+func (u *Users) Open(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var stored []*UserStats
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+	}
+	u.Lock()
+	defer u.Unlock()
+	u.file = fileName
+	for _, s := range stored {
+		if s.Statements == nil {
+			s.Statements = make(map[string]int64)
+		}
+		if cur, ok := u.users[s.User]; ok {
+			s.merge(cur)
+		}
+		u.users[s.User] = s
+	}
+	return nil
+}
+
+func (s *UserStats) merge(other *UserStats) {
+	for pool, n := range other.Statements {
+		s.Statements[pool] += n
+	}
+	s.BytesIn += other.BytesIn
+	s.BytesOut += other.BytesOut
+	s.BackendTime += other.BackendTime
+	if other.LastSeen > s.LastSeen {
+		s.LastSeen = other.LastSeen
+	}
+}
+
+//get returns the usage of the user, the caller holds the lock.
+func (u *Users) get(user string, now int64) *UserStats {
+	s, ok := u.users[user]
+	if !ok {
+		s = &UserStats{User: user, Statements: make(map[string]int64), Since: now}
+		u.users[user] = s
+	}
+	s.LastSeen = now
+	u.dirty = true
+	return s
+}
+
+//AddStmt records a statement of the user forwarded to the pool.
+func (u *Users) AddStmt(user, pool string, backendTime time.Duration) {
+	u.Lock()
+	defer u.Unlock()
+	s := u.get(user, time.Now().Unix())
+	s.Statements[pool]++
+	s.BackendTime += backendTime.Microseconds()
+}
+
+//AddBytes records the bytes the user read from and wrote to the proxy.
+func (u *Users) AddBytes(user string, in, out int64) {
+	if in == 0 && out == 0 {
+		return
+	}
+	u.Lock()
+	defer u.Unlock()
+	s := u.get(user, time.Now().Unix())
+	s.BytesIn += in
+	s.BytesOut += out
+}
+
+//List returns a copy of the usage of every user, ordered by user.
+func (u *Users) List() []UserStats {
+	u.Lock()
+	rs := make([]UserStats, 0, len(u.users))
+	for _, s := range u.users {
+		c := *s
+		c.Statements = make(map[string]int64, len(s.Statements))
+		for pool, n := range s.Statements {
+			c.Statements[pool] = n
+		}
+		rs = append(rs, c)
+	}
+	u.Unlock()
+	sort.Slice(rs, func(i, j int) bool { return rs[i].User < rs[j].User })
+	return rs
+}
+
+//Flush saves the usage to the file if it changed since the last flush.
+func (u *Users) Flush() error {
+	u.Lock()
+	if u.file == "" || !u.dirty {
+		u.Unlock()
+		return nil
+	}
+	fileName := u.file
+	u.dirty = false
+	u.Unlock()
+
+	data, err := json.MarshalIndent(u.List(), "", "  ")
+	if err == nil {
+		//write a temp file and rename it, so a crash doesn't leave half a file
+		tmp := fileName + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, fileName)
+		}
+	}
+	if err != nil {
+		u.Lock()
+		u.dirty = true
+		u.Unlock()
+	}
+	return err
+}
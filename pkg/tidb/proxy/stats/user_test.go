@@ -0,0 +1,71 @@
+// Copyright 2016 The he3proxy Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package stats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsersSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "user_stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "users.json")
+
+	users := NewUsers()
+	if err = users.Open(fileName); err != nil {
+		t.Fatal(err)
+	}
+	users.AddStmt("app", "tp", 2*time.Millisecond)
+	users.AddStmt("app", "ap", 3*time.Millisecond)
+	users.AddBytes("app", 100, 2000)
+	users.AddStmt("report", "ap", time.Second)
+	if err = users.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewUsers()
+	if err = restarted.Open(fileName); err != nil {
+		t.Fatal(err)
+	}
+	restarted.AddStmt("app", "tp", time.Millisecond)
+	list := restarted.List()
+	if len(list) != 2 || list[0].User != "app" || list[1].User != "report" {
+		t.Fatalf("unexpected users %+v", list)
+	}
+	app := list[0]
+	if app.Statements["tp"] != 2 || app.Statements["ap"] != 1 || app.BytesIn != 100 || app.BytesOut != 2000 ||
+		app.BackendTime != 6000 {
+		t.Fatalf("unexpected usage %+v", app)
+	}
+}
+
+func TestUsersFlushOnlyChanges(t *testing.T) {
+	users := NewUsers()
+	users.AddBytes("app", 0, 0)
+	if len(users.List()) != 0 {
+		t.Fatal("expect no usage recorded without bytes")
+	}
+	//no file, nothing is written
+	users.AddStmt("app", "tp", time.Millisecond)
+	if err := users.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -65,7 +65,11 @@ const (
 func init() {
 
 	// Create the kubernetes clientset
-	k8sConfig := ctrl.GetConfigOrDie()
+	k8sConfig, err := ctrl.GetConfig()
+	if err != nil {
+		//not running in kubernetes, only discovery without k8s can be used
+		return
+	}
 	//k8sConfig, err := clientcmd.BuildConfigFromFlags(viper.GetString("https://10.154.0.150:6443"), viper.GetString("./configs"))
 	//if err != nil {
 	//	klog.Errorf("Failed to get kubeConfig! Error is %v", err)
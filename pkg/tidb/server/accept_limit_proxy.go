@@ -0,0 +1,193 @@
+package server
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/metrics"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/util/logutil"
+	"go.uber.org/zap"
+)
+
+const (
+	// limit of the new connections of the whole proxy, the one of a client ip is connLimitHost
+	acceptLimitProxy = "proxy"
+	// client ips with a bucket kept before the full ones are dropped
+	acceptLimitPruneSize = 4096
+	// least time between two logs of the connections closed by the limit
+	acceptLimitLogInterval = 10 * time.Second
+	// time the error is written in before a limited connection is closed
+	acceptLimitWriteTimeout = 100 * time.Millisecond
+)
+
+// acceptLimitedPacket is the ER_CON_COUNT_ERROR sent to a limited connection in place of
+// the initial handshake, so the client reports it instead of a lost connection.
+var acceptLimitedPacket = func() []byte {
+	code := uint16(mysql.ErrConCount)
+	payload := append([]byte{mysql.ErrHeader, byte(code), byte(code >> 8)},
+		"Too many connections, new connections are rate limited by the proxy"...)
+	return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0}, payload...)
+}()
+
+// tokenBucket allows rate events a second and bursts of up to burst events.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// refill adds the tokens earned since the last call.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// acceptLimiter rate limits the new client connections of the proxy and of each client ip,
+// before max-server-connections and the connection limits are checked after the handshake.
+type acceptLimiter struct {
+	sync.Mutex
+	proxy      *tokenBucket
+	perIPRate  float64
+	perIPBurst int
+	// buckets by client ip
+	ips    map[string]*tokenBucket
+	pruned time.Time
+	// connections closed since the last log
+	limited int
+	logged  time.Time
+}
+
+// newAcceptLimiter returns nil if neither limit is set.
+func newAcceptLimiter(cfg *proxyconfig.Config, now time.Time) *acceptLimiter {
+	if cfg == nil || (cfg.AcceptLimit.Rate <= 0 && cfg.AcceptLimit.PerIPRate <= 0) {
+		return nil
+	}
+	l := &acceptLimiter{
+		perIPRate:  cfg.AcceptLimit.PerIPRate,
+		perIPBurst: cfg.AcceptLimit.PerIPBurst,
+		ips:        make(map[string]*tokenBucket),
+	}
+	if cfg.AcceptLimit.Rate > 0 {
+		l.proxy = newTokenBucket(cfg.AcceptLimit.Rate, cfg.AcceptLimit.Burst, now)
+	}
+	return l
+}
+
+// allow returns the type of the limit the new connection from host exceeds, empty if it
+// is accepted.
+func (l *acceptLimiter) allow(host string, now time.Time) string {
+	l.Lock()
+	defer l.Unlock()
+	if b := l.bucketOf(host, now); b != nil && !b.take(now) {
+		return connLimitHost
+	}
+	if l.proxy != nil && !l.proxy.take(now) {
+		return acceptLimitProxy
+	}
+	return ""
+}
+
+// bucketOf returns the bucket of the client ip, nil if it has no limit. While the buckets
+// are full of recent ips a new ip is only limited by the proxy, the caller holds the lock.
+func (l *acceptLimiter) bucketOf(host string, now time.Time) *tokenBucket {
+	if l.perIPRate <= 0 || host == "" {
+		return nil
+	}
+	if b, ok := l.ips[host]; ok {
+		return b
+	}
+	if len(l.ips) >= acceptLimitPruneSize {
+		//pruning walks every bucket, so it runs at most once a second during a storm
+		if now.Sub(l.pruned) < time.Second {
+			return nil
+		}
+		l.prune(now)
+		if len(l.ips) >= acceptLimitPruneSize {
+			return nil
+		}
+	}
+	b := newTokenBucket(l.perIPRate, l.perIPBurst, now)
+	l.ips[host] = b
+	return b
+}
+
+// prune drops the buckets refilled to their burst, the caller holds the lock.
+func (l *acceptLimiter) prune(now time.Time) {
+	l.pruned = now
+	for host, b := range l.ips {
+		if b.refill(now); b.tokens >= b.burst {
+			delete(l.ips, host)
+		}
+	}
+}
+
+// observeLimited counts a closed connection, it returns the connections closed since the
+// last log when the next log is due.
+func (l *acceptLimiter) observeLimited(now time.Time) (int, bool) {
+	l.Lock()
+	defer l.Unlock()
+	l.limited++
+	if now.Sub(l.logged) < acceptLimitLogInterval {
+		return 0, false
+	}
+	limited := l.limited
+	l.limited, l.logged = 0, now
+	return limited, true
+}
+
+// limitAccept closes the new connection if it exceeds the accept limit, before a session
+// or a goroutine is made for it. It reports whether it did.
+func (s *Server) limitAccept(conn net.Conn) bool {
+	l := s.acceptLimiter
+	if l == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		host = ""
+	}
+	now := time.Now()
+	ty := l.allow(host, now)
+	if ty == "" {
+		return false
+	}
+	metrics.ProxyAcceptLimitedCounter.WithLabelValues(ty).Inc()
+	if limited, ok := l.observeLimited(now); ok {
+		golog.Warn("server", "limitAccept", "close new connections over the accept limit", 0,
+			"limit", ty, "host", host, "closed", limited)
+	}
+	if err = conn.SetWriteDeadline(now.Add(acceptLimitWriteTimeout)); err == nil {
+		_, err = conn.Write(acceptLimitedPacket)
+	}
+	if err != nil {
+		logutil.BgLogger().Debug("write accept limit error failed", zap.Error(err))
+	}
+	if err = conn.Close(); err != nil {
+		logutil.BgLogger().Debug("close limited connection failed", zap.Error(err))
+	}
+	return true
+}
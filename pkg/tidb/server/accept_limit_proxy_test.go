@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+)
+
+func TestAcceptLimiter(t *testing.T) {
+	if newAcceptLimiter(&proxyconfig.Config{}, time.Now()) != nil {
+		t.Fatal("expect no limiter without rates")
+	}
+	now := time.Now()
+	cfg := &proxyconfig.Config{AcceptLimit: proxyconfig.AcceptLimitConfig{Rate: 10, Burst: 5, PerIPRate: 1, PerIPBurst: 2}}
+	l := newAcceptLimiter(cfg, now)
+
+	//a client ip gets its burst, then waits for the refill
+	for i := 0; i < 2; i++ {
+		if ty := l.allow("10.0.0.1", now); ty != "" {
+			t.Fatalf("expect connection %d accepted, got %s", i, ty)
+		}
+	}
+	if ty := l.allow("10.0.0.1", now); ty != connLimitHost {
+		t.Fatalf("expect the client ip limited, got %q", ty)
+	}
+	//the limited connection took no token of the proxy, which has 3 left
+	for i := 0; i < 3; i++ {
+		if ty := l.allow(fmt.Sprintf("10.0.1.%d", i), now); ty != "" {
+			t.Fatalf("expect connection %d accepted, got %s", i, ty)
+		}
+	}
+	if ty := l.allow("10.0.1.9", now); ty != acceptLimitProxy {
+		t.Fatalf("expect the proxy limited, got %q", ty)
+	}
+	later := now.Add(time.Second)
+	if ty := l.allow("10.0.0.1", later); ty != "" {
+		t.Fatalf("expect the client ip refilled, got %q", ty)
+	}
+
+	//full buckets are dropped when the ips are pruned
+	l.prune(now.Add(time.Minute))
+	if len(l.ips) != 0 {
+		t.Fatalf("expect full buckets pruned, got %d", len(l.ips))
+	}
+
+	if n, ok := l.observeLimited(now); !ok || n != 1 {
+		t.Fatalf("expect the first limited connection logged, got %d %v", n, ok)
+	}
+	l.observeLimited(now.Add(time.Second))
+	if n, ok := l.observeLimited(now.Add(acceptLimitLogInterval)); !ok || n != 2 {
+		t.Fatalf("expect 2 connections logged after the interval, got %d %v", n, ok)
+	}
+}
+
+func TestAcceptLimitedPacket(t *testing.T) {
+	p := acceptLimitedPacket
+	if int(p[0])|int(p[1])<<8|int(p[2])<<16 != len(p)-4 || p[3] != 0 || p[4] != 0xff || int(p[5])|int(p[6])<<8 != 1040 {
+		t.Fatalf("unexpected packet %v", p[:7])
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// waitAPTurn waits for the turn of the user in the ap queue, so a user running many ap
+// statements doesn't starve the others while the ap pool is saturated.
+func (c *clientConn) waitAPTurn(cluster *backend.Cluster) error {
+	if cluster.APQueue == nil {
+		return nil
+	}
+	timeout := time.Duration(c.server.cfg.Proxycfg.Cluster.ApFairQueue.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = proxyconfig.DefaultFairQueueTimeout * time.Second
+	}
+	start := time.Now()
+	release, err := cluster.WaitAPTurn(c.user, timeout)
+	if err != nil {
+		golog.Warn("server", "waitAPTurn", "wait for the turn in the ap queue failed", 0,
+			"connID", c.connectionID, "user", c.user, "wait", time.Since(start).String(), "error", err)
+		return err
+	}
+	c.apRelease = release
+	return nil
+}
+
+// releaseAPTurn gives the turn of the finished statement to the next one waiting.
+func (c *clientConn) releaseAPTurn() {
+	if c.apRelease != nil {
+		c.apRelease()
+		c.apRelease = nil
+	}
+}
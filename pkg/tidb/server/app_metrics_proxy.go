@@ -0,0 +1,75 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+const (
+	// label of the apps over the cardinality limit
+	appOther = "other"
+	// label of the clients without app
+	appUnknown = "unknown"
+)
+
+// appLabels caps the apps labelling the statement metrics, the first max apps seen keep
+// their label and later ones share appOther.
+type appLabels struct {
+	sync.Mutex
+	max  int
+	apps map[string]struct{}
+}
+
+func newAppLabels(cfg config.AppMetricsConfig) *appLabels {
+	if !cfg.Enable {
+		return nil
+	}
+	max := cfg.MaxApps
+	if max <= 0 {
+		max = config.DefaultAppMetricsMaxApps
+	}
+	return &appLabels{max: max, apps: make(map[string]struct{})}
+}
+
+func (l *appLabels) label(app string) string {
+	if app == "" {
+		return appUnknown
+	}
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.apps[app]; ok {
+		return app
+	}
+	if len(l.apps) >= l.max {
+		return appOther
+	}
+	l.apps[app] = struct{}{}
+	return app
+}
+
+// appLabel returns the app label of the client, the app configured for its user or its
+// program_name attribute.
+func (cc *clientConn) appLabel() string {
+	if cc.app == "" {
+		app, ok := cc.server.cfg.Proxycfg.AppMetrics.Users[cc.user]
+		if !ok {
+			app = cc.attrs["program_name"]
+		}
+		cc.app = cc.server.appLabels.label(app)
+	}
+	return cc.app
+}
+
+// observeApp accounts the statement routed to the pool of the conn to the app of the client.
+func (cc *clientConn) observeApp(conn *backend.BackendConn, latency time.Duration) {
+	if cc.server.appLabels == nil {
+		return
+	}
+	app, pool := cc.appLabel(), conn.GetDbType()
+	metrics.ProxyAppStmtCounter.WithLabelValues(app, pool).Inc()
+	metrics.ProxyAppLatencyHistogram.WithLabelValues(app, pool).Observe(latency.Seconds())
+}
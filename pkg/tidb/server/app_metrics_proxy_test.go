@@ -0,0 +1,25 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func TestAppLabels(t *testing.T) {
+	if newAppLabels(config.AppMetricsConfig{}) != nil {
+		t.Fatal("app labels without app metrics enabled")
+	}
+	l := newAppLabels(config.AppMetricsConfig{Enable: true, MaxApps: 2})
+	for _, c := range []struct{ app, label string }{
+		{"", appUnknown},
+		{"billing", "billing"},
+		{"bi", "bi"},
+		{"etl", appOther},
+		{"billing", "billing"},
+	} {
+		if got := l.label(c.app); got != c.label {
+			t.Fatalf("label of %q is %q, want %q", c.app, got, c.label)
+		}
+	}
+}
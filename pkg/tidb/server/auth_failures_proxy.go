@@ -0,0 +1,166 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/privilege"
+)
+
+// reasons of a failed handshake
+const (
+	authFailTLS            = "tls"
+	authFailBadPassword    = "bad_password"
+	authFailUnknownUser    = "unknown_user"
+	authFailTimeout        = "timeout"
+	authFailPDLost         = "pd_lost"
+	authFailMaxConnections = "max_connections"
+	authFailBlocked        = "blocked"
+	authFailShutdown       = "shutdown"
+	// the client closed the connection before the auth, e.g. a tcp health check
+	authFailClosed = "closed"
+	authFailOther  = "other"
+)
+
+// failures kept for /proxy/auth/failures
+const authFailureSamples = 256
+
+// authFailure is a failed handshake of a client.
+type authFailure struct {
+	Time   time.Time `json:"time"`
+	IP     string    `json:"ip"`
+	User   string    `json:"user,omitempty"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// authFailures counts the failed handshakes by reason and keeps the last ones for security
+// triage. Connections closed before the auth are counted but not kept, health checks would
+// push the others out.
+type authFailures struct {
+	sync.Mutex
+	counts  map[string]int64
+	samples []authFailure
+	next    int
+}
+
+func newAuthFailures() *authFailures {
+	return &authFailures{counts: make(map[string]int64)}
+}
+
+func (f *authFailures) add(failure authFailure) {
+	metrics.ProxyHandshakeFailureCounter.WithLabelValues(failure.Reason).Inc()
+	f.Lock()
+	defer f.Unlock()
+	f.counts[failure.Reason]++
+	if failure.Reason == authFailClosed {
+		return
+	}
+	if len(f.samples) < authFailureSamples {
+		f.samples = append(f.samples, failure)
+		return
+	}
+	f.samples[f.next] = failure
+	f.next = (f.next + 1) % authFailureSamples
+}
+
+// recent returns the kept failures of the reason, all reasons if it is empty, the newest first.
+func (f *authFailures) recent(reason string) []authFailure {
+	f.Lock()
+	defer f.Unlock()
+	failures := make([]authFailure, 0, len(f.samples))
+	for i := len(f.samples) - 1; i >= 0; i-- {
+		failure := f.samples[(f.next+i)%len(f.samples)]
+		if reason == "" || failure.Reason == reason {
+			failures = append(failures, failure)
+		}
+	}
+	return failures
+}
+
+func (f *authFailures) countsByReason() map[string]int64 {
+	f.Lock()
+	defer f.Unlock()
+	counts := make(map[string]int64, len(f.counts))
+	for reason, n := range f.counts {
+		counts[reason] = n
+	}
+	return counts
+}
+
+// authFailureReason tells why the handshake failed, the reason set by the handshake wins
+// over the one told by the error.
+func (cc *clientConn) authFailureReason(err error) string {
+	if cc.authFailure != "" {
+		return cc.authFailure
+	}
+	cause := errors.Cause(err)
+	if ne, ok := cause.(net.Error); ok && ne.Timeout() {
+		return authFailTimeout
+	}
+	switch {
+	case cause == io.EOF || cause == io.ErrUnexpectedEOF:
+		return authFailClosed
+	case errAccessDenied.Equal(err):
+		return authFailBadPassword
+	case errHostIsBlocked.Equal(err):
+		return authFailBlocked
+	case errTooManyUserConnections.Equal(err), errConCount.Equal(err):
+		return authFailMaxConnections
+	case errServerShutdown.Equal(err):
+		return authFailShutdown
+	case errSecureTransportRequired.Equal(err):
+		return authFailTLS
+	}
+	return authFailOther
+}
+
+// accessDeniedReason tells a wrong password from a user without an account matching the host.
+// The accounts of passthrough clusters are on the backends, the proxy can't tell.
+func (cc *clientConn) accessDeniedReason(host string) string {
+	pm := privilege.GetPrivilegeManager(cc.ctx)
+	if pm == nil || cc.passthrough != nil {
+		return authFailBadPassword
+	}
+	if _, err := pm.GetAuthPlugin(cc.user, host); err != nil {
+		return authFailUnknownUser
+	}
+	return authFailBadPassword
+}
+
+// observeHandshakeFailure records the failed handshake of the connection.
+func (s *Server) observeHandshakeFailure(cc *clientConn, reason string, err error) {
+	if s.authFailures == nil {
+		return
+	}
+	failure := authFailure{Time: time.Now(), IP: cc.peerHost, User: cc.user, Reason: reason}
+	if failure.IP == "" && cc.bufReadConn != nil {
+		failure.IP, _, _ = net.SplitHostPort(cc.bufReadConn.RemoteAddr().String())
+	}
+	if err != nil {
+		failure.Error = err.Error()
+	}
+	s.authFailures.add(failure)
+}
+
+// AuthFailuresReport is served by /proxy/auth/failures.
+type AuthFailuresReport struct {
+	Counts map[string]int64 `json:"counts"`
+	Recent []authFailure    `json:"recent"`
+}
+
+// handleAuthFailures shows the failed handshakes by reason and the last ones, of the reason
+// given by the reason query parameter if set.
+func (s *Server) handleAuthFailures(w http.ResponseWriter, req *http.Request) {
+	report := AuthFailuresReport{Counts: map[string]int64{}, Recent: []authFailure{}}
+	if s.authFailures != nil {
+		report.Counts = s.authFailures.countsByReason()
+		report.Recent = s.authFailures.recent(req.FormValue("reason"))
+	}
+	writeData(w, report)
+}
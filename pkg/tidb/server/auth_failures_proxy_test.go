@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/pingcap/errors"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestAuthFailureReason(t *testing.T) {
+	cc := &clientConn{}
+	for _, c := range []struct {
+		err    error
+		reason string
+	}{
+		{errAccessDenied.FastGenByArgs("u", "h", "YES"), authFailBadPassword},
+		{errors.Trace(io.EOF), authFailClosed},
+		{errors.Trace(timeoutError{}), authFailTimeout},
+		{errHostIsBlocked.FastGenByArgs("h"), authFailBlocked},
+		{errTooManyUserConnections.FastGenByArgs("u@h"), authFailMaxConnections},
+		{errConCount, authFailMaxConnections},
+		{errServerShutdown.GenWithStackByArgs(), authFailShutdown},
+		{errors.New("Unknown auth plugin"), authFailOther},
+	} {
+		if got := cc.authFailureReason(c.err); got != c.reason {
+			t.Fatalf("reason of %v is %s, want %s", c.err, got, c.reason)
+		}
+	}
+	cc.authFailure = authFailUnknownUser
+	if got := cc.authFailureReason(errAccessDenied.FastGenByArgs("u", "h", "YES")); got != authFailUnknownUser {
+		t.Fatalf("reason set by the handshake overridden by %s", got)
+	}
+}
+
+func TestAuthFailuresRecent(t *testing.T) {
+	f := newAuthFailures()
+	f.add(authFailure{IP: "10.0.0.1", Reason: authFailClosed})
+	for i := 0; i < authFailureSamples+2; i++ {
+		reason := authFailBadPassword
+		if i%2 == 1 {
+			reason = authFailUnknownUser
+		}
+		f.add(authFailure{IP: fmt.Sprintf("10.0.1.%d", i), Reason: reason})
+	}
+	recent := f.recent("")
+	if len(recent) != authFailureSamples || recent[0].IP != fmt.Sprintf("10.0.1.%d", authFailureSamples+1) {
+		t.Fatalf("%d failures kept, newest %+v", len(recent), recent[0])
+	}
+	if recent[len(recent)-1].IP != "10.0.1.2" {
+		t.Fatalf("oldest failure kept %+v", recent[len(recent)-1])
+	}
+	for _, failure := range f.recent(authFailUnknownUser) {
+		if failure.Reason != authFailUnknownUser {
+			t.Fatalf("failure of another reason %+v", failure)
+		}
+	}
+	counts := f.countsByReason()
+	if counts[authFailClosed] != 1 || counts[authFailBadPassword]+counts[authFailUnknownUser] != authFailureSamples+2 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/metrics"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// client ips with failures kept before the ones out of the window are dropped
+const authThrottlePruneSize = 4096
+
+// authThrottle blocks a client ip after repeated auth failures, it blunts brute force
+// attacks on the passwords through the proxy.
+type authThrottle struct {
+	sync.Mutex
+	maxFailures int
+	window      time.Duration
+	blockTime   time.Duration
+	// failure times within the window by client ip
+	failures map[string][]time.Time
+	// end of the block by client ip
+	blocked map[string]time.Time
+}
+
+// newAuthThrottle returns nil if the throttle is disabled.
+func newAuthThrottle(cfg *proxyconfig.Config) *authThrottle {
+	if cfg == nil || cfg.AuthThrottle.MaxFailures <= 0 {
+		return nil
+	}
+	t := &authThrottle{
+		maxFailures: cfg.AuthThrottle.MaxFailures,
+		window:      time.Duration(cfg.AuthThrottle.Window) * time.Second,
+		blockTime:   time.Duration(cfg.AuthThrottle.BlockTime) * time.Second,
+		failures:    make(map[string][]time.Time),
+		blocked:     make(map[string]time.Time),
+	}
+	if t.window <= 0 {
+		t.window = proxyconfig.DefaultAuthFailureWindow * time.Second
+	}
+	if t.blockTime <= 0 {
+		t.blockTime = proxyconfig.DefaultAuthBlockTime * time.Second
+	}
+	return t
+}
+
+// isBlocked reports whether the host is blocked at now.
+func (t *authThrottle) isBlocked(host string, now time.Time) bool {
+	t.Lock()
+	defer t.Unlock()
+	until, ok := t.blocked[host]
+	if ok && now.After(until) {
+		delete(t.blocked, host)
+		return false
+	}
+	return ok
+}
+
+// fail records an auth failure of the host, it reports whether the host gets blocked.
+func (t *authThrottle) fail(host string, now time.Time) bool {
+	t.Lock()
+	defer t.Unlock()
+	recent := t.failures[host][:0]
+	for _, at := range t.failures[host] {
+		if now.Sub(at) < t.window {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < t.maxFailures {
+		t.failures[host] = recent
+		if len(t.failures) > authThrottlePruneSize {
+			t.prune(now)
+		}
+		return false
+	}
+	delete(t.failures, host)
+	t.blocked[host] = now.Add(t.blockTime)
+	return true
+}
+
+// prune drops the failures out of the window and the ended blocks, the caller holds the lock.
+func (t *authThrottle) prune(now time.Time) {
+	for host, failures := range t.failures {
+		if now.Sub(failures[len(failures)-1]) >= t.window {
+			delete(t.failures, host)
+		}
+	}
+	for host, until := range t.blocked {
+		if now.After(until) {
+			delete(t.blocked, host)
+		}
+	}
+}
+
+// succeed forgets the failures of the host.
+func (t *authThrottle) succeed(host string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.failures, host)
+}
+
+// checkAuthThrottle rejects the auth of a blocked client ip before the password is checked.
+func (cc *clientConn) checkAuthThrottle(host string) error {
+	if t := cc.server.authThrottle; t != nil && t.isBlocked(host, time.Now()) {
+		return errHostIsBlocked.FastGenByArgs(host)
+	}
+	return nil
+}
+
+// observeAuth counts the result of the auth of the client ip.
+func (cc *clientConn) observeAuth(host string, ok bool) {
+	t := cc.server.authThrottle
+	if t == nil {
+		return
+	}
+	if ok {
+		t.succeed(host)
+		return
+	}
+	if t.fail(host, time.Now()) {
+		metrics.ProxyAuthBlockedCounter.Inc()
+		golog.Warn("server", "observeAuth", "block client ip after auth failures", 0,
+			"connID", cc.connectionID, "user", cc.user, "host", host,
+			"failures", t.maxFailures, "block_time", t.blockTime.String())
+	}
+}
+
+// handshakeTimeout is the time a client has to complete the handshake and the auth.
+func (s *Server) handshakeTimeout() time.Duration {
+	if s.cfg.Proxycfg == nil || s.cfg.Proxycfg.HandshakeTimeout == 0 {
+		return proxyconfig.DefaultHandshakeTimeout * time.Second
+	}
+	return time.Duration(s.cfg.Proxycfg.HandshakeTimeout) * time.Second
+}
+
+// handshakeWithDeadline runs the handshake under the handshake timeout, so half open
+// clients that never complete the auth don't hold their goroutine forever. The timeout
+// applies to every packet read, the packet io clears a deadline set on the conn.
+func (cc *clientConn) handshakeWithDeadline(ctx context.Context) error {
+	timeout := cc.server.handshakeTimeout()
+	if timeout <= 0 {
+		return cc.handshake(ctx)
+	}
+	if err := cc.bufReadConn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return errors.Trace(err)
+	}
+	cc.pkt.setReadTimeout(timeout)
+	err := cc.handshake(ctx)
+	cc.pkt.setReadTimeout(0)
+	if ne, ok := errors.Cause(err).(net.Error); ok && ne.Timeout() {
+		metrics.ProxyHandshakeTimeoutCounter.Inc()
+		golog.Warn("server", "handshakeWithDeadline", "handshake timeout", 0,
+			"connID", cc.connectionID, "remote", cc.bufReadConn.RemoteAddr().String(),
+			"timeout", timeout.String())
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	return errors.Trace(cc.bufReadConn.SetWriteDeadline(time.Time{}))
+}
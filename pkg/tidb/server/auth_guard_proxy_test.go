@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+)
+
+func TestAuthThrottle(t *testing.T) {
+	if newAuthThrottle(&proxyconfig.Config{}) != nil {
+		t.Fatal("throttle enabled without max failures")
+	}
+	cfg := &proxyconfig.Config{AuthThrottle: proxyconfig.AuthThrottleConfig{MaxFailures: 3, Window: 10, BlockTime: 60}}
+	th := newAuthThrottle(cfg)
+	now := time.Now()
+
+	th.fail("10.0.0.1", now)
+	th.fail("10.0.0.1", now.Add(time.Second))
+	//both earlier failures are out of the window by the third one
+	if th.fail("10.0.0.1", now.Add(12*time.Second)) || th.isBlocked("10.0.0.1", now.Add(12*time.Second)) {
+		t.Fatal("failures out of the window counted")
+	}
+	if th.fail("10.0.0.1", now.Add(13*time.Second)) {
+		t.Fatal("client ip blocked after 2 failures in the window")
+	}
+	if !th.fail("10.0.0.1", now.Add(14*time.Second)) {
+		t.Fatal("client ip not blocked after 3 failures in the window")
+	}
+	if !th.isBlocked("10.0.0.1", now.Add(15*time.Second)) || th.isBlocked("10.0.0.2", now.Add(15*time.Second)) {
+		t.Fatal("unexpected block state")
+	}
+	if th.isBlocked("10.0.0.1", now.Add(75*time.Second)) {
+		t.Fatal("block not ended after the block time")
+	}
+
+	th.fail("10.0.0.3", now)
+	th.fail("10.0.0.3", now)
+	th.succeed("10.0.0.3")
+	if th.fail("10.0.0.3", now) {
+		t.Fatal("failures kept after a successful auth")
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	goerr "errors"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/auth"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/privilege"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	proxyerrors "github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	proxymysql "github.com/pingcap/tidb/proxy/mysql"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/types"
+)
+
+// passthroughCluster returns the cluster relaying the authentication of the client, nil if
+// the proxy authenticates it.
+func (cc *clientConn) passthroughCluster() *backend.Cluster {
+	cluster := cc.server.clusterOf(cc.dbname)
+	if cluster == nil || cluster.Cfg.AuthMode != proxyconfig.AuthPassthrough {
+		return nil
+	}
+	return cluster
+}
+
+// authPassthrough relays the auth exchange of the client to a backend of the cluster. The
+// client is asked to switch to the auth plugin of the backend with its scramble, then the
+// packets are relayed until the backend accepts or refuses the client. The relay is returned
+// open once the backend accepts, the caller closes it.
+func (cc *clientConn) authPassthrough(ctx context.Context, cluster *backend.Cluster) (relay *backend.AuthRelay, err error) {
+	if cc.capability&mysql.ClientPluginAuth == 0 {
+		return nil, errors.New("auth passthrough needs a client supporting auth plugins")
+	}
+	relay, err = cluster.DialAuthRelay()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			relay.Close()
+		}
+	}()
+	cc.salt = relay.Salt()
+	authData, err := cc.authSwitchRequest(ctx, relay.Plugin())
+	if err != nil {
+		return nil, err
+	}
+	if err = relay.Start(cc.user, authData); err != nil {
+		return nil, err
+	}
+	for {
+		packet, reply, err := relay.Next()
+		if packet == nil {
+			if err != nil {
+				return nil, err
+			}
+			return relay, nil
+		}
+		if err = cc.writePacket(append(make([]byte, 4, 4+len(packet)), packet...)); err != nil {
+			return nil, err
+		}
+		if err = cc.flush(ctx); err != nil {
+			return nil, err
+		}
+		if !reply {
+			continue
+		}
+		data, err := cc.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		if err = relay.Reply(data); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// loginPassthrough authenticates the client on a backend and takes the identity of the user
+// in the session. A client refused by the backend is not authed without error, an error
+// means the backend could not tell. On a tenant cluster the session keeps the backend
+// session of the exchange, its statements are forwarded on it.
+func (cc *clientConn) loginPassthrough(host string) (bool, error) {
+	var authUser, authHost string
+	relay, err := cc.authPassthrough(context.Background(), cc.passthrough)
+	if err == nil {
+		defer relay.Close()
+		authUser, authHost, err = relay.CurrentUser()
+	}
+	var sqlErr *proxymysql.SqlError
+	if goerr.As(err, &sqlErr) {
+		metrics.ProxyAuthPassthroughCounter.WithLabelValues("refused").Inc()
+		golog.Warn("server", "loginPassthrough", "backend refused the client", 0,
+			"connID", cc.connectionID, "user", cc.user, "host", host, "error", err)
+		return false, nil
+	}
+	if goerr.Is(err, proxyerrors.ErrRelayCleartext) {
+		metrics.ProxyAuthPassthroughCounter.WithLabelValues("refused").Inc()
+		golog.Warn("server", "loginPassthrough", "cleartext password not relayed, auth_relay_tls is not set", 0,
+			"connID", cc.connectionID, "user", cc.user, "host", host)
+		return false, nil
+	}
+	if err != nil {
+		metrics.ProxyAuthPassthroughCounter.WithLabelValues("failed").Inc()
+		return false, err
+	}
+	metrics.ProxyAuthPassthroughCounter.WithLabelValues("ok").Inc()
+	if !bindPassthroughUser(cc.ctx, cc.passthrough, cc.user, host, authUser, authHost) {
+		golog.Warn("server", "loginPassthrough", "user authed by the backend is unknown to the proxy", 0,
+			"connID", cc.connectionID, "user", cc.user, "host", host, "authUser", authUser, "authHost", authHost)
+		return false, nil
+	}
+	cc.releaseTenantConn()
+	if isTenantCluster(cc.passthrough) {
+		cc.tenant = cc.passthrough
+		cc.tenantConn = relay.Session(cc.passthrough)
+	}
+	return true, nil
+}
+
+// accountChecker looks up the accounts of the proxy for a session.
+type accountChecker interface {
+	sessionctx.Context
+	AuthWithoutVerification(user *auth.UserIdentity) bool
+}
+
+// isTenantCluster tells whether the cluster is a tenant one, its accounts are not the ones
+// of the proxy.
+func isTenantCluster(cluster *backend.Cluster) bool {
+	return cluster.ProxyNode != nil && cluster.ProxyNode.Remote
+}
+
+// bindPassthroughUser sets the account the backend of the cluster authenticated the client
+// as in the session. The users of a tenant cluster are unknown to the proxy, a home user of
+// the same name is another account, so they are never looked up in its privilege tables:
+// the session gets no privilege on the proxy. Its statements are forwarded uncompiled on the
+// backend session the tenant authenticated the client on, the tenant checks them there.
+// On the cluster of the proxy the account has to be the one the proxy knows for the client.
+func bindPassthroughUser(sess accountChecker, cluster *backend.Cluster, user, host, authUser, authHost string) bool {
+	vars := sess.GetSessionVars()
+	if isTenantCluster(cluster) {
+		vars.User = &auth.UserIdentity{Username: user, Hostname: host, AuthUsername: authUser, AuthHostname: authHost}
+		vars.ActiveRoles = nil
+		privilege.BindPrivilegeManager(sess, tenantPrivileges{privilege.GetPrivilegeManager(sess)})
+		return true
+	}
+	if !sess.AuthWithoutVerification(&auth.UserIdentity{Username: user, Hostname: host}) {
+		return false
+	}
+	if vars.User.AuthUsername != authUser || vars.User.AuthHostname != authHost {
+		vars.User, vars.ActiveRoles = nil, nil
+		return false
+	}
+	return true
+}
+
+// tenantPrivileges is the privilege manager of a session logged in on a tenant cluster, its
+// account has no privilege on the proxy.
+type tenantPrivileges struct {
+	privilege.Manager
+}
+
+func (tenantPrivileges) ShowGrants(sessionctx.Context, *auth.UserIdentity, []*auth.RoleIdentity) ([]string, error) {
+	return nil, nil
+}
+
+func (tenantPrivileges) GetEncodedPassword(string, string) string {
+	return ""
+}
+
+func (tenantPrivileges) RequestVerification([]*auth.RoleIdentity, string, string, string, mysql.PrivilegeType) bool {
+	return false
+}
+
+func (tenantPrivileges) RequestVerificationWithUser(string, string, string, mysql.PrivilegeType, *auth.UserIdentity) bool {
+	return false
+}
+
+func (tenantPrivileges) HasExplicitlyGrantedDynamicPrivilege([]*auth.RoleIdentity, string, bool) bool {
+	return false
+}
+
+func (tenantPrivileges) RequestDynamicVerification([]*auth.RoleIdentity, string, bool) bool {
+	return false
+}
+
+func (tenantPrivileges) RequestDynamicVerificationWithUser(string, bool, *auth.UserIdentity) bool {
+	return false
+}
+
+func (tenantPrivileges) ConnectionVerification(string, string, []byte, []byte, *tls.ConnectionState) (string, string, bool) {
+	return "", "", false
+}
+
+func (tenantPrivileges) GetAuthWithoutVerification(string, string) (string, string, bool) {
+	return "", "", false
+}
+
+func (tenantPrivileges) DBIsVisible([]*auth.RoleIdentity, string) bool {
+	return false
+}
+
+func (tenantPrivileges) UserPrivilegesTable([]*auth.RoleIdentity, string, string) [][]types.Datum {
+	return nil
+}
+
+func (tenantPrivileges) ActiveRoles(sessionctx.Context, []*auth.RoleIdentity) (bool, string) {
+	return false, ""
+}
+
+func (tenantPrivileges) FindEdge(sessionctx.Context, *auth.RoleIdentity, *auth.UserIdentity) bool {
+	return false
+}
+
+func (tenantPrivileges) GetDefaultRoles(string, string) []*auth.RoleIdentity {
+	return nil
+}
+
+func (tenantPrivileges) GetAllRoles(string, string) []*auth.RoleIdentity {
+	return nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser/auth"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/privilege"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/util/mock"
+)
+
+// homeAccounts is a session knowing the accounts of the proxy by user, every one of them
+// has all the privileges.
+type homeAccounts struct {
+	*mock.Context
+	hosts  map[string]string
+	looked bool
+}
+
+func (h *homeAccounts) AuthWithoutVerification(user *auth.UserIdentity) bool {
+	h.looked = true
+	host, ok := h.hosts[user.Username]
+	if !ok {
+		return false
+	}
+	user.AuthUsername, user.AuthHostname = user.Username, host
+	h.GetSessionVars().User = user
+	return true
+}
+
+type superPrivileges struct {
+	privilege.Manager
+}
+
+func (superPrivileges) RequestVerification([]*auth.RoleIdentity, string, string, string, mysql.PrivilegeType) bool {
+	return true
+}
+
+func (superPrivileges) RequestDynamicVerification([]*auth.RoleIdentity, string, bool) bool {
+	return true
+}
+
+func newHomeAccounts(hosts map[string]string) *homeAccounts {
+	sess := &homeAccounts{Context: mock.NewContext(), hosts: hosts}
+	privilege.BindPrivilegeManager(sess, superPrivileges{})
+	return sess
+}
+
+func TestBindPassthroughUserTenant(t *testing.T) {
+	tenant := &backend.Cluster{ProxyNode: &backend.Proxy{Remote: true}}
+	//the tenant admin is not the privileged admin of the proxy
+	sess := newHomeAccounts(map[string]string{"admin": "%"})
+	if !bindPassthroughUser(sess, tenant, "admin", "10.0.0.1", "admin", "10.%") {
+		t.Fatal("tenant user authed by its backend refused")
+	}
+	if sess.looked {
+		t.Fatal("tenant user looked up in the accounts of the proxy")
+	}
+	user := sess.GetSessionVars().User
+	if user.AuthUsername != "admin" || user.AuthHostname != "10.%" {
+		t.Fatalf("session bound to %s@%s, want the tenant account admin@10.%%", user.AuthUsername, user.AuthHostname)
+	}
+	pm := privilege.GetPrivilegeManager(sess)
+	if pm.RequestVerification(nil, "mysql", "user", "", mysql.SelectPriv) ||
+		pm.RequestDynamicVerification(nil, "SUPER", false) || pm.DBIsVisible(nil, "mysql") {
+		t.Fatal("tenant user got the privileges of the home user of the same name")
+	}
+}
+
+func TestBindPassthroughUserHome(t *testing.T) {
+	home := &backend.Cluster{ProxyNode: &backend.Proxy{}}
+	sess := newHomeAccounts(map[string]string{"app": "%"})
+	if !bindPassthroughUser(sess, home, "app", "10.0.0.1", "app", "%") {
+		t.Fatal("home user authed by the backend refused")
+	}
+	if !privilege.GetPrivilegeManager(sess).RequestVerification(nil, "test", "", "", mysql.SelectPriv) {
+		t.Fatal("home user lost its privileges")
+	}
+	//the backend authenticated another account than the one of the proxy
+	sess = newHomeAccounts(map[string]string{"app": "%"})
+	if bindPassthroughUser(sess, home, "app", "10.0.0.1", "app", "10.%") {
+		t.Fatal("home user bound to an account the backend did not authenticate")
+	}
+	if sess.GetSessionVars().User != nil {
+		t.Fatal("refused user left in the session")
+	}
+	sess = newHomeAccounts(nil)
+	if bindPassthroughUser(sess, home, "app", "10.0.0.1", "app", "%") {
+		t.Fatal("user unknown to the proxy accepted")
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/predictor"
+	"github.com/pingcap/tidb/sessionctx/variable"
+)
+
+// simEvent is a change of the cores of a pool in the simulated timeline.
+type simEvent struct {
+	Time   int64
+	Pool   string
+	From   float64
+	To     float64
+	Policy string
+	Action string
+	Cost   int64
+}
+
+// simSummary is what a pool used over the simulated trace.
+type simSummary struct {
+	CoreSeconds float64
+	PeakCores   float64
+	ScaleOuts   int
+	ScaleIns    int
+}
+
+// simPool is the autoscaler of a pool replayed offline, the scaler serves each request at
+// once.
+type simPool struct {
+	ev        *poolEvaluator
+	actual    float64
+	addedCost uint64
+	nextEval  int64
+	predictor *predictor.HoltWinters
+	summary   simSummary
+}
+
+// autoscaleSim replays a counter trace through the scaling policies of the cluster config.
+type autoscaleSim struct {
+	cfg          config.ClusterConfig
+	pools        map[string]*simPool
+	quietSeconds int64
+	events       []simEvent
+}
+
+func newAutoscaleSim(cfg config.ClusterConfig) *autoscaleSim {
+	sim := &autoscaleSim{cfg: cfg, pools: make(map[string]*simPool)}
+	for _, tidbType := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+		p := &simPool{ev: newPoolEvaluator(tidbType, cfg.AutoscaleOf(tidbType), &Scale{})}
+		if cfg.PredictAhead > 0 {
+			p.predictor = predictor.NewDefault()
+		}
+		sim.pools[tidbType] = p
+	}
+	return sim
+}
+
+// run replays the samples, the cores of the pools start at the ones of the first sample, whose
+// cost was sent before the replay starts.
+func (sim *autoscaleSim) run(samples []CounterSample) {
+	if len(samples) == 0 {
+		return
+	}
+	tp, ap := sim.pools[backend.TiDBForTP], sim.pools[backend.TiDBForAP]
+	tp.actual, ap.actual = samples[0].TPCores, samples[0].APCores
+	last := samples[0].Time
+	for _, p := range sim.pools {
+		p.nextEval = last + int64(p.ev.cfg.Interval)
+		p.summary.PeakCores = p.actual
+	}
+	for _, sample := range samples[1:] {
+		if elapsed := sample.Time - last; elapsed > 0 {
+			for _, p := range sim.pools {
+				p.summary.CoreSeconds += p.actual * float64(elapsed)
+			}
+			last = sample.Time
+		}
+		tp.addedCost += uint64(sample.TPCost)
+		for _, tidbType := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+			if p := sim.pools[tidbType]; sample.Time >= p.nextEval {
+				sim.evaluate(tidbType, p, sample)
+				p.nextEval = sample.Time + int64(p.ev.cfg.Interval)
+			}
+		}
+	}
+}
+
+// evaluate mirrors Serverless.evaluate for one pool, the ap routing queue and the stepwise
+// scale in of the live proxy are not replayed.
+func (sim *autoscaleSim) evaluate(tidbType string, p *simPool, sample CounterSample) {
+	ev, scale := p.ev, p.ev.scale
+	usage := backend.PoolUsage{Cost: sample.APCost, AddedCost: p.addedCost, Cores: p.actual}
+	p.addedCost = 0
+	cost := ev.sample(usage)
+	desired, policy := scale.GetNeedCores(cost, tidbType), policyCost
+	if p.predictor != nil {
+		now := time.Unix(sample.Time, 0)
+		p.predictor.Observe(now, float64(cost))
+		if p.predictor.Ready() {
+			ahead := time.Duration(sim.cfg.PredictAhead) * time.Minute
+			if predictcore := scale.GetNeedCores(int64(p.predictor.Forecast(now, ahead)), tidbType); predictcore > desired {
+				desired, policy = predictcore, policyPredict
+			}
+		}
+	}
+	min, max := sim.cfg.ReplicaBounds(tidbType)
+	if boundcore := boundCores(desired, min, max, DefaultReplicaCores, DefaultReplicaCores); boundcore != desired {
+		desired, policy = boundcore, policyReplicaBound
+	}
+	if stepcore := ev.step(p.actual, desired); stepcore != desired {
+		desired, policy = stepcore, policyStep
+	}
+	if tidbType == backend.TiDBForTP {
+		desired, policy = sim.computeRole(p, sample, desired, policy, min)
+	}
+
+	switch {
+	case policy == policyPureCompute || policy == policyComplex:
+		scale.resetscalein()
+		sim.setCores(tidbType, p, sample.Time, cost, desired, policy, actionSetCores)
+	case desired > p.actual:
+		scale.resetscalein()
+		sim.setCores(tidbType, p, sample.Time, cost, desired, policy, actionScaleOut)
+	case desired < p.actual:
+		needcore, due := scale.countScalein(p.actual-desired, desired, ev.cfg.Interval)
+		if due {
+			scale.resetscalein()
+			if needcore < p.actual {
+				sim.setCores(tidbType, p, sample.Time, cost, needcore, policy, actionScaleIn)
+			}
+		}
+	}
+}
+
+// computeRole mirrors Serverless.computeRole, the proxy computes alone while tp has no core.
+func (sim *autoscaleSim) computeRole(p *simPool, sample CounterSample, desired float64, policy string, minReplicas int) (float64, string) {
+	quiet := sample.ProxyCost < variable.ServerlessVariable.TPScaleInCost.Load() &&
+		sample.QPS < variable.ServerlessVariable.TPScaleInQPS.Load()
+	if !quiet {
+		sim.quietSeconds = 0
+		if p.actual == 0 {
+			return math.Max(desired, 1), policyComplex
+		}
+		return desired, policy
+	}
+	sim.quietSeconds += int64(p.ev.cfg.Interval)
+	if sim.quietSeconds >= variable.ServerlessVariable.TPScaleInSeconds.Load() && p.actual > 0 && minReplicas <= 0 {
+		sim.quietSeconds = 0
+		return 0, policyPureCompute
+	}
+	return desired, policy
+}
+
+func (sim *autoscaleSim) setCores(tidbType string, p *simPool, at, cost int64, cores float64, policy, action string) {
+	if cores == p.actual {
+		return
+	}
+	sim.events = append(sim.events, simEvent{
+		Time:   at,
+		Pool:   tidbType,
+		From:   p.actual,
+		To:     cores,
+		Policy: policy,
+		Action: action,
+		Cost:   cost,
+	})
+	if cores > p.actual {
+		p.summary.ScaleOuts++
+	} else {
+		p.summary.ScaleIns++
+	}
+	p.actual = cores
+	if cores > p.summary.PeakCores {
+		p.summary.PeakCores = cores
+	}
+}
+
+// print writes the timeline and the core hours and cost of every pool at corePrice per
+// core hour.
+func (sim *autoscaleSim) print(out io.Writer, corePrice float64) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "time\tpool\taction\tcores\tpolicy\tcost")
+	for _, e := range sim.events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%g -> %g\t%s\t%d\n", time.Unix(e.Time, 0).UTC().Format(time.RFC3339),
+			e.Pool, e.Action, e.From, e.To, e.Policy, e.Cost)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "pool\tcore_hours\tpeak_cores\tscale_outs\tscale_ins\test_cost")
+	for _, tidbType := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+		s := sim.pools[tidbType].summary
+		hours := s.CoreSeconds / 3600
+		fmt.Fprintf(w, "%s\t%.2f\t%g\t%d\t%d\t%.2f\n", tidbType, hours, s.PeakCores, s.ScaleOuts, s.ScaleIns, hours*corePrice)
+	}
+	return w.Flush()
+}
+
+// SimulateAutoscale replays the counter trace in traceFile through the scaling policies of
+// the proxy config, and writes the scale timeline and the estimated cost to out. The
+// serverless_tp_scalein_* thresholds are the global values of the process.
+func SimulateAutoscale(cfg *config.Config, traceFile string, out io.Writer, corePrice float64) error {
+	f, err := os.Open(traceFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	samples, err := readCounterTrace(f)
+	if err != nil {
+		return err
+	}
+	if cfg.Cluster.ScaleInInterval != 0 {
+		variable.ServerlessVariable.ScaleInInterval.Store(int64(cfg.Cluster.ScaleInInterval))
+	}
+	sim := newAutoscaleSim(cfg.Cluster)
+	sim.run(samples)
+	return sim.print(out, corePrice)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func TestAutoscaleSim(t *testing.T) {
+	var trace bytes.Buffer
+	enc := json.NewEncoder(&trace)
+	//the proxy computes alone, tp load comes for 10s and leaves
+	for i := int64(0); i < 60; i++ {
+		sample := CounterSample{Time: 1700000000 + i}
+		if i < 10 {
+			sample.QPS, sample.TPCost, sample.ProxyCost = 1000, 3000000, 3000000
+		}
+		if err := enc.Encode(&sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+	samples, err := readCounterTrace(&trace)
+	if err != nil || len(samples) != 60 {
+		t.Fatalf("read trace: %d %v", len(samples), err)
+	}
+
+	sim := newAutoscaleSim(config.ClusterConfig{})
+	sim.run(samples)
+	if len(sim.events) != 2 {
+		t.Fatalf("expect 2 scale events, got %+v", sim.events)
+	}
+	if e := sim.events[0]; e.Pool != backend.TiDBForTP || e.From != 0 || e.To != 3 || e.Policy != policyComplex {
+		t.Fatalf("expect tp set to 3 cores by the load, got %+v", e)
+	}
+	//tp is dropped after serverless_tp_scalein_seconds of quiet
+	if e := sim.events[1]; e.To != 0 || e.Policy != policyPureCompute || e.Time != 1700000024 {
+		t.Fatalf("expect tp dropped after 15s of quiet, got %+v", e)
+	}
+	tp := sim.pools[backend.TiDBForTP].summary
+	if tp.CoreSeconds != 69 || tp.PeakCores != 3 || tp.ScaleOuts != 1 || tp.ScaleIns != 1 {
+		t.Fatalf("unexpected tp summary %+v", tp)
+	}
+
+	var out bytes.Buffer
+	if err = sim.print(&out, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "complex_compute") || !strings.Contains(out.String(), "0.04") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+
+	if _, err = readCounterTrace(strings.NewReader("{\"time\":1}\n\nnot json\n")); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("expect the bad line reported, got %v", err)
+	}
+}
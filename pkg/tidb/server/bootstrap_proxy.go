@@ -0,0 +1,100 @@
+package server
+
+import (
+	goerr "errors"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+const (
+	bootstrapRetryMin = 600 * time.Millisecond
+	bootstrapRetryMax = 10 * time.Second
+)
+
+var (
+	errNoProxyPod = goerr.New("proxy pod not found")
+	errNoReadyPod = goerr.New("no tidb pod is ready")
+)
+
+// discoverCluster fills the pools with the tidbs of every type. The pools are only
+// touched once all of them are discovered, so a failed attempt leaves them empty.
+func discoverCluster(cluster *backend.Cluster, discovery Discovery) error {
+	var norms = []string{backend.TiDBForTP, backend.TiDBForAP}
+	tidbs := make(map[string]string, len(norms))
+	for _, v := range norms {
+		t, err := discovery.Discover(v)
+		if err != nil {
+			return err
+		}
+		tidbs[v] = t
+	}
+	for _, v := range norms {
+		golog.Info("server", "discoverCluster", "Server running", 0, "tidbtype is ", v,
+			"Podlist string is ----------", tidbs[v])
+		if err := cluster.ParseTidbs(tidbs[v], v, cluster.Cfg); err != nil {
+			for _, pool := range cluster.BackendPools {
+				pool.Reset()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// bootstrapCluster keeps discovering the backends until the pods are ready, so the proxy
+// doesn't crash loop when it starts before the tidb cluster. Clients get a cluster
+// initializing error until then, and the cluster checks start once it is done. In meshed
+// namespaces it first waits for the sidecar that carries the dials. Pools restored from
+// the snapshot serve at once, and are synced with the discovery once it succeeds.
+func (s *Server) bootstrapCluster() {
+	s.waitSidecar()
+	cluster := s.cluster
+	restored := s.restorePoolSnapshot()
+	if restored {
+		startCluster(cluster)
+	}
+	wait := bootstrapRetryMin
+	for attempt := 1; ; attempt++ {
+		if s.inShutdownMode {
+			return
+		}
+		var err error
+		if restored {
+			err = s.syncDiscovered()
+		} else {
+			err = discoverCluster(cluster, s.discovery)
+		}
+		if err == nil {
+			break
+		}
+		golog.Warn("server", "bootstrapCluster", "discover cluster failed, retry later", 0,
+			"attempt", attempt,
+			"retry_in", wait.String(),
+			"error", err)
+		if !s.pause(wait) {
+			return
+		}
+		if wait *= 2; wait > bootstrapRetryMax {
+			wait = bootstrapRetryMax
+		}
+	}
+
+	if !restored {
+		startCluster(cluster)
+	}
+	s.goLoop(s.savePoolSnapshots)
+}
+
+// startCluster opens the cluster to clients and starts the cluster checks.
+func startCluster(cluster *backend.Cluster) {
+	cluster.Online = true
+	cluster.SetInitialized()
+	golog.Info("server", "bootstrapCluster", "cluster initialized", 0,
+		"cluster", cluster.Cfg.ClusterName)
+	go cluster.CheckCluster()
+	go cluster.CheckWarmup()
+	go cluster.CheckWeights()
+	go cluster.WatchPods()
+}
@@ -0,0 +1,331 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/auth"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/privilege"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_caching_sha2_authentication_exchanges.html
+const (
+	sha2Command       = 1
+	sha2RequestPubKey = 2
+	sha2FastAuthOk    = 3
+	sha2FastAuthFail  = 4
+)
+
+// sha2State is how the client logging in by caching_sha2_password was checked.
+type sha2State int
+
+const (
+	sha2None sha2State = iota
+	// the scramble matched the cached full auth of the account
+	sha2Fast
+	// the client sent its password by tls, the unix socket or encrypted by the rsa key
+	sha2Full
+)
+
+// cachingSha2 holds the rsa key of the full auth and the accounts cached for the fast auth.
+type cachingSha2 struct {
+	bits      int
+	cacheSize int
+
+	keyOnce sync.Once
+	key     *rsa.PrivateKey
+	pubPEM  []byte
+	keyErr  error
+
+	mu    sync.Mutex
+	cache map[string]sha2Entry
+}
+
+// sha2Entry is the last full auth of a client user and host.
+type sha2Entry struct {
+	//sha256(sha256(password))
+	stage2 []byte
+	//account matched, the entry is stale once its password changes
+	authUser string
+	authHost string
+	encoded  string
+}
+
+// newCachingSha2 loads the key files, a key is generated on first use if they are empty.
+func newCachingSha2(cfg *proxyconfig.Config) (*cachingSha2, error) {
+	c := &cachingSha2{
+		bits:      proxyconfig.DefaultCachingSha2KeyBits,
+		cacheSize: proxyconfig.DefaultCachingSha2CacheSize,
+		cache:     make(map[string]sha2Entry),
+	}
+	if cfg == nil {
+		return c, nil
+	}
+	sc := cfg.CachingSha2
+	if sc.KeyBits > 0 {
+		c.bits = sc.KeyBits
+	}
+	if sc.CacheSize != 0 {
+		c.cacheSize = sc.CacheSize
+	}
+	if sc.PrivateKey == "" {
+		if sc.PublicKey != "" {
+			return nil, errors.New("caching_sha2 public_key without private_key")
+		}
+		return c, nil
+	}
+	key, err := loadRSAPrivateKey(sc.PrivateKey)
+	if err != nil {
+		return nil, errors.Annotate(err, "caching_sha2 private_key")
+	}
+	var pubPEM []byte
+	if sc.PublicKey != "" {
+		if pubPEM, err = ioutil.ReadFile(sc.PublicKey); err != nil {
+			return nil, errors.Annotate(err, "caching_sha2 public_key")
+		}
+	} else if pubPEM, err = encodeRSAPublicKey(&key.PublicKey); err != nil {
+		return nil, err
+	}
+	c.keyOnce.Do(func() {
+		c.key, c.pubPEM = key, pubPEM
+	})
+	return c, nil
+}
+
+func loadRSAPrivateKey(fileName string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem block")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not a rsa key")
+	}
+	return key, nil
+}
+
+func encodeRSAPublicKey(key *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// rsaKey returns the key of the full auth, generating it on first use if no file is set.
+func (c *cachingSha2) rsaKey() (*rsa.PrivateKey, []byte, error) {
+	c.keyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, c.bits)
+		if err != nil {
+			c.keyErr = err
+			return
+		}
+		if c.pubPEM, c.keyErr = encodeRSAPublicKey(&key.PublicKey); c.keyErr == nil {
+			c.key = key
+			golog.Info("server", "rsaKey", "generated caching_sha2 rsa key", 0, "bits", c.bits)
+		}
+	})
+	return c.key, c.pubPEM, c.keyErr
+}
+
+// decrypt returns the password the client encrypted by the public key, xored with the
+// scramble and ending with NUL.
+func (c *cachingSha2) decrypt(data, salt []byte) ([]byte, error) {
+	key, _, err := c.rsaKey()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, key, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range plain {
+		plain[i] ^= salt[i%len(salt)]
+	}
+	return bytes.TrimRight(plain, "\x00"), nil
+}
+
+func sha2CacheKey(user, host string) string {
+	return user + "@" + host
+}
+
+// verify checks the scramble of the client against its cached full auth, the scramble is
+// xor(sha256(password), sha256(sha256(sha256(password)), salt)).
+func (c *cachingSha2) verify(user, host string, scramble, salt []byte) (sha2Entry, bool) {
+	if len(scramble) != sha256.Size {
+		return sha2Entry{}, false
+	}
+	entry, ok := c.entry(user, host)
+	if !ok {
+		return sha2Entry{}, false
+	}
+	h := sha256.New()
+	h.Write(entry.stage2)
+	h.Write(salt)
+	stage1 := h.Sum(nil)
+	for i := range stage1 {
+		stage1[i] ^= scramble[i]
+	}
+	stage2 := sha256.Sum256(stage1)
+	return entry, subtle.ConstantTimeCompare(stage2[:], entry.stage2) == 1
+}
+
+func (c *cachingSha2) entry(user, host string) (sha2Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.cache[sha2CacheKey(user, host)]
+	return entry, ok
+}
+
+// remember caches the full auth of the client for its next fast auths.
+func (c *cachingSha2) remember(user, host string, password []byte, entry sha2Entry) {
+	if c.cacheSize < 0 {
+		return
+	}
+	stage1 := sha256.Sum256(password)
+	stage2 := sha256.Sum256(stage1[:])
+	entry.stage2 = stage2[:]
+	key := sha2CacheKey(user, host)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.cache[key]; !ok && len(c.cache) >= c.cacheSize {
+		//full, an arbitrary account falls back to the full auth
+		for k := range c.cache {
+			delete(c.cache, k)
+			break
+		}
+	}
+	c.cache[key] = entry
+}
+
+func (c *cachingSha2) forget(user, host string) {
+	c.mu.Lock()
+	delete(c.cache, sha2CacheKey(user, host))
+	c.mu.Unlock()
+}
+
+// authSha runs the caching_sha2_password exchange after the handshake response. A scramble
+// matching the cached full auth of the account is accepted as is, otherwise the client is
+// asked for its password, sent in clear by tls and the unix socket, else encrypted by the
+// rsa public key. It returns the password of a full auth, nil after a fast auth.
+func (cc *clientConn) authSha(ctx context.Context, scramble []byte) ([]byte, error) {
+	sha2 := cc.server.cachingSha2
+	host, _, err := cc.PeerHost("YES")
+	if err != nil {
+		return nil, err
+	}
+	if cc.fastAuthSha(host, scramble) {
+		cc.sha2 = sha2Fast
+		return nil, cc.writeSha2(ctx, []byte{sha2Command, sha2FastAuthOk})
+	}
+	if err = cc.writeSha2(ctx, []byte{sha2Command, sha2FastAuthFail}); err != nil {
+		return nil, err
+	}
+	data, err := cc.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	cc.sha2 = sha2Full
+	if cc.tlsConn != nil || cc.isUnixSocket {
+		metrics.ProxyCachingSha2Counter.WithLabelValues("full_tls").Inc()
+		return bytes.TrimRight(data, "\x00"), nil
+	}
+	if len(data) == 1 && data[0] == sha2RequestPubKey {
+		_, pubPEM, err := sha2.rsaKey()
+		if err != nil {
+			return nil, err
+		}
+		if err = cc.writeSha2(ctx, append([]byte{sha2Command}, pubPEM...)); err != nil {
+			return nil, err
+		}
+		if data, err = cc.readPacket(); err != nil {
+			return nil, err
+		}
+	}
+	//clients having the public key send the encrypted password right away
+	password, err := sha2.decrypt(data, cc.salt)
+	if err != nil {
+		metrics.ProxyCachingSha2Counter.WithLabelValues("failed").Inc()
+		golog.Warn("server", "authSha", "decrypt password failed", 0,
+			"connID", cc.connectionID, "user", cc.user, "host", host, "error", err)
+		return nil, errAccessDenied.FastGenByArgs(cc.user, host, "YES")
+	}
+	metrics.ProxyCachingSha2Counter.WithLabelValues("full_rsa").Inc()
+	return password, nil
+}
+
+// fastAuthSha checks the scramble against the cached full auth, which is dropped once the
+// password of the account changed.
+func (cc *clientConn) fastAuthSha(host string, scramble []byte) bool {
+	if cc.ctx == nil {
+		return false
+	}
+	sha2 := cc.server.cachingSha2
+	entry, ok := sha2.verify(cc.user, host, scramble, cc.salt)
+	if !ok {
+		return false
+	}
+	pm := privilege.GetPrivilegeManager(cc.ctx.Session)
+	if pm == nil || pm.GetEncodedPassword(entry.authUser, entry.authHost) != entry.encoded {
+		sha2.forget(cc.user, host)
+		return false
+	}
+	metrics.ProxyCachingSha2Counter.WithLabelValues("fast").Inc()
+	return true
+}
+
+// authSha2 logs in the client checked by authSha, with the password of a full auth. It's
+// checked only once, a COM_CHANGE_USER afterwards goes by the native auth.
+func (cc *clientConn) authSha2(host string, authData []byte) bool {
+	state := cc.sha2
+	cc.sha2 = sha2None
+	user := &auth.UserIdentity{Username: cc.user, Hostname: host}
+	if state == sha2Fast {
+		//the cached account, not one created since with the same user
+		entry, ok := cc.server.cachingSha2.entry(cc.user, host)
+		return ok && cc.ctx.AuthWithoutVerification(user) &&
+			user.AuthUsername == entry.authUser && user.AuthHostname == entry.authHost
+	}
+	if !cc.ctx.Auth(user, authData, cc.salt) {
+		metrics.ProxyCachingSha2Counter.WithLabelValues("failed").Inc()
+		return false
+	}
+	vars := cc.ctx.GetSessionVars()
+	if pm := privilege.GetPrivilegeManager(cc.ctx.Session); pm != nil && vars.User != nil {
+		entry := sha2Entry{authUser: vars.User.AuthUsername, authHost: vars.User.AuthHostname}
+		entry.encoded = pm.GetEncodedPassword(entry.authUser, entry.authHost)
+		cc.server.cachingSha2.remember(cc.user, host, authData, entry)
+	}
+	return true
+}
+
+func (cc *clientConn) writeSha2(ctx context.Context, data []byte) error {
+	if err := cc.writePacket(append([]byte{0, 0, 0, 0}, data...)); err != nil {
+		return err
+	}
+	return cc.flush(ctx)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+// sha2Scramble is the fast auth scramble of a client.
+func sha2Scramble(password, salt []byte) []byte {
+	stage1 := sha256.Sum256(password)
+	stage2 := sha256.Sum256(stage1[:])
+	h := sha256.New()
+	h.Write(stage2[:])
+	h.Write(salt)
+	scramble := h.Sum(nil)
+	for i := range scramble {
+		scramble[i] ^= stage1[i]
+	}
+	return scramble
+}
+
+func TestCachingSha2FastAuth(t *testing.T) {
+	c, err := newCachingSha2(&config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := []byte("0123456789abcdefghij")
+	if _, ok := c.verify("u", "h", sha2Scramble([]byte("pwd"), salt), salt); ok {
+		t.Fatal("fast auth without full auth")
+	}
+	c.remember("u", "h", []byte("pwd"), sha2Entry{authUser: "u", authHost: "%"})
+	entry, ok := c.verify("u", "h", sha2Scramble([]byte("pwd"), salt), salt)
+	if !ok || entry.authHost != "%" {
+		t.Fatalf("fast auth failed, entry %+v", entry)
+	}
+	if _, ok := c.verify("u", "h", sha2Scramble([]byte("bad"), salt), salt); ok {
+		t.Fatal("fast auth by a wrong password")
+	}
+	if _, ok := c.verify("u", "other", sha2Scramble([]byte("pwd"), salt), salt); ok {
+		t.Fatal("fast auth from another host")
+	}
+	c.forget("u", "h")
+	if _, ok := c.verify("u", "h", sha2Scramble([]byte("pwd"), salt), salt); ok {
+		t.Fatal("fast auth after forget")
+	}
+
+	c, _ = newCachingSha2(&config.Config{CachingSha2: config.CachingSha2Config{CacheSize: 1}})
+	c.remember("a", "h", []byte("pwd"), sha2Entry{})
+	c.remember("b", "h", []byte("pwd"), sha2Entry{})
+	if len(c.cache) != 1 {
+		t.Fatalf("%d accounts cached, want 1", len(c.cache))
+	}
+	c, _ = newCachingSha2(&config.Config{CachingSha2: config.CachingSha2Config{CacheSize: -1}})
+	c.remember("a", "h", []byte("pwd"), sha2Entry{})
+	if len(c.cache) != 0 {
+		t.Fatal("cached with the fast auth disabled")
+	}
+}
+
+func TestCachingSha2Decrypt(t *testing.T) {
+	c, err := newCachingSha2(&config.Config{CachingSha2: config.CachingSha2Config{KeyBits: 1024}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pubPEM, err := c.rsaKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pubPEM)
+	if block == nil {
+		t.Fatal("public key is not pem")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//what clients send, the password ending with NUL xored with the salt
+	salt := []byte("0123456789abcdefghij")
+	plain := append([]byte("a password longer than the salt"), 0)
+	for i := range plain {
+		plain[i] ^= salt[i%len(salt)]
+	}
+	data, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub.(*rsa.PublicKey), plain, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, err := c.decrypt(data, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(password, []byte("a password longer than the salt")) {
+		t.Fatalf("decrypted %q", password)
+	}
+	if _, err = c.decrypt([]byte("garbage"), salt); err == nil {
+		t.Fatal("decrypted garbage")
+	}
+}
+
+func TestCachingSha2KeyFiles(t *testing.T) {
+	if _, err := newCachingSha2(&config.Config{CachingSha2: config.CachingSha2Config{PublicKey: "pub.pem"}}); err == nil {
+		t.Fatal("public key without private key")
+	}
+	if _, err := newCachingSha2(&config.Config{CachingSha2: config.CachingSha2Config{PrivateKey: "/nonexistent.pem"}}); err == nil {
+		t.Fatal("missing private key file")
+	}
+}
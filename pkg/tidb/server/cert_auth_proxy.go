@@ -0,0 +1,141 @@
+package server
+
+import (
+	"crypto/x509"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/metrics"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/util"
+)
+
+// certAuth maps the verified client certificates to proxy users, so services log in by
+// their certificate instead of a shared password.
+type certAuth struct {
+	mappings []certMapping
+	require  string
+}
+
+type certMapping struct {
+	subject string
+	sans    map[util.SANType][]string
+	user    string
+}
+
+// newCertAuth returns nil if the certificate auth is disabled.
+func newCertAuth(cfg *proxyconfig.Config) (*certAuth, error) {
+	if cfg == nil || len(cfg.CertAuth.Mappings) == 0 && cfg.CertAuth.Require == "" {
+		return nil, nil
+	}
+	switch cfg.CertAuth.Require {
+	case "", proxyconfig.CertRequireX509, proxyconfig.CertRequireSAN:
+	default:
+		return nil, errors.Errorf("invalid cert_auth require %s", cfg.CertAuth.Require)
+	}
+	a := &certAuth{require: cfg.CertAuth.Require}
+	for _, m := range cfg.CertAuth.Mappings {
+		if m.User == "" {
+			return nil, errors.New("cert_auth mapping without user")
+		}
+		//a mapping matching any certificate would log in every client as its user
+		if m.Subject == "" && m.SAN == "" {
+			return nil, errors.Errorf("cert_auth mapping of user %s matches any certificate", m.User)
+		}
+		mapping := certMapping{subject: m.Subject, user: m.User}
+		if m.SAN != "" {
+			sans, err := util.ParseAndCheckSAN(m.SAN)
+			if err != nil {
+				return nil, errors.Annotatef(err, "cert_auth mapping of user %s", m.User)
+			}
+			mapping.sans = sans
+		}
+		a.mappings = append(a.mappings, mapping)
+	}
+	return a, nil
+}
+
+// match returns the first mapping matched by the certificate, nil if none matches. With
+// require san only the mappings with sans are tried.
+func (a *certAuth) match(cert *x509.Certificate) *certMapping {
+	for i := range a.mappings {
+		m := &a.mappings[i]
+		if a.require == proxyconfig.CertRequireSAN && len(m.sans) == 0 {
+			continue
+		}
+		if m.subject != "" && m.subject != util.X509NameOnline(cert.Subject) {
+			continue
+		}
+		if !matchCertSAN(m.sans, cert) {
+			continue
+		}
+		return m
+	}
+	return nil
+}
+
+// matchCertSAN reports whether every san type has one of its values in the certificate,
+// the same way the REQUIRE SAN of a user is checked.
+func matchCertSAN(sans map[util.SANType][]string, cert *x509.Certificate) bool {
+	for typ, values := range sans {
+		var given []string
+		switch typ {
+		case util.URI:
+			for _, uri := range cert.URIs {
+				given = append(given, uri.String())
+			}
+		case util.DNS:
+			given = cert.DNSNames
+		case util.IP:
+			for _, ip := range cert.IPAddresses {
+				given = append(given, ip.String())
+			}
+		}
+		var matched bool
+		for _, v := range values {
+			for _, g := range given {
+				if v == g {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// authByCert maps the client certificate to the user it logs in as. A client naming
+// another user logs in by password, unless a certificate is required.
+func (cc *clientConn) authByCert() (bool, error) {
+	a := cc.server.certAuth
+	if a == nil {
+		return false, nil
+	}
+	var m *certMapping
+	if cc.tlsConn != nil {
+		//the chains are only set for a certificate verified by the ssl-ca
+		if chains := cc.tlsConn.ConnectionState().VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+			m = a.match(chains[0][0])
+		}
+	}
+	if m != nil && (cc.user == "" || cc.user == m.user) {
+		metrics.ProxyCertAuthCounter.WithLabelValues("mapped").Inc()
+		golog.Info("server", "authByCert", "client logged in by certificate", 0,
+			"connID", cc.connectionID, "user", m.user)
+		cc.user = m.user
+		return true, nil
+	}
+	if a.require == "" {
+		return false, nil
+	}
+	metrics.ProxyCertAuthCounter.WithLabelValues("rejected").Inc()
+	host, _, err := cc.PeerHost("NO")
+	if err != nil {
+		return false, err
+	}
+	golog.Warn("server", "authByCert", "reject client without a mapped certificate", 0,
+		"connID", cc.connectionID, "user", cc.user, "host", host, "require", a.require)
+	return false, errAccessDenied.FastGenByArgs(cc.user, host, "NO")
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"net/url"
+	"testing"
+
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+)
+
+func TestCertAuthMatch(t *testing.T) {
+	if a, err := newCertAuth(&proxyconfig.Config{}); a != nil || err != nil {
+		t.Fatal("cert auth enabled without mappings")
+	}
+	for _, m := range []proxyconfig.CertMappingConfig{
+		{Subject: "/CN=api"},
+		{User: "api"},
+		{SAN: "EMAIL:a@b", User: "api"},
+	} {
+		cfg := &proxyconfig.Config{CertAuth: proxyconfig.CertAuthConfig{Mappings: []proxyconfig.CertMappingConfig{m}}}
+		if _, err := newCertAuth(cfg); err == nil {
+			t.Fatalf("expect invalid mapping %+v", m)
+		}
+	}
+
+	cfg := &proxyconfig.Config{CertAuth: proxyconfig.CertAuthConfig{Mappings: []proxyconfig.CertMappingConfig{
+		{Subject: "/O=acme/CN=billing", User: "billing"},
+		{SAN: "URI:spiffe://cluster.local/ns/report/sa/api, DNS:api.report", User: "report"},
+	}}}
+	a, err := newCertAuth(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/report/sa/api")
+	//names are set as parsed from the certificate
+	billing := &x509.Certificate{Subject: pkix.Name{Names: []pkix.AttributeTypeAndValue{
+		{Type: asn1.ObjectIdentifier{2, 5, 4, 10}, Value: "acme"},
+		{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "billing"},
+	}}}
+	report := &x509.Certificate{URIs: []*url.URL{spiffe}, DNSNames: []string{"api.report"}, IPAddresses: []net.IP{net.IPv4(10, 0, 0, 1)}}
+	if m := a.match(billing); m == nil || m.user != "billing" {
+		t.Fatalf("expect billing matched by subject, got %+v", m)
+	}
+	if m := a.match(report); m == nil || m.user != "report" {
+		t.Fatalf("expect report matched by san, got %+v", m)
+	}
+	//every san type listed has to match
+	report.DNSNames = []string{"other.report"}
+	if m := a.match(report); m != nil {
+		t.Fatalf("expect no match without the dns san, got %+v", m)
+	}
+
+	cfg.CertAuth.Require = proxyconfig.CertRequireSAN
+	if a, err = newCertAuth(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if m := a.match(billing); m != nil {
+		t.Fatalf("expect subject mapping skipped when san is required, got %+v", m)
+	}
+	cfg.CertAuth.Require = "password"
+	if _, err = newCertAuth(cfg); err == nil {
+		t.Fatal("expect invalid require")
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const clientShards = 64
+
+type clientShard struct {
+	sync.RWMutex
+	clients map[uint64]*clientConn
+}
+
+// clientRegistry is the set of client connections, sharded by connection id so
+// monitoring queries iterating all clients only hold one shard at a time and
+// never stall new connections.
+type clientRegistry struct {
+	shards [clientShards]clientShard
+	count  int64
+}
+
+func newClientRegistry() *clientRegistry {
+	r := &clientRegistry{}
+	for i := range r.shards {
+		r.shards[i].clients = make(map[uint64]*clientConn)
+	}
+	return r
+}
+
+func (r *clientRegistry) shard(id uint64) *clientShard {
+	return &r.shards[id%clientShards]
+}
+
+// add registers the connection and returns the number of connections.
+func (r *clientRegistry) add(cc *clientConn) int {
+	s := r.shard(cc.connectionID)
+	s.Lock()
+	_, ok := s.clients[cc.connectionID]
+	s.clients[cc.connectionID] = cc
+	s.Unlock()
+	if ok {
+		return r.len()
+	}
+	return int(atomic.AddInt64(&r.count, 1))
+}
+
+// remove unregisters the connection and returns the number of connections.
+func (r *clientRegistry) remove(id uint64) int {
+	s := r.shard(id)
+	s.Lock()
+	_, ok := s.clients[id]
+	delete(s.clients, id)
+	s.Unlock()
+	if !ok {
+		return r.len()
+	}
+	return int(atomic.AddInt64(&r.count, -1))
+}
+
+func (r *clientRegistry) get(id uint64) (*clientConn, bool) {
+	s := r.shard(id)
+	s.RLock()
+	cc, ok := s.clients[id]
+	s.RUnlock()
+	return cc, ok
+}
+
+func (r *clientRegistry) len() int {
+	return int(atomic.LoadInt64(&r.count))
+}
+
+// snapshot returns the connections registered at the time each shard is visited,
+// callers work on the copy without holding any lock.
+func (r *clientRegistry) snapshot() []*clientConn {
+	conns := make([]*clientConn, 0, r.len())
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.RLock()
+		for _, cc := range s.clients {
+			conns = append(conns, cc)
+		}
+		s.RUnlock()
+	}
+	return conns
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func newRegistryWithClients(n int) *clientRegistry {
+	r := newClientRegistry()
+	for i := 0; i < n; i++ {
+		r.add(&clientConn{connectionID: uint64(i)})
+	}
+	return r
+}
+
+func TestClientRegistry(t *testing.T) {
+	r := newRegistryWithClients(100)
+	if r.len() != 100 || len(r.snapshot()) != 100 {
+		t.Fatalf("expect 100 clients, got %d %d", r.len(), len(r.snapshot()))
+	}
+	if n := r.add(&clientConn{connectionID: 1}); n != 100 {
+		t.Fatalf("re-adding a client should not change the count, got %d", n)
+	}
+	if _, ok := r.get(99); !ok {
+		t.Fatal("client 99 not found")
+	}
+	if n := r.remove(99); n != 99 {
+		t.Fatalf("expect 99 clients, got %d", n)
+	}
+	if n := r.remove(99); n != 99 {
+		t.Fatalf("removing twice should not change the count, got %d", n)
+	}
+	if _, ok := r.get(99); ok {
+		t.Fatal("client 99 should be removed")
+	}
+}
+
+// BenchmarkClientRegistryAddRemove measures connection churn while a monitor
+// keeps taking snapshots of 20000 connections.
+func BenchmarkClientRegistryAddRemove(b *testing.B) {
+	r := newRegistryWithClients(20000)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				r.snapshot()
+			}
+		}
+	}()
+
+	id := uint64(20000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cc := &clientConn{connectionID: atomic.AddUint64(&id, 1)}
+			r.add(cc)
+			r.remove(cc.connectionID)
+		}
+	})
+}
+
+func BenchmarkClientRegistrySnapshot(b *testing.B) {
+	r := newRegistryWithClients(20000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.snapshot()
+	}
+}
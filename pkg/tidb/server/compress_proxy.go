@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bufio"
+
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	proxymysql "github.com/pingcap/tidb/proxy/mysql"
+)
+
+const clientZstdCompression = proxymysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM
+
+// setCompression advertises protocol compression to clients and asks the backends for it.
+func (s *Server) setCompression(cfg *proxyconfig.CompressionConfig) {
+	if cfg.Enable {
+		s.capability |= mysql.ClientCompress | clientZstdCompression
+	}
+	backend.SetCompression(cfg.Backend, cfg.BackendLevel)
+}
+
+// enableCompression switches the client to compressed frames after the handshake OK,
+// zlib is taken when the client asks for both like mysql does.
+func (cc *clientConn) enableCompression() error {
+	var algorithm string
+	var level int
+	switch {
+	case cc.capability&mysql.ClientCompress > 0:
+		algorithm = proxymysql.CompressionZlib
+		level = cc.server.cfg.Proxycfg.Compression.ZlibLevel
+	case cc.capability&clientZstdCompression > 0:
+		algorithm, level = proxymysql.CompressionZstd, cc.zstdLevel
+	default:
+		return nil
+	}
+	compressed, err := proxymysql.NewCompressedConn(cc.bufReadConn, cc.bufReadConn, algorithm, level)
+	if err != nil {
+		return err
+	}
+	compressed.Observe = backend.CompressionObserver("client", algorithm)
+	cc.pkt.compressed = compressed
+	cc.pkt.reader = compressed
+	cc.pkt.bufWriter = bufio.NewWriterSize(compressed, defaultWriterSize)
+	return nil
+}
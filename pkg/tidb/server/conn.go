@@ -162,6 +162,8 @@ func newClientConn(s *Server) *clientConn {
 		status:       connStatusDispatching,
 		lastActive:   time.Now(),
 		authPlugin:   mysql.AuthNativePassword,
+		createTime:   time.Now(),
+		activeTime:   time.Now().UnixNano(),
 	}
 }
 
@@ -180,7 +182,16 @@ type clientConn struct {
 	alloc        arena.Allocator   // an memory allocator for reducing memory allocation.
 	lastPacket   []byte            // latest sql query string, currently used for logging error.
 	ctx          *TiDBContext      // an interface to execute sql statements.
-	attrs        map[string]string // attributes parsed from client handshake response, not used for now.
+	attrs        map[string]string // attributes parsed from client handshake response, forwarded to backends when forward_conn_attrs is set.
+	certAuthed   bool              // logged in by the client certificate, without password.
+	passthrough  *backend.Cluster  // cluster relaying the authentication, nil if the proxy authenticates.
+	tenant       *backend.Cluster  // tenant cluster the session is bound to by a passthrough login on it.
+	sha2         sha2State         // how the caching_sha2_password exchange checked the client.
+	app          string            // app label of the statement metrics, set by the first statement.
+	zstdLevel    int               // zstd level asked in the handshake response, 0 means the default.
+	trackedState sessionStates     // session state changes for the next OK packet, with CLIENT_SESSION_TRACK.
+	digestSQL    string            // statement of digest.
+	digest       string            // normalized digest of digestSQL, see stmtDigest.
 	peerHost     string            // peer host
 	peerPort     string            // peer port
 	status       int32             // dispatching/reading/shutdown/waitshutdown
@@ -190,7 +201,8 @@ type clientConn struct {
 	authPlugin   string            // default authentication plugin
 	isUnixSocket bool              // connection is Unix Socket file
 
-	// mu is used for cancelling the execution of current transaction.
+	// mu is used for cancelling the execution of current transaction. It also guards the
+	// writes of the fields other goroutines read after the handshake, see conn_shared_proxy.go.
 	mu struct {
 		sync.RWMutex
 		cancelFunc context.CancelFunc
@@ -200,6 +212,56 @@ type clientConn struct {
 	txConn *backend.BackendConn
 	curVersion uint64
 	prepareConn *backend.BackendConn
+	//cluster of the last backend conn, txConn and prepareConn belong to it
+	routedCluster *backend.Cluster
+	//connection accepted on the analytics listener, always use ap pool
+	forceAP bool
+	//for idle timeout and max lifetime, activeTime is accessed atomically
+	createTime time.Time
+	activeTime int64
+	//route all statements of a multi-statement batch to the pool of its first statement
+	pinBatch   bool
+	pinnedType string
+	//releases the turn of the running statement in the ap queue
+	apRelease func()
+	//cost of the plan of the running statement, before the estimator
+	planCost int64
+	//bytes of pkt already added to the user stats
+	userBytesIn  int64
+	userBytesOut int64
+	//set by the server when the backend of txConn is removed past the drain timeout,
+	//the transaction is rolled back while the client is idle and the next statement fails
+	removedRollback int32
+	//the proxy rolled back the transaction, the next statement fails with it
+	abortedTxn error
+	//set to 1 by the long transaction detector when the transaction is idle past the
+	//idle kill timeout, it is rolled back while the client is idle
+	idleTxnKill int32
+	//unix nano of the first command in the current transaction, 0 outside of one,
+	//accessed atomically
+	txnSince int64
+	//backend holding the temporary tables of the session, all statements go to it
+	tempConn   *backend.BackendConn
+	//backend session the tenant cluster authenticated the client on, see handleTenantStmt
+	tenantConn *backend.BackendConn
+	tempTables map[string]struct{}
+	//set to 1 when the backend of the idle session is released, accessed atomically
+	suspended int32
+	//user and client ip the connection is counted for by the connection limits,
+	//limitHeld is 1 while it is counted
+	limitUser string
+	limitHost string
+	limitHeld int32
+	//set once the session runs a set statement, its metadata selects skip the cache.
+	//metadataKey is the cache key of the metadata select missing the cache
+	varsChanged bool
+	metadataKey string
+	//accepted while pd is lost under the degrade policy, it only reads until pd is back
+	pdLossReadOnly bool
+	//why the handshake failed when the error doesn't tell, see authFailureReason
+	authFailure string
+	//backend and id of the statement whose backend failed mid-query, see error_context
+	errorContext *errorContext
 }
 
 func (cc *clientConn) GetCurVersion() uint64 {
@@ -275,6 +337,14 @@ func (cc *clientConn) handshake(ctx context.Context) error {
 		return err
 	}
 
+	if cc.rejectOnShutdown() {
+		err := errServerShutdown.GenWithStackByArgs()
+		if err1 := cc.writeError(ctx, err); err1 != nil {
+			logutil.Logger(ctx).Debug("writeError failed", zap.Error(err1))
+		}
+		return err
+	}
+
 	// MySQL supports an "init_connect" query, which can be run on initial connection.
 	// The query must return a non-error or the client is disconnected.
 	if err := cc.initConnect(ctx); err != nil {
@@ -293,6 +363,10 @@ func (cc *clientConn) handshake(ctx context.Context) error {
 		data = dumpUint16(data, mysql.ServerStatusAutocommit)
 		data = append(data, 0, 0)
 	}
+	if cc.tracksSession() {
+		//empty info
+		data = append(data, 0)
+	}
 
 	err := cc.writePacket(data)
 	cc.pkt.sequence = 0
@@ -308,19 +382,17 @@ func (cc *clientConn) handshake(ctx context.Context) error {
 		logutil.Logger(ctx).Debug("flush response to client failed", zap.Error(err))
 		return err
 	}
-	return err
+	return cc.enableCompression()
 }
 
 func (cc *clientConn) Close() error {
-	cc.server.rwlock.Lock()
-	delete(cc.server.clients, cc.connectionID)
-	connections := len(cc.server.clients)
-	cc.server.rwlock.Unlock()
+	connections := cc.server.clients.remove(cc.connectionID)
 	return closeConn(cc, connections)
 }
 
 func closeConn(cc *clientConn, connections int) error {
 	metrics.ConnGauge.Set(float64(connections))
+	cc.releaseConnLimit()
 	err := cc.bufReadConn.Close()
 	terror.Log(err)
 	if cc.ctx != nil {
@@ -330,8 +402,7 @@ func closeConn(cc *clientConn, connections int) error {
 }
 
 func (cc *clientConn) closeWithoutLock() error {
-	delete(cc.server.clients, cc.connectionID)
-	return closeConn(cc, len(cc.server.clients))
+	return closeConn(cc, cc.server.clients.remove(cc.connectionID))
 }
 
 // writeInitialHandshake sends server version, connection ID, server capability, collation, server status
@@ -435,6 +506,7 @@ type handshakeResponse41 struct {
 	Auth       []byte
 	AuthPlugin string
 	Attrs      map[string]string
+	ZstdLevel  uint8
 }
 
 // parseOldHandshakeResponseHeader parses the old version handshake header HandshakeResponse320
@@ -581,15 +653,20 @@ func parseHandshakeResponseBody(ctx context.Context, packet *handshakeResponse41
 		if num, null, off := parseLengthEncodedInt(data[offset:]); !null {
 			offset += off
 			row := data[offset : offset+int(num)]
+			offset += int(num)
 			attrs, err := parseAttrs(row)
 			if err != nil {
 				logutil.Logger(ctx).Warn("parse attrs failed", zap.Error(err))
-				return nil
+			} else {
+				packet.Attrs = attrs
 			}
-			packet.Attrs = attrs
 		}
 	}
 
+	if packet.Capability&clientZstdCompression > 0 && len(data[offset:]) > 0 {
+		packet.ZstdLevel = data[offset]
+	}
+
 	return nil
 }
 
@@ -654,6 +731,7 @@ func (cc *clientConn) readOptionalSSLRequestAndHandshakeResponse(ctx context.Con
 		if tlsConfig != nil {
 			// The packet is a SSLRequest, let's switch to TLS.
 			if err = cc.upgradeToTLS(tlsConfig); err != nil {
+				cc.authFailure = authFailTLS
 				return err
 			}
 			// Read the following HandshakeResponse packet.
@@ -694,6 +772,25 @@ func (cc *clientConn) readOptionalSSLRequestAndHandshakeResponse(ctx context.Con
 	cc.dbname = resp.DBName
 	cc.collation = resp.Collation
 	cc.attrs = resp.Attrs
+	cc.zstdLevel = int(resp.ZstdLevel)
+
+	if cc.certAuthed, err = cc.authByCert(); err != nil || cc.certAuthed {
+		if err == nil {
+			err = cc.openSessionAndDoAuth(nil)
+		}
+		if err != nil {
+			logutil.Logger(ctx).Warn("certificate authentication failure", zap.Error(err))
+		}
+		return err
+	}
+
+	if cc.passthrough = cc.passthroughCluster(); cc.passthrough != nil {
+		err = cc.openSessionAndDoAuth(nil)
+		if err != nil {
+			logutil.Logger(ctx).Warn("passthrough authentication failure", zap.Error(err))
+		}
+		return err
+	}
 
 	newAuth, err := cc.checkAuthPlugin(ctx, &resp.AuthPlugin)
 	if err != nil {
@@ -705,7 +802,7 @@ func (cc *clientConn) readOptionalSSLRequestAndHandshakeResponse(ctx context.Con
 
 	switch resp.AuthPlugin {
 	case mysql.AuthCachingSha2Password:
-		resp.Auth, err = cc.authSha(ctx)
+		resp.Auth, err = cc.authSha(ctx, resp.Auth)
 		if err != nil {
 			return err
 		}
@@ -721,34 +818,6 @@ func (cc *clientConn) readOptionalSSLRequestAndHandshakeResponse(ctx context.Con
 	return err
 }
 
-func (cc *clientConn) authSha(ctx context.Context) ([]byte, error) {
-
-	const (
-		ShaCommand       = 1
-		RequestRsaPubKey = 2
-		FastAuthOk       = 3
-		FastAuthFail     = 4
-	)
-
-	err := cc.writePacket([]byte{0, 0, 0, 0, ShaCommand, FastAuthFail})
-	if err != nil {
-		logutil.Logger(ctx).Error("authSha packet write failed", zap.Error(err))
-		return nil, err
-	}
-	err = cc.flush(ctx)
-	if err != nil {
-		logutil.Logger(ctx).Error("authSha packet flush failed", zap.Error(err))
-		return nil, err
-	}
-
-	data, err := cc.readPacket()
-	if err != nil {
-		logutil.Logger(ctx).Error("authSha packet read failed", zap.Error(err))
-		return nil, err
-	}
-	return bytes.Trim(data, "\x00"), nil
-}
-
 func (cc *clientConn) SessionStatusToString() string {
 	status := cc.ctx.Status()
 	inTxn, autoCommit := 0, 0
@@ -769,8 +838,8 @@ func (cc *clientConn) openSession() error {
 		tlsState := cc.tlsConn.ConnectionState()
 		tlsStatePtr = &tlsState
 	}
-	var err error
-	cc.ctx, err = cc.server.driver.OpenCtx(cc.connectionID, cc.capability, cc.collation, cc.dbname, tlsStatePtr)
+	tctx, err := cc.server.driver.OpenCtx(cc.connectionID, cc.capability, cc.collation, cc.dbname, tlsStatePtr)
+	cc.setCtx(tctx)
 	if err != nil {
 		return err
 	}
@@ -792,19 +861,40 @@ func (cc *clientConn) openSessionAndDoAuth(authData []byte) error {
 	}
 
 	hasPassword := "YES"
-	if len(authData) == 0 {
+	if len(authData) == 0 && cc.passthrough == nil && cc.sha2 == sha2None {
 		hasPassword = "NO"
 	}
 	host, port, err := cc.PeerHost(hasPassword)
 	if err != nil {
 		return err
 	}
-	if !cc.ctx.Auth(&auth.UserIdentity{Username: cc.user, Hostname: host}, authData, cc.salt) {
+	if err = cc.checkAuthThrottle(host); err != nil {
+		return err
+	}
+	var authed bool
+	if cc.certAuthed {
+		authed = cc.ctx.AuthWithoutVerification(&auth.UserIdentity{Username: cc.user, Hostname: host})
+	} else if cc.passthrough != nil {
+		//a backend that can't tell doesn't count as a failed auth of the client
+		if authed, err = cc.loginPassthrough(host); err != nil {
+			return err
+		}
+	} else if cc.sha2 != sha2None {
+		authed = cc.authSha2(host, authData)
+	} else {
+		authed = cc.ctx.Auth(&auth.UserIdentity{Username: cc.user, Hostname: host}, authData, cc.salt)
+	}
+	cc.observeAuth(host, authed)
+	if !authed {
+		cc.authFailure = cc.accessDeniedReason(host)
 		return errAccessDenied.FastGenByArgs(cc.user, host, hasPassword)
 	}
+	if err = cc.acquireConnLimit(host); err != nil {
+		return err
+	}
 	cc.ctx.SetPort(port)
 	if cc.dbname != "" {
-		err = cc.useDB(context.Background(), cc.dbname)
+		err = cc.useConnectDB(context.Background(), cc.dbname)
 		if err != nil {
 			return err
 		}
@@ -936,8 +1026,10 @@ func (cc*clientConn) ReleasePrepare(ctx context.Context) {
 	} else if cc.prepareConn != nil && !cc.prepareConn.IsProxySelf() {
 		cc.prepareConn.Close()
 	}
-	cc.txConn = nil
-	cc.prepareConn = nil
+	cc.setTxConn(nil)
+	cc.setPrepareConn(nil)
+	cc.releaseTempTables()
+	cc.releaseTenantConn()
 }
 // Run reads client query and writes query result to client in for loop, if there is a panic during query handling,
 // it will be recovered and log the panic error.
@@ -975,7 +1067,35 @@ func (cc *clientConn) Run(ctx context.Context) {
 	go func() {
 		for {
 			time.Sleep(time.Second)
-			cluster := cc.server.cluster
+			if block == true && atomic.LoadInt32(&cc.removedRollback) == 1 {
+				msg, ok := <-done
+				if !ok {
+					cc.ReleasePrepare(ctx)
+					return
+				}
+				cc.rollbackRemovedTxn()
+				done <- msg
+			}
+			if block == true && atomic.LoadInt32(&cc.idleTxnKill) == 1 {
+				msg, ok := <-done
+				if !ok {
+					cc.ReleasePrepare(ctx)
+					return
+				}
+				cc.killIdleTxn()
+				done <- msg
+			}
+			if suspend := cc.suspendAfter(); suspend > 0 && block == true &&
+				time.Since(start) > suspend && cc.canSuspend() {
+				msg, ok := <-done
+				if !ok {
+					cc.ReleasePrepare(ctx)
+					return
+				}
+				cc.suspendIdle()
+				done <- msg
+			}
+			cluster := cc.cluster()
 			if pool,ok := cluster.BackendPools[backend.TiDBForTP];ok {
 				if block == true && time.Since(start).Seconds() > 3.0 {
 					if cc.curVersion == pool.CurVersion || cc.prepareConn == nil {
@@ -999,7 +1119,7 @@ func (cc *clientConn) Run(ctx context.Context) {
 						if cc.prepareConn != nil && !cc.prepareConn.IsProxySelf() {
 							cc.prepareConn.Close()
 						}
-						cc.prepareConn = nil
+						cc.setPrepareConn(nil)
 						done <- msg
 					}
 				}
@@ -1060,7 +1180,10 @@ func (cc *clientConn) Run(ctx context.Context) {
 		}
 
 		startTime := time.Now()
-		if err = cc.dispatch(ctx, data); err != nil {
+		if err = cc.abortedTxnError(data); err == nil {
+			err = cc.dispatch(ctx, data)
+		}
+		if err != nil {
 			if terror.ErrorEqual(err, io.EOF) {
 				cc.addMetrics(data[0], startTime, nil)
 				disconnectNormal.Inc()
@@ -1089,6 +1212,7 @@ func (cc *clientConn) Run(ctx context.Context) {
 			terror.Log(err1)
 		}
 		cc.addMetrics(data[0], startTime, err)
+		cc.addUserBytes()
 		cc.pkt.sequence = 0
 	}
 }
@@ -1194,12 +1318,19 @@ func (cc *clientConn) addMetrics(cmd byte, startTime time.Time, err error) {
 // It also gets a token from server which is used to limit the concurrently handling clients.
 // The most frequently used command is ComQuery.
 func (cc *clientConn) dispatch(ctx context.Context, data []byte) error {
+	if handled, err := cc.handleLocalProbe(ctx, data); handled {
+		return err
+	}
 	cc.server.counter.IncrClientQPS()
+	cc.errorContext = nil
 	defer func() {
 		// reset killed for each request
 		atomic.StoreUint32(&cc.ctx.GetSessionVars().Killed, 0)
 	}()
 	t := time.Now()
+	defer func() {
+		cc.server.counter.AddClientLatency(time.Since(t))
+	}()
 	if (cc.ctx.Status() & mysql.ServerStatusInTrans) > 0 {
 		connIdleDurationHistogramInTxn.Observe(t.Sub(cc.lastActive).Seconds())
 	} else {
@@ -1248,7 +1379,7 @@ func (cc *clientConn) dispatch(ctx context.Context, data []byte) error {
 			pprof.SetGoroutineLabels(ctx)
 		}
 	}
-	token := cc.server.getToken()
+	token := cc.getTokenForProxy(cmd, data)
 	defer func() {
 		// if handleChangeUser failed, cc.ctx may be nil
 		if cc.ctx != nil {
@@ -1257,7 +1388,9 @@ func (cc *clientConn) dispatch(ctx context.Context, data []byte) error {
 
 		cc.server.releaseToken(token)
 		span.Finish()
+		cc.trackTxn(t)
 		cc.lastActive = time.Now()
+		atomic.StoreInt64(&cc.activeTime, cc.lastActive.UnixNano())
 	}()
 
 	vars := cc.ctx.GetSessionVars()
@@ -1357,6 +1490,9 @@ func (cc *clientConn) writeStats(ctx context.Context) error {
 }
 
 func (cc *clientConn) useDB(ctx context.Context, db string) (err error) {
+	if cc.tenantConn != nil {
+		return cc.useTenantDB(db)
+	}
 	// if input is "use `SELECT`", mysql client just send "SELECT"
 	// so we add `` around db.
 	stmts, err := cc.ctx.Parse(ctx, "use `"+db+"`")
@@ -1367,7 +1503,8 @@ func (cc *clientConn) useDB(ctx context.Context, db string) (err error) {
 	if err != nil {
 		return err
 	}
-	cc.dbname = db
+	cc.setDBName(db)
+	cc.trackSchema()
 	return
 }
 
@@ -1403,7 +1540,14 @@ func (cc *clientConn) writeOkWith(ctx context.Context, msg string, affectedRows,
 		enclen = lengthEncodedIntSize(uint64(len(msg))) + len(msg)
 	}
 
-	data := cc.alloc.AllocWithLen(4, 32+enclen)
+	var states []byte
+	if cc.tracksSession() {
+		if states = cc.trackedState.take(); len(states) > 0 {
+			status |= serverSessionStateChanged
+		}
+	}
+
+	data := cc.alloc.AllocWithLen(4, 32+enclen+len(states))
 	data = append(data, mysql.OKHeader)
 	data = dumpLengthEncodedInt(data, affectedRows)
 	data = dumpLengthEncodedInt(data, lastInsertID)
@@ -1411,7 +1555,13 @@ func (cc *clientConn) writeOkWith(ctx context.Context, msg string, affectedRows,
 		data = dumpUint16(data, status)
 		data = dumpUint16(data, warnCnt)
 	}
-	if enclen > 0 {
+	if cc.tracksSession() {
+		//a client tracking the session reads the info even if empty
+		data = dumpLengthEncodedString(data, []byte(msg))
+		if len(states) > 0 {
+			data = dumpLengthEncodedString(data, states)
+		}
+	} else if enclen > 0 {
 		// although MySQL manual says the info message is string<EOF>(https://dev.mysql.com/doc/internals/en/packet-OK_Packet.html),
 		// it is actually string<lenenc>
 		data = dumpLengthEncodedString(data, []byte(msg))
@@ -1440,9 +1590,12 @@ func (cc *clientConn) writeError(ctx context.Context, e error) error {
 		case *terror.Error:
 			m = terror.ToSQLError(y)
 		default:
-			m = mysql.NewErrf(mysql.ErrUnknown, "%s", nil, e.Error())
+			if m = cc.proxySQLError(e); m == nil {
+				m = mysql.NewErrf(mysql.ErrUnknown, "%s", nil, e.Error())
+			}
 		}
 	}
+	m = cc.withErrorContext(m)
 
 	cc.lastCode = m.Code
 	defer errno.IncrementError(m.Code, cc.user, cc.peerHost)
@@ -1711,6 +1864,9 @@ func (cc *clientConn) handleIndexAdvise(ctx context.Context, indexAdviseInfo *ex
 // Query `load stats` does not return result either.
 func (cc *clientConn) handleQuery(ctx context.Context, sql string) (err error) {
 	defer trace.StartRegion(ctx, "handleQuery").End()
+	if handled, err := cc.handleAdminForProxy(ctx, sql); handled {
+		return err
+	}
 	sc := cc.ctx.GetSessionVars().StmtCtx
 
 	prevWarns := sc.GetWarnings()
@@ -1767,6 +1923,13 @@ func (cc *clientConn) handleQuery(ctx context.Context, sql string) (err error) {
 	if len(pointPlans) > 0 {
 		defer cc.ctx.ClearValue(plannercore.PointPlanKey)
 	}
+	if len(stmts) > 1 && cc.server.cfg.Proxycfg.DisableMultiStmtSplit {
+		cc.pinBatch = true
+		defer func() {
+			cc.pinBatch = false
+			cc.pinnedType = ""
+		}()
+	}
 	var retryable bool
 	for i, stmt := range stmts {
 		if len(pointPlans) > 0 {
@@ -1918,7 +2081,7 @@ func (cc *clientConn) handleSetAutoCommit(val ast.ExprNode) error {
 					co.SetNoDelayFlase()
 					co.Close()
 				}
-				cc.txConn = nil
+				cc.setTxConn(nil)
 				return fmt.Errorf("set autocommit error, %v", e)
 			}
 			if cc.isPrepare() == false {
@@ -1926,7 +2089,7 @@ func (cc *clientConn) handleSetAutoCommit(val ast.ExprNode) error {
 				co.Close()
 			}
 		}
-		cc.txConn = nil
+		cc.setTxConn(nil)
 	}
 	return nil
 }
@@ -1957,22 +2120,46 @@ func (cc *clientConn) handleStmt(ctx context.Context, stmt ast.StmtNode, warns [
 	var err error
 	sctx := cc.ctx
 
-	cc.ctx.GetSessionVars().Proxy.SQLtext = stmt.Text()
+	cc.setSQLText(stmt.Text())
 	defer func() {
-		cc.ctx.GetSessionVars().Proxy.SQLtext=""
+		cc.setSQLText("")
 	}()
-	stmtcost, err := cc.ctx.GotStmtCostForProxy(ctx, stmt)
+	if cluster := cc.cluster(); isTenantCluster(cluster) {
+		return false, cc.handleTenantStmt(ctx, cluster, stmt, lastStmt)
+	}
+	//the optimizer only sets the cost while it is 0
+	cc.ctx.GetSessionVars().Proxy.Cost = 0
+	stmtcost, err := cc.stmtCost(ctx, stmt)
 	if err != nil {
 		fmt.Errorf("get cost err is %s\n", err)
 		return false, err
 	}
+	cc.estimateCost()
 	//fmt.Printf("new sql is %s,cost is %f \n",stmt.Text(),cc.ctx.GetSessionVars().Proxy.Cost)
 	switch stmt.(type) {
 	case *ast.BeginStmt:
 		//fmt.Println("========handleStmt begin1=========",cc.txConn,cc.prepareConn)
 		cc.ctx.GetSessionVars().SetInTxn(true)
+	case *ast.SetStmt:
+		cc.varsChanged = true
+	}
+	if err = cc.checkWrites(stmt); err != nil {
+		return false, err
+	}
+	if sctx.GetSessionVars().Proxy.Userquery {
+		if hit, err := cc.writeCachedMetadata(ctx, stmt, lastStmt); hit {
+			return false, err
+		}
+		if handled, err := cc.writeSchemaListing(ctx, stmt, lastStmt); handled {
+			return false, err
+		}
 	}
-	conn, err := cc.getBackendConn(cc.server.cluster,cc.ctx.GetSessionVars().InTxn()||!cc.ctx.GetSessionVars().IsAutocommit())
+	if cc.usesFreshConn(stmt) {
+		if handled, err := cc.handleFreshConnStmt(ctx, stmt, lastStmt); handled {
+			return false, err
+		}
+	}
+	conn, err := cc.getBackendConn(cc.cluster(),cc.ctx.GetSessionVars().InTxn()||!cc.ctx.GetSessionVars().IsAutocommit())
 	if err != nil {
 		fmt.Errorf("get backend conn failed: %s\n", err)
 		return false, err
@@ -1995,11 +2182,22 @@ func (cc *clientConn) handleStmt(ctx context.Context, stmt ast.StmtNode, warns [
 		}
 	}
 	//
-	if sctx.GetSessionVars().Proxy.Userquery&& !conn.IsProxySelf() {
+	if sctx.GetSessionVars().Proxy.Userquery&& !conn.IsProxySelf() && !cc.readsProxyState(stmt) {
 		switch stmt.(type) {
 		case *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt, *ast.SelectStmt:
-			err := cc.handleDMLForProxy(ctx, conn, stmt)
+			err := cc.handleDMLForProxy(ctx, conn, stmt, lastStmt)
+			return false, err
+		case *ast.LoadDataStmt:
+			err := cc.handleLoadDataForProxy(ctx, conn, stmt.(*ast.LoadDataStmt), lastStmt)
 			return false, err
+		case *ast.CreateTableStmt, *ast.DropTableStmt:
+			if cc.isTempTableStmt(stmt) {
+				err := cc.handleDMLForProxy(ctx, conn, stmt, lastStmt)
+				if err == nil {
+					cc.trackTempTables(conn, stmt)
+				}
+				return false, err
+			}
 		}
 	}
 
@@ -2012,6 +2210,16 @@ func (cc *clientConn) handleStmt(ctx context.Context, stmt ast.StmtNode, warns [
 	       	return false,err
 	   	}
 	*/
+	if stmtcost == nil {
+		//routed by the cached cost, the proxy runs the statement itself
+		if stmtcost, err = cc.ctx.GotStmtCostForProxy(ctx, stmt); err != nil {
+			return false, err
+		}
+	}
+	//a self conn runs the statement while writing its result set, so it is timed till return
+	if conn.IsProxySelf() {
+		defer cc.observeOrigin(conn, time.Now())
+	}
 	rs, err := cc.ctx.ExecStmtForProxy(ctx, stmtcost)
 
 	reg.End()
@@ -2023,6 +2231,12 @@ func (cc *clientConn) handleStmt(ctx context.Context, stmt ast.StmtNode, warns [
 	if err != nil {
 		return true, err
 	}
+	if conn.IsProxySelf() {
+		cc.appendRoutingNote(conn)
+		if cc.isTempTableStmt(stmt) {
+			cc.trackTempTables(conn, stmt)
+		}
+	}
 
 	switch stmt.(type) {
 	case *ast.CommitStmt:
@@ -2031,6 +2245,9 @@ func (cc *clientConn) handleStmt(ctx context.Context, stmt ast.StmtNode, warns [
 		cc.rollbackInProxy()
 	case *ast.SetStmt:
 		cc.handleSet(stmt.(*ast.SetStmt),stmt.Text())
+		cc.trackSet(stmt.(*ast.SetStmt))
+	case *ast.UseStmt:
+		cc.trackSchema()
 	}
 
 	if lastStmt {
@@ -2347,7 +2564,7 @@ func (cc *clientConn) upgradeToTLS(tlsConfig *tls.Config) error {
 
 func (cc *clientConn) handleChangeUser(ctx context.Context, data []byte) error {
 	user, data := parseNullTermString(data)
-	cc.user = string(hack.String(user))
+	cc.setUser(string(hack.String(user)))
 	if len(data) < 1 {
 		return mysql.ErrMalformPacket
 	}
@@ -2359,7 +2576,7 @@ func (cc *clientConn) handleChangeUser(ctx context.Context, data []byte) error {
 	pass := data[:passLen]
 	data = data[passLen:]
 	dbName, _ := parseNullTermString(data)
-	cc.dbname = string(hack.String(dbName))
+	cc.setDBName(string(hack.String(dbName)))
 
 	err := cc.ctx.Close()
 	if err != nil {
@@ -2374,6 +2591,7 @@ func (cc *clientConn) handleChangeUser(ctx context.Context, data []byte) error {
 
 func (cc *clientConn) handleResetConnection(ctx context.Context) error {
 	user := cc.ctx.GetSessionVars().User
+	cc.trackedState = sessionStates{}
 	err := cc.ctx.Close()
 	if err != nil {
 		logutil.Logger(ctx).Debug("close old context failed", zap.Error(err))
@@ -2383,7 +2601,8 @@ func (cc *clientConn) handleResetConnection(ctx context.Context) error {
 		tlsState := cc.tlsConn.ConnectionState()
 		tlsStatePtr = &tlsState
 	}
-	cc.ctx, err = cc.server.driver.OpenCtx(cc.connectionID, cc.capability, cc.collation, cc.dbname, tlsStatePtr)
+	tctx, err := cc.server.driver.OpenCtx(cc.connectionID, cc.capability, cc.collation, cc.dbname, tlsStatePtr)
+	cc.setCtx(tctx)
 	if err != nil {
 		return err
 	}
@@ -2436,7 +2655,7 @@ func (cc *clientConn) handleRefresh(ctx context.Context, subCommand byte) error
 func (cc *clientConn) clean() {
 	if cc.txConn!=nil{
 		cc.txConn.Close()
-		cc.txConn=nil
+		cc.setTxConn(nil)
 	}
 }
 
@@ -0,0 +1,439 @@
+package server
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	parsermysql "github.com/pingcap/parser/mysql"
+	plannercore "github.com/pingcap/tidb/planner/core"
+	"github.com/pingcap/tidb/privilege"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/maintenance"
+	"github.com/pingcap/tidb/proxy/mysql"
+	"github.com/pingcap/tidb/proxy/rewrite"
+)
+
+// admin statements served by the proxy itself, the parser doesn't know them.
+var (
+	adminShowBackendsRegexp  = regexp.MustCompile(`(?i)^\s*admin\s+show\s+proxy\s+backends\s*;?\s*$`)
+	adminShowRewritesRegexp  = regexp.MustCompile(`(?i)^\s*admin\s+show\s+proxy\s+rewrites\s*;?\s*$`)
+	adminAddRewriteRegexp    = regexp.MustCompile(`(?is)^\s*admin\s+add\s+proxy\s+rewrite\s+(.*?)\s*;?\s*$`)
+	adminDeleteRewriteRegexp = regexp.MustCompile(`(?i)^\s*admin\s+delete\s+proxy\s+rewrite\s+(\d+)\s*;?\s*$`)
+	adminShowPinsRegexp      = regexp.MustCompile(`(?i)^\s*admin\s+show\s+proxy\s+pins\s*;?\s*$`)
+	adminAddPinRegexp        = regexp.MustCompile(`(?is)^\s*admin\s+add\s+proxy\s+pin\s+(.*?)\s*;?\s*$`)
+	adminDeletePinRegexp     = regexp.MustCompile(`(?i)^\s*admin\s+delete\s+proxy\s+pin\s+(\d+)\s*;?\s*$`)
+	adminShowPoolsRegexp     = regexp.MustCompile(`(?i)^\s*admin\s+show\s+proxy\s+pools\s*;?\s*$`)
+	// ADMIN CORDON|UNCORDON PROXY POOL tp|ap, ADMIN DRAIN PROXY POOL tp|ap [TIMEOUT seconds]
+	adminCordonPoolRegexp     = regexp.MustCompile(`(?i)^\s*admin\s+(cordon|uncordon)\s+proxy\s+pool\s+(tp|ap)\s*;?\s*$`)
+	adminDrainPoolRegexp      = regexp.MustCompile(`(?i)^\s*admin\s+drain\s+proxy\s+pool\s+(tp|ap)(?:\s+timeout\s+(\d+))?\s*;?\s*$`)
+	adminShowConnLimitsRegexp = regexp.MustCompile(`(?i)^\s*admin\s+show\s+proxy\s+connection\s+limits\s*;?\s*$`)
+	// ADMIN SET PROXY CONNECTION LIMIT USER|HOST 'key'|* n|DEFAULT
+	adminSetConnLimitRegexp = regexp.MustCompile(`(?i)^\s*admin\s+set\s+proxy\s+connection\s+limit\s+(user|host)\s+(\*|'[^']*')\s+(\d+|default)\s*;?\s*$`)
+	// ADMIN ADD PROXY MAINTENANCE WINDOW pool='tp' cron='0 2 * * 6' duration='7200'
+	adminShowWindowsRegexp  = regexp.MustCompile(`(?i)^\s*admin\s+show\s+proxy\s+maintenance\s+windows\s*;?\s*$`)
+	adminAddWindowRegexp    = regexp.MustCompile(`(?is)^\s*admin\s+add\s+proxy\s+maintenance\s+window\s+(.*?)\s*;?\s*$`)
+	adminDeleteWindowRegexp = regexp.MustCompile(`(?i)^\s*admin\s+delete\s+proxy\s+maintenance\s+window\s+(\d+)\s*;?\s*$`)
+	// ADMIN SET PROXY READ ONLY ON|OFF [REASON 'text'], ADMIN ADD|DELETE PROXY READ ONLY EXEMPTION 'user'
+	adminShowReadOnlyRegexp   = regexp.MustCompile(`(?i)^\s*admin\s+show\s+proxy\s+read\s+only\s*;?\s*$`)
+	adminSetReadOnlyRegexp    = regexp.MustCompile(`(?is)^\s*admin\s+set\s+proxy\s+read\s+only\s+(on|off)(?:\s+reason\s+'((?:[^'\\]|\\.|'')*)')?\s*;?\s*$`)
+	adminExemptReadOnlyRegexp = regexp.MustCompile(`(?i)^\s*admin\s+(add|delete)\s+proxy\s+read\s+only\s+exemption\s+'([^']*)'\s*;?\s*$`)
+	// key = 'value' of ADMIN ADD PROXY REWRITE, quotes in the value are doubled or escaped
+	adminRewriteArgRegexp = regexp.MustCompile(`(?is)(\w+)\s*=\s*'((?:[^'\\]|\\.|'')*)'\s*,?\s*`)
+)
+
+var (
+	adminShowBackendsColumns   = []string{"pool", "address", "state", "version", "weight", "using_conns"}
+	adminShowRewritesColumns   = []string{"id", "digest", "user", "class", "match", "replace", "hits"}
+	adminShowPoolsColumns      = []string{"pool", "cordoned", "tidbs", "using_conns"}
+	adminShowPinsColumns       = []string{"id", "digest", "user", "pool", "hint", "hits"}
+	adminShowConnLimitsColumns = []string{"type", "key", "limit", "connections", "peer_connections"}
+	adminShowWindowsColumns    = []string{"id", "pool", "cron", "duration", "source", "active"}
+	adminShowReadOnlyColumns   = []string{"enabled", "reason", "since", "exempt_users"}
+)
+
+// handleAdminForProxy serves proxy admin statements, it returns false if sql is not one of them.
+func (cc *clientConn) handleAdminForProxy(ctx context.Context, sql string) (bool, error) {
+	if cc.server == nil || cc.server.cluster == nil {
+		return false, nil
+	}
+	switch {
+	case adminShowBackendsRegexp.MatchString(sql):
+		return true, cc.handleShowProxyBackends(ctx)
+	case adminShowRewritesRegexp.MatchString(sql):
+		return true, cc.handleShowProxyRewrites(ctx)
+	case adminAddRewriteRegexp.MatchString(sql):
+		return true, cc.handleAddProxyRewrite(ctx, adminAddRewriteRegexp.FindStringSubmatch(sql)[1])
+	case adminDeleteRewriteRegexp.MatchString(sql):
+		return true, cc.handleDeleteProxyRewrite(ctx, adminDeleteRewriteRegexp.FindStringSubmatch(sql)[1])
+	case adminShowPinsRegexp.MatchString(sql):
+		return true, cc.handleShowProxyPins(ctx)
+	case adminAddPinRegexp.MatchString(sql):
+		return true, cc.handleAddProxyPin(ctx, adminAddPinRegexp.FindStringSubmatch(sql)[1])
+	case adminDeletePinRegexp.MatchString(sql):
+		return true, cc.handleDeleteProxyPin(ctx, adminDeletePinRegexp.FindStringSubmatch(sql)[1])
+	case adminShowConnLimitsRegexp.MatchString(sql):
+		return true, cc.handleShowProxyConnLimits(ctx)
+	case adminSetConnLimitRegexp.MatchString(sql):
+		m := adminSetConnLimitRegexp.FindStringSubmatch(sql)
+		return true, cc.handleSetProxyConnLimit(ctx, strings.ToLower(m[1]), strings.Trim(m[2], "'"), strings.ToLower(m[3]))
+	case adminShowWindowsRegexp.MatchString(sql):
+		return true, cc.handleShowProxyWindows(ctx)
+	case adminAddWindowRegexp.MatchString(sql):
+		return true, cc.handleAddProxyWindow(ctx, adminAddWindowRegexp.FindStringSubmatch(sql)[1])
+	case adminDeleteWindowRegexp.MatchString(sql):
+		return true, cc.handleDeleteProxyWindow(ctx, adminDeleteWindowRegexp.FindStringSubmatch(sql)[1])
+	case adminShowReadOnlyRegexp.MatchString(sql):
+		return true, cc.handleShowProxyReadOnly(ctx)
+	case adminSetReadOnlyRegexp.MatchString(sql):
+		m := adminSetReadOnlyRegexp.FindStringSubmatch(sql)
+		return true, cc.handleSetProxyReadOnly(ctx, strings.EqualFold(m[1], "on"), adminArgValue(m[2]))
+	case adminExemptReadOnlyRegexp.MatchString(sql):
+		m := adminExemptReadOnlyRegexp.FindStringSubmatch(sql)
+		return true, cc.handleExemptProxyReadOnly(ctx, strings.EqualFold(m[1], "add"), m[2])
+	case adminShowPoolsRegexp.MatchString(sql):
+		return true, cc.handleShowProxyPools(ctx)
+	case explainRouteRegexp.MatchString(sql):
+		return true, cc.handleExplainRoute(ctx, explainRouteRegexp.FindStringSubmatch(sql)[1])
+	case adminCordonPoolRegexp.MatchString(sql):
+		m := adminCordonPoolRegexp.FindStringSubmatch(sql)
+		return true, cc.handleCordonProxyPool(ctx, strings.ToLower(m[1]), strings.ToLower(m[2]))
+	case adminDrainPoolRegexp.MatchString(sql):
+		m := adminDrainPoolRegexp.FindStringSubmatch(sql)
+		return true, cc.handleDrainProxyPool(ctx, strings.ToLower(m[1]), m[2])
+	}
+	return false, nil
+}
+
+// checkSuperForProxy requires SUPER for admin statements changing the proxy.
+func (cc *clientConn) checkSuperForProxy() error {
+	pm := privilege.GetPrivilegeManager(cc.ctx.Session)
+	if pm != nil && !pm.RequestVerification(cc.ctx.GetSessionVars().ActiveRoles, "", "", "", parsermysql.SuperPriv) {
+		return plannercore.ErrSpecificAccessDenied.GenWithStackByArgs("SUPER")
+	}
+	return nil
+}
+
+// checkProcessForProxy requires PROCESS or SUPER for admin statements showing the state of
+// the proxy, as its information_schema tables do.
+func (cc *clientConn) checkProcessForProxy() error {
+	pm := privilege.GetPrivilegeManager(cc.ctx.Session)
+	if pm == nil {
+		return nil
+	}
+	roles := cc.ctx.GetSessionVars().ActiveRoles
+	if !pm.RequestVerification(roles, "", "", "", parsermysql.ProcessPriv) &&
+		!pm.RequestVerification(roles, "", "", "", parsermysql.SuperPriv) {
+		return plannercore.ErrSpecificAccessDenied.GenWithStackByArgs("PROCESS")
+	}
+	return nil
+}
+
+func (cc *clientConn) handleShowProxyBackends(ctx context.Context) error {
+	if err := cc.checkProcessForProxy(); err != nil {
+		return err
+	}
+	var values [][]interface{}
+	for _, ty := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+		pool, ok := cc.server.cluster.BackendPools[ty]
+		if !ok {
+			continue
+		}
+		pool.RLock()
+		for i, db := range pool.Tidbs {
+			_, _, _, _, usingConns, _ := db.ConnCount()
+			var weight float64
+			if i < len(pool.TidbsWeights) {
+				weight = pool.TidbsWeights[i]
+			}
+			values = append(values, []interface{}{ty, db.Addr(), db.State(), db.Version(), weight, usingConns})
+		}
+		pool.RUnlock()
+	}
+
+	return cc.writeAdminResultset(ctx, adminShowBackendsColumns, values)
+}
+
+// writeAdminResultset writes the rows, the fields are built from the names when there are none.
+func (cc *clientConn) writeAdminResultset(ctx context.Context, columns []string, values [][]interface{}) error {
+	var r *mysql.Resultset
+	var err error
+	if len(values) == 0 {
+		r = &mysql.Resultset{Fields: make([]*mysql.Field, len(columns))}
+		for i, name := range columns {
+			r.Fields[i] = &mysql.Field{Name: []byte(name), Charset: 33, Type: mysql.MYSQL_TYPE_VAR_STRING}
+		}
+	} else if r, err = cc.buildResultset(nil, columns, values); err != nil {
+		return err
+	}
+	return cc.writeResultsetForProxy(ctx, r, cc.ctx.Status())
+}
+
+func (cc *clientConn) handleShowProxyRewrites(ctx context.Context) error {
+	if err := cc.checkProcessForProxy(); err != nil {
+		return err
+	}
+	var values [][]interface{}
+	for _, r := range rewrite.DefaultEngine().Rules() {
+		values = append(values, []interface{}{r.ID, r.Digest, r.User, r.Class, r.Match, r.Replace, r.Hits})
+	}
+	return cc.writeAdminResultset(ctx, adminShowRewritesColumns, values)
+}
+
+// handleAddProxyRewrite adds a rule from digest='..' user='..' class='..' match='..' replace='..',
+// the rule id is returned as the last insert id.
+func (cc *clientConn) handleAddProxyRewrite(ctx context.Context, args string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	var rule rewrite.Rule
+	for _, m := range adminRewriteArgRegexp.FindAllStringSubmatch(args, -1) {
+		value := adminArgValue(m[2])
+		switch strings.ToLower(m[1]) {
+		case "digest":
+			rule.Digest = value
+		case "user":
+			rule.User = value
+		case "class":
+			rule.Class = strings.ToLower(value)
+		case "match":
+			rule.Match = value
+		case "replace":
+			rule.Replace = value
+		default:
+			return errors.ErrInvalidArgument
+		}
+	}
+	id, err := rewrite.DefaultEngine().Add(rule)
+	if err != nil {
+		return err
+	}
+	publishConfigChange("rewrite_rule", "add", map[string]interface{}{"id": id, "user": cc.user})
+	return cc.writeOkWith(ctx, "", 0, uint64(id), cc.ctx.Status(), 0)
+}
+
+func (cc *clientConn) handleDeleteProxyRewrite(ctx context.Context, arg string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err = rewrite.DefaultEngine().Delete(id); err != nil {
+		return err
+	}
+	publishConfigChange("rewrite_rule", "delete", map[string]interface{}{"id": id, "user": cc.user})
+	return cc.writeOkWith(ctx, "", 1, 0, cc.ctx.Status(), 0)
+}
+
+// adminArgValue unquotes a value of key = 'value' arguments.
+func adminArgValue(quoted string) string {
+	value := strings.ReplaceAll(quoted, "''", "'")
+	return strings.NewReplacer(`\'`, "'", `\\`, `\`).Replace(value)
+}
+
+func (cc *clientConn) handleShowProxyPins(ctx context.Context) error {
+	if err := cc.checkProcessForProxy(); err != nil {
+		return err
+	}
+	var values [][]interface{}
+	for _, p := range rewrite.DefaultEngine().Pins() {
+		values = append(values, []interface{}{p.ID, p.Digest, p.User, p.Pool, p.Hint, p.Hits})
+	}
+	return cc.writeAdminResultset(ctx, adminShowPinsColumns, values)
+}
+
+// handleAddProxyPin pins a digest from digest='..' pool='tp|ap' hint='..' user='..', the
+// pin id is returned as the last insert id.
+func (cc *clientConn) handleAddProxyPin(ctx context.Context, args string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	var pin rewrite.Pin
+	for _, m := range adminRewriteArgRegexp.FindAllStringSubmatch(args, -1) {
+		value := adminArgValue(m[2])
+		switch strings.ToLower(m[1]) {
+		case "digest":
+			pin.Digest = value
+		case "user":
+			pin.User = value
+		case "pool":
+			pin.Pool = value
+		case "hint":
+			pin.Hint = value
+		default:
+			return errors.ErrInvalidArgument
+		}
+	}
+	id, err := rewrite.DefaultEngine().AddPin(pin)
+	if err != nil {
+		return err
+	}
+	publishConfigChange("pin", "add", map[string]interface{}{"id": id, "user": cc.user})
+	return cc.writeOkWith(ctx, "", 0, uint64(id), cc.ctx.Status(), 0)
+}
+
+func (cc *clientConn) handleDeleteProxyPin(ctx context.Context, arg string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err = rewrite.DefaultEngine().DeletePin(id); err != nil {
+		return err
+	}
+	publishConfigChange("pin", "delete", map[string]interface{}{"id": id, "user": cc.user})
+	return cc.writeOkWith(ctx, "", 1, 0, cc.ctx.Status(), 0)
+}
+
+func (cc *clientConn) handleShowProxyConnLimits(ctx context.Context) error {
+	if err := cc.checkProcessForProxy(); err != nil {
+		return err
+	}
+	var values [][]interface{}
+	if cc.server.connLimits != nil {
+		for _, info := range cc.server.connLimits.infos() {
+			values = append(values, []interface{}{info.Type, info.Key, info.Limit, info.Connections, info.PeerConnections})
+		}
+	}
+	return cc.writeAdminResultset(ctx, adminShowConnLimitsColumns, values)
+}
+
+// handleSetProxyConnLimit changes the connection limit of a user or client ip, * is the
+// default of every one without its own limit and DEFAULT drops the own limit.
+func (cc *clientConn) handleSetProxyConnLimit(ctx context.Context, ty, key, value string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	if cc.server.connLimits == nil {
+		return errors.ErrInvalidArgument
+	}
+	limit := -1
+	if value != "default" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			return err
+		}
+	}
+	cc.server.connLimits.set(ty, key, limit)
+	return cc.writeOkWith(ctx, "", 0, 0, cc.ctx.Status(), 0)
+}
+
+func (cc *clientConn) handleShowProxyPools(ctx context.Context) error {
+	if err := cc.checkProcessForProxy(); err != nil {
+		return err
+	}
+	var values [][]interface{}
+	for _, state := range cc.server.cluster.PoolStates() {
+		values = append(values, []interface{}{state.Type, state.Cordoned, state.Tidbs, state.UsingConns})
+	}
+	return cc.writeAdminResultset(ctx, adminShowPoolsColumns, values)
+}
+
+func (cc *clientConn) handleCordonProxyPool(ctx context.Context, op, pool string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	var err error
+	if op == "cordon" {
+		err = cc.server.cluster.CordonPool(pool)
+	} else {
+		err = cc.server.cluster.UncordonPool(pool)
+	}
+	if err != nil {
+		return err
+	}
+	return cc.writeOkWith(ctx, "", 0, 0, cc.ctx.Status(), 0)
+}
+
+// handleDrainProxyPool returns when the pool is drained or the timeout is over, the backend
+// connections still in use are returned as the affected rows.
+func (cc *clientConn) handleDrainProxyPool(ctx context.Context, pool, timeout string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	var seconds int64
+	if timeout != "" {
+		var err error
+		if seconds, err = strconv.ParseInt(timeout, 10, 64); err != nil {
+			return err
+		}
+	}
+	left, err := cc.server.cluster.DrainPool(pool, time.Duration(seconds)*time.Second)
+	if err != nil {
+		return err
+	}
+	return cc.writeOkWith(ctx, "", uint64(left), 0, cc.ctx.Status(), 0)
+}
+
+func (cc *clientConn) handleShowProxyWindows(ctx context.Context) error {
+	if err := cc.checkProcessForProxy(); err != nil {
+		return err
+	}
+	var values [][]interface{}
+	now := time.Now()
+	for _, w := range maintenance.Default().List() {
+		pool := w.Pool
+		if pool == "" {
+			pool = "*"
+		}
+		values = append(values, []interface{}{w.ID, pool, w.Cron, int64(w.Duration / time.Second), w.Source, w.ActiveAt(now)})
+	}
+	return cc.writeAdminResultset(ctx, adminShowWindowsColumns, values)
+}
+
+// handleAddProxyWindow adds a maintenance window from pool='tp|ap' cron='..' duration='seconds',
+// a window without pool freezes every pool. The window id is returned as the last insert id.
+func (cc *clientConn) handleAddProxyWindow(ctx context.Context, args string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	var w maintenance.Window
+	for _, m := range adminRewriteArgRegexp.FindAllStringSubmatch(args, -1) {
+		value := adminArgValue(m[2])
+		switch strings.ToLower(m[1]) {
+		case "pool":
+			w.Pool = strings.ToLower(value)
+			if w.Pool != backend.TiDBForTP && w.Pool != backend.TiDBForAP {
+				return errors.ErrInvalidArgument
+			}
+		case "cron":
+			w.Cron = value
+		case "duration":
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			w.Duration = time.Duration(seconds) * time.Second
+		default:
+			return errors.ErrInvalidArgument
+		}
+	}
+	id, err := maintenance.Default().Add(w)
+	if err != nil {
+		return err
+	}
+	publishConfigChange("maintenance_window", "add", map[string]interface{}{"id": id, "user": cc.user})
+	return cc.writeOkWith(ctx, "", 0, uint64(id), cc.ctx.Status(), 0)
+}
+
+func (cc *clientConn) handleDeleteProxyWindow(ctx context.Context, arg string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err = maintenance.Default().Delete(id); err != nil {
+		return err
+	}
+	publishConfigChange("maintenance_window", "delete", map[string]interface{}{"id": id, "user": cc.user})
+	return cc.writeOkWith(ctx, "", 1, 0, cc.ctx.Status(), 0)
+}
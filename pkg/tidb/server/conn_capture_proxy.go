@@ -0,0 +1,41 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/mysql"
+	"github.com/pingcap/tidb/proxy/replay"
+)
+
+// captureStmt records a statement routed to the backend for later replay.
+func (c *clientConn) captureStmt(conn *backend.BackendConn, sql string, start time.Time, rs *mysql.Result, err error) {
+	recorder := replay.DefaultRecorder()
+	if !recorder.Enabled() {
+		return
+	}
+	rec := replay.NewRecord(sql)
+	rec.Ts = start.UnixNano()
+	rec.ConnID = c.connectionID
+	rec.DB = c.dbname
+	rec.Pool = conn.GetDbType()
+	rec.Duration = time.Since(start).Microseconds()
+	if err != nil {
+		rec.Error = err.Error()
+	} else if rs != nil && rs.Resultset != nil {
+		sum := new(replay.Checksum)
+		for _, row := range rs.Resultset.RowDatas {
+			if err = sum.AddTextRow(row, len(rs.Resultset.Fields)); err != nil {
+				break
+			}
+		}
+		rec.Checksum = sum.Sum()
+		rec.Rows = sum.Rows()
+	} else if rs != nil {
+		rec.Rows = int64(rs.AffectedRows)
+	}
+	if err = recorder.Write(rec); err != nil {
+		golog.Warn("ClientConn", "captureStmt", "write capture failed", 0, "connID", c.connectionID, "error", err)
+	}
+}
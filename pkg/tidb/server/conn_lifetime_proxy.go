@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+const (
+	reapIdleTimeout = "idle_timeout"
+	reapMaxLifetime = "max_lifetime"
+)
+
+// reapReason returns why the connection should be closed, empty if it should be kept.
+// Connections holding a transaction are never reaped, it is known by txnSince as the
+// session is owned by the connection goroutine.
+func (cc *clientConn) reapReason(now time.Time, idleTimeout, maxLifetime time.Duration) string {
+	if atomic.LoadInt64(&cc.txnSince) != 0 {
+		return ""
+	}
+	if idleTimeout > 0 && now.Sub(time.Unix(0, atomic.LoadInt64(&cc.activeTime))) > idleTimeout {
+		return reapIdleTimeout
+	}
+	if maxLifetime > 0 && now.Sub(cc.createTime) > maxLifetime {
+		return reapMaxLifetime
+	}
+	return ""
+}
+
+// reapConnections closes client connections idle longer than client_idle_timeout or
+// older than client_max_lifetime, so leaked app connections don't hold backend capacity.
+func (s *Server) reapConnections(idleTimeout, maxLifetime time.Duration) {
+	now := time.Now()
+	type reaped struct {
+		cc     *clientConn
+		reason string
+	}
+	var conns []reaped
+	for _, cc := range s.clients.snapshot() {
+		reason := cc.reapReason(now, idleTimeout, maxLifetime)
+		if reason == "" {
+			continue
+		}
+		// Only a connection waiting for the next command can be closed now, a busy one is
+		// notified and exits after the current command finishes.
+		if atomic.CompareAndSwapInt32(&cc.status, connStatusReading, connStatusShutdown) {
+			conns = append(conns, reaped{cc, reason})
+		} else if reason == reapMaxLifetime {
+			atomic.CompareAndSwapInt32(&cc.status, connStatusDispatching, connStatusWaitShutdown)
+		}
+	}
+
+	for _, r := range conns {
+		user, db := r.cc.userDB()
+		golog.Info("server", "reapConnections", "close client connection", 0,
+			"connID", r.cc.connectionID, "user", user, "host", r.cc.peerHost, "reason", r.reason)
+		metrics.ProxyConnReapedCounter.WithLabelValues(r.reason).Inc()
+		// Tell the client why the connection is gone before closing it.
+		err := r.cc.writeError(context.Background(), errNewAbortingConnection.FastGenByArgs(
+			r.cc.connectionID, db, user, r.cc.peerHost, r.reason))
+		if err == nil {
+			err = r.cc.flush(context.Background())
+		}
+		if err != nil {
+			golog.Debug("server", "reapConnections", "notify client failed", 0,
+				"connID", r.cc.connectionID, "error", err)
+		}
+		if err = r.cc.Close(); err != nil {
+			golog.Error("server", "reapConnections", "close connection failed", 0,
+				"connID", r.cc.connectionID, "error", err)
+		}
+	}
+}
+
+func (s *Server) runConnectionReaper() {
+	idleTimeout := time.Duration(s.cfg.Proxycfg.ClientIdleTimeout) * time.Second
+	maxLifetime := time.Duration(s.cfg.Proxycfg.ClientMaxLifetime) * time.Second
+	if idleTimeout <= 0 && maxLifetime <= 0 {
+		return
+	}
+	for {
+		if !s.pause(time.Second) || s.inShutdownMode {
+			return
+		}
+		s.reapConnections(idleTimeout, maxLifetime)
+	}
+}
+
+// rejectOnShutdown reports whether a new connection should get ER_SERVER_SHUTDOWN, so clients
+// connecting while the proxy drains fail fast instead of seeing a reset when the listener closes.
+func (cc *clientConn) rejectOnShutdown() bool {
+	if cc.server == nil || !cc.server.inShutdownMode || cc.server.cfg.Proxycfg == nil || !cc.server.cfg.Proxycfg.RejectOnShutdown {
+		return false
+	}
+	golog.Info("server", "rejectOnShutdown", "reject new connection during shutdown", 0,
+		"connID", cc.connectionID, "user", cc.user)
+	return true
+}
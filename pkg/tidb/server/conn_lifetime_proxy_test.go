@@ -0,0 +1,44 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReapReason(t *testing.T) {
+	now := time.Now()
+	cc := &clientConn{createTime: now.Add(-time.Hour), activeTime: now.Add(-time.Minute).UnixNano()}
+	if r := cc.reapReason(now, 30*time.Second, 0); r != reapIdleTimeout {
+		t.Fatalf("expect %s, got %q", reapIdleTimeout, r)
+	}
+	if r := cc.reapReason(now, 0, 30*time.Minute); r != reapMaxLifetime {
+		t.Fatalf("expect %s, got %q", reapMaxLifetime, r)
+	}
+	atomic.StoreInt64(&cc.txnSince, now.Add(-time.Minute).UnixNano())
+	if r := cc.reapReason(now, 30*time.Second, 30*time.Minute); r != "" {
+		t.Fatalf("connection in a transaction reaped for %s", r)
+	}
+}
+
+// TestReapWhileUsingDB reads the connection from the reaper while its goroutine changes
+// the database, run with -race.
+func TestReapWhileUsingDB(t *testing.T) {
+	cc := &clientConn{user: "app"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			cc.setDBName("test")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if user, _ := cc.userDB(); user != "app" {
+			t.Fatalf("expect user app, got %s", user)
+		}
+	}
+	<-done
+	if _, db := cc.userDB(); db != "test" {
+		t.Fatalf("expect db test, got %s", db)
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/metrics"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+const (
+	connLimitUser = "user"
+	connLimitHost = "host"
+	// key of the limit every user or client ip gets unless it has its own
+	connLimitDefault = "*"
+)
+
+// connLimitInfo is a connection limit and the connections it counts.
+type connLimitInfo struct {
+	Type        string `json:"type"`
+	Key         string `json:"key"`
+	Limit       int    `json:"limit"`
+	Connections int    `json:"connections"`
+	// connections of the other proxy replicas, counted against the limit too
+	PeerConnections int `json:"peer_connections,omitempty"`
+}
+
+// connLimits caps the client connections of a user and of a client ip, on top of the
+// global max-server-connections. A limit of 0 means no limit.
+type connLimits struct {
+	sync.Mutex
+	// limits by type and key, connLimitDefault holds the default of the type
+	limits map[string]map[string]int
+	// connections by type and key
+	conns map[string]map[string]int
+	// connections of the other proxy replicas by type and key, see coordinator
+	peers map[string]map[string]int
+}
+
+func newConnLimits(cfg *proxyconfig.Config) *connLimits {
+	l := &connLimits{
+		limits: map[string]map[string]int{connLimitUser: {}, connLimitHost: {}},
+		conns:  map[string]map[string]int{connLimitUser: {}, connLimitHost: {}},
+	}
+	if cfg == nil {
+		return l
+	}
+	l.limits[connLimitUser][connLimitDefault] = cfg.MaxUserConnections
+	l.limits[connLimitHost][connLimitDefault] = cfg.MaxHostConnections
+	for user, limit := range cfg.UserConnectionLimits {
+		l.limits[connLimitUser][user] = limit
+	}
+	for host, limit := range cfg.HostConnectionLimits {
+		l.limits[connLimitHost][host] = limit
+	}
+	return l
+}
+
+// limit returns the limit of the key, the caller holds the lock.
+func (l *connLimits) limit(ty, key string) int {
+	if limit, ok := l.limits[ty][key]; ok {
+		return limit
+	}
+	return l.limits[ty][connLimitDefault]
+}
+
+// acquire counts the connection of the user from the host, it returns the type of the
+// limit the connection exceeds, empty if it is accepted.
+func (l *connLimits) acquire(user, host string) string {
+	l.Lock()
+	defer l.Unlock()
+	keys := [][2]string{{connLimitUser, user}, {connLimitHost, host}}
+	for _, k := range keys {
+		if limit := l.limit(k[0], k[1]); limit > 0 && l.conns[k[0]][k[1]]+l.peers[k[0]][k[1]] >= limit {
+			return k[0]
+		}
+	}
+	for _, k := range keys {
+		l.conns[k[0]][k[1]]++
+	}
+	return ""
+}
+
+func (l *connLimits) release(user, host string) {
+	l.Lock()
+	defer l.Unlock()
+	for _, k := range [][2]string{{connLimitUser, user}, {connLimitHost, host}} {
+		if l.conns[k[0]][k[1]]--; l.conns[k[0]][k[1]] <= 0 {
+			delete(l.conns[k[0]], k[1])
+		}
+	}
+}
+
+// setPeers replaces the connections of the other proxy replicas.
+func (l *connLimits) setPeers(peers map[string]map[string]int) {
+	l.Lock()
+	l.peers = peers
+	l.Unlock()
+}
+
+// counts returns a copy of the connections of this replica by type and key.
+func (l *connLimits) counts() map[string]map[string]int {
+	l.Lock()
+	defer l.Unlock()
+	rs := make(map[string]map[string]int, len(l.conns))
+	for ty, conns := range l.conns {
+		rs[ty] = make(map[string]int, len(conns))
+		for key, n := range conns {
+			rs[ty][key] = n
+		}
+	}
+	return rs
+}
+
+// set changes the limit of the key at runtime, a negative limit drops the limit of the
+// key so the default applies again. Connections over the new limit are kept.
+func (l *connLimits) set(ty, key string, limit int) {
+	l.Lock()
+	defer l.Unlock()
+	if limit < 0 && key != connLimitDefault {
+		delete(l.limits[ty], key)
+		return
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	l.limits[ty][key] = limit
+}
+
+// infos returns the limits and the connections of the keys that have either.
+func (l *connLimits) infos() []connLimitInfo {
+	l.Lock()
+	defer l.Unlock()
+	var infos []connLimitInfo
+	for _, ty := range []string{connLimitUser, connLimitHost} {
+		keys := make(map[string]struct{})
+		for key := range l.limits[ty] {
+			keys[key] = struct{}{}
+		}
+		for key := range l.conns[ty] {
+			keys[key] = struct{}{}
+		}
+		for key := range l.peers[ty] {
+			keys[key] = struct{}{}
+		}
+		start := len(infos)
+		for key := range keys {
+			infos = append(infos, connLimitInfo{Type: ty, Key: key, Limit: l.limit(ty, key),
+				Connections: l.conns[ty][key], PeerConnections: l.peers[ty][key]})
+		}
+		sort.Slice(infos[start:], func(i, j int) bool { return infos[start+i].Key < infos[start+j].Key })
+	}
+	return infos
+}
+
+// acquireConnLimit counts the authenticated connection against the per user and per
+// client ip limits, a connection that changes user is counted again.
+func (cc *clientConn) acquireConnLimit(host string) error {
+	limits := cc.server.connLimits
+	if limits == nil {
+		return nil
+	}
+	cc.releaseConnLimit()
+	if ty := limits.acquire(cc.user, host); ty != "" {
+		key := cc.user
+		if ty == connLimitHost {
+			key = host
+		}
+		metrics.ProxyConnLimitRejectedCounter.WithLabelValues(ty, key).Inc()
+		golog.Warn("server", "acquireConnLimit", "too many connections", 0,
+			"connID", cc.connectionID, "user", cc.user, "host", host, "limit", ty)
+		return errTooManyUserConnections.FastGenByArgs(cc.user + "@" + host)
+	}
+	cc.limitUser, cc.limitHost = cc.user, host
+	atomic.StoreInt32(&cc.limitHeld, 1)
+	return nil
+}
+
+func (cc *clientConn) releaseConnLimit() {
+	if cc.server == nil || cc.server.connLimits == nil || !atomic.CompareAndSwapInt32(&cc.limitHeld, 1, 0) {
+		return
+	}
+	cc.server.connLimits.release(cc.limitUser, cc.limitHost)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func TestConnLimits(t *testing.T) {
+	l := newConnLimits(&config.Config{
+		MaxUserConnections:   2,
+		UserConnectionLimits: map[string]int{"batch": 1, "admin": 0},
+		HostConnectionLimits: map[string]int{"10.0.0.9": 1},
+	})
+	for i := 0; i < 2; i++ {
+		if ty := l.acquire("app", "10.0.0.1"); ty != "" {
+			t.Fatalf("connection %d under the limit rejected by %s", i, ty)
+		}
+	}
+	if ty := l.acquire("app", "10.0.0.2"); ty != connLimitUser {
+		t.Fatalf("connection over the default user limit rejected by %q", ty)
+	}
+	if ty := l.acquire("batch", "10.0.0.1"); ty != "" {
+		t.Fatalf("connection under the user limit rejected by %s", ty)
+	}
+	if ty := l.acquire("batch", "10.0.0.1"); ty != connLimitUser {
+		t.Fatalf("connection over the user limit rejected by %q", ty)
+	}
+	//0 means no limit for the user, the host limit still applies
+	for i := 0; i < 3; i++ {
+		l.acquire("admin", "10.0.0.1")
+	}
+	if ty := l.acquire("admin", "10.0.0.9"); ty != "" {
+		t.Fatalf("connection under the host limit rejected by %s", ty)
+	}
+	if ty := l.acquire("admin", "10.0.0.9"); ty != connLimitHost {
+		t.Fatalf("connection over the host limit rejected by %q", ty)
+	}
+
+	//a rejected connection is not counted, the released ones free their slots
+	counts := l.counts()
+	if counts[connLimitUser]["app"] != 2 || counts[connLimitUser]["admin"] != 4 || counts[connLimitHost]["10.0.0.1"] != 6 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+	l.release("app", "10.0.0.1")
+	if ty := l.acquire("app", "10.0.0.2"); ty != "" {
+		t.Fatalf("released slot not reused, rejected by %s", ty)
+	}
+	l.release("batch", "10.0.0.1")
+	if _, ok := l.counts()[connLimitUser]["batch"]; ok {
+		t.Fatal("user without connections kept")
+	}
+
+	//a limit set at runtime applies to the next connection, dropping it restores the default
+	l.set(connLimitUser, "app", 5)
+	if ty := l.acquire("app", "10.0.0.2"); ty != "" {
+		t.Fatalf("connection under the raised limit rejected by %s", ty)
+	}
+	l.set(connLimitUser, "app", -1)
+	if ty := l.acquire("app", "10.0.0.2"); ty != connLimitUser {
+		t.Fatalf("connection over the default limit rejected by %q", ty)
+	}
+	for _, info := range l.infos() {
+		if info.Type == connLimitUser && info.Key == "app" && (info.Limit != 2 || info.Connections != 3) {
+			t.Fatalf("unexpected limit info %+v", info)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyerrors "github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// handleLoadDataForProxy relays LOAD DATA to the backend. The local file is streamed
+// from the client packet by packet, so at most one packet is buffered in the proxy.
+func (c *clientConn) handleLoadDataForProxy(ctx context.Context, conn *backend.BackendConn, stmt *ast.LoadDataStmt, lastStmt bool) error {
+	if stmt.IsLocal && c.capability&mysql.ClientLocalFiles == 0 {
+		return errNotAllowedCommand
+	}
+	maxSize := c.server.cfg.Proxycfg.MaxLoadDataSize * 1024 * 1024
+
+	metrics.ProxyLoadDataInProgressGauge.Inc()
+	defer metrics.ProxyLoadDataInProgressGauge.Dec()
+	start := time.Now()
+	var total int64
+	rs, err := conn.LoadData(stmt.Text(), func(fileName string, send func(data []byte) error) error {
+		if err := c.writeReq(ctx, fileName); err != nil {
+			return err
+		}
+		var relayErr error
+		for {
+			data, err := c.readPacket()
+			if err != nil {
+				return err
+			}
+			if len(data) == 0 {
+				break
+			}
+			// keep reading the rest of the file after a failure, the client expects a result only after the empty packet.
+			if relayErr != nil {
+				continue
+			}
+			total += int64(len(data))
+			if maxSize > 0 && total > maxSize {
+				relayErr = proxyerrors.ErrLoadDataTooLarge
+				continue
+			}
+			if relayErr = send(data); relayErr == nil {
+				metrics.ProxyLoadDataBytesCounter.Add(float64(len(data)))
+			}
+		}
+		return relayErr
+	})
+	golog.Info("ClientConn", "handleLoadDataForProxy", "load data relayed", 0,
+		"connID", c.connectionID,
+		"backend", conn.GetDbAddr(),
+		"bytes", total,
+		"duration", time.Since(start).String(),
+		"error", err)
+	if err != nil {
+		return err
+	}
+
+	sessionVars := c.ctx.GetSessionVars()
+	sessionVars.StmtCtx.AddAffectedRows(rs.AffectedRows)
+	sessionVars.StmtCtx.LastInsertID = rs.InsertId
+	c.trackStates(rs.SessionStates...)
+	c.appendRoutingNote(conn)
+	return c.writeOkWith(ctx, c.ctx.LastMessage(), c.ctx.AffectedRows(), c.ctx.LastInsertID(), c.proxyStatus(lastStmt), c.ctx.WarningCount())
+}
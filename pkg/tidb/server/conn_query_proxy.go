@@ -3,24 +3,55 @@ package server
 import (
 	"context"
 	"fmt"
+	"net"
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/tidb/metrics"
 	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/config"
 	"github.com/pingcap/tidb/proxy/mysql"
+	"github.com/pingcap/tidb/proxy/rewrite"
+	"github.com/pingcap/tidb/proxy/stats"
+	"github.com/pingcap/tidb/sessionctx/variable"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
 /*处理query语句*/
-func (c *clientConn) handleDMLForProxy(ctx context.Context,conn *backend.BackendConn,stmt ast.StmtNode) ( error) {
+func (c *clientConn) handleDMLForProxy(ctx context.Context,conn *backend.BackendConn,stmt ast.StmtNode,lastStmt bool) ( error) {
 	sessionVars := c.ctx.GetSessionVars()
 	var rs *mysql.Result
 	s := &TiDBStatement{
 		sql: stmt.Text(),
 	}
+	if sql, ok := rewrite.DefaultEngine().Rewrite(s.sql, c.stmtDigest(s.sql), c.user, conn.GetDbType()); ok {
+		s.sql = sql
+	}
+	if pin, ok := rewrite.DefaultEngine().PinOf(c.stmtDigest(stmt.Text()), c.user, false); ok {
+		s.sql = pin.WithHint(s.sql)
+	}
+	if err := c.setReplicaRead(conn, stmt); err != nil {
+		return err
+	}
+	if err := c.setReadEngines(conn, stmt); err != nil {
+		return err
+	}
+	if err := c.setPriority(conn, stmt); err != nil {
+		return err
+	}
+	start := time.Now()
 	rs, err := c.executeInNode(conn, s, nil)
+	c.captureStmt(conn, s.sql, start, rs, err)
+	elapsed := time.Since(start)
+	c.observeOrigin(conn, start)
+	stats.DefaultTop().Add(conn.GetDbType(), sessionVars.CurrentDB, stmt.Text(), elapsed, int64(sessionVars.Proxy.Cost))
+	stats.DefaultUsers().AddStmt(c.user, conn.GetDbType(), elapsed)
 	if err != nil {
 		return  err
 	}
+	c.observeCost(elapsed)
+	c.mirrorShadow(stmt, s.sql)
 
 	if rs == nil {
 		msg := fmt.Sprintf("result is empty")
@@ -28,11 +59,17 @@ func (c *clientConn) handleDMLForProxy(ctx context.Context,conn *backend.Backend
 	}
 	sessionVars.StmtCtx.AddAffectedRows(rs.AffectedRows)
 	sessionVars.StmtCtx.LastInsertID = rs.InsertId
+	c.trackStates(rs.SessionStates...)
+	c.appendRoutingNote(conn)
+	if s.sql == stmt.Text() {
+		c.cacheMetadata(rs.Resultset)
+	}
 
+	status := c.proxyStatus(lastStmt)
 	if rs.Resultset != nil {
-		err = c.writeResultsetForProxy(ctx,rs.Resultset)
+		err = c.writeResultsetForProxy(ctx,rs.Resultset,status)
 	} else {
-		err = c.writeOK(ctx)
+		err = c.writeOkWith(ctx, c.ctx.LastMessage(), c.ctx.AffectedRows(), c.ctx.LastInsertID(), status, c.ctx.WarningCount())
 	}
 
 	if err != nil {
@@ -58,7 +95,7 @@ func (c *clientConn) scaleClosePrepare(cluster *backend.Cluster) uint64 {
 							c.prepareConn.Close()
 						}
 					}
-					c.prepareConn = nil
+					c.setPrepareConn(nil)
 				}
 			}
 		}
@@ -71,9 +108,14 @@ func (c *clientConn) connSet(co *backend.BackendConn) (err error) {
 	if !co.IsProxySelf() {
 		if err = co.UseDB(c.dbname); err != nil {
 			//reset the database to null
-			c.dbname = ""
+			c.setDBName("")
 			return
 		}
+		if c.server.cfg.Proxycfg.ForwardConnAttrs || c.server.cfg.Proxycfg.ForwardClientIP == config.ForwardClientIPAttr || c.certAuthed {
+			if err = co.SetConnAttrs(c.proxyConnAttrs(co)); err != nil {
+				return
+			}
+		}
 		/*charset,_ := variable.GetSessionOrGlobalSystemVar(c.ctx.GetSessionVars(), variable.CharacterSetConnection)
 		collation,_ := variable.GetSessionOrGlobalSystemVar(c.ctx.GetSessionVars(), variable.CollationConnection)
 
@@ -88,7 +130,8 @@ func (c *clientConn) connSet(co *backend.BackendConn) (err error) {
 func (c *clientConn) mountPrepareConn(co *backend.BackendConn,curVersion uint64)(err error) {
 	if co.GetBindConn() == true {
 		if c.prepareConn == nil && c.isPrepare() == true {
-			c.prepareConn = co
+			c.setPrepareConn(co)
+			c.resumeSuspended()
 			if !co.IsProxySelf() {
 				err = c.connSet(co)
 				if err != nil {
@@ -114,6 +157,7 @@ func (c *clientConn) mountPrepareConn(co *backend.BackendConn,curVersion uint64)
 func (c *clientConn) getBackendConn(cluster *backend.Cluster,bindFlag bool) (co *backend.BackendConn, err error) {
 	sessionVars := c.ctx.GetSessionVars()
 	cost := int64(sessionVars.Proxy.Cost)
+	c.routedCluster = cluster
 	var Flag bool
 	var curVersion uint64
 	if cost > cluster.MaxCostPerSql {
@@ -122,10 +166,16 @@ func (c *clientConn) getBackendConn(cluster *backend.Cluster,bindFlag bool) (co
 	if cost > 100000 {
 		fmt.Println("current cost is ", cost, " max cost is ", cluster.MaxCostPerSql,"sql",sessionVars.Proxy.SQLtext)
 	}
+	defer func() {
+		//closeConn isn't called for a statement failing to get its conn
+		if err != nil {
+			c.releaseAPTurn()
+		}
+	}()
 	if !sessionVars.InTxn() && sessionVars.IsAutocommit() ||
 		sessionVars.GetStatusFlag(mysql.SERVER_STATUS_PREPARE) == false {
 		//fmt.Println("no tran")
-		co, err = cluster.GetTidbConn(cost,false)
+		co, err = c.routeTidbConn(cluster, cost, false)
 		if err != nil {
 			return
 		}
@@ -135,14 +185,14 @@ func (c *clientConn) getBackendConn(cluster *backend.Cluster,bindFlag bool) (co
 			//set tx transaction
 			var txStart bool
 			if c.txConn == nil {
-				c.txConn = c.prepareConn
+				c.setTxConn(c.prepareConn)
 				if c.prepareConn != nil {
 					txStart = true
 				}
 			}
 			co = c.txConn
 			if co == nil {
-				if co, err = cluster.GetTidbConn(cost, bindFlag); err != nil {
+				if co, err = c.routeTidbConn(cluster, cost, bindFlag); err != nil {
 					return
 				}
 				if !co.IsProxySelf() {
@@ -157,11 +207,12 @@ func (c *clientConn) getBackendConn(cluster *backend.Cluster,bindFlag bool) (co
 					}
 					co.SetNoDelayTrue()
 				}
-				c.txConn = co
+				c.setTxConn(co)
 			} else {
 				dbtype := co.GetDbType()
 				if co.IsProxySelf() {
 					atomic.AddInt64(&cluster.ProxyNode.ProxyCost, cost)
+					cluster.ProxyNode.Costs.Add(cost, backend.OriginProxy)
 					metrics.QueriesCounter.WithLabelValues(backend.TiDBForTP).Inc()
 				} else {
 					if txStart == true {
@@ -176,8 +227,8 @@ func (c *clientConn) getBackendConn(cluster *backend.Cluster,bindFlag bool) (co
 						}
 					}
 					if dbtype == backend.TiDBForTP || dbtype == backend.TiDBForAP {
-						atomic.AddInt64(&cluster.BackendPools[dbtype].Costs, cost)
-						atomic.AddUint64(&cluster.BackendPools[dbtype].TotalCost[backend.CurCost], uint64(cost))
+						cluster.AddConnCost(co, cost)
+						cluster.ProxyNode.Costs.Add(cost, backend.OriginForward)
 						metrics.QueriesCounter.WithLabelValues(dbtype).Inc()
 					}
 				}
@@ -186,7 +237,7 @@ func (c *clientConn) getBackendConn(cluster *backend.Cluster,bindFlag bool) (co
 			//no transation, scale out or scale in,prepare umount connection
 			co = c.prepareConn
 			if co == nil {
-				if co, err = cluster.GetTidbConn(cost,bindFlag); err != nil {
+				if co, err = c.routeTidbConn(cluster, cost, bindFlag); err != nil {
 					return
 				}
 				if !co.IsProxySelf() {
@@ -196,11 +247,12 @@ func (c *clientConn) getBackendConn(cluster *backend.Cluster,bindFlag bool) (co
 				dbtype := co.GetDbType()
 				if co.IsProxySelf() {
 					atomic.AddInt64(&cluster.ProxyNode.ProxyCost, cost)
+					cluster.ProxyNode.Costs.Add(cost, backend.OriginProxy)
 					metrics.QueriesCounter.WithLabelValues(backend.TiDBForTP).Inc()
 				} else {
 					if dbtype == backend.TiDBForTP || dbtype == backend.TiDBForAP {
-						atomic.AddInt64(&cluster.BackendPools[dbtype].Costs, cost)
-						atomic.AddUint64(&cluster.BackendPools[dbtype].TotalCost[backend.CurCost], uint64(cost))
+						cluster.AddConnCost(co, cost)
+						cluster.ProxyNode.Costs.Add(cost, backend.OriginForward)
 						metrics.QueriesCounter.WithLabelValues(dbtype).Inc()
 					}
 				}
@@ -217,8 +269,8 @@ func (c *clientConn) getBackendConn(cluster *backend.Cluster,bindFlag bool) (co
 				co.SetNoDelayFlase()
 				co.Close()
 			}
-			c.txConn = nil
-			c.prepareConn = nil
+			c.setTxConn(nil)
+			c.setPrepareConn(nil)
 		}
 	}
 	if co.GetBindConn() == false {
@@ -228,6 +280,146 @@ func (c *clientConn) getBackendConn(cluster *backend.Cluster,bindFlag bool) (co
 	return
 }
 
+//proxyConnAttrs describes the client connection for backend tracing, it carries the app
+//attributes from the client handshake, the proxy connection id and the routing class.
+func (c *clientConn) proxyConnAttrs(co *backend.BackendConn) string {
+	attrs := make([]string, 0, 4)
+	for _, key := range []string{"program_name", "_client_name"} {
+		if v, ok := c.attrs[key]; ok {
+			attrs = append(attrs, key+"="+v)
+		}
+	}
+	attrs = append(attrs,
+		"proxy_conn_id="+strconv.FormatUint(c.connectionID, 10),
+		"route="+co.GetDbType())
+	if c.server.cfg.Proxycfg.ForwardClientIP != "" && c.peerHost != "" {
+		attrs = append(attrs, "client_ip="+c.peerHost)
+	}
+	//the identity mapped from the client certificate, backends log in as the proxy user
+	if c.certAuthed {
+		attrs = append(attrs, "proxy_user="+c.user, "proxy_auth=x509")
+	}
+	return strings.Join(attrs, ",")
+}
+
+//appendRoutingNote tells the client which backend ran the statement and its class,
+//it is enabled by serverless_routing_feedback and read by SHOW WARNINGS.
+func (c *clientConn) appendRoutingNote(conn *backend.BackendConn) {
+	sessionVars := c.ctx.GetSessionVars()
+	if !sessionVars.Proxy.RoutingFeedback {
+		return
+	}
+	cost := int64(sessionVars.Proxy.Cost)
+	addr := "proxy"
+	if !conn.IsProxySelf() {
+		addr = conn.GetDbAddr()
+	}
+	sessionVars.StmtCtx.AppendNote(fmt.Errorf("routed to %s backend %s, class %s, cost %d",
+		conn.GetDbType(), addr, backend.CostClass(cost), cost))
+}
+
+//proxyStatus returns server status of the statement result, statements in the middle
+//of a multi-statement batch tell the client more results follow.
+func (c *clientConn) proxyStatus(lastStmt bool) uint16 {
+	status := c.ctx.Status()
+	if !lastStmt {
+		status |= mysql.SERVER_MORE_RESULTS_EXISTS
+	}
+	return status
+}
+
+//routeTidbConn chooses backend by cost, unless the connection is bound to a pool.
+func (c *clientConn) routeTidbConn(cluster *backend.Cluster, cost int64, bindFlag bool) (*backend.BackendConn, error) {
+	if co := c.pinnedConn(cluster, cost); co != nil {
+		return co, nil
+	}
+	ty, pod, _ := c.routeType(true)
+	if pod == "" && (ty == backend.TiDBForAP || ty == "" && backend.CostClass(cost) == backend.TiDBForAP) {
+		if err := c.waitAPTurn(cluster); err != nil {
+			return nil, err
+		}
+	}
+	co, err := c.pickTidbConn(cluster, ty, pod, cost, bindFlag)
+	if err != nil {
+		c.releaseAPTurn()
+		return nil, err
+	}
+	if err = c.bindClient(co); err != nil {
+		co.Close()
+		c.releaseAPTurn()
+		return nil, err
+	}
+	return co, nil
+}
+
+//how the pool or the backend of a statement is chosen, shown by EXPLAIN ROUTE
+const (
+	routeByCost    = "cost"
+	routeBySession = "serverless_route"
+	routeByPort    = "ap_port"
+	routeByBatch   = "batch_pin"
+	routeByPin     = "plan_pin"
+)
+
+//routeType returns the pool or the backend the statement is routed to and why, both
+//empty means the pool is chosen by the cost. countPin counts the hit of a plan pin.
+func (c *clientConn) routeType(countPin bool) (ty string, pod string, by string) {
+	if route := c.ctx.GetSessionVars().Proxy.Route; route != "" && !strings.EqualFold(route, variable.ServerlessRouteAuto) {
+		switch strings.ToLower(route) {
+		case backend.TiDBForTP, backend.TiDBForAP:
+			return strings.ToLower(route), "", routeBySession
+		}
+		return "", route, routeBySession
+	}
+	if c.forceAP {
+		return backend.TiDBForAP, "", routeByPort
+	}
+	if c.pinnedType != "" {
+		return c.pinnedType, "", routeByBatch
+	}
+	//plan pins of known problem statements override the cost based classifier
+	if pin, ok := rewrite.DefaultEngine().PinOf(c.stmtDigest(c.ctx.GetSessionVars().Proxy.SQLtext), c.user, countPin); ok {
+		return pin.Pool, "", routeByPin
+	}
+	return "", "", routeByCost
+}
+
+//pickTidbConn gets conn from the backend set by serverless_route, or from the pool. Pinning
+//to a single backend bypasses the balancer so it needs the SUPER privilege.
+func (c *clientConn) pickTidbConn(cluster *backend.Cluster, ty, pod string, cost int64, bindFlag bool) (*backend.BackendConn, error) {
+	if pod != "" {
+		if err := c.checkSuperForProxy(); err != nil {
+			return nil, err
+		}
+		return cluster.GetTidbConnByPod(pod, cost, bindFlag)
+	}
+	if ty != "" {
+		return cluster.GetTidbConnByType(ty, cost, bindFlag)
+	}
+	co, err := cluster.GetTidbConn(cost, bindFlag)
+	if err == nil && c.pinBatch {
+		switch co.GetDbType() {
+		case backend.TiDBForTP, backend.TiDBForAP:
+			c.pinnedType = co.GetDbType()
+		}
+	}
+	return co, err
+}
+
+//bindClient moves the statement to a backend conn dialed for the client ip when the
+//client ip is sent by PROXY protocol.
+func (c *clientConn) bindClient(co *backend.BackendConn) error {
+	if c.server.cfg.Proxycfg.ForwardClientIP != config.ForwardClientIPProxyProtocol || co.IsProxySelf() {
+		return nil
+	}
+	ip := net.ParseIP(c.peerHost)
+	if ip == nil {
+		return nil
+	}
+	port, _ := strconv.Atoi(c.peerPort)
+	return co.BindClient(&net.TCPAddr{IP: ip, Port: port})
+}
+
 func initTidbStmt(tidbStmt *backend.Stmt,conn *backend.Conn,s *TiDBStatement,bindFlag bool) {
 	//init tidb stmt
 	tidbStmt.SetColums(s.columns)
@@ -238,10 +430,19 @@ func initTidbStmt(tidbStmt *backend.Stmt,conn *backend.Conn,s *TiDBStatement,bin
 	tidbStmt.SetBindConn(bindFlag)
 }
 
-func (c *clientConn) executeInNode(conn *backend.BackendConn, s *TiDBStatement,args []interface{}) (*mysql.Result, error) {
+func (c *clientConn) executeInNode(conn *backend.BackendConn, s *TiDBStatement,args []interface{}) (r *mysql.Result, err error) {
 	tidbStmt := &backend.Stmt{}
 	initTidbStmt(tidbStmt,conn.Conn,s,conn.GetBindConn())
-	r, err := conn.Execute(tidbStmt,s.paramsType,args...)
+	if !conn.IsProxySelf() {
+		limit := c.resultLimit(conn)
+		conn.SetResultLimit(limit)
+		defer conn.SetResultLimit(backend.ResultLimit{})
+		defer func() {
+			c.checkResultLimit(conn, limit, r, err)
+		}()
+	}
+	r, err = conn.Execute(tidbStmt,s.paramsType,args...)
+	c.noteBackendFailure(conn, s.sql, err)
 	if err != nil {
 		return nil, err
 	}
@@ -250,17 +451,26 @@ func (c *clientConn) executeInNode(conn *backend.BackendConn, s *TiDBStatement,a
 }
 
 func (c *clientConn) closeConn(conn *backend.BackendConn, rollback bool) {
+	c.releaseAPTurn()
 	sessionVars := c.ctx.GetSessionVars()
 	if conn == nil {
 		return
 	}
+	cluster := c.routedCluster
+	if cluster == nil {
+		cluster = c.server.cluster
+	}
 	dbtype := conn.GetDbType()
 	cost := int64(sessionVars.Proxy.Cost)
 	if !conn.IsProxySelf() && (dbtype == backend.TiDBForTP || dbtype == backend.TiDBForAP) {
-		atomic.AddInt64(&c.server.cluster.BackendPools[dbtype].Costs, -cost)
+		cluster.AddConnCost(conn, -cost)
+	}
+	if !conn.IsProxySelf() && (dbtype == backend.TiDBForTP || dbtype == backend.TiDBForAP || dbtype == backend.BigCost) {
+		cluster.ProxyNode.Costs.Add(-cost, backend.OriginForward)
 	}
 	if conn.IsProxySelf() {
-		atomic.AddInt64(&c.server.cluster.ProxyNode.ProxyCost, -cost)
+		atomic.AddInt64(&cluster.ProxyNode.ProxyCost, -cost)
+		cluster.ProxyNode.Costs.Add(-cost, backend.OriginProxy)
 	}
 	if sessionVars.InTxn() || !sessionVars.IsAutocommit() ||
 		sessionVars.GetStatusFlag(mysql.SERVER_STATUS_PREPARE) == true &&
@@ -275,11 +485,11 @@ func (c *clientConn) closeConn(conn *backend.BackendConn, rollback bool) {
 			conn.Rollback()
 		}
 	}
-	c.prepareConn = nil
-	c.txConn = nil
+	c.setPrepareConn(nil)
+	c.setTxConn(nil)
 	//stop the big size tidb when the big sql is finished.
 	if dbtype == backend.BigCost {
-		_, err := backend.ScaleTempTidb(c.server.cluster.Cfg.NameSpace, c.server.cluster.Cfg.ClusterName, 0, false, conn.GetAddr())
+		_, err := backend.ScaleTempTidb(cluster.Cfg.NameSpace, cluster.Cfg.ClusterName, 0, false, conn.GetAddr())
 		if err != nil {
 			fmt.Errorf("delete big size tidb %s faield: %s.", conn.GetAddr(), err)
 		}
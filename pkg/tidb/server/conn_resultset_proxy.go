@@ -17,6 +17,7 @@ package server
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/pingcap/tidb/proxy/core/errors"
 	"github.com/pingcap/tidb/proxy/core/hack"
@@ -143,11 +144,24 @@ func (c *clientConn) buildResultset(fields []*mysql.Field, names []string, value
 	return r, nil
 }
 
-func (c *clientConn) writeResultsetForProxy( ctx context.Context,r *mysql.Resultset) error {
-	sessionvar:=c.ctx.GetSessionVars()
-	sta :=sessionvar.Status
+//relayWriteTimeout bounds the write of a relayed result set, so a client not reading it
+//doesn't hold the backend connection of the statement forever.
+func (c *clientConn) relayWriteTimeout() time.Duration {
+	if c.server == nil || c.server.cfg.Proxycfg == nil {
+		return 0
+	}
+	return time.Duration(c.server.cfg.Proxycfg.ClientWriteTimeout) * time.Second
+}
+
+func (c *clientConn) writeResultsetForProxy( ctx context.Context,r *mysql.Resultset,sta uint16) error {
 	data := c.alloc.AllocWithLen(4, 1024)
 	var err error
+	if timeout := c.relayWriteTimeout(); timeout > 0 {
+		if err = c.bufReadConn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		defer c.bufReadConn.SetWriteDeadline(time.Time{})
+	}
 	columnLen := mysql.PutLengthEncodedInt(uint64(len(r.Fields)))
 
 	data = append(data, columnLen...)
@@ -182,6 +196,5 @@ func (c *clientConn) writeResultsetForProxy( ctx context.Context,r *mysql.Result
 	if err != nil {
 		return err
 	}
-	c.flush(ctx)
-	return nil
+	return c.flush(ctx)
 }
@@ -0,0 +1,98 @@
+package server
+
+import "github.com/pingcap/tidb/proxy/backend"
+
+// The connection goroutine owns the clientConn and reads its fields without a lock. The
+// fields other goroutines read too, the admin statements, the status server and the
+// watchdogs, are set by it under cc.mu, and read by the others through the getters below.
+
+func (cc *clientConn) setCtx(ctx *TiDBContext) {
+	cc.mu.Lock()
+	cc.ctx = ctx
+	cc.mu.Unlock()
+}
+
+func (cc *clientConn) setUser(user string) {
+	cc.mu.Lock()
+	cc.user = user
+	cc.mu.Unlock()
+}
+
+func (cc *clientConn) setDBName(db string) {
+	cc.mu.Lock()
+	cc.dbname = db
+	cc.mu.Unlock()
+}
+
+func (cc *clientConn) setTxConn(co *backend.BackendConn) {
+	cc.mu.Lock()
+	cc.txConn = co
+	cc.mu.Unlock()
+}
+
+func (cc *clientConn) setPrepareConn(co *backend.BackendConn) {
+	cc.mu.Lock()
+	cc.prepareConn = co
+	cc.mu.Unlock()
+}
+
+func (cc *clientConn) setTempConn(co *backend.BackendConn) {
+	cc.mu.Lock()
+	cc.tempConn = co
+	cc.mu.Unlock()
+}
+
+// setSQLText sets the statement the session runs, the digest of the diagnostics is taken
+// from it.
+func (cc *clientConn) setSQLText(sql string) {
+	cc.mu.Lock()
+	cc.ctx.GetSessionVars().Proxy.SQLtext = sql
+	cc.mu.Unlock()
+}
+
+// getCtx returns the session of the connection to another goroutine.
+func (cc *clientConn) getCtx() *TiDBContext {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.ctx
+}
+
+// userDB returns the user and the default database of the connection to another goroutine.
+func (cc *clientConn) userDB() (user, db string) {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.user, cc.dbname
+}
+
+// getTxConn returns the backend connection of the transaction to another goroutine.
+func (cc *clientConn) getTxConn() *backend.BackendConn {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.txConn
+}
+
+// sessionView is the state of a connection read by another goroutine at once.
+type sessionView struct {
+	user        string
+	db          string
+	sql         string
+	txConn      *backend.BackendConn
+	prepareConn *backend.BackendConn
+	tempConn    *backend.BackendConn
+}
+
+func (cc *clientConn) view() sessionView {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	v := sessionView{
+		user:        cc.user,
+		db:          cc.dbname,
+		txConn:      cc.txConn,
+		prepareConn: cc.prepareConn,
+		tempConn:    cc.tempConn,
+	}
+	if cc.ctx != nil {
+		v.sql = cc.ctx.GetSessionVars().Proxy.SQLtext
+	}
+	return v
+}
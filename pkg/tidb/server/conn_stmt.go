@@ -127,11 +127,11 @@ func (cc *clientConn) handleStmtPrepare(ctx context.Context, sql string) error {
 		cc.ctx.GetSessionVars().Proxy.Cost = 0
 	}()
 	cc.setPrepare()
-	conn,err := cc.getBackendConn(cc.server.cluster,true)
+	conn,err := cc.getBackendConn(cc.cluster(),true)
 	if err !=  nil {
 		return err
 	}
-	cc.prepareConn = conn
+	cc.setPrepareConn(conn)
 	defer cc.closeConn(conn, false)
 	if !conn.IsProxySelf() {
 		if conn.GetBindConn() == true {
@@ -145,7 +145,7 @@ func (cc *clientConn) handleStmtPrepare(ctx context.Context, sql string) error {
 			}
 		}
 	}
-	cc.prepareConn = conn
+	cc.setPrepareConn(conn)
 	return nil
 }
 
@@ -244,14 +244,17 @@ func (cc *clientConn) handleStmtExecute(ctx context.Context, data []byte) (err e
 			return errors.Annotate(err, cc.preparedStmt2String(stmtID))
 		}
 	}
-	cc.ctx.GetSessionVars().Proxy.SQLtext = tidbtext.sql
+	cc.setSQLText(tidbtext.sql)
 	cc.ctx.GetSessionVars().Proxy.Cost = 0
 	defer func() {
-		cc.ctx.GetSessionVars().Proxy.SQLtext = ""
+		cc.setSQLText("")
 		cc.ctx.GetSessionVars().Proxy.Cost = 0
 	}()
 
 	preparedStmt := cc.ctx.GetSessionVars().PreparedStmts[stmtID].(*plannercore.CachedPrepareStmt)
+	if err = cc.checkWrites(preparedStmt.PreparedAst.Stmt); err != nil {
+		return err
+	}
 
 	/*	switch preparedStmt.PreparedAst.Stmt.(type) {
 		case *ast.BeginStmt,*ast.CommitStmt,*ast.RollbackStmt:
@@ -260,13 +263,14 @@ func (cc *clientConn) handleStmtExecute(ctx context.Context, data []byte) (err e
 	*/
 
 	est, _ := session.ExecutePreparedStmtForProxy(ctx, tidbtext.ctx.Session, stmtID, args)
+	cc.estimateCost()
 	//fmt.Printf("prepare sql is %s,cost is %f\n", est.Text, tidbtext.ctx.GetSessionVars().Proxy.Cost)
 	switch tidbtext.s.(type) {
 	case *ast.BeginStmt:
 		//fmt.Println("========handleStmtExecute begin1=========",cc.txConn,cc.prepareConn)
 		cc.ctx.GetSessionVars().SetInTxn(true)
 	}
-	conn, err := cc.getBackendConn(cc.server.cluster,true)
+	conn, err := cc.getBackendConn(cc.cluster(),true)
 	if err != nil {
 		//fmt.Errorf("get backend conn failed: %s\n", err)
 		return err
@@ -772,7 +776,7 @@ func (cc *clientConn) handleStmtClose(data []byte) (err error) {
 		if err == nil {
 			stmts := cc.ctx.GetMapStatement()
 			if len(stmts) == 0 {
-				cc.prepareConn = nil
+				cc.setPrepareConn(nil)
 			}
 		}
 	}
@@ -22,6 +22,7 @@ import (
 	//"github.com/pingcap/errors"
 	//"github.com/pingcap/tidb/types"
 	"math"
+	"time"
 	//"strconv"
 	//"strings"
 	"context"
@@ -47,18 +48,21 @@ func init() {
 func (c *clientConn) handlePrepare(ctx context.Context,conn *backend.BackendConn,planstmt *plannercore.CachedPrepareStmt, s *TiDBStatement, args []interface{}) error {
 	var rs *mysql.Result
 	stmtctx := c.ctx.GetSessionVars().StmtCtx
+	start := time.Now()
 	rs, err := c.executeInNode(conn,s,args)
 	if err != nil {
 		return err
 	}
+	c.observeCost(time.Since(start))
+	c.trackStates(rs.SessionStates...)
 
 	if rs.Resultset != nil {
-		err = c.writeResultsetForProxy(ctx, rs.Resultset)
+		err = c.writeResultsetForProxy(ctx, rs.Resultset, c.ctx.GetSessionVars().Status)
 	} else {
 		if stmtctx.InSelectStmt {
 			selectstmt, _ := planstmt.PreparedAst.Stmt.(*ast.SelectStmt)
 			r := c.newEmptyResultsetAst(selectstmt)
-			err = c.writeResultsetForProxy(ctx, r)
+			err = c.writeResultsetForProxy(ctx, r, c.ctx.GetSessionVars().Status)
 		}
 		if stmtctx.InDeleteStmt || stmtctx.InInsertStmt || stmtctx.InUpdateStmt {
 			err = c.writeOK(ctx)
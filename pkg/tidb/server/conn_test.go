@@ -20,15 +20,23 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
+	"time"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/parser/auth"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/terror"
 	"github.com/pingcap/tidb/domain"
 	"github.com/pingcap/tidb/executor"
 	"github.com/pingcap/tidb/kv"
+	plannercore "github.com/pingcap/tidb/planner/core"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	proxymysql "github.com/pingcap/tidb/proxy/mysql"
 	"github.com/pingcap/tidb/session"
 	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/sessionctx/variable"
@@ -607,10 +615,9 @@ func (ts *ConnTestSuite) TestConnExecutionTimeout(c *C) {
 		alloc: arena.NewAllocator(32 * 1024),
 	}
 	srv := &Server{
-		clients: map[uint64]*clientConn{
-			connID: cc,
-		},
+		clients: newClientRegistry(),
 	}
+	srv.clients.add(cc)
 	handle := ts.dom.ExpensiveQueryHandle().SetSessionManager(srv)
 	go handle.Run()
 
@@ -879,3 +886,249 @@ func (ts *ConnTestSuite) TestShowErrors(c *C) {
 	c.Assert(err, NotNil)
 	tk.MustQuery("show errors").Check(testkit.Rows("Error 1051 Unknown table 'test.idontexist'"))
 }
+
+func (ts *ConnTestSuite) TestPDLossReadOnly(c *C) {
+	c.Assert(failpoint.Enable("github.com/pingcap/tidb/server/pdLost", "return(true)"), IsNil)
+	defer func() {
+		c.Assert(failpoint.Disable("github.com/pingcap/tidb/server/pdLost"), IsNil)
+	}()
+	ctx := context.Background()
+	tk := testkit.NewTestKitWithInit(c, ts.store)
+	tk.MustExec("create table pd_loss (a int)")
+	cc := &clientConn{
+		server:         &Server{},
+		ctx:            &TiDBContext{Session: tk.Se, stmts: make(map[int]*TiDBStatement)},
+		pdLossReadOnly: true,
+	}
+	for sql, rejected := range map[string]bool{
+		"insert into pd_loss values (1)": true,
+		"update pd_loss set a = 2":       true,
+		"create table pd_loss2 (a int)":  true,
+		"select * from pd_loss":          false,
+		"set @a = 1":                     false,
+		"use test":                       false,
+		"begin":                          false,
+	} {
+		stmts, err := cc.ctx.Parse(ctx, sql)
+		c.Assert(err, IsNil)
+		err = cc.checkWrites(stmts[0])
+		c.Assert(terror.ErrorEqual(err, errReadOnlyOnPDLoss), Equals, rejected, Commentf("%s: %v", sql, err))
+	}
+	//COM_STMT_EXECUTE checks the statement prepared
+	for sql, rejected := range map[string]bool{
+		"insert into pd_loss values (?)":    true,
+		"delete from pd_loss where a = ?":   true,
+		"select * from pd_loss where a = ?": false,
+	} {
+		stmtID, _, _, err := tk.Se.PrepareStmt(sql)
+		c.Assert(err, IsNil)
+		prepared := tk.Se.GetSessionVars().PreparedStmts[stmtID].(*plannercore.CachedPrepareStmt)
+		err = cc.checkWrites(prepared.PreparedAst.Stmt)
+		c.Assert(terror.ErrorEqual(err, errReadOnlyOnPDLoss), Equals, rejected, Commentf("%s: %v", sql, err))
+	}
+}
+
+func (ts *ConnTestSuite) TestSuspendStateless(c *C) {
+	tk := testkit.NewTestKitWithInit(c, ts.store)
+	cc := &clientConn{
+		server: &Server{cfg: newTestConfig()},
+		ctx:    &TiDBContext{Session: tk.Se, stmts: make(map[int]*TiDBStatement)},
+	}
+	c.Assert(cc.stateless(), IsTrue)
+	//nothing to give back without a bound backend connection
+	c.Assert(cc.canSuspend(), IsFalse)
+
+	tk.MustExec("begin")
+	c.Assert(cc.stateless(), IsFalse)
+	tk.MustExec("commit")
+	tk.MustExec("set autocommit = 0")
+	c.Assert(cc.stateless(), IsFalse)
+	tk.MustExec("set autocommit = 1")
+	c.Assert(cc.stateless(), IsTrue)
+
+	cc.tempTables = map[string]struct{}{"test.t": {}}
+	c.Assert(cc.stateless(), IsFalse)
+	cc.tempTables = nil
+	cc.txConn = &backend.BackendConn{}
+	c.Assert(cc.stateless(), IsFalse)
+	cc.txConn = nil
+	cc.ctx = nil
+	c.Assert(cc.stateless(), IsFalse)
+}
+
+// serveTenant accepts one login on a tenant backend, then answers every command it gets with
+// an ok of 3 affected rows in a transaction until the session closes the conn.
+func serveTenant(l net.Listener, stmt chan<- string, done chan<- error) {
+	conn, err := l.Accept()
+	if err != nil {
+		done <- err
+		return
+	}
+	defer conn.Close()
+	pkg := proxymysql.NewPacketIO(conn)
+	capability := proxymysql.CLIENT_PROTOCOL_41 | proxymysql.CLIENT_SECURE_CONNECTION | proxymysql.CLIENT_PLUGIN_AUTH
+	salt := []byte("0123456789abcdefghij")
+	hs := []byte{0, 0, 0, 0, 10}
+	hs = append(hs, "5.7.25-TiDB-v5.1.0"...)
+	hs = append(hs, 0, 1, 0, 0, 0)
+	hs = append(hs, salt[:8]...)
+	hs = append(hs, 0, byte(capability), byte(capability>>8), 46, 2, 0,
+		byte(capability>>16), byte(capability>>24), 21)
+	hs = append(hs, make([]byte, 10)...)
+	hs = append(hs, salt[8:]...)
+	hs = append(hs, 0)
+	hs = append(hs, "mysql_native_password"...)
+	hs = append(hs, 0)
+	if err = pkg.WritePacket(hs); err != nil {
+		done <- err
+		return
+	}
+	if _, err = pkg.ReadPacket(); err != nil {
+		done <- err
+		return
+	}
+	if err = pkg.WritePacket([]byte{0, 0, 0, 0, proxymysql.OK_HEADER, 0, 0, 2, 0, 0, 0}); err != nil {
+		done <- err
+		return
+	}
+	for {
+		pkg.Sequence = 0
+		query, err := pkg.ReadPacket()
+		if err != nil {
+			//the session closed the conn
+			done <- nil
+			return
+		}
+		stmt <- string(query[1:])
+		if err = pkg.WritePacket([]byte{0, 0, 0, 0, proxymysql.OK_HEADER, 3, 0, 3, 0, 0, 0}); err != nil {
+			done <- err
+			return
+		}
+	}
+}
+
+// tenantSession logs in on the fake tenant backend and returns the backend session kept
+// for the client.
+func tenantSession(c *C, l net.Listener, tenant *backend.Cluster) *backend.BackendConn {
+	relay, err := backend.DialAuthRelay(l.Addr().String(), nil)
+	c.Assert(err, IsNil)
+	c.Assert(relay.Start("tenant_user", nil), IsNil)
+	packet, _, err := relay.Next()
+	c.Assert(packet, IsNil)
+	c.Assert(err, IsNil)
+	co := relay.Session(tenant)
+	//the session owns the conn, closing the relay leaves it open
+	relay.Close()
+	return co
+}
+
+func (ts *ConnTestSuite) TestTenantStmt(c *C) {
+	ctx := context.Background()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	stmt, done := make(chan string, 1), make(chan error, 1)
+	go serveTenant(l, stmt, done)
+	tenant := &backend.Cluster{ProxyNode: &backend.Proxy{Remote: true}}
+	co := tenantSession(c, l, tenant)
+	defer func() {
+		co.Unpin()
+		co.Close()
+	}()
+
+	cfg := newTestConfig()
+	cfg.Proxycfg = &proxyconfig.Config{}
+	var out bytes.Buffer
+	tk := testkit.NewTestKitWithInit(c, ts.store)
+	cc := &clientConn{
+		server:     &Server{cfg: cfg},
+		ctx:        &TiDBContext{Session: tk.Se, stmts: make(map[int]*TiDBStatement)},
+		alloc:      arena.NewAllocator(1024),
+		capability: mysql.ClientProtocol41,
+		pkt:        &packetIO{bufWriter: bufio.NewWriter(&out)},
+		tenant:     tenant,
+		tenantConn: co,
+	}
+	//the tenant database is unknown to the proxy schema, compiling it locally fails
+	const sql = "delete from tenant_db.orders where id < 10"
+	stmts, err := cc.ctx.Parse(ctx, sql)
+	c.Assert(err, IsNil)
+	_, err = cc.handleStmt(ctx, stmts[0], nil, true)
+	c.Assert(err, IsNil)
+	c.Assert(<-stmt, Equals, sql)
+	c.Assert(cc.pkt.bufWriter.Flush(), IsNil)
+	//ok of 3 affected rows with the transaction status of the tenant session
+	c.Assert(out.Bytes()[4:9], DeepEquals, []byte{mysql.OKHeader, 3, 0, 3, 0})
+
+	//a session logged in on the proxy has no identity on the tenant
+	cc.tenant, cc.tenantConn = nil, nil
+	tk.Se.GetSessionVars().CurrentDB = "tenant_db"
+	cc.server.tenants = newTenants()
+	cc.server.tenants.byDB["tenant_db"] = tenant
+	_, err = cc.handleStmt(ctx, stmts[0], nil, true)
+	c.Assert(terror.ErrorEqual(err, errTenantDBDenied), IsTrue, Commentf("%v", err))
+}
+
+func (ts *ConnTestSuite) TestTenantSessionUseDB(c *C) {
+	ctx := context.Background()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+	stmt, done := make(chan string, 1), make(chan error, 1)
+	go serveTenant(l, stmt, done)
+	tenant := &backend.Cluster{ProxyNode: &backend.Proxy{Remote: true}}
+	tk := testkit.NewTestKitWithInit(c, ts.store)
+	cc := &clientConn{
+		server:     &Server{cfg: newTestConfig()},
+		ctx:        &TiDBContext{Session: tk.Se, stmts: make(map[int]*TiDBStatement)},
+		tenant:     tenant,
+		tenantConn: tenantSession(c, l, tenant),
+	}
+	//the tenant database is unknown to the proxy schema, the backend session changes to it
+	c.Assert(cc.useDB(ctx, "tenant_db"), IsNil)
+	c.Assert(<-stmt, Equals, "tenant_db")
+	c.Assert(cc.ctx.GetSessionVars().CurrentDB, Equals, "tenant_db")
+	c.Assert(cc.dbname, Equals, "tenant_db")
+
+	//the backend session is closed with the client session, not pooled
+	cc.ReleasePrepare(ctx)
+	c.Assert(cc.tenantConn, IsNil)
+	select {
+	case err = <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("tenant backend session left open")
+	}
+}
+
+func (ts *ConnTestSuite) TestConnectTenantDB(c *C) {
+	ctx := context.Background()
+	tk := testkit.NewTestKitWithInit(c, ts.store)
+	tk.MustExec("create user if not exists 'tenant_guest'@'%'")
+	defer tk.MustExec("drop user 'tenant_guest'@'%'")
+	tenant := &backend.Cluster{ProxyNode: &backend.Proxy{Remote: true}}
+	tenant.Cfg.ConnectDB = proxyconfig.ConnectDBConfig{Validate: true, AutoCreate: true}
+	connect := func() (*clientConn, error) {
+		guest := testkit.NewTestKitWithInit(c, ts.store)
+		c.Assert(guest.Se.Auth(&auth.UserIdentity{Username: "tenant_guest", Hostname: "%"}, nil, nil), IsTrue)
+		cc := &clientConn{
+			server:   &Server{cfg: newTestConfig(), tenants: newTenants()},
+			ctx:      &TiDBContext{Session: guest.Se, stmts: make(map[int]*TiDBStatement)},
+			user:     "tenant_guest",
+			peerHost: "10.0.0.1",
+		}
+		cc.server.tenants.byDB["tenant_db"] = tenant
+		return cc, cc.useConnectDB(ctx, "tenant_db")
+	}
+	cc, err := connect()
+	c.Assert(err, NotNil)
+	c.Assert(cc.ctx.GetSessionVars().CurrentDB, Equals, "")
+
+	//grants on the proxy say nothing of the tenant, the user of the proxy has no identity
+	//there: the database is neither used nor looked for or created on the tenant backends
+	tk.MustExec("grant create on tenant_db.* to 'tenant_guest'@'%'")
+	cc, err = connect()
+	c.Assert(terror.ErrorEqual(err, errTenantDBDenied), IsTrue, Commentf("%v", err))
+	c.Assert(cc.ctx.GetSessionVars().CurrentDB, Equals, "")
+	c.Assert(cc.dbname, Equals, "")
+}
@@ -52,7 +52,7 @@ func (c *clientConn) cleanPrePare(id uint32) error {
 		c.prepareConn.SetNoDelayFlase()
 		c.closeConn(c.prepareConn,false)
 	}
-	c.prepareConn = nil
+	c.setPrepareConn(nil)
 	return nil
 }
 
@@ -111,7 +111,7 @@ func (c *clientConn) commit() (err error) {
 			co.Close()
 		}
 	}
-	c.txConn = nil
+	c.setTxConn(nil)
 	return
 }
 
@@ -124,7 +124,7 @@ func (c *clientConn) commitInProxy() (err error) {
 			return fmt.Errorf("commitInProxy failed")
 		}
 	}
-	c.txConn = nil
+	c.setTxConn(nil)
 	return
 }
 
@@ -147,7 +147,7 @@ func (c *clientConn) rollback() (err error) {
 			co.Close()
 		}
 	}
-	c.txConn = nil
+	c.setTxConn(nil)
 	return
 }
 
@@ -161,7 +161,7 @@ func (c *clientConn) rollbackInProxy() (err error) {
 			return fmt.Errorf("rollbackInProxy failed")
 		}
 	}
-	c.txConn = nil
+	c.setTxConn(nil)
 	return
 }
 
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/infoschema"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/privilege"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// interval the handshake checks again whether the proxy schema has the database
+const connectDBInterval = 100 * time.Millisecond
+
+// useConnectDB sets the default database of the handshake. A database unknown to the
+// proxy is looked for on the backends of its cluster, and created on one with auto create,
+// before the handshake fails. The proxy schema then gets the time to catch up.
+func (cc *clientConn) useConnectDB(ctx context.Context, db string) error {
+	err := cc.useDB(ctx, db)
+	if err == nil || !infoschema.ErrDatabaseNotExists.Equal(err) {
+		return err
+	}
+	cluster := cc.server.clusterOf(db)
+	if cluster != nil && isTenantCluster(cluster) {
+		//a session logged in on the tenant changed to it on its backend session above,
+		//the others have no identity there, the shared conns of the tenant can't check them
+		return errTenantDBDenied.GenWithStackByArgs(cc.user, cc.peerHost, db)
+	}
+	if cluster == nil || !cluster.Cfg.ConnectDB.Validate {
+		return err
+	}
+	cfg := cluster.Cfg.ConnectDB
+	retries := cfg.Retries
+	if retries <= 0 {
+		retries = proxyconfig.DefaultConnectDBRetries
+	}
+	result := "found"
+	addr, findErr := cluster.FindDatabase(db, retries)
+	if addr == "" {
+		if !cfg.AutoCreate {
+			metrics.ProxyConnectDBCounter.WithLabelValues("missing").Inc()
+			golog.Warn("server", "useConnectDB", "default database not found on backends", 0,
+				"connID", cc.connectionID, "db", db, "error", findErr)
+			return err
+		}
+		//the user creates the database, not the proxy
+		pm := privilege.GetPrivilegeManager(cc.ctx.Session)
+		if pm != nil && !pm.RequestVerification(cc.ctx.GetSessionVars().ActiveRoles, db, "", "", mysql.CreatePriv) {
+			metrics.ProxyConnectDBCounter.WithLabelValues("missing").Inc()
+			golog.Warn("server", "useConnectDB", "user may not create the default database", 0,
+				"connID", cc.connectionID, "db", db, "user", cc.user)
+			return err
+		}
+		var createErr error
+		if addr, createErr = cluster.CreateDatabase(db); createErr != nil {
+			metrics.ProxyConnectDBCounter.WithLabelValues("missing").Inc()
+			golog.Warn("server", "useConnectDB", "create default database failed", 0,
+				"connID", cc.connectionID, "db", db, "addr", addr, "error", createErr)
+			return err
+		}
+		result = "created"
+		golog.Info("server", "useConnectDB", "default database created", 0,
+			"connID", cc.connectionID, "db", db, "addr", addr, "user", cc.user)
+	}
+	wait := time.Duration(cfg.Wait) * time.Second
+	if cfg.Wait <= 0 {
+		wait = proxyconfig.DefaultConnectDBWait * time.Second
+	}
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		time.Sleep(connectDBInterval)
+		if err = cc.useDB(ctx, db); err == nil || !infoschema.ErrDatabaseNotExists.Equal(err) {
+			if err == nil {
+				metrics.ProxyConnectDBCounter.WithLabelValues(result).Inc()
+			}
+			return err
+		}
+	}
+	metrics.ProxyConnectDBCounter.WithLabelValues("timeout").Inc()
+	golog.Warn("server", "useConnectDB", "proxy schema misses the default database of backends", 0,
+		"connID", cc.connectionID, "db", db, "addr", addr, "wait", wait.String())
+	return err
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/tidb/proxy/backend"
+)
+
+// connectionBackend is a backend connection bound to a client session.
+type connectionBackend struct {
+	Role string `json:"role"`
+	Addr string `json:"addr"`
+	Type string `json:"type"`
+}
+
+// connectionInfo is the diagnostics of a client session, across the proxy and its backends.
+type connectionInfo struct {
+	ID       uint64              `json:"id"`
+	User     string              `json:"user"`
+	Host     string              `json:"host"`
+	DB       string              `json:"db"`
+	State    string              `json:"state"`
+	InTxn    bool                `json:"in_txn"`
+	TxnAge   float64             `json:"txn_age_seconds,omitempty"`
+	Age      float64             `json:"age_seconds"`
+	Idle     float64             `json:"idle_seconds"`
+	BytesIn  int64               `json:"bytes_in"`
+	BytesOut int64               `json:"bytes_out"`
+	Digest   string              `json:"digest,omitempty"`
+	Backends []connectionBackend `json:"backends"`
+	// the backend of the idle session was released, it gets one on the next statement
+	Suspended bool `json:"suspended,omitempty"`
+	// negotiated protocol compression
+	Compression      string `json:"compression,omitempty"`
+	CompressionLevel int    `json:"compression_level,omitempty"`
+}
+
+func connState(status int32) string {
+	switch status {
+	case connStatusDispatching:
+		return "executing"
+	case connStatusReading:
+		return "idle"
+	case connStatusShutdown:
+		return "closed"
+	case connStatusWaitShutdown:
+		return "closing"
+	}
+	return "unknown"
+}
+
+func bindBackend(role string, co *backend.BackendConn) connectionBackend {
+	addr := "proxy"
+	if !co.IsProxySelf() {
+		addr = co.GetDbAddr()
+	}
+	return connectionBackend{Role: role, Addr: addr, Type: co.GetDbType()}
+}
+
+// connectionInfo takes the diagnostics of the session without stopping it, from the view
+// of the connection and its atomic counters. The session itself is owned by the connection
+// goroutine, the transaction is known by txnSince.
+func (cc *clientConn) connectionInfo(now time.Time) connectionInfo {
+	v := cc.view()
+	info := connectionInfo{
+		ID:       cc.connectionID,
+		User:     v.user,
+		Host:     cc.peerHost,
+		DB:       v.db,
+		State:    connState(atomic.LoadInt32(&cc.status)),
+		Age:      now.Sub(cc.createTime).Seconds(),
+		Idle:     now.Sub(time.Unix(0, atomic.LoadInt64(&cc.activeTime))).Seconds(),
+		Backends: make([]connectionBackend, 0, 2),
+	}
+	info.Suspended = atomic.LoadInt32(&cc.suspended) == 1
+	if cc.pkt != nil {
+		info.BytesIn = atomic.LoadInt64(&cc.pkt.bytesIn)
+		info.BytesOut = atomic.LoadInt64(&cc.pkt.bytesOut)
+		if compressed := cc.pkt.compressed; compressed != nil {
+			info.Compression = compressed.Algorithm()
+			info.CompressionLevel = compressed.Level()
+		}
+	}
+	txConn, prepareConn, tempConn := v.txConn, v.prepareConn, v.tempConn
+	if txConn != nil {
+		info.Backends = append(info.Backends, bindBackend("txn", txConn))
+	}
+	since := atomic.LoadInt64(&cc.txnSince)
+	if since > 0 {
+		info.TxnAge = now.Sub(time.Unix(0, since)).Seconds()
+	}
+	info.InTxn = txConn != nil || since > 0
+	if prepareConn != nil && prepareConn != txConn {
+		info.Backends = append(info.Backends, bindBackend("prepare", prepareConn))
+	}
+	if tempConn != nil && tempConn != txConn && tempConn != prepareConn {
+		info.Backends = append(info.Backends, bindBackend("temp", tempConn))
+	}
+	if v.sql != "" {
+		_, digest := parser.NormalizeDigest(v.sql)
+		info.Digest = digest.String()
+	}
+	return info
+}
+
+// connectionInfos returns the diagnostics of all client sessions.
+func (s *Server) connectionInfos() []connectionInfo {
+	now := time.Now()
+	conns := s.clients.snapshot()
+	infos := make([]connectionInfo, 0, len(conns))
+	for _, cc := range conns {
+		infos = append(infos, cc.connectionInfo(now))
+	}
+	return infos
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestConnectionInfoWhileRunning takes the diagnostics of a session while its goroutine
+// changes it, run with -race.
+func TestConnectionInfoWhileRunning(t *testing.T) {
+	now := time.Now()
+	cc := &clientConn{connectionID: 1, user: "app", createTime: now, activeTime: now.UnixNano()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			cc.setDBName("test")
+			cc.setPrepareConn(nil)
+		}
+		atomic.StoreInt64(&cc.txnSince, now.UnixNano())
+	}()
+	for i := 0; i < 100; i++ {
+		if info := cc.connectionInfo(now); info.User != "app" {
+			t.Fatalf("expect user app, got %s", info.User)
+		}
+	}
+	<-done
+	info := cc.connectionInfo(now.Add(time.Second))
+	if info.DB != "test" || !info.InTxn || info.TxnAge != 1 {
+		t.Fatalf("unexpected diagnostics %+v", info)
+	}
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/util"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// label of the member leases, valued by the cluster name
+	memberLeaseLabel = "serverless.proxy/member-of"
+	// annotation of a member lease holding its memberState
+	memberStateAnnotation = "serverless.proxy/state"
+)
+
+// memberState is what a proxy replica shares with the others in its member lease.
+type memberState struct {
+	// client connections by limit type and key, see connLimits
+	Conns map[string]map[string]int `json:"conns"`
+	// cost of the last evaluation of every pool, without the cost of the other replicas
+	Cost map[string]int64 `json:"cost"`
+}
+
+// coordinator coordinates the proxy replicas behind one service. The leader elected by a
+// kubernetes lease is the only one sending scale requests, and every replica renews a
+// member lease with its counters, so the connection limits and the scaling cost count the
+// connections and the statements of every replica.
+type coordinator struct {
+	cfg       proxyconfig.CoordinationConfig
+	identity  string
+	namespace string
+	cluster   string
+
+	leader int32
+
+	mu       sync.RWMutex
+	peers    []string
+	peerCost map[string]int64
+}
+
+// newCoordinator returns nil if the coordination is disabled, then the proxy acts alone.
+func newCoordinator(cfg *proxyconfig.Config) *coordinator {
+	if cfg == nil || !cfg.Coordination.Enable {
+		return nil
+	}
+	c := &coordinator{
+		cfg:       cfg.Coordination,
+		namespace: cfg.Cluster.NameSpace,
+		cluster:   cfg.Cluster.ClusterName,
+	}
+	if c.cfg.LeaseDuration <= 0 {
+		c.cfg.LeaseDuration = proxyconfig.DefaultCoordinationLeaseDuration
+	}
+	if c.cfg.RenewDeadline <= 0 {
+		c.cfg.RenewDeadline = proxyconfig.DefaultCoordinationRenewDeadline
+	}
+	if c.cfg.RetryPeriod <= 0 {
+		c.cfg.RetryPeriod = proxyconfig.DefaultCoordinationRetryPeriod
+	}
+	if c.cfg.SyncInterval <= 0 {
+		c.cfg.SyncInterval = proxyconfig.DefaultCoordinationSyncInterval
+	}
+	//the pod name in kubernetes
+	c.identity, _ = os.Hostname()
+	return c
+}
+
+// isLeader reports whether the proxy sends the scale requests, a proxy acting alone does.
+func (c *coordinator) isLeader() bool {
+	return c == nil || atomic.LoadInt32(&c.leader) == 1
+}
+
+func (c *coordinator) setLeader(leader bool) {
+	var v int32
+	if leader {
+		v = 1
+	}
+	if atomic.SwapInt32(&c.leader, v) != v {
+		golog.Info("coordinator", "setLeader", "leadership changed", 0,
+			"identity", c.identity, "leader", leader)
+	}
+	metrics.ProxyLeaderGauge.Set(float64(v))
+}
+
+// peerCostOf returns the cost of the pool taken by the other replicas in their last sync.
+func (c *coordinator) peerCostOf(tidbType string) int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peerCost[tidbType]
+}
+
+func (c *coordinator) leaderLeaseName() string {
+	return c.cluster + "-proxy-leader"
+}
+
+func (c *coordinator) memberLeaseName() string {
+	return c.cluster + "-proxy-" + c.identity
+}
+
+// memberAlive reports whether the member lease was renewed within its duration.
+func memberAlive(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.Before(expiry)
+}
+
+// sumMembers adds up the counters of the other replicas.
+func sumMembers(states []memberState) (map[string]map[string]int, map[string]int64) {
+	conns := map[string]map[string]int{connLimitUser: {}, connLimitHost: {}}
+	cost := make(map[string]int64)
+	for _, st := range states {
+		for ty, keys := range st.Conns {
+			if _, ok := conns[ty]; !ok {
+				continue
+			}
+			for key, n := range keys {
+				conns[ty][key] += n
+			}
+		}
+		for ty, n := range st.Cost {
+			cost[ty] += n
+		}
+	}
+	return conns, cost
+}
+
+// runLeaderElection campaigns for the leader lease until the proxy stops its loops. A
+// proxy outside kubernetes can't coordinate and leads alone.
+func (s *Server) runLeaderElection() {
+	c := s.coord
+	if c == nil {
+		return
+	}
+	if util.KubeClient == nil {
+		golog.Warn("coordinator", "runLeaderElection", "no kubernetes client, the proxy leads alone", 0)
+		c.setLeader(true)
+		return
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: c.leaderLeaseName(), Namespace: c.namespace},
+		Client:     util.KubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.identity},
+	}
+	for {
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   time.Duration(c.cfg.LeaseDuration) * time.Second,
+			RenewDeadline:   time.Duration(c.cfg.RenewDeadline) * time.Second,
+			RetryPeriod:     time.Duration(c.cfg.RetryPeriod) * time.Second,
+			ReleaseOnCancel: true,
+			Name:            c.leaderLeaseName(),
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(context.Context) { c.setLeader(true) },
+				OnStoppedLeading: func() { c.setLeader(false) },
+			},
+		})
+		if err != nil {
+			golog.Error("coordinator", "runLeaderElection", "create leader elector failed", 0,
+				"error", err)
+			return
+		}
+		//Run returns once the leadership is lost, the proxy campaigns again
+		elector.Run(s.loops.ctx)
+		if !s.pause(time.Duration(c.cfg.RetryPeriod) * time.Second) {
+			return
+		}
+	}
+}
+
+// runMembership renews the member lease of the proxy with its counters and takes the
+// counters of the other replicas, until the proxy stops its loops and drops its lease.
+func (s *Server) runMembership() {
+	c := s.coord
+	if c == nil || util.KubeClient == nil {
+		return
+	}
+	leases := util.KubeClient.CoordinationV1().Leases(c.namespace)
+	defer func() {
+		if err := leases.Delete(c.memberLeaseName(), &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			golog.Warn("coordinator", "runMembership", "delete member lease failed", 0, "error", err)
+		}
+	}()
+	for {
+		if err := s.renewMember(); err != nil {
+			golog.Warn("coordinator", "runMembership", "renew member lease failed", 0, "error", err)
+		}
+		if err := s.syncMembers(); err != nil {
+			golog.Warn("coordinator", "runMembership", "sync members failed", 0, "error", err)
+		}
+		if !s.pause(time.Duration(c.cfg.SyncInterval) * time.Second) {
+			return
+		}
+	}
+}
+
+// localState is the memberState of the proxy.
+func (s *Server) localState() memberState {
+	st := memberState{Cost: make(map[string]int64)}
+	if s.connLimits != nil {
+		st.Conns = s.connLimits.counts()
+	}
+	if s.serverless != nil {
+		for _, pool := range s.serverless.States() {
+			st.Cost[pool.Pool] = pool.Cost - pool.PeerCost
+		}
+	}
+	return st
+}
+
+func (s *Server) renewMember() error {
+	c := s.coord
+	state, err := json.Marshal(s.localState())
+	if err != nil {
+		return err
+	}
+	leases := util.KubeClient.CoordinationV1().Leases(c.namespace)
+	//a member is gone after missing two syncs
+	duration := int32(2*c.cfg.SyncInterval + c.cfg.RetryPeriod)
+	now := metav1.NewMicroTime(time.Now())
+	lease, err := leases.Get(c.memberLeaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.memberLeaseName(),
+				Namespace: c.namespace,
+				Labels:    map[string]string{memberLeaseLabel: c.cluster},
+			},
+		}
+		lease.Annotations = map[string]string{memberStateAnnotation: string(state)}
+		lease.Spec = coordinationv1.LeaseSpec{HolderIdentity: &c.identity, LeaseDurationSeconds: &duration, RenewTime: &now}
+		_, err = leases.Create(lease)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string)
+	}
+	lease.Annotations[memberStateAnnotation] = string(state)
+	lease.Spec.HolderIdentity, lease.Spec.LeaseDurationSeconds, lease.Spec.RenewTime = &c.identity, &duration, &now
+	_, err = leases.Update(lease)
+	return err
+}
+
+// syncMembers takes the counters of the other replicas whose member lease is alive.
+func (s *Server) syncMembers() error {
+	c := s.coord
+	list, err := util.KubeClient.CoordinationV1().Leases(c.namespace).List(metav1.ListOptions{
+		LabelSelector: memberLeaseLabel + "=" + c.cluster,
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var peers []string
+	var states []memberState
+	for i := range list.Items {
+		lease := &list.Items[i]
+		if lease.Name == c.memberLeaseName() || !memberAlive(lease, now) {
+			continue
+		}
+		var st memberState
+		if err := json.Unmarshal([]byte(lease.Annotations[memberStateAnnotation]), &st); err != nil {
+			golog.Warn("coordinator", "syncMembers", "invalid member state", 0,
+				"lease", lease.Name, "error", err)
+			continue
+		}
+		peers = append(peers, lease.Name)
+		states = append(states, st)
+	}
+	conns, cost := sumMembers(states)
+	if s.connLimits != nil {
+		s.connLimits.setPeers(conns)
+	}
+	c.mu.Lock()
+	c.peers, c.peerCost = peers, cost
+	c.mu.Unlock()
+	metrics.ProxyPeersGauge.Set(float64(len(peers)))
+	return nil
+}
+
+// CoordinationInfo is the coordination state of the proxy served by /proxy/coordination.
+type CoordinationInfo struct {
+	Identity string           `json:"identity"`
+	Leader   bool             `json:"leader"`
+	Peers    []string         `json:"peers"`
+	PeerCost map[string]int64 `json:"peer_cost"`
+}
+
+func (c *coordinator) info() CoordinationInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	info := CoordinationInfo{
+		Identity: c.identity,
+		Leader:   c.isLeader(),
+		Peers:    append([]string{}, c.peers...),
+		PeerCost: make(map[string]int64, len(c.peerCost)),
+	}
+	for _, ty := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+		info.PeerCost[ty] = c.peerCost[ty]
+	}
+	return info
+}
+
+func (s *Server) handleCoordination(w http.ResponseWriter, req *http.Request) {
+	//a proxy acting alone is its own leader without peers
+	info := CoordinationInfo{Leader: true}
+	if s.coord != nil {
+		info = s.coord.info()
+	}
+	writeData(w, info)
+}
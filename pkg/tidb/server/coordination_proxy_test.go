@@ -0,0 +1,69 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCoordinatorAlone(t *testing.T) {
+	var c *coordinator
+	if !c.isLeader() || c.peerCostOf("tp") != 0 {
+		t.Fatal("a proxy acting alone doesn't lead")
+	}
+	if newCoordinator(&config.Config{}) != nil {
+		t.Fatal("coordinator without coordination enabled")
+	}
+	c = newCoordinator(&config.Config{Coordination: config.CoordinationConfig{Enable: true}})
+	if c.isLeader() {
+		t.Fatal("leader before the election")
+	}
+	if c.cfg.SyncInterval != config.DefaultCoordinationSyncInterval {
+		t.Fatalf("sync interval %d", c.cfg.SyncInterval)
+	}
+}
+
+func TestMemberAlive(t *testing.T) {
+	now := time.Now()
+	duration := int32(10)
+	lease := &coordinationv1.Lease{}
+	if memberAlive(lease, now) {
+		t.Fatal("lease never renewed is alive")
+	}
+	renew := metav1.NewMicroTime(now.Add(-5 * time.Second))
+	lease.Spec.RenewTime, lease.Spec.LeaseDurationSeconds = &renew, &duration
+	if !memberAlive(lease, now) {
+		t.Fatal("lease renewed within its duration is not alive")
+	}
+	if memberAlive(lease, now.Add(6*time.Second)) {
+		t.Fatal("expired lease is alive")
+	}
+}
+
+func TestPeerConnLimits(t *testing.T) {
+	conns, cost := sumMembers([]memberState{
+		{Conns: map[string]map[string]int{connLimitUser: {"app": 2}, connLimitHost: {"10.0.0.1": 1}}, Cost: map[string]int64{"tp": 100}},
+		{Conns: map[string]map[string]int{connLimitUser: {"app": 1}, "unknown": {"x": 1}}, Cost: map[string]int64{"tp": 50, "ap": 7}},
+	})
+	if conns[connLimitUser]["app"] != 3 || conns[connLimitHost]["10.0.0.1"] != 1 || len(conns) != 2 {
+		t.Fatalf("unexpected peer connections %v", conns)
+	}
+	if cost["tp"] != 150 || cost["ap"] != 7 {
+		t.Fatalf("unexpected peer cost %v", cost)
+	}
+
+	l := newConnLimits(&config.Config{MaxUserConnections: 4})
+	l.setPeers(conns)
+	if ty := l.acquire("app", "10.0.0.2"); ty != "" {
+		t.Fatalf("connection under the limit rejected by %s", ty)
+	}
+	if ty := l.acquire("app", "10.0.0.2"); ty != connLimitUser {
+		t.Fatal("connections of the peers not counted against the limit")
+	}
+	if counts := l.counts(); counts[connLimitUser]["app"] != 1 {
+		t.Fatalf("peer connections shared as local ones %v", counts)
+	}
+}
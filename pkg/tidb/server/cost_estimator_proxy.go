@@ -0,0 +1,22 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pingcap/tidb/proxy/estimator"
+)
+
+// estimateCost replaces the plan cost of the statement by the cost of the estimator, the
+// statement is routed and accounted by it. The plan cost is kept to feed back the latency.
+func (cc *clientConn) estimateCost() {
+	sessionVars := cc.ctx.GetSessionVars()
+	cc.planCost = int64(sessionVars.Proxy.Cost)
+	_, digest := sessionVars.StmtCtx.SQLDigest()
+	sessionVars.Proxy.Cost = float64(estimator.Default().Estimate(digest.String(), cc.planCost))
+}
+
+// observeCost feeds back the latency of the statement forwarded to a backend.
+func (cc *clientConn) observeCost(latency time.Duration) {
+	_, digest := cc.ctx.GetSessionVars().StmtCtx.SQLDigest()
+	estimator.Default().Observe(digest.String(), cc.planCost, latency)
+}
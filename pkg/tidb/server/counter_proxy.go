@@ -16,18 +16,23 @@ package server
 
 import (
 	"sync/atomic"
+	"time"
 )
 
 type Counter struct {
 	OldClientQPS    int64
 	OldErrLogTotal  int64
 	OldSlowLogTotal int64
+	//microseconds per statement in the last second
+	OldClientLatency int64
 
 	ClientConns        int64
 	ClientQPS          int64
 	ErrLogTotal        int64
 	SlowLogTotal       int64
 	QuiescentTotalTime int64
+	//microseconds
+	ClientLatency int64
 }
 
 func (counter *Counter) IncrClientConns() {
@@ -46,6 +51,10 @@ func (counter *Counter) IncrClientQPS() {
 	atomic.AddInt64(&counter.ClientQPS, 1)
 }
 
+func (counter *Counter) AddClientLatency(d time.Duration) {
+	atomic.AddInt64(&counter.ClientLatency, d.Microseconds())
+}
+
 func (counter *Counter) IncrErrLogTotal() {
 	atomic.AddInt64(&counter.ErrLogTotal, 1)
 }
@@ -62,9 +71,12 @@ func (counter *Counter) FlushCounter() {
 
 	if counter.ClientQPS == 0 {
 		counter.IncrQuiescentTotalTime()
+		atomic.StoreInt64(&counter.OldClientLatency, 0)
 	} else {
 		atomic.StoreInt64(&counter.QuiescentTotalTime, 0)
+		atomic.StoreInt64(&counter.OldClientLatency, counter.ClientLatency/counter.ClientQPS)
 	}
 
 	atomic.StoreInt64(&counter.ClientQPS, 0)
+	atomic.StoreInt64(&counter.ClientLatency, 0)
 }
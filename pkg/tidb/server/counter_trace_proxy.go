@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// CounterSample is one second of the counter trace, what the autoscaler saw in it.
+type CounterSample struct {
+	Time      int64 `json:"time"`
+	QPS       int64 `json:"qps"`
+	LatencyUs int64 `json:"latency_us"`
+	Conns     int64 `json:"conns"`
+	// cost sent to tp in the second, cost of the running ap statements
+	TPCost int64 `json:"tp_cost"`
+	APCost int64 `json:"ap_cost"`
+	// cost the tp tidbs would leave to the proxy, see pureComputeCost
+	ProxyCost int64   `json:"proxy_cost"`
+	TPCores   float64 `json:"tp_cores"`
+	APCores   float64 `json:"ap_cores"`
+}
+
+// counterTrace appends a sample to the trace file every second.
+type counterTrace struct {
+	file *os.File
+	enc  *json.Encoder
+	//cost sent to tp till the last sample
+	tpSent    uint64
+	tpStarted bool
+}
+
+func openCounterTrace(fileName string) (*counterTrace, error) {
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &counterTrace{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (t *counterTrace) close() {
+	if err := t.file.Close(); err != nil {
+		golog.Warn("server", "counterTrace", "close counter trace failed", 0, "error", err)
+	}
+}
+
+// recordCounterTrace appends the counter flushed in the last second to the counter trace,
+// the trace is turned off after it fails to write.
+func (s *Server) recordCounterTrace(now time.Time) {
+	t := s.counterTrace
+	if t == nil || s.cluster == nil {
+		return
+	}
+	sample := CounterSample{
+		Time:      now.Unix(),
+		QPS:       atomic.LoadInt64(&s.counter.OldClientQPS),
+		LatencyUs: atomic.LoadInt64(&s.counter.OldClientLatency),
+		Conns:     atomic.LoadInt64(&s.counter.ClientConns),
+		ProxyCost: s.pureComputeCost(),
+	}
+	if pool, ok := s.cluster.BackendPools[backend.TiDBForTP]; ok {
+		usage := pool.PeekUsage()
+		//the total drops when a tidb is removed, the second is left out
+		if usage.AddedCost > t.tpSent && t.tpStarted {
+			sample.TPCost = int64(usage.AddedCost - t.tpSent)
+		}
+		t.tpSent, t.tpStarted = usage.AddedCost, true
+		sample.TPCores = usage.Cores
+	}
+	if pool, ok := s.cluster.BackendPools[backend.TiDBForAP]; ok {
+		usage := pool.PeekUsage()
+		sample.APCost, sample.APCores = usage.Cost, usage.Cores
+	}
+	if err := t.enc.Encode(&sample); err != nil {
+		golog.Error("server", "recordCounterTrace", "write counter trace failed, trace stopped", 0, "error", err)
+		t.close()
+		s.counterTrace = nil
+	}
+}
+
+// readCounterTrace reads the samples of a counter trace.
+func readCounterTrace(r io.Reader) ([]CounterSample, error) {
+	var samples []CounterSample
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var sample CounterSample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			return nil, fmt.Errorf("line %d of counter trace: %v", line, err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
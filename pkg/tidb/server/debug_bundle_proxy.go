@@ -0,0 +1,244 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/terror"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/util/printer"
+	"github.com/pingcap/tidb/util/topsql/tracecpu"
+)
+
+const (
+	//seconds of the cpu, mutex and block profiles of a bundle
+	debugBundleSeconds    = 10
+	debugBundleMaxSeconds = 120
+	//nanoseconds of blocking sampled once while a bundle is taken
+	debugBundleBlockRate = 10000
+)
+
+// routing stages of the goroutines, a goroutine is counted in the innermost stage on its
+// stack.
+var routingStages = []struct {
+	name  string
+	frame string
+}{
+	{"backend_io", "/proxy/backend.(*Conn)."},
+	{"ap_queue", "/proxy/backend.(*FairQueue).Acquire("},
+	{"token_wait", "/server.(*clientConn).getTokenForProxy("},
+	{"route", "/server.(*clientConn).routeTidbConn("},
+	{"dispatch", "/server.(*clientConn).dispatch("},
+	{"read_client", "/server.(*clientConn).readPacket("},
+	{"handshake", "/server.(*clientConn).handshake("},
+	{"accept", "/server.(*Server).startNetworkListener("},
+}
+
+// routingSnapshot is the state of the routing subsystem in a debug bundle.
+type routingSnapshot struct {
+	Time        time.Time           `json:"time"`
+	Goroutines  int                 `json:"goroutines"`
+	Stages      map[string]int      `json:"stages"`
+	Connections int                 `json:"connections"`
+	TokenQueue  tokenQueueInfo      `json:"token_queue"`
+	QueueDepths map[string]int64    `json:"queue_depths"`
+	Pools       []backend.PoolState `json:"pools"`
+	Scaling     []PoolScaleState    `json:"scaling,omitempty"`
+	Tenants     []TenantInfo        `json:"tenants,omitempty"`
+}
+
+// goroutineStages counts the goroutines of the stack dump by routing stage.
+func goroutineStages(stacks []byte) (int, map[string]int) {
+	stages := make(map[string]int)
+	total := 0
+	for _, g := range strings.Split(string(stacks), "\n\n") {
+		if !strings.HasPrefix(g, "goroutine ") {
+			continue
+		}
+		total++
+		stage := "other"
+	frames:
+		for _, line := range strings.Split(g, "\n") {
+			if strings.HasPrefix(line, "\t") {
+				continue
+			}
+			for _, st := range routingStages {
+				if strings.Contains(line, st.frame) {
+					stage = st.name
+					break frames
+				}
+			}
+		}
+		stages[stage]++
+	}
+	return total, stages
+}
+
+func allStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+func (s *Server) routingSnapshot() routingSnapshot {
+	snap := routingSnapshot{
+		Time:        time.Now(),
+		Connections: s.ConnectionCount(),
+		TokenQueue:  s.tokenQueueInfo(),
+		QueueDepths: make(map[string]int64),
+		Pools:       s.cluster.PoolStates(),
+		Tenants:     s.tenantInfos(),
+	}
+	snap.Goroutines, snap.Stages = goroutineStages(allStacks())
+	for ty, pool := range s.cluster.BackendPools {
+		snap.QueueDepths[ty] = atomic.LoadInt64(&pool.Queued)
+	}
+	if s.serverless != nil {
+		snap.Scaling = s.serverless.States()
+	}
+	return snap
+}
+
+// redactedProxyConfig returns the proxy config without its passwords and secrets.
+func redactedProxyConfig(cfg *proxyconfig.Config) *proxyconfig.Config {
+	if cfg == nil {
+		return nil
+	}
+	c := *cfg
+	if c.WebPassword != "" {
+		c.WebPassword = "******"
+	}
+	if c.Cluster.Password != "" {
+		c.Cluster.Password = "******"
+	}
+	if c.Cluster.Shadow.Password != "" {
+		c.Cluster.Shadow.Password = "******"
+	}
+	c.Scaler.Webhooks = append([]proxyconfig.WebhookConfig(nil), cfg.Scaler.Webhooks...)
+	for i := range c.Scaler.Webhooks {
+		if c.Scaler.Webhooks[i].Secret != "" {
+			c.Scaler.Webhooks[i].Secret = "******"
+		}
+	}
+	return &c
+}
+
+// debugBundle writes the files of a diagnostics bundle into a tar.gz.
+type debugBundle struct {
+	tw  *tar.Writer
+	now time.Time
+}
+
+func (b *debugBundle) add(name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: b.now}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := b.tw.Write(data)
+	return err
+}
+
+func (b *debugBundle) addJSON(name string, v interface{}) error {
+	js, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return err
+	}
+	return b.add(name, js)
+}
+
+func (b *debugBundle) addProfile(name string, debug int) error {
+	p := rpprof.Lookup(name)
+	if p == nil {
+		return fmt.Errorf("unknown profile %s", name)
+	}
+	var buf bytes.Buffer
+	if err := p.WriteTo(&buf, debug); err != nil {
+		return err
+	}
+	if debug > 0 {
+		name += ".txt"
+	} else {
+		name += ".pb.gz"
+	}
+	return b.add(name, buf.Bytes())
+}
+
+// handleDebugBundle serves a tar.gz for support cases: the routing snapshot taken before
+// and after the profiling window, the goroutine and heap profiles, and the cpu, mutex and
+// block profiles of the window. Mutex and block profiling are raised only for the window.
+// The seconds query parameter sets the window, 10 seconds by default.
+func (s *Server) handleDebugBundle(w http.ResponseWriter, req *http.Request) {
+	sec, err := strconv.Atoi(req.FormValue("seconds"))
+	if err != nil || sec <= 0 {
+		sec = debugBundleSeconds
+	}
+	if sec > debugBundleMaxSeconds {
+		sec = debugBundleMaxSeconds
+	}
+	if !atomic.CompareAndSwapInt32(&s.debugBundleRunning, 0, 1) {
+		serveError(w, http.StatusConflict, "a debug bundle is being taken")
+		return
+	}
+	defer atomic.StoreInt32(&s.debugBundleRunning, 0)
+
+	var cpu bytes.Buffer
+	if err = tracecpu.StartCPUProfile(&cpu); err != nil {
+		serveError(w, http.StatusInternalServerError, fmt.Sprintf("could not enable cpu profiling: %v", err))
+		return
+	}
+	before := s.routingSnapshot()
+	mutexRate := runtime.SetMutexProfileFraction(1)
+	runtime.SetBlockProfileRate(debugBundleBlockRate)
+	sleepWithCtx(req.Context(), time.Duration(sec)*time.Second)
+	err = tracecpu.StopCPUProfile()
+	after := s.routingSnapshot()
+
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="proxy_debug_%s.tar.gz"`, now.Format("20060102150405")))
+	gw := gzip.NewWriter(w)
+	b := &debugBundle{tw: tar.NewWriter(gw), now: now}
+	files := []func() error{
+		func() error { return b.addJSON("routing_before.json", before) },
+		func() error { return b.addJSON("routing_after.json", after) },
+		func() error { return b.add("goroutine_stacks.txt", allStacks()) },
+		func() error { return b.addProfile("goroutine", 1) },
+		func() error { return b.addProfile("heap", 0) },
+		func() error { return b.addProfile("mutex", 0) },
+		func() error { return b.addProfile("block", 0) },
+		func() error { return b.addJSON("config.json", redactedProxyConfig(s.cfg.Proxycfg)) },
+		func() error { return b.add("version.txt", []byte(printer.GetTiDBInfo())) },
+	}
+	if err == nil {
+		files = append(files, func() error { return b.add("cpu.pb.gz", cpu.Bytes()) })
+	}
+	//the profiles of the window are read before the rates are restored
+	defer runtime.SetBlockProfileRate(0)
+	defer runtime.SetMutexProfileFraction(mutexRate)
+	for _, add := range files {
+		if err = add(); err != nil {
+			break
+		}
+	}
+	terror.Log(errors.Trace(err))
+	terror.Log(errors.Trace(b.tw.Close()))
+	terror.Log(errors.Trace(gw.Close()))
+}
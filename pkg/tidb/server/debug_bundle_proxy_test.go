@@ -0,0 +1,58 @@
+package server
+
+import (
+	"testing"
+
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+)
+
+func TestGoroutineStages(t *testing.T) {
+	stacks := `goroutine 1 [IO wait]:
+internal/poll.(*FD).Read(0xc000010000)
+	/usr/local/go/src/internal/poll/fd_unix.go:166 +0x1d5
+github.com/pingcap/tidb/proxy/backend.(*Conn).readPacket(0xc000020000)
+	/src/proxy/backend/backend_conn.go:174 +0x45
+github.com/pingcap/tidb/server.(*clientConn).dispatch(0xc000030000)
+	/src/server/conn.go:1300 +0x100
+
+goroutine 2 [select]:
+github.com/pingcap/tidb/proxy/backend.(*FairQueue).Acquire(0xc000040000)
+	/src/proxy/backend/fairqueue.go:100 +0x80
+github.com/pingcap/tidb/server.(*clientConn).routeTidbConn(0xc000030000)
+	/src/server/conn_query_proxy.go:330 +0x90
+
+goroutine 3 [IO wait]:
+github.com/pingcap/tidb/server.(*clientConn).readPacket(0xc000030000)
+	/src/server/conn.go:450 +0x30
+
+goroutine 4 [sleep]:
+time.Sleep(0x3b9aca00)
+	/usr/local/go/src/runtime/time.go:193 +0xd2
+`
+	total, stages := goroutineStages([]byte(stacks))
+	if total != 4 {
+		t.Fatalf("%d goroutines, want 4", total)
+	}
+	want := map[string]int{"backend_io": 1, "ap_queue": 1, "read_client": 1, "other": 1}
+	for stage, n := range want {
+		if stages[stage] != n {
+			t.Fatalf("stage %s has %d goroutines, want %d: %v", stage, stages[stage], n, stages)
+		}
+	}
+}
+
+func TestRedactedProxyConfig(t *testing.T) {
+	cfg := &proxyconfig.Config{WebPassword: "web"}
+	cfg.Cluster.Password = "db"
+	cfg.Cluster.Shadow.Password = "shadow"
+	cfg.Scaler.Webhooks = []proxyconfig.WebhookConfig{{URL: "http://hook", Secret: "key"}}
+	c := redactedProxyConfig(cfg)
+	if c.WebPassword == "web" || c.Cluster.Password == "db" || c.Cluster.Shadow.Password == "shadow" ||
+		c.Scaler.Webhooks[0].Secret == "key" {
+		t.Fatal("secret left in the bundle config")
+	}
+	if cfg.WebPassword != "web" || cfg.Cluster.Password != "db" || cfg.Cluster.Shadow.Password != "shadow" ||
+		cfg.Scaler.Webhooks[0].Secret != "key" {
+		t.Fatal("proxy config changed")
+	}
+}
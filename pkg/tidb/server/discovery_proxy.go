@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	goerr "errors"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	proxyerrors "github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/util"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+const (
+	defaultEtcdDiscoveryPrefix = "/serverless-proxy/backends"
+	etcdDiscoveryTimeout       = 5 * time.Second
+)
+
+var (
+	errNoKubeClient = goerr.New("kubernetes client is not available")
+	errNoEtcdClient = goerr.New("etcd client is not available")
+)
+
+// Discovery finds the backends of a pool. Discover returns the tidbs as addr@cores
+// separated by commas, the same format as ParseTidbs takes.
+type Discovery interface {
+	Discover(tidbType string) (string, error)
+}
+
+// newDiscovery returns the discovery selected in the cluster config.
+func (s *Server) newDiscovery(cfg *proxyconfig.ClusterConfig) (Discovery, error) {
+	switch cfg.DiscoveryType() {
+	case proxyconfig.DiscoveryK8s:
+		return &k8sDiscovery{cfg: cfg}, nil
+	case proxyconfig.DiscoveryStatic:
+		return &staticDiscovery{cfg: cfg}, nil
+	case proxyconfig.DiscoveryDNS:
+		return &srvDiscovery{cfg: cfg}, nil
+	case proxyconfig.DiscoveryEtcd:
+		d := &etcdDiscovery{s: s, cfg: cfg}
+		if len(cfg.Discovery.EtcdEndpoints) > 0 {
+			cli, err := clientv3.New(clientv3.Config{
+				Endpoints:   cfg.Discovery.EtcdEndpoints,
+				DialTimeout: etcdDiscoveryTimeout,
+			})
+			if err != nil {
+				return nil, err
+			}
+			d.cli = cli
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("unknown discovery type %s", cfg.Discovery.Type)
+}
+
+// k8sDiscovery finds the tidb pods by the labels of the cluster.
+type k8sDiscovery struct {
+	cfg *proxyconfig.ClusterConfig
+}
+
+func (d *k8sDiscovery) Discover(tidbType string) (string, error) {
+	if util.KubeClient == nil {
+		return "", errNoKubeClient
+	}
+	return discoverPool(d.cfg, tidbType)
+}
+
+// withSelf adds the proxy itself to the tp pool like the proxy pod does in kubernetes.
+func withSelf(tidbType string, addrs []string) string {
+	if tidbType == backend.TiDBForTP {
+		addrs = append([]string{"self" + backend.WeightSplit + DefaultProxySize}, addrs...)
+	}
+	return strings.Join(addrs, backend.TidbSplit)
+}
+
+// staticDiscovery takes the tidbs listed in the config.
+type staticDiscovery struct {
+	cfg *proxyconfig.ClusterConfig
+}
+
+func (d *staticDiscovery) Discover(tidbType string) (string, error) {
+	var addrs []string
+	for _, addr := range strings.Split(d.cfg.Discovery.Static[tidbType], backend.TidbSplit) {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return withSelf(tidbType, addrs), nil
+}
+
+// srvDiscovery resolves the DNS SRV record of each pool, the record weight is taken as cores.
+type srvDiscovery struct {
+	cfg *proxyconfig.ClusterConfig
+}
+
+func (d *srvDiscovery) Discover(tidbType string) (string, error) {
+	var addrs []string
+	if name := d.cfg.Discovery.SRV[tidbType]; name != "" {
+		_, records, err := net.LookupSRV("", "", name)
+		if err != nil {
+			return "", err
+		}
+		addrs = srvTidbs(records)
+	}
+	return withSelf(tidbType, addrs), nil
+}
+
+// srvTidbs returns the tidbs of the SRV records as addr@cores.
+func srvTidbs(records []*net.SRV) []string {
+	addrs := make([]string, 0, len(records))
+	for _, r := range records {
+		cores := float64(r.Weight)
+		if cores <= 0 {
+			cores = 1
+		}
+		addr := net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+		addrs = append(addrs, addr+backend.WeightSplit+strconv.FormatFloat(cores, 'f', -1, 64))
+	}
+	return addrs
+}
+
+// etcdDiscovery lists the tidbs registered under prefix/<pool>/<addr>, the value is the cores.
+type etcdDiscovery struct {
+	s   *Server
+	cfg *proxyconfig.ClusterConfig
+	cli *clientv3.Client
+}
+
+func (d *etcdDiscovery) client() *clientv3.Client {
+	if d.cli != nil {
+		return d.cli
+	}
+	if d.s.dom != nil {
+		return d.s.dom.GetEtcdClient()
+	}
+	return nil
+}
+
+func (d *etcdDiscovery) Discover(tidbType string) (string, error) {
+	cli := d.client()
+	if cli == nil {
+		return "", errNoEtcdClient
+	}
+	prefix := d.cfg.Discovery.EtcdPrefix
+	if prefix == "" {
+		prefix = defaultEtcdDiscoveryPrefix
+	}
+	prefix = path.Join(prefix, tidbType) + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdDiscoveryTimeout)
+	defer cancel()
+	resp, err := cli.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return "", err
+	}
+	return withSelf(tidbType, etcdTidbs(prefix, resp.Kvs)), nil
+}
+
+// etcdTidbs returns the tidbs registered under prefix as addr@cores, or addr without cores.
+func etcdTidbs(prefix string, kvs []*mvccpb.KeyValue) []string {
+	var addrs []string
+	for _, kv := range kvs {
+		addr := strings.TrimPrefix(string(kv.Key), prefix)
+		if addr == "" {
+			continue
+		}
+		if cores := strings.TrimSpace(string(kv.Value)); cores != "" {
+			addr += backend.WeightSplit + cores
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// refreshDiscovery keeps the pools in line with the discovery outside kubernetes,
+// where no operator calls the add and delete apis when tidbs come and go.
+func (s *Server) refreshDiscovery() {
+	cfg := &s.cfg.Proxycfg.Cluster
+	interval := time.Duration(cfg.Discovery.RefreshInterval) * time.Second
+	if cfg.DiscoveryType() == proxyconfig.DiscoveryK8s || interval <= 0 {
+		return
+	}
+	for {
+		if !s.pause(interval) || s.inShutdownMode {
+			return
+		}
+		if !s.cluster.Initialized() {
+			continue
+		}
+		for _, tidbType := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+			tidbs, err := s.discovery.Discover(tidbType)
+			if err != nil {
+				golog.Warn("server", "refreshDiscovery", "discover tidbs failed", 0,
+					"tidbtype", tidbType, "error", err)
+				continue
+			}
+			s.syncPool(tidbType, tidbs)
+		}
+	}
+}
+
+// poolDiff returns the discovered tidbs missing in the pool of the cluster and the addrs
+// of the pool tidbs no longer discovered.
+func poolDiff(cluster *backend.Cluster, tidbType, tidbs string) ([]*NewTidb, []string) {
+	pool := cluster.BackendPools[tidbType]
+	existing := make(map[string]bool)
+	pool.RLock()
+	for _, db := range pool.Tidbs {
+		if !db.Self {
+			existing[db.Addr()] = true
+		}
+	}
+	pool.RUnlock()
+	return tidbsDiff(cluster.Cfg.ClusterName, tidbType, tidbs, existing)
+}
+
+// tidbsDiff returns the discovered tidbs not in existing and the existing addrs no longer
+// discovered.
+func tidbsDiff(clusterName, tidbType, tidbs string, existing map[string]bool) ([]*NewTidb, []string) {
+	found := discoveredTidbs(tidbs)
+	var added []*NewTidb
+	for addr, tidb := range found {
+		if !existing[addr] {
+			added = append(added, &NewTidb{Cluster: clusterName, Addr: tidb, TidbType: tidbType})
+		}
+	}
+	var gone []string
+	for addr := range existing {
+		if _, ok := found[addr]; !ok {
+			gone = append(gone, addr)
+		}
+	}
+	return added, gone
+}
+
+// discoveredTidbs returns the discovered tidbs by addr, without the proxy itself.
+func discoveredTidbs(tidbs string) map[string]string {
+	found := make(map[string]string)
+	for _, tidb := range strings.Split(tidbs, backend.TidbSplit) {
+		addr := strings.Split(tidb, backend.WeightSplit)[0]
+		if addr != "" && addr != "self" {
+			found[addr] = tidb
+		}
+	}
+	return found
+}
+
+// addTidbs adds the tidbs, pods unavailable for now are left to the next round.
+func (s *Server) addTidbs(caller, tidbType string, added []*NewTidb) {
+	if len(added) == 0 {
+		return
+	}
+	err := s.cluster.AddTidb(added)
+	switch {
+	case err == nil:
+		golog.Info("server", caller, "add discovered tidb", 0, "tidbtype", tidbType, "count", len(added))
+	case proxyerrors.IsRetriable(err):
+		golog.Warn("server", caller, "add tidb later", 0, "tidbtype", tidbType, "error", err)
+	default:
+		golog.Error("server", caller, "add tidb failed", 0, "tidbtype", tidbType, "error", err)
+	}
+}
+
+// syncPool adds the discovered tidbs missing in the pool and deletes the ones gone. A
+// discovery finding no tidb deletes none, an empty DNS or etcd answer is more likely a
+// failed lookup than a pool gone for good.
+func (s *Server) syncPool(tidbType, tidbs string) {
+	added, gone := poolDiff(s.cluster, tidbType, tidbs)
+	s.addTidbs("syncPool", tidbType, added)
+	if len(gone) == 0 || s.deferInMaintenance(tidbType, gone) {
+		return
+	}
+	if len(discoveredTidbs(tidbs)) == 0 {
+		golog.Warn("server", "syncPool", "discovery found no tidb, keep the pool", 0,
+			"tidbtype", tidbType, "not_discovered", strings.Join(gone, backend.TidbSplit))
+		return
+	}
+	s.deleteGone(tidbType, gone, s.cluster.DeleteTidb)
+}
+
+// deleteGone deletes the tidbs no longer discovered in the background, as every delete
+// waits for the drain of its tidb. A tidb still being deleted is not deleted again.
+func (s *Server) deleteGone(tidbType string, gone []string, deleteTidb func(addr, tidbType string) error) {
+	for _, addr := range gone {
+		key := tidbType + "/" + addr
+		if _, deleting := s.deletingTidbs.LoadOrStore(key, struct{}{}); deleting {
+			continue
+		}
+		golog.Info("server", "syncPool", "delete tidb no longer discovered", 0, "tidbtype", tidbType, "addr", addr)
+		go func(addr, key string) {
+			defer s.deletingTidbs.Delete(key)
+			if err := deleteTidb(addr, tidbType); err != nil {
+				golog.Error("server", "syncPool", "delete tidb failed", 0, "tidbtype", tidbType, "addr", addr, "error", err)
+			}
+		}(addr, key)
+	}
+}
+
+// reconcilePools adds the tidb pods discovered in kubernetes but missing in the pools,
+// the operator only calls the add api once and a pod unavailable at the time would be
+// left out for good. Deleting stays with the operator, divergence is only logged.
+func (s *Server) reconcilePools() {
+	cfg := &s.cfg.Proxycfg.Cluster
+	interval := time.Duration(cfg.Discovery.ReconcileInterval) * time.Second
+	if cfg.Discovery.ReconcileInterval == 0 {
+		interval = proxyconfig.DefaultReconcileInterval * time.Second
+	}
+	if cfg.DiscoveryType() != proxyconfig.DiscoveryK8s || interval <= 0 {
+		return
+	}
+	for {
+		if !s.pause(interval) || s.inShutdownMode {
+			return
+		}
+		if !s.cluster.Initialized() {
+			continue
+		}
+		for _, tidbType := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+			tidbs, err := s.discovery.Discover(tidbType)
+			if err != nil {
+				golog.Debug("server", "reconcilePools", "discover tidbs failed", 0,
+					"tidbtype", tidbType, "error", err)
+				continue
+			}
+			added, gone := poolDiff(s.cluster, tidbType, tidbs)
+			if len(added) == 0 && len(gone) == 0 {
+				continue
+			}
+			missing := make([]string, 0, len(added))
+			for _, tidb := range added {
+				missing = append(missing, tidb.Addr)
+			}
+			golog.Warn("server", "reconcilePools", "pool diverges from discovery", 0,
+				"tidbtype", tidbType, "missing", strings.Join(missing, backend.TidbSplit),
+				"not_discovered", strings.Join(gone, backend.TidbSplit))
+			s.addTidbs("reconcilePools", tidbType, added)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+func TestStaticDiscovery(t *testing.T) {
+	cfg := &proxyconfig.ClusterConfig{}
+	cfg.Discovery.Static = map[string]string{
+		backend.TiDBForTP: " 10.0.0.1:4000@4, ,10.0.0.2:4000",
+		backend.TiDBForAP: "10.0.1.1:4000@8",
+	}
+	d := &staticDiscovery{cfg: cfg}
+	tidbs, err := d.Discover(backend.TiDBForTP)
+	if err != nil || tidbs != "self@"+DefaultProxySize+",10.0.0.1:4000@4,10.0.0.2:4000" {
+		t.Fatalf("tp tidbs %q, %v", tidbs, err)
+	}
+	//only the tp pool has the proxy itself
+	if tidbs, _ = d.Discover(backend.TiDBForAP); tidbs != "10.0.1.1:4000@8" {
+		t.Fatalf("ap tidbs %q", tidbs)
+	}
+}
+
+func TestSRVTidbs(t *testing.T) {
+	addrs := srvTidbs([]*net.SRV{
+		{Target: "tidb-0.tidb.svc.", Port: 4000, Weight: 8},
+		{Target: "tidb-1.tidb.svc", Port: 4001},
+	})
+	//a record without weight counts one core
+	want := []string{"tidb-0.tidb.svc:4000@8", "tidb-1.tidb.svc:4001@1"}
+	if !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("srv tidbs %v, want %v", addrs, want)
+	}
+}
+
+func TestEtcdTidbs(t *testing.T) {
+	prefix := defaultEtcdDiscoveryPrefix + "/tp/"
+	addrs := etcdTidbs(prefix, []*mvccpb.KeyValue{
+		{Key: []byte(prefix + "10.0.0.1:4000"), Value: []byte(" 4 ")},
+		{Key: []byte(prefix + "10.0.0.2:4000")},
+		{Key: []byte(prefix)},
+	})
+	want := []string{"10.0.0.1:4000@4", "10.0.0.2:4000"}
+	if !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("etcd tidbs %v, want %v", addrs, want)
+	}
+}
+
+func TestTidbsDiff(t *testing.T) {
+	existing := map[string]bool{"10.0.0.1:4000": true, "10.0.0.2:4000": true}
+	added, gone := tidbsDiff("c1", backend.TiDBForTP, "self@4.0,10.0.0.2:4000@4,10.0.0.3:4000@2", existing)
+	if len(added) != 1 || *added[0] != (NewTidb{Cluster: "c1", Addr: "10.0.0.3:4000@2", TidbType: backend.TiDBForTP}) {
+		t.Fatalf("added %+v", added)
+	}
+	if !reflect.DeepEqual(gone, []string{"10.0.0.1:4000"}) {
+		t.Fatalf("gone %v", gone)
+	}
+
+	//the proxy alone is found when the lookup came back empty
+	added, gone = tidbsDiff("c1", backend.TiDBForTP, "self@4.0", existing)
+	sort.Strings(gone)
+	if len(added) != 0 || len(gone) != 2 || len(discoveredTidbs("self@4.0")) != 0 {
+		t.Fatalf("empty discovery: added %+v, gone %v", added, gone)
+	}
+}
+
+func TestDeleteGone(t *testing.T) {
+	s := &Server{}
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	deleteTidb := func(addr, tidbType string) error {
+		mu.Lock()
+		calls[tidbType+"/"+addr]++
+		mu.Unlock()
+		<-release
+		return nil
+	}
+	//the deletes wait for the drain in the background, a tidb being deleted is not
+	//deleted again by the next round
+	start := time.Now()
+	s.deleteGone(backend.TiDBForTP, []string{"10.0.0.1:4000", "10.0.0.2:4000"}, deleteTidb)
+	s.deleteGone(backend.TiDBForTP, []string{"10.0.0.1:4000"}, deleteTidb)
+	s.deleteGone(backend.TiDBForAP, []string{"10.0.0.1:4000"}, deleteTidb)
+	if time.Since(start) > time.Second {
+		t.Fatal("deletes blocked the sync")
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pending := 0
+		s.deletingTidbs.Range(func(interface{}, interface{}) bool {
+			pending++
+			return true
+		})
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d deletes still running", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{"tp/10.0.0.1:4000": 1, "tp/10.0.0.2:4000": 1, "ap/10.0.0.1:4000": 1}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("deletes %v, want %v", calls, want)
+	}
+}
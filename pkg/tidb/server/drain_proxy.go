@@ -0,0 +1,75 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"github.com/pingcap/parser/mysql"
+	proxyerrors "github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// forceRollback asks the client connections with a transaction open on the removed tidb to
+// roll it back, so the pod doesn't linger in terminating holding locks. It returns the
+// number of connections asked.
+func (s *Server) forceRollback(addr string) int {
+	var count int
+	for _, cc := range s.clients.snapshot() {
+		co := cc.getTxConn()
+		if co == nil || co.IsProxySelf() || co.GetDbAddr() != addr {
+			continue
+		}
+		atomic.StoreInt32(&cc.removedRollback, 1)
+		count++
+	}
+	if count > 0 {
+		golog.Warn("server", "forceRollback", "roll back transactions on removed tidb", 0,
+			"addr", addr, "connections", count)
+	}
+	return count
+}
+
+// rollbackRemovedTxn rolls back the transaction on the removed tidb, the caller holds the
+// connection while the client is idle.
+func (cc *clientConn) rollbackRemovedTxn() {
+	atomic.StoreInt32(&cc.removedRollback, 0)
+	cc.abortTxn(proxyerrors.ErrTidbRemoved)
+}
+
+// abortTxn rolls back the transaction of the idle session on its backend, the first
+// statement after it fails with reason. The caller holds the connection.
+func (cc *clientConn) abortTxn(reason error) {
+	co := cc.txConn
+	if co == nil || co.IsProxySelf() {
+		return
+	}
+	if err := co.Rollback(); err != nil {
+		golog.Warn("server", "abortTxn", "rollback failed", 0,
+			"connID", cc.connectionID, "addr", co.GetDbAddr(), "reason", reason, "error", err)
+	}
+	co.SetNoDelayFlase()
+	co.Close()
+	if cc.prepareConn == co {
+		cc.setPrepareConn(nil)
+	}
+	cc.setTxConn(nil)
+	cc.ctx.GetSessionVars().SetInTxn(false)
+	atomic.StoreInt64(&cc.txnSince, 0)
+	cc.abortedTxn = reason
+	golog.Info("server", "abortTxn", "transaction rolled back", 0,
+		"connID", cc.connectionID, "addr", co.GetDbAddr(), "reason", reason)
+}
+
+// abortedTxnError fails the first statement after the transaction was rolled back,
+// so the client learns its transaction is gone instead of running the rest outside it.
+func (cc *clientConn) abortedTxnError(data []byte) error {
+	if cc.abortedTxn == nil || len(data) == 0 {
+		return nil
+	}
+	switch data[0] {
+	case mysql.ComQuery, mysql.ComStmtExecute:
+		err := cc.abortedTxn
+		cc.abortedTxn = nil
+		return err
+	}
+	return nil
+}
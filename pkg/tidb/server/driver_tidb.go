@@ -266,6 +266,11 @@ func (tc *TiDBContext) GotStmtCostForProxy(ctx context.Context, stmt ast.StmtNod
 	return session.ExecuteStmtForProxy(ctx, tc.Session, stmt)
 }
 
+// ResetStmtForProxy readies the session for a statement forwarded without its plan.
+func (tc *TiDBContext) ResetStmtForProxy(ctx context.Context, stmt ast.StmtNode) error {
+	return session.ResetStmtForProxy(ctx, tc.Session, stmt)
+}
+
 func (tc *TiDBContext) ExecStmtForProxy(ctx context.Context, stmt sqlexec.Statement) (ResultSet, error) {
 
 	rs, err := session.RunStmtForProxy(ctx, tc.Session, stmt)
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// errorContextSeq numbers the backend failures given a correlation id.
+var errorContextSeq uint64
+
+// errorContext is the routing context added to the error of a statement whose backend
+// failed mid-query.
+type errorContext struct {
+	pod string
+	id  string
+}
+
+func (e *errorContext) suffix() string {
+	return fmt.Sprintf(" [backend %s, id %s]", e.pod, e.id)
+}
+
+func (cc *clientConn) errorContextOn() bool {
+	return cc.server != nil && cc.server.cfg.Proxycfg != nil && cc.server.cfg.Proxycfg.ErrorContext
+}
+
+// noteBackendFailure keeps the routing context of the statement when its backend hit a
+// connection error or a fault code, and logs it under the same correlation id.
+func (cc *clientConn) noteBackendFailure(conn *backend.BackendConn, sql string, err error) {
+	cc.errorContext = nil
+	if err == nil || conn.IsProxySelf() || !conn.Faulted() || !cc.errorContextOn() {
+		return
+	}
+	ec := &errorContext{
+		pod: conn.GetPodName(),
+		id:  fmt.Sprintf("%d-%d", cc.connectionID, atomic.AddUint64(&errorContextSeq, 1)),
+	}
+	_, digest := parser.NormalizeDigest(sql)
+	golog.Warn("server", "noteBackendFailure", "backend failed mid-query", 0,
+		"id", ec.id, "connID", cc.connectionID, "user", cc.user, "backend", conn.GetDbAddr(),
+		"pool", conn.GetDbType(), "digest", digest.String(), "error", err.Error())
+	cc.errorContext = ec
+}
+
+// withErrorContext appends the routing context of the failed statement to its error once.
+func (cc *clientConn) withErrorContext(m *mysql.SQLError) *mysql.SQLError {
+	ec := cc.errorContext
+	if ec == nil {
+		return m
+	}
+	cc.errorContext = nil
+	return &mysql.SQLError{Code: m.Code, State: m.State, Message: m.Message + ec.suffix()}
+}
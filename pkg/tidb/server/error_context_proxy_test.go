@@ -0,0 +1,27 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser/mysql"
+)
+
+func TestWithErrorContext(t *testing.T) {
+	cc := &clientConn{}
+	m := &mysql.SQLError{Code: mysql.ErrUnknown, State: "08S01", Message: "lost connection to TP backend"}
+	if got := cc.withErrorContext(m); got != m {
+		t.Fatal("expect the error unchanged without a failed backend")
+	}
+
+	cc.errorContext = &errorContext{pod: "cluster-tidb-1", id: "7-3"}
+	got := cc.withErrorContext(m)
+	if got.Message != "lost connection to TP backend [backend cluster-tidb-1, id 7-3]" || got.Code != m.Code || got.State != m.State {
+		t.Fatalf("unexpected error %+v", got)
+	}
+	if m.Message != "lost connection to TP backend" {
+		t.Fatal("the original error was modified")
+	}
+	if again := cc.withErrorContext(m); again != m || cc.errorContext != nil {
+		t.Fatal("expect the context added once")
+	}
+}
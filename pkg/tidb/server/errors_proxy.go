@@ -0,0 +1,104 @@
+package server
+
+import (
+	goerr "errors"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyerrors "github.com/pingcap/tidb/proxy/core/errors"
+	proxymysql "github.com/pingcap/tidb/proxy/mysql"
+)
+
+const (
+	// SQLSTATE class 08 is treated as a transient connection exception by most drivers.
+	proxyUnavailableState  = "08S01"
+	// SQLSTATE 40001 tells drivers the transaction was rolled back and can be retried.
+	proxyRollbackState = "40001"
+	defaultProxyRetryAfter = 10
+)
+
+// proxyRetryAfter is the retry hint in seconds, it follows the interval of scale out requests.
+func (cc *clientConn) proxyRetryAfter() int {
+	if cc.server != nil && cc.server.cfg.Proxycfg != nil && cc.server.cfg.Proxycfg.Cluster.ResendForScaleOUT > 0 {
+		return cc.server.cfg.Proxycfg.Cluster.ResendForScaleOUT
+	}
+	return defaultProxyRetryAfter
+}
+
+// proxySQLError converts errors raised by the proxy or its backends into mysql errors.
+// It returns nil if the error is not recognized.
+func (cc *clientConn) proxySQLError(e error) *mysql.SQLError {
+	var sqlErr *proxymysql.SqlError
+	if goerr.As(e, &sqlErr) {
+		// errors returned by backend tidb keep their own code and state.
+		return &mysql.SQLError{Code: sqlErr.Code, State: sqlErr.State, Message: sqlErr.Message}
+	}
+
+	pool := "backend"
+	var poolErr *proxyerrors.PoolError
+	if goerr.As(e, &poolErr) {
+		switch poolErr.Pool {
+		case backend.TiDBForTP, backend.TiDBForAP:
+			pool = strings.ToUpper(poolErr.Pool) + " backend"
+		}
+		e = poolErr.Err
+	}
+	retryAfter := cc.proxyRetryAfter()
+
+	switch e {
+	case proxyerrors.ErrNoTidbDB, proxyerrors.ErrTidbDown, proxyerrors.ErrAllDatabaseDown, proxyerrors.ErrNoTidbConn:
+		return &mysql.SQLError{
+			Code:  mysql.ErrUnknown,
+			State: proxyUnavailableState,
+			Message: fmt.Sprintf("no healthy %s, cluster scaling in progress, retry in %ds (retryable: true)",
+				pool, retryAfter),
+		}
+	case proxyerrors.ErrClusterInitializing:
+		return &mysql.SQLError{
+			Code:    mysql.ErrUnknown,
+			State:   proxyUnavailableState,
+			Message: fmt.Sprintf("cluster initializing, backends are not ready yet, retry in %ds (retryable: true)", retryAfter),
+		}
+	case proxyerrors.ErrTidbRemoved:
+		return &mysql.SQLError{
+			Code:    mysql.ErrLockDeadlock,
+			State:   proxyRollbackState,
+			Message: "transaction rolled back, node removed by autoscaler, retry the transaction (retryable: true)",
+		}
+	case proxyerrors.ErrIdleTxnKilled:
+		return &mysql.SQLError{
+			Code:    mysql.ErrLockDeadlock,
+			State:   proxyRollbackState,
+			Message: "transaction rolled back, idle in transaction longer than the proxy allows, retry the transaction (retryable: true)",
+		}
+	case proxyerrors.ErrGetConnTimeout:
+		return &mysql.SQLError{
+			Code:  mysql.ErrConCount,
+			State: mysql.MySQLState[mysql.ErrConCount],
+			Message: fmt.Sprintf("%s connection pool exhausted, cluster scaling in progress, retry in %ds (retryable: true)",
+				pool, retryAfter),
+		}
+	case proxyerrors.ErrConnIsNil, proxyerrors.ErrBadConn, proxymysql.ErrBadConn:
+		return &mysql.SQLError{
+			Code:    mysql.ErrUnknown,
+			State:   proxyUnavailableState,
+			Message: fmt.Sprintf("lost connection to %s, retry the statement (retryable: true)", pool),
+		}
+	case proxyerrors.ErrLoadDataTooLarge:
+		return &mysql.SQLError{
+			Code:  mysql.ErrNetPacketTooLarge,
+			State: mysql.MySQLState[mysql.ErrNetPacketTooLarge],
+			Message: fmt.Sprintf("LOAD DATA file exceeds max_load_data_size of %dMB (retryable: false)",
+				cc.server.cfg.Proxycfg.MaxLoadDataSize),
+		}
+	case proxyerrors.ErrCmdUnsupport:
+		return &mysql.SQLError{
+			Code:    mysql.ErrNotSupportedYet,
+			State:   mysql.MySQLState[mysql.ErrNotSupportedYet],
+			Message: "command is not supported by proxy (retryable: false)",
+		}
+	}
+	return nil
+}
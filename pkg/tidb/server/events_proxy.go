@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/terror"
+	"github.com/pingcap/tidb/proxy/events"
+)
+
+const (
+	// events a client of /proxy/events may lag behind before it is disconnected
+	eventsBuffer = 64
+	// interval of the comments keeping an idle event stream open through proxies
+	eventsHeartbeat = 15 * time.Second
+)
+
+// publishConfigChange tells the event stream a runtime config change, kind is what changed
+// and op how.
+func publishConfigChange(kind, op string, detail map[string]interface{}) {
+	if detail == nil {
+		detail = make(map[string]interface{}, 2)
+	}
+	detail["kind"], detail["op"] = kind, op
+	events.Default().Publish(events.Event{Type: events.ConfigChanged, Detail: detail})
+}
+
+// publishScaleDecision tells the event stream the reconcile changed the size of the pool,
+// the rounds keeping it are left out.
+func (s *Server) publishScaleDecision(st *PoolScaleState) {
+	switch st.Action {
+	case actionScaleOut, actionScaleIn, actionSetCores, actionStepwise, actionScaleInDenied:
+	default:
+		return
+	}
+	events.Default().Publish(events.Event{
+		Type:    events.ScaleDecision,
+		Cluster: s.cluster.Cfg.ClusterName,
+		Pool:    st.Pool,
+		Detail: map[string]interface{}{
+			"action":  st.Action,
+			"policy":  st.Policy,
+			"actual":  st.Actual,
+			"desired": st.Desired,
+		},
+	})
+}
+
+// handleEvents streams the topology, scaling and config events as server-sent events. A
+// client resuming with Last-Event-ID first gets the kept events it missed.
+func (s *Server) handleEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte("streaming unsupported"))
+		terror.Log(errors.Trace(err))
+		return
+	}
+	var lastID uint64
+	if v := req.Header.Get("Last-Event-ID"); v != "" {
+		lastID, _ = strconv.ParseUint(v, 10, 64)
+	}
+	sub, missed := events.Default().Subscribe(eventsBuffer, lastID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range missed {
+		if writeEvent(w, e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	var done <-chan struct{}
+	if s.loops != nil {
+		done = s.loops.ctx.Done()
+	}
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			//a subscriber too slow is dropped, it resumes by Last-Event-ID
+			if !ok {
+				return
+			}
+			if writeEvent(w, e) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		case <-done:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e events.Event) error {
+	js, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, js)
+	return err
+}
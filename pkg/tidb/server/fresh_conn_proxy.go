@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/proxy/mysql"
+	"github.com/pingcap/tidb/proxy/stats"
+)
+
+// isAdminStmt reports whether the statement is a DDL, ANALYZE or ADMIN statement.
+func isAdminStmt(stmt ast.StmtNode) bool {
+	switch stmt.(type) {
+	case ast.DDLNode, *ast.AnalyzeTableStmt, *ast.AdminStmt:
+		return true
+	}
+	return false
+}
+
+// usesFreshConn reports whether the statement is forwarded over a fresh backend conn, by
+// serverless_fresh_conn of the session, or by admin_conn of the cluster if it is auto.
+// Temporary tables live on the session conn and transactions keep their conn.
+func (cc *clientConn) usesFreshConn(stmt ast.StmtNode) bool {
+	vars := cc.ctx.GetSessionVars()
+	if !vars.Proxy.Userquery || vars.InTxn() || !isAdminStmt(stmt) || cc.isTempTableStmt(stmt) {
+		return false
+	}
+	switch vars.Proxy.FreshConn {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	return cc.cluster().Cfg.AdminConn.Fresh
+}
+
+// handleFreshConnStmt runs the statement over a conn dialed for it, handled is false when
+// no tidb is up and the proxy runs the statement itself.
+func (cc *clientConn) handleFreshConnStmt(ctx context.Context, stmt ast.StmtNode, lastStmt bool) (handled bool, err error) {
+	cluster := cc.cluster()
+	conn, err := cluster.GetFreshConn(cluster.Cfg.AdminConn.Backend)
+	if err != nil || conn == nil {
+		return err != nil, err
+	}
+	defer conn.Close()
+	if err = cc.connSet(conn); err != nil {
+		return true, err
+	}
+	s := &TiDBStatement{sql: stmt.Text()}
+	start := time.Now()
+	rs, err := cc.executeInNode(conn, s, nil)
+	cc.captureStmt(conn, s.sql, start, rs, err)
+	stats.DefaultUsers().AddStmt(cc.user, conn.GetDbType(), time.Since(start))
+	if err != nil {
+		return true, err
+	}
+	if rs == nil {
+		return true, mysql.NewError(mysql.ER_UNKNOWN_ERROR, "result is empty")
+	}
+	sessionVars := cc.ctx.GetSessionVars()
+	sessionVars.StmtCtx.AddAffectedRows(rs.AffectedRows)
+	cc.trackStates(rs.SessionStates...)
+	cc.appendRoutingNote(conn)
+
+	status := cc.proxyStatus(lastStmt)
+	if rs.Resultset != nil {
+		return true, cc.writeResultsetForProxy(ctx, rs.Resultset, status)
+	}
+	return true, cc.writeOkWith(ctx, cc.ctx.LastMessage(), cc.ctx.AffectedRows(), cc.ctx.LastInsertID(), status, cc.ctx.WarningCount())
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser"
+)
+
+func TestIsAdminStmt(t *testing.T) {
+	p := parser.New()
+	for sql, admin := range map[string]bool{
+		"create table t2 (a int)":       true,
+		"alter table t add index i (a)": true,
+		"drop database d":               true,
+		"analyze table t":               true,
+		"admin check table t":           true,
+		"admin show ddl jobs":           true,
+		"select * from t":               false,
+		"insert into t values (1)":      false,
+		"set @a = 1":                    false,
+		"show create table t":           false,
+	} {
+		stmt, err := p.ParseOneStmt(sql, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isAdminStmt(stmt); got != admin {
+			t.Errorf("expect admin %v for %q, got %v", admin, sql, got)
+		}
+	}
+}
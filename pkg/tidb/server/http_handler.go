@@ -106,6 +106,11 @@ func writeError(w http.ResponseWriter, err error) {
 }
 
 func writeData(w http.ResponseWriter, data interface{}) {
+	writeDataStatus(w, http.StatusOK, data)
+}
+
+// writeDataStatus writes the data as json with the status code.
+func writeDataStatus(w http.ResponseWriter, status int, data interface{}) {
 	js, err := json.MarshalIndent(data, "", " ")
 	if err != nil {
 		writeError(w, err)
@@ -113,7 +118,7 @@ func writeData(w http.ResponseWriter, data interface{}) {
 	}
 	// write response
 	w.Header().Set(headerContentType, contentTypeJSON)
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	_, err = w.Write(js)
 	terror.Log(errors.Trace(err))
 }
@@ -40,6 +40,12 @@ import (
 	"github.com/pingcap/parser/terror"
 	"github.com/pingcap/tidb/config"
 	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	proxyerrors "github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/replay"
+	"github.com/pingcap/tidb/proxy/scaler"
+	"github.com/pingcap/tidb/proxy/stats"
 	"github.com/pingcap/tidb/util"
 	"github.com/pingcap/tidb/util/logutil"
 	"github.com/pingcap/tidb/util/printer"
@@ -113,8 +119,37 @@ func (s *Server) startHTTPServer() {
 	router.HandleFunc("/api/v1/clusters/sldb/Tidbs", s.AddTidb).Name("addTidbs").Methods("POST")
 	router.HandleFunc("/api/v1/clusters/deltidb", s.DeleteOneTidb).Name("deleteTidbs").Methods("POST")
 	router.HandleFunc("/api/v1/clusters/status/{tidbtype}", s.GetClustersStatus).Name("getClustersStatus").Methods("GET")
+	router.HandleFunc("/api/v1/clusters/rebalance", s.RebalanceWeights).Name("rebalanceWeights").Methods("POST")
+	router.HandleFunc("/api/v1/clusters/costs", s.GetClusterCosts).Name("getClusterCosts").Methods("GET")
+	router.HandleFunc("/proxy/serverless", s.handleServerless).Name("Serverless").Methods("GET")
+	router.HandleFunc("/proxy/scaler/health", s.handleScalerHealth).Name("ScalerHealth").Methods("GET")
+	router.HandleFunc("/proxy/scaler/calls", s.handleScalerCalls).Name("ScalerCalls").Methods("GET")
+	router.HandleFunc("/proxy/pools", s.handlePools).Name("Pools").Methods("GET")
+	router.HandleFunc("/proxy/pools/{type}/{op:cordon|uncordon|drain}", s.handlePoolOp).Name("PoolOp").Methods("POST")
+	router.HandleFunc("/proxy/connections", s.handleConnections).Name("Connections").Methods("GET")
+	router.HandleFunc("/proxy/read-only", s.handleReadOnly).Name("ReadOnly").Methods("GET", "POST")
+	router.HandleFunc("/proxy/top-queries", s.handleTopQueries).Name("TopQueries").Methods("GET")
+	router.HandleFunc("/proxy/user-stats", s.handleUserStats).Name("UserStats").Methods("GET")
+	router.HandleFunc("/proxy/tenants", s.handleTenants).Name("Tenants").Methods("GET")
+	router.HandleFunc("/proxy/scale-metrics", s.handleScaleMetrics).Name("ScaleMetrics").Methods("GET")
+	router.HandleFunc("/proxy/origin-report", s.handleOriginReport).Name("OriginReport").Methods("GET")
+	router.HandleFunc("/proxy/events", s.handleEvents).Name("Events").Methods("GET")
+	router.HandleFunc("/proxy/shadow", s.handleShadow).Name("Shadow").Methods("GET")
+	router.HandleFunc("/proxy/coordination", s.handleCoordination).Name("Coordination").Methods("GET")
+	router.HandleFunc("/proxy/long-txns", s.handleLongTxns).Name("LongTxns").Methods("GET")
+	router.HandleFunc("/proxy/auth/failures", s.handleAuthFailures).Name("AuthFailures").Methods("GET")
+	router.HandleFunc("/proxy/debug/bundle", s.handleDebugBundle).Name("DebugBundle").Methods("GET")
+	router.HandleFunc("/proxy/token-queue", s.handleTokenQueue).Name("TokenQueue").Methods("GET")
+	router.HandleFunc("/proxy/ap-queue", s.handleAPQueue).Name("APQueue").Methods("GET")
+	router.HandleFunc("/proxy/connections/{id}", s.handleConnection).Name("Connection").Methods("GET")
+	router.HandleFunc("/api/v1/capture", s.Capture).Name("capture").Methods("POST")
+	router.HandleFunc("/api/v1/replay", s.StartReplay).Name("startReplay").Methods("POST")
+	router.HandleFunc("/api/v1/replay", s.GetReplay).Name("getReplay").Methods("GET")
+	router.HandleFunc("/api/v1/listener", s.Rebind).Name("rebind").Methods("POST")
+	router.HandleFunc("/proxy/prestop", s.handlePreStop).Name("PreStop").Methods("GET", "POST")
 
 	router.HandleFunc("/status", s.handleStatus).Name("Status")
+	router.HandleFunc("/schema_version", s.handleSchemaVersion).Name("SchemaVersion")
 	// HTTP path for prometheus.
 	router.Handle("/metrics", promhttp.Handler()).Name("Metrics")
 
@@ -307,15 +342,24 @@ func (s *Server) startHTTPServer() {
 	fetcher := sqlInfoFetcher{store: tikvHandlerTool.Store}
 	serverMux.HandleFunc("/debug/sub-optimal-plan", fetcher.zipInfoForSQL)
 
-	// failpoint is enabled only for tests so we can add some http APIs here for tests.
-	failpoint.Inject("enableTestAPI", func() {
+	handleFailpoints := func() {
 		serverMux.HandleFunc("/fail/", func(w http.ResponseWriter, r *http.Request) {
 			r.URL.Path = strings.TrimPrefix(r.URL.Path, "/fail")
 			new(failpoint.HttpHandler).ServeHTTP(w, r)
 		})
+	}
+	// failpoint is enabled only for tests so we can add some http APIs here for tests.
+	testAPI := false
+	failpoint.Inject("enableTestAPI", func() {
+		testAPI = true
+		handleFailpoints()
 
 		router.Handle("/test/{mod}/{op}", &testHandler{tikvHandlerTool, 0})
 	})
+	// chaos experiments inject proxy failures into a binary built with failpoints enabled.
+	if !testAPI && s.cfg.Proxycfg != nil && s.cfg.Proxycfg.EnableFailpoints {
+		handleFailpoints()
+	}
 
 	// ddlHook is enabled only for tests so we can substitute the callback in the DDL.
 	router.Handle("/test/ddl/hook", &ddlHookHandler{tikvHandlerTool.Store.(kv.Storage)})
@@ -404,9 +448,15 @@ func (s *Server) setCNChecker(tlsConfig *tls.Config) *tls.Config {
 
 // status of TiDB.
 type status struct {
-	Connections int    `json:"connections"`
-	Version     string `json:"version"`
-	GitHash     string `json:"git_hash"`
+	Connections  int    `json:"connections"`
+	Version      string `json:"version"`
+	GitHash      string `json:"git_hash"`
+	ShuttingDown bool   `json:"shutting_down"`
+	//old listeners still accepting after a rebind
+	Draining []string `json:"draining,omitempty"`
+	//lost the connection to pd, degraded means sessions are kept under the degrade policy
+	PDLost   bool `json:"pd_lost,omitempty"`
+	Degraded bool `json:"degraded,omitempty"`
 }
 
 func (s *Server) handleStatus(w http.ResponseWriter, req *http.Request) {
@@ -416,21 +466,140 @@ func (s *Server) handleStatus(w http.ResponseWriter, req *http.Request) {
 	// acquires a lock that may already be held by the shutdown process.
 	if s.inShutdownMode {
 		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte(`{"shutting_down":true}`))
+		terror.Log(errors.Trace(err))
 		return
 	}
 	st := status{
 		Connections: s.ConnectionCount(),
 		Version:     mysql.ServerVersion,
 		GitHash:     versioninfo.TiDBGitHash,
+		Draining:    s.drainingAddrs(),
+		PDLost:      s.pdLost(),
+	}
+	st.Degraded = st.PDLost && s.degradeOnPDLoss()
+	writeData(w, st)
+}
+
+func (s *Server) handleSchemaVersion(w http.ResponseWriter, req *http.Request) {
+	if s.dom == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	writeData(w, schemaVersionInfo{SchemaVersion: s.dom.InfoSchema().SchemaMetaVersion()})
+}
+
+// handleScalerHealth reports the connectivity to the scale operator.
+func (s *Server) handleScalerHealth(w http.ResponseWriter, req *http.Request) {
+	health := scaler.Default().Health()
+	status := http.StatusOK
+	if !health.Connected {
+		status = http.StatusServiceUnavailable
+	}
+	writeDataStatus(w, status, health)
+}
+
+// handleScalerCalls lists the last scale rpcs with their caller, result and latency, the newest first.
+func (s *Server) handleScalerCalls(w http.ResponseWriter, req *http.Request) {
+	writeData(w, scaler.Default().Calls())
+}
+
+// handlePools reports whether the pools are cordoned and the backend connections in use.
+func (s *Server) handlePools(w http.ResponseWriter, req *http.Request) {
+	writeData(w, s.cluster.PoolStates())
+}
+
+// handlePoolOp cordons, uncordons or drains a pool, drain waits up to the timeout in seconds
+// given by the timeout query parameter.
+func (s *Server) handlePoolOp(w http.ResponseWriter, req *http.Request) {
+	params := mux.Vars(req)
+	ty := params["type"]
+	var err error
+	switch params["op"] {
+	case "cordon":
+		err = s.cluster.CordonPool(ty)
+	case "uncordon":
+		err = s.cluster.UncordonPool(ty)
+	case "drain":
+		var seconds int
+		if v := req.FormValue("timeout"); v != "" {
+			if seconds, err = strconv.Atoi(v); err != nil {
+				break
+			}
+		}
+		_, err = s.cluster.DrainPool(ty, time.Duration(seconds)*time.Second)
 	}
-	js, err := json.Marshal(st)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		logutil.BgLogger().Error("encode json failed", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		_, err = w.Write([]byte(err.Error()))
+		terror.Log(errors.Trace(err))
+		return
+	}
+	publishConfigChange("pool", params["op"], map[string]interface{}{"pool": ty})
+	s.handlePools(w, req)
+}
+
+// handleTopQueries lists the most frequent statement digests, the pool and limit query
+// parameters narrow them to a pool and to the first limit digests.
+func (s *Server) handleTopQueries(w http.ResponseWriter, req *http.Request) {
+	var limit int
+	if v := req.FormValue("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err = w.Write([]byte(err.Error()))
+			terror.Log(errors.Trace(err))
+			return
+		}
+	}
+	writeData(w, stats.DefaultTop().Digests(req.FormValue("pool"), limit))
+}
+
+// handleTokenQueue lists the sessions waiting for an execution token, to tell token
+// starvation from a slow backend when a query hangs.
+func (s *Server) handleTokenQueue(w http.ResponseWriter, req *http.Request) {
+	writeData(w, s.tokenQueueInfo())
+}
+
+// handleAPQueue reports the statements running and waiting in the ap queue by user.
+func (s *Server) handleAPQueue(w http.ResponseWriter, req *http.Request) {
+	stats := backend.FairQueueStats{Users: []backend.FairUserStats{}}
+	if s.cluster != nil && s.cluster.APQueue != nil {
+		stats = s.cluster.APQueue.Stats()
+	}
+	writeData(w, stats)
+}
+
+// handleServerless reports the desired and the actual cores of every pool, and the policy
+// and the action of the last reconcile.
+func (s *Server) handleServerless(w http.ResponseWriter, req *http.Request) {
+	states := []PoolScaleState{}
+	if s.serverless != nil {
+		states = s.serverless.States()
+	}
+	writeData(w, states)
+}
+
+// handleConnections lists the diagnostics of every client session.
+func (s *Server) handleConnections(w http.ResponseWriter, req *http.Request) {
+	writeData(w, s.connectionInfos())
+}
+
+// handleConnection returns the diagnostics of one client session by connection id.
+func (s *Server) handleConnection(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err = w.Write([]byte("invalid connection id"))
+		terror.Log(errors.Trace(err))
 		return
 	}
-	_, err = w.Write(js)
-	terror.Log(errors.Trace(err))
+	cc, ok := s.clients.get(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeData(w, cc.connectionInfo(time.Now()))
 }
 
 func (s *Server) AddTidb(w http.ResponseWriter, req *http.Request) {
@@ -467,6 +636,20 @@ func (s *Server) DeleteOneTidb(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	err = s.DeleteTidb(args.Cluster, args.Addr, args.TidbType)
+	if err == proxyerrors.ErrLongTxnsOnTidb {
+		//the operator retries the scale in later
+		w.WriteHeader(http.StatusConflict)
+		logutil.BgLogger().Warn("DeleteTidb Request vetoed "+args.Addr+" "+args.TidbType, zap.Error(err))
+		return
+	}
+	if proxyerrors.IsScaleInRejected(err) {
+		//the tidb stays routed, removing it would leave the pool unroutable or too small
+		w.WriteHeader(http.StatusConflict)
+		_, werr := w.Write([]byte(err.Error()))
+		terror.Log(errors.Trace(werr))
+		logutil.BgLogger().Warn("DeleteTidb Request rejected "+args.Addr+" "+args.TidbType, zap.Error(err))
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		logutil.BgLogger().Error("DeleteTidb Request failed "+args.Addr+ " " +args.TidbType,zap.Error(err))
@@ -476,6 +659,203 @@ func (s *Server) DeleteOneTidb(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
+// RebalanceWeights refreshes tidb weights from pod resources on demand.
+func (s *Server) RebalanceWeights(w http.ResponseWriter, req *http.Request) {
+	writeData(w, struct {
+		Changed bool `json:"changed"`
+	}{s.cluster.RebalanceWeights()})
+}
+
+// Capture starts or stops recording routed statements to a replay log.
+func (s *Server) Capture(w http.ResponseWriter, req *http.Request) {
+	args := struct {
+		Action string `json:"action"`
+		File   string `json:"file"`
+	}{}
+	err := json.NewDecoder(req.Body).Decode(&args)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		logutil.BgLogger().Error("encode Request failed", zap.Error(err))
+		return
+	}
+	result := struct {
+		Statements int64 `json:"statements"`
+	}{}
+	switch args.Action {
+	case "start":
+		var file string
+		if file, err = replay.CapturePath(s.captureConfig().Dir, args.File); err == nil {
+			err = replay.DefaultRecorder().Start(file)
+		}
+	case "stop":
+		result.Statements, err = replay.DefaultRecorder().Stop()
+	default:
+		err = errors.Errorf("unknown capture action %s", args.Action)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err = w.Write([]byte(err.Error()))
+		terror.Log(errors.Trace(err))
+		return
+	}
+	writeData(w, result)
+}
+
+// Rebind moves the mysql listener or unix socket to a new address without restarting,
+// the old one is drained for drain seconds and shown in /status until it is closed.
+func (s *Server) Rebind(w http.ResponseWriter, req *http.Request) {
+	args := struct {
+		Host   string `json:"host"`
+		Port   uint   `json:"port"`
+		Socket string `json:"socket"`
+		Drain  int    `json:"drain"`
+	}{}
+	err := json.NewDecoder(req.Body).Decode(&args)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		logutil.BgLogger().Error("encode Request failed", zap.Error(err))
+		return
+	}
+	if err = s.RebindListener(args.Host, args.Port, args.Socket, time.Duration(args.Drain)*time.Second); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err = w.Write([]byte(err.Error()))
+		terror.Log(errors.Trace(err))
+		return
+	}
+	publishConfigChange("listener", "rebind", map[string]interface{}{"host": args.Host, "port": args.Port, "socket": args.Socket})
+	host, port, socket := s.listenAddrs()
+	writeData(w, struct {
+		Host     string   `json:"host"`
+		Port     uint     `json:"port"`
+		Socket   string   `json:"socket"`
+		Draining []string `json:"draining"`
+	}{host, port, socket, s.drainingAddrs()})
+}
+
+// StartReplay re-drives a captured workload against a backend, the target is
+// either an address or the first tidb of a pool.
+func (s *Server) StartReplay(w http.ResponseWriter, req *http.Request) {
+	args := struct {
+		replay.Options
+		Pool string `json:"pool"`
+	}{}
+	err := json.NewDecoder(req.Body).Decode(&args)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		logutil.BgLogger().Error("encode Request failed", zap.Error(err))
+		return
+	}
+	capture := s.captureConfig()
+	if !capture.Replay {
+		w.WriteHeader(http.StatusForbidden)
+		_, err = w.Write([]byte(proxyerrors.ErrReplayDisabled.Error()))
+		terror.Log(errors.Trace(err))
+		return
+	}
+	if args.Writes && !capture.ReplayWrites {
+		w.WriteHeader(http.StatusForbidden)
+		_, err = w.Write([]byte(proxyerrors.ErrReplayWrites.Error()))
+		terror.Log(errors.Trace(err))
+		return
+	}
+	cluster := s.GetAllClusters()
+	if args.Addr == "" && args.Pool != "" {
+		if pool, ok := cluster.BackendPools[args.Pool]; ok {
+			pool.RLock()
+			if len(pool.Tidbs) > 0 {
+				args.Addr = pool.Tidbs[0].Addr()
+			}
+			pool.RUnlock()
+		}
+	}
+	if args.Addr == "" || args.File == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err = w.Write([]byte("file and addr or pool are required"))
+		terror.Log(errors.Trace(err))
+		return
+	}
+	if args.File, err = replay.CapturePath(capture.Dir, args.File); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err = w.Write([]byte(err.Error()))
+		terror.Log(errors.Trace(err))
+		return
+	}
+	//replays only go to the tidbs of the cluster, as they run with its credentials
+	if args.Addr = clusterTidbAddr(cluster, args.Addr); args.Addr == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err = w.Write([]byte(proxyerrors.ErrReplayTarget.Error()))
+		terror.Log(errors.Trace(err))
+		return
+	}
+	if args.User == "" {
+		args.User = cluster.Cfg.User
+		args.Password = cluster.Cfg.Password
+	}
+	if err = replay.DefaultReplayer().Start(args.Options); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		_, err = w.Write([]byte(err.Error()))
+		terror.Log(errors.Trace(err))
+		return
+	}
+	logutil.BgLogger().Info("replay started", zap.String("file", args.File), zap.String("addr", args.Addr))
+}
+
+// captureConfig returns the capture dir and the replay switches of the proxy config.
+func (s *Server) captureConfig() proxyconfig.CaptureConfig {
+	if s.cfg.Proxycfg == nil {
+		return proxyconfig.CaptureConfig{}
+	}
+	return s.cfg.Proxycfg.Capture
+}
+
+// clusterTidbAddr returns the addr of the tidb of the cluster at addr, empty if there is
+// none. The weight of the addr is dropped.
+func clusterTidbAddr(cluster *backend.Cluster, addr string) string {
+	addr = strings.Split(addr, backend.WeightSplit)[0]
+	for _, ty := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+		pool, ok := cluster.BackendPools[ty]
+		if !ok {
+			continue
+		}
+		pool.RLock()
+		for _, db := range pool.Tidbs {
+			if !db.Self && strings.Split(db.Addr(), backend.WeightSplit)[0] == addr {
+				pool.RUnlock()
+				return addr
+			}
+		}
+		pool.RUnlock()
+	}
+	return ""
+}
+
+// GetReplay reports the progress of the running or last replay.
+func (s *Server) GetReplay(w http.ResponseWriter, req *http.Request) {
+	writeData(w, replay.DefaultReplayer().Report())
+}
+
+// GetClusterCosts reports the cost of running statements by class and origin.
+func (s *Server) GetClusterCosts(w http.ResponseWriter, req *http.Request) {
+	cluster := s.GetAllClusters()
+	var utilization map[string]float64
+	if s.serverless != nil {
+		utilization = s.serverless.Utilization()
+	}
+	writeData(w, struct {
+		Costs           map[string]map[string]int64 `json:"costs"`
+		PureComputeCost int64                       `json:"pure_compute_cost"`
+		ProxyAsCompute  bool                        `json:"proxy_as_compute"`
+		LocalLoad       backend.LocalLoadInfo       `json:"local_load"`
+		Utilization     map[string]float64          `json:"utilization,omitempty"`
+	}{
+		Costs:           cluster.ProxyNode.Costs.Snapshot(),
+		PureComputeCost: s.pureComputeCost(),
+		ProxyAsCompute:  cluster.ProxyNode.ProxyAsCompute,
+		LocalLoad:       backend.LocalLoad(),
+		Utilization:     utilization,
+	})
+}
+
 type DBStatus struct {
 	Cluster         string `json:"cluster"`
 	Address         string `json:"address"`
@@ -521,11 +901,5 @@ func (s *Server) GetClustersStatus(w http.ResponseWriter, req *http.Request) {
 		dbStatus = append(dbStatus, TidbStatus)
 	}
 
-	js, err := json.Marshal(dbStatus)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		logutil.BgLogger().Error("encode json failed", zap.Error(err))
-		return
-	}
-	_, err = w.Write(js)
+	writeData(w, dbStatus)
 }
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/executor"
+	"github.com/pingcap/tidb/infoschema"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/rewrite"
+	"github.com/pingcap/tidb/proxy/scaler"
+	"github.com/pingcap/tidb/proxy/stats"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util"
+)
+
+const (
+	routingRuleRewrite = "rewrite"
+	routingRulePin     = "pin"
+)
+
+// proxyStateTables are the information_schema tables served from the proxy memory.
+var proxyStateTables = map[string]struct{}{
+	model.NewCIStr(infoschema.TableServerlessBackends).L:     {},
+	model.NewCIStr(infoschema.TableServerlessScaleHistory).L: {},
+	model.NewCIStr(infoschema.TableServerlessRoutingRules).L: {},
+	model.NewCIStr(infoschema.TableServerlessTopQueries).L:   {},
+}
+
+// readsProxyState reports whether the select only reads proxy state tables, it runs on
+// the proxy instead of a backend, whose tables would be empty.
+func (c *clientConn) readsProxyState(stmt ast.StmtNode) bool {
+	switch stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt:
+	default:
+		return false
+	}
+	collector := &tableNameCollector{}
+	stmt.Accept(collector)
+	if len(collector.tables) == 0 {
+		return false
+	}
+	for _, tn := range collector.tables {
+		schema := tn.Schema.L
+		if schema == "" {
+			schema = model.NewCIStr(c.dbname).L
+		}
+		if schema != util.InformationSchemaName.L {
+			return false
+		}
+		if _, ok := proxyStateTables[tn.Name.L]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+var _ executor.ProxyStateProvider = (*Server)(nil)
+
+// ProxyStateRows implements executor.ProxyStateProvider.
+func (s *Server) ProxyStateRows(tableName string) ([][]types.Datum, error) {
+	switch tableName {
+	case infoschema.TableServerlessBackends:
+		return s.backendRows(), nil
+	case infoschema.TableServerlessScaleHistory:
+		return scaleHistoryRows()
+	case infoschema.TableServerlessRoutingRules:
+		return routingRuleRows(), nil
+	case infoschema.TableServerlessTopQueries:
+		return topQueryRows(), nil
+	}
+	return nil, nil
+}
+
+func (s *Server) backendRows() [][]types.Datum {
+	if s.cluster == nil {
+		return nil
+	}
+	var rows [][]types.Datum
+	for _, ty := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+		pool, ok := s.cluster.BackendPools[ty]
+		if !ok {
+			continue
+		}
+		cordoned := pool.IsCordoned()
+		pool.RLock()
+		for i, db := range pool.Tidbs {
+			_, _, _, _, usingConns, _ := db.ConnCount()
+			var weight float64
+			if i < len(pool.TidbsWeights) {
+				weight = pool.TidbsWeights[i]
+			}
+			rows = append(rows, types.MakeDatums(ty, db.Addr(), db.State(), db.Version(), weight, usingConns, cordoned, db.Zone()))
+		}
+		pool.RUnlock()
+	}
+	return rows
+}
+
+func scaleHistoryRows() ([][]types.Datum, error) {
+	calls := scaler.Default().Calls()
+	rows := make([][]types.Datum, 0, len(calls))
+	for _, call := range calls {
+		request, err := json.Marshal(call.Request)
+		if err != nil {
+			return nil, err
+		}
+		var response []byte
+		if call.Response != nil {
+			if response, err = json.Marshal(call.Response); err != nil {
+				return nil, err
+			}
+		}
+		at := types.NewTime(types.FromGoTime(time.Unix(call.Time, 0)), mysql.TypeTimestamp, types.DefaultFsp)
+		rows = append(rows, types.MakeDatums(at, call.Caller, call.Method, string(request), string(response),
+			call.Latency, call.Error, call.Limited))
+	}
+	return rows, nil
+}
+
+func routingRuleRows() [][]types.Datum {
+	engine := rewrite.DefaultEngine()
+	var rows [][]types.Datum
+	for _, r := range engine.Rules() {
+		rows = append(rows, types.MakeDatums(routingRuleRewrite, r.ID, r.Digest, r.User, r.Class, r.Match, r.Replace, r.Hits))
+	}
+	for _, p := range engine.Pins() {
+		rows = append(rows, types.MakeDatums(routingRulePin, p.ID, p.Digest, p.User, p.Pool, "", p.Hint, p.Hits))
+	}
+	return rows
+}
+
+func topQueryRows() [][]types.Datum {
+	digests := stats.DefaultTop().Digests("", 0)
+	rows := make([][]types.Datum, 0, len(digests))
+	for _, d := range digests {
+		lastSeen := types.NewTime(types.FromGoTime(time.Unix(d.LastSeen, 0)), mysql.TypeTimestamp, types.DefaultFsp)
+		rows = append(rows, types.MakeDatums(d.Pool, d.Digest, d.Sample, d.Count, d.CountError,
+			d.MeanLatency, d.TotalLatency, d.TotalCost, lastSeen))
+	}
+	return rows
+}
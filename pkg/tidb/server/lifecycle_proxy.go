@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// loopsStopTimeout bounds the wait of Close for the background loops, a loop stuck in a
+// backend call doesn't hold the shutdown.
+const loopsStopTimeout = 10 * time.Second
+
+// loops tracks the background goroutines of the proxy, they run until Close stops them.
+type loops struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newLoops() *loops {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &loops{ctx: ctx, cancel: cancel}
+}
+
+// goLoop runs the loop in its own goroutine, stopLoops waits for it to return.
+func (s *Server) goLoop(loop func()) {
+	s.loops.wg.Add(1)
+	go func() {
+		defer s.loops.wg.Done()
+		loop()
+	}()
+}
+
+// pause sleeps for d between two rounds of a loop, it returns false at once when the loops
+// are stopped and the loop should return.
+func (s *Server) pause(d time.Duration) bool {
+	if s.loops == nil {
+		time.Sleep(d)
+		return !s.inShutdownMode
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-s.loops.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// loopsStopped reports whether the proxy is shutting down its background loops, no new
+// scaling nor pool change should start then.
+func (s *Server) loopsStopped() bool {
+	return s.loops != nil && s.loops.ctx.Err() != nil
+}
+
+// stopLoops stops the background loops and waits for the running rounds to finish, so no
+// loop scales the cluster or changes the pools once the listeners close.
+func (s *Server) stopLoops(timeout time.Duration) {
+	if s.loops == nil {
+		return
+	}
+	s.loops.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.loops.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		golog.Info("server", "stopLoops", "background loops stopped", 0)
+	case <-time.After(timeout):
+		golog.Warn("server", "stopLoops", "background loops not stopped in time", 0,
+			"timeout", timeout.String())
+	}
+}
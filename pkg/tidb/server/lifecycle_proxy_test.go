@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStopLoops(t *testing.T) {
+	s := &Server{loops: newLoops()}
+	rounds := make(chan struct{}, 100)
+	exited := make(chan struct{})
+	s.goLoop(func() {
+		defer close(exited)
+		for s.pause(time.Millisecond) {
+			select {
+			case rounds <- struct{}{}:
+			default:
+			}
+		}
+	})
+	<-rounds
+	if s.loopsStopped() {
+		t.Fatal("loops stopped before Close")
+	}
+	s.stopLoops(time.Second)
+	select {
+	case <-exited:
+	default:
+		t.Fatal("loop still running after stopLoops")
+	}
+	if !s.loopsStopped() || s.pause(time.Hour) {
+		t.Fatal("pause doesn't return at once after the loops stop")
+	}
+}
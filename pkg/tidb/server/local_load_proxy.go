@@ -0,0 +1,18 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pingcap/tidb/proxy/backend"
+)
+
+// runLocalLoadSampler samples the usage of the proxy container, the tp pool stops routing new
+// statements to the proxy itself while it has no headroom.
+func (s *Server) runLocalLoadSampler() {
+	for {
+		backend.SampleLocalLoad(time.Now())
+		if !s.pause(backend.LocalSampleInterval()) {
+			return
+		}
+	}
+}
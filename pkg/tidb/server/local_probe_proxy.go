@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	parsermysql "github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/metrics"
+)
+
+// comment of the validation query of connection pools, e.g. Connector/J
+const pingComment = "/* ping */"
+
+// probeKind returns ping for a /* ping */ query alone, select for SELECT 1 with or without
+// the comment, and empty for any other query.
+func probeKind(sql string) string {
+	sql = strings.TrimSpace(strings.TrimRight(sql, "; \t\r\n\x00"))
+	if len(sql) >= len(pingComment) && strings.EqualFold(sql[:len(pingComment)], pingComment) {
+		sql = strings.TrimSpace(sql[len(pingComment):])
+		if sql == "" {
+			return "ping"
+		}
+	}
+	if strings.EqualFold(strings.Join(strings.Fields(sql), " "), "select 1") {
+		return "select"
+	}
+	return ""
+}
+
+// handleLocalProbe answers the health probes of load balancers and connection pools in the
+// proxy. They take no token and no backend, and don't count as client qps, so they neither
+// use the quota nor keep the cluster from being silent.
+func (cc *clientConn) handleLocalProbe(ctx context.Context, data []byte) (bool, error) {
+	if cc.server.cfg.Proxycfg == nil || cc.server.cfg.Proxycfg.DisableLocalProbes || len(data) == 0 {
+		return false, nil
+	}
+	kind := "ping"
+	switch data[0] {
+	case parsermysql.ComPing:
+	case parsermysql.ComQuery:
+		if kind = probeKind(string(data[1:])); kind == "" {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+	metrics.ProxyLocalProbeCounter.WithLabelValues(kind).Inc()
+	cc.lastActive = time.Now()
+	atomic.StoreInt64(&cc.activeTime, cc.lastActive.UnixNano())
+	if kind == "ping" {
+		return true, cc.writeOK(ctx)
+	}
+	r, err := cc.buildResultset(nil, []string{"1"}, [][]interface{}{{int64(1)}})
+	if err != nil {
+		return true, err
+	}
+	return true, cc.writeResultsetForProxy(ctx, r, cc.ctx.Status())
+}
+
@@ -0,0 +1,20 @@
+package server
+
+import "testing"
+
+func TestProbeKind(t *testing.T) {
+	for sql, kind := range map[string]string{
+		"SELECT 1":                "select",
+		"select  1;":              "select",
+		"/* ping */ SELECT 1":     "select",
+		"/* PING */":              "ping",
+		"/* ping */;\n":           "ping",
+		"select 1 from dual":      "",
+		"select 10":               "",
+		"/* ping */ select now()": "",
+	} {
+		if got := probeKind(sql); got != kind {
+			t.Errorf("expect %q for %q, got %q", kind, sql, got)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/terror"
+	"github.com/pingcap/tidb/metrics"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	proxyerrors "github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/scaler"
+)
+
+const (
+	longTxnAlert = "alert"
+	longTxnKill  = "kill"
+
+	defaultLongTxnLimit = 20
+)
+
+// trackTxn records when the session entered its transaction, called after every command
+// started at t.
+func (cc *clientConn) trackTxn(t time.Time) {
+	inTxn := cc.txConn != nil || cc.ctx != nil && cc.ctx.Status()&mysql.ServerStatusInTrans > 0
+	if !inTxn {
+		atomic.StoreInt64(&cc.txnSince, 0)
+	} else if atomic.LoadInt64(&cc.txnSince) == 0 {
+		atomic.StoreInt64(&cc.txnSince, t.UnixNano())
+	}
+}
+
+// killIdleTxn rolls back the transaction idle past the idle kill timeout, the caller holds
+// the connection while the client is idle.
+func (cc *clientConn) killIdleTxn() {
+	atomic.StoreInt32(&cc.idleTxnKill, 0)
+	//the client may have sent statements since the check
+	idleKill := time.Duration(longTxnConfigOf(cc.server.cfg.Proxycfg).IdleKill) * time.Second
+	if idleKill <= 0 || time.Since(time.Unix(0, atomic.LoadInt64(&cc.activeTime))) < idleKill {
+		return
+	}
+	if co := cc.txConn; co == nil || co.IsProxySelf() {
+		return
+	}
+	metrics.ProxyLongTxnCounter.WithLabelValues(longTxnKill).Inc()
+	cc.abortTxn(proxyerrors.ErrIdleTxnKilled)
+}
+
+func longTxnConfigOf(cfg *proxyconfig.Config) proxyconfig.LongTxnConfig {
+	var c proxyconfig.LongTxnConfig
+	if cfg != nil {
+		c = cfg.LongTxn
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = proxyconfig.DefaultLongTxnThreshold
+	}
+	if c.Interval <= 0 {
+		c.Interval = proxyconfig.DefaultLongTxnInterval
+	}
+	return c
+}
+
+// openTxn is a transaction open in a client session.
+type openTxn struct {
+	cc    *clientConn
+	since int64
+	info  connectionInfo
+}
+
+// openTxns returns the open transactions of the client sessions, the oldest first.
+func (s *Server) openTxns(now time.Time) []openTxn {
+	var txns []openTxn
+	for _, cc := range s.clients.snapshot() {
+		since := atomic.LoadInt64(&cc.txnSince)
+		if since == 0 {
+			continue
+		}
+		txns = append(txns, openTxn{cc: cc, since: since, info: cc.connectionInfo(now)})
+	}
+	sort.Slice(txns, func(i, j int) bool { return txns[i].since < txns[j].since })
+	return txns
+}
+
+// longTxnDetector alerts the transactions open past the threshold once each, and picks the
+// ones idle past the idle kill timeout holding a backend transaction.
+type longTxnDetector struct {
+	cfg proxyconfig.LongTxnConfig
+	//start of the alerted transaction by connection
+	alerted map[uint64]int64
+}
+
+func newLongTxnDetector(cfg proxyconfig.LongTxnConfig) *longTxnDetector {
+	return &longTxnDetector{cfg: cfg, alerted: make(map[uint64]int64)}
+}
+
+func (d *longTxnDetector) check(txns []openTxn, now time.Time) (alerts []connectionInfo, kills []openTxn) {
+	seen := make(map[uint64]struct{}, len(txns))
+	for _, txn := range txns {
+		id := txn.info.ID
+		seen[id] = struct{}{}
+		if now.Sub(time.Unix(0, txn.since)) < time.Duration(d.cfg.Threshold)*time.Second {
+			continue
+		}
+		if d.alerted[id] != txn.since {
+			d.alerted[id] = txn.since
+			alerts = append(alerts, txn.info)
+		}
+		if d.cfg.IdleKill > 0 && txn.info.State == connState(connStatusReading) &&
+			txn.info.Idle > float64(d.cfg.IdleKill) && holdsBackendTxn(txn.info) {
+			kills = append(kills, txn)
+		}
+	}
+	for id := range d.alerted {
+		if _, ok := seen[id]; !ok {
+			delete(d.alerted, id)
+		}
+	}
+	return
+}
+
+// holdsBackendTxn reports whether the transaction is open on a tidb, one run by the proxy
+// itself holds no lock.
+func holdsBackendTxn(info connectionInfo) bool {
+	for _, b := range info.Backends {
+		if b.Role == "txn" && b.Addr != "proxy" {
+			return true
+		}
+	}
+	return false
+}
+
+// LongTxnAlert is the json posted to the long transaction webhooks.
+type LongTxnAlert struct {
+	Time      int64          `json:"time"`
+	Cluster   string         `json:"cluster"`
+	Namespace string         `json:"namespace"`
+	Threshold int            `json:"threshold_seconds"`
+	Txn       connectionInfo `json:"txn"`
+}
+
+// runLongTxnDetector checks the open transactions until the proxy stops its loops.
+func (s *Server) runLongTxnDetector() {
+	d := newLongTxnDetector(longTxnConfigOf(s.cfg.Proxycfg))
+	client := &http.Client{}
+	for {
+		if !s.pause(time.Duration(d.cfg.Interval) * time.Second) {
+			return
+		}
+		now := time.Now()
+		txns := s.openTxns(now)
+		var oldest float64
+		if len(txns) > 0 {
+			oldest = now.Sub(time.Unix(0, txns[0].since)).Seconds()
+		}
+		metrics.ProxyOldestTxnGauge.Set(oldest)
+
+		alerts, kills := d.check(txns, now)
+		for _, txn := range kills {
+			if atomic.CompareAndSwapInt32(&txn.cc.idleTxnKill, 0, 1) {
+				golog.Warn("server", "runLongTxnDetector", "roll back idle transaction", 0,
+					"connID", txn.info.ID, "user", txn.info.User, "host", txn.info.Host,
+					"age", txn.info.TxnAge, "idle", txn.info.Idle)
+			}
+		}
+		for _, info := range alerts {
+			golog.Warn("server", "runLongTxnDetector", "long transaction", 0,
+				"connID", info.ID, "user", info.User, "host", info.Host, "age", info.TxnAge,
+				"digest", info.Digest)
+			metrics.ProxyLongTxnCounter.WithLabelValues(longTxnAlert).Inc()
+			s.postLongTxn(client, d.cfg, LongTxnAlert{
+				Time:      now.Unix(),
+				Cluster:   s.cfg.Proxycfg.Cluster.ClusterName,
+				Namespace: s.cfg.Proxycfg.Cluster.NameSpace,
+				Threshold: d.cfg.Threshold,
+				Txn:       info,
+			})
+		}
+	}
+}
+
+// postLongTxn posts the alert to the webhooks, signed and retried like the scale events.
+func (s *Server) postLongTxn(client *http.Client, cfg proxyconfig.LongTxnConfig, alert LongTxnAlert) {
+	if len(cfg.Webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		golog.Error("server", "postLongTxn", "encode long transaction failed", 0, "error", err)
+		return
+	}
+	for _, hook := range cfg.Webhooks {
+		if hook.URL == "" {
+			continue
+		}
+		timeout, retries := hook.Timeout, hook.MaxRetries
+		if timeout <= 0 {
+			timeout = scaler.DefaultWebhookTimeout
+		}
+		if retries == 0 {
+			retries = scaler.DefaultWebhookMaxRetries
+		} else if retries < 0 {
+			retries = 0
+		}
+		client.Timeout = time.Duration(timeout) * time.Second
+		for i := 0; i <= retries; i++ {
+			if i > 0 && !s.pause(time.Duration(i)*time.Second) {
+				return
+			}
+			if err = postWebhook(client, hook, body); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			golog.Warn("server", "postLongTxn", "post long transaction failed", 0,
+				"url", hook.URL, "error", err)
+		}
+	}
+}
+
+func postWebhook(client *http.Client, hook proxyconfig.WebhookConfig, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Secret != "" {
+		req.Header.Set(scaler.SignatureHeader, scaler.Sign(hook.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// LongTxnReport is served by /proxy/long-txns.
+type LongTxnReport struct {
+	Threshold int              `json:"threshold_seconds"`
+	IdleKill  int              `json:"idle_kill_seconds"`
+	Txns      []connectionInfo `json:"txns"`
+}
+
+// handleLongTxns lists the oldest open transactions, at most limit of them.
+func (s *Server) handleLongTxns(w http.ResponseWriter, req *http.Request) {
+	limit := defaultLongTxnLimit
+	if v := req.FormValue("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, err = w.Write([]byte(err.Error()))
+			terror.Log(errors.Trace(err))
+			return
+		}
+	}
+	cfg := longTxnConfigOf(s.cfg.Proxycfg)
+	report := LongTxnReport{Threshold: cfg.Threshold, IdleKill: cfg.IdleKill, Txns: []connectionInfo{}}
+	for _, txn := range s.openTxns(time.Now()) {
+		if limit > 0 && len(report.Txns) >= limit {
+			break
+		}
+		report.Txns = append(report.Txns, txn.info)
+	}
+	writeData(w, report)
+}
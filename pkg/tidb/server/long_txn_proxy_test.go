@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func TestLongTxnDetector(t *testing.T) {
+	cfg := longTxnConfigOf(&config.Config{LongTxn: config.LongTxnConfig{Threshold: 30, IdleKill: 60}})
+	if cfg.Interval != config.DefaultLongTxnInterval {
+		t.Fatalf("interval %d", cfg.Interval)
+	}
+	d := newLongTxnDetector(cfg)
+	now := time.Now()
+	txn := func(id uint64, age, idle time.Duration, addr string) openTxn {
+		info := connectionInfo{ID: id, State: connState(connStatusReading), Idle: idle.Seconds(),
+			Backends: []connectionBackend{{Role: "txn", Addr: addr}}}
+		return openTxn{since: now.Add(-age).UnixNano(), info: info}
+	}
+	txns := []openTxn{
+		txn(1, 2*time.Minute, 90*time.Second, "10.0.0.1:4000"),
+		txn(2, 2*time.Minute, 90*time.Second, "proxy"),
+		txn(3, time.Minute, 10*time.Second, "10.0.0.1:4000"),
+		txn(4, 10*time.Second, 10*time.Second, "10.0.0.1:4000"),
+	}
+	alerts, kills := d.check(txns, now)
+	if len(alerts) != 3 || alerts[2].ID != 3 {
+		t.Fatalf("unexpected alerts %v", alerts)
+	}
+	if len(kills) != 1 || kills[0].info.ID != 1 {
+		t.Fatalf("unexpected kills %v", kills)
+	}
+	//a transaction is alerted once, a new one of the same connection again
+	txns[2].since = now.Add(-40 * time.Second).UnixNano()
+	if alerts, _ = d.check(txns, now); len(alerts) != 1 || alerts[0].ID != 3 {
+		t.Fatalf("unexpected alerts %v", alerts)
+	}
+	d.check(txns[:1], now)
+	if len(d.alerted) != 1 {
+		t.Fatalf("%d alerted transactions kept", len(d.alerted))
+	}
+
+	busy := txn(5, 2*time.Minute, 90*time.Second, "10.0.0.1:4000")
+	busy.info.State = connState(connStatusDispatching)
+	if _, kills = d.check([]openTxn{busy}, now); len(kills) != 0 {
+		t.Fatal("busy transaction killed")
+	}
+}
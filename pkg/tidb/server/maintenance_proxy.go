@@ -0,0 +1,38 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pingcap/tidb/proxy/core/errors"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/maintenance"
+)
+
+// checkMaintenance fails a scale request of a pool in its maintenance window, so the
+// autoscaler doesn't fight an upgrade the operator runs.
+func checkMaintenance(caller, pool string) error {
+	w := maintenance.Default().Active(pool, time.Now())
+	if w == nil {
+		return nil
+	}
+	golog.Info("serverless", "checkMaintenance", "skip scale request in maintenance window", 0,
+		"caller", caller, "tidbtype", pool, "window", w.ID, "cron", w.Cron)
+	return errors.NewPoolError(pool, errors.ErrPoolInMaintenance)
+}
+
+// deferInMaintenance reports whether the deletion of the tidbs no longer discovered is
+// deferred, they are deleted by the first sync after the window.
+func (s *Server) deferInMaintenance(tidbType string, gone []string) bool {
+	if len(gone) == 0 {
+		return false
+	}
+	w := maintenance.Default().Active(tidbType, time.Now())
+	if w == nil {
+		return false
+	}
+	for _, addr := range gone {
+		golog.Info("server", "syncPool", "defer deleting tidb no longer discovered in maintenance window", 0,
+			"tidbtype", tidbType, "addr", addr, "window", w.ID, "cron", w.Cron)
+	}
+	return true
+}
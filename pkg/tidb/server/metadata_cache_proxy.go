@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/mysql"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// entries kept by the metadata cache, expired ones are dropped first when it is full
+const metadataCacheSize = 1024
+
+type metadataEntry struct {
+	rs      *mysql.Resultset
+	expires time.Time
+}
+
+// metadataCache keeps the results of the statements ORMs and drivers send on every connect,
+// like select @@session.auto_increment_increment, @@character_set_client, so they are
+// answered by the proxy instead of a backend. The results are shared and never modified.
+type metadataCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]*metadataEntry
+}
+
+func newMetadataCache(ttl time.Duration) *metadataCache {
+	return &metadataCache{ttl: ttl, entries: make(map[string]*metadataEntry)}
+}
+
+func (m *metadataCache) get(key string, now time.Time) (*mysql.Resultset, bool) {
+	m.Lock()
+	defer m.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(e.expires) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return e.rs, true
+}
+
+func (m *metadataCache) put(key string, rs *mysql.Resultset, now time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= metadataCacheSize {
+		for k, e := range m.entries {
+			if now.After(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		for k := range m.entries {
+			if len(m.entries) < metadataCacheSize {
+				break
+			}
+			delete(m.entries, k)
+		}
+	}
+	m.entries[key] = &metadataEntry{rs: rs, expires: now.Add(m.ttl)}
+}
+
+// volatileVars are the system variables that change without a set statement.
+var volatileVars = map[string]struct{}{
+	"last_insert_id":       {},
+	"identity":             {},
+	"insert_id":            {},
+	"warning_count":        {},
+	"error_count":          {},
+	"timestamp":            {},
+	"rand_seed1":           {},
+	"rand_seed2":           {},
+	"tidb_current_ts":      {},
+	"tidb_last_txn_info":   {},
+	"tidb_last_query_info": {},
+}
+
+// metadataExprChecker accepts the expressions of a metadata select, system variables that
+// only change by set statements and literals.
+type metadataExprChecker struct {
+	ok bool
+}
+
+func (v *metadataExprChecker) Enter(in ast.Node) (ast.Node, bool) {
+	switch x := in.(type) {
+	case *ast.VariableExpr:
+		if _, volatile := volatileVars[strings.ToLower(x.Name)]; !x.IsSystem || volatile {
+			v.ok = false
+		}
+		return in, true
+	case *driver.ValueExpr:
+		return in, true
+	}
+	v.ok = false
+	return in, true
+}
+
+func (v *metadataExprChecker) Leave(in ast.Node) (ast.Node, bool) {
+	return in, v.ok
+}
+
+// isMetadataSelect reports whether the select only reads system variables and literals.
+func isMetadataSelect(stmt ast.StmtNode) bool {
+	sel, ok := stmt.(*ast.SelectStmt)
+	if !ok || sel.From != nil || sel.Where != nil || sel.GroupBy != nil || sel.Having != nil ||
+		sel.LockInfo != nil || sel.SelectIntoOpt != nil || sel.Fields == nil {
+		return false
+	}
+	checker := &metadataExprChecker{ok: true}
+	for _, field := range sel.Fields.Fields {
+		if field.WildCard != nil || field.Expr == nil {
+			return false
+		}
+		field.Expr.Accept(checker)
+		if !checker.ok {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataCacheKey returns the key of a cacheable metadata select. Sessions that changed
+// a variable don't use the cache as the results depend on their variables, the key has
+// the schema version so the cache doesn't outlive a change of the backend schema.
+func (cc *clientConn) metadataCacheKey(stmt ast.StmtNode) (string, bool) {
+	if cc.server.metadataCache == nil || cc.varsChanged || !isMetadataSelect(stmt) {
+		return "", false
+	}
+	var version int64
+	if cc.server.dom != nil {
+		version = cc.server.dom.InfoSchema().SchemaMetaVersion()
+	}
+	return cc.user + "\x00" + cc.dbname + "\x00" + strconv.Itoa(int(cc.collation)) + "\x00" +
+		strconv.FormatInt(version, 10) + "\x00" + stmt.Text(), true
+}
+
+// writeCachedMetadata answers the metadata select from the cache, it reports whether it
+// did. On a miss the key is kept so the result from the backend fills the cache.
+func (cc *clientConn) writeCachedMetadata(ctx context.Context, stmt ast.StmtNode, lastStmt bool) (bool, error) {
+	cc.metadataKey = ""
+	key, ok := cc.metadataCacheKey(stmt)
+	if !ok {
+		return false, nil
+	}
+	rs, hit := cc.server.metadataCache.get(key, time.Now())
+	if !hit {
+		metrics.ProxyMetadataCacheCounter.WithLabelValues("miss").Inc()
+		cc.metadataKey = key
+		return false, nil
+	}
+	metrics.ProxyMetadataCacheCounter.WithLabelValues("hit").Inc()
+	return true, cc.writeResultsetForProxy(ctx, rs, cc.proxyStatus(lastStmt))
+}
+
+// cacheMetadata fills the cache with the result of the metadata select that missed it.
+func (cc *clientConn) cacheMetadata(rs *mysql.Resultset) {
+	if cc.metadataKey == "" || rs == nil || rs.Truncated {
+		return
+	}
+	cc.server.metadataCache.put(cc.metadataKey, rs, time.Now())
+	cc.metadataKey = ""
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+func TestIsMetadataSelect(t *testing.T) {
+	p := parser.New()
+	cases := map[string]bool{
+		"select @@version_comment limit 1": true,
+		"SELECT @@session.auto_increment_increment AS auto_increment_increment, @@tx_isolation": true,
+		"select @@last_insert_id":        false,
+		"select @a":                      false,
+		"select connection_id()":         false,
+		"select @@version from t":        false,
+		"select 1, @@max_allowed_packet": true,
+	}
+	for sql, expected := range cases {
+		stmt, err := p.ParseOneStmt(sql, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isMetadataSelect(stmt) != expected {
+			t.Fatalf("%s: expect %v", sql, expected)
+		}
+	}
+}
+
+func TestMetadataCache(t *testing.T) {
+	cache := newMetadataCache(time.Second)
+	now := time.Now()
+	rs := &mysql.Resultset{}
+	cache.put("k", rs, now)
+	if got, ok := cache.get("k", now.Add(500*time.Millisecond)); !ok || got != rs {
+		t.Fatal("cached result not found")
+	}
+	if _, ok := cache.get("k", now.Add(2*time.Second)); ok {
+		t.Fatal("expired result returned")
+	}
+	for i := 0; i <= metadataCacheSize; i++ {
+		cache.put(string(rune(i)), rs, now)
+	}
+	if len(cache.entries) > metadataCacheSize {
+		t.Fatalf("cache grows to %d entries", len(cache.entries))
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+const (
+	// Mysqlx.ServerMessages.Type.ERROR
+	mysqlxServerError = 1
+	// Mysqlx.Error.Severity.FATAL
+	mysqlxSeverityFatal = 1
+	// a message header is a 4 bytes little endian length followed by 1 byte type
+	mysqlxHeaderLen   = 5
+	mysqlxReadTimeout = 10 * time.Second
+	mysqlxDialTimeout = 5 * time.Second
+	mysqlxUnsupported = "X Protocol is not supported by the proxy, connect with the classic MySQL protocol"
+)
+
+// appendProtoVarint appends the protobuf varint of v.
+func appendProtoVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendProtoString(b []byte, field int, s string) []byte {
+	b = appendProtoVarint(b, uint64(field<<3|2))
+	b = appendProtoVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// mysqlxErrorFrame encodes a fatal Mysqlx.Error message with its frame header.
+func mysqlxErrorFrame(code uint16, state, msg string) []byte {
+	var body []byte
+	// severity = 1, code = 2, msg = 3, sql_state = 4
+	body = appendProtoVarint(body, 1<<3)
+	body = appendProtoVarint(body, mysqlxSeverityFatal)
+	body = appendProtoVarint(body, 2<<3)
+	body = appendProtoVarint(body, uint64(code))
+	body = appendProtoString(body, 3, msg)
+	body = appendProtoString(body, 4, state)
+
+	frame := make([]byte, 4, mysqlxHeaderLen+len(body))
+	binary.LittleEndian.PutUint32(frame, uint32(len(body)+1))
+	frame = append(frame, mysqlxServerError)
+	return append(frame, body...)
+}
+
+// handleMysqlxConn relays the connection to the X Protocol backend if one is configured,
+// otherwise it answers the first client message with a capability error, so drivers
+// probing the X port fail fast instead of waiting for a reply that never comes.
+func (s *Server) handleMysqlxConn(conn net.Conn) {
+	defer conn.Close()
+	if backendAddr := s.cfg.Proxycfg.MysqlxBackend; backendAddr != "" {
+		s.relayMysqlxConn(conn, backendAddr)
+		return
+	}
+
+	// the client speaks first in X Protocol, normally with CapabilitiesGet
+	header := make([]byte, mysqlxHeaderLen)
+	if err := conn.SetReadDeadline(time.Now().Add(mysqlxReadTimeout)); err == nil {
+		if _, err = io.ReadFull(conn, header); err != nil {
+			golog.Debug("server", "handleMysqlxConn", "read x protocol header failed", 0,
+				"remote", conn.RemoteAddr().String(), "error", err)
+		}
+	}
+	golog.Info("server", "handleMysqlxConn", "reject x protocol connection", 0,
+		"remote", conn.RemoteAddr().String(), "message_type", header[4])
+	frame := mysqlxErrorFrame(mysql.ErrNotSupportedYet, mysql.MySQLState[mysql.ErrNotSupportedYet], mysqlxUnsupported)
+	if _, err := conn.Write(frame); err != nil {
+		golog.Debug("server", "handleMysqlxConn", "write x protocol error failed", 0,
+			"remote", conn.RemoteAddr().String(), "error", err)
+	}
+}
+
+func (s *Server) relayMysqlxConn(conn net.Conn, backendAddr string) {
+	backendConn, err := net.DialTimeout("tcp", backendAddr, mysqlxDialTimeout)
+	if err != nil {
+		golog.Error("server", "relayMysqlxConn", "dial x protocol backend failed", 0,
+			"backend", backendAddr, "error", err)
+		frame := mysqlxErrorFrame(mysql.ErrUnknown, proxyUnavailableState, "X Protocol backend is unavailable (retryable: true)")
+		conn.Write(frame)
+		return
+	}
+	defer backendConn.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(backendConn, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, backendConn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func (s *Server) startMysqlxListener(listener net.Listener, errChan chan error) {
+	if listener == nil {
+		errChan <- nil
+		return
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.inShutdownMode {
+				errChan <- nil
+			} else {
+				errChan <- err
+			}
+			return
+		}
+		go s.handleMysqlxConn(conn)
+	}
+}
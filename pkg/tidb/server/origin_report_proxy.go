@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/stats"
+)
+
+// OriginReport is the workload of the statements run in the proxy and forwarded to
+// backends, in time slices of Interval seconds, the last one still open.
+type OriginReport struct {
+	Interval int                 `json:"interval"`
+	Slices   []stats.OriginSlice `json:"slices"`
+}
+
+// observeOrigin accounts the statement started at start to the workload of its origin,
+// executed in the proxy on a self conn or forwarded to a backend, and of its app.
+func (cc *clientConn) observeOrigin(conn *backend.BackendConn, start time.Time) {
+	origin := stats.OriginForward
+	if conn.IsProxySelf() {
+		origin = stats.OriginProxy
+	}
+	cost := int64(cc.ctx.GetSessionVars().Proxy.Cost)
+	class := backend.CostClass(cost)
+	elapsed := time.Since(start)
+	metrics.ProxyOriginLatencyHistogram.WithLabelValues(class, origin).Observe(elapsed.Seconds())
+	stats.DefaultOrigins().Add(class, origin, elapsed, cost)
+	cc.observeApp(conn, elapsed)
+}
+
+func (s *Server) originReportInterval() time.Duration {
+	interval := time.Duration(s.cfg.Proxycfg.OriginReportInterval) * time.Second
+	if interval <= 0 {
+		interval = config.DefaultOriginReportInterval * time.Second
+	}
+	return interval
+}
+
+// runOriginReport closes a slice of the origin workload every interval and logs how the
+// statements run in the proxy compare with the forwarded ones.
+func (s *Server) runOriginReport() {
+	for s.pause(s.originReportInterval()) {
+		logOriginSlice(stats.DefaultOrigins().Rotate(time.Now()))
+	}
+}
+
+func logOriginSlice(slice stats.OriginSlice) {
+	for _, class := range []string{backend.TiDBForTP, backend.TiDBForAP, backend.BigCost} {
+		local, lok := slice.Stat(class, stats.OriginProxy)
+		forward, fok := slice.Stat(class, stats.OriginForward)
+		if !lok && !fok {
+			continue
+		}
+		ratio, _ := slice.LatencyRatio(class)
+		golog.Info("Server", "logOriginSlice", "origin workload", 0,
+			"class", class,
+			"proxy_count", local.Count, "proxy_mean_latency_us", local.MeanLatency,
+			"proxy_mean_cost", local.MeanCost,
+			"forward_count", forward.Count, "forward_mean_latency_us", forward.MeanLatency,
+			"forward_mean_cost", forward.MeanCost,
+			"latency_ratio", ratio)
+	}
+}
+
+// handleOriginReport lists the origin workload of the kept slices.
+func (s *Server) handleOriginReport(w http.ResponseWriter, req *http.Request) {
+	report := OriginReport{
+		Interval: int(s.originReportInterval() / time.Second),
+		Slices:   stats.DefaultOrigins().Slices(time.Now()),
+	}
+	writeData(w, report)
+}
@@ -37,12 +37,14 @@ package server
 import (
 	"bufio"
 	"io"
+	"sync/atomic"
 	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/parser/terror"
 	"github.com/pingcap/tidb/metrics"
+	proxymysql "github.com/pingcap/tidb/proxy/mysql"
 )
 
 const defaultWriterSize = 16 * 1024
@@ -58,6 +60,12 @@ type packetIO struct {
 	bufWriter   *bufio.Writer
 	sequence    uint8
 	readTimeout time.Duration
+	//bytes read from and written to the client, accessed atomically
+	bytesIn  int64
+	bytesOut int64
+	// reader is bufReadConn, or the compressed frames read from it once negotiated
+	reader     io.Reader
+	compressed *proxymysql.CompressedConn
 }
 
 func newPacketIO(bufReadConn *bufferedReadConn) *packetIO {
@@ -68,6 +76,7 @@ func newPacketIO(bufReadConn *bufferedReadConn) *packetIO {
 
 func (p *packetIO) setBufferedReadConn(bufReadConn *bufferedReadConn) {
 	p.bufReadConn = bufReadConn
+	p.reader = bufReadConn
 	p.bufWriter = bufio.NewWriterSize(bufReadConn, defaultWriterSize)
 }
 
@@ -82,7 +91,7 @@ func (p *packetIO) readOnePacket() ([]byte, error) {
 			return nil, err
 		}
 	}
-	if _, err := io.ReadFull(p.bufReadConn, header[:]); err != nil {
+	if _, err := io.ReadFull(p.reader, header[:]); err != nil {
 		return nil, errors.Trace(err)
 	}
 
@@ -94,6 +103,7 @@ func (p *packetIO) readOnePacket() ([]byte, error) {
 	p.sequence++
 
 	length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	atomic.AddInt64(&p.bytesIn, int64(len(header)+length))
 
 	data := make([]byte, length)
 	if p.readTimeout > 0 {
@@ -101,7 +111,7 @@ func (p *packetIO) readOnePacket() ([]byte, error) {
 			return nil, err
 		}
 	}
-	if _, err := io.ReadFull(p.bufReadConn, data); err != nil {
+	if _, err := io.ReadFull(p.reader, data); err != nil {
 		return nil, errors.Trace(err)
 	}
 	return data, nil
@@ -145,6 +155,7 @@ func (p *packetIO) readPacket() ([]byte, error) {
 func (p *packetIO) writePacket(data []byte) error {
 	length := len(data) - 4
 	writePacketBytes.Observe(float64(len(data)))
+	atomic.AddInt64(&p.bytesOut, int64(len(data)))
 
 	for length >= mysql.MaxPayloadLen {
 		data[0] = 0xff
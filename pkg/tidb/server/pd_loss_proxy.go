@@ -0,0 +1,112 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pingcap/failpoint"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/errno"
+	"github.com/pingcap/tidb/metrics"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/util/dbterror"
+)
+
+// interval the pd connection state is exported to the metrics
+const pdLossCheckInterval = time.Second
+
+var errReadOnlyOnPDLoss = dbterror.ClassServer.NewStd(errno.ErrReadOnlyMode)
+
+// pdLost reports whether the server lost the connection to pd.
+func (s *Server) pdLost() bool {
+	failpoint.Inject("pdLost", func(val failpoint.Value) {
+		failpoint.Return(val.(bool))
+	})
+	return s.dom != nil && s.dom.IsLostConnectionToPD()
+}
+
+// degradeOnPDLoss reports whether sessions are kept while pd is lost.
+func (s *Server) degradeOnPDLoss() bool {
+	return s.cfg.Proxycfg != nil && s.cfg.Proxycfg.PDLossPolicy == proxyconfig.PDLossDegrade
+}
+
+// admitOnPDLoss reports whether the new connection is accepted, while pd is lost it is
+// rejected unless the degrade policy accepts it as a read only session.
+func (s *Server) admitOnPDLoss(cc *clientConn) bool {
+	if !s.pdLost() {
+		return true
+	}
+	if !s.degradeOnPDLoss() {
+		metrics.ProxyPDLossConnCounter.WithLabelValues("rejected").Inc()
+		golog.Warn("server", "admitOnPDLoss", "reject connection due to lost connection to PD", 0,
+			"connID", cc.connectionID)
+		return false
+	}
+	metrics.ProxyPDLossConnCounter.WithLabelValues("read_only").Inc()
+	golog.Warn("server", "admitOnPDLoss", "accept read only connection while lost connection to PD", 0,
+		"connID", cc.connectionID)
+	cc.pdLossReadOnly = true
+	return true
+}
+
+// keepSessionsOnPDLoss reports whether the kill of all connections on pd loss is skipped,
+// a shutdown still kills them.
+func (s *Server) keepSessionsOnPDLoss() bool {
+	return !s.inShutdownMode && s.degradeOnPDLoss() && s.pdLost()
+}
+
+// checkPDLossReadOnly rejects the writes of a session accepted while pd is lost, the
+// session is a normal one once pd is back.
+func (cc *clientConn) checkPDLossReadOnly(stmt ast.StmtNode) error {
+	if !cc.pdLossReadOnly {
+		return nil
+	}
+	if !cc.server.pdLost() {
+		cc.pdLossReadOnly = false
+		return nil
+	}
+	if isWriteStmt(stmt) {
+		return errReadOnlyOnPDLoss
+	}
+	return nil
+}
+
+// checkWrites rejects the write of a text or prepared statement the session may not run,
+// while pd is lost or the proxy is in read-only mode.
+func (cc *clientConn) checkWrites(stmt ast.StmtNode) error {
+	if err := cc.checkPDLossReadOnly(stmt); err != nil {
+		return err
+	}
+	return cc.checkReadOnly(stmt)
+}
+
+// watchPDLoss exports whether pd is lost and logs the changes.
+func (s *Server) watchPDLoss() {
+	if s.dom == nil {
+		return
+	}
+	var lost bool
+	for !s.inShutdownMode {
+		if now := s.pdLost(); now != lost {
+			lost = now
+			if lost {
+				metrics.ProxyPDLostGauge.Set(1)
+				golog.Warn("server", "watchPDLoss", "lost connection to PD", 0,
+					"policy", s.pdLossPolicy())
+			} else {
+				metrics.ProxyPDLostGauge.Set(0)
+				golog.Info("server", "watchPDLoss", "connection to PD restored", 0)
+			}
+		}
+		if !s.pause(pdLossCheckInterval) {
+			return
+		}
+	}
+}
+
+func (s *Server) pdLossPolicy() string {
+	if s.degradeOnPDLoss() {
+		return proxyconfig.PDLossDegrade
+	}
+	return proxyconfig.PDLossReject
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+)
+
+// poolEvaluator is the autoscaler of one pool. It samples the cost of the pool at its own
+// interval and averages it over its window, so tp can react within a second while ap
+// follows the trend of minutes. Its scale holds the scale in and resend state of the pool.
+type poolEvaluator struct {
+	tidbType string
+	cfg      proxyconfig.AutoscaleConfig
+	scale    *Scale
+
+	//cost per second of the last intervals, the oldest is replaced
+	samples []int64
+	next    int
+	filled  int
+}
+
+func newPoolEvaluator(tidbType string, cfg proxyconfig.AutoscaleConfig, scale *Scale) *poolEvaluator {
+	n := int(math.Ceil(float64(cfg.Window) / float64(cfg.Interval)))
+	return &poolEvaluator{
+		tidbType: tidbType,
+		cfg:      cfg,
+		scale:    scale,
+		samples:  make([]int64, n),
+	}
+}
+
+func (ev *poolEvaluator) interval() time.Duration {
+	return time.Duration(ev.cfg.Interval) * time.Second
+}
+
+// sample adds the cost of the pool in the last interval, and returns the average over the
+// window. The cost sent to tp is accumulated over the interval, so it is taken per second,
+// ap is scaled by the cost of its running statements.
+func (ev *poolEvaluator) sample(usage backend.PoolUsage) int64 {
+	cost := usage.Cost
+	if ev.tidbType == backend.TiDBForTP {
+		cost = int64(usage.AddedCost) / int64(ev.cfg.Interval)
+	}
+	ev.samples[ev.next] = cost
+	ev.next = (ev.next + 1) % len(ev.samples)
+	if ev.filled < len(ev.samples) {
+		ev.filled++
+	}
+	var sum int64
+	for i := 0; i < ev.filled; i++ {
+		sum += ev.samples[i]
+	}
+	return sum / int64(ev.filled)
+}
+
+// step limits the change from the actual cores asked by one request.
+func (ev *poolEvaluator) step(actual, desired float64) float64 {
+	if s := ev.cfg.ScaleOutStep; s > 0 && desired > actual+s {
+		return actual + s
+	}
+	if s := ev.cfg.ScaleInStep; s > 0 && desired < actual-s {
+		return actual - s
+	}
+	return desired
+}
+
+// runEvaluator evaluates the pool until the proxy stops its loops.
+func (s *Server) runEvaluator(ev *poolEvaluator) {
+	for {
+		if s.cluster.Initialized() {
+			start := time.Now()
+			if st := s.serverless.evaluate(ev); st != nil {
+				metrics.ProxyAutoscaleEvalCounter.WithLabelValues(ev.tidbType, st.Action).Inc()
+				metrics.ProxyAutoscaleEvalHistogram.WithLabelValues(ev.tidbType).Observe(time.Since(start).Seconds())
+			}
+		}
+		if !s.pause(ev.interval()) {
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func TestPoolEvaluatorSample(t *testing.T) {
+	cfg := config.ClusterConfig{Autoscale: map[string]config.AutoscaleConfig{
+		backend.TiDBForTP: {Interval: 2, Window: 6},
+	}}
+	tp := newPoolEvaluator(backend.TiDBForTP, cfg.AutoscaleOf(backend.TiDBForTP), &Scale{})
+	//cost sent to tp in 2s is taken per second, averaged over the last 3 intervals
+	for _, c := range []struct {
+		added uint64
+		avg   int64
+	}{{200, 100}, {400, 150}, {600, 200}, {1200, 366}} {
+		if got := tp.sample(backend.PoolUsage{AddedCost: c.added}); got != c.avg {
+			t.Fatalf("sample %d averaged %d, want %d", c.added, got, c.avg)
+		}
+	}
+
+	ap := newPoolEvaluator(backend.TiDBForAP, cfg.AutoscaleOf(backend.TiDBForAP), &Scale{})
+	if ap.cfg.Interval != config.DefaultAutoscaleInterval || len(ap.samples) != 1 {
+		t.Fatalf("ap evaluator %+v with %d samples", ap.cfg, len(ap.samples))
+	}
+	ap.sample(backend.PoolUsage{Cost: 500})
+	if got := ap.sample(backend.PoolUsage{Cost: 300}); got != 300 {
+		t.Fatalf("ap without window averaged %d", got)
+	}
+}
+
+func TestPoolEvaluatorStep(t *testing.T) {
+	ev := newPoolEvaluator(backend.TiDBForAP, config.AutoscaleConfig{Interval: 1, Window: 1, ScaleOutStep: 2, ScaleInStep: 1}, &Scale{})
+	for _, c := range []struct{ actual, desired, want float64 }{
+		{4, 10, 6},
+		{4, 5, 5},
+		{4, 1, 3},
+		{4, 3.5, 3.5},
+	} {
+		if got := ev.step(c.actual, c.desired); got != c.want {
+			t.Fatalf("step from %v to %v is %v, want %v", c.actual, c.desired, got, c.want)
+		}
+	}
+}
+
+func TestScaleInCountsSeconds(t *testing.T) {
+	s := &Scale{scaleInInterval: 5}
+	//crossing a minute by a 10s step starts a new slot of the max needed cores
+	s.preFiveMinuteHashrate[2] = 3
+	s.scalueincout = 65
+	if got := s.savePreFiveHashate(1, 10); got != 1 {
+		t.Fatalf("max of the new minute %v, want 1", got)
+	}
+	s.scalueincout = 75
+	if got := s.savePreFiveHashate(2, 10); got != 2 {
+		t.Fatalf("max within the minute %v, want 2", got)
+	}
+	s.scalueincout = 85
+	if got := s.savePreFiveHashate(1, 10); got != 2 {
+		t.Fatalf("max within the minute %v, want 2", got)
+	}
+}
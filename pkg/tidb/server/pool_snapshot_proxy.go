@@ -0,0 +1,104 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+const (
+	// interval the pools are compared with the saved snapshot
+	poolSnapshotInterval = 5 * time.Second
+	// an unchanged snapshot is saved again after this, so it doesn't age out
+	poolSnapshotRefresh = time.Minute
+)
+
+// restorePoolSnapshot fills the pools from the snapshot saved before the restart, so the
+// proxy serves before the discovery completes. A snapshot of another cluster or older
+// than the max age is ignored. It reports whether the pools are restored.
+func (s *Server) restorePoolSnapshot() bool {
+	cfg := &s.cfg.Proxycfg.Cluster
+	if cfg.PoolSnapshotFile == "" {
+		return false
+	}
+	snap, err := backend.LoadSnapshot(cfg.PoolSnapshotFile)
+	if err != nil {
+		golog.Warn("server", "restorePoolSnapshot", "load pool snapshot failed", 0,
+			"file", cfg.PoolSnapshotFile, "error", err)
+		return false
+	}
+	if snap == nil {
+		return false
+	}
+	maxAge := time.Duration(cfg.PoolSnapshotMaxAge) * time.Second
+	if cfg.PoolSnapshotMaxAge == 0 {
+		maxAge = proxyconfig.DefaultPoolSnapshotMaxAge * time.Second
+	}
+	age := time.Since(time.Unix(snap.Time, 0))
+	if snap.Cluster != cfg.ClusterName || age > maxAge {
+		golog.Info("server", "restorePoolSnapshot", "ignore stale pool snapshot", 0,
+			"file", cfg.PoolSnapshotFile, "cluster", snap.Cluster, "age", age.String())
+		return false
+	}
+	for _, ty := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+		if err = s.cluster.ParseTidbs(withSelf(ty, snap.Tidbs(ty)), ty, s.cluster.Cfg); err != nil {
+			for _, pool := range s.cluster.BackendPools {
+				pool.Reset()
+			}
+			golog.Warn("server", "restorePoolSnapshot", "restore pool snapshot failed", 0,
+				"file", cfg.PoolSnapshotFile, "error", err)
+			return false
+		}
+	}
+	s.cluster.ApplyStates(snap)
+	golog.Info("server", "restorePoolSnapshot", "pools restored from snapshot", 0,
+		"file", cfg.PoolSnapshotFile, "age", age.String(),
+		"tp", strings.Join(snap.Tidbs(backend.TiDBForTP), backend.TidbSplit),
+		"ap", strings.Join(snap.Tidbs(backend.TiDBForAP), backend.TidbSplit))
+	return true
+}
+
+// syncDiscovered brings the pools restored from the snapshot in line with the discovery.
+// Like discoverCluster, the pools are only touched once all of them are discovered.
+func (s *Server) syncDiscovered() error {
+	norms := []string{backend.TiDBForTP, backend.TiDBForAP}
+	tidbs := make(map[string]string, len(norms))
+	for _, v := range norms {
+		t, err := s.discovery.Discover(v)
+		if err != nil {
+			return err
+		}
+		tidbs[v] = t
+	}
+	for _, v := range norms {
+		s.syncPool(v, tidbs[v])
+	}
+	return nil
+}
+
+// savePoolSnapshots saves the snapshot of the pools whenever they change, and refreshes
+// the time of an unchanged one.
+func (s *Server) savePoolSnapshots() {
+	file := s.cfg.Proxycfg.Cluster.PoolSnapshotFile
+	if file == "" {
+		return
+	}
+	var last *backend.Snapshot
+	for !s.inShutdownMode {
+		snap := s.cluster.Snapshot()
+		if !snap.SamePools(last) || time.Since(time.Unix(last.Time, 0)) >= poolSnapshotRefresh {
+			if err := snap.Save(file); err != nil {
+				golog.Warn("server", "savePoolSnapshots", "save pool snapshot failed", 0,
+					"file", file, "error", err)
+			} else {
+				last = snap
+			}
+		}
+		if !s.pause(poolSnapshotInterval) {
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/sessionctx/variable"
+)
+
+// forcePriority maps the proxy priority levels to tidb_force_priority of the backend.
+var forcePriority = map[string]string{
+	config.PriorityHigh:   "HIGH_PRIORITY",
+	config.PriorityMedium: "NO_PRIORITY",
+	config.PriorityLow:    "LOW_PRIORITY",
+}
+
+// stmtPriority returns the priority written in the statement itself, like SELECT HIGH_PRIORITY.
+func stmtPriority(stmt ast.StmtNode) mysql.PriorityEnum {
+	switch x := stmt.(type) {
+	case *ast.SelectStmt:
+		if x.SelectStmtOpts != nil {
+			return x.SelectStmtOpts.Priority
+		}
+	case *ast.InsertStmt:
+		return x.Priority
+	case *ast.UpdateStmt:
+		return x.Priority
+	case *ast.DeleteStmt:
+		return x.Priority
+	}
+	return mysql.NoPriority
+}
+
+// priority returns the priority level of the statement, the session variable comes first,
+// then the user and the routing class of the backend in the config.
+func (c *clientConn) priority(conn *backend.BackendConn) string {
+	if level := c.ctx.GetSessionVars().Proxy.Priority; level != "" && level != variable.ServerlessPriorityAuto {
+		return level
+	}
+	class := conn.GetDbType()
+	if class != backend.TiDBForTP {
+		class = backend.TiDBForAP
+	}
+	return c.server.cfg.Proxycfg.Priority.Level(c.user, class)
+}
+
+// setPriority sets tidb_force_priority of the backend session before the statement is
+// forwarded, so tp traffic keeps priority on backends shared with ap. A priority written in
+// the statement is left to the backend.
+func (c *clientConn) setPriority(conn *backend.BackendConn, stmt ast.StmtNode) error {
+	if conn.IsProxySelf() || conn.Conn == nil {
+		return nil
+	}
+	level := c.priority(conn)
+	if stmtPriority(stmt) != mysql.NoPriority {
+		level = ""
+	}
+	return conn.SetForcePriority(forcePriority[level])
+}
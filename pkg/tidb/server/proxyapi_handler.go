@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	proxyerrors "github.com/pingcap/tidb/proxy/core/errors"
 	"github.com/pingcap/tidb/proxy/core/golog"
 	"github.com/pingcap/tidb/proxy/util"
 	v1 "k8s.io/api/core/v1"
@@ -17,11 +19,6 @@ const (
 	Offline = iota
 	Online
 	Unknown
-	ComponentLabelKey    string = "app.kubernetes.io/component"
-	RoleInstanceLabelKey string = "bcrds.cmss.com/role"
-	AllInstanceLabelKey  string = "bcrds.cmss.com/instance"
-	InstanceLabelKey     string = "app.kubernetes.io/instance"
-	TidbPort             string = "4000"
 )
 
 
@@ -37,6 +34,9 @@ func (s *Server) GetAllClusters() *backend.Cluster {
 }
 
 func (s *Server) DeleteTidb(cluster, addr, tidbType string) error {
+	if !s.cluster.Initialized() {
+		return proxyerrors.ErrClusterInitializing
+	}
 	addr = strings.Split(addr, backend.WeightSplit)[0]
 	if err := s.cluster.DeleteTidb(addr, tidbType); err != nil {
 		return err
@@ -46,30 +46,24 @@ func (s *Server) DeleteTidb(cluster, addr, tidbType string) error {
 }
 
 func (s *Server) AddNewTidb(allNewTidb []*NewTidb) error {
-
+	//pods ready before the bootstrap finishes are picked up by the discovery
+	if !s.cluster.Initialized() {
+		return proxyerrors.ErrClusterInitializing
+	}
 	if err := s.cluster.AddTidb(allNewTidb); err != nil {
 		return err
 	}
 	return nil
 }
 
-func GetProxyPod(clustername, namespace string) (*v1.PodList, error) {
-	var listOptions metav1.ListOptions
-	listOptions = metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("%s=%s,%s=%s,%s=%s", ComponentLabelKey, "tidb", RoleInstanceLabelKey, "proxy", AllInstanceLabelKey, clustername),
-	}
-	podList, err := util.KubeClient.CoreV1().Pods(namespace).List(listOptions)
-	if err != nil {
-		golog.Error("server", "GetPod", "get pod fail", 0, "error", err)
-		return nil, err
-	}
-	return podList, nil
+func GetProxyPod(labels *proxyconfig.PodLabelConfig, clustername, namespace string) (*v1.PodList, error) {
+	return GetPod(labels, clustername, namespace, proxyconfig.RoleProxy)
 }
 
-func GetPod(clustername, namespace, tidbType string) (*v1.PodList, error) {
+func GetPod(labels *proxyconfig.PodLabelConfig, clustername, namespace, tidbType string) (*v1.PodList, error) {
 	var listOptions metav1.ListOptions
 	listOptions = metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("%s=%s,%s=%s,%s=%s", ComponentLabelKey, "tidb", RoleInstanceLabelKey, tidbType, AllInstanceLabelKey, clustername),
+		LabelSelector: labels.Selector(clustername, tidbType),
 	}
 
 	podList, err := util.KubeClient.CoreV1().Pods(namespace).List(listOptions)
@@ -95,9 +89,9 @@ func getPodReadyCondition(status *v1.PodStatus) *v1.PodCondition {
 }
 
 func (s *Server) dnsCheckOne(pod *v1.Pod) error {
-	tcName := pod.Labels[InstanceLabelKey]
-	name := pod.Name + "." + tcName + "-tidb-peer" + "." + pod.Namespace
-	dnscheck := fmt.Sprintf(`nslookup %s && mysql -h%s -u%s  -p%s -P4000 --connect-timeout=2 -e "select 1;"`, name, name, s.cluster.Cfg.User, s.cluster.Cfg.Password)
+	labels := s.cluster.Cfg.PodLabels()
+	name := labels.PeerHost(pod.Name, pod.Labels, pod.Namespace)
+	dnscheck := fmt.Sprintf(`nslookup %s && mysql -h%s -u%s  -p%s -P%d --connect-timeout=2 -e "select 1;"`, name, name, s.cluster.Cfg.User, s.cluster.Cfg.Password, labels.Port)
 	cmd := exec.Command("/bin/sh", "-c", dnscheck)
 	var out, outerr bytes.Buffer
 	cmd.Stdout = &out
@@ -141,6 +135,7 @@ func getFloatCpu(cpu string) string {
 }
 
 func (s *Server) NewOne(podList *v1.PodList, tidbType string) []*NewTidb {
+	labels := s.cluster.Cfg.PodLabels()
 	allNew := make([]*NewTidb, 0)
 	for _, pod := range podList.Items {
 		if pod.DeletionTimestamp != nil {
@@ -156,15 +151,14 @@ func (s *Server) NewOne(podList *v1.PodList, tidbType string) []*NewTidb {
 			}
 			if flag == false {
 				one := &NewTidb{}
-				tcName := pod.Labels[InstanceLabelKey]
 				cpuNum := ""
 				for _, v1 := range pod.Spec.Containers {
-					if v1.Name == "tidb" {
+					if v1.Name == labels.Container {
 						cpuNum = v1.Resources.Requests.Cpu().String()
 					}
 				}
 				cpuNum = getFloatCpu(cpuNum)
-				one.Addr = pod.Name + "." + tcName + "-tidb-peer" + "." + pod.Namespace + ":" + TidbPort + "@" + cpuNum
+				one.Addr = labels.PeerAddr(pod.Name, pod.Labels, pod.Namespace) + "@" + cpuNum
 				one.Cluster = s.cluster.Cfg.ClusterName
 				one.TidbType = tidbType
 				allNew = append(allNew, one)
@@ -180,7 +174,7 @@ func (s *Server) NewOne(podList *v1.PodList, tidbType string) []*NewTidb {
 }
 
 func (s *Server) FindNewTidb(clusterName, ns, tidbType string) error {
-	Podlist, err := GetPod(clusterName, ns, tidbType)
+	Podlist, err := GetPod(s.cluster.Cfg.PodLabels(), clusterName, ns, tidbType)
 	if err != nil {
 		golog.Error("server", "FindNewTidb", "get pod fail", 0, "error", err)
 		return err
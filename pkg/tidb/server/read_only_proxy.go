@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/ast"
+	parsermysql "github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/terror"
+	"github.com/pingcap/tidb/errno"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/util/dbterror"
+)
+
+var errProxyReadOnly = dbterror.ClassServer.NewStdErr(errno.ErrReadOnlyMode,
+	parsermysql.Message("The proxy is in read-only mode, writes are rejected: %s", nil))
+
+// readOnlyMode rejects the writes of users not exempt while it is enabled.
+type readOnlyMode struct {
+	sync.RWMutex
+	enabled bool
+	reason  string
+	since   int64
+	exempt  map[string]bool
+}
+
+// ReadOnlyStatus is the read-only mode shown by /proxy/read-only.
+type ReadOnlyStatus struct {
+	Enabled     bool     `json:"enabled"`
+	Reason      string   `json:"reason"`
+	Since       int64    `json:"since,omitempty"`
+	ExemptUsers []string `json:"exempt_users"`
+}
+
+func newReadOnlyMode(cfg config.ReadOnlyConfig) *readOnlyMode {
+	m := &readOnlyMode{exempt: make(map[string]bool, len(cfg.ExemptUsers))}
+	for _, user := range cfg.ExemptUsers {
+		m.exempt[user] = true
+	}
+	m.set(cfg.Enable, cfg.Reason)
+	return m
+}
+
+// set switches the mode, the reason is kept until the mode is switched again.
+func (m *readOnlyMode) set(enabled bool, reason string) {
+	m.Lock()
+	defer m.Unlock()
+	if enabled && !m.enabled {
+		m.since = time.Now().Unix()
+	} else if !enabled {
+		m.since = 0
+	}
+	m.enabled, m.reason = enabled, reason
+	if enabled {
+		metrics.ProxyReadOnlyGauge.Set(1)
+	} else {
+		metrics.ProxyReadOnlyGauge.Set(0)
+	}
+}
+
+// setExempt adds the user to or removes it from the exempt users.
+func (m *readOnlyMode) setExempt(user string, exempt bool) {
+	m.Lock()
+	defer m.Unlock()
+	if exempt {
+		m.exempt[user] = true
+	} else {
+		delete(m.exempt, user)
+	}
+}
+
+// rejects reports whether writes of the user are rejected and why.
+func (m *readOnlyMode) rejects(user string) (bool, string) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.enabled && !m.exempt[user], m.reason
+}
+
+func (m *readOnlyMode) status() ReadOnlyStatus {
+	m.RLock()
+	defer m.RUnlock()
+	st := ReadOnlyStatus{Enabled: m.enabled, Reason: m.reason, Since: m.since, ExemptUsers: make([]string, 0, len(m.exempt))}
+	for user := range m.exempt {
+		st.ExemptUsers = append(st.ExemptUsers, user)
+	}
+	sort.Strings(st.ExemptUsers)
+	return st
+}
+
+// isWriteStmt reports whether the statement changes data or schema, reads, locking
+// reads, SET and transaction statements are not writes.
+func isWriteStmt(stmt ast.StmtNode) bool {
+	switch stmt.(type) {
+	case *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt, *ast.LoadDataStmt:
+		return true
+	case ast.DDLNode:
+		return true
+	}
+	return false
+}
+
+// checkReadOnly rejects the write while the proxy is in read-only mode, unless the user
+// of the session is exempt.
+func (cc *clientConn) checkReadOnly(stmt ast.StmtNode) error {
+	if cc.server == nil || cc.server.readOnly == nil || !isWriteStmt(stmt) {
+		return nil
+	}
+	if rejected, reason := cc.server.readOnly.rejects(cc.user); rejected {
+		metrics.ProxyReadOnlyRejectedCounter.Inc()
+		if reason == "" {
+			reason = "read-only mode is enabled"
+		}
+		return errProxyReadOnly.GenWithStackByArgs(reason)
+	}
+	return nil
+}
+
+// setReadOnly switches the read-only mode and logs who switched it.
+func (s *Server) setReadOnly(enabled bool, reason, by string) {
+	s.readOnly.set(enabled, reason)
+	golog.Warn("Server", "setReadOnly", "read-only mode switched", 0,
+		"enabled", enabled, "reason", reason, "by", by)
+	publishConfigChange("read_only", "set", map[string]interface{}{"enabled": enabled, "reason": reason, "by": by})
+}
+
+// handleReadOnly shows the read-only mode, a POST switches it by the enable and reason
+// parameters and adds or removes the user of the exempt and unexempt parameters.
+func (s *Server) handleReadOnly(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		if v := req.FormValue("enable"); v != "" {
+			switch strings.ToLower(v) {
+			case "1", "true", "on":
+				s.setReadOnly(true, req.FormValue("reason"), "http")
+			case "0", "false", "off":
+				s.setReadOnly(false, "", "http")
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				_, err := w.Write([]byte("enable must be on or off"))
+				terror.Log(errors.Trace(err))
+				return
+			}
+		}
+		if user := req.FormValue("exempt"); user != "" {
+			s.readOnly.setExempt(user, true)
+		}
+		if user := req.FormValue("unexempt"); user != "" {
+			s.readOnly.setExempt(user, false)
+		}
+	}
+	writeData(w, s.readOnly.status())
+}
+
+func (cc *clientConn) handleShowProxyReadOnly(ctx context.Context) error {
+	if err := cc.checkProcessForProxy(); err != nil {
+		return err
+	}
+	st := cc.server.readOnly.status()
+	enabled := "OFF"
+	if st.Enabled {
+		enabled = "ON"
+	}
+	var since string
+	if st.Since > 0 {
+		since = time.Unix(st.Since, 0).Format("2006-01-02 15:04:05")
+	}
+	values := [][]interface{}{{enabled, st.Reason, since, strings.Join(st.ExemptUsers, ",")}}
+	return cc.writeAdminResultset(ctx, adminShowReadOnlyColumns, values)
+}
+
+func (cc *clientConn) handleSetProxyReadOnly(ctx context.Context, enabled bool, reason string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	cc.server.setReadOnly(enabled, reason, cc.user)
+	return cc.writeOkWith(ctx, "", 0, 0, cc.ctx.Status(), 0)
+}
+
+// handleExemptProxyReadOnly adds the user to or removes it from the users whose writes are
+// served in read-only mode.
+func (cc *clientConn) handleExemptProxyReadOnly(ctx context.Context, exempt bool, user string) error {
+	if err := cc.checkSuperForProxy(); err != nil {
+		return err
+	}
+	cc.server.readOnly.setExempt(user, exempt)
+	return cc.writeOkWith(ctx, "", 1, 0, cc.ctx.Status(), 0)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/tidb/proxy/config"
+)
+
+func TestIsWriteStmt(t *testing.T) {
+	p := parser.New()
+	for sql, write := range map[string]bool{
+		"insert into t values (1)":       true,
+		"update t set a = 1":             true,
+		"delete from t":                  true,
+		"create table t2 (a int)":        true,
+		"alter table t add column b int": true,
+		"select * from t":                false,
+		"select * from t for update":     false,
+		"begin":                          false,
+		"set @a = 1":                     false,
+		"show tables":                    false,
+	} {
+		stmt, err := p.ParseOneStmt(sql, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isWriteStmt(stmt); got != write {
+			t.Errorf("expect write %v for %q, got %v", write, sql, got)
+		}
+	}
+}
+
+func TestReadOnlyModeExemptUsers(t *testing.T) {
+	m := newReadOnlyMode(config.ReadOnlyConfig{ExemptUsers: []string{"migrator"}})
+	if rejected, _ := m.rejects("app"); rejected {
+		t.Fatal("expect writes served while read-only mode is off")
+	}
+	m.set(true, "storage maintenance")
+	if rejected, reason := m.rejects("app"); !rejected || reason != "storage maintenance" {
+		t.Fatalf("expect writes of app rejected for storage maintenance, got %v %q", rejected, reason)
+	}
+	if rejected, _ := m.rejects("migrator"); rejected {
+		t.Fatal("expect writes of exempt migrator served")
+	}
+	m.setExempt("app", true)
+	m.setExempt("migrator", false)
+	st := m.status()
+	if !st.Enabled || st.Since == 0 || len(st.ExemptUsers) != 1 || st.ExemptUsers[0] != "app" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	m.set(false, "")
+	if rejected, _ := m.rejects("migrator"); rejected {
+		t.Fatal("expect writes served once read-only mode is off")
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	goerr "errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/blacktear23/go-proxyprotocol"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/terror"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/util/logutil"
+	"go.uber.org/zap"
+)
+
+const defaultListenerDrain = 30 * time.Second
+
+var (
+	errRebindNotRunning = goerr.New("server is not running")
+	errRebindNoListener = goerr.New("listener is not configured, it can only be moved")
+)
+
+// drainingListener is an old listener still accepting while clients move to the new one.
+type drainingListener struct {
+	listener net.Listener
+	addr     string
+}
+
+// wrapProxyProtocol wraps the listener that serves PROXY protocol, tcp if configured
+// or else the socket, the same as NewServer does.
+func (s *Server) wrapProxyProtocol(l net.Listener, isUnixSocket bool) (net.Listener, error) {
+	if s.cfg.ProxyProtocol.Networks == "" || (isUnixSocket && s.listener != nil) {
+		return l, nil
+	}
+	return proxyprotocol.NewListener(l, s.cfg.ProxyProtocol.Networks, int(s.cfg.ProxyProtocol.HeaderTimeout))
+}
+
+// RebindListener moves the mysql listener to host:port and the unix socket to socket
+// without dropping sessions. The new listener accepts right away, the old one keeps
+// accepting for the drain period so clients and load balancers can switch, then it is closed.
+// Empty host, zero port or empty socket keep the current value.
+func (s *Server) RebindListener(host string, port uint, socket string, drain time.Duration) error {
+	s.rebindMu.Lock()
+	defer s.rebindMu.Unlock()
+	if s.listenerErrChan == nil || s.inShutdownMode {
+		return errRebindNotRunning
+	}
+	if drain <= 0 {
+		drain = defaultListenerDrain
+	}
+	curHost, curPort, curSocket := s.listenAddrs()
+	if host == "" {
+		host = curHost
+	}
+	if port == 0 {
+		port = curPort
+	}
+
+	var newListener, newSocket net.Listener
+	var err error
+	defer func() {
+		if err != nil {
+			for _, l := range []net.Listener{newListener, newSocket} {
+				if l != nil {
+					l.Close()
+				}
+			}
+		}
+	}()
+	if host != curHost || port != curPort {
+		if s.listener == nil {
+			return errRebindNoListener
+		}
+		tcpProto := "tcp"
+		if s.cfg.EnableTCP4Only {
+			tcpProto = "tcp4"
+		}
+		if newListener, err = net.Listen(tcpProto, fmt.Sprintf("%s:%d", host, port)); err != nil {
+			return err
+		}
+		// the bound listener is closed by the defer if it can't be wrapped
+		var wrapped net.Listener
+		if wrapped, err = s.wrapProxyProtocol(newListener, false); err != nil {
+			return err
+		}
+		newListener = wrapped
+	}
+	if socket != "" && socket != curSocket {
+		if s.socket == nil {
+			err = errRebindNoListener
+			return err
+		}
+		if newSocket, err = net.Listen("unix", socket); err != nil {
+			return err
+		}
+		var wrapped net.Listener
+		if wrapped, err = s.wrapProxyProtocol(newSocket, true); err != nil {
+			return err
+		}
+		newSocket = wrapped
+	}
+
+	// swap under the lock Close takes, so shutdown closes the new listeners
+	s.rwlock.Lock()
+	if newListener != nil {
+		s.retire(s.listener, fmt.Sprintf("%s:%d", curHost, curPort), drain)
+		s.listener = newListener
+		s.listenHost, s.listenPort = host, port
+		go s.startNetworkListener(newListener, false, false, s.listenerErrChan)
+		logutil.BgLogger().Info("server is running MySQL protocol", zap.String("addr", newListener.Addr().String()))
+	}
+	if newSocket != nil {
+		s.retire(s.socket, curSocket, drain)
+		s.socket = newSocket
+		s.listenSocket = socket
+		go s.startNetworkListener(newSocket, true, false, s.listenerErrChan)
+		logutil.BgLogger().Info("server is running MySQL protocol", zap.String("socket", socket))
+	}
+	s.rwlock.Unlock()
+	return nil
+}
+
+// listenAddrs returns the address and the socket the mysql listeners are bound to, they
+// differ from the config after a rebind.
+func (s *Server) listenAddrs() (host string, port uint, socket string) {
+	s.rwlock.RLock()
+	defer s.rwlock.RUnlock()
+	return s.listenHost, s.listenPort, s.listenSocket
+}
+
+// retire keeps the old listener accepting until the drain period ends, caller holds s.rwlock.
+func (s *Server) retire(l net.Listener, addr string, drain time.Duration) {
+	d := &drainingListener{listener: l, addr: addr}
+	s.draining = append(s.draining, d)
+	if s.retired == nil {
+		s.retired = make(map[net.Listener]struct{})
+	}
+	s.retired[l] = struct{}{}
+	golog.Info("server", "RebindListener", "draining old listener", 0,
+		"addr", addr, "drain", drain.String())
+	time.AfterFunc(drain, func() {
+		s.closeDraining(d)
+	})
+}
+
+func (s *Server) closeDraining(d *drainingListener) {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+	for i, v := range s.draining {
+		if v == d {
+			s.draining = append(s.draining[:i], s.draining[i+1:]...)
+			golog.Info("server", "RebindListener", "close old listener", 0, "addr", d.addr)
+			if err := d.listener.Close(); err != nil {
+				golog.Warn("server", "RebindListener", "close old listener failed", 0,
+					"addr", d.addr, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// isRetired reports whether the listener was replaced by a rebind, its accept loop
+// stops quietly since the new listener took over its place in Run.
+func (s *Server) isRetired(l net.Listener) bool {
+	s.rwlock.RLock()
+	defer s.rwlock.RUnlock()
+	_, ok := s.retired[l]
+	return ok
+}
+
+// closeDrainingListeners closes the old listeners at shutdown, caller holds s.rwlock.
+func (s *Server) closeDrainingListeners() {
+	for _, d := range s.draining {
+		terror.Log(errors.Trace(d.listener.Close()))
+	}
+	s.draining = nil
+}
+
+// drainingAddrs returns the addresses of the old listeners still draining.
+func (s *Server) drainingAddrs() []string {
+	s.rwlock.RLock()
+	defer s.rwlock.RUnlock()
+	addrs := make([]string, 0, len(s.draining))
+	for _, d := range s.draining {
+		addrs = append(addrs, d.addr)
+	}
+	return addrs
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// freePort returns a tcp port of the loopback nothing listens on.
+func freePort(t *testing.T) uint {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return uint(l.Addr().(*net.TCPAddr).Port)
+}
+
+func newRebindServer(t *testing.T) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{cfg: newTestConfig(), listener: l, listenerErrChan: make(chan error, 4)}
+	s.listenHost, s.listenPort = "127.0.0.1", uint(l.Addr().(*net.TCPAddr).Port)
+	return s
+}
+
+func TestRebindListenerDrain(t *testing.T) {
+	s := newRebindServer(t)
+	old := s.listener
+	oldAddr := old.Addr().String()
+	port := freePort(t)
+	if err := s.RebindListener("", port, "", 100*time.Millisecond); err != nil {
+		t.Fatalf("rebind: %v", err)
+	}
+	defer s.listener.Close()
+	if host, got, _ := s.listenAddrs(); host != "127.0.0.1" || got != port {
+		t.Fatalf("listening on %s:%d, want 127.0.0.1:%d", host, got, port)
+	}
+	if s.cfg.Port != newTestConfig().Port {
+		t.Fatalf("rebind changed the configured port to %d", s.cfg.Port)
+	}
+	if got := uint(s.listener.Addr().(*net.TCPAddr).Port); got != port {
+		t.Fatalf("new listener on port %d, want %d", got, port)
+	}
+
+	// the old listener keeps accepting during the drain, then it is closed
+	if d := s.drainingAddrs(); len(d) != 1 || d[0] != oldAddr {
+		t.Fatalf("draining %v, want %s", d, oldAddr)
+	}
+	conn, err := net.Dial("tcp", oldAddr)
+	if err != nil {
+		t.Fatalf("old listener refused a client during the drain: %v", err)
+	}
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.drainingAddrs()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("old listener still draining after the drain period")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !s.isRetired(old) {
+		t.Fatal("old listener not retired, its accept loop would stop the server")
+	}
+	if conn, err = net.Dial("tcp", oldAddr); err == nil {
+		conn.Close()
+		t.Fatal("old listener accepting after the drain")
+	}
+}
+
+func TestRebindListenerWrapFailure(t *testing.T) {
+	s := newRebindServer(t)
+	defer s.listener.Close()
+	old := s.listener
+	s.cfg.ProxyProtocol.Networks = "not-a-network/99"
+	port := freePort(t)
+	if err := s.RebindListener("", port, "", time.Second); err == nil {
+		t.Fatal("rebind with invalid PROXY protocol networks")
+	}
+	if s.listener != old || len(s.drainingAddrs()) != 0 {
+		t.Fatal("listener replaced by a failed rebind")
+	}
+	if _, got, _ := s.listenAddrs(); got != uint(old.Addr().(*net.TCPAddr).Port) {
+		t.Fatalf("failed rebind moved the listen port to %d", got)
+	}
+	// the port bound by the failed rebind is released
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("port of the failed rebind still bound: %v", err)
+	}
+	l.Close()
+}
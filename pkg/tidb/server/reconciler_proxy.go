@@ -0,0 +1,324 @@
+package server
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/maintenance"
+	"github.com/pingcap/tidb/proxy/scalepb"
+	"github.com/pingcap/tidb/proxy/scaler"
+	"github.com/pingcap/tidb/sessionctx/variable"
+)
+
+// an unchanged scale request is sent again after this when resend_for_scale_out is not set
+const defaultResendForScaleOut = 10 * time.Second
+
+// policies deciding the desired cores of a pool
+const (
+	policyCost         = "cost"
+	policyPredict      = "predict"
+	policyApQueue      = "ap_queue"
+	policyReplicaBound = "replica_bounds"
+	policyPureCompute  = "pure_compute"
+	policyComplex      = "complex_compute"
+	policyStep         = "step_size"
+)
+
+// actions taken on a pool by the last reconcile
+const (
+	actionNone          = "none"
+	actionScaleOut      = "scale_out"
+	actionScaleIn       = "scale_in"
+	actionScaleInWait   = "scale_in_pending"
+	actionSetCores      = "set_cores"
+	actionStepwise      = "stepwise_scale_in"
+	actionUnchanged     = "request_unchanged"
+	actionScaleInDenied = "scale_in_denied"
+	actionScaleInPaused = "scale_in_paused"
+	actionMaintenance   = "maintenance"
+	actionExternal      = "external"
+	actionFollower      = "follower"
+)
+
+// PoolScaleState is the desired and the actual cores of a pool in the last reconcile.
+type PoolScaleState struct {
+	Pool    string  `json:"pool"`
+	Actual  float64 `json:"actual_cores"`
+	Desired float64 `json:"desired_cores"`
+	// policy that decided the desired cores
+	Policy      string  `json:"policy"`
+	Action      string  `json:"action"`
+	Up          int     `json:"up"`
+	Down        int     `json:"down"`
+	Cost        int64   `json:"cost"`
+	// cost of the other proxy replicas, included in the cost
+	PeerCost int64 `json:"peer_cost,omitempty"`
+	Utilization float64 `json:"utilization"`
+	// seconds the tp load stays under the pure compute thresholds
+	QuietSeconds int64 `json:"quiet_seconds,omitempty"`
+	// seconds between evaluations of the pool and the cost is averaged over
+	Interval int `json:"interval"`
+	Window   int `json:"window"`
+	// last cores asked from the scaler and when
+	Requested   float64 `json:"requested_cores"`
+	RequestedAt int64   `json:"requested_at,omitempty"`
+	// no scaler endpoint is reachable, scale in is paused
+	ScalingUnavailable bool `json:"scaling_unavailable,omitempty"`
+	// id of the maintenance window the pool is in, no scale request is sent
+	Maintenance int64 `json:"maintenance_window,omitempty"`
+}
+
+// Reconcile evaluates every pool once, see evaluate. Each pool is evaluated by its own
+// loop at its own interval, see runEvaluator.
+func (sl *Serverless) Reconcile() {
+	for _, tidbType := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+		if ev, ok := sl.evaluators[tidbType]; ok {
+			sl.evaluate(ev)
+		}
+	}
+}
+
+// evaluate computes the desired cores of the pool from the scaling policies, and asks the
+// scaler for the minimal change from the actual cores. The cost policy and the pure
+// compute policy of the tp pool are decided together, so they never ask for conflicting
+// sizes in the same round. It returns nil if the pool was not evaluated.
+func (sl *Serverless) evaluate(ev *poolEvaluator) *PoolScaleState {
+	//Close stops the scaling before it drains and closes the listeners
+	if sl.proxy.loopsStopped() {
+		return nil
+	}
+	tidbType, scale := ev.tidbType, ev.scale
+	pool, ok := sl.proxy.cluster.BackendPools[tidbType]
+	if !ok {
+		return nil
+	}
+	//only up backends count, a down backend neither serves the cost nor adds cores
+	usage := pool.TakeUsage()
+	st := &PoolScaleState{
+		Pool:     tidbType,
+		Actual:   usage.Cores,
+		Up:       usage.Up,
+		Down:     usage.Down,
+		Interval: ev.cfg.Interval,
+		Window:   ev.cfg.Window,
+	}
+	scaleInAllowed := sl.desiredCores(st, ev, pool, usage)
+	if w := maintenance.Default().Active(tidbType, time.Now()); w != nil {
+		//the desired cores are still reported, the pool is scaled by the first
+		//reconcile after the window
+		metrics.ProxyMaintenanceGauge.WithLabelValues(tidbType).Set(1)
+		scale.resetscalein()
+		st.Maintenance, st.Action = w.ID, actionMaintenance
+	} else if !sl.proxy.coord.isLeader() {
+		//the leader of the proxy replicas scales the pools, the others only report
+		metrics.ProxyMaintenanceGauge.WithLabelValues(tidbType).Set(0)
+		scale.resetscalein()
+		st.Action = actionFollower
+	} else if sl.proxy.cfg.Proxycfg.Scaler.External {
+		//the desired cores are only published for the external autoscaler
+		metrics.ProxyMaintenanceGauge.WithLabelValues(tidbType).Set(0)
+		st.Action = actionExternal
+	} else {
+		metrics.ProxyMaintenanceGauge.WithLabelValues(tidbType).Set(0)
+		sl.reconcilePool(st, ev, scaleInAllowed)
+	}
+	st.Requested, st.RequestedAt = scale.lastchange, scale.lastSend
+	sl.setState(st)
+	publishScaleMetrics(st, pool)
+	sl.proxy.publishScaleDecision(st)
+	return st
+}
+
+// desiredCores fills the desired cores of the pool by the cost averaged over the window of
+// the evaluator, the forecast, the ap routing queue, the replica bounds and the step size.
+// It reports whether the pool may scale in.
+func (sl *Serverless) desiredCores(st *PoolScaleState, ev *poolEvaluator, pool *backend.Pool, usage backend.PoolUsage) bool {
+	tidbType := st.Pool
+	//the statements of the other proxy replicas load the same pool
+	st.PeerCost = sl.proxy.coord.peerCostOf(tidbType)
+	st.Cost = ev.sample(usage) + st.PeerCost
+	sl.setUtilization(st, usage)
+	st.Desired, st.Policy = ev.scale.GetNeedCores(st.Cost, tidbType), policyCost
+	if predictcore := sl.predictNeedCores(st.Cost, tidbType); predictcore > st.Desired {
+		golog.Debug("serverless", "Reconcile", "scale by predicted load", 0,
+			"tidbtype", tidbType, "needcore", st.Desired, "predictcore", predictcore)
+		st.Desired, st.Policy = predictcore, policyPredict
+	}
+	scaleInAllowed := true
+	if tidbType == backend.TiDBForAP {
+		var needcore float64
+		needcore, scaleInAllowed = sl.apQueueNeedCores(pool, st.Desired, st.Actual)
+		if needcore != st.Desired {
+			st.Desired, st.Policy = needcore, policyApQueue
+		}
+	}
+	if boundcore := sl.boundNeedCores(tidbType, st.Desired); boundcore != st.Desired {
+		golog.Debug("serverless", "Reconcile", "need cores bounded by replicas", 0,
+			"tidbtype", tidbType, "needcore", st.Desired, "boundcore", boundcore)
+		st.Desired, st.Policy = boundcore, policyReplicaBound
+	}
+	if stepcore := ev.step(st.Actual, st.Desired); stepcore != st.Desired {
+		st.Desired, st.Policy = stepcore, policyStep
+	}
+	if tidbType == backend.TiDBForTP {
+		sl.computeRole(st, int64(ev.cfg.Interval))
+	}
+	return scaleInAllowed
+}
+
+// computeRole decides whether the proxy serves the tp load alone. After the load stays
+// under the pure compute thresholds for tp_scale_in_seconds the dedicated tp tidbs are
+// dropped, and a pure compute proxy gets one back as soon as the load returns. Every call
+// counts the seconds of the interval of the tp evaluator.
+func (sl *Serverless) computeRole(st *PoolScaleState, seconds int64) {
+	s := sl.proxy
+	quiet := s.pureComputeCost() < variable.ServerlessVariable.TPScaleInCost.Load() &&
+		atomic.LoadInt64(&sl.counter.OldClientQPS) < variable.ServerlessVariable.TPScaleInQPS.Load()
+	if !quiet {
+		atomic.StoreInt64(&sl.quietSeconds, 0)
+		if s.cluster.ProxyNode.ProxyAsCompute && st.Up+st.Down == 0 {
+			st.Desired, st.Policy = math.Max(st.Desired, 1), policyComplex
+		}
+		return
+	}
+	st.QuietSeconds = atomic.AddInt64(&sl.quietSeconds, seconds)
+	minReplicas, _ := s.cfg.Proxycfg.Cluster.ReplicaBounds(backend.TiDBForTP)
+	if st.QuietSeconds >= variable.ServerlessVariable.TPScaleInSeconds.Load() && st.Up+st.Down > 0 && minReplicas <= 0 {
+		st.Desired, st.Policy = 0, policyPureCompute
+	}
+}
+
+// reconcilePool asks the scaler for the desired cores of the pool if they differ from the
+// actual ones. Scaling in by cost waits for the scale in interval, and a request the
+// scaler already got is only sent again after resend_for_scale_out. Scale in is paused
+// while no scaler endpoint is reachable, its request would be lost.
+func (sl *Serverless) reconcilePool(st *PoolScaleState, ev *poolEvaluator, scaleInAllowed bool) {
+	scale := ev.scale
+	st.ScalingUnavailable = !scaler.Default().Available()
+	if st.Pool == backend.TiDBForTP && atomic.LoadInt32(&sl.stepwise) == 1 {
+		//the stepwise scale in owns the tp pool until it is verified or rolled back, the
+		//quiet time is counted again after it
+		atomic.StoreInt64(&sl.quietSeconds, 0)
+		st.Action = actionStepwise
+		return
+	}
+	switch st.Policy {
+	case policyPureCompute:
+		if st.ScalingUnavailable {
+			st.Action = actionScaleInPaused
+			return
+		}
+		//no tp tidb may be left up while the proxy doesn't compute
+		if sl.proxy.cluster.CheckScaleIn(st.Pool, "", 0) != nil {
+			atomic.StoreInt64(&sl.quietSeconds, 0)
+			st.Action = actionScaleInDenied
+			return
+		}
+		atomic.StoreInt64(&sl.quietSeconds, 0)
+		scale.resetscalein()
+		if window := time.Duration(sl.proxy.cfg.Proxycfg.Cluster.ScaleInVerifyWindow) * time.Second; window > 0 {
+			st.Action = actionStepwise
+			atomic.StoreInt32(&sl.stepwise, 1)
+			go func() {
+				defer atomic.StoreInt32(&sl.stepwise, 0)
+				sl.proxy.scaleInStepwise(window)
+			}()
+			return
+		}
+		st.Action = sl.setCores(st, scale, scaleByPureCompute)
+		return
+	case policyComplex:
+		scale.resetscalein()
+		st.Action = sl.setCores(st, scale, scaleByComplex)
+		return
+	}
+	switch {
+	case st.Desired == st.Actual:
+		st.Action = actionNone
+	case st.Desired > st.Actual:
+		scale.resetscalein()
+		if !scale.due(st.Desired) {
+			st.Action = actionUnchanged
+			return
+		}
+		st.Action = actionScaleOut
+		scale.scaleout(st.Actual, st.Desired, st.Pool)
+	case !scaleInAllowed:
+		st.Action = actionScaleInDenied
+	case st.ScalingUnavailable:
+		scale.resetscalein()
+		st.Action = actionScaleInPaused
+	default:
+		st.Action = actionScaleInWait
+		if scale.SetScalein(st.Actual-st.Desired, st.Desired, st.Pool, ev.cfg.Interval) {
+			st.Action = actionScaleIn
+		}
+	}
+}
+
+// setCores asks the scaler to set the cores of the pool.
+func (sl *Serverless) setCores(st *PoolScaleState, scale *Scale, caller string) string {
+	if !scale.due(st.Desired) {
+		return actionUnchanged
+	}
+	scale.SetLastChange(st.Desired)
+	err := scaleCluster(caller, &scalepb.ScaleRequest{
+		Clustername: ClusterName,
+		Namespace:   NameSpace,
+		Hashrate:    float32(st.Desired),
+		Scaletype:   st.Pool,
+	})
+	if err != nil {
+		golog.Error("serverless", "Reconcile", "set cores of pool failed", 0,
+			"caller", caller, "tidbtype", st.Pool, "cores", st.Desired, "error", err)
+	}
+	return actionSetCores
+}
+
+// setUtilization records the cores needed by the cost over the cores of the up backends,
+// above 1 the pool is scaled out. An empty pool with cost is reported at 1 per needed core.
+func (sl *Serverless) setUtilization(st *PoolScaleState, usage backend.PoolUsage) {
+	st.Utilization = float64(st.Cost) / costOneCore(st.Pool)
+	if usage.Cores > 0 {
+		st.Utilization /= usage.Cores
+	}
+	metrics.ProxyPoolUtilizationGauge.WithLabelValues(st.Pool).Set(st.Utilization)
+	if usage.Down > 0 {
+		golog.Debug("serverless", "Reconcile", "down backends left out of scaling", 0,
+			"tidbtype", st.Pool, "up", usage.Up, "down", usage.Down, "utilization", st.Utilization)
+	}
+}
+
+func (sl *Serverless) setState(st *PoolScaleState) {
+	sl.statesMu.Lock()
+	sl.states[st.Pool] = st
+	sl.statesMu.Unlock()
+}
+
+// States returns the desired and actual state of every pool in the last reconcile.
+func (sl *Serverless) States() []PoolScaleState {
+	sl.statesMu.RLock()
+	defer sl.statesMu.RUnlock()
+	states := make([]PoolScaleState, 0, len(sl.states))
+	for _, ty := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+		if st, ok := sl.states[ty]; ok {
+			states = append(states, *st)
+		}
+	}
+	return states
+}
+
+// Utilization returns the utilization of every pool taken in the last reconcile.
+func (sl *Serverless) Utilization() map[string]float64 {
+	sl.statesMu.RLock()
+	defer sl.statesMu.RUnlock()
+	rs := make(map[string]float64, len(sl.states))
+	for k, st := range sl.states {
+		rs[k] = st.Utilization
+	}
+	return rs
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScaleRequestDue(t *testing.T) {
+	sc := &Scale{resendForScaleOut: time.Minute}
+	if !sc.due(2) {
+		t.Fatal("first request is not due")
+	}
+	sc.SetLastChange(2)
+	if sc.due(2) {
+		t.Fatal("same request due again within resend interval")
+	}
+	if !sc.due(3) {
+		t.Fatal("changed request is not due")
+	}
+	sc.lastSend = time.Now().Add(-2 * time.Minute).Unix()
+	if !sc.due(2) {
+		t.Fatal("same request not due after resend interval")
+	}
+}
+
+func TestPoolScaleStates(t *testing.T) {
+	sl := &Serverless{states: make(map[string]*PoolScaleState)}
+	sl.setState(&PoolScaleState{Pool: "ap", Desired: 4, Actual: 2, Utilization: 2})
+	sl.setState(&PoolScaleState{Pool: "tp", Desired: 1, Actual: 1, Utilization: 0.5})
+	states := sl.States()
+	if len(states) != 2 || states[0].Pool != "tp" || states[1].Pool != "ap" {
+		t.Fatalf("unexpected states %+v", states)
+	}
+	if u := sl.Utilization(); u["tp"] != 0.5 || u["ap"] != 2 {
+		t.Fatalf("unexpected utilization %v", u)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/proxy/backend"
+)
+
+// followerReadEligible reports whether the statement is a plain read that may be served
+// by a tikv follower, locking reads must go to the leader.
+func followerReadEligible(stmt ast.StmtNode) bool {
+	switch x := stmt.(type) {
+	case *ast.SelectStmt:
+		return x.LockInfo == nil || x.LockInfo.LockType == ast.SelectLockNone
+	case *ast.SetOprStmt:
+		return true
+	}
+	return false
+}
+
+// replicaRead returns the tidb_replica_read for the routing class of the backend,
+// ap reads go to followers unless configured otherwise.
+func (c *clientConn) replicaRead(conn *backend.BackendConn) string {
+	class := conn.GetDbType()
+	if class != backend.TiDBForTP {
+		class = backend.TiDBForAP
+	}
+	if mode, ok := c.server.cfg.Proxycfg.Cluster.ReplicaRead[class]; ok {
+		return mode
+	}
+	if class == backend.TiDBForAP {
+		return backend.ReplicaReadFollower
+	}
+	return backend.ReplicaReadLeader
+}
+
+// setReplicaRead sets the replica read of the backend session before the statement is
+// forwarded. It is set for every statement since pooled connections are shared by sessions
+// with and without serverless_follower_read.
+func (c *clientConn) setReplicaRead(conn *backend.BackendConn, stmt ast.StmtNode) error {
+	if conn.IsProxySelf() || conn.Conn == nil {
+		return nil
+	}
+	mode := backend.ReplicaReadLeader
+	if c.ctx.GetSessionVars().Proxy.FollowerRead && followerReadEligible(stmt) {
+		mode = c.replicaRead(conn)
+	}
+	return conn.SetReplicaRead(mode)
+}
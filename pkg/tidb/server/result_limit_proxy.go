@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/mysql"
+)
+
+// resultLimit returns the cap of result sets for the routing class of the backend.
+func (c *clientConn) resultLimit(conn *backend.BackendConn) backend.ResultLimit {
+	cfg, ok := c.server.cfg.Proxycfg.Cluster.ResultLimit[resultClass(conn)]
+	if !ok {
+		return backend.ResultLimit{}
+	}
+	return backend.ResultLimit{
+		MaxRows:  cfg.MaxRows,
+		MaxBytes: cfg.MaxBytes,
+		Truncate: cfg.Action == config.ResultLimitTruncate,
+	}
+}
+
+func resultClass(conn *backend.BackendConn) string {
+	if conn.GetDbType() == backend.TiDBForTP {
+		return backend.TiDBForTP
+	}
+	return backend.TiDBForAP
+}
+
+// checkResultLimit meters a result set over the limit, a truncated result gets a warning.
+func (c *clientConn) checkResultLimit(conn *backend.BackendConn, limit backend.ResultLimit, rs *mysql.Result, err error) {
+	switch {
+	case err != nil && backend.IsResultTooLarge(err):
+		metrics.ProxyResultLimitCounter.WithLabelValues(resultClass(conn), config.ResultLimitError).Inc()
+	case err == nil && rs != nil && rs.Resultset != nil && rs.Truncated:
+		metrics.ProxyResultLimitCounter.WithLabelValues(resultClass(conn), config.ResultLimitTruncate).Inc()
+		c.ctx.GetSessionVars().StmtCtx.AppendWarning(fmt.Errorf("result set truncated to %d rows by the proxy limit of %d rows or %d bytes",
+			rs.RowNumber(), limit.MaxRows, limit.MaxBytes))
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/privilege/privileges"
+	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/util/sqlexec"
+)
+
+type routeEntry struct {
+	key  string
+	cost int64
+}
+
+// routeVersion is the schema and the privileges the costs of the route cache were taken
+// with.
+type routeVersion struct {
+	schema int64
+	priv   *privileges.MySQLPrivilege
+}
+
+// routeCache keeps the plan cost of the statement digests routed by the proxy, the least
+// recently used first out. The statements forwarded to a backend by a cached cost are not
+// compiled by the proxy, so neither are their privileges checked. The costs are dropped when
+// the schema changes, so are the plans, and when the privileges are reloaded after a grant
+// or a revoke.
+type routeCache struct {
+	sync.Mutex
+	size    int
+	version routeVersion
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func newRouteCache(size int) *routeCache {
+	return &routeCache{size: size, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *routeCache) get(key string, version routeVersion) (int64, bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.invalidate(version)
+	e, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*routeEntry).cost, true
+}
+
+func (c *routeCache) put(key string, version routeVersion, cost int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.invalidate(version)
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*routeEntry).cost = cost
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&routeEntry{key: key, cost: cost})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*routeEntry).key)
+	}
+}
+
+// invalidate drops the costs taken with another schema or privileges, the caller holds the
+// lock.
+func (c *routeCache) invalidate(version routeVersion) {
+	if version == c.version {
+		return
+	}
+	c.version = version
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// routeCacheKey returns the key of a statement forwarded by its cost, its digest in the
+// current database as the same text reads other tables in another one. The key has the
+// user and its active roles, as a hit skips the privilege check of the compile, so a cost
+// is only used by the identity whose privileges were checked when it was taken.
+func (cc *clientConn) routeCacheKey(stmt ast.StmtNode) (string, bool) {
+	vars := cc.ctx.GetSessionVars()
+	if cc.server.routeCache == nil || !vars.Proxy.Userquery || vars.User == nil {
+		return "", false
+	}
+	switch stmt.(type) {
+	case *ast.SelectStmt, *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt:
+	default:
+		return "", false
+	}
+	return sessionUserKey(vars) + "\x00" + vars.CurrentDB + "\x00" + cc.stmtDigest(stmt.Text()), true
+}
+
+// stmtDigest returns the normalized digest of the statement, it is computed once for the
+// statement however many rules, pins and caches look it up.
+func (cc *clientConn) stmtDigest(sql string) string {
+	if cc.digest == "" || cc.digestSQL != sql {
+		_, d := parser.NormalizeDigest(sql)
+		cc.digestSQL, cc.digest = sql, d.String()
+	}
+	return cc.digest
+}
+
+// sessionUserKey returns the account and the active roles of the session, what the
+// privileges of its statements depend on.
+func sessionUserKey(vars *variable.SessionVars) string {
+	if vars.User == nil {
+		return ""
+	}
+	roles := make([]string, 0, len(vars.ActiveRoles))
+	for _, r := range vars.ActiveRoles {
+		roles = append(roles, r.String())
+	}
+	sort.Strings(roles)
+	return vars.User.AuthUsername + "@" + vars.User.AuthHostname + "\x00" + strings.Join(roles, ",")
+}
+
+// routeVersion returns the schema version and the privileges the proxy has loaded.
+func (cc *clientConn) routeVersion() routeVersion {
+	var version routeVersion
+	if cc.server.dom != nil {
+		version.schema = cc.server.dom.InfoSchema().SchemaMetaVersion()
+		if h := cc.server.dom.PrivilegeHandle(); h != nil {
+			version.priv = h.Get()
+		}
+	}
+	return version
+}
+
+// stmtCost compiles the statement for its plan cost, unless the cost of its digest is in
+// the route cache. Then the statement is nil, and is compiled only if the proxy runs it.
+func (cc *clientConn) stmtCost(ctx context.Context, stmt ast.StmtNode) (sqlexec.Statement, error) {
+	key, ok := cc.routeCacheKey(stmt)
+	if !ok {
+		return cc.ctx.GotStmtCostForProxy(ctx, stmt)
+	}
+	version := cc.routeVersion()
+	if cost, hit := cc.server.routeCache.get(key, version); hit {
+		if err := cc.ctx.ResetStmtForProxy(ctx, stmt); err != nil {
+			return nil, err
+		}
+		metrics.ProxyRouteCacheCounter.WithLabelValues("hit").Inc()
+		cc.ctx.GetSessionVars().Proxy.Cost = float64(cost)
+		return nil, nil
+	}
+	metrics.ProxyRouteCacheCounter.WithLabelValues("miss").Inc()
+	compiled, err := cc.ctx.GotStmtCostForProxy(ctx, stmt)
+	if err == nil {
+		cc.server.routeCache.put(key, version, int64(cc.ctx.GetSessionVars().Proxy.Cost))
+	}
+	return compiled, err
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/privilege/privileges"
+)
+
+func TestRouteCache(t *testing.T) {
+	c := newRouteCache(2)
+	v1 := routeVersion{schema: 1}
+	c.put("a", v1, 10)
+	c.put("b", v1, 20)
+	if cost, hit := c.get("a", v1); !hit || cost != 10 {
+		t.Fatalf("cost %d, hit %v", cost, hit)
+	}
+	//b is the least recently used
+	c.put("c", v1, 30)
+	if _, hit := c.get("b", v1); hit {
+		t.Fatal("least recently used cost kept")
+	}
+	if _, hit := c.get("a", v1); !hit {
+		t.Fatal("recently used cost dropped")
+	}
+	c.put("c", v1, 35)
+	if cost, _ := c.get("c", v1); cost != 35 || len(c.entries) != 2 {
+		t.Fatalf("cost %d of %d entries", cost, len(c.entries))
+	}
+
+	//a schema change drops every cost
+	if _, hit := c.get("a", routeVersion{schema: 2}); hit || len(c.entries) != 0 {
+		t.Fatal("cost kept after a schema change")
+	}
+
+	//so does a reload of the privileges, as hits skip the privilege check
+	v2 := routeVersion{schema: 2}
+	c.put("a", v2, 10)
+	if _, hit := c.get("a", routeVersion{schema: 2, priv: &privileges.MySQLPrivilege{}}); hit {
+		t.Fatal("cost kept after the privileges changed")
+	}
+}
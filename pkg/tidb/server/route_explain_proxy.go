@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/errors"
+)
+
+// EXPLAIN ROUTE <statement> shows where the statement would be routed without running it.
+var explainRouteRegexp = regexp.MustCompile(`(?is)^\s*explain\s+route\s+(.+?)\s*;?\s*$`)
+
+var explainRouteColumns = []string{"class", "cost", "route_by", "pool", "address", "state", "weight", "using_conns", "running_cost", "chosen", "note"}
+
+// statements of an open transaction or of a session holding temporary tables stay on its backend
+const (
+	routeByTxn       = "transaction"
+	routeByTempTable = "temp_table"
+)
+
+// handleExplainRoute compiles the statement for its cost and writes its class, the candidate
+// backends with their load and the backend chosen for it. The statement is not run, the round
+// robin cursor and the hits of plan pins are left as they are.
+func (cc *clientConn) handleExplainRoute(ctx context.Context, sql string) error {
+	stmts, err := cc.ctx.Parse(ctx, sql)
+	if err != nil {
+		return err
+	}
+	if len(stmts) != 1 {
+		return errors.ErrExplainRouteStmt
+	}
+	sessionVars := cc.ctx.GetSessionVars()
+	prevText, prevCost := sessionVars.Proxy.SQLtext, sessionVars.Proxy.Cost
+	defer func() {
+		sessionVars.Proxy.SQLtext, sessionVars.Proxy.Cost = prevText, prevCost
+	}()
+	sessionVars.Proxy.SQLtext, sessionVars.Proxy.Cost = stmts[0].Text(), 0
+	if _, err = cc.ctx.GotStmtCostForProxy(ctx, stmts[0]); err != nil {
+		return err
+	}
+	cc.estimateCost()
+	cost := int64(sessionVars.Proxy.Cost)
+	class := backend.CostClass(cost)
+
+	if co, by := cc.sessionConn(); co != nil {
+		addr := "self"
+		if !co.IsProxySelf() {
+			addr = co.GetDbAddr()
+		}
+		values := [][]interface{}{{class, cost, by, co.GetDbType(), addr, "", 0.0, int64(0), int64(0), "yes", ""}}
+		return cc.writeAdminResultset(ctx, explainRouteColumns, values)
+	}
+
+	ty, pod, by := cc.routeType(false)
+	plan := cc.cluster().ExplainRoute(ty, pod, cost)
+	values := make([][]interface{}, 0, len(plan.Candidates)+1)
+	for _, c := range plan.Candidates {
+		var chosen, note string
+		if c.Addr == plan.Choice {
+			chosen = "yes"
+		}
+		switch {
+		case c.Overloaded:
+			note = "overloaded"
+		case c.SchemaStale:
+			note = "schema stale"
+		}
+		values = append(values, []interface{}{class, cost, by, plan.Pool, c.Addr, c.State, c.Weight, c.UsingConns, c.Cost, chosen, note})
+	}
+	if plan.Choice == "" {
+		note := "a temporary tidb is started for the statement"
+		if plan.Err != nil {
+			note = plan.Err.Error()
+		}
+		values = append(values, []interface{}{class, cost, by, plan.Pool, "", "", 0.0, int64(0), int64(0), "yes", note})
+	}
+	return cc.writeAdminResultset(ctx, explainRouteColumns, values)
+}
+
+// sessionConn returns the backend the session keeps its statements on and why, or nil when
+// the statement is routed.
+func (cc *clientConn) sessionConn() (*backend.BackendConn, string) {
+	if cc.tempConn != nil {
+		return cc.tempConn, routeByTempTable
+	}
+	if cc.txConn != nil {
+		return cc.txConn, routeByTxn
+	}
+	return nil, ""
+}
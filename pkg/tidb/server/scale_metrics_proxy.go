@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+)
+
+// ScaleMetrics is the load of a pool served by /proxy/scale-metrics for external autoscalers,
+// e.g. the metrics-api scaler of keda with tp.utilization as value location.
+type ScaleMetrics struct {
+	// cores needed by the cost over the cores of the up backends, above 1 the pool needs more
+	Utilization float64 `json:"utilization"`
+	// statements waiting for a backend of the pool
+	Queued       int64   `json:"queued"`
+	ActualCores  float64 `json:"actual_cores"`
+	DesiredCores float64 `json:"desired_cores"`
+	Up           int     `json:"up"`
+}
+
+// publishScaleMetrics exports the state of the pool in the last reconcile as gauges, read by
+// a prometheus adapter for the kubernetes custom and external metrics apis.
+func publishScaleMetrics(st *PoolScaleState, pool *backend.Pool) {
+	metrics.ProxyPoolDesiredCoresGauge.WithLabelValues(st.Pool).Set(st.Desired)
+	metrics.ProxyPoolActualCoresGauge.WithLabelValues(st.Pool).Set(st.Actual)
+	metrics.ProxyPoolQueuedGauge.WithLabelValues(st.Pool).Set(float64(atomic.LoadInt64(&pool.Queued)))
+}
+
+func (s *Server) scaleMetrics() map[string]ScaleMetrics {
+	rs := make(map[string]ScaleMetrics)
+	if s.serverless == nil {
+		return rs
+	}
+	for _, st := range s.serverless.States() {
+		m := ScaleMetrics{
+			Utilization:  st.Utilization,
+			ActualCores:  st.Actual,
+			DesiredCores: st.Desired,
+			Up:           st.Up,
+		}
+		if pool, ok := s.cluster.BackendPools[st.Pool]; ok {
+			m.Queued = atomic.LoadInt64(&pool.Queued)
+		}
+		rs[st.Pool] = m
+	}
+	return rs
+}
+
+func (s *Server) handleScaleMetrics(w http.ResponseWriter, req *http.Request) {
+	writeData(w, s.scaleMetrics())
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/backend"
+)
+
+func TestScaleMetrics(t *testing.T) {
+	sl := &Serverless{states: make(map[string]*PoolScaleState)}
+	sl.setState(&PoolScaleState{Pool: "tp", Desired: 3, Actual: 2, Up: 2, Utilization: 1.5})
+	cluster := &backend.Cluster{BackendPools: map[string]*backend.Pool{"tp": {Queued: 7}}}
+	s := &Server{serverless: sl, cluster: cluster}
+	m := s.scaleMetrics()
+	tp, ok := m["tp"]
+	if !ok || len(m) != 1 {
+		t.Fatalf("unexpected scale metrics %+v", m)
+	}
+	if tp.Utilization != 1.5 || tp.Queued != 7 || tp.DesiredCores != 3 || tp.ActualCores != 2 || tp.Up != 2 {
+		t.Fatalf("unexpected tp scale metrics %+v", tp)
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/scalepb"
+	"github.com/pingcap/tidb/sessionctx/variable"
+)
+
+const (
+	defaultScaleInLatencyTolerance = 50
+	scaleInBaselineWindow          = 5 * time.Second
+
+	scaleInVerified = "verified"
+	scaleInRollback = "rollback"
+	scaleInTimeout  = "timeout"
+	scaleInFailed   = "failed"
+)
+
+type loadSample struct {
+	qps int64
+	//microseconds per statement
+	latency int64
+	costs   int64
+}
+
+// pureComputeCost is the load the proxy has to absorb as a pure compute node, everything
+// it already runs plus the tp class statements forwarded to tidbs. ap class statements
+// keep going to the ap pool so they don't count.
+func (s *Server) pureComputeCost() int64 {
+	costs := &s.cluster.ProxyNode.Costs
+	return costs.Origin(backend.OriginProxy) + costs.Load(backend.TiDBForTP, backend.OriginForward)
+}
+
+func (s *Server) sampleLoad() loadSample {
+	return loadSample{
+		qps:     atomic.LoadInt64(&s.counter.OldClientQPS),
+		latency: atomic.LoadInt64(&s.counter.OldClientLatency),
+		costs:   s.pureComputeCost(),
+	}
+}
+
+// averageLoad samples the load every second for the window, latency is averaged over
+// the seconds that served statements.
+func (s *Server) averageLoad(window time.Duration) loadSample {
+	var avg loadSample
+	var seconds, busy int64
+	for end := time.Now().Add(window); time.Now().Before(end); {
+		time.Sleep(time.Second)
+		cur := s.sampleLoad()
+		avg.qps += cur.qps
+		avg.costs += cur.costs
+		if cur.qps > 0 {
+			avg.latency += cur.latency
+			busy++
+		}
+		seconds++
+	}
+	if seconds > 0 {
+		avg.qps /= seconds
+		avg.costs /= seconds
+	}
+	if busy > 0 {
+		avg.latency /= busy
+	}
+	return avg
+}
+
+// tpBackendCores returns the cores and count of the dedicated tp tidbs, and the cores of the smallest one.
+func (s *Server) tpBackendCores() (total, smallest float64, count int) {
+	pool := s.cluster.BackendPools[backend.TiDBForTP]
+	pool.RLock()
+	defer pool.RUnlock()
+	for i, db := range pool.Tidbs {
+		if db.Self || i >= len(pool.TidbsWeights) {
+			continue
+		}
+		w := pool.TidbsWeights[i]
+		total += w
+		if count == 0 || w < smallest {
+			smallest = w
+		}
+		count++
+	}
+	return
+}
+
+func (s *Server) requestTPCores(caller string, cores float64) error {
+	return scaleCluster(caller, &scalepb.ScaleRequest{
+		Clustername: s.cfg.Proxycfg.Cluster.ClusterName,
+		Namespace:   s.cfg.Proxycfg.Cluster.NameSpace,
+		Hashrate:    float32(cores),
+		Scaletype:   backend.TiDBForTP,
+	})
+}
+
+// waitTPBackends waits until at most count dedicated tp tidbs are left in the pool.
+func (s *Server) waitTPBackends(count int, timeout time.Duration) bool {
+	for end := time.Now().Add(timeout); time.Now().Before(end); time.Sleep(time.Second) {
+		if _, _, n := s.tpBackendCores(); n <= count {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyScaleIn watches the load for the window, it returns why the scale in regressed,
+// or empty if qps and costs stay under the scale in thresholds and latency within tolerance.
+func (s *Server) verifyScaleIn(baseline loadSample, tolerance int, window time.Duration) string {
+	var latency, busy int64
+	for end := time.Now().Add(window); time.Now().Before(end); {
+		time.Sleep(time.Second)
+		cur := s.sampleLoad()
+		if cur.qps >= variable.ServerlessVariable.TPScaleInQPS.Load() {
+			return "qps"
+		}
+		if cur.costs >= variable.ServerlessVariable.TPScaleInCost.Load() {
+			return "costs"
+		}
+		if cur.qps > 0 {
+			latency += cur.latency
+			busy++
+		}
+	}
+	if baseline.latency > 0 && busy > 0 && latency/busy > baseline.latency*int64(100+tolerance)/100 {
+		return "latency"
+	}
+	return ""
+}
+
+// scaleInStepwise removes the dedicated tp tidbs one at a time instead of dropping them all
+// at once, each removal is verified for a window and scaled back out if the load regresses.
+func (s *Server) scaleInStepwise(window time.Duration) {
+	tolerance := s.cfg.Proxycfg.Cluster.ScaleInLatencyTolerance
+	if tolerance <= 0 {
+		tolerance = defaultScaleInLatencyTolerance
+	}
+	for {
+		total, smallest, count := s.tpBackendCores()
+		if count == 0 {
+			return
+		}
+		if err := s.cluster.CheckScaleIn(backend.TiDBForTP, "", count-1); err != nil {
+			return
+		}
+		baseline := s.averageLoad(scaleInBaselineWindow)
+		if err := s.requestTPCores(scaleByStepwise, total-smallest); err != nil {
+			metrics.ProxyScaleInStepCounter.WithLabelValues(scaleInFailed).Inc()
+			golog.Error("server", "scaleInStepwise", "request scale in failed", 0,
+				"cores", total-smallest, "error", err)
+			return
+		}
+		if !s.waitTPBackends(count-1, window) {
+			metrics.ProxyScaleInStepCounter.WithLabelValues(scaleInTimeout).Inc()
+			golog.Warn("server", "scaleInStepwise", "tp tidb not removed in verify window", 0,
+				"cores", total-smallest, "tidbs", count)
+			return
+		}
+		if reason := s.verifyScaleIn(baseline, tolerance, window); reason != "" {
+			metrics.ProxyScaleInStepCounter.WithLabelValues(scaleInRollback).Inc()
+			golog.Warn("server", "scaleInStepwise", "load regressed after scale in, scale back out", 0,
+				"reason", reason,
+				"cores", total,
+				"baseline_qps", baseline.qps,
+				"baseline_latency", baseline.latency)
+			if err := s.requestTPCores(scaleByStepRollback, total); err != nil {
+				golog.Error("server", "scaleInStepwise", "request scale out failed", 0,
+					"cores", total, "error", err)
+			}
+			return
+		}
+		metrics.ProxyScaleInStepCounter.WithLabelValues(scaleInVerified).Inc()
+		golog.Info("server", "scaleInStepwise", "scale in step verified", 0,
+			"cores", total-smallest, "tidbs", count-1)
+	}
+}
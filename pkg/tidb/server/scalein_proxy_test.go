@@ -0,0 +1,55 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/sessionctx/variable"
+)
+
+func newScaleInServer() *Server {
+	pool := &backend.Pool{
+		Tidbs:        []*backend.DB{{Self: true}, {}, {}, {}},
+		TidbsWeights: []float64{8, 4, 2, 6},
+	}
+	cluster := &backend.Cluster{
+		BackendPools: map[string]*backend.Pool{backend.TiDBForTP: pool},
+		ProxyNode:    &backend.Proxy{},
+	}
+	return &Server{cluster: cluster, counter: new(Counter)}
+}
+
+func TestTPBackendCores(t *testing.T) {
+	s := newScaleInServer()
+	//the proxy itself is not a dedicated tp tidb
+	if total, smallest, count := s.tpBackendCores(); total != 12 || smallest != 2 || count != 3 {
+		t.Fatalf("tp backends total %v smallest %v count %d, want 12 2 3", total, smallest, count)
+	}
+	if !s.waitTPBackends(3, time.Second) {
+		t.Fatal("3 tp tidbs left but still waiting")
+	}
+	if s.waitTPBackends(2, 0) {
+		t.Fatal("3 tp tidbs left but waited for 2")
+	}
+}
+
+func TestVerifyScaleIn(t *testing.T) {
+	s := newScaleInServer()
+	baseline := loadSample{qps: 10, latency: 1000}
+	atomic.StoreInt64(&s.counter.OldClientQPS, 10)
+
+	atomic.StoreInt64(&s.counter.OldClientLatency, 1400)
+	if reason := s.verifyScaleIn(baseline, 50, time.Second); reason != "" {
+		t.Fatalf("latency within tolerance regressed by %s", reason)
+	}
+	atomic.StoreInt64(&s.counter.OldClientLatency, 1600)
+	if reason := s.verifyScaleIn(baseline, 50, time.Second); reason != "latency" {
+		t.Fatalf("regressed by %q, want latency", reason)
+	}
+	atomic.StoreInt64(&s.counter.OldClientQPS, variable.ServerlessVariable.TPScaleInQPS.Load())
+	if reason := s.verifyScaleIn(baseline, 50, time.Second); reason != "qps" {
+		t.Fatalf("regressed by %q, want qps", reason)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pingcap/parser/ast"
+	parsermysql "github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/privilege"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/mysql"
+	"github.com/pingcap/tidb/sessionctx/variable"
+)
+
+// listingDB returns the database listed by SHOW TABLES, the current one if the statement
+// names none, and whether the listing cache answers the statement.
+func listingDB(show *ast.ShowStmt, current string) (string, bool) {
+	switch show.Tp {
+	case ast.ShowDatabases:
+		return "", true
+	case ast.ShowTables:
+		if show.DBName != "" {
+			return show.DBName, true
+		}
+		return current, current != ""
+	}
+	return "", false
+}
+
+// filterListing returns the rows whose name, the first column, is visible. The listing is
+// shared by the sessions, so the rows are copied instead of removed.
+func filterListing(rs *mysql.Resultset, visible func(name string) bool) *mysql.Resultset {
+	filtered := &mysql.Resultset{Fields: rs.Fields, FieldNames: rs.FieldNames}
+	for i := range rs.Values {
+		if name, err := rs.GetString(i, 0); err != nil || !visible(name) {
+			continue
+		}
+		filtered.Values = append(filtered.Values, rs.Values[i])
+		filtered.RowDatas = append(filtered.RowDatas, rs.RowDatas[i])
+	}
+	return filtered
+}
+
+// schemaListingKey returns the key of the listing of the database read from the cluster
+// for the account and roles of the session, the listing of a cluster is never the one of
+// another and the privileges may change it. The key has the schema version so a schema
+// change seen by the proxy reads the listing again.
+func schemaListingKey(cluster *backend.Cluster, vars *variable.SessionVars, version int64, db, sql string) string {
+	return tenantKey(cluster.Cfg.NameSpace, cluster.Cfg.ClusterName) + "\x00" + sessionUserKey(vars) + "\x00" +
+		db + "\x00" + strconv.FormatInt(version, 10) + "\x00" + sql
+}
+
+// listingCluster returns the cluster listing the database, the one of the session for
+// SHOW DATABASES.
+func (cc *clientConn) listingCluster(db string) *backend.Cluster {
+	if db == "" || cc.tenant != nil {
+		return cc.cluster()
+	}
+	return cc.server.clusterOf(db)
+}
+
+// writeSchemaListing answers SHOW DATABASES and SHOW TABLES from the listing read from one
+// tidb, filtered by the privileges of the session, it reports whether it did. The proxy
+// runs the statement itself when no tidb is up or the read fails.
+func (cc *clientConn) writeSchemaListing(ctx context.Context, stmt ast.StmtNode, lastStmt bool) (bool, error) {
+	show, ok := stmt.(*ast.ShowStmt)
+	if !ok || cc.server.schemaListing == nil {
+		return false, nil
+	}
+	db, ok := listingDB(show, cc.dbname)
+	if !ok {
+		return false, nil
+	}
+	vars := cc.ctx.GetSessionVars()
+	pm := privilege.GetPrivilegeManager(cc.ctx.Session)
+	checked := pm != nil && vars.User != nil
+	if checked && show.Tp == ast.ShowTables && !pm.DBIsVisible(vars.ActiveRoles, db) {
+		//the proxy returns the access denied error
+		return false, nil
+	}
+
+	var version int64
+	if cc.server.dom != nil {
+		version = cc.server.dom.InfoSchema().SchemaMetaVersion()
+	}
+	cluster := cc.listingCluster(db)
+	key := schemaListingKey(cluster, vars, version, db, stmt.Text())
+	result := "hit"
+	rs, hit := cc.server.schemaListing.get(key, time.Now())
+	if !hit {
+		preferred := cluster.Cfg.SchemaListing.Backend
+		if preferred == "" {
+			preferred = cluster.Cfg.AdminConn.Backend
+		}
+		var err error
+		if rs, _, err = cluster.FetchListing(preferred, db, stmt.Text()); err != nil || rs == nil {
+			if err != nil {
+				golog.Warn("server", "writeSchemaListing", "read the schema listing failed", 0,
+					"sql", stmt.Text(), "error", err.Error())
+			}
+			metrics.ProxySchemaListingCounter.WithLabelValues("proxy").Inc()
+			return false, nil
+		}
+		cc.server.schemaListing.put(key, rs, time.Now())
+		result = "fetched"
+	}
+	metrics.ProxySchemaListingCounter.WithLabelValues(result).Inc()
+
+	if checked {
+		rs = filterListing(rs, func(name string) bool {
+			if show.Tp == ast.ShowDatabases {
+				return pm.DBIsVisible(vars.ActiveRoles, name)
+			}
+			return pm.RequestVerification(vars.ActiveRoles, db, name, "", parsermysql.AllPrivMask)
+		})
+	}
+	return true, cc.writeResultsetForProxy(ctx, rs, cc.proxyStatus(lastStmt))
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/auth"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/mysql"
+	"github.com/pingcap/tidb/sessionctx/variable"
+)
+
+func TestListingDB(t *testing.T) {
+	p := parser.New()
+	cases := []struct {
+		sql     string
+		current string
+		db      string
+		ok      bool
+	}{
+		{"show databases", "", "", true},
+		{"show databases like 'a%'", "test", "", true},
+		{"show tables", "test", "test", true},
+		{"show full tables from app where Table_type = 'VIEW'", "test", "app", true},
+		{"show tables", "", "", false},
+		{"show table status", "test", "", false},
+		{"show processlist", "test", "", false},
+	}
+	for _, c := range cases {
+		stmt, err := p.ParseOneStmt(c.sql, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, ok := listingDB(stmt.(*ast.ShowStmt), c.current)
+		if db != c.db || ok != c.ok {
+			t.Fatalf("%s: expect %q %v, got %q %v", c.sql, c.db, c.ok, db, ok)
+		}
+	}
+}
+
+func TestFilterListing(t *testing.T) {
+	rs := &mysql.Resultset{
+		Fields:   []*mysql.Field{{Name: []byte("Database")}},
+		Values:   [][]interface{}{{"INFORMATION_SCHEMA"}, {"app"}, {"secret"}},
+		RowDatas: []mysql.RowData{mysql.RowData("a"), mysql.RowData("b"), mysql.RowData("c")},
+	}
+	filtered := filterListing(rs, func(name string) bool { return name != "secret" })
+	if len(filtered.Values) != 2 || len(filtered.RowDatas) != 2 || string(filtered.RowDatas[1]) != "b" {
+		t.Fatalf("unexpected listing %+v", filtered)
+	}
+	if len(rs.Values) != 3 {
+		t.Fatal("the shared listing was modified")
+	}
+}
+
+func TestSchemaListingKey(t *testing.T) {
+	home := &backend.Cluster{Cfg: proxyconfig.ClusterConfig{NameSpace: "ns0", ClusterName: "main"}}
+	tenant := &backend.Cluster{Cfg: proxyconfig.ClusterConfig{NameSpace: "ns1", ClusterName: "orders"}}
+	vars := variable.NewSessionVars()
+	vars.User = &auth.UserIdentity{Username: "app", Hostname: "10.0.0.1", AuthUsername: "app", AuthHostname: "%"}
+	key := schemaListingKey(home, vars, 1, "", "show databases")
+	if schemaListingKey(tenant, vars, 1, "", "show databases") == key {
+		t.Fatal("listing of the home cluster shared with a tenant cluster")
+	}
+	other := variable.NewSessionVars()
+	other.User = &auth.UserIdentity{Username: "admin", Hostname: "10.0.0.1", AuthUsername: "admin", AuthHostname: "%"}
+	if schemaListingKey(home, other, 1, "", "show databases") == key {
+		t.Fatal("listing shared by two accounts")
+	}
+	vars.ActiveRoles = []*auth.RoleIdentity{{Username: "reader", Hostname: "%"}}
+	if schemaListingKey(home, vars, 1, "", "show databases") == key {
+		t.Fatal("listing shared by two sets of roles")
+	}
+}
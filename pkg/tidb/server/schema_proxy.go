@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+const defaultTidbStatusPort = 10080
+
+type schemaVersionInfo struct {
+	SchemaVersion int64 `json:"schema_version"`
+}
+
+var schemaHTTPClient = &http.Client{Timeout: 2 * time.Second}
+
+func (s *Server) tidbStatusPort() int {
+	if port := s.cfg.Proxycfg.Cluster.TidbStatusPort; port > 0 {
+		return port
+	}
+	return defaultTidbStatusPort
+}
+
+// getBackendSchemaVersion asks the backend status server for its loaded schema version.
+func (s *Server) getBackendSchemaVersion(db *backend.DB) (int64, error) {
+	host := strings.Split(db.Addr(), ":")[0]
+	resp, err := schemaHTTPClient.Get(fmt.Sprintf("http://%s:%d/schema_version", host, s.tidbStatusPort()))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("get schema version from %s failed, status %d", host, resp.StatusCode)
+	}
+	var info schemaVersionInfo
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return 0, err
+	}
+	return info.SchemaVersion, nil
+}
+
+// checkSchemaVersion compares schema version of every backend with the proxy's own,
+// backends lagging behind are marked stale and skipped by the balancer until they catch up.
+func (s *Server) checkSchemaVersion() {
+	if s.dom == nil {
+		return
+	}
+	latest := s.dom.InfoSchema().SchemaMetaVersion()
+	for _, pool := range s.cluster.BackendPools {
+		pool.RLock()
+		tidbs := make([]*backend.DB, len(pool.Tidbs))
+		copy(tidbs, pool.Tidbs)
+		pool.RUnlock()
+
+		for _, db := range tidbs {
+			if db.Self {
+				continue
+			}
+			version, err := s.getBackendSchemaVersion(db)
+			if err != nil {
+				golog.Warn("server", "checkSchemaVersion", "get schema version failed", 0,
+					"addr", db.Addr(), "error", err)
+				continue
+			}
+			db.SetSchemaVersion(version, latest)
+			lag := latest - version
+			if lag < 0 {
+				lag = 0
+			}
+			metrics.ProxySchemaLagGauge.WithLabelValues(db.Addr()).Set(float64(lag))
+		}
+	}
+}
+
+func (s *Server) runSchemaChecker() {
+	interval := s.cfg.Proxycfg.Cluster.SchemaCheckInterval
+	if interval <= 0 {
+		return
+	}
+	for {
+		s.checkSchemaVersion()
+		if !s.pause(time.Duration(interval) * time.Second) {
+			return
+		}
+	}
+}
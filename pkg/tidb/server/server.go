@@ -46,7 +46,10 @@ import (
 	"github.com/pingcap/tidb/proxy/backend"
 	proxyconfig "github.com/pingcap/tidb/proxy/config"
 	"github.com/pingcap/tidb/proxy/core/golog"
-	"github.com/pingcap/tidb/proxy/scalepb"
+	"github.com/pingcap/tidb/proxy/estimator"
+	"github.com/pingcap/tidb/proxy/maintenance"
+	"github.com/pingcap/tidb/proxy/rewrite"
+	"github.com/pingcap/tidb/proxy/stats"
 	"github.com/pingcap/tidb/session/txninfo"
 	"github.com/pingcap/tidb/sessionctx/variable"
 	"github.com/pingcap/tidb/util"
@@ -104,6 +107,9 @@ var (
 	errSecureTransportRequired = dbterror.ClassServer.NewStd(errno.ErrSecureTransportRequired)
 	errMultiStatementDisabled  = dbterror.ClassServer.NewStd(errno.ErrMultiStatementDisabled)
 	errNewAbortingConnection   = dbterror.ClassServer.NewStd(errno.ErrNewAbortingConnection)
+	errServerShutdown          = dbterror.ClassServer.NewStd(errno.ErrServerShutdown)
+	errTooManyUserConnections  = dbterror.ClassServer.NewStd(errno.ErrTooManyUserConnections)
+	errHostIsBlocked           = dbterror.ClassServer.NewStd(errno.ErrHostIsBlocked)
 )
 
 // DefaultCapability is the capability of the server when it is created using the default configuration.
@@ -112,7 +118,7 @@ const defaultCapability = mysql.ClientLongPassword | mysql.ClientLongFlag |
 	mysql.ClientConnectWithDB | mysql.ClientProtocol41 |
 	mysql.ClientTransactions | mysql.ClientSecureConnection | mysql.ClientFoundRows |
 	mysql.ClientMultiStatements | mysql.ClientMultiResults | mysql.ClientLocalFiles |
-	mysql.ClientConnectAtts | mysql.ClientPluginAuth | mysql.ClientInteractive
+	mysql.ClientConnectAtts | mysql.ClientPluginAuth | mysql.ClientInteractive | clientSessionTrack
 
 
 const DefaultProxySize = "4.0"
@@ -124,9 +130,11 @@ type Server struct {
 	driver            IDriver
 	listener          net.Listener
 	socket            net.Listener
+	apListener        net.Listener
+	mysqlxListener    net.Listener
 	rwlock            sync.RWMutex
 	concurrentLimiter *TokenLimiter
-	clients           map[uint64]*clientConn
+	clients           *clientRegistry
 	capability        uint32
 	dom               *domain.Domain
 	globalConnID      util.GlobalConnID
@@ -138,16 +146,58 @@ type Server struct {
 	inShutdownMode bool
 	//for proxy
 	counter    *Counter
+	//counter of every second replayed by -simulate-autoscale, nil if not recorded
+	counterTrace *counterTrace
 	serverless *Serverless
 	cluster    *backend.Cluster
+	discovery  Discovery
+	//pool and addr of the tidbs syncPool is deleting
+	deletingTidbs sync.Map
+	//mirrors read only statements to a test tidb, nil if disabled
+	shadow *backend.Shadow
+	//leader and counters of the proxy replicas, nil if the proxy acts alone
+	coord *coordinator
+	//per user and per client ip connection limits
+	connLimits   *connLimits
+	authThrottle *authThrottle
+	//rate of new client connections, nil if not limited
+	acceptLimiter *acceptLimiter
+	//failed handshakes by reason
+	authFailures *authFailures
+	certAuth     *certAuth
+	cachingSha2  *cachingSha2
+	readOnly     *readOnlyMode
+	//clusters of other tenants routed by database
+	tenants *tenants
+	//apps labelling the statement metrics, nil if disabled
+	appLabels *appLabels
+	//background loops, stopped by Close before the listeners
+	loops *loops
+	//set while /proxy/debug/bundle takes its profiles, accessed atomically
+	debugBundleRunning int32
+	//results of metadata selects, nil if disabled
+	metadataCache *metadataCache
+	//listings of SHOW DATABASES and SHOW TABLES read from one tidb, nil if disabled
+	schemaListing *metadataCache
+	//plan cost of the routed statement digests, nil if disabled
+	routeCache *routeCache
+	//sessions waiting for the token limiter
+	tokenQueue *tokenQueue
+	//listeners replaced at runtime, guarded by rwlock
+	rebindMu        sync.Mutex
+	listenerErrChan chan error
+	draining        []*drainingListener
+	retired         map[net.Listener]struct{}
+	//address and socket the listeners are bound to, the config keeps the configured ones,
+	//guarded by rwlock
+	listenHost   string
+	listenPort   uint
+	listenSocket string
 }
 
 // ConnectionCount gets current connection count.
 func (s *Server) ConnectionCount() int {
-	s.rwlock.RLock()
-	cnt := len(s.clients)
-	s.rwlock.RUnlock()
-	return cnt
+	return s.clients.len()
 }
 
 func (s *Server) getToken() *Token {
@@ -200,9 +250,11 @@ func NewServer(cfg *config.Config, driver IDriver) (*Server, error) {
 		cfg:               cfg,
 		driver:            driver,
 		concurrentLimiter: NewTokenLimiter(cfg.TokenLimit),
-		clients:           make(map[uint64]*clientConn),
+		tokenQueue:        newTokenQueue(),
+		clients:           newClientRegistry(),
 		globalConnID:      util.GlobalConnID{ServerID: 0, Is64bits: true},
 		counter: new(Counter),
+		loops:   newLoops(),
 	}
 
 	if sl, err := parseServerless(s.cfg.Proxycfg, s, s.counter); err != nil {
@@ -211,13 +263,58 @@ func NewServer(cfg *config.Config, driver IDriver) (*Server, error) {
 		s.serverless = sl
 	}
 
-	cluster, err := parseCluster(cfg.Proxycfg.Cluster)
+	//backends are discovered in the background so the proxy serves before the tidb pods are ready
+	s.cluster = newCluster(cfg.Proxycfg.Cluster)
+	s.cluster.ForceRollback = s.forceRollback
+	s.shadow = backend.NewShadow(cfg.Proxycfg.Cluster.Shadow, cfg.Proxycfg.Cluster.User, cfg.Proxycfg.Cluster.Password)
+	s.connLimits = newConnLimits(cfg.Proxycfg)
+	s.coord = newCoordinator(cfg.Proxycfg)
+	s.authThrottle = newAuthThrottle(cfg.Proxycfg)
+	s.acceptLimiter = newAcceptLimiter(cfg.Proxycfg, time.Now())
+	s.authFailures = newAuthFailures()
+	s.readOnly = newReadOnlyMode(cfg.Proxycfg.ReadOnly)
+	s.tenants = newTenants()
+	s.appLabels = newAppLabels(cfg.Proxycfg.AppMetrics)
+	estimator.SetDefault(estimator.New(cfg.Proxycfg.CostEstimator))
+	if err := maintenance.Default().Load(cfg.Proxycfg.Cluster.MaintenanceWindows); err != nil {
+		return nil, err
+	}
+	if ca, err := newCertAuth(cfg.Proxycfg); err != nil {
+		return nil, err
+	} else {
+		s.certAuth = ca
+	}
+	if sha2, err := newCachingSha2(cfg.Proxycfg); err != nil {
+		return nil, err
+	} else {
+		s.cachingSha2 = sha2
+	}
+	if ttl := cfg.Proxycfg.MetadataCacheTTL; ttl > 0 {
+		s.metadataCache = newMetadataCache(time.Duration(ttl) * time.Second)
+	}
+	if ttl := cfg.Proxycfg.Cluster.SchemaListing.TTL; ttl > 0 {
+		s.schemaListing = newMetadataCache(time.Duration(ttl) * time.Second)
+	}
+	if size := cfg.Proxycfg.RouteCacheSize; size > 0 {
+		s.routeCache = newRouteCache(size)
+	}
+	if size := cfg.Proxycfg.TopQueries; size != 0 {
+		stats.DefaultTop().SetSize(size)
+	}
+	s.openUserStats()
+	if fileName := cfg.Proxycfg.Cluster.RulesFile; fileName != "" {
+		if err := rewrite.DefaultEngine().Open(fileName); err != nil {
+			golog.Warn("Server", "NewServer", "load rules file failed", 0,
+				"file", fileName, "error", err)
+		}
+	}
+	discovery, err := s.newDiscovery(&s.cluster.Cfg)
 	if err != nil {
-		golog.Error("Server", "parseCluster", err.Error(), 0)
+		golog.Error("Server", "newDiscovery", err.Error(), 0)
 		return nil, err
 	}
-
-	s.cluster = cluster
+	s.discovery = discovery
+	s.goLoop(s.bootstrapCluster)
 
 	setTxnScope()
 	tlsConfig, err := util.LoadTLSCertificates(s.cfg.Security.SSLCA, s.cfg.Security.SSLKey, s.cfg.Security.SSLCert)
@@ -238,6 +335,21 @@ func NewServer(cfg *config.Config, driver IDriver) (*Server, error) {
 	if s.tlsConfig != nil {
 		s.capability |= mysql.ClientSSL
 	}
+	if cfg.Proxycfg != nil {
+		s.setCompression(&cfg.Proxycfg.Compression)
+		backend.SetConnTimeouts(cfg.Proxycfg.Cluster.BackendConn)
+		backend.SetPodCacheTTL(cfg.Proxycfg.Cluster.PodCacheTTL)
+		backend.SetProxyCompute(cfg.Proxycfg.Cluster.ProxyCompute)
+		backend.SetErrorDecay(cfg.Proxycfg.Cluster.ErrorDecay)
+		if fileName := cfg.Proxycfg.Cluster.CounterTrace; fileName != "" {
+			trace, traceErr := openCounterTrace(fileName)
+			if traceErr != nil {
+				golog.Warn("server", "NewServer", "open counter trace failed", 0,
+					"file", fileName, "error", traceErr)
+			}
+			s.counterTrace = trace
+		}
+	}
 
 	if s.cfg.Host != "" && (s.cfg.Port != 0 || runInGoTest) {
 		addr := fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.Port)
@@ -261,10 +373,26 @@ func NewServer(cfg *config.Config, driver IDriver) (*Server, error) {
 		logutil.BgLogger().Info("server is running MySQL protocol", zap.String("socket", s.cfg.Socket))
 	}
 
+	if s.cfg.Proxycfg.ApAddr != "" {
+		if s.apListener, err = net.Listen("tcp", s.cfg.Proxycfg.ApAddr); err != nil {
+			return nil, errors.Trace(err)
+		}
+		logutil.BgLogger().Info("server is running MySQL protocol for analytics", zap.String("addr", s.cfg.Proxycfg.ApAddr))
+	}
+
+	if s.cfg.Proxycfg.MysqlxAddr != "" {
+		if s.mysqlxListener, err = net.Listen("tcp", s.cfg.Proxycfg.MysqlxAddr); err != nil {
+			return nil, errors.Trace(err)
+		}
+		logutil.BgLogger().Info("server is running X protocol", zap.String("addr", s.cfg.Proxycfg.MysqlxAddr),
+			zap.String("backend", s.cfg.Proxycfg.MysqlxBackend))
+	}
+
 	if s.socket == nil && s.listener == nil {
 		err = errors.New("Server not configured to listen on either -socket or -host and -port")
 		return nil, errors.Trace(err)
 	}
+	s.listenHost, s.listenPort, s.listenSocket = s.cfg.Host, s.cfg.Port, s.cfg.Socket
 
 	if s.cfg.ProxyProtocol.Networks != "" {
 		proxyTarget := s.listener
@@ -314,8 +442,7 @@ func parseServerless(cfg *proxyconfig.Config, server *Server, count *Counter) (*
 
 //for proxy
 
-func parseCluster(cfg proxyconfig.ClusterConfig) (*backend.Cluster, error) {
-	var err error
+func newCluster(cfg proxyconfig.ClusterConfig) *backend.Cluster {
 	cluster := new(backend.Cluster)
 	cluster.Cfg = cfg
 	//for test
@@ -326,74 +453,65 @@ func parseCluster(cfg proxyconfig.ClusterConfig) (*backend.Cluster, error) {
 		ProxyAsCompute: true,
 	}
 	cluster.DownAfterNoAlive = time.Duration(cfg.DownAfterNoAlive) * time.Second
-
-	var norms = []string{backend.TiDBForTP, backend.TiDBForAP}
-	for _, v := range norms {
-		var Podlist *v1.PodList
-
-		var Pod *v1.Pod
-		var timeCount int
-		for {
-			timeCount++
-			time.Sleep(600 * time.Millisecond)
-			if timeCount > 200 {
-				golog.Info("server", "NewServer", "wait pod ready more than 120s",0)
-				break
-			}
-			Podlist = &v1.PodList{}
-			Podlist.Items = make([]v1.Pod, 0)
-
-			if v == backend.TiDBForTP {
-				ProxyPodlist, err := GetProxyPod(cfg.ClusterName, cfg.NameSpace)
-				if err != nil || len(ProxyPodlist.Items) == 0 {
-					golog.Warn("server", "NewServer", "GetProxyPod fail or null pod",0,"the err is ",err)
-					break
-				}
-				for _, pod := range ProxyPodlist.Items {
-					Podlist.Items = append(Podlist.Items, pod)
-				}
-			}
-
-			NormalPodlist, err := GetPod(cfg.ClusterName, cfg.NameSpace, v)
-			if err != nil || len(NormalPodlist.Items) == 0 {
-				golog.Warn("server", "NewServer", "GetPod fail or null pod",0,"the err is ",err,"tidbtype is ",v)
-				break
-			}
-			for _, pod := range NormalPodlist.Items {
-				Podlist.Items = append(Podlist.Items, pod)
-			}
-
-			readyFlag := false
-			for _, v := range Podlist.Items {
-				golog.Info("Server", "ReadyOrNot", fmt.Sprint("podname is %s", v.Name), 0)
-				if IsPodReady(&v) {
-					Pod = v.DeepCopy()
-					readyFlag = true
-					break
-				}
-			}
-			if readyFlag == true {
-				break
+	cluster.ConcurrencyPerCore = cfg.ConcurrencyPerCore
+	cluster.WarmupWindow = time.Duration(cfg.WarmupPeriod) * time.Second
+	cluster.RebalanceInterval = time.Duration(cfg.RebalanceInterval) * time.Second
+	cluster.DrainTimeout = time.Duration(cfg.ScaleInDrainTimeout) * time.Second
+	if mode := cfg.Locality.Mode; mode != "" && mode != proxyconfig.LocalityNone {
+		cluster.SetLocality(mode, backend.LocalZone(&cfg))
+	}
+	if cfg.ApFairQueue.Enable {
+		apPool := cluster.BackendPools[backend.TiDBForAP]
+		cluster.APQueue = backend.NewFairQueue(func() int64 {
+			if cfg.ApFairQueue.MaxRunning > 0 {
+				return int64(cfg.ApFairQueue.MaxRunning)
 			}
-		}
+			return apPool.MaxConcurrent(cfg.ConcurrencyPerCore)
+		}, cfg.ApFairQueue.Weights)
+	}
+	return cluster
+}
 
-		if err = dnsCheck(Pod, &cluster.Cfg); err != nil {
-			return nil, err
-		}
-		tidbs := MakeTidbs(Podlist, cfg.NameSpace)
-		golog.Info("server", "NewServer", "Server running", 0, "tidbtype is ", v,
-			"Podlist string is ----------", tidbs)
+//discoverPool lists the pods of the pool once, it fails if the pods can't be listed
+//or none of them is ready yet. A pool without pods is empty, not an error.
+func discoverPool(cfg *proxyconfig.ClusterConfig, tidbType string) (string, error) {
+	Podlist := &v1.PodList{}
+	Podlist.Items = make([]v1.Pod, 0)
 
-		err = cluster.ParseTidbs(tidbs, v, cfg)
+	if tidbType == backend.TiDBForTP {
+		ProxyPodlist, err := GetProxyPod(cfg.PodLabels(), cfg.ClusterName, cfg.NameSpace)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
+		if len(ProxyPodlist.Items) == 0 {
+			return "", errNoProxyPod
+		}
+		Podlist.Items = append(Podlist.Items, ProxyPodlist.Items...)
 	}
 
-	cluster.Online = true
-	go cluster.CheckCluster()
+	NormalPodlist, err := GetPod(cfg.PodLabels(), cfg.ClusterName, cfg.NameSpace, tidbType)
+	if err != nil {
+		return "", err
+	}
+	if len(NormalPodlist.Items) == 0 {
+		return MakeTidbs(Podlist, cfg.NameSpace, cfg.PodLabels()), nil
+	}
+	Podlist.Items = append(Podlist.Items, NormalPodlist.Items...)
 
-	return cluster, nil
+	var Pod *v1.Pod
+	for _, v := range Podlist.Items {
+		if IsPodReady(&v) {
+			Pod = v.DeepCopy()
+			break
+		}
+	}
+	if Pod == nil {
+		return "", errNoReadyPod
+	}
+	if err = dnsCheck(Pod, cfg); err != nil {
+		return "", err
+	}
+	return MakeTidbs(Podlist, cfg.NameSpace, cfg.PodLabels()), nil
 }
 
 func dnsCheck(pod *v1.Pod, cfg *proxyconfig.ClusterConfig) error {
@@ -401,11 +519,11 @@ func dnsCheck(pod *v1.Pod, cfg *proxyconfig.ClusterConfig) error {
 		return nil
 	}
 	DNSTimeout := int64(60)
-	tcName := pod.Labels[InstanceLabelKey]
-	name := pod.Name + "." + tcName + "-tidb-peer" + "." + pod.Namespace
+	labels := cfg.PodLabels()
+	name := labels.PeerHost(pod.Name, pod.Labels, pod.Namespace)
 	dnscheck := fmt.Sprintf(`
       TIMEOUT_READY=%d
-      while ( ! nslookup %s || ! mysql -h%s -u%s  -p%s -P4000 --connect-timeout=2 -e "select 1;" )
+      while ( ! nslookup %s || ! mysql -h%s -u%s  -p%s -P%d --connect-timeout=2 -e "select 1;" )
       do
          # If TIMEOUT_READY is 0 we should never time out and exit
          TIMEOUT_READY=$(( TIMEOUT_READY-1 ))
@@ -415,7 +533,7 @@ func dnsCheck(pod *v1.Pod, cfg *proxyconfig.ClusterConfig) error {
                exit 1
            fi
          sleep 1
-      done`, DNSTimeout, name, name, cfg.User, cfg.Password)
+      done`, DNSTimeout, name, name, cfg.User, cfg.Password, labels.Port)
 	cmd := exec.Command("/bin/sh", "-c", dnscheck)
 	err := cmd.Start()
 	if err != nil {
@@ -427,7 +545,7 @@ func dnsCheck(pod *v1.Pod, cfg *proxyconfig.ClusterConfig) error {
 	return err
 }
 
-func MakeTidbs(Podlist *v1.PodList, ns string) string {
+func MakeTidbs(Podlist *v1.PodList, ns string, labels *proxyconfig.PodLabelConfig) string {
 	result := ""
 	if Podlist == nil {
 		return result
@@ -436,16 +554,15 @@ func MakeTidbs(Podlist *v1.PodList, ns string) string {
 		podname := v.Name
 		cpuNum := ""
 		for _, v1 := range v.Spec.Containers {
-			if v1.Name == "tidb" {
+			if v1.Name == labels.Container {
 				cpuNum = v1.Resources.Requests.Cpu().String()
 			}
 		}
 		cpuNum = getFloatCpu(cpuNum)
-		tcName := v.Labels[InstanceLabelKey]
-		if v.Labels[RoleInstanceLabelKey]== "proxy" {
+		if labels.IsRole(v.Labels, proxyconfig.RoleProxy) {
 			result = result + "self" + "@" + DefaultProxySize + ","
 		} else {
-			result = result + podname + "." + tcName + "-tidb-peer" + "." + ns + ":" + TidbPort + "@" + cpuNum + ","
+			result = result + labels.PeerAddr(podname, v.Labels, ns) + "@" + cpuNum + ","
 		}
 
 	}
@@ -489,97 +606,82 @@ func (s *Server) Run() error {
 		s.startStatusHTTP()
 	}
 
-	//check proxy is pure compute or complex.
-	go s.CheckClusterSilence()
-
 	// flush counter
-	go s.flushCounter()
+	s.goLoop(s.flushCounter)
+
+	//elect the replica sending scale requests and share the counters with the others
+	s.goLoop(s.runLeaderElection)
+	s.goLoop(s.runMembership)
+
+	//run the autoscaler of every pool
+	for _, ev := range s.serverless.evaluators {
+		ev := ev
+		s.goLoop(func() { s.runEvaluator(ev) })
+	}
 
-	//run serverless
-	go s.runserverless()
+	//register proxy topology for dashboard
+	s.goLoop(s.proxyTopologyKeeper)
+
+	//track schema version of backends
+	s.goLoop(s.runSchemaChecker)
+
+	//close idle or too old client connections
+	s.goLoop(s.runConnectionReaper)
+
+	//alert and roll back long transactions
+	s.goLoop(s.runLongTxnDetector)
+
+	//save the usage of every user for showback
+	s.goLoop(s.runUserStatsFlusher)
+
+	//compare statements run in the proxy with forwarded ones
+	s.goLoop(s.runOriginReport)
+
+	//keep headroom in the proxy serving as a compute node
+	s.goLoop(s.runLocalLoadSampler)
+
+	//serve the clusters of other tenants
+	s.goLoop(s.runTenants)
+
+	//follow backends outside kubernetes
+	s.goLoop(s.refreshDiscovery)
+	s.goLoop(s.reconcilePools)
+	s.goLoop(s.watchPDLoss)
 
 	// If error should be reported and exit the server it can be sent on this
 	// channel. Otherwise end with sending a nil error to signal "done"
 	errChan := make(chan error)
-	go s.startNetworkListener(s.listener, false, errChan)
-	go s.startNetworkListener(s.socket, true, errChan)
-	err := <-errChan
-	if err != nil {
-		return err
+	s.rebindMu.Lock()
+	s.listenerErrChan = errChan
+	s.rebindMu.Unlock()
+	go s.startNetworkListener(s.listener, false, false, errChan)
+	go s.startNetworkListener(s.socket, true, false, errChan)
+	go s.startNetworkListener(s.apListener, false, true, errChan)
+	go s.startMysqlxListener(s.mysqlxListener, errChan)
+	for i := 0; i < 4; i++ {
+		if err := <-errChan; err != nil {
+			return err
+		}
 	}
-	return <-errChan
+	return nil
 }
 
 func (s *Server) flushCounter() {
+	defer func() {
+		if s.counterTrace != nil {
+			s.counterTrace.close()
+		}
+	}()
 	for {
 		s.counter.FlushCounter()
-		time.Sleep(1 * time.Second)
-	}
-}
-
-func (s *Server) runserverless() {
-	for {
-		s.serverless.CheckServerless()
-		time.Sleep(1 * time.Second)
-	}
-}
-
-func (s *Server) CheckClusterSilence() {
-	var count int
-	for {
-		tppool := s.cluster.BackendPools[backend.TiDBForTP]
-		costs := s.cluster.BackendPools[backend.TiDBForTP].Costs + s.cluster.ProxyNode.ProxyCost
-		if costs < 10000 && s.counter.OldClientQPS < 100 {
-			count += 1
-			if count >= 15 {
-				if len(tppool.Tidbs) > 1 {
-					scaleReq := &scalepb.ScaleRequest{
-						Clustername: s.cfg.Proxycfg.Cluster.ClusterName,
-						Namespace:   s.cfg.Proxycfg.Cluster.NameSpace,
-						Hashrate:    0,
-						Scaletype:   backend.TiDBForTP,
-					}
-					_, err := ScalerClient.ScaleCluster(context.Background(), scaleReq)
-					if err != nil {
-						fmt.Errorf("fail to scale in all tp tidb node but proxy node: %s", err)
-					}
-				}
-				fmt.Println("proxy is as pure compute node, proxy cost is ", costs, " max cost for one sql is ", s.cluster.MaxCostPerSql, "normal tp cost is ", s.cluster.BackendPools[backend.TiDBForTP].Costs, ", qps is ", s.counter.OldClientQPS)
-				count = 0
-			}
-			//proxy service as a pure tp type compute node, and no need other tp type tidb.
-			//if !s.cluster.ProxyNode.ProxyAsCompute {
-			//	proxyAddr := "self" + "@" + DefaultProxySize
-			//	if err := s.cluster.AddTidb(proxyAddr, backend.TiDBForTP); err != nil {
-			//		fmt.Errorf("add proxy into tp pools failed: %s", err)
-			//	} else {
-			//		fmt.Println("add proxy into tp pools success when proxy as a pure compute node.")
-			//	}
-			//}
-
-		} else {
-			count = 0
-			if s.cluster.ProxyNode.ProxyAsCompute && len(tppool.Tidbs) == 1 {
-				scaleReq := &scalepb.ScaleRequest{
-					Clustername: s.cfg.Proxycfg.Cluster.ClusterName,
-					Namespace:   s.cfg.Proxycfg.Cluster.NameSpace,
-					Hashrate:    1,
-					Scaletype:   backend.TiDBForTP,
-				}
-				_, err := ScalerClient.ScaleCluster(context.Background(), scaleReq)
-				if err != nil {
-					fmt.Errorf("fail to scale out tp tidb node from 0 to 1: %s", err)
-				}
-			}
-			fmt.Println("proxy is as complex compute node, proxy cost is", costs, " max cost for one sql is ", s.cluster.MaxCostPerSql, "normal tp cost is ", s.cluster.BackendPools[backend.TiDBForTP].Costs)
-
+		s.recordCounterTrace(time.Now())
+		if !s.pause(1 * time.Second) {
+			return
 		}
-
-		time.Sleep(1 * time.Second)
 	}
 }
 
-func (s *Server) startNetworkListener(listener net.Listener, isUnixSocket bool, errChan chan error) {
+func (s *Server) startNetworkListener(listener net.Listener, isUnixSocket bool, forceAP bool, errChan chan error) {
 	if listener == nil {
 		errChan <- nil
 		return
@@ -589,6 +691,9 @@ func (s *Server) startNetworkListener(listener net.Listener, isUnixSocket bool,
 		if err != nil {
 			if opErr, ok := err.(*net.OpError); ok {
 				if opErr.Err.Error() == "use of closed network connection" {
+					if s.isRetired(listener) {
+						return
+					}
 					if s.inShutdownMode {
 						errChan <- nil
 					} else {
@@ -608,11 +713,15 @@ func (s *Server) startNetworkListener(listener net.Listener, isUnixSocket bool,
 			errChan <- err
 			return
 		}
+		if !isUnixSocket && s.limitAccept(conn) {
+			continue
+		}
 
 		clientConn := s.newConn(conn)
 		if isUnixSocket {
 			clientConn.isUnixSocket = true
 		}
+		clientConn.forceAP = forceAP
 
 		err = plugin.ForeachPlugin(plugin.Audit, func(p *plugin.Plugin) error {
 			authPlugin := plugin.DeclareAuditManifest(p.Manifest)
@@ -636,8 +745,8 @@ func (s *Server) startNetworkListener(listener net.Listener, isUnixSocket bool,
 			continue
 		}
 
-		if s.dom != nil && s.dom.IsLostConnectionToPD() {
-			logutil.BgLogger().Warn("reject connection due to lost connection to PD")
+		if !s.admitOnPDLoss(clientConn) {
+			s.observeHandshakeFailure(clientConn, authFailPDLost, nil)
 			terror.Log(clientConn.Close())
 			continue
 		}
@@ -660,9 +769,13 @@ func (s *Server) startShutdown() {
 	}
 }
 
-// Close closes the server.
+// Close closes the server. The scaling and the other background loops stop first, so none
+// of them scales the cluster or changes the pools while the proxy drains, then the
+// listeners close.
 func (s *Server) Close() {
 	s.startShutdown()
+	s.stopLoops(loopsStopTimeout)
+	s.shadow.Close()
 	s.rwlock.Lock() // prevent new connections
 	defer s.rwlock.Unlock()
 
@@ -676,6 +789,17 @@ func (s *Server) Close() {
 		terror.Log(errors.Trace(err))
 		s.socket = nil
 	}
+	if s.apListener != nil {
+		err := s.apListener.Close()
+		terror.Log(errors.Trace(err))
+		s.apListener = nil
+	}
+	if s.mysqlxListener != nil {
+		err := s.mysqlxListener.Close()
+		terror.Log(errors.Trace(err))
+		s.mysqlxListener = nil
+	}
+	s.closeDrainingListeners()
 	if s.statusServer != nil {
 		err := s.statusServer.Close()
 		terror.Log(errors.Trace(err))
@@ -685,13 +809,16 @@ func (s *Server) Close() {
 		s.grpcServer.Stop()
 		s.grpcServer = nil
 	}
+	s.quitSidecar()
+	s.flushUserStats()
 	metrics.ServerEventCounter.WithLabelValues(metrics.EventClose).Inc()
 }
 
 // onConn runs in its own goroutine, handles queries from this connection.
 func (s *Server) onConn(conn *clientConn) {
 	ctx := logutil.WithConnID(context.Background(), conn.connectionID)
-	if err := conn.handshake(ctx); err != nil {
+	if err := conn.handshakeWithDeadline(ctx); err != nil {
+		s.observeHandshakeFailure(conn, conn.authFailureReason(err), err)
 		if plugin.IsEnable(plugin.Audit) && conn.ctx != nil {
 			conn.ctx.GetSessionVars().ConnectionInfo = conn.connectInfo()
 			err = plugin.ForeachPlugin(plugin.Audit, func(p *plugin.Plugin) error {
@@ -717,10 +844,10 @@ func (s *Server) onConn(conn *clientConn) {
 	defer func() {
 		logutil.Logger(ctx).Debug("connection closed")
 	}()
-	s.rwlock.Lock()
-	s.clients[conn.connectionID] = conn
-	connections := len(s.clients)
-	s.rwlock.Unlock()
+	// the read lock keeps new connections out while the server is closing.
+	s.rwlock.RLock()
+	connections := s.clients.add(conn)
+	s.rwlock.RUnlock()
 	metrics.ConnGauge.Set(float64(connections))
 
 	sessionVars := conn.ctx.GetSessionVars()
@@ -763,6 +890,7 @@ func (s *Server) onConn(conn *clientConn) {
 }
 
 func (cc *clientConn) connectInfo() *variable.ConnectionInfo {
+	_, port, _ := cc.server.listenAddrs()
 	connType := "Socket"
 	if cc.isUnixSocket {
 		connType = "UnixSocket"
@@ -776,7 +904,7 @@ func (cc *clientConn) connectInfo() *variable.ConnectionInfo {
 		ClientIP:          cc.peerHost,
 		ClientPort:        cc.peerPort,
 		ServerID:          1,
-		ServerPort:        int(cc.server.cfg.Port),
+		ServerPort:        int(port),
 		User:              cc.user,
 		ServerOSLoginUser: osUser,
 		OSVersion:         osVersion,
@@ -794,9 +922,7 @@ func (s *Server) checkConnectionCount() error {
 		return nil
 	}
 
-	s.rwlock.RLock()
-	conns := len(s.clients)
-	s.rwlock.RUnlock()
+	conns := s.clients.len()
 
 	if conns >= int(s.cfg.MaxServerConnections) {
 		logutil.BgLogger().Error("too many connections",
@@ -808,11 +934,10 @@ func (s *Server) checkConnectionCount() error {
 
 // ShowProcessList implements the SessionManager interface.
 func (s *Server) ShowProcessList() map[uint64]*util.ProcessInfo {
-	s.rwlock.RLock()
-	defer s.rwlock.RUnlock()
-	rs := make(map[uint64]*util.ProcessInfo, len(s.clients))
-	for _, client := range s.clients {
-		if pi := client.ctx.ShowProcess(); pi != nil {
+	clients := s.clients.snapshot()
+	rs := make(map[uint64]*util.ProcessInfo, len(clients))
+	for _, client := range clients {
+		if pi := client.getCtx().ShowProcess(); pi != nil {
 			rs[pi.ID] = pi
 		}
 	}
@@ -821,12 +946,11 @@ func (s *Server) ShowProcessList() map[uint64]*util.ProcessInfo {
 
 // ShowTxnList shows all txn info for displaying in `TIDB_TRX`
 func (s *Server) ShowTxnList() []*txninfo.TxnInfo {
-	s.rwlock.RLock()
-	defer s.rwlock.RUnlock()
-	rs := make([]*txninfo.TxnInfo, 0, len(s.clients))
-	for _, client := range s.clients {
-		if client.ctx.Session != nil {
-			info := client.ctx.Session.TxnInfo()
+	clients := s.clients.snapshot()
+	rs := make([]*txninfo.TxnInfo, 0, len(clients))
+	for _, client := range clients {
+		if tctx := client.getCtx(); tctx.Session != nil {
+			info := tctx.Session.TxnInfo()
 			if info != nil {
 				rs = append(rs, info)
 			}
@@ -837,13 +961,11 @@ func (s *Server) ShowTxnList() []*txninfo.TxnInfo {
 
 // GetProcessInfo implements the SessionManager interface.
 func (s *Server) GetProcessInfo(id uint64) (*util.ProcessInfo, bool) {
-	s.rwlock.RLock()
-	conn, ok := s.clients[id]
-	s.rwlock.RUnlock()
+	conn, ok := s.clients.get(id)
 	if !ok {
 		return &util.ProcessInfo{}, false
 	}
-	return conn.ctx.ShowProcess(), ok
+	return conn.getCtx().ShowProcess(), ok
 }
 
 // Kill implements the SessionManager interface.
@@ -851,9 +973,7 @@ func (s *Server) Kill(connectionID uint64, query bool) {
 	logutil.BgLogger().Info("kill", zap.Uint64("connID", connectionID), zap.Bool("query", query))
 	metrics.ServerEventCounter.WithLabelValues(metrics.EventKill).Inc()
 
-	s.rwlock.RLock()
-	defer s.rwlock.RUnlock()
-	conn, ok := s.clients[connectionID]
+	conn, ok := s.clients.get(connectionID)
 	if !ok {
 		return
 	}
@@ -876,7 +996,7 @@ func (s *Server) getTLSConfig() *tls.Config {
 }
 
 func killConn(conn *clientConn) {
-	sessVars := conn.ctx.GetSessionVars()
+	sessVars := conn.getCtx().GetSessionVars()
 	atomic.StoreUint32(&sessVars.Killed, 1)
 	conn.mu.RLock()
 	cancelFunc := conn.mu.cancelFunc
@@ -888,11 +1008,13 @@ func killConn(conn *clientConn) {
 
 // KillAllConnections kills all connections when server is not gracefully shutdown.
 func (s *Server) KillAllConnections() {
+	if s.keepSessionsOnPDLoss() {
+		logutil.BgLogger().Warn("[server] keep all connections while lost connection to PD.")
+		return
+	}
 	logutil.BgLogger().Info("[server] kill all connections.")
 
-	s.rwlock.RLock()
-	defer s.rwlock.RUnlock()
-	for _, conn := range s.clients {
+	for _, conn := range s.clients.snapshot() {
 		atomic.StoreInt32(&conn.status, connStatusShutdown)
 		if err := conn.closeWithoutLock(); err != nil {
 			terror.Log(err)
@@ -948,14 +1070,12 @@ func (s *Server) GracefulDown(ctx context.Context, done chan struct{}) {
 
 func (s *Server) kickIdleConnection() {
 	var conns []*clientConn
-	s.rwlock.RLock()
-	for _, cc := range s.clients {
+	for _, cc := range s.clients.snapshot() {
 		if cc.ShutdownOrNotify() {
 			// Shutdowned conn will be closed by us, and notified conn will exist themselves.
 			conns = append(conns, cc)
 		}
 	}
-	s.rwlock.RUnlock()
 
 	for _, cc := range conns {
 		err := cc.Close()
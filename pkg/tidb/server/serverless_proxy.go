@@ -6,14 +6,19 @@ import (
 	"github.com/pingcap/tidb/proxy/backend"
 	"github.com/pingcap/tidb/proxy/config"
 	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/predictor"
 	"github.com/pingcap/tidb/proxy/scalepb"
-	"google.golang.org/grpc"
+	"github.com/pingcap/tidb/proxy/scaler"
+	"github.com/pingcap/tidb/sessionctx/variable"
 	"math"
+	"sync"
 	"time"
 )
 
 type Serverless struct {
 	multiScales map[string]*Scale
+	//autoscaler of every pool, each evaluated by its own loop
+	evaluators map[string]*poolEvaluator
 
 	//for servereless
 	proxy          *Server
@@ -22,6 +27,25 @@ type Serverless struct {
 
 	//for 0 core
 	silentPeriod int
+
+	//for predictive scale out
+	predictors       map[string]*predictor.HoltWinters
+	predictAhead     time.Duration
+	predictStateFile string
+
+	//for ap scale by routing queue
+	apQueueWait       time.Duration
+	apQueueIdle       time.Duration
+	apQueueEmptySince time.Time
+
+	//seconds the tp load stays under the pure compute thresholds, stepwise is set to 1
+	//while the stepwise scale in runs, accessed atomically
+	quietSeconds int64
+	stepwise     int32
+
+	//desired and actual state by pool, taken in the last reconcile
+	statesMu sync.RWMutex
+	states   map[string]*PoolScaleState
 }
 
 type Scale struct {
@@ -47,20 +71,40 @@ func (sl *Serverless) RestServerless(tidbType string) {
 const (
 	CostOneTpCore float64 = 1000000
 	CostOneApCore float64 = 2000000000
+	//cores of one replica when the pool has no tidb to refer to
+	DefaultReplicaCores float64 = 1
 )
-var ScalerClient scalepb.ScaleClient
 var ClusterName string
 var NameSpace string
 
-func GprcClientToCluster() error {
-	serviceName := "scale-operator.sldb-admin.svc:8028"
-	conn, err := grpc.Dial(serviceName, grpc.WithInsecure())
-	if err != nil {
-		golog.Fatal("serverless","GprcClientToCluster","gprc to scaler failed",0,"address",serviceName)
+//policies asking for a scale, recorded in the scale audit
+const (
+	scaleByAutoOut      = "auto_scale_out"
+	scaleByAutoIn       = "auto_scale_in"
+	scaleByPureCompute  = "pure_compute_scale_in"
+	scaleByComplex      = "complex_compute_scale_out"
+	scaleByStepwise     = "stepwise_scale_in"
+	scaleByStepRollback = "stepwise_rollback"
+	scaleBySldb         = "sldb"
+)
+
+func scaleCluster(caller string, req *scalepb.ScaleRequest) error {
+	if err := checkMaintenance(caller, req.Scaletype); err != nil {
 		return err
 	}
-	ScalerClient = scalepb.NewScaleClient(conn)
-	return nil
+	_, err := scaler.ScaleCluster(context.Background(), caller, req)
+	return err
+}
+
+func autoScalerCluster(caller string, req *scalepb.AutoScaleRequest, reason map[string]float64) {
+	if checkMaintenance(caller, req.Scaletype) != nil {
+		return
+	}
+	_, err := scaler.AutoScalerCluster(scaler.WithReason(context.Background(), reason), caller, req)
+	if err != nil {
+		golog.Error("serverless", "autoScalerCluster", "send auto scale request failed", 0,
+			"scaletype", req.Scaletype, "hashrate", req.Hashrate, "error", err)
+	}
 }
 
 func NewServerless(cfg *config.Config, srv *Server, count *Counter) (*Serverless, error) {
@@ -71,15 +115,15 @@ func NewServerless(cfg *config.Config, srv *Server, count *Counter) (*Serverless
 	s.multiScales = make(map[string]*Scale)
 	s.multiScales[backend.TiDBForTP] = &Scale{}
 	s.multiScales[backend.TiDBForAP] = &Scale{}
+	s.states = make(map[string]*PoolScaleState)
 
 	//s.allscaleinum = make([]float64, 12)
+	//the interval can be changed later by SET GLOBAL serverless_scalein_interval
 	if cfg.Cluster.ScaleInInterval != 0 {
-		s.multiScales[backend.TiDBForTP].scaleInInterval = cfg.Cluster.ScaleInInterval
-		s.multiScales[backend.TiDBForAP].scaleInInterval = cfg.Cluster.ScaleInInterval
-	} else {
-		s.multiScales[backend.TiDBForTP].scaleInInterval = 5
-		s.multiScales[backend.TiDBForAP].scaleInInterval = 5
+		variable.ServerlessVariable.ScaleInInterval.Store(int64(cfg.Cluster.ScaleInInterval))
 	}
+	s.multiScales[backend.TiDBForTP].scaleInInterval = int(variable.ServerlessVariable.ScaleInInterval.Load())
+	s.multiScales[backend.TiDBForAP].scaleInInterval = int(variable.ServerlessVariable.ScaleInInterval.Load())
 
 	ClusterName = cfg.Cluster.ClusterName
 	NameSpace = cfg.Cluster.NameSpace
@@ -94,37 +138,66 @@ func NewServerless(cfg *config.Config, srv *Server, count *Counter) (*Serverless
 		"address",
 		s.serverlessaddr)
 
-	GprcClientToCluster()
+	if cfg.Cluster.PredictAhead > 0 {
+		s.initPredictors(cfg.Cluster)
+	}
+	s.evaluators = make(map[string]*poolEvaluator)
+	for tidbType, scale := range s.multiScales {
+		s.evaluators[tidbType] = newPoolEvaluator(tidbType, cfg.Cluster.AutoscaleOf(tidbType), scale)
+	}
+	s.apQueueWait = time.Duration(cfg.Cluster.ApQueueWait) * time.Millisecond
+	s.apQueueIdle = time.Duration(cfg.Cluster.ApQueueIdle) * time.Second
+
+	scaler.Init(cfg.Scaler)
 
 	return s, nil
 }
 
-func (sl *Serverless) CheckServerless() {
-	for tidbtype, pool := range sl.proxy.cluster.BackendPools {
-		var addCost int64
-		if tidbtype == backend.TiDBForTP {
-			if pool.TotalCost[backend.LastCost] <= pool.TotalCost[backend.CurCost] {
-				addCost = int64(pool.TotalCost[backend.CurCost] - pool.TotalCost[backend.LastCost])
+func (sl *Serverless) predictFile(tidbType string) string {
+	if sl.predictStateFile == "" {
+		return ""
+	}
+	return sl.predictStateFile + "." + tidbType
+}
+
+func (sl *Serverless) initPredictors(cfg config.ClusterConfig) {
+	sl.predictAhead = time.Duration(cfg.PredictAhead) * time.Minute
+	sl.predictStateFile = cfg.PredictStateFile
+	sl.predictors = make(map[string]*predictor.HoltWinters)
+	for tidbType := range sl.multiScales {
+		m := predictor.NewDefault()
+		if fileName := sl.predictFile(tidbType); fileName != "" {
+			if saved, err := predictor.Load(fileName); err == nil {
+				m = saved
 			} else {
-				addCost = int64(pool.TotalCost[backend.CurCost])
+				golog.Warn("serverless", "initPredictors", "load predict model failed", 0,
+					"file", fileName, "error", err)
 			}
-			pool.TotalCost[backend.LastCost] = pool.TotalCost[backend.CurCost]
-		} else {
-			addCost = pool.Costs
-		}
-		needcore := sl.multiScales[tidbtype].GetNeedCores(addCost, tidbtype)
-		currentcore := sl.GetCurrentCores(tidbtype)
-		if needcore == currentcore {
-			continue
-		}
-		if needcore > currentcore {
-			fmt.Println("CheckServerless scaleout======",tidbtype,pool.Costs,addCost,pool.TotalCost[backend.LastCost],currentcore,needcore)
-			sl.multiScales[tidbtype].scaleout(currentcore, needcore, tidbtype)
-		} else {
-			sl.scalein(currentcore, needcore, tidbtype)
 		}
+		sl.predictors[tidbType] = m
 	}
+}
 
+//predictNeedCores trains the model with current cost, and returns cores needed by the
+//forecast peak in the coming predictAhead window, 0 if the model is not ready.
+func (sl *Serverless) predictNeedCores(cost int64, tidbType string) float64 {
+	m, ok := sl.predictors[tidbType]
+	if !ok {
+		return 0
+	}
+	now := time.Now()
+	if m.Observe(now, float64(cost)) {
+		if fileName := sl.predictFile(tidbType); fileName != "" {
+			if err := m.Save(fileName); err != nil {
+				golog.Warn("serverless", "predictNeedCores", "save predict model failed", 0,
+					"file", fileName, "error", err)
+			}
+		}
+	}
+	if !m.Ready() {
+		return 0
+	}
+	return sl.multiScales[tidbType].GetNeedCores(int64(m.Forecast(now, sl.predictAhead)), tidbType)
 }
 
 func (sl *Scale) GetlastSend() int64 {
@@ -136,7 +209,17 @@ func (sl *Scale) SetLastChange(diff float64) {
 	sl.lastchange = diff
 }
 
-func (sl*Scale)savePreFiveHashate(needcore float64) float64 {
+//due reports whether the cores are to be asked from the scaler, the cores asked last
+//time are only asked again after resendForScaleOut.
+func (sl *Scale) due(cores float64) bool {
+	resend := sl.resendForScaleOut
+	if resend <= 0 {
+		resend = defaultResendForScaleOut
+	}
+	return cores != sl.lastchange || time.Since(time.Unix(sl.lastSend, 0)) >= resend
+}
+
+func (sl*Scale)savePreFiveHashate(needcore float64, seconds int) float64 {
 	if sl.scaleInInterval == 0 {
 		sl.scaleInInterval = 1
 	}
@@ -145,7 +228,8 @@ func (sl*Scale)savePreFiveHashate(needcore float64) float64 {
 		length = sl.scaleInInterval
 	}
 	index := int(math.Ceil(float64(sl.scalueincout)/60))%length
-	if  sl.scalueincout % 60 == 0 {
+	//a new minute started
+	if  sl.scalueincout/60 != (sl.scalueincout-seconds)/60 {
 		sl.preFiveMinuteHashrate[index] = needcore
 	}
 	if needcore > sl.preFiveMinuteHashrate[index] {
@@ -160,15 +244,13 @@ func (sl*Scale)savePreFiveHashate(needcore float64) float64 {
 	return max
 }
 
-func (sl *Scale) SetScalein(diffcores, needcore float64, tidbtype string) {
-	sl.scalueincout++
-
-	if diffcores < sl.minscalinnum {
-		sl.minscalinnum = diffcores
-	}
-	needcore = sl.savePreFiveHashate(needcore)
+//SetScalein counts the seconds the pool needs fewer cores, seconds since the last call,
+//and asks the scaler for the most cores needed in the interval once it is over. It
+//reports whether it asked.
+func (sl *Scale) SetScalein(diffcores, needcore float64, tidbtype string, seconds int) bool {
+	needcore, due := sl.countScalein(diffcores, needcore, seconds)
 	fmt.Println("CheckServerless scalein======",tidbtype,needcore)
-	if sl.scalueincout > sl.scaleInInterval*60{
+	if due {
 		fmt.Printf("send scale in ")
 		req2 := &scalepb.AutoScaleRequest{
 			Clustername: ClusterName,
@@ -178,8 +260,13 @@ func (sl *Scale) SetScalein(diffcores, needcore float64, tidbtype string) {
 			Autoscaler: 2,
 			Scaletype: tidbtype,
 		}
-		ScalerClient.AutoScalerCluster(context.Background(),req2)
+		autoScalerCluster(scaleByAutoIn, req2, map[string]float64{
+			"need_cores":        needcore,
+			"min_surplus_cores": sl.minscalinnum,
+		})
+		sl.SetLastChange(needcore)
 		sl.resetscalein()
+		return true
 	}
 
 	/*if sl.scalueincout == 60 {
@@ -196,6 +283,20 @@ func (sl *Scale) SetScalein(diffcores, needcore float64, tidbtype string) {
 		sl.resetscalein()
 	}
 */
+	return false
+}
+
+//countScalein counts the seconds the pool needs fewer cores, it returns the most cores
+//needed in the scale in interval and whether the interval is over.
+func (sl *Scale) countScalein(diffcores, needcore float64, seconds int) (float64, bool) {
+	sl.scaleInInterval = int(variable.ServerlessVariable.ScaleInInterval.Load())
+	sl.scalueincout += seconds
+
+	if diffcores < sl.minscalinnum {
+		sl.minscalinnum = diffcores
+	}
+	needcore = sl.savePreFiveHashate(needcore, seconds)
+	return needcore, sl.scalueincout > sl.scaleInInterval*60
 }
 
 func (sl *Scale) resetscalein() {
@@ -205,16 +306,6 @@ func (sl *Scale) resetscalein() {
 
 }
 
-func (sl *Serverless) scalein(currentcore, needcore float64, tidbType string) {
-	//if sl.silentPeriod > 0 {
-	//	if needcore == 0 && sl.counter.QuiescentTotalTime > int64(sl.silentPeriod)*60 {
-	//		fmt.Printf("quiescent time %d > 30s post serverless scale down to 0 \n", sl.counter.QuiescentTotalTime)
-	//		return
-	//	}
-	//}
-	sl.multiScales[tidbType].SetScalein(currentcore - needcore, needcore, tidbType)
-}
-
 func (sl *Scale) scaleout(currentcore, needcore float64, tidbtype string) {
 	sl.resetscalein()
 
@@ -228,20 +319,20 @@ func (sl *Scale) scaleout(currentcore, needcore float64, tidbtype string) {
 		Scaletype: tidbtype,
 	}
 
-	//if (difference == sl.lastchange && time.Now().Unix()-sl.GetlastSend() > int64(sl.resendForScaleOut)) || difference != sl.lastchange {
-		fmt.Printf("scal out current %d,needcore is %d \n", currentcore, needcore)
-		ScalerClient.AutoScalerCluster(context.Background(),req)
-		//sl.SetLastChange(difference)
-	//}
+	//the caller checks the request is due, see due
+	autoScalerCluster(scaleByAutoOut, req, map[string]float64{
+		"current_cores": currentcore,
+		"need_cores":    needcore,
+	})
+	sl.SetLastChange(needcore)
 
 }
 
 func (sl *Serverless) GetCurrentCores(tidbType string) float64 {
-	tws := sl.proxy.cluster.BackendPools[tidbType].TidbsWeights
-	tidbs := sl.proxy.cluster.BackendPools[tidbType].Tidbs
+	tidbs, tws := sl.proxy.cluster.BackendPools[tidbType].Weights()
 	var currentcores float64
 	for index, tw := range tws {
-		if tidbs[index].Self {
+		if tidbs[index].Self || tidbs[index].State() != "up" {
 			continue
 		}
 		currentcores = currentcores + float64(tw)
@@ -249,14 +340,52 @@ func (sl *Serverless) GetCurrentCores(tidbType string) float64 {
 	return currentcores
 }
 
-func (sl *Scale) GetNeedCores(costs int64, tidbtype string) float64 {
-	var CostOneCore float64
-	switch tidbtype {
-	case backend.TiDBForAP:
-		CostOneCore = CostOneApCore
-	case backend.TiDBForTP:
-		CostOneCore = CostOneTpCore
+//boundNeedCores keeps the cores sent to the scaler within the replica floor and ceiling
+//of the pool, replica size is estimated by the smallest and largest tidb in the pool.
+func (sl *Serverless) boundNeedCores(tidbType string, needcore float64) float64 {
+	min, max := sl.proxy.cfg.Proxycfg.Cluster.ReplicaBounds(tidbType)
+	if min <= 0 && max <= 0 {
+		return needcore
 	}
+	smallest, largest := DefaultReplicaCores, DefaultReplicaCores
+	var replicas int
+	tidbs, tws := sl.proxy.cluster.BackendPools[tidbType].Weights()
+	for index, tw := range tws {
+		if tidbs[index].Self {
+			continue
+		}
+		if replicas == 0 || tw < smallest {
+			smallest = tw
+		}
+		if replicas == 0 || tw > largest {
+			largest = tw
+		}
+		replicas++
+	}
+	return boundCores(needcore, min, max, smallest, largest)
+}
+
+//boundCores keeps the cores within min replicas of the smallest size and max replicas of
+//the largest one, 0 means no bound.
+func boundCores(needcore float64, min, max int, smallest, largest float64) float64 {
+	if min > 0 && needcore < float64(min)*smallest {
+		needcore = float64(min) * smallest
+	}
+	if max > 0 && needcore > float64(max)*largest {
+		needcore = float64(max) * largest
+	}
+	return needcore
+}
+
+func costOneCore(tidbtype string) float64 {
+	if tidbtype == backend.TiDBForAP {
+		return CostOneApCore
+	}
+	return CostOneTpCore
+}
+
+func (sl *Scale) GetNeedCores(costs int64, tidbtype string) float64 {
+	CostOneCore := costOneCore(tidbtype)
 
 	if costs > int64(CostOneCore) {
 		return math.Ceil(float64(costs) / float64(CostOneCore))
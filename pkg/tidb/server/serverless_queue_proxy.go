@@ -0,0 +1,62 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// apQueueNeedCores adjusts the ap need cores by the routing queue. Cost only shows the work
+// already admitted, so when statements wait longer than ap_queue_wait for a backend the pool
+// grows by one replica even if the cost is low. Scale in is allowed only after the queue
+// stays empty for ap_queue_idle.
+func (sl *Serverless) apQueueNeedCores(pool *backend.Pool, needcore, currentcore float64) (float64, bool) {
+	maxWait := pool.TakeMaxWait()
+	queued := atomic.LoadInt64(&pool.Queued)
+	metrics.ProxyPoolQueuedGauge.WithLabelValues(backend.TiDBForAP).Set(float64(queued))
+	metrics.ProxyPoolQueueWaitGauge.WithLabelValues(backend.TiDBForAP).Set(maxWait.Seconds())
+
+	now := time.Now()
+	waitTooLong := sl.apQueueWait > 0 && maxWait > sl.apQueueWait
+	if queued > 0 || waitTooLong {
+		sl.apQueueEmptySince = time.Time{}
+	} else if sl.apQueueEmptySince.IsZero() {
+		sl.apQueueEmptySince = now
+	}
+
+	if waitTooLong && needcore <= currentcore {
+		replica := sl.smallestReplicaCores(backend.TiDBForAP)
+		golog.Info("serverless", "apQueueNeedCores", "ap statements wait too long, scale out", 0,
+			"max_wait", maxWait.String(),
+			"queued", queued,
+			"needcore", needcore,
+			"currentcore", currentcore,
+			"replica", replica)
+		needcore = currentcore + replica
+	}
+
+	if sl.apQueueIdle <= 0 {
+		return needcore, true
+	}
+	return needcore, !sl.apQueueEmptySince.IsZero() && now.Sub(sl.apQueueEmptySince) >= sl.apQueueIdle
+}
+
+// smallestReplicaCores returns the cores of the smallest tidb in the pool.
+func (sl *Serverless) smallestReplicaCores(tidbType string) float64 {
+	smallest := DefaultReplicaCores
+	var found bool
+	tidbs, tws := sl.proxy.cluster.BackendPools[tidbType].Weights()
+	for index, tw := range tws {
+		if index >= len(tidbs) || tidbs[index].Self {
+			continue
+		}
+		if !found || tw < smallest {
+			smallest = tw
+			found = true
+		}
+	}
+	return smallest
+}
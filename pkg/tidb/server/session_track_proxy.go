@@ -0,0 +1,137 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	proxymysql "github.com/pingcap/tidb/proxy/mysql"
+	"github.com/pingcap/tidb/sessionctx/variable"
+)
+
+const (
+	clientSessionTrack        = proxymysql.CLIENT_SESSION_TRACK
+	serverSessionStateChanged = proxymysql.SERVER_SESSION_STATE_CHANGED
+
+	sessionTrackSchema          = "session_track_schema"
+	sessionTrackSystemVariables = "session_track_system_variables"
+	sessionTrackStateChange     = "session_track_state_change"
+)
+
+// system variables tracked while session_track_system_variables is empty, the same as mysql
+var defaultTrackedSysVars = []string{
+	variable.TimeZone,
+	variable.AutoCommit,
+	variable.CharacterSetClient,
+	variable.CharacterSetResults,
+	variable.CharacterSetConnection,
+}
+
+// sessionStates are the session state changes told to the client by its next OK packet,
+// a later change of the same state replaces the earlier one.
+type sessionStates struct {
+	states []proxymysql.SessionState
+}
+
+func (s *sessionStates) add(states ...proxymysql.SessionState) {
+	for _, st := range states {
+		key := st.Key()
+		for i := range s.states {
+			if s.states[i].Key() == key {
+				s.states = append(s.states[:i], s.states[i+1:]...)
+				break
+			}
+		}
+		s.states = append(s.states, st)
+	}
+}
+
+// take returns the session state info of the changes and forgets them.
+func (s *sessionStates) take() []byte {
+	if len(s.states) == 0 {
+		return nil
+	}
+	data := proxymysql.DumpSessionStates(s.states)
+	s.states = nil
+	return data
+}
+
+// tracksSession reports whether the client asked for the session state changes.
+func (cc *clientConn) tracksSession() bool {
+	return cc.capability&clientSessionTrack > 0
+}
+
+// trackStates passes the session state changes told by the backend running the statement.
+func (cc *clientConn) trackStates(states ...proxymysql.SessionState) {
+	if cc.tracksSession() && len(states) > 0 {
+		cc.trackedState.add(states...)
+	}
+}
+
+func (cc *clientConn) sessionTrackVar(name string) string {
+	value, err := variable.GetSessionOrGlobalSystemVar(cc.ctx.GetSessionVars(), name)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// trackSchema tells the current database changed by USE or COM_INIT_DB, unless
+// session_track_schema is off.
+func (cc *clientConn) trackSchema() {
+	if !cc.tracksSession() {
+		return
+	}
+	//an empty value is the mysql default, on
+	if v := cc.sessionTrackVar(sessionTrackSchema); v == "" || variable.TiDBOptOn(v) {
+		cc.trackedState.add(proxymysql.SchemaState(cc.ctx.GetSessionVars().CurrentDB))
+	}
+	cc.trackStateChange()
+}
+
+func (cc *clientConn) trackStateChange() {
+	if variable.TiDBOptOn(cc.sessionTrackVar(sessionTrackStateChange)) {
+		cc.trackedState.add(proxymysql.StateChangeState())
+	}
+}
+
+// trackSet tells the session variables changed by a SET run by the proxy, the backends only
+// replay it, their OK packets are not seen by the client.
+func (cc *clientConn) trackSet(stmt *ast.SetStmt) {
+	if !cc.tracksSession() {
+		return
+	}
+	tracked := make(map[string]bool)
+	names := strings.Split(cc.sessionTrackVar(sessionTrackSystemVariables), ",")
+	if len(names) == 1 && names[0] == "" {
+		names = defaultTrackedSysVars
+	}
+	for _, name := range names {
+		tracked[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	changed := false
+	vars := cc.ctx.GetSessionVars()
+	for _, v := range stmt.Variables {
+		if v.IsGlobal {
+			continue
+		}
+		changed = true
+		var sysVars []string
+		switch {
+		case v.Name == ast.SetNames || v.Name == ast.SetCharset:
+			sysVars = []string{variable.CharacterSetClient, variable.CharacterSetResults, variable.CharacterSetConnection}
+		case v.IsSystem:
+			sysVars = []string{strings.ToLower(v.Name)}
+		}
+		for _, name := range sysVars {
+			if !tracked["*"] && !tracked[name] {
+				continue
+			}
+			if value, err := variable.GetSessionOrGlobalSystemVar(vars, name); err == nil {
+				cc.trackedState.add(proxymysql.SysVarState(name, value))
+			}
+		}
+	}
+	if changed {
+		cc.trackStateChange()
+	}
+}
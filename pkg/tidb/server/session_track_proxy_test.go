@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+
+	proxymysql "github.com/pingcap/tidb/proxy/mysql"
+)
+
+func TestSessionStates(t *testing.T) {
+	cc := &clientConn{}
+	cc.trackStates(proxymysql.SchemaState("test"))
+	if data := cc.trackedState.take(); data != nil {
+		t.Fatal("states tracked for a client without CLIENT_SESSION_TRACK")
+	}
+
+	cc.capability = clientSessionTrack
+	cc.trackStates(proxymysql.SysVarState("autocommit", "ON"), proxymysql.SchemaState("a"))
+	cc.trackStates(proxymysql.SchemaState("b"), proxymysql.SysVarState("time_zone", "UTC"), proxymysql.SysVarState("AUTOCOMMIT", "OFF"))
+	states, err := proxymysql.ParseSessionStates(cc.trackedState.take())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []proxymysql.SessionState{proxymysql.SchemaState("b"), proxymysql.SysVarState("time_zone", "UTC"), proxymysql.SysVarState("AUTOCOMMIT", "OFF")}
+	if len(states) != len(want) {
+		t.Fatalf("unexpected states %v", states)
+	}
+	for i := range want {
+		if states[i].Type != want[i].Type || string(states[i].Data) != string(want[i].Data) {
+			t.Fatalf("state %d is %v, want %v", i, states[i], want[i])
+		}
+	}
+	if cc.trackedState.take() != nil {
+		t.Fatal("states told twice")
+	}
+}
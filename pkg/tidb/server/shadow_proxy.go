@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/proxy/backend"
+)
+
+// ShadowReport is served by /proxy/shadow.
+type ShadowReport struct {
+	Enabled bool `json:"enabled"`
+	backend.ShadowStats
+}
+
+// mirrorShadow mirrors a sampled read only statement that passed on the pools to the
+// shadow tidb, as it was sent to the pools.
+func (cc *clientConn) mirrorShadow(stmt ast.StmtNode, sql string) {
+	shadow := cc.server.shadow
+	if !shadow.Sampled() || !ast.IsReadOnly(stmt) {
+		return
+	}
+	shadow.Mirror(cc.ctx.GetSessionVars().CurrentDB, sql)
+}
+
+func (s *Server) handleShadow(w http.ResponseWriter, req *http.Request) {
+	var report ShadowReport
+	if s.shadow != nil {
+		report.Enabled, report.ShadowStats = true, s.shadow.Stats()
+	}
+	writeData(w, report)
+}
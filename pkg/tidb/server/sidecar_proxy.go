@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pingcap/parser/terror"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+const sidecarPollInterval = 500 * time.Millisecond
+
+var sidecarClient = &http.Client{Timeout: time.Second}
+
+func (s *Server) sidecarConfig() *proxyconfig.SidecarConfig {
+	if s.cfg.Proxycfg == nil {
+		return &proxyconfig.SidecarConfig{}
+	}
+	return &s.cfg.Proxycfg.Sidecar
+}
+
+// waitSidecar blocks until the sidecar reports ready, dials before it fail in meshed
+// namespaces as the outbound traffic is redirected to it. The backends are dialed anyway
+// once the timeout is over, a proxy without sidecar would otherwise never serve.
+func (s *Server) waitSidecar() {
+	cfg := s.sidecarConfig()
+	if cfg.ReadyURL == "" {
+		return
+	}
+	timeout := time.Duration(cfg.ReadyTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = proxyconfig.DefaultSidecarReadyTimeout * time.Second
+	}
+	start := time.Now()
+	for !s.inShutdownMode {
+		resp, err := sidecarClient.Get(cfg.ReadyURL)
+		if err == nil {
+			terror.Log(resp.Body.Close())
+			if resp.StatusCode == http.StatusOK {
+				golog.Info("server", "waitSidecar", "sidecar ready", 0,
+					"waited", time.Since(start).String())
+				return
+			}
+		}
+		if time.Since(start) >= timeout {
+			golog.Warn("server", "waitSidecar", "sidecar not ready, dial backends anyway", 0,
+				"url", cfg.ReadyURL, "timeout", timeout.String(), "error", err)
+			return
+		}
+		time.Sleep(sidecarPollInterval)
+	}
+}
+
+// handlePreStop is the pre-stop hook of the proxy container. It reports unhealthy so no new
+// clients come, stops the scaling and waits for the client connections to close, the
+// sidecar keeps running meanwhile as it only quits after the proxy is closed.
+func (s *Server) handlePreStop(w http.ResponseWriter, req *http.Request) {
+	s.rwlock.RLock()
+	s.inShutdownMode = true
+	s.rwlock.RUnlock()
+	s.stopLoops(loopsStopTimeout)
+
+	deadline := time.Now().Add(time.Duration(s.sidecarConfig().DrainTimeout) * time.Second)
+	for s.ConnectionCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(sidecarPollInterval)
+	}
+	left := s.ConnectionCount()
+	golog.Info("server", "handlePreStop", "pre-stop done", 0, "connections", left)
+
+	writeData(w, struct {
+		Connections int `json:"connections"`
+	}{left})
+}
+
+// quitSidecar asks the sidecar to exit once the proxy is closed, otherwise the pod of a
+// job or a pod with a long grace period is kept running by the sidecar alone.
+func (s *Server) quitSidecar() {
+	cfg := s.sidecarConfig()
+	if cfg.QuitURL == "" {
+		return
+	}
+	resp, err := sidecarClient.Post(cfg.QuitURL, "", nil)
+	if err != nil {
+		golog.Warn("server", "quitSidecar", "quit sidecar failed", 0,
+			"url", cfg.QuitURL, "error", err)
+		return
+	}
+	terror.Log(resp.Body.Close())
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+const (
+	suspendIdle   = "suspend"
+	suspendResume = "resume"
+)
+
+// suspendAfter returns how long a session stays idle before its backend connection is
+// released, 0 if sessions are never suspended.
+func (cc *clientConn) suspendAfter() time.Duration {
+	if cc.server == nil || cc.server.cfg.Proxycfg == nil {
+		return 0
+	}
+	return time.Duration(cc.server.cfg.Proxycfg.SessionSuspendIdle) * time.Second
+}
+
+// canSuspend reports whether the session holds a backend connection it can give back.
+// Only the connection bound for prepared statements is kept between statements of a
+// stateless session.
+func (cc *clientConn) canSuspend() bool {
+	co := cc.prepareConn
+	return co != nil && !co.IsProxySelf() && co.GetBindConn() && cc.stateless()
+}
+
+// stateless reports whether the session keeps no state on its backend connection between
+// statements, sessions in a transaction or with temporary tables keep theirs.
+func (cc *clientConn) stateless() bool {
+	if cc.ctx == nil || cc.txConn != nil || cc.tempConn != nil || len(cc.tempTables) > 0 {
+		return false
+	}
+	sessionVars := cc.ctx.GetSessionVars()
+	return !sessionVars.InTxn() && sessionVars.IsAutocommit() && cc.ctx.Status()&mysql.ServerStatusInTrans == 0
+}
+
+// suspendIdle closes the statements prepared on the bound backend and puts its connection
+// back to the pool. The next statement gets a connection again and prepares them on it,
+// the same as after a scale event. The caller holds the connection while the client is idle.
+func (cc *clientConn) suspendIdle() {
+	if !cc.canSuspend() {
+		return
+	}
+	co := cc.prepareConn
+	for _, v := range cc.ctx.GetMapStatement() {
+		co.ClosePrepare(v.tidbId)
+	}
+	co.SetNoDelayFlase()
+	co.Close()
+	cc.setPrepareConn(nil)
+	atomic.StoreInt32(&cc.suspended, 1)
+	metrics.ProxySessionSuspendCounter.WithLabelValues(suspendIdle).Inc()
+	golog.Debug("server", "suspendIdle", "release backend of idle session", 0,
+		"connID", cc.connectionID, "addr", co.GetDbAddr())
+}
+
+// resumeSuspended counts the session getting a backend connection after it was suspended.
+func (cc *clientConn) resumeSuspended() {
+	if atomic.CompareAndSwapInt32(&cc.suspended, 1, 0) {
+		metrics.ProxySessionSuspendCounter.WithLabelValues(suspendResume).Inc()
+	}
+}
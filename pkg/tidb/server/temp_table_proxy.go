@@ -0,0 +1,109 @@
+package server
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/backend"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// tempTableKey names a temporary table by schema and table, lower cased.
+func (cc *clientConn) tempTableKey(name *ast.TableName) string {
+	schema := name.Schema.L
+	if schema == "" {
+		schema = strings.ToLower(cc.dbname)
+	}
+	return schema + "." + name.Name.L
+}
+
+// isTempTableStmt reports whether the statement creates a temporary table or drops one of
+// the temporary tables of the session, they run on the backend the session is pinned to.
+func (cc *clientConn) isTempTableStmt(stmt ast.StmtNode) bool {
+	switch x := stmt.(type) {
+	case *ast.CreateTableStmt:
+		return x.TemporaryKeyword == ast.TemporaryLocal
+	case *ast.DropTableStmt:
+		if x.IsView {
+			return false
+		}
+		if x.TemporaryKeyword == ast.TemporaryLocal {
+			return true
+		}
+		for _, t := range x.Tables {
+			if _, ok := cc.tempTables[cc.tempTableKey(t)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trackTempTables pins the session to the backend once it creates a temporary table, all
+// following statements go to that backend session until the temporary tables are dropped
+// or the session ends.
+func (cc *clientConn) trackTempTables(conn *backend.BackendConn, stmt ast.StmtNode) {
+	switch x := stmt.(type) {
+	case *ast.CreateTableStmt:
+		if x.TemporaryKeyword != ast.TemporaryLocal {
+			return
+		}
+		if cc.tempTables == nil {
+			cc.tempTables = make(map[string]struct{})
+		}
+		cc.tempTables[cc.tempTableKey(x.Table)] = struct{}{}
+		if cc.tempConn == nil {
+			conn.Pin()
+			cc.setTempConn(conn)
+			metrics.ProxySessionPinGauge.Inc()
+			golog.Info("server", "trackTempTables", "pin session to backend for temporary tables", 0,
+				"connID", cc.connectionID, "backend", bindBackend("temp", conn).Addr)
+		}
+	case *ast.DropTableStmt:
+		if x.IsView {
+			return
+		}
+		for _, t := range x.Tables {
+			delete(cc.tempTables, cc.tempTableKey(t))
+		}
+		if len(cc.tempTables) == 0 && cc.tempConn != nil {
+			cc.tempConn.Unpin()
+			cc.setTempConn(nil)
+			metrics.ProxySessionPinGauge.Dec()
+		}
+	}
+}
+
+// pinnedConn returns the backend the session is pinned to, the cost of the statement is
+// counted on it as for a routed statement.
+func (cc *clientConn) pinnedConn(cluster *backend.Cluster, cost int64) *backend.BackendConn {
+	co := cc.tempConn
+	if co == nil {
+		return nil
+	}
+	dbtype := co.GetDbType()
+	if co.IsProxySelf() {
+		atomic.AddInt64(&cluster.ProxyNode.ProxyCost, cost)
+		cluster.ProxyNode.Costs.Add(cost, backend.OriginProxy)
+		metrics.QueriesCounter.WithLabelValues(backend.TiDBForTP).Inc()
+	} else if dbtype == backend.TiDBForTP || dbtype == backend.TiDBForAP {
+		cluster.AddConnCost(co, cost)
+		cluster.ProxyNode.Costs.Add(cost, backend.OriginForward)
+		metrics.QueriesCounter.WithLabelValues(dbtype).Inc()
+	}
+	return co
+}
+
+// releaseTempTables drops the backend session holding the temporary tables when the client
+// session ends, so they are not seen by the sessions reusing the backend connection.
+func (cc *clientConn) releaseTempTables() {
+	if cc.tempConn == nil {
+		return
+	}
+	cc.tempConn.Discard()
+	cc.setTempConn(nil)
+	cc.tempTables = nil
+	metrics.ProxySessionPinGauge.Dec()
+}
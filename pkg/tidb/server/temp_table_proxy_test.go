@@ -0,0 +1,62 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/proxy/backend"
+)
+
+func TestTempTableTracking(t *testing.T) {
+	p := parser.New()
+	parse := func(sql string) ast.StmtNode {
+		stmt, err := p.ParseOneStmt(sql, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stmt
+	}
+	cc := &clientConn{dbname: "Test"}
+	if cc.isTempTableStmt(parse("drop table t")) {
+		t.Fatal("drop of a normal table runs on the pinned backend")
+	}
+	for sql, temp := range map[string]bool{
+		"create temporary table t (a int)": true,
+		"drop temporary table t":           true,
+		"create table t (a int)":           false,
+		"drop view t":                      false,
+	} {
+		if got := cc.isTempTableStmt(parse(sql)); got != temp {
+			t.Errorf("expect temporary %v for %q, got %v", temp, sql, got)
+		}
+	}
+
+	//the session is already pinned, the tables are tracked by schema and name
+	pinned := &backend.BackendConn{}
+	pinned.Pin()
+	cc.tempConn = pinned
+	cc.trackTempTables(pinned, parse("create temporary table T1 (a int)"))
+	cc.trackTempTables(pinned, parse("create temporary table other.t2 (a int)"))
+	cc.trackTempTables(pinned, parse("create table t3 (a int)"))
+	if len(cc.tempTables) != 2 {
+		t.Fatalf("unexpected temporary tables %v", cc.tempTables)
+	}
+	for _, key := range []string{"test.t1", "other.t2"} {
+		if _, ok := cc.tempTables[key]; !ok {
+			t.Fatalf("temporary table %s not tracked in %v", key, cc.tempTables)
+		}
+	}
+	if !cc.isTempTableStmt(parse("drop table test.t1")) || cc.isTempTableStmt(parse("drop table t2")) {
+		t.Fatal("drop of a temporary table not told apart by schema")
+	}
+
+	cc.trackTempTables(pinned, parse("drop table t1"))
+	if cc.tempConn != pinned || !pinned.IsPinned() {
+		t.Fatal("session unpinned while a temporary table is left")
+	}
+	cc.trackTempTables(pinned, parse("drop table other.t2"))
+	if cc.tempConn != nil || pinned.IsPinned() || len(cc.tempTables) != 0 {
+		t.Fatalf("session still pinned after dropping the temporary tables: %v", cc.tempTables)
+	}
+}
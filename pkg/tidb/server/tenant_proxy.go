@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/errno"
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/mysql"
+	"github.com/pingcap/tidb/proxy/util"
+	"github.com/pingcap/tidb/util/dbterror"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var errTenantDBDenied = dbterror.ClassServer.NewStd(errno.ErrDBaccessDenied)
+
+// tenantCluster is the tidb cluster of a tenant served besides the cluster of the proxy.
+type tenantCluster struct {
+	cluster   *backend.Cluster
+	discovery Discovery
+	databases []string
+	//found by the label selector rather than configured
+	selected bool
+}
+
+// tenants routes the databases of the tenants to their clusters.
+type tenants struct {
+	sync.RWMutex
+	//keyed by namespace/clustername
+	clusters map[string]*tenantCluster
+	byDB     map[string]*backend.Cluster
+}
+
+func newTenants() *tenants {
+	return &tenants{clusters: make(map[string]*tenantCluster), byDB: make(map[string]*backend.Cluster)}
+}
+
+func tenantKey(namespace, clusterName string) string {
+	return namespace + "/" + clusterName
+}
+
+// tenantDiscovery finds the ready tidb pods of a tenant cluster, the proxy is not a compute
+// node of tenant clusters.
+type tenantDiscovery struct {
+	cfg *proxyconfig.ClusterConfig
+}
+
+func (d *tenantDiscovery) Discover(tidbType string) (string, error) {
+	if util.KubeClient == nil {
+		return "", errNoKubeClient
+	}
+	pods, err := GetPod(d.cfg.PodLabels(), d.cfg.ClusterName, d.cfg.NameSpace, tidbType)
+	if err != nil {
+		return "", err
+	}
+	ready := &v1.PodList{}
+	for i := range pods.Items {
+		if IsPodReady(&pods.Items[i]) {
+			ready.Items = append(ready.Items, pods.Items[i])
+		}
+	}
+	return MakeTidbs(ready, d.cfg.NameSpace, d.cfg.PodLabels()), nil
+}
+
+// tenantConfig returns the config of a tenant cluster, the one of the proxy cluster with
+// the name and namespace of the tenant. The files of the proxy cluster are not shared.
+func tenantConfig(base proxyconfig.ClusterConfig, name, namespace string) proxyconfig.ClusterConfig {
+	cfg := base
+	cfg.ClusterName, cfg.NameSpace = name, namespace
+	cfg.Discovery = proxyconfig.DiscoveryConfig{Type: proxyconfig.DiscoveryK8s}
+	cfg.PoolSnapshotFile, cfg.RulesFile, cfg.PredictStateFile = "", "", ""
+	return cfg
+}
+
+// addTenant starts serving the databases by the tenant cluster, the cluster is open to
+// clients at once and its tidbs are added as they are found ready.
+func (s *Server) addTenant(name, namespace string, databases []string, selected bool) {
+	if len(databases) == 0 {
+		databases = []string{name}
+	}
+	key := tenantKey(namespace, name)
+	s.tenants.Lock()
+	defer s.tenants.Unlock()
+	if _, ok := s.tenants.clusters[key]; ok {
+		return
+	}
+	for _, db := range databases {
+		if _, ok := s.tenants.byDB[strings.ToLower(db)]; ok {
+			golog.Warn("server", "addTenant", "database already routed to another tenant", 0,
+				"tenant", key, "database", db)
+			return
+		}
+	}
+	cfg := tenantConfig(s.cfg.Proxycfg.Cluster, name, namespace)
+	cluster := newCluster(cfg)
+	cluster.ProxyNode.ProxyAsCompute = false
+	cluster.ProxyNode.Remote = true
+	t := &tenantCluster{
+		cluster:   cluster,
+		discovery: &tenantDiscovery{cfg: &cluster.Cfg},
+		databases: databases,
+		selected:  selected,
+	}
+	s.tenants.clusters[key] = t
+	for _, db := range databases {
+		s.tenants.byDB[strings.ToLower(db)] = cluster
+	}
+	startCluster(cluster)
+	golog.Info("server", "addTenant", "serve tenant cluster", 0,
+		"tenant", key, "databases", strings.Join(databases, ","))
+}
+
+// clusterOf returns the cluster serving the database, the cluster of the proxy unless the
+// database belongs to a tenant.
+func (s *Server) clusterOf(db string) *backend.Cluster {
+	if s.tenants != nil && db != "" {
+		s.tenants.RLock()
+		cluster, ok := s.tenants.byDB[strings.ToLower(db)]
+		s.tenants.RUnlock()
+		if ok {
+			return cluster
+		}
+	}
+	return s.cluster
+}
+
+// cluster returns the cluster the statements of the session are routed to, by its current
+// database. A session holding a backend conn stays on the cluster of the conn, a session
+// logged in on a tenant cluster never leaves it.
+func (cc *clientConn) cluster() *backend.Cluster {
+	if cc.tenant != nil {
+		return cc.tenant
+	}
+	if cc.routedCluster != nil && (cc.txConn != nil || cc.prepareConn != nil) {
+		return cc.routedCluster
+	}
+	return cc.server.clusterOf(cc.ctx.GetSessionVars().CurrentDB)
+}
+
+// handleTenantStmt forwards the statement of a session routed to a tenant cluster as the
+// client sent it. The proxy schema and privileges don't know the databases of the tenant,
+// so nothing is compiled locally: the statement runs on the backend session the tenant
+// authenticated the client on, and the tenant checks it against the grants of the client.
+// Other sessions are refused, the shared backend conns log in with the service account.
+func (cc *clientConn) handleTenantStmt(ctx context.Context, cluster *backend.Cluster, stmt ast.StmtNode, lastStmt bool) error {
+	co := cc.tenantConn
+	if cc.tenant != cluster || co == nil {
+		return errTenantDBDenied.GenWithStackByArgs(cc.user, cc.peerHost, cc.ctx.GetSessionVars().CurrentDB)
+	}
+	rs, err := cc.executeInNode(co, &TiDBStatement{sql: stmt.Text()}, nil)
+	if err != nil {
+		return err
+	}
+	vars := cc.ctx.GetSessionVars()
+	if use, ok := stmt.(*ast.UseStmt); ok {
+		vars.CurrentDB = use.DBName
+		cc.setDBName(use.DBName)
+	}
+	vars.StmtCtx.AddAffectedRows(rs.AffectedRows)
+	vars.StmtCtx.LastInsertID = rs.InsertId
+	cc.trackStates(rs.SessionStates...)
+	//the transaction is the one of the backend session
+	status := rs.Status
+	if !lastStmt {
+		status |= mysql.SERVER_MORE_RESULTS_EXISTS
+	}
+	if rs.Resultset != nil {
+		return cc.writeResultsetForProxy(ctx, rs.Resultset, status)
+	}
+	return cc.writeOkWith(ctx, "", rs.AffectedRows, rs.InsertId, status, 0)
+}
+
+// useTenantDB changes the current database of the backend session of a tenant session.
+func (cc *clientConn) useTenantDB(db string) error {
+	if err := cc.tenantConn.UseDB(db); err != nil {
+		return err
+	}
+	cc.ctx.GetSessionVars().CurrentDB = db
+	cc.setDBName(db)
+	return nil
+}
+
+// releaseTenantConn closes the backend session of a tenant session, it is never pooled.
+func (cc *clientConn) releaseTenantConn() {
+	if cc.tenantConn == nil {
+		return
+	}
+	cc.tenantConn.Unpin()
+	cc.tenantConn.Close()
+	cc.tenantConn = nil
+}
+
+// runTenants adds the configured tenant clusters, then keeps the tenants found by the
+// label selector and the tidbs of every tenant up to date.
+func (s *Server) runTenants() {
+	cfg := s.cfg.Proxycfg.Tenants
+	if len(cfg.Clusters) == 0 && cfg.Selector == "" {
+		return
+	}
+	for _, t := range cfg.Clusters {
+		s.addTenant(t.ClusterName, t.NameSpace, t.Databases, false)
+	}
+	interval := time.Duration(cfg.RefreshInterval) * time.Second
+	if interval <= 0 {
+		interval = proxyconfig.DefaultTenantRefreshInterval * time.Second
+	}
+	for !s.inShutdownMode {
+		if cfg.Selector != "" {
+			s.selectTenants(cfg.Selector)
+		}
+		s.syncTenants()
+		if !s.pause(interval) {
+			return
+		}
+	}
+}
+
+// selectTenants adds the clusters of the tidb pods matching the selector in every namespace.
+func (s *Server) selectTenants(selector string) {
+	if util.KubeClient == nil {
+		return
+	}
+	pods, err := util.KubeClient.CoreV1().Pods("").List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		golog.Warn("server", "selectTenants", "list tenant pods failed", 0, "selector", selector, "error", err)
+		return
+	}
+	clusterKey := s.cluster.Cfg.PodLabels().ClusterKey
+	for _, pod := range pods.Items {
+		name := pod.Labels[clusterKey]
+		if name == "" || pod.Namespace == s.cluster.Cfg.NameSpace && name == s.cluster.Cfg.ClusterName {
+			continue
+		}
+		s.addTenant(name, pod.Namespace, nil, true)
+	}
+}
+
+// syncTenants adds the ready tidbs of the tenant clusters missing in their pools and
+// deletes the ones gone.
+func (s *Server) syncTenants() {
+	s.tenants.RLock()
+	list := make([]*tenantCluster, 0, len(s.tenants.clusters))
+	for _, t := range s.tenants.clusters {
+		list = append(list, t)
+	}
+	s.tenants.RUnlock()
+	for _, t := range list {
+		for _, tidbType := range []string{backend.TiDBForTP, backend.TiDBForAP} {
+			tidbs, err := t.discovery.Discover(tidbType)
+			if err != nil {
+				golog.Warn("server", "syncTenants", "discover tenant tidbs failed", 0,
+					"tenant", t.cluster.Cfg.ClusterName, "tidbtype", tidbType, "error", err)
+				continue
+			}
+			added, gone := poolDiff(t.cluster, tidbType, tidbs)
+			if len(added) > 0 {
+				if err = t.cluster.AddTidb(added); err != nil {
+					golog.Warn("server", "syncTenants", "add tenant tidb later", 0,
+						"tenant", t.cluster.Cfg.ClusterName, "tidbtype", tidbType, "error", err)
+				}
+			}
+			for _, addr := range gone {
+				if err = t.cluster.DeleteTidb(addr, tidbType); err != nil {
+					golog.Error("server", "syncTenants", "delete tenant tidb failed", 0,
+						"tenant", t.cluster.Cfg.ClusterName, "addr", addr, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// TenantInfo is a tenant cluster shown by /proxy/tenants.
+type TenantInfo struct {
+	Cluster   string   `json:"cluster"`
+	Namespace string   `json:"namespace"`
+	Databases []string `json:"databases"`
+	Selected  bool     `json:"selected"`
+	TP        int      `json:"tp"`
+	AP        int      `json:"ap"`
+}
+
+func (s *Server) tenantInfos() []TenantInfo {
+	s.tenants.RLock()
+	defer s.tenants.RUnlock()
+	infos := make([]TenantInfo, 0, len(s.tenants.clusters))
+	for _, t := range s.tenants.clusters {
+		infos = append(infos, TenantInfo{
+			Cluster:   t.cluster.Cfg.ClusterName,
+			Namespace: t.cluster.Cfg.NameSpace,
+			Databases: t.databases,
+			Selected:  t.selected,
+			TP:        len(t.cluster.BackendPools[backend.TiDBForTP].Backends()),
+			AP:        len(t.cluster.BackendPools[backend.TiDBForAP].Backends()),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return tenantKey(infos[i].Namespace, infos[i].Cluster) < tenantKey(infos[j].Namespace, infos[j].Cluster)
+	})
+	return infos
+}
+
+func (s *Server) handleTenants(w http.ResponseWriter, req *http.Request) {
+	writeData(w, s.tenantInfos())
+}
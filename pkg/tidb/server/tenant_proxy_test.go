@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/tidb/proxy/backend"
+	proxyconfig "github.com/pingcap/tidb/proxy/config"
+)
+
+func TestTenantConfig(t *testing.T) {
+	base := proxyconfig.ClusterConfig{
+		ClusterName:      "main",
+		NameSpace:        "ns0",
+		RulesFile:        "/etc/rules.yaml",
+		PoolSnapshotFile: "/var/pools.json",
+		Discovery:        proxyconfig.DiscoveryConfig{Type: proxyconfig.DiscoveryStatic},
+	}
+	cfg := tenantConfig(base, "orders", "ns1")
+	if cfg.ClusterName != "orders" || cfg.NameSpace != "ns1" {
+		t.Fatalf("unexpected tenant %s/%s", cfg.NameSpace, cfg.ClusterName)
+	}
+	if cfg.RulesFile != "" || cfg.PoolSnapshotFile != "" {
+		t.Fatal("files of the proxy cluster shared by the tenant")
+	}
+	if cfg.Discovery.Type != proxyconfig.DiscoveryK8s {
+		t.Fatalf("tenant discovery %s, want k8s", cfg.Discovery.Type)
+	}
+	if base.ClusterName != "main" || base.RulesFile == "" {
+		t.Fatal("config of the proxy cluster changed")
+	}
+}
+
+func TestClusterOf(t *testing.T) {
+	main, orders := &backend.Cluster{}, &backend.Cluster{}
+	s := &Server{cluster: main, tenants: newTenants()}
+	s.tenants.byDB["orders"] = orders
+	if s.clusterOf("Orders") != orders {
+		t.Fatal("database of the tenant not routed to its cluster")
+	}
+	if s.clusterOf("test") != main || s.clusterOf("") != main {
+		t.Fatal("other databases not routed to the proxy cluster")
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/proxy/backend"
+)
+
+const (
+	// read engines of ap statements whose tables all have tiflash replicas, tikv is left
+	// out so the backend plans them on tiflash
+	tiflashReadEngines = "tiflash,tidb"
+	defaultReadEngines = "tikv,tiflash,tidb"
+)
+
+// tableNameCollector collects the tables referenced by a statement.
+type tableNameCollector struct {
+	tables []*ast.TableName
+}
+
+func (c *tableNameCollector) Enter(in ast.Node) (ast.Node, bool) {
+	if tn, ok := in.(*ast.TableName); ok {
+		c.tables = append(c.tables, tn)
+	}
+	return in, false
+}
+
+func (c *tableNameCollector) Leave(in ast.Node) (ast.Node, bool) {
+	return in, true
+}
+
+// tiflashReady reports whether every table the read references has an available tiflash
+// replica. Replicas are looked up in the schema the proxy loads from the cluster, unknown
+// tables such as cte names count as not ready.
+func (c *clientConn) tiflashReady(stmt ast.StmtNode) bool {
+	switch stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt:
+	default:
+		return false
+	}
+	if c.server.dom == nil {
+		return false
+	}
+	collector := &tableNameCollector{}
+	stmt.Accept(collector)
+	if len(collector.tables) == 0 {
+		return false
+	}
+	is := c.server.dom.InfoSchema()
+	for _, tn := range collector.tables {
+		schema := tn.Schema
+		if schema.L == "" {
+			schema = model.NewCIStr(c.dbname)
+		}
+		tbl, err := is.TableByName(schema, tn.Name)
+		if err != nil {
+			return false
+		}
+		if replica := tbl.Meta().TiFlashReplica; replica == nil || !replica.Available {
+			return false
+		}
+	}
+	return true
+}
+
+// setReadEngines steers ap reads to tiflash when their tables have replicas, the backend
+// session is set back to the default engines for everything else.
+func (c *clientConn) setReadEngines(conn *backend.BackendConn, stmt ast.StmtNode) error {
+	if !c.server.cfg.Proxycfg.Cluster.TiflashRouting || conn.IsProxySelf() || conn.Conn == nil {
+		return nil
+	}
+	engines := defaultReadEngines
+	if dbType := conn.GetDbType(); (dbType == backend.TiDBForAP || dbType == backend.BigCost) && c.tiflashReady(stmt) {
+		engines = tiflashReadEngines
+	}
+	return conn.SetReadEngines(engines)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/proxy/core/golog"
+)
+
+// length of the statement shown for a queued session
+const tokenWaitSQLLen = 256
+
+// tokenWaiter is a session queued for an execution token.
+type tokenWaiter struct {
+	ID      uint64    `json:"id"`
+	User    string    `json:"user"`
+	Host    string    `json:"host"`
+	Since   time.Time `json:"since"`
+	Wait    float64   `json:"wait_seconds"`
+	Command string    `json:"command"`
+	Digest  string    `json:"digest,omitempty"`
+	SQL     string    `json:"sql,omitempty"`
+}
+
+// tokenQueue is the sessions waiting for the token limiter.
+type tokenQueue struct {
+	sync.Mutex
+	waiters map[uint64]*tokenWaiter
+}
+
+func newTokenQueue() *tokenQueue {
+	return &tokenQueue{waiters: make(map[uint64]*tokenWaiter)}
+}
+
+func (q *tokenQueue) add(w *tokenWaiter) {
+	q.Lock()
+	q.waiters[w.ID] = w
+	q.Unlock()
+	metrics.ProxyTokenQueueGauge.Inc()
+}
+
+func (q *tokenQueue) remove(id uint64) {
+	q.Lock()
+	delete(q.waiters, id)
+	q.Unlock()
+	metrics.ProxyTokenQueueGauge.Dec()
+}
+
+// snapshot returns the queued sessions, longest waiting first.
+func (q *tokenQueue) snapshot(now time.Time) []tokenWaiter {
+	q.Lock()
+	waiters := make([]tokenWaiter, 0, len(q.waiters))
+	for _, w := range q.waiters {
+		v := *w
+		v.Wait = now.Sub(v.Since).Seconds()
+		waiters = append(waiters, v)
+	}
+	q.Unlock()
+	sort.Slice(waiters, func(i, j int) bool { return waiters[i].Since.Before(waiters[j].Since) })
+	return waiters
+}
+
+// tokenQueueInfo is the token limiter state served by /proxy/token-queue.
+type tokenQueueInfo struct {
+	Capacity int           `json:"capacity"`
+	InUse    int           `json:"in_use"`
+	Queued   []tokenWaiter `json:"queued"`
+}
+
+func (s *Server) tokenQueueInfo() tokenQueueInfo {
+	capacity := int(s.concurrentLimiter.count)
+	info := tokenQueueInfo{
+		Capacity: capacity,
+		InUse:    capacity - s.concurrentLimiter.Available(),
+		Queued:   []tokenWaiter{},
+	}
+	if s.tokenQueue != nil {
+		info.Queued = s.tokenQueue.snapshot(time.Now())
+	}
+	return info
+}
+
+// getTokenForProxy gets the execution token for the command, a session that has to wait
+// is listed in the token queue and logged with its statement if it waits too long. A
+// session finding a free token skips the queue even if it loses the race for it.
+func (cc *clientConn) getTokenForProxy(cmd byte, data []byte) *Token {
+	s := cc.server
+	if s.tokenQueue == nil || s.concurrentLimiter.Available() > 0 {
+		return s.getToken()
+	}
+	w := &tokenWaiter{
+		ID:      cc.connectionID,
+		User:    cc.user,
+		Host:    cc.peerHost,
+		Since:   time.Now(),
+		Command: mysql.Command2Str[cmd],
+	}
+	if cmd == mysql.ComQuery {
+		w.SQL = string(data)
+		if len(w.SQL) > tokenWaitSQLLen {
+			w.SQL = w.SQL[:tokenWaitSQLLen]
+		}
+	}
+	s.tokenQueue.add(w)
+	token := s.getToken()
+	s.tokenQueue.remove(w.ID)
+
+	if threshold := s.tokenWaitLogThreshold(); threshold > 0 {
+		if wait := time.Since(w.Since); wait >= threshold {
+			var digest string
+			if cmd == mysql.ComQuery {
+				_, d := parser.NormalizeDigest(string(data))
+				digest = d.String()
+			}
+			golog.Warn("server", "getTokenForProxy", "session waited long for token", 0,
+				"connID", cc.connectionID, "user", cc.user, "host", cc.peerHost,
+				"wait", wait.String(), "command", w.Command, "digest", digest)
+		}
+	}
+	return token
+}
+
+func (s *Server) tokenWaitLogThreshold() time.Duration {
+	if s.cfg.Proxycfg == nil {
+		return 0
+	}
+	return time.Duration(s.cfg.Proxycfg.TokenWaitLogThreshold) * time.Millisecond
+}
@@ -33,6 +33,11 @@ func (tl *TokenLimiter) Get() *Token {
 	return <-tl.ch
 }
 
+// Available returns the number of free tokens.
+func (tl *TokenLimiter) Available() int {
+	return len(tl.ch)
+}
+
 // NewTokenLimiter creates a TokenLimiter with count tokens.
 func NewTokenLimiter(count uint) *TokenLimiter {
 	tl := &TokenLimiter{count: count, ch: make(chan *Token, count)}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/ddl/util"
+	"github.com/pingcap/tidb/domain/infosync"
+	"github.com/pingcap/tidb/owner"
+	"github.com/pingcap/tidb/util/logutil"
+	"github.com/pingcap/tidb/util/versioninfo"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/concurrency"
+	"go.uber.org/zap"
+)
+
+const (
+	// ProxyTopologyPath is the etcd path for storing proxy topology, watched by TiDB Dashboard.
+	ProxyTopologyPath     = "/topology/serverless-proxy"
+	proxyTopologyRetryCnt = 3
+)
+
+type proxyBackendTopology struct {
+	Address string  `json:"address"`
+	Weight  float64 `json:"weight"`
+	Status  string  `json:"status"`
+	Self    bool    `json:"self"`
+}
+
+type proxyTopologyInfo struct {
+	Version        string                            `json:"version"`
+	GitHash        string                            `json:"git_hash"`
+	IP             string                            `json:"ip"`
+	Port           uint                              `json:"port"`
+	StatusPort     uint                              `json:"status_port"`
+	StatusAddress  string                            `json:"status_address"`
+	StartTimestamp int64                             `json:"start_timestamp"`
+	ProxyAsCompute bool                              `json:"proxy_as_compute"`
+	Backends       map[string][]proxyBackendTopology `json:"backends"`
+}
+
+// proxyTopologyKey returns the etcd key of the proxy, it changes with the port of a rebind.
+func (s *Server) proxyTopologyKey() string {
+	_, port, _ := s.listenAddrs()
+	return fmt.Sprintf("%s/%s:%v", ProxyTopologyPath, s.cfg.AdvertiseAddress, port)
+}
+
+func (s *Server) getProxyTopologyInfo(startTS int64) proxyTopologyInfo {
+	_, port, _ := s.listenAddrs()
+	info := proxyTopologyInfo{
+		Version:        mysql.TiDBReleaseVersion,
+		GitHash:        versioninfo.TiDBGitHash,
+		IP:             s.cfg.AdvertiseAddress,
+		Port:           port,
+		StatusPort:     s.cfg.Status.StatusPort,
+		StatusAddress:  fmt.Sprintf("%s:%d", s.cfg.AdvertiseAddress, s.cfg.Status.StatusPort),
+		StartTimestamp: startTS,
+		Backends:       make(map[string][]proxyBackendTopology),
+	}
+	if s.cluster == nil {
+		return info
+	}
+	info.ProxyAsCompute = s.cluster.ProxyNode.ProxyAsCompute
+	for tidbType, pool := range s.cluster.BackendPools {
+		pool.RLock()
+		backends := make([]proxyBackendTopology, 0, len(pool.Tidbs))
+		for i, db := range pool.Tidbs {
+			b := proxyBackendTopology{
+				Address: db.Addr(),
+				Status:  db.State(),
+				Self:    db.Self,
+			}
+			if i < len(pool.TidbsWeights) {
+				b.Weight = pool.TidbsWeights[i]
+			}
+			backends = append(backends, b)
+		}
+		pool.RUnlock()
+		info.Backends[tidbType] = backends
+	}
+	return info
+}
+
+// storeProxyTopology stores the proxy info under key and refreshes the ttl key bound to the
+// session lease.
+func (s *Server) storeProxyTopology(ctx context.Context, etcdCli *clientv3.Client, session *concurrency.Session, key string, startTS int64) error {
+	infoBuf, err := json.Marshal(s.getProxyTopologyInfo(startTS))
+	if err != nil {
+		return err
+	}
+	// Note: no lease is required here, the same as tidb.
+	if err = util.PutKVToEtcd(ctx, etcdCli, proxyTopologyRetryCnt, key+"/info", string(infoBuf)); err != nil {
+		return err
+	}
+	return util.PutKVToEtcd(ctx, etcdCli, proxyTopologyRetryCnt, key+"/ttl",
+		fmt.Sprintf("%v", time.Now().UnixNano()), clientv3.WithLease(session.Lease()))
+}
+
+// proxyTopologyKeeper registers the proxy and its backend pools into PD's etcd so that
+// TiDB Dashboard and diagnostic tools can find the proxy instances.
+func (s *Server) proxyTopologyKeeper() {
+	if s.dom == nil || s.dom.GetEtcdClient() == nil {
+		return
+	}
+	etcdCli := s.dom.GetEtcdClient()
+	startTS := time.Now().Unix()
+	key := s.proxyTopologyKey()
+	logPrefix := fmt.Sprintf("[proxy-topology-syncer] %s", key)
+	ctx := context.Background()
+
+	session, err := owner.NewSession(ctx, logPrefix, etcdCli, owner.NewSessionDefaultRetryCnt, infosync.TopologySessionTTL)
+	if err != nil {
+		logutil.BgLogger().Error("create proxy topology session failed", zap.Error(err))
+		return
+	}
+	if err = s.storeProxyTopology(ctx, etcdCli, session, key, startTS); err != nil {
+		logutil.BgLogger().Error("store proxy topology failed", zap.Error(err))
+	}
+
+	ticker := time.NewTicker(infosync.TopologyTimeToRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.loops.ctx.Done():
+			s.removeProxyTopology(etcdCli, key)
+			return
+		case <-ticker.C:
+			if s.inShutdownMode {
+				s.removeProxyTopology(etcdCli, key)
+				return
+			}
+			// a rebind moved the proxy, the info under the old port is stale
+			if moved := s.proxyTopologyKey(); moved != key {
+				s.removeProxyTopology(etcdCli, key)
+				key = moved
+			}
+			if err = s.storeProxyTopology(ctx, etcdCli, session, key, startTS); err != nil {
+				logutil.BgLogger().Error("refresh proxy topology failed", zap.Error(err))
+			}
+		case <-session.Done():
+			logutil.BgLogger().Info("proxy topology syncer need to restart")
+			session, err = owner.NewSession(ctx, logPrefix, etcdCli, owner.NewSessionDefaultRetryCnt, infosync.TopologySessionTTL)
+			if err != nil {
+				logutil.BgLogger().Error("proxy topology syncer restart failed", zap.Error(err))
+				return
+			}
+			logutil.BgLogger().Info("proxy topology syncer restarted")
+		}
+	}
+}
+
+// removeProxyTopology deletes the info and the ttl key stored under key.
+func (s *Server) removeProxyTopology(etcdCli *clientv3.Client, key string) {
+	for _, k := range []string{key + "/info", key + "/ttl"} {
+		if err := util.DeleteKeyFromEtcd(k, etcdCli, proxyTopologyRetryCnt, time.Second); err != nil {
+			logutil.BgLogger().Warn("remove proxy topology failed", zap.String("key", k), zap.Error(err))
+		}
+	}
+}
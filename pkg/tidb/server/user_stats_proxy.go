@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/proxy/config"
+	"github.com/pingcap/tidb/proxy/core/golog"
+	"github.com/pingcap/tidb/proxy/stats"
+)
+
+// openUserStats loads the user usage saved by the last run, a broken file is logged and
+// the usage starts over.
+func (s *Server) openUserStats() {
+	fileName := s.cfg.Proxycfg.UserStatsFile
+	if fileName == "" {
+		return
+	}
+	if err := stats.DefaultUsers().Open(fileName); err != nil {
+		golog.Warn("Server", "openUserStats", "load user stats file failed", 0,
+			"file", fileName, "error", err)
+	}
+}
+
+// runUserStatsFlusher flushes the user usage periodically, Close flushes it once more
+// after the loops stop.
+func (s *Server) runUserStatsFlusher() {
+	if s.cfg.Proxycfg.UserStatsFile == "" {
+		return
+	}
+	interval := time.Duration(s.cfg.Proxycfg.UserStatsFlushInterval) * time.Second
+	if interval <= 0 {
+		interval = config.DefaultUserStatsFlushInterval * time.Second
+	}
+	for s.pause(interval) {
+		s.flushUserStats()
+	}
+}
+
+func (s *Server) flushUserStats() {
+	if err := stats.DefaultUsers().Flush(); err != nil {
+		golog.Warn("Server", "flushUserStats", "save user stats failed", 0,
+			"file", s.cfg.Proxycfg.UserStatsFile, "error", err)
+	}
+}
+
+// addUserBytes records the bytes the client read and wrote since the last command.
+func (cc *clientConn) addUserBytes() {
+	in, out := atomic.LoadInt64(&cc.pkt.bytesIn), atomic.LoadInt64(&cc.pkt.bytesOut)
+	stats.DefaultUsers().AddBytes(cc.user, in-cc.userBytesIn, out-cc.userBytesOut)
+	cc.userBytesIn, cc.userBytesOut = in, out
+}
+
+// handleUserStats lists the usage of every user since the stats file was created.
+func (s *Server) handleUserStats(w http.ResponseWriter, req *http.Request) {
+	writeData(w, stats.DefaultUsers().List())
+}
@@ -42,10 +42,10 @@ import (
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb/config"
 	"github.com/pingcap/tidb/proxy/scalepb"
+	"github.com/pingcap/tidb/proxy/scaler"
 	"github.com/pingcap/tidb/types"
 	"github.com/pingcap/tidb/util/chunk"
 	"github.com/pingcap/tidb/util/hack"
-	"google.golang.org/grpc"
 	"io"
 	"math"
 	"net/http"
@@ -53,8 +53,6 @@ import (
 	"time"
 )
 
-const ScaleAddr = "scale-operator.sldb-admin.svc:8028"
-
 func parseNullTermString(b []byte) (str []byte, remain []byte) {
 	off := bytes.IndexByte(b, 0)
 	if off == -1 {
@@ -438,19 +436,8 @@ func ScaleSldb(ns, clus string, hashrate float32, needtimeout bool) (bool, error
 	}
 
 	fmt.Println("start--------------------------")
-	conn, err := grpc.DialContext(ctx, ScaleAddr, grpc.WithInsecure())
-	//conn, err := grpc.Dial(ScaleAddr, grpc.WithInsecure(), grpc.WithTimeout(10 * time.Second))
-	if err != nil {
-		return false, err
-	}
-	// 函数结束时关闭连接
-	defer conn.Close()
-
-	// 创建Waiter服务的客户端
-	t := scalepb.NewScaleClient(conn)
-
 	// 调用gRPC接口
-	tr, err := t.ScaleCluster(ctx, &scalepb.ScaleRequest{
+	tr, err := scaler.ScaleCluster(ctx, scaleBySldb, &scalepb.ScaleRequest{
 		Clustername: clus,
 		Namespace:   ns,
 		Hashrate:    hashrate,
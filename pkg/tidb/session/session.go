@@ -1679,30 +1679,13 @@ func ExecuteStmtForProxy(ctx context.Context,sess Session, stmtNode ast.StmtNode
 		ctx = opentracing.ContextWithSpan(ctx, span1)
 	}
     s,_:=sess.(*session)
-	s.PrepareTxnCtx(ctx)
-	err := s.loadCommonGlobalVariablesIfNeeded()
-	if err != nil {
-		return nil, err
-	}
-
-	s.sessionVars.StartTime = time.Now()
-
-	// Some executions are done in compile stage, so we reset them before compile.
-	if err := executor.ResetContextOfStmt(s, stmtNode); err != nil {
+	if err := ResetStmtForProxy(ctx, s, stmtNode); err != nil {
 		return nil, err
 	}
 	normalizedSQL, digest := s.sessionVars.StmtCtx.SQLDigest()
 	if variable.TopSQLEnabled() {
 		ctx = topsql.AttachSQLInfo(ctx, normalizedSQL, digest, "", nil, s.sessionVars.InRestrictedSQL)
 	}
-
-	if err := s.validateStatementReadOnlyInStaleness(stmtNode); err != nil {
-		return nil, err
-	}
-
-	// Uncorrelated subqueries will execute once when building plan, so we reset process info before building plan.
-	cmd32 := atomic.LoadUint32(&s.GetSessionVars().CommandValue)
-	s.SetProcessInfo(stmtNode.Text(), time.Now(), byte(cmd32), 0)
 	s.txn.onStmtStart(digest.String())
 	defer s.txn.onStmtEnd()
 
@@ -1737,6 +1720,31 @@ func ExecuteStmtForProxy(ctx context.Context,sess Session, stmtNode ast.StmtNode
 
 
 
+// ResetStmtForProxy readies the session for the statement without compiling it, the proxy
+// forwards a statement routed by a cached cost to a backend without its plan.
+func ResetStmtForProxy(ctx context.Context, sess Session, stmtNode ast.StmtNode) error {
+	s, _ := sess.(*session)
+	s.PrepareTxnCtx(ctx)
+	if err := s.loadCommonGlobalVariablesIfNeeded(); err != nil {
+		return err
+	}
+
+	s.sessionVars.StartTime = time.Now()
+
+	// Some executions are done in compile stage, so we reset them before compile.
+	if err := executor.ResetContextOfStmt(s, stmtNode); err != nil {
+		return err
+	}
+	if err := s.validateStatementReadOnlyInStaleness(stmtNode); err != nil {
+		return err
+	}
+
+	// Uncorrelated subqueries will execute once when building plan, so we reset process info before building plan.
+	cmd32 := atomic.LoadUint32(&s.GetSessionVars().CommandValue)
+	s.SetProcessInfo(stmtNode.Text(), time.Now(), byte(cmd32), 0)
+	return nil
+}
+
 //*****************
 
 func (s *session) validateStatementReadOnlyInStaleness(stmtNode ast.StmtNode) error {
@@ -880,6 +880,16 @@ type ProxyContext struct {
 	Userquery bool
 	Cost float64
 	SQLtext string
+	// RoutingFeedback attaches a note telling which backend ran the statement.
+	RoutingFeedback bool
+	// FollowerRead prefers tikv followers for reads forwarded to backends.
+	FollowerRead bool
+	// Priority is the priority level of statements forwarded to backends, auto means by the proxy config.
+	Priority string
+	// Route pins statements forwarded to backends to a pool, tp or ap, or to the backend of a pod, auto means by cost.
+	Route string
+	// FreshConn forwards DDL, ANALYZE and ADMIN statements over a fresh backend conn, on or off, auto means by the proxy config.
+	FreshConn string
 }
 
 // AllocMPPTaskID allocates task id for mpp tasks. It will reset the task id if the query's
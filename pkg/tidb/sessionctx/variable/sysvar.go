@@ -1758,6 +1758,51 @@ var defaultSysVars = []*SysVar{
 		TopSQLVariable.ReportIntervalSeconds.Store(val)
 		return nil
 	}},
+	{Scope: ScopeGlobal, Name: ServerlessTPScaleInCost, Value: strconv.Itoa(DefServerlessTPScaleInCost), Type: TypeInt, MinValue: 0, MaxValue: math.MaxInt64, GetGlobal: func(s *SessionVars) (string, error) {
+		return strconv.FormatInt(ServerlessVariable.TPScaleInCost.Load(), 10), nil
+	}, SetGlobal: func(vars *SessionVars, s string) error {
+		return setServerlessVariable(ServerlessVariable.TPScaleInCost, s)
+	}},
+	{Scope: ScopeGlobal, Name: ServerlessTPScaleInQPS, Value: strconv.Itoa(DefServerlessTPScaleInQPS), Type: TypeInt, MinValue: 0, MaxValue: math.MaxInt64, GetGlobal: func(s *SessionVars) (string, error) {
+		return strconv.FormatInt(ServerlessVariable.TPScaleInQPS.Load(), 10), nil
+	}, SetGlobal: func(vars *SessionVars, s string) error {
+		return setServerlessVariable(ServerlessVariable.TPScaleInQPS, s)
+	}},
+	{Scope: ScopeGlobal, Name: ServerlessTPScaleInSeconds, Value: strconv.Itoa(DefServerlessTPScaleInSeconds), Type: TypeInt, MinValue: 1, MaxValue: math.MaxInt32, GetGlobal: func(s *SessionVars) (string, error) {
+		return strconv.FormatInt(ServerlessVariable.TPScaleInSeconds.Load(), 10), nil
+	}, SetGlobal: func(vars *SessionVars, s string) error {
+		return setServerlessVariable(ServerlessVariable.TPScaleInSeconds, s)
+	}},
+	{Scope: ScopeGlobal, Name: ServerlessScaleInInterval, Value: strconv.Itoa(DefServerlessScaleInInterval), Type: TypeInt, MinValue: 1, MaxValue: math.MaxInt32, GetGlobal: func(s *SessionVars) (string, error) {
+		return strconv.FormatInt(ServerlessVariable.ScaleInInterval.Load(), 10), nil
+	}, SetGlobal: func(vars *SessionVars, s string) error {
+		return setServerlessVariable(ServerlessVariable.ScaleInInterval, s)
+	}},
+	{Scope: ScopeGlobal | ScopeSession, Name: ServerlessRoutingFeedback, Value: BoolToOnOff(DefServerlessRoutingFeedback), Type: TypeBool, SetSession: func(s *SessionVars, val string) error {
+		s.Proxy.RoutingFeedback = TiDBOptOn(val)
+		return nil
+	}},
+	{Scope: ScopeGlobal | ScopeSession, Name: ServerlessFollowerRead, Value: BoolToOnOff(DefServerlessFollowerRead), Type: TypeBool, SetSession: func(s *SessionVars, val string) error {
+		s.Proxy.FollowerRead = TiDBOptOn(val)
+		return nil
+	}},
+	{Scope: ScopeGlobal | ScopeSession, Name: ServerlessPriority, Value: DefServerlessPriority, Type: TypeEnum, PossibleValues: []string{ServerlessPriorityAuto, "high", "medium", "low"}, SetSession: func(s *SessionVars, val string) error {
+		s.Proxy.Priority = strings.ToLower(val)
+		return nil
+	}},
+	{Scope: ScopeSession, Name: ServerlessRoute, Value: DefServerlessRoute, Type: TypeStr, Validation: func(vars *SessionVars, normalizedValue string, originalValue string, scope ScopeFlag) (string, error) {
+		if normalizedValue = strings.TrimSpace(normalizedValue); normalizedValue == "" {
+			return "", ErrWrongValueForVar.GenWithStackByArgs(ServerlessRoute, originalValue)
+		}
+		return normalizedValue, nil
+	}, SetSession: func(s *SessionVars, val string) error {
+		s.Proxy.Route = val
+		return nil
+	}},
+	{Scope: ScopeGlobal | ScopeSession, Name: ServerlessFreshConn, Value: DefServerlessFreshConn, Type: TypeEnum, PossibleValues: []string{ServerlessFreshConnAuto, On, Off}, SetSession: func(s *SessionVars, val string) error {
+		s.Proxy.FreshConn = strings.ToLower(val)
+		return nil
+	}},
 
 	{Scope: ScopeGlobal | ScopeSession, Name: TiDBEnableGlobalTemporaryTable, Value: BoolToOnOff(DefTiDBEnableGlobalTemporaryTable), Hidden: true, Type: TypeBool, SetSession: func(s *SessionVars, val string) error {
 		s.EnableGlobalTemporaryTable = TiDBOptOn(val)
@@ -15,6 +15,7 @@ package variable
 
 import (
 	"math"
+	"strconv"
 
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb/config"
@@ -599,6 +600,41 @@ const (
 	TiDBEnableEnhancedSecurity = "tidb_enable_enhanced_security"
 )
 
+// Serverless proxy vars that have only global scope, they tune the autoscaler at runtime.
+const (
+	// ServerlessTPScaleInCost is the tp cost below which the cluster is considered idle.
+	ServerlessTPScaleInCost = "serverless_tp_scalein_cost"
+	// ServerlessTPScaleInQPS is the client qps below which the cluster is considered idle.
+	ServerlessTPScaleInQPS = "serverless_tp_scalein_qps"
+	// ServerlessTPScaleInSeconds is how long the cluster must stay idle before the tp tidbs are scaled in.
+	ServerlessTPScaleInSeconds = "serverless_tp_scalein_seconds"
+	// ServerlessScaleInInterval is the cooldown in minutes of low load before a pool is scaled in.
+	ServerlessScaleInInterval = "serverless_scalein_interval"
+)
+
+// Serverless proxy vars that have session scope.
+const (
+	// ServerlessRoutingFeedback makes the proxy attach a note to each routed statement telling which backend ran it.
+	ServerlessRoutingFeedback = "serverless_routing_feedback"
+	// ServerlessFollowerRead makes the proxy send eligible reads to tikv followers with the replica read of their routing class.
+	ServerlessFollowerRead = "serverless_follower_read"
+	// ServerlessPriority is the priority level, auto, high, medium or low, of statements the proxy forwards to backends.
+	ServerlessPriority = "serverless_priority"
+	// ServerlessRoute pins the statements of the session to a pool, tp or ap, or to the backend of a pod, auto routes by cost.
+	ServerlessRoute = "serverless_route"
+	// ServerlessFreshConn forwards DDL, ANALYZE and ADMIN statements over a fresh backend conn, on or off, auto means by the proxy config.
+	ServerlessFreshConn = "serverless_fresh_conn"
+)
+
+// ServerlessPriorityAuto takes the priority of the user or routing class in the proxy config.
+const ServerlessPriorityAuto = "auto"
+
+// ServerlessRouteAuto routes the statements by their cost.
+const ServerlessRouteAuto = "auto"
+
+// ServerlessFreshConnAuto takes the fresh conn mode of the cluster in the proxy config.
+const ServerlessFreshConnAuto = "auto"
+
 // Default TiDB system variable values.
 const (
 	DefHostname                           = "localhost"
@@ -739,6 +775,15 @@ const (
 	DefTMPTableSize                       = 16777216
 	DefTiDBEnableLocalTxn                 = false
 	DefTiDBEnableOrderedResultMode        = false
+	DefServerlessTPScaleInCost            = 10000
+	DefServerlessTPScaleInQPS             = 100
+	DefServerlessTPScaleInSeconds         = 15
+	DefServerlessScaleInInterval          = 5
+	DefServerlessRoutingFeedback          = false
+	DefServerlessFollowerRead             = false
+	DefServerlessPriority                 = ServerlessPriorityAuto
+	DefServerlessRoute                    = ServerlessRouteAuto
+	DefServerlessFreshConn                = ServerlessFreshConnAuto
 )
 
 // Process global variables.
@@ -773,8 +818,35 @@ var (
 	}
 	EnableLocalTxn     = atomic.NewBool(DefTiDBEnableLocalTxn)
 	RestrictedReadOnly = atomic.NewBool(DefTiDBRestrictedReadOnly)
+	ServerlessVariable = Serverless{
+		TPScaleInCost:    atomic.NewInt64(DefServerlessTPScaleInCost),
+		TPScaleInQPS:     atomic.NewInt64(DefServerlessTPScaleInQPS),
+		TPScaleInSeconds: atomic.NewInt64(DefServerlessTPScaleInSeconds),
+		ScaleInInterval:  atomic.NewInt64(DefServerlessScaleInInterval),
+	}
 )
 
+// Serverless is the variable for tuning the autoscaler of serverless proxy.
+type Serverless struct {
+	// TPScaleInCost is the tp cost below which the cluster is idle.
+	TPScaleInCost *atomic.Int64
+	// TPScaleInQPS is the client qps below which the cluster is idle.
+	TPScaleInQPS *atomic.Int64
+	// TPScaleInSeconds is the idle seconds before scaling in tp tidbs.
+	TPScaleInSeconds *atomic.Int64
+	// ScaleInInterval is the low load minutes before scaling in a pool.
+	ScaleInInterval *atomic.Int64
+}
+
+func setServerlessVariable(v *atomic.Int64, s string) error {
+	val, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	v.Store(val)
+	return nil
+}
+
 // TopSQL is the variable for control top sql feature.
 type TopSQL struct {
 	// Enable top-sql or not.
@@ -120,6 +120,9 @@ var (
 
 	//for proxy config
 	configForProxy  = flag.String("configproxy", "/etc/proxy.yaml", "proxy config file")
+	//replay a counter trace offline instead of serving
+	simulateAutoscale = flag.String("simulate-autoscale", "", "replay the counter trace file through the autoscale policies of the proxy config, print the scale timeline and exit")
+	simulateCorePrice = flag.Float64("simulate-core-price", 1, "price of one core hour in the cost estimated by -simulate-autoscale")
 
 	// Base
 	store            = flag.String(nmStore, "unistore", "registered store name, [tikv, mocktikv, unistore]")
@@ -191,6 +194,13 @@ func main() {
 	proxycfg.Cluster.NameSpace = namespace
 
 	config.GetGlobalConfig().Proxycfg=proxycfg
+	if *simulateAutoscale != "" {
+		if err = server.SimulateAutoscale(proxycfg, *simulateAutoscale, os.Stdout, *simulateCorePrice); err != nil {
+			fmt.Fprintln(os.Stderr, "simulate autoscale failed:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 /*	fmt.Println("***************")
 	fmt.Println(proxycfg.Cluster.Tidbs)